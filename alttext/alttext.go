@@ -0,0 +1,366 @@
+// Package alttext implements goodness md alt, which asks a vision model to
+// describe the images in posts that have no alt text and writes the
+// descriptions into the markdown once they are approved
+package alttext
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/httpclient"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/llm"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/errs"
+	"GoodnessucWorkflow/pkg/imaging"
+	"GoodnessucWorkflow/pkg/markdown"
+)
+
+// systemPrompt tells the model how to write alt text; %d is -max-length
+const systemPrompt = `You write alt text for the images in blog posts. Describe what the image shows that matters to a reader who cannot see it, in plain words and at most %d characters. Transcribe short text that is the point of the image, such as a heading or an error message. Do not start with "Image of" or "Picture of". Reply with the alt text alone, with no quotes or commentary.`
+
+// Images are scaled down to fit maxSide before they are sent, which is
+// as much as vision models look at
+const maxSide = 1568
+
+// maxImageSize bounds an image downloaded from a URL
+const maxImageSize = 20 << 20
+
+// proposal is alt text for one image
+type proposal struct {
+	img markdown.Image
+	alt string
+}
+
+// reviewer asks about each proposal and remembers answers that cover the
+// rest of the run
+type reviewer struct {
+	yes, interactive bool
+	in               *bufio.Reader
+	quit             bool
+}
+
+// Run is the md alt command
+func Run(args []string) {
+	fs := flag.NewFlagSet("alt", flag.ExitOnError)
+	logging.AddFlags(fs)
+	model := llm.AddFlags(fs)
+	instructions := fs.String("instructions", "", "more guidance for the model, such as \"write in French\"")
+	maxLength := fs.Int("max-length", 125, "longest alt text to ask for, in characters")
+	names := fs.Bool("names", true, "also replace alt text that is only the image's file name, as goodness img upload writes it")
+	root := fs.String("root", ".", "folder that image paths starting with / are relative to")
+	yes := fs.Bool("yes", false, "write every proposal without asking")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness md alt [flags] post.md|folder ...\n\nFinds the images in posts that have no alt text, sends each to a vision\nmodel for a description and writes it into the markdown. On a terminal\neach proposal is shown to accept, edit or skip; -yes writes them all.\nWithout a terminal or -yes the proposals are only printed. Local images\nare found next to the post, and images with http(s) URLs are downloaded.\nWritten alt text can be reversed with goodness undo.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	if *maxLength < 20 {
+		logging.Exitf(exitcode.Usage, "Invalid -max-length value %d; expected at least 20", *maxLength)
+	}
+	model.Vision = true
+	m, err := model.Open()
+	if err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid model options: %s", err)
+	}
+	system := fmt.Sprintf(systemPrompt, *maxLength)
+	if *instructions != "" {
+		system += "\n\nAlso: " + *instructions
+	}
+
+	var posts []string
+	for _, arg := range fs.Args() {
+		found, err := fsutil.MarkdownFiles(arg)
+		if err != nil {
+			logging.Fatalf("Failed to read %s: %s", arg, err)
+		}
+		posts = append(posts, found...)
+	}
+
+	ctx := cli.SignalContext()
+	r := &reviewer{yes: *yes, interactive: !*yes && term.IsTerminal(int(os.Stdin.Fd())), in: bufio.NewReader(os.Stdin)}
+	missing, proposed, written, failed := 0, 0, 0, 0
+	for _, path := range posts {
+		if ctx.Err() != nil || r.quit {
+			break
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			logging.Errorf("Failed to read %s: %s", path, err)
+			output.Processed(path, "failed", "read", err)
+			failed++
+			continue
+		}
+		doc := string(data)
+		title := postTitle(doc)
+		paragraphs := markdown.Prose(doc)
+
+		var accepted []proposal
+		fileFailed := false
+		for _, img := range markdown.Images(doc) {
+			if !needsAlt(img, *names) {
+				continue
+			}
+			missing++
+			if ctx.Err() != nil || r.quit {
+				break
+			}
+			picture, err := load(ctx, img.Target, filepath.Dir(path), *root)
+			if err == nil {
+				var answer string
+				prompt := describePrompt(title, surrounding(paragraphs, img.AltStart))
+				answer, err = m.Complete(ctx, llm.Request{System: system, Prompt: prompt, Images: []llm.Image{picture}})
+				if err == nil {
+					p := proposal{img, clean(answer)}
+					proposed++
+					if p.alt = r.review(path, p); p.alt != "" {
+						accepted = append(accepted, p)
+					}
+					continue
+				}
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			logging.Errorf("Failed to describe %s in %s:%d: %s", img.Target, path, img.Line, err)
+			fileFailed = true
+		}
+
+		if len(accepted) > 0 {
+			for i := len(accepted) - 1; i >= 0; i-- {
+				p := accepted[i]
+				doc = doc[:p.img.AltStart] + p.img.SetAlt(p.alt) + doc[p.img.AltEnd:]
+			}
+			if err := journal.WriteFile(path, []byte(doc), 0644); err != nil {
+				logging.Errorf("Failed to write %s: %s", path, err)
+				output.Processed(path, "failed", "write", err)
+				failed++
+				continue
+			}
+			output.Wrote(path)
+			written += len(accepted)
+			logging.Infof("Wrote alt text for %d images to %s", len(accepted), path)
+		}
+		if fileFailed {
+			output.Processed(path, "failed", "describe", nil)
+			failed++
+		} else {
+			output.Processed(path, "ok", "", nil)
+		}
+	}
+	output.Set("missing", missing)
+	output.Set("proposed", proposed)
+	output.Set("written", written)
+	if proposed > written && !*yes && !r.interactive {
+		fmt.Println()
+		logging.Infof("Left the posts unchanged; pass -yes to write the %d proposals without asking", proposed)
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		output.Exit(exitcode.Interrupted)
+	case failed > 0:
+		output.Exit(exitcode.Failures)
+	case missing == 0:
+		logging.Infof("Every image has alt text")
+		output.Exit(exitcode.NoMatch)
+	}
+}
+
+// review shows a proposal and returns the alt text to write: the proposal,
+// the user's own, or "" to leave the image as it is
+func (r *reviewer) review(path string, p proposal) string {
+	fmt.Printf("\n%s:%d %s\n  %s\n", path, p.img.Line, p.img.Target, p.alt)
+	if r.yes {
+		return p.alt
+	}
+	if !r.interactive {
+		return ""
+	}
+	for {
+		fmt.Print(i18n.T("Write this alt text? [y/N/e/a/q] "))
+		answer, _ := r.in.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y":
+			return p.alt
+		case "a":
+			r.yes = true
+			return p.alt
+		case "e":
+			fmt.Print(i18n.T("Alt text: "))
+			own, _ := r.in.ReadString('\n')
+			if own = strings.TrimSpace(own); own != "" {
+				return own
+			}
+		case "q":
+			r.quit = true
+			return ""
+		default:
+			return ""
+		}
+	}
+}
+
+// needsAlt reports whether img has no alt text, or with names only its
+// file name
+func needsAlt(img markdown.Image, names bool) bool {
+	alt := strings.TrimSpace(img.Alt)
+	if alt == "" {
+		return true
+	}
+	if !names {
+		return false
+	}
+	base := filepath.Base(strings.SplitN(img.Target, "?", 2)[0])
+	if unescaped, err := url.PathUnescape(base); err == nil {
+		base = unescaped
+	}
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+	words := strings.Join(strings.FieldsFunc(stem, func(r rune) bool { return r == '-' || r == '_' }), " ")
+	return strings.EqualFold(alt, base) || strings.EqualFold(alt, stem) || strings.EqualFold(alt, words)
+}
+
+// postTitle returns the title in a post's front matter, if any
+func postTitle(doc string) string {
+	front, _, _ := markdown.SplitFrontMatter(doc)
+	var meta struct {
+		Title string `yaml:"title"`
+	}
+	if yaml.Unmarshal([]byte(front), &meta) != nil {
+		return ""
+	}
+	return meta.Title
+}
+
+// surrounding returns the text of the last paragraph before offset, which
+// usually introduces the image, and of the paragraph around it, if any
+func surrounding(paragraphs []markdown.Paragraph, offset int) string {
+	var before, around string
+	for _, p := range paragraphs {
+		if p.Start > offset {
+			break
+		}
+		plain := markdown.InlineText(p.Text)
+		switch {
+		case plain == "":
+		case p.End < offset:
+			before = plain
+		default:
+			around = plain
+		}
+	}
+	return strings.TrimSpace(before + " " + around)
+}
+
+// describePrompt asks for alt text, giving what the post says around the
+// image
+func describePrompt(title, around string) string {
+	var b strings.Builder
+	b.WriteString("Write alt text for this image.")
+	if title != "" {
+		fmt.Fprintf(&b, "\n\nIt is in a post titled %q.", title)
+	}
+	if around != "" {
+		fmt.Fprintf(&b, "\n\nThe text around it reads:\n%s", around)
+	}
+	return b.String()
+}
+
+// load reads the image at target, relative to dir or, starting with /, to
+// root, or downloads it, and prepares it for the model as a JPEG that fits
+// in maxSide
+func load(ctx context.Context, target, dir, root string) (llm.Image, error) {
+	var data []byte
+	var err error
+	u, parseErr := url.Parse(target)
+	switch {
+	case parseErr == nil && (u.Scheme == "http" || u.Scheme == "https"):
+		data, err = download(ctx, target)
+	case parseErr == nil && u.Scheme == "" && u.Host == "":
+		name := u.Path
+		if strings.HasPrefix(name, "/") {
+			name = filepath.Join(root, filepath.FromSlash(name))
+		} else {
+			name = filepath.Join(dir, filepath.FromSlash(name))
+		}
+		data, err = os.ReadFile(name)
+	default:
+		err = fmt.Errorf("%w: not a file or http(s) URL", errs.ErrUnsupportedFormat)
+	}
+	if err != nil {
+		return llm.Image{}, err
+	}
+
+	flatten := func(img image.Image) image.Image { return imaging.FlattenWhite(img) }
+	result, err := imaging.Convert(ctx, bytes.NewReader(data), imaging.Options{
+		Format:     "jpeg",
+		Quality:    85,
+		Transforms: []imaging.Transform{imaging.Fit(maxSide, maxSide), flatten},
+		SVGWidth:   maxSide,
+	})
+	if err != nil {
+		return llm.Image{}, err
+	}
+	return llm.Image{MediaType: "image/jpeg", Data: result.Data}, nil
+}
+
+// download fetches an image from a URL
+func download(ctx context.Context, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpclient.New(time.Minute).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, &errs.RemoteError{Service: resp.Request.URL.Host, Status: resp.Status, StatusCode: resp.StatusCode}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImageSize {
+		return nil, fmt.Errorf("larger than %d bytes", maxImageSize)
+	}
+	return data, nil
+}
+
+// clean takes the alt text out of an answer in quotes or with a label
+func clean(answer string) string {
+	s := strings.Join(strings.Fields(answer), " ")
+	s = strings.TrimPrefix(s, "Alt text: ")
+	for opening, closing := range map[string]string{`"`: `"`, "“": "”", "'": "'"} {
+		if len(s) > len(opening)+len(closing) && strings.HasPrefix(s, opening) && strings.HasSuffix(s, closing) {
+			s = strings.TrimSpace(s[len(opening) : len(s)-len(closing)])
+		}
+	}
+	return s
+}
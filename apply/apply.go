@@ -0,0 +1,71 @@
+// Package apply implements goodness apply, which carries out a plan saved by
+// the -plan flag of a batch command, undoing what it did if a step fails
+package apply
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/internal/plan"
+)
+
+// Run is the apply command
+func Run(args []string) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	logging.AddFlags(fs)
+	show := fs.Bool("show", false, "print the plan and exit without changing anything")
+	force := fs.Bool("force", false, "apply even if files changed since the plan was made")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness apply [flags] PLAN\n\nCarries out a plan written by the -plan flag of img rename, organize, merge\nor dedupe. The plan is refused if any file it moves, copies or deletes\nchanged since it was made. If a step fails, or the run is interrupted, the\nsteps already done are undone in reverse, and deleted files are only\nremoved once every step has succeeded.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	p, err := plan.Load(fs.Arg(0))
+	if err != nil {
+		logging.Fatalf("Failed to read plan: %s", err)
+	}
+
+	fmt.Printf("Plan made by goodness %s at %s:\n\n", p.Command, p.Created.Local().Format(time.DateTime))
+	p.Print(os.Stdout)
+	output.Set("steps", p.Steps)
+	if *show {
+		return
+	}
+
+	if errs := p.Check(); len(errs) > 0 {
+		for _, err := range errs {
+			logging.Warnf("Stale plan: %s", err)
+		}
+		if !*force {
+			logging.Fatalf("Failed to apply plan: %d files changed since it was made; make a new plan or use -force", len(errs))
+		}
+	}
+	// A signal stops the plan between steps and undoes the steps done
+	ctx := cli.SignalContext()
+	if err := p.Apply(ctx); err != nil {
+		if errors.Is(err, context.Canceled) {
+			logging.Errorf("Interrupted, %s", err)
+			output.Exit(exitcode.Interrupted)
+		}
+		logging.Fatalf("Failed to apply plan: %s", err)
+	}
+	for _, path := range p.Targets() {
+		output.Wrote(path)
+	}
+	fmt.Printf("\nApplied %d steps\n", len(p.Steps))
+}
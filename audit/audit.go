@@ -0,0 +1,183 @@
+// Package audit implements goodness audit, which queries the audit log of
+// file changes kept by internal/journal and checks its hash chain, to find
+// out what happened to a file long after the run that changed it
+package audit
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/internal/secrets"
+)
+
+// Run is the audit command
+func Run(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	logging.AddFlags(fs)
+	run := fs.String("run", "", "only changes of this run, as listed in the RUN column")
+	since := fs.String("since", "", "only changes since a date (2006-01-02) or for a duration back, such as 72h or 30d")
+	limit := fs.Int("limit", 50, "show at most this many of the newest matching changes; 0 shows all")
+	verify := fs.Bool("verify", false, "only check the hash chain and its head record and print the last hash")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness audit [flags] [file|directory ...]\n\nLists the file changes recorded in the audit log, oldest first, with the\nrun and command that made them: every file any command created, changed,\nmoved or deleted, and what undo put back. Paths limit it to changes to\nthose files or inside those directories, including moves from them.\n\nEach entry carries the hash of the one before it, and audit.head beside\nthe log records the last, so lines edited, removed, reordered or cut off\nthe end are reported with exit status 4. Anyone who can write the state\nfolder can still rewrite both files whole unless GOODNESS_AUDIT_KEY is\nset, in the environment or with goodness auth login audit, to sign the\nhead record; it is signed from the next change on. Set GOODNESS_AUDIT=off\nto stop recording.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	var after time.Time
+	if *since != "" {
+		var err error
+		if after, err = parseSince(*since); err != nil {
+			logging.Exitf(exitcode.Usage, "Invalid -since value %q", *since)
+		}
+	}
+	if *limit < 0 {
+		logging.Exitf(exitcode.Usage, "Invalid -limit value %d", *limit)
+	}
+	paths := make([]string, fs.NArg())
+	for i, arg := range fs.Args() {
+		abs, err := filepath.Abs(arg)
+		if err != nil {
+			logging.Fatalf("Failed to resolve %s: %s", arg, err)
+		}
+		paths[i] = abs
+	}
+
+	// Unlike the commands that write the log, -verify may ask for the
+	// passphrase of the secrets file holding the key
+	if *verify {
+		if _, _, err := secrets.Lookup(journal.EnvAuditKey); err != nil {
+			logging.Fatalf("Failed to read the audit key: %s", err)
+		}
+	}
+	entries, err := journal.ReadAudit()
+	var broken *journal.ChainError
+	if err != nil && !errors.As(err, &broken) {
+		logging.Fatalf("Failed to read the audit log: %s", err)
+	}
+	if *verify {
+		printVerify(entries, broken)
+	}
+
+	commands := make(map[string][]string)
+	var matched []journal.AuditEntry
+	for _, e := range entries {
+		if e.Op == "start" {
+			if _, ok := commands[e.Run]; !ok {
+				commands[e.Run] = e.Command
+			}
+			continue
+		}
+		if *run != "" && e.Run != *run || e.Time.Before(after) {
+			continue
+		}
+		if len(paths) > 0 && !slices.ContainsFunc(paths, func(p string) bool { return fsutil.Within(e.Path, p) || fsutil.Within(e.From, p) }) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	if *limit > 0 && len(matched) > *limit {
+		logging.Infof("Showing the newest %d of %d changes; -limit 0 shows all", *limit, len(matched))
+		matched = matched[len(matched)-*limit:]
+	}
+
+	if len(matched) == 0 {
+		logging.Infof("No changes recorded")
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, i18n.T("TIME\tRUN\tCHANGE\tPATH\tCOMMAND"))
+		for _, e := range matched {
+			path := e.Path
+			if e.Op == "move" {
+				path = e.From + " -> " + e.Path
+			}
+			change := e.Op
+			if e.Undoes != "" {
+				change = i18n.Sprintf("%s (undo of %s)", e.Op, e.Undoes)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\tgoodness %s\n", e.Time.Local().Format(time.DateTime), e.Run, change, path, strings.Join(commands[e.Run], " "))
+		}
+		tw.Flush()
+	}
+	output.Set("changes", matched)
+
+	if broken != nil {
+		logging.Errorf("The audit log was tampered with or damaged: %s", broken)
+		output.Exit(exitcode.Error)
+	}
+	if len(matched) == 0 {
+		output.Exit(exitcode.NoMatch)
+	}
+	output.Exit(exitcode.OK)
+}
+
+// printVerify reports on the chain and its head record for -verify and
+// exits
+func printVerify(entries []journal.AuditEntry, broken *journal.ChainError) {
+	output.Set("entries", len(entries))
+	if broken != nil {
+		logging.Errorf("The audit log was tampered with or damaged: %s", broken)
+		output.Exit(exitcode.Error)
+	}
+	anchor, err := journal.ReadAuditAnchor()
+	if err != nil {
+		logging.Fatalf("Failed to read the audit log: %s", err)
+	}
+	output.Set("head", anchor.Head)
+	output.Set("signed", anchor.Signed && anchor.Keyed)
+	if len(entries) == 0 {
+		logging.Infof("The audit log is empty")
+		output.Exit(exitcode.OK)
+	}
+	last := entries[len(entries)-1]
+	fmt.Println(i18n.Sprintf("The audit log is intact: %d entries, the last at %s", len(entries), last.Time.Local().Format(time.DateTime)))
+	fmt.Println(i18n.Sprintf("Last hash: %s", last.Hash))
+	output.Set("last_hash", last.Hash)
+	switch {
+	case !anchor.Head:
+		logging.Warnf("The audit log has no head record yet, so entries cut off the end would not show; the next change writes one")
+	case anchor.Signed && !anchor.Keyed:
+		logging.Warnf("The head record is signed but %s is not set to check it", journal.EnvAuditKey)
+	case anchor.Signed:
+		fmt.Println(i18n.Sprintf("The head record is signed with %s", journal.EnvAuditKey))
+	default:
+		logging.Infof("The head record is not signed, so whoever can write the log can also rewrite it whole; set %s to sign it", journal.EnvAuditKey)
+	}
+	output.Exit(exitcode.OK)
+}
+
+// parseSince reads a date, or a duration back from now in Go's syntax or
+// as whole days such as 30d
+func parseSince(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, errors.New("invalid number of days")
+		}
+		return time.Now().AddDate(0, 0, -n), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, errors.New("invalid duration")
+	}
+	return time.Now().Add(-d), nil
+}
@@ -0,0 +1,194 @@
+// Package auth implements goodness auth, which saves the tokens of the
+// publishing and upload integrations to the system keychain, or to an
+// encrypted file without one, so they need not sit in plaintext
+// configuration or shell profiles
+package auth
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/term"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/internal/secrets"
+)
+
+// Commands are the auth subcommands
+func Commands() []cli.Command {
+	return []cli.Command{
+		{Name: "login", Summary: "save the tokens of a service to the keychain", Run: runLogin, Complete: serviceNames},
+		{Name: "logout", Summary: "remove the saved tokens of a service", Run: runLogout, Complete: serviceNames},
+		{Name: "status", Summary: "show where the token of each service comes from", Run: runStatus},
+	}
+}
+
+// Run is the auth command
+func Run(args []string) {
+	cli.Dispatch("goodness auth", Commands(), args)
+}
+
+func serviceNames() []string {
+	names := make([]string, len(secrets.Services))
+	for i, s := range secrets.Services {
+		names[i] = s.Name
+	}
+	return names
+}
+
+// servicesHelp lists the services and their secrets for usage messages
+func servicesHelp() string {
+	var b strings.Builder
+	for _, s := range secrets.Services {
+		fmt.Fprintf(&b, "  %-12s%s\n", s.Name, strings.Join(s.Keys, ", "))
+	}
+	return b.String()
+}
+
+// keysOf returns the secrets of the service called name, or name itself
+// when it is a variable name such as SLACK_WEBHOOK for a workflow
+func keysOf(name string) []string {
+	if s, ok := secrets.FindService(name); ok {
+		return s.Keys
+	}
+	if secrets.ValidKey(name) {
+		return []string{name}
+	}
+	logging.Exitf(exitcode.Usage, "Unknown service %q; expected %s or a variable name such as SLACK_WEBHOOK", name, strings.Join(serviceNames(), ", "))
+	return nil
+}
+
+func runLogin(args []string) {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	logging.AddFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.Sprintf("Usage: goodness auth login [flags] SERVICE|VARIABLE\n\nAsks for the tokens of a service and saves them to the system keychain, or\nto an encrypted file when there is none. Commands use them when the\nenvironment does not set the variable. Without a terminal the tokens are\nread from standard input, one per line. Services and their tokens:\n\n%s\nAny other VARIABLE, such as a webhook used as ${SLACK_WEBHOOK} in a\nworkflow, is saved on its own.\n\n", servicesHelp()))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	keys := keysOf(fs.Arg(0))
+
+	interactive := term.IsTerminal(int(os.Stdin.Fd()))
+	lines := bufio.NewScanner(os.Stdin)
+	for _, key := range keys {
+		var value string
+		if interactive {
+			fmt.Fprintf(os.Stderr, "%s: ", key)
+			data, err := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				logging.Fatalf("Failed to read %s: %s", key, err)
+			}
+			value = string(data)
+		} else if lines.Scan() {
+			value = lines.Text()
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			logging.Exitf(exitcode.Usage, "No value given for %s", key)
+		}
+		if err := secrets.Set(key, value); err != nil {
+			logging.Fatalf("Failed to save %s: %s", key, err)
+		}
+		logging.Infof("Saved %s to the %s", key, where())
+	}
+	output.Exit(exitcode.OK)
+}
+
+func runLogout(args []string) {
+	fs := flag.NewFlagSet("logout", flag.ExitOnError)
+	logging.AddFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness auth logout [flags] SERVICE|VARIABLE\n\nRemoves the saved tokens of a service, or a saved variable, from the\nkeychain and the encrypted file. Variables set in the environment are left\nalone.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	removed := 0
+	for _, key := range keysOf(fs.Arg(0)) {
+		err := secrets.Delete(key)
+		if errors.Is(err, secrets.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			logging.Fatalf("Failed to remove %s: %s", key, err)
+		}
+		logging.Infof("Removed %s", key)
+		removed++
+	}
+	if removed == 0 {
+		logging.Exitf(exitcode.NoMatch, "Nothing saved for %s", fs.Arg(0))
+	}
+	output.Exit(exitcode.OK)
+}
+
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	logging.AddFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness auth status [flags]\n\nLists the tokens of each service and whether each comes from the\nenvironment, the keychain or the encrypted file, or is missing. Values are\nnever printed.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() > 0 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+
+	fmt.Println(i18n.Sprintf("Tokens are saved to the %s", where()))
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, i18n.T("SERVICE\tVARIABLE\tSOURCE"))
+	sources := make(map[string]string)
+	failed := false
+	for _, s := range secrets.Services {
+		for _, key := range s.Keys {
+			source, err := secrets.Source(key)
+			if err != nil {
+				logging.Errorf("Failed to look up %s: %s", key, err)
+				source = "unknown"
+				failed = true
+			}
+			source = cmp.Or(source, "missing")
+			sources[key] = source
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Name, key, source)
+		}
+	}
+	tw.Flush()
+	output.Set("sources", sources)
+	if failed {
+		output.Exit(exitcode.Error)
+	}
+	output.Exit(exitcode.OK)
+}
+
+// where describes the store secrets are saved to
+func where() string {
+	if secrets.Backend() == "keychain" {
+		return i18n.T("system keychain")
+	}
+	return i18n.Sprintf("encrypted file %s", secrets.FilePath())
+}
@@ -0,0 +1,261 @@
+// Package batch implements goodness batch, which runs one converter
+// headless, as a container or a Kubernetes job: sources are read from an
+// input volume, results and all state are written to an output volume,
+// settings come from GOODNESS_* variables, logs are JSON lines on stdout,
+// and SIGTERM checkpoints the run so the job's next attempt resumes it
+package batch
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+)
+
+// workDir holds the job's checkpoint, cache, state and temporary files in
+// the output folder
+const workDir = ".goodness"
+
+// convertState is the state file of goodness img convert, which -resume
+// continues from
+const convertState = ".jpgr-state.json"
+
+// checkpoint is how far a job got, kept in the output folder so a job
+// that is stopped and started again carries on
+type checkpoint struct {
+	Command []string  `json:"command"`
+	Started time.Time `json:"started"`
+	// Staged is set once every source is copied to the output folder
+	Staged bool `json:"staged"`
+	// Finished is set once the command ran to the end, with ExitCode
+	Finished bool `json:"finished"`
+	ExitCode int  `json:"exit_code"`
+
+	path string
+}
+
+// Run is the batch command
+func Run(args []string) {
+	// Logs are for the container's log collector from the start
+	logging.SetFormat("json")
+	logging.SetOutput(os.Stdout)
+
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	logging.AddFlags(fs)
+	in := fs.String("in", "/input", "folder of sources, which is only read")
+	out := fs.String("out", "/output", "folder the results are written to, with the job's state, cache and temporary files")
+	command := fs.String("command", "img convert", "command to run with its flags, when none follows the batch flags")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness batch [flags] [img|md COMMAND [flags]]\n\nRuns one converter headless, for containers and Kubernetes jobs. The\nsources in -in are copied to -out, where the command runs on them, so the\ninput volume can be mounted read only. Set flags as GOODNESS_BATCH_IN,\nGOODNESS_BATCH_COMMAND and, for the command, GOODNESS_IMG_CONVERT_WORKERS\nand so on. Logs are JSON lines on stdout.\n\nThe cache, state and temporary files are kept in .goodness under -out, with\na checkpoint of the job. SIGTERM lets the files in progress finish and exits\nwith status 6; running the job again resumes it, and once it has finished\nit does nothing.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	argv := fs.Args()
+	if len(argv) == 0 {
+		argv = strings.Fields(*command)
+	}
+	if len(argv) < 2 || argv[0] != "img" && argv[0] != "md" {
+		logging.Exitf(exitcode.Usage, "Invalid command %q; expected an img or md command such as img convert", strings.Join(argv, " "))
+	}
+	if info, err := os.Stat(*in); err != nil || !info.IsDir() {
+		logging.Exitf(exitcode.Usage, "Invalid -in value %q: not a folder", *in)
+	}
+	inDir, err1 := filepath.Abs(*in)
+	outDir, err2 := filepath.Abs(*out)
+	if err := errors.Join(err1, err2); err != nil {
+		logging.Fatalf("Failed to resolve the folders: %s", err)
+	}
+	if fsutil.Within(outDir, inDir) || fsutil.Within(inDir, outDir) {
+		logging.Exitf(exitcode.Usage, "-in and -out must not contain each other")
+	}
+	work := filepath.Join(outDir, workDir)
+	for _, dir := range []string{"cache", "state", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(work, dir), 0755); err != nil {
+			logging.Fatalf("Failed to create %s: %s", work, err)
+		}
+	}
+	output.Set("in", inDir)
+	output.Set("out", outDir)
+	output.Set("command", argv)
+
+	cp, err := loadCheckpoint(filepath.Join(work, "checkpoint.json"))
+	if err != nil {
+		logging.Fatalf("Failed to read the checkpoint: %s", err)
+	}
+	switch {
+	case cp.Command == nil:
+		cp.Command, cp.Started = argv, time.Now().UTC()
+	case !slices.Equal(cp.Command, argv):
+		logging.Exitf(exitcode.Usage, "%s holds a job of goodness %s; empty it to run another command", outDir, strings.Join(cp.Command, " "))
+	case cp.Finished:
+		logging.Infof("The job finished at an earlier run with status %d; empty %s to run it again", cp.ExitCode, outDir)
+		output.Exit(cp.ExitCode)
+	default:
+		logging.Infof("Resuming the job started %s", cp.Started.Format(time.RFC3339))
+	}
+	if !cp.Staged {
+		n, err := stage(inDir, outDir)
+		if err != nil {
+			logging.Fatalf("Failed to copy the sources to %s: %s", outDir, err)
+		}
+		logging.Infof("Copied %d sources from %s to %s", n, inDir, outDir)
+		cp.Staged = true
+		if err := cp.save(); err != nil {
+			logging.Fatalf("Failed to write the checkpoint: %s", err)
+		}
+	}
+
+	code := runCommand(argv, outDir, work)
+	if code == exitcode.Interrupted {
+		logging.Warnf("Stopped; the job resumes when it runs again")
+		output.Exit(code)
+	}
+	cp.Finished, cp.ExitCode = true, code
+	if err := cp.save(); err != nil {
+		logging.Errorf("Failed to write the checkpoint: %s", err)
+	}
+	output.Set("exit_code", code)
+	output.Exit(code)
+}
+
+// runCommand runs goodness argv on dir with the job's state kept in work
+// and returns its exit status
+func runCommand(argv []string, dir, work string) int {
+	self, err := os.Executable()
+	if err != nil {
+		logging.Fatalf("Failed to find the goodness executable: %s", err)
+	}
+	argv = slices.Clone(argv)
+	if argv[0] == "img" && argv[1] == "convert" && !slices.Contains(argv, "-resume") {
+		if _, err := os.Stat(filepath.Join(dir, convertState)); err == nil {
+			argv = append(argv, "-resume")
+		}
+	}
+	argv = append(argv, ".")
+
+	cmd := exec.Command(self, argv...)
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stdout
+	// Nothing is written outside the output folder, for containers with a
+	// read-only root filesystem, and there is nothing to undo or report
+	cmd.Env = append(os.Environ(),
+		"GOODNESS_LOG_FORMAT=json",
+		"GOODNESS_JOURNAL=off",
+		"GOODNESS_TELEMETRY=off",
+		"XDG_CACHE_HOME="+filepath.Join(work, "cache"),
+		"XDG_STATE_HOME="+filepath.Join(work, "state"),
+		"TMPDIR="+filepath.Join(work, "tmp"),
+	)
+	logging.Infof("Running goodness %s in %s", strings.Join(argv, " "), dir)
+	if err := cmd.Start(); err != nil {
+		logging.Fatalf("Failed to run goodness %s: %s", strings.Join(argv, " "), err)
+	}
+
+	// The container runtime signals only the first process. SIGTERM is
+	// passed on for the command to checkpoint; SIGINT from a terminal
+	// reaches the command directly
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGTERM {
+				logging.Infof("Received SIGTERM; finishing the files in progress")
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return exitcode.OK
+	case errors.As(err, &exitErr) && exitErr.ExitCode() > 0:
+		return exitErr.ExitCode()
+	}
+	logging.Errorf("goodness %s failed: %s", strings.Join(argv, " "), err)
+	return exitcode.Error
+}
+
+// stage copies the regular files under in to the same paths under out and
+// returns how many it copied. Files already there with the same size, from
+// an attempt that was stopped, are kept
+func stage(in, out string) (int, error) {
+	n := 0
+	err := filepath.WalkDir(in, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(in, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(out, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if existing, err := os.Stat(target); err == nil && existing.Size() == info.Size() {
+			return nil
+		}
+		// Copied aside and renamed, so a copy cut short is never taken for
+		// a whole one
+		tmp := filepath.Join(filepath.Dir(target), ".goodness-stage-"+d.Name())
+		os.Remove(tmp)
+		if err := fsutil.CopyFile(path, tmp); err != nil {
+			return err
+		}
+		if err := fsutil.Rename(tmp, target); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+func loadCheckpoint(path string) (*checkpoint, error) {
+	cp := &checkpoint{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cp, json.Unmarshal(data, cp)
+}
+
+func (cp *checkpoint) save() error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(cp.path, data, 0644)
+}
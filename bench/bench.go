@@ -0,0 +1,310 @@
+// Package bench implements goodness bench, which times the markdown
+// transforms and the image pipeline over a corpus of files and can write
+// pprof profiles of the run, so changes to the parsers and to concurrency
+// can be measured rather than guessed at
+package bench
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/imaging"
+	"GoodnessucWorkflow/pkg/markdown"
+)
+
+// benchmark is one stage run over one fixture
+type benchmark struct {
+	stage   string
+	fixture string
+	// size is the input the stage reads each time, for throughput
+	size int64
+	op   func() error
+}
+
+func (b benchmark) name() string {
+	return b.stage + "/" + b.fixture
+}
+
+// result is how one benchmark did
+type result struct {
+	ops         int64
+	perOp       time.Duration
+	mbPerSec    float64
+	allocsPerOp uint64
+	bytesPerOp  uint64
+}
+
+// Run is the bench command
+func Run(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	logging.AddFlags(fs)
+	benchtime := fs.Duration("time", time.Second, "how long to run each benchmark")
+	parallel := fs.Int("parallel", 1, "goroutines running each benchmark at once, to measure throughput under concurrency")
+	match := fs.String("match", "", "only run benchmarks whose stage/file name matches this regular expression")
+	formats := fs.String("formats", "jpeg,png,gif", "comma separated formats to time encoding to: jpeg, png, gif or webp (needs cwebp)")
+	cpuProfile := fs.String("cpuprofile", "", "write a pprof CPU profile of the benchmarks to this file")
+	memProfile := fs.String("memprofile", "", "write a pprof heap profile, with allocations, to this file after the benchmarks")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness bench [flags] [corpus]\n\nTimes each stage of the markdown transforms and the image pipeline over the\nmarkdown and image files in corpus, or over a built-in corpus of posts, a\nphoto, a screenshot and an icon. Image stages are decode, analyze (what\n-format auto looks at), resize to half size, encode-FORMAT and convert-auto,\nthe whole conversion. Each line reports operations, time and throughput per\noperation, and allocations per operation.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	if *benchtime <= 0 {
+		logging.Exitf(exitcode.Usage, "Invalid -time value %s", *benchtime)
+	}
+	if *parallel < 1 {
+		logging.Exitf(exitcode.Usage, "Invalid -parallel value %d", *parallel)
+	}
+	var pattern *regexp.Regexp
+	if *match != "" {
+		var err error
+		if pattern, err = regexp.Compile(*match); err != nil {
+			logging.Exitf(exitcode.Usage, "Invalid -match value %q: %s", *match, err)
+		}
+	}
+	encodings := strings.Split(*formats, ",")
+	for _, f := range encodings {
+		if f != "jpeg" && f != "png" && f != "gif" && f != "webp" {
+			logging.Exitf(exitcode.Usage, "Invalid -formats value %q", *formats)
+		}
+	}
+
+	var fixtures []fixture
+	var err error
+	if fs.NArg() == 1 {
+		fixtures, err = loadCorpus(fs.Arg(0))
+	} else {
+		fixtures, err = builtinCorpus()
+	}
+	if err != nil {
+		logging.Fatalf("Failed to load the corpus: %s", err)
+	}
+
+	var benchmarks []benchmark
+	for _, f := range fixtures {
+		found, err := fixtureBenchmarks(f, encodings)
+		if err != nil {
+			logging.Warnf("Skipping %s: %s", f.name, err)
+			continue
+		}
+		for _, b := range found {
+			if pattern == nil || pattern.MatchString(b.name()) {
+				benchmarks = append(benchmarks, b)
+			}
+		}
+	}
+	if len(benchmarks) == 0 {
+		logging.Exitf(exitcode.NoMatch, "No benchmarks to run")
+	}
+
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			logging.Fatalf("Failed to create the CPU profile: %s", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			logging.Fatalf("Failed to start the CPU profile: %s", err)
+		}
+		output.BeforeExit(func(code int) int {
+			pprof.StopCPUProfile()
+			if err := f.Close(); err != nil {
+				logging.Errorf("Failed to write the CPU profile: %s", err)
+				return exitcode.Error
+			}
+			output.Wrote(*cpuProfile)
+			return code
+		})
+	}
+
+	width := len("BENCHMARK")
+	for _, b := range benchmarks {
+		width = max(width, len(b.name()))
+	}
+	fmt.Printf("goos: %s  goarch: %s  cpus: %d  gomaxprocs: %d  parallel: %d\n", runtime.GOOS, runtime.GOARCH, runtime.NumCPU(), runtime.GOMAXPROCS(0), *parallel)
+	fmt.Printf("%-*s  %10s  %12s  %10s  %11s  %12s\n", width, "BENCHMARK", "OPS", "TIME/OP", "MB/S", "ALLOCS/OP", "B/OP")
+
+	failed := 0
+	report := make([]map[string]any, 0, len(benchmarks))
+	for _, b := range benchmarks {
+		r, err := measure(b.op, b.size, *parallel, *benchtime)
+		if err != nil {
+			logging.Errorf("Failed to run %s: %s", b.name(), err)
+			failed++
+			continue
+		}
+		fmt.Printf("%-*s  %10d  %12s  %10.2f  %11d  %12d\n", width, b.name(), r.ops, r.perOp, r.mbPerSec, r.allocsPerOp, r.bytesPerOp)
+		report = append(report, map[string]any{
+			"stage":         b.stage,
+			"file":          b.fixture,
+			"ops":           r.ops,
+			"ns_per_op":     r.perOp.Nanoseconds(),
+			"mb_per_sec":    r.mbPerSec,
+			"allocs_per_op": r.allocsPerOp,
+			"bytes_per_op":  r.bytesPerOp,
+		})
+	}
+	output.Set("benchmarks", report)
+
+	if *memProfile != "" {
+		if err := writeHeapProfile(*memProfile); err != nil {
+			logging.Fatalf("Failed to write the memory profile: %s", err)
+		}
+		output.Wrote(*memProfile)
+	}
+	if failed > 0 {
+		output.Exit(exitcode.Failures)
+	}
+	output.Exit(exitcode.OK)
+}
+
+// fixtureBenchmarks returns the stages that apply to f: every markdown
+// transform and the link parser for documents, the image pipeline for images
+func fixtureBenchmarks(f fixture, encodings []string) ([]benchmark, error) {
+	size := int64(len(f.data))
+	if f.markdown {
+		var benchmarks []benchmark
+		for _, name := range markdown.Names() {
+			t, _ := markdown.Named(name)
+			benchmarks = append(benchmarks, benchmark{stage: "markdown-" + name, fixture: f.name, size: size, op: func() error {
+				_, err := io.Copy(io.Discard, t(bytes.NewReader(f.data)))
+				return err
+			}})
+		}
+		benchmarks = append(benchmarks, benchmark{stage: "markdown-links", fixture: f.name, size: size, op: func() error {
+			markdown.Links(string(f.data))
+			return nil
+		}})
+		return benchmarks, nil
+	}
+
+	// The later stages start from the decoded image, so they time only
+	// themselves
+	img, _, err := imaging.Decode(f.data, imaging.Options{})
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	bounds := img.Bounds()
+	pixels := int64(bounds.Dx() * bounds.Dy() * 4)
+	benchmarks := []benchmark{
+		{stage: "decode", size: size, op: func() error {
+			_, _, err := imaging.Decode(f.data, imaging.Options{})
+			return err
+		}},
+		{stage: "analyze", size: pixels, op: func() error {
+			imaging.Analyze(img)
+			return nil
+		}},
+		{stage: "resize", size: pixels, op: func() error {
+			imaging.Resize(img, max(bounds.Dx()/2, 1), max(bounds.Dy()/2, 1))
+			return nil
+		}},
+	}
+	for _, format := range encodings {
+		src := flatten(img, format)
+		benchmarks = append(benchmarks, benchmark{stage: "encode-" + format, size: pixels, op: func() error {
+			_, err := imaging.Encode(ctx, src, imaging.Options{Format: format})
+			return err
+		}})
+	}
+	benchmarks = append(benchmarks, benchmark{stage: "convert-auto", size: size, op: func() error {
+		_, err := imaging.Convert(ctx, bytes.NewReader(f.data), imaging.Options{Format: "auto"})
+		return err
+	}})
+	for i := range benchmarks {
+		benchmarks[i].fixture = f.name
+	}
+	return benchmarks, nil
+}
+
+// flatten draws img on white for formats without transparency, as
+// goodness img convert does before encoding them
+func flatten(img image.Image, format string) image.Image {
+	if imaging.KeepsAlpha(format) || !imaging.HasTransparency(img) {
+		return img
+	}
+	return imaging.FlattenWhite(img)
+}
+
+// measure runs op on parallel goroutines, over and over for about d, after
+// one run to warm up. Time per operation is wall time divided by the
+// operations done, so more goroutines lower it as far as they scale
+func measure(op func() error, size int64, parallel int, d time.Duration) (result, error) {
+	if err := op(); err != nil {
+		return result{}, err
+	}
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	var ops atomic.Int64
+	var g errgroup.Group
+	start := time.Now()
+	for range parallel {
+		g.Go(func() error {
+			for {
+				if err := op(); err != nil {
+					return err
+				}
+				ops.Add(1)
+				if time.Since(start) >= d {
+					return nil
+				}
+			}
+		})
+	}
+	err := g.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		return result{}, err
+	}
+
+	n := ops.Load()
+	return result{
+		ops:         n,
+		perOp:       elapsed / time.Duration(n),
+		mbPerSec:    float64(size*n) / 1e6 / elapsed.Seconds(),
+		allocsPerOp: (after.Mallocs - before.Mallocs) / uint64(n),
+		bytesPerOp:  (after.TotalAlloc - before.TotalAlloc) / uint64(n),
+	}, nil
+}
+
+// writeHeapProfile writes the heap profile, which also holds every
+// allocation made so far, to path
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	err = pprof.WriteHeapProfile(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fixture is one file of the corpus
+type fixture struct {
+	name string
+	// markdown is set for markdown documents; the rest are images
+	markdown bool
+	data     []byte
+}
+
+// imageExtensions are the images a corpus folder contributes
+var imageExtensions = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true, ".svg": true}
+
+// loadCorpus reads the markdown and image files under dir
+func loadCorpus(dir string) ([]fixture, error) {
+	var fixtures []fixture
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		isMarkdown := ext == ".md" || ext == ".markdown"
+		if d.IsDir() || (!isMarkdown && !imageExtensions[ext]) {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fixtures = append(fixtures, fixture{name: filepath.ToSlash(rel), markdown: isMarkdown, data: data})
+		return nil
+	})
+	return fixtures, err
+}
+
+// builtinCorpus makes the default corpus: markdown posts of a few sizes and
+// a photo, a screenshot and a transparent icon, the kinds of image
+// goodness img sees most. It is the same on every run, so results compare
+func builtinCorpus() ([]fixture, error) {
+	fixtures := []fixture{
+		{name: "post-4KB.md", markdown: true, data: post(4 << 10)},
+		{name: "post-64KB.md", markdown: true, data: post(64 << 10)},
+		{name: "post-1MB.md", markdown: true, data: post(1 << 20)},
+	}
+	for _, img := range []struct {
+		name string
+		img  image.Image
+	}{
+		{"photo.png", photo(1600, 1067)},
+		{"screenshot.png", screenshot(1280, 800)},
+		{"icon.png", icon(256)},
+	} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img.img); err != nil {
+			return nil, err
+		}
+		fixtures = append(fixtures, fixture{name: img.name, data: buf.Bytes()})
+	}
+	return fixtures, nil
+}
+
+// section is repeated to make a post: headings, inline code, links, an
+// image, a nested list and a fenced code block
+const section = "## Section %[1]d\n\nThe `parser` walks each line once and hands inline code such as `strings.Builder` and `io.Reader` to the transforms. See [the guide](https://example.com/guide/%[1]d) and ![a chart](images/chart-%[1]d.png).  \nA second line ends with trailing spaces.   \n\n- the first item has `code`\n- the second does not\n  - a nested item with [a link](../other-%[1]d.md)\n\n```go\nfunc main() {\n\tfmt.Println(\"section %[1]d\")\n}\n```\n\n\n"
+
+// post returns a markdown document of about size bytes
+func post(size int) []byte {
+	var b bytes.Buffer
+	b.WriteString("# Benchmark post\n\n")
+	for i := 1; b.Len() < size; i++ {
+		fmt.Fprintf(&b, section, i)
+	}
+	return b.Bytes()
+}
+
+// photo draws smooth gradients with noise, which compress like a photo
+func photo(width, height int) image.Image {
+	rng := rand.New(rand.NewPCG(1, 2))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			noise := rng.IntN(24)
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(x * 200 / width),
+				G: uint8(y*180/height + noise),
+				B: uint8((x+y)*120/(width+height) + noise),
+				A: 255,
+			})
+		}
+	}
+	return img
+}
+
+// screenshot draws flat panels and rows of text-like bars in few colors
+func screenshot(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	fill := func(r image.Rectangle, c color.NRGBA) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				img.SetNRGBA(x, y, c)
+			}
+		}
+	}
+	fill(img.Bounds(), color.NRGBA{250, 250, 250, 255})
+	fill(image.Rect(0, 0, width, 48), color.NRGBA{36, 41, 47, 255})
+	fill(image.Rect(0, 48, 240, height), color.NRGBA{240, 242, 245, 255})
+	rng := rand.New(rand.NewPCG(3, 4))
+	for y := 80; y < height-24; y += 22 {
+		for x := 280; x < width-40; {
+			word := 20 + rng.IntN(60)
+			fill(image.Rect(x, y, min(x+word, width-40), y+10), color.NRGBA{60, 64, 70, 255})
+			x += word + 8
+		}
+	}
+	return img
+}
+
+// icon draws a disc with soft edges on a transparent square
+func icon(size int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	center := float64(size) / 2
+	for y := range size {
+		for x := range size {
+			dx, dy := float64(x)+0.5-center, float64(y)+0.5-center
+			d := center - 8 - math.Hypot(dx, dy)
+			alpha := min(max(d, 0), 1)
+			img.SetNRGBA(x, y, color.NRGBA{220, 80, 40, uint8(alpha * 255)})
+		}
+	}
+	return img
+}
@@ -0,0 +1,23 @@
+// goodness.js loads goodness.wasm and returns the markdown transforms as
+// plain functions that throw on errors. Load wasm_exec.js first.
+async function loadGoodness(url = "goodness.wasm") {
+  const go = new Go();
+  const { instance } = await WebAssembly.instantiateStreaming(fetch(url), go.importObject);
+  // run resolves only when the module exits, which it does not; the API is
+  // in place as soon as main blocks
+  go.run(instance);
+
+  const raw = globalThis.goodnessWasm;
+  const wrap = (fn) => (...args) => {
+    const result = fn(...args);
+    if (result instanceof Error) {
+      throw result;
+    }
+    return result;
+  };
+  const api = { transforms: Array.from(raw.transforms), transform: wrap(raw.transform) };
+  for (const name of api.transforms) {
+    api[name] = wrap(raw[name]);
+  }
+  return api;
+}
@@ -0,0 +1,81 @@
+//go:build js && wasm
+
+// Command goodness-wasm exposes the markdown transforms to JavaScript, so a
+// web page converts text exactly as goodness md does. Build it with
+//
+//	GOOS=js GOARCH=wasm go build -o cmd/goodness-wasm/goodness.wasm ./cmd/goodness-wasm
+//	cp "$(go env GOROOT)/lib/wasm/wasm_exec.js" cmd/goodness-wasm/
+//
+// (misc/wasm rather than lib/wasm before Go 1.24) and serve the folder;
+// index.html is a paste-and-convert page. goodness.js loads the module:
+//
+//	const goodness = await loadGoodness("goodness.wasm")
+//	goodness.transforms               // ["bold", "tidy"]
+//	goodness.transform(text, names)   // run the named transforms in order
+//	goodness.bold(text)
+//	goodness.tidy(text)
+//
+// The module itself sets the global goodnessWasm, whose functions return
+// an Error rather than throwing it, since a Go callback cannot throw;
+// goodness.js throws it
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"syscall/js"
+
+	"GoodnessucWorkflow/pkg/markdown"
+)
+
+func main() {
+	api := js.Global().Get("Object").New()
+	names := js.Global().Get("Array").New()
+	for _, name := range markdown.Names() {
+		names.Call("push", name)
+		t, _ := markdown.Named(name)
+		api.Set(name, js.FuncOf(func(this js.Value, args []js.Value) any {
+			if len(args) != 1 || args[0].Type() != js.TypeString {
+				return jsError(fmt.Errorf("%s expects one string", name))
+			}
+			return run(args[0].String(), t)
+		}))
+	}
+	api.Set("transforms", names)
+	api.Set("transform", js.FuncOf(transform))
+	js.Global().Set("goodnessWasm", api)
+
+	// The functions above run on calls from JavaScript until the page goes
+	select {}
+}
+
+// transform is goodnessWasm.transform(text, names)
+func transform(this js.Value, args []js.Value) any {
+	if len(args) != 2 || args[0].Type() != js.TypeString || !js.Global().Get("Array").Call("isArray", args[1]).Bool() {
+		return jsError(fmt.Errorf("transform expects a string and an array of transform names"))
+	}
+	var transforms []markdown.Transform
+	for i := 0; i < args[1].Length(); i++ {
+		name := args[1].Index(i).String()
+		t, ok := markdown.Named(name)
+		if !ok {
+			return jsError(fmt.Errorf("unknown transform %q; expected one of %s", name, strings.Join(markdown.Names(), ", ")))
+		}
+		transforms = append(transforms, t)
+	}
+	return run(args[0].String(), markdown.Chain(transforms...))
+}
+
+func run(text string, t markdown.Transform) any {
+	out, err := io.ReadAll(t(strings.NewReader(text)))
+	if err != nil {
+		return jsError(err)
+	}
+	return string(out)
+}
+
+// jsError converts err to a JavaScript Error for goodness.js to throw
+func jsError(err error) any {
+	return js.Global().Get("Error").New(err.Error())
+}
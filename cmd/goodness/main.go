@@ -0,0 +1,70 @@
+// Command goodness bundles the writing and publishing workflows: markdown
+// tools under md and image tools under img
+package main
+
+import (
+	"os"
+
+	"GoodnessucWorkflow/alttext"
+	"GoodnessucWorkflow/apply"
+	"GoodnessucWorkflow/audit"
+	"GoodnessucWorkflow/auth"
+	"GoodnessucWorkflow/batch"
+	"GoodnessucWorkflow/bench"
+	"GoodnessucWorkflow/bolder"
+	"GoodnessucWorkflow/copyedit"
+	"GoodnessucWorkflow/daemon"
+	"GoodnessucWorkflow/frontmatter"
+	"GoodnessucWorkflow/grammar"
+	"GoodnessucWorkflow/hook"
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/jpgr"
+	"GoodnessucWorkflow/plugin"
+	"GoodnessucWorkflow/serve"
+	"GoodnessucWorkflow/slides"
+	"GoodnessucWorkflow/social"
+	"GoodnessucWorkflow/telemetry"
+	"GoodnessucWorkflow/translate"
+	"GoodnessucWorkflow/ui"
+	"GoodnessucWorkflow/undo"
+	"GoodnessucWorkflow/version"
+	"GoodnessucWorkflow/workflow"
+)
+
+// mdCommands are the markdown subcommands, run as goodness md <command>
+var mdCommands = []cli.Command{
+	{Name: "alt", Summary: "describe images that have no alt text with a vision model and write the approved descriptions in", Run: alttext.Run},
+	{Name: "bold", Summary: "rewrite inline code as bold text", Run: bolder.Run},
+	{Name: "compress-post", Summary: "scale, compress and convert the images a post links to and update its links", Run: jpgr.CompressPost},
+	{Name: "edit", Summary: "ask a language model for tone and clarity edits to a post's prose and apply the ones approved", Run: copyedit.Run},
+	{Name: "frontmatter", Summary: "set, rename and delete front matter keys, add tags and rewrite dates across many posts, with a dry run", Run: frontmatter.Run},
+	{Name: "grammar", Summary: "check the grammar of posts' prose, never their code, with LanguageTool and fix the unambiguous problems", Run: grammar.Run},
+	{Name: "slides", Summary: "turn a markdown file into HTML slides, standalone or for reveal.js", Run: slides.Run},
+	{Name: "social", Summary: "draft a thread for X, a LinkedIn summary and a teaser from a post", Run: social.Run},
+	{Name: "translate", Summary: "translate the prose of posts with DeepL or Google, keeping code, links and front matter keys, into post.fr.md", Run: translate.Run},
+}
+
+func main() {
+	cli.Main("goodness", []cli.Command{
+		{Name: "md", Summary: "markdown tools", Run: func(args []string) { cli.Dispatch("goodness md", mdCommands, args) }, Commands: mdCommands},
+		{Name: "img", Summary: "image tools; flags or paths without a command convert, as jpgr did", Run: jpgr.Run, Commands: jpgr.Commands()},
+		{Name: "plugin", Summary: "list and run external transform plugins", Run: plugin.Run, Commands: plugin.Commands()},
+		{Name: "run", Summary: "run the steps of a workflow file, such as publish.yaml", Run: workflow.Run, Complete: workflow.Names},
+		{Name: "workflow", Summary: "start a workflow file from a built-in template or a shared one", Run: workflow.Command, Commands: workflow.Commands()},
+		{Name: "batch", Summary: "run a converter headless in a container, from an input volume to an output volume, resuming after SIGTERM", Run: batch.Run},
+		{Name: "daemon", Summary: "watch folders and run workflows when files change, until stopped", Run: daemon.Run},
+		{Name: "serve", Summary: "run goodness as a network service", Run: serve.Run, Commands: serve.Commands()},
+		{Name: "apply", Summary: "carry out a plan saved with -plan, undoing it if a step fails", Run: apply.Run},
+		{Name: "undo", Summary: "reverse the file changes of the last run, or of a run from undo -list", Run: undo.Run},
+		{Name: "audit", Summary: "show the hash-chained log of every file change, by file, run or date, and check it", Run: audit.Run},
+		{Name: "hook", Summary: "check staged markdown and images before a commit; -install adds the git hook", Run: hook.Run},
+		{Name: "auth", Summary: "save service tokens to the system keychain instead of plaintext configuration", Run: auth.Run, Commands: auth.Commands()},
+		{Name: "ui", Summary: "browse pending files, preview markdown changes and run workflows in the terminal", Run: ui.Run},
+		{Name: "bench", Summary: "time the markdown transforms and image pipeline over a corpus, optionally writing pprof profiles", Run: bench.Run},
+		{Name: "telemetry", Summary: "opt in to anonymous statistics of the commands and formats used, and review or send them", Run: telemetry.Run, Commands: telemetry.Commands()},
+		{Name: "version", Summary: "print the version, build details and the formats supported here", Run: version.Run},
+		{Name: "completion", Summary: "print a shell completion script: bash, zsh, fish or powershell", Run: cli.Completion("goodness"), Complete: func() []string {
+			return []string{"bash", "zsh", "fish", "powershell"}
+		}},
+	}, os.Args[1:])
+}
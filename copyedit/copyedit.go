@@ -0,0 +1,209 @@
+// Package copyedit implements goodness md edit, which asks a language model
+// for tone and clarity edits to the prose of a post and applies the ones
+// the writer approves. Code, front matter, tables and HTML are never sent
+package copyedit
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/term"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/llm"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/internal/textdiff"
+	"GoodnessucWorkflow/pkg/markdown"
+)
+
+// systemPrompt tells the model what to do with each paragraph
+const systemPrompt = `You are a copy editor. You are given one paragraph of a blog post, written in markdown. Rewrite it to read more clearly and naturally, keeping its meaning, its language and the author's voice. Do not add facts or opinions. Keep the markdown exactly: links, images, inline code in backticks and emphasis stay as they are. Reply with the paragraph alone, with no quotes, notes or commentary. When it needs no changes, reply with it unchanged.`
+
+// fixedMarkup are the parts of a paragraph an edit must keep: code spans,
+// link and image targets and bare URLs
+var fixedMarkup = regexp.MustCompile("(`+)[^`]+?(`+)|\\]\\([^)]*\\)|<[a-z]+:[^>]+>|https?://[^\\s)>]+")
+
+// edit is a suggestion for one paragraph
+type edit struct {
+	p    markdown.Paragraph
+	text string
+}
+
+// Run is the md edit command
+func Run(args []string) {
+	fs := flag.NewFlagSet("edit", flag.ExitOnError)
+	logging.AddFlags(fs)
+	model := llm.AddFlags(fs)
+	instructions := fs.String("instructions", "", "more guidance for the model, such as \"friendly, British spelling\"")
+	headings := fs.Bool("headings", false, "suggest edits to headings too")
+	minWords := fs.Int("min-words", 8, "leave paragraphs with fewer words alone")
+	yes := fs.Bool("yes", false, "apply every suggestion without asking")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness md edit [flags] post.md\n\nSends each paragraph of a post, never its code, front matter, tables or\nHTML, to a language model for tone and clarity edits. The suggestions are\nshown as diffs to apply one by one; -yes applies them all. Without a\nterminal or -yes the diffs are only printed. A suggestion that changes a\nparagraph's code, links or images is dropped. Applied edits can be\nreversed with goodness undo.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	m, err := model.Open()
+	if err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid model options: %s", err)
+	}
+
+	path := fs.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logging.Fatalf("Failed to read %s: %s", path, err)
+	}
+	doc := string(data)
+	system := systemPrompt
+	if *instructions != "" {
+		system += "\n\nAlso: " + *instructions
+	}
+
+	ctx := cli.SignalContext()
+	var edits []edit
+	asked, failed := 0, 0
+	paragraphs := markdown.Prose(doc)
+	for _, p := range paragraphs {
+		if p.Kind == markdown.KindHeading && !*headings {
+			continue
+		}
+		if p.Kind != markdown.KindHeading && len(strings.Fields(p.Text)) < *minWords {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		asked++
+		logging.Debugf("Asking about line %d", p.Line)
+		answer, err := m.Complete(ctx, llm.Request{System: system, Prompt: p.Text})
+		if err != nil {
+			if ctx.Err() == nil {
+				logging.Errorf("Failed to get a suggestion for line %d: %s", p.Line, err)
+				failed++
+			}
+			continue
+		}
+		suggestion := clean(answer, p.Text)
+		if strings.Join(strings.Fields(suggestion), " ") == strings.Join(strings.Fields(p.Text), " ") {
+			continue
+		}
+		if !slices.Equal(markup(p.Text), markup(suggestion)) {
+			logging.Warnf("Dropped the suggestion for line %d, which changed its code, links or images", p.Line)
+			continue
+		}
+		edits = append(edits, edit{p, suggestion})
+	}
+	if ctx.Err() != nil {
+		logging.Warnf("Interrupted with %d paragraphs not sent", len(paragraphs)-asked)
+	}
+	output.Set("suggestions", len(edits))
+
+	applied := review(doc, edits, *yes, path)
+	if len(applied) > 0 {
+		if err := journal.WriteFile(path, []byte(apply(doc, applied)), 0644); err != nil {
+			logging.Fatalf("Failed to write %s: %s", path, err)
+		}
+		output.Wrote(path)
+		logging.Infof("Applied %d of %d edits to %s; goodness undo reverses them", len(applied), len(edits), path)
+	}
+	output.Set("applied", len(applied))
+
+	switch {
+	case ctx.Err() != nil:
+		output.Exit(exitcode.Interrupted)
+	case failed > 0:
+		output.Exit(exitcode.Failures)
+	}
+}
+
+// review shows each edit as a diff and returns the ones to apply: all of
+// them with yes, those the user agrees to on a terminal and none otherwise
+func review(doc string, edits []edit, yes bool, path string) []edit {
+	if len(edits) == 0 {
+		logging.Infof("No edits suggested for %s", path)
+		return nil
+	}
+	interactive := !yes && term.IsTerminal(int(os.Stdin.Fd()))
+	in := bufio.NewReader(os.Stdin)
+	var applied []edit
+	for i, e := range edits {
+		fmt.Printf("\n--- %s:%d\n", path, e.p.Line)
+		for _, l := range textdiff.Lines(doc[e.p.Start:e.p.End], e.p.Rewrite(e.text)) {
+			if l.Kind != '~' {
+				fmt.Printf("%c %s\n", l.Kind, l.Text)
+			}
+		}
+		if yes {
+			applied = append(applied, e)
+			continue
+		}
+		if !interactive {
+			continue
+		}
+		fmt.Print(i18n.Sprintf("Apply this edit (%d of %d)? [y/N/a/q] ", i+1, len(edits)))
+		answer, _ := in.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y":
+			applied = append(applied, e)
+		case "a":
+			return append(applied, edits[i:]...)
+		case "q":
+			return applied
+		}
+	}
+	if !yes && !interactive {
+		fmt.Println()
+		logging.Infof("Left %s unchanged; pass -yes to apply the %d edits without asking", path, len(edits))
+	}
+	return applied
+}
+
+// apply returns doc with the edits made, which are in document order
+func apply(doc string, edits []edit) string {
+	for i := len(edits) - 1; i >= 0; i-- {
+		e := edits[i]
+		doc = doc[:e.p.Start] + e.p.Rewrite(e.text) + doc[e.p.End:]
+	}
+	return doc
+}
+
+// clean takes the paragraph out of an answer the model wrapped in a code
+// fence or quotes despite being asked not to
+func clean(answer, original string) string {
+	s := strings.TrimSpace(answer)
+	if strings.HasPrefix(s, "```") && strings.HasSuffix(s, "```") && !strings.HasPrefix(original, "```") {
+		s = strings.TrimSuffix(s, "```")
+		if _, rest, ok := strings.Cut(s, "\n"); ok {
+			s = rest
+		}
+		s = strings.TrimSpace(s)
+	}
+	for opening, closing := range map[string]string{`"`: `"`, "“": "”"} {
+		if len(s) > len(opening)+len(closing) && strings.HasPrefix(s, opening) && strings.HasSuffix(s, closing) && !strings.HasPrefix(original, opening) {
+			s = strings.TrimSpace(s[len(opening) : len(s)-len(closing)])
+		}
+	}
+	return s
+}
+
+// markup returns the parts of text an edit must keep, sorted
+func markup(text string) []string {
+	found := fixedMarkup.FindAllString(text, -1)
+	slices.Sort(found)
+	return found
+}
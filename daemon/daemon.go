@@ -0,0 +1,213 @@
+// Package daemon keeps goodness running in the background: it watches
+// folders and runs a workflow when files in them change, and reports what
+// it is doing on a status endpoint
+package daemon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"GoodnessucWorkflow/internal/config"
+	"GoodnessucWorkflow/workflow"
+)
+
+// Config is a parsed daemon file:
+//
+//	status: 127.0.0.1:7878
+//	rules:
+//	  - name: screenshots
+//	    watch: ~/Desktop
+//	    match: ["Screenshot*.png"]
+//	    workflow: publish
+//	    debounce: 5s
+//	    concurrency: 1
+//	  - name: nightly-archive
+//	    schedule: "0 2 * * *"
+//	    jitter: 10m
+//	    workflow: archive.yaml
+//
+// A run started by changes gets the variables watch.dir, the watched
+// folder, and watch.files, the changed files separated by commas. A run
+// started by the schedule gets schedule.time, the time it was due, in
+// RFC 3339 form
+type Config struct {
+	Status string `yaml:"status"`
+	Rules  []Rule `yaml:"rules"`
+}
+
+// Rule runs a workflow when files in a folder are created or written, on a
+// schedule, or both
+type Rule struct {
+	Name      string   `yaml:"name"`
+	Watch     string   `yaml:"watch"`
+	Recursive bool     `yaml:"recursive"`
+	Match     []string `yaml:"match"`
+	// Workflow is a workflow file, relative to the daemon file, or a name
+	// from the configuration files
+	Workflow string            `yaml:"workflow"`
+	Vars     map[string]string `yaml:"vars"`
+	// Debounce is how long the folder must be quiet before a run starts
+	Debounce time.Duration `yaml:"debounce"`
+	// Concurrency caps the runs of this rule at once; changes seen while
+	// it is reached start one more run when a slot frees up
+	Concurrency int `yaml:"concurrency"`
+	// Schedule is a cron expression in local time, such as "0 2 * * *" or
+	// @hourly. A scheduled run is skipped while the rule is still running
+	Schedule string `yaml:"schedule"`
+	// Jitter delays each scheduled run by a random time up to it, so rules
+	// due at the same time do not all start at once
+	Jitter time.Duration `yaml:"jitter"`
+
+	cron *schedule
+}
+
+// defaultDebounce lets a burst of writes, such as a copied folder, settle
+// into one run
+const defaultDebounce = 2 * time.Second
+
+// DefaultPath is daemon.yaml next to the global configuration file
+func DefaultPath() (string, error) {
+	global, err := config.GlobalPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(global), "daemon.yaml"), nil
+}
+
+// Load reads a daemon file and checks every rule, resolving folders and
+// workflows to absolute paths
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	c := &Config{}
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if len(c.Rules) == 0 {
+		return nil, fmt.Errorf("%s: no rules", path)
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("%s: rule %d: duplicate name %q", path, i+1, r.Name)
+		}
+		seen[r.Name] = true
+		if err := r.check(dir); err != nil {
+			return nil, fmt.Errorf("%s: rule %s: %s", path, r.Name, err)
+		}
+	}
+	return c, nil
+}
+
+func (r *Rule) check(dir string) error {
+	switch {
+	case r.Watch == "" && r.Schedule == "":
+		return errors.New("needs a folder to watch or a schedule")
+	case r.Workflow == "":
+		return errors.New("needs a workflow")
+	case r.Debounce < 0:
+		return errors.New("debounce cannot be negative")
+	case r.Concurrency < 0:
+		return errors.New("concurrency cannot be negative")
+	case r.Jitter < 0:
+		return errors.New("jitter cannot be negative")
+	}
+	if r.Schedule != "" {
+		var err error
+		if r.cron, err = parseSchedule(r.Schedule); err != nil {
+			return fmt.Errorf("schedule: %s", err)
+		}
+		if _, err := r.cron.next(time.Now()); err != nil {
+			return fmt.Errorf("schedule: %s", err)
+		}
+	}
+	for _, pattern := range r.Match {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("match: %q: %s", pattern, err)
+		}
+	}
+	if r.Debounce == 0 {
+		r.Debounce = defaultDebounce
+	}
+	if r.Concurrency == 0 {
+		r.Concurrency = 1
+	}
+
+	if r.Watch != "" {
+		r.Watch = absolute(dir, r.Watch)
+		info, err := os.Stat(r.Watch)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a folder", r.Watch)
+		}
+	}
+
+	var err error
+	workflowPath := r.Workflow
+	if strings.ContainsRune(workflowPath, filepath.Separator) || filepath.Ext(workflowPath) != "" {
+		workflowPath = absolute(dir, workflowPath)
+	}
+	if r.Workflow, err = workflow.Resolve(workflowPath); err != nil {
+		return err
+	}
+	if r.Workflow, err = filepath.Abs(r.Workflow); err != nil {
+		return err
+	}
+	_, err = workflow.Load(r.Workflow)
+	return err
+}
+
+// matches reports whether a changed file should trigger the rule: not
+// hidden or temporary, and matching one of its patterns when it has any
+func (r *Rule) matches(path string) bool {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+		return false
+	}
+	if len(r.Match) == 0 {
+		return true
+	}
+	for _, pattern := range r.Match {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// absolute resolves path against dir, expanding a leading ~ to the home
+// folder
+func absolute(dir, path string) string {
+	if rest, ok := strings.CutPrefix(path, "~"); ok && (rest == "" || rest[0] == '/' || rest[0] == filepath.Separator) {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, rest)
+		}
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return filepath.Clean(path)
+}
@@ -0,0 +1,161 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/metrics"
+	"GoodnessucWorkflow/internal/output"
+)
+
+// defaultStatus is where the status endpoint listens unless the daemon file
+// or -status says otherwise
+const defaultStatus = "127.0.0.1:7878"
+
+// Run is the daemon command
+func Run(args []string) {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	logging.AddFlags(fs)
+	status := fs.String("status", "", "address of the status endpoint, or off (default the daemon file's status, else "+defaultStatus+")")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness daemon [flags] [daemon.yaml]\n\nWatches the folders of each rule in the daemon file and runs the rule's\nworkflow once changes settle, and on the rule's cron schedule, until\ninterrupted. GET /status on the status endpoint reports every rule and GET\n/metrics serves Prometheus metrics. The default file is daemon.yaml next to the global configuration file.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	var path string
+	switch fs.NArg() {
+	case 0:
+		var err error
+		if path, err = DefaultPath(); err != nil {
+			logging.Fatalf("Failed to locate the daemon file: %s", err)
+		}
+	case 1:
+		path = fs.Arg(0)
+	default:
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	c, err := Load(path)
+	if err != nil {
+		output.AnnotateError(path, "invalid daemon file", err)
+		logging.Exitf(exitcode.Usage, "Invalid daemon file: %s", err)
+	}
+	if *status == "" {
+		*status = c.Status
+	}
+	if *status == "" {
+		*status = defaultStatus
+	}
+	self, err := os.Executable()
+	if err != nil {
+		logging.Fatalf("Failed to find the goodness executable: %s", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	d := &daemon{started: time.Now()}
+	var wg sync.WaitGroup
+	for _, r := range c.Rules {
+		d.states = append(d.states, &ruleState{Rule: r, self: self, ctx: ctx, wg: &wg, changed: make(map[string]bool)})
+	}
+
+	if *status != "off" {
+		srv := &http.Server{Addr: *status, Handler: d}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logging.Errorf("Failed to serve status: %s", err)
+			}
+		}()
+		defer srv.Close()
+		logging.Infof("Status on http://%s/status", *status)
+	}
+
+	for _, s := range d.states {
+		if s.cron != nil {
+			go s.schedule()
+			logging.Infof("Rule %s: scheduled %s", s.Name, s.Schedule)
+		}
+	}
+	if err := watch(ctx, d.states); err != nil {
+		logging.Fatalf("Failed to watch: %s", err)
+	}
+	logging.Infof("Stopping; waiting for running workflows")
+	wg.Wait()
+}
+
+// daemon is the state the status endpoint reports
+type daemon struct {
+	started time.Time
+	states  []*ruleState
+}
+
+// ruleStatus is one rule in the status report
+type ruleStatus struct {
+	Name     string     `json:"name"`
+	Watch    string     `json:"watch,omitempty"`
+	Schedule string     `json:"schedule,omitempty"`
+	Workflow string     `json:"workflow"`
+	Running  int        `json:"running"`
+	Pending  int        `json:"pending"`
+	Runs     int        `json:"runs"`
+	Failures int        `json:"failures"`
+	Skipped  int        `json:"skipped"`
+	LastRun  *runRecord `json:"last_run,omitempty"`
+	NextRun  *time.Time `json:"next_run,omitempty"`
+}
+
+func (d *daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/metrics" && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		metrics.Handler().ServeHTTP(w, r)
+		return
+	}
+	if r.URL.Path != "/status" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report := struct {
+		Started time.Time    `json:"started"`
+		Uptime  string       `json:"uptime"`
+		Rules   []ruleStatus `json:"rules"`
+	}{Started: d.started, Uptime: time.Since(d.started).Round(time.Second).String()}
+	for _, s := range d.states {
+		s.mu.Lock()
+		status := ruleStatus{
+			Name: s.Name, Watch: s.Watch, Schedule: s.Schedule, Workflow: s.Workflow,
+			Running: s.running, Pending: len(s.changed), Runs: s.runs, Failures: s.failures, Skipped: s.skipped, LastRun: s.last,
+		}
+		if !s.nextRun.IsZero() {
+			next := s.nextRun
+			status.NextRun = &next
+		}
+		report.Rules = append(report.Rules, status)
+		s.mu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
@@ -0,0 +1,134 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+)
+
+// span is the set of values from lo to hi, every step
+func span(lo, hi, step int) uint64 {
+	var set uint64
+	for v := lo; v <= hi; v += step {
+		set |= 1 << v
+	}
+	return set
+}
+
+func values(vs ...int) uint64 {
+	var set uint64
+	for _, v := range vs {
+		set |= 1 << v
+	}
+	return set
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec string
+		want schedule
+	}{
+		{"5 4 * * *", schedule{values(5), values(4), span(1, 31, 1), span(1, 12, 1), span(0, 7, 1), true, true}},
+		{"*/15 9-17 1,15 jan-mar mon-fri", schedule{values(0, 15, 30, 45), span(9, 17, 1), values(1, 15), values(1, 2, 3), span(1, 5, 1), false, false}},
+		{"0-30/10 */6 10-20/5 */3 sun,sat", schedule{values(0, 10, 20, 30), values(0, 6, 12, 18), values(10, 15, 20), values(1, 4, 7, 10), values(0, 6), false, false}},
+		{"7/20 0 1-5,10 DEC Sun", schedule{values(7, 27, 47), values(0), values(1, 2, 3, 4, 5, 10), values(12), values(0), false, false}},
+		{"0 0 */2 * 7", schedule{values(0), values(0), span(1, 31, 2), span(1, 12, 1), values(0, 7), true, false}},
+		{"0 0 13 * fri-sat", schedule{values(0), values(0), values(13), span(1, 12, 1), values(5, 6), false, false}},
+		{"@weekly", schedule{values(0), values(0), span(1, 31, 1), span(1, 12, 1), values(0), true, false}},
+		{"  @Daily ", schedule{values(0), values(0), span(1, 31, 1), span(1, 12, 1), span(0, 7, 1), true, true}},
+	}
+	for _, tt := range tests {
+		got, err := parseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("parseSchedule(%q): %s", tt.spec, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("parseSchedule(%q) = %+v, want %+v", tt.spec, *got, tt.want)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"1- * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"* * * * fri-mon",
+		"@every 5m",
+	} {
+		if s, err := parseSchedule(spec); err == nil {
+			t.Errorf("parseSchedule(%q) = %+v, want an error", spec, *s)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04:05", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	// 2026-10-16 is a Friday
+	tests := []struct {
+		spec, from, want string
+	}{
+		{"0 2 * * *", "2026-10-16 01:59:00", "2026-10-16 02:00:00"},
+		{"0 2 * * *", "2026-10-16 02:00:00", "2026-10-17 02:00:00"},
+		{"0 2 * * *", "2026-10-16 01:59:59", "2026-10-16 02:00:00"},
+		{"*/15 9-17 * * mon-fri", "2026-10-16 10:07:00", "2026-10-16 10:15:00"},
+		{"*/15 9-17 * * mon-fri", "2026-10-16 17:50:00", "2026-10-19 09:00:00"},
+		{"@monthly", "2026-10-31 12:00:00", "2026-11-01 00:00:00"},
+		{"30 23 31 12 *", "2026-12-31 23:30:00", "2027-12-31 23:30:00"},
+		{"0 12 * jun,dec *", "2026-10-16 00:00:00", "2026-12-01 12:00:00"},
+		{"0 0 29 2 *", "2026-10-16 00:00:00", "2028-02-29 00:00:00"},
+		{"0 0 * * 7", "2026-10-16 00:00:00", "2026-10-18 00:00:00"},
+		// Both day fields restricted: a day matching either runs
+		{"0 0 20 * fri", "2026-10-16 00:00:00", "2026-10-20 00:00:00"},
+		{"0 0 20 * fri", "2026-10-20 00:00:00", "2026-10-23 00:00:00"},
+		// A day field starting with * does not restrict, stepped or not
+		{"0 0 */2 * sun", "2026-10-16 00:00:00", "2026-10-18 00:00:00"},
+		{"0 0 20 * */7", "2026-10-16 00:00:00", "2026-10-20 00:00:00"},
+	}
+	for _, tt := range tests {
+		s, err := parseSchedule(tt.spec)
+		if err != nil {
+			t.Fatalf("parseSchedule(%q): %s", tt.spec, err)
+		}
+		got, err := s.next(at(tt.from))
+		if err != nil {
+			t.Errorf("%q next after %s: %s", tt.spec, tt.from, err)
+			continue
+		}
+		if want := at(tt.want); !got.Equal(want) {
+			t.Errorf("%q next after %s = %s, want %s", tt.spec, tt.from, got.Format(time.DateTime), tt.want)
+		}
+	}
+}
+
+func TestScheduleNever(t *testing.T) {
+	for _, spec := range []string{"0 0 30 2 *", "0 0 31 4,6,9,11 *"} {
+		s, err := parseSchedule(spec)
+		if err != nil {
+			t.Fatalf("parseSchedule(%q): %s", spec, err)
+		}
+		if got, err := s.next(time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)); err != errNever {
+			t.Errorf("%q next = %s, %v, want errNever", spec, got, err)
+		}
+	}
+}
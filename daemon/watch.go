@@ -0,0 +1,293 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/metrics"
+)
+
+// stopGrace is how long a workflow may take to stop after the daemon asks
+// it to before it is killed
+const stopGrace = 10 * time.Second
+
+// runRecord describes one finished run of a rule
+type runRecord struct {
+	// Trigger is watch or schedule
+	Trigger  string    `json:"trigger"`
+	Started  time.Time `json:"started"`
+	Duration string    `json:"duration"`
+	Files    int       `json:"files,omitempty"`
+	ExitCode int       `json:"exit_code"`
+}
+
+// ruleState is a rule while the daemon runs: the changes waiting for the
+// next run and the counters the status endpoint reports
+type ruleState struct {
+	Rule
+	self string
+	ctx  context.Context
+	wg   *sync.WaitGroup
+
+	mu       sync.Mutex
+	changed  map[string]bool
+	timer    *time.Timer
+	running  int
+	runs     int
+	failures int
+	skipped  int
+	last     *runRecord
+	nextRun  time.Time
+}
+
+// covers reports whether the rule watches dir
+func (s *ruleState) covers(dir string) bool {
+	if s.Watch == "" {
+		return false
+	}
+	if dir == s.Watch {
+		return true
+	}
+	rel, err := filepath.Rel(s.Watch, dir)
+	return s.Recursive && err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// changedFile records a change and restarts the debounce timer
+func (s *ruleState) changedFile(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed[path] = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer = time.AfterFunc(s.Debounce, s.start)
+}
+
+// start runs the workflow for the changes seen so far, unless the rule is
+// already running as often as it may; the changes then wait for the next
+// run to finish
+func (s *ruleState) start() {
+	s.mu.Lock()
+	if len(s.changed) == 0 || s.running >= s.Concurrency || s.ctx.Err() != nil {
+		s.mu.Unlock()
+		return
+	}
+	files := make([]string, 0, len(s.changed))
+	for path := range s.changed {
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	clear(s.changed)
+	s.running++
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	logging.Infof("Rule %s: running %s for %d changed files", s.Name, filepath.Base(s.Workflow), len(files))
+	go s.run("watch", len(files), "watch.dir="+s.Watch, "watch.files="+strings.Join(files, ","))
+}
+
+// startScheduled runs the workflow for the schedule unless the rule is
+// already running, which would let slow runs pile up
+func (s *ruleState) startScheduled(due time.Time) {
+	s.mu.Lock()
+	if s.running > 0 || s.ctx.Err() != nil {
+		if s.running > 0 {
+			s.skipped++
+			metrics.Skipped(s.Name)
+			logging.Warnf("Rule %s: skipping the run due %s; the last one is still running", s.Name, due.Format(time.RFC3339))
+		}
+		s.mu.Unlock()
+		return
+	}
+	s.running++
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	logging.Infof("Rule %s: running %s on schedule", s.Name, filepath.Base(s.Workflow))
+	go s.run("schedule", 0, "schedule.time="+due.Format(time.RFC3339))
+}
+
+// schedule starts the scheduled runs of the rule until ctx is done
+func (s *ruleState) schedule() {
+	for {
+		due, err := s.cron.next(time.Now())
+		if err != nil {
+			logging.Errorf("Rule %s: %s", s.Name, err)
+			return
+		}
+		start := due
+		if s.Jitter > 0 {
+			start = start.Add(rand.N(s.Jitter))
+		}
+		s.mu.Lock()
+		s.nextRun = start
+		s.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(start))
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.startScheduled(due)
+		}
+	}
+}
+
+// run runs the workflow once with vars, NAME=VALUE, ahead of the rule's own
+func (s *ruleState) run(trigger string, files int, vars ...string) {
+	defer s.wg.Done()
+
+	args := []string{"run"}
+	for _, v := range vars {
+		args = append(args, "-var", v)
+	}
+	for _, name := range sortedKeys(s.Vars) {
+		args = append(args, "-var", name+"="+s.Vars[name])
+	}
+	args = append(args, s.Workflow)
+
+	start := time.Now()
+	cmd := exec.CommandContext(s.ctx, s.self, args...)
+	cmd.Dir = filepath.Dir(s.Workflow)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = stopGrace
+	err := cmd.Run()
+
+	code := 0
+	if err != nil {
+		code = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		}
+		logging.Errorf("Rule %s: workflow failed: %s", s.Name, err)
+	} else {
+		logging.Infof("Rule %s: workflow finished in %s", s.Name, time.Since(start).Round(time.Millisecond))
+	}
+
+	s.mu.Lock()
+	s.running--
+	s.runs++
+	if err != nil {
+		s.failures++
+	}
+	s.last = &runRecord{Trigger: trigger, Started: start, Duration: time.Since(start).Round(time.Millisecond).String(), Files: files, ExitCode: code}
+	s.mu.Unlock()
+	metrics.Run(s.Name, err == nil, files, time.Since(start))
+
+	// Changes that arrived while every slot was busy run now
+	s.start()
+}
+
+// watch feeds file events to the rules until ctx is done
+func watch(ctx context.Context, states []*ruleState) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	for _, s := range states {
+		if s.Watch == "" {
+			continue
+		}
+		if err := addTree(w, s.Watch, s.Recursive); err != nil {
+			return err
+		}
+		logging.Infof("Rule %s: watching %s", s.Name, s.Watch)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			logging.Warnf("Failed to watch: %s", err)
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if !ev.Has(fsnotify.Create) && !ev.Has(fsnotify.Write) {
+				continue
+			}
+			if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+				if ev.Has(fsnotify.Create) {
+					addFolder(w, states, ev.Name)
+				}
+				continue
+			}
+			for _, s := range states {
+				if s.covers(filepath.Dir(ev.Name)) && s.matches(ev.Name) {
+					logging.Debugf("Rule %s: changed %s", s.Name, ev.Name)
+					s.changedFile(ev.Name)
+				}
+			}
+		}
+	}
+}
+
+// addFolder starts watching a folder created under a recursive rule and
+// counts the files already in it as changed, since a folder moved or copied
+// in arrives whole
+func addFolder(w *fsnotify.Watcher, states []*ruleState, dir string) {
+	watched := false
+	for _, s := range states {
+		if !s.Recursive || !s.covers(dir) {
+			continue
+		}
+		if !watched {
+			if err := addTree(w, dir, true); err != nil {
+				logging.Warnf("Failed to watch %s: %s", dir, err)
+			}
+			watched = true
+		}
+		filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && s.matches(path) {
+				s.changedFile(path)
+			}
+			return nil
+		})
+	}
+}
+
+// addTree watches dir and, when recursive, every folder below it that is
+// not hidden
+func addTree(w *fsnotify.Watcher, dir string, recursive bool) error {
+	if !recursive {
+		return w.Add(dir)
+	}
+	return filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return w.Add(path)
+	})
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
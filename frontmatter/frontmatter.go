@@ -0,0 +1,372 @@
+// Package frontmatter implements goodness md frontmatter, which edits the
+// YAML front matter of many posts at once: it sets, renames and deletes
+// keys, adds and removes tags and rewrites dates, in the posts a filter on
+// existing values picks
+package frontmatter
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/internal/textdiff"
+	"GoodnessucWorkflow/pkg/markdown"
+)
+
+// listFlag collects the values of a flag that may be repeated
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ", ") }
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Run is the md frontmatter command
+func Run(args []string) {
+	fs := flag.NewFlagSet("frontmatter", flag.ExitOnError)
+	logging.AddFlags(fs)
+	var where, set, rename, del, addTag, removeTag listFlag
+	fs.Var(&where, "where", "only edit posts where `key=value`, key!=value, key or !key holds; a list matches when it holds value; may be repeated, and all must hold")
+	fs.Var(&set, "set", "set `key=value`, with value read as YAML, as draft=false or tags=[go, web]; may be repeated")
+	fs.Var(&rename, "rename", "rename the key `old=new`; may be repeated")
+	fs.Var(&del, "delete", "delete the `key`; may be repeated")
+	fs.Var(&addTag, "add-tag", "add the `tag` to the posts' tags; may be repeated")
+	fs.Var(&removeTag, "remove-tag", "remove the `tag` from the posts' tags; may be repeated")
+	tagsKey := fs.String("tags-key", "tags", "key holding the tags -add-tag and -remove-tag change")
+	dateFormat := fs.String("date-format", "", "rewrite the dates in -date-keys in this Go layout, such as 2006-01-02, or rfc3339")
+	dateKeys := fs.String("date-keys", "date,lastmod,publishDate,expiryDate", "comma separated keys -date-format rewrites")
+	dryRun := fs.Bool("dry-run", false, "print the changes as diffs without touching any file")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness md frontmatter [flags] post.md|folder ...\n\nEdits the YAML front matter of every post given or found in the folders:\nrenames, sets and deletes keys, adds and removes tags and rewrites dates,\nin that order. -where picks the posts to edit by their current values.\nPosts without front matter are left alone. -dry-run prints the changes as\ndiffs; otherwise they are written, and goodness undo reverses them.\n\nThe front matter of an edited post is written back with its indentation,\nincluding lists flush under their keys, but comments and the spacing\nwithin lines come out normalized, as in \"key: value # comment\". Use\n-dry-run to see the whole change first.\n\nExamples:\n  goodness md frontmatter -where draft=true -set draft=false posts/\n  goodness md frontmatter -rename categories=tags -add-tag go posts/\n  goodness md frontmatter -date-format 2006-01-02 -dry-run posts/\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	e := editor{tagsKey: *tagsKey, addTags: addTag, removeTags: removeTag, deletes: del}
+	var err error
+	if e.filters, err = parseFilters(where); err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid -where value: %s", err)
+	}
+	if e.sets, err = parsePairs(set, true); err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid -set value: %s", err)
+	}
+	if e.renames, err = parsePairs(rename, false); err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid -rename value: %s", err)
+	}
+	if *dateFormat != "" {
+		if e.dateLayout, err = dateLayout(*dateFormat); err != nil {
+			logging.Exitf(exitcode.Usage, "Invalid -date-format value %q: %s", *dateFormat, err)
+		}
+		for _, k := range strings.Split(*dateKeys, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				e.dateKeys = append(e.dateKeys, k)
+			}
+		}
+	}
+	if fs.NArg() == 0 || !e.edits() {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+
+	var posts []string
+	for _, arg := range fs.Args() {
+		found, err := fsutil.MarkdownFiles(arg)
+		if err != nil {
+			logging.Fatalf("Failed to read %s: %s", arg, err)
+		}
+		posts = append(posts, found...)
+	}
+
+	matched, changed, failed := 0, 0, 0
+	for _, path := range posts {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			logging.Errorf("Failed to read %s: %s", path, err)
+			output.Processed(path, "failed", "read", err)
+			failed++
+			continue
+		}
+		doc := string(data)
+		edited, ok, err := e.edit(doc)
+		if err != nil {
+			logging.Errorf("Failed to edit the front matter of %s: %s", path, err)
+			output.Processed(path, "failed", "edit", err)
+			failed++
+			continue
+		}
+		if !ok {
+			output.Processed(path, "skipped", "", nil)
+			continue
+		}
+		matched++
+		if edited == doc {
+			output.Processed(path, "ok", "", nil)
+			continue
+		}
+		changed++
+
+		if *dryRun {
+			fmt.Printf("\n--- %s\n", path)
+			for _, l := range textdiff.Lines(doc, edited) {
+				if l.Kind == '~' {
+					fmt.Println("  ...")
+					continue
+				}
+				fmt.Printf("%c %s\n", l.Kind, l.Text)
+			}
+			output.Processed(path, "ok", "", nil)
+			continue
+		}
+		if err := journal.WriteFile(path, []byte(edited), 0644); err != nil {
+			logging.Errorf("Failed to write %s: %s", path, err)
+			output.Processed(path, "failed", "write", err)
+			failed++
+			continue
+		}
+		output.Wrote(path)
+		output.Processed(path, "ok", "", nil)
+	}
+
+	output.Set("matched", matched)
+	output.Set("changed", changed)
+	if *dryRun {
+		fmt.Println()
+		logging.Infof("Would change %d of %d posts with front matter that matched", changed, matched)
+	} else {
+		logging.Infof("Changed %d of %d posts with front matter that matched", changed, matched)
+	}
+	switch {
+	case failed > 0:
+		output.Exit(exitcode.Failures)
+	case matched == 0:
+		output.Exit(exitcode.NoMatch)
+	}
+}
+
+// pair is a key and a value given as key=value
+type pair struct {
+	key   string
+	value string
+}
+
+// parsePairs reads key=value flags. With yamlValue the values must be YAML
+func parsePairs(values []string, yamlValue bool) ([]pair, error) {
+	var pairs []pair
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%q; expected key=value", v)
+		}
+		if yamlValue {
+			if _, err := yamlNode(value); err != nil {
+				return nil, fmt.Errorf("%q: %w", v, err)
+			}
+		} else if value = strings.TrimSpace(value); value == "" {
+			return nil, fmt.Errorf("%q; expected old=new", v)
+		}
+		pairs = append(pairs, pair{key, value})
+	}
+	return pairs, nil
+}
+
+// yamlNode reads a -set value as a YAML node
+func yamlNode(value string) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		// An empty value is an empty string, not null
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Style: yaml.DoubleQuotedStyle}, nil
+	}
+	node := doc.Content[0]
+	if node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode {
+		node.Style |= yaml.FlowStyle
+	}
+	return node, nil
+}
+
+// editor holds the edits to make to each post
+type editor struct {
+	filters             []filter
+	renames, sets       []pair
+	deletes             []string
+	tagsKey             string
+	addTags, removeTags []string
+	dateLayout          string
+	dateKeys            []string
+}
+
+// edits reports whether e changes anything
+func (e *editor) edits() bool {
+	return len(e.renames)+len(e.sets)+len(e.deletes)+len(e.addTags)+len(e.removeTags) > 0 || e.dateLayout != ""
+}
+
+// edit returns doc with its front matter edited. ok is false when doc has
+// no front matter or the filters leave it out
+func (e *editor) edit(doc string) (edited string, ok bool, err error) {
+	front, body, found := markdown.SplitFrontMatter(doc)
+	if !found {
+		return doc, false, nil
+	}
+	// The line break before the closing --- ends the last value, as a
+	// literal block keeps it
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(front+"\n"), &root); err != nil {
+		return "", false, err
+	}
+	var m *yaml.Node
+	switch {
+	case len(root.Content) == 0:
+		m = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{m}}
+	case root.Content[0].Kind == yaml.MappingNode:
+		m = root.Content[0]
+	default:
+		return "", false, errors.New("the front matter is not a mapping of keys to values")
+	}
+	layout := layoutOf(&root)
+	for _, f := range e.filters {
+		if !f.matches(m, e.tagsKey) {
+			return doc, false, nil
+		}
+	}
+
+	changed := false
+	for _, r := range e.renames {
+		key, _ := lookup(m, r.key)
+		if key == nil {
+			continue
+		}
+		if other, _ := lookup(m, r.value); other != nil {
+			return "", true, fmt.Errorf("cannot rename %s to %s, which is already set", r.key, r.value)
+		}
+		key.Value, changed = r.value, true
+	}
+	for _, s := range e.sets {
+		node, _ := yamlNode(s.value)
+		if _, value := lookup(m, s.key); value != nil && sameValue(value, node) {
+			continue
+		}
+		setValue(m, s.key, node)
+		changed = true
+	}
+	for _, key := range e.deletes {
+		changed = deleteKey(m, key) || changed
+	}
+	if len(e.addTags)+len(e.removeTags) > 0 {
+		changed = e.editTags(m) || changed
+	}
+	if e.dateLayout != "" {
+		c, err := e.reformatDates(m)
+		if err != nil {
+			return "", true, err
+		}
+		changed = c || changed
+	}
+	if !changed {
+		return doc, true, nil
+	}
+
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(layout.indent)
+	if err := enc.Encode(&root); err != nil {
+		return "", true, err
+	}
+	enc.Close()
+	newFront := strings.TrimSuffix(b.String(), "\n")
+	if layout.flushLists {
+		newFront = flushLists(newFront, layout.indent)
+	}
+	if newFront == "{}" {
+		newFront = ""
+	}
+
+	// Only the front matter changes; its --- lines and the body stay
+	header := doc[:len(doc)-len(body)]
+	frontStart := strings.IndexByte(header, '\n') + 1
+	frontEnd := frontStart + len(front)
+	if strings.HasPrefix(header, "---\r\n") {
+		newFront = strings.ReplaceAll(newFront, "\n", "\r\n")
+	}
+	switch {
+	case front == "" && newFront != "":
+		newFront += "\n"
+		if strings.HasPrefix(header, "---\r\n") {
+			newFront += "\r"
+		}
+	case front != "" && newFront == "":
+		// The line break after the old front matter goes with it
+		if strings.HasPrefix(doc[frontEnd:], "\r\n") {
+			frontEnd += 2
+		} else if strings.HasPrefix(doc[frontEnd:], "\n") {
+			frontEnd++
+		}
+	}
+	return doc[:frontStart] + newFront + doc[frontEnd:], true, nil
+}
+
+// lookup returns the key and value nodes of key in the mapping m, or nils
+func lookup(m *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i], m.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// setValue sets key to value in the mapping m, adding it at the end when
+// it is not there
+func setValue(m *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			// Comments stay with the key
+			value.LineComment = cmp.Or(value.LineComment, m.Content[i+1].LineComment)
+			m.Content[i+1] = value
+			return
+		}
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+// deleteKey removes key from the mapping m and reports whether it was there
+func deleteKey(m *yaml.Node, key string) bool {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content = slices.Delete(m.Content, i, i+2)
+			return true
+		}
+	}
+	return false
+}
+
+// sameValue reports whether two nodes hold the same YAML value
+func sameValue(a, b *yaml.Node) bool {
+	var va, vb any
+	if a.Decode(&va) != nil || b.Decode(&vb) != nil {
+		return false
+	}
+	ea, _ := yaml.Marshal(va)
+	eb, _ := yaml.Marshal(vb)
+	return bytes.Equal(ea, eb)
+}
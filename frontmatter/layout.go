@@ -0,0 +1,118 @@
+package frontmatter
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// layout is how the front matter was indented, kept when it is written
+// back. Comments and spacing within lines still come out as the YAML
+// encoder writes them
+type layout struct {
+	// indent is the indentation of nested mappings
+	indent int
+	// flushLists is set when block lists start at the column of their key,
+	// as "tags:\n- go", rather than indented under it
+	flushLists bool
+}
+
+// layoutOf reads the layout of the front matter parsed into root, taking
+// the first nested mapping and the first block list it finds as examples
+func layoutOf(root *yaml.Node) layout {
+	l := layout{indent: 2}
+	var foundIndent, foundList bool
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		if n.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				key, value := n.Content[i], n.Content[i+1]
+				block := value.Style&yaml.FlowStyle == 0 && len(value.Content) > 0
+				switch {
+				case block && value.Kind == yaml.MappingNode && !foundIndent:
+					if indent := value.Content[0].Column - key.Column; indent >= 2 && indent <= 9 {
+						l.indent, foundIndent = indent, true
+					}
+				case block && value.Kind == yaml.SequenceNode && !foundList:
+					l.flushLists, foundList = value.Column == key.Column, true
+				}
+			}
+		}
+		for _, c := range n.Content {
+			walk(c)
+		}
+	}
+	walk(root)
+	return l
+}
+
+// blockScalar ends a line whose value is a literal or folded block, as
+// "note: |" or "- >-"
+var blockScalar = regexp.MustCompile(`(^|[:-] )[|>][-+1-9]*$`)
+
+// flushLists moves the block lists the encoder indented under their keys
+// back to the keys' column. front is the encoder's output with indent
+// spaces per level; if moving the lists would change what it holds, as
+// with text that only looks like a list inside a literal block, it is
+// returned as it is
+func flushLists(front string, indent int) string {
+	lines := strings.Split(front, "\n")
+	out := make([]string, len(lines))
+	// blocks are the columns at which the lists being moved start, and
+	// text is the column of the line starting a block scalar, whose lines
+	// below are only moved along
+	var blocks []int
+	text := -1
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" {
+			out[i] = line
+			continue
+		}
+		col := len(line) - len(trimmed)
+		if text >= 0 && col > text {
+			out[i] = line[min(len(blocks)*indent, col):]
+			continue
+		}
+		text = -1
+		for len(blocks) > 0 && col < blocks[len(blocks)-1] {
+			blocks = blocks[:len(blocks)-1]
+		}
+		out[i] = line[min(len(blocks)*indent, col):]
+		if blockScalar.MatchString(trimmed) {
+			text = col
+			continue
+		}
+
+		// A key with nothing after it whose next line is a list item at
+		// the next level opens a list. Its column counts the "- " of any
+		// list items it is in
+		keyCol := col
+		for strings.HasPrefix(trimmed, "- ") {
+			trimmed = strings.TrimLeft(trimmed[2:], " ")
+			keyCol = len(line) - len(trimmed)
+		}
+		if strings.HasPrefix(trimmed, "#") || !strings.HasSuffix(trimmed, ":") {
+			continue
+		}
+		for _, next := range lines[i+1:] {
+			nextTrimmed := strings.TrimLeft(next, " ")
+			if nextTrimmed == "" || strings.HasPrefix(nextTrimmed, "#") {
+				continue
+			}
+			if len(next)-len(nextTrimmed) == keyCol+indent && (nextTrimmed == "-" || strings.HasPrefix(nextTrimmed, "- ")) {
+				blocks = append(blocks, keyCol+indent)
+			}
+			break
+		}
+	}
+	flushed := strings.Join(out, "\n")
+
+	var before, after any
+	if yaml.Unmarshal([]byte(front), &before) != nil || yaml.Unmarshal([]byte(flushed), &after) != nil || !reflect.DeepEqual(before, after) {
+		return front
+	}
+	return flushed
+}
@@ -0,0 +1,47 @@
+package frontmatter
+
+import "testing"
+
+func TestEditKeepsLayout(t *testing.T) {
+	tests := []struct {
+		name, doc, want string
+	}{
+		{
+			"flush list",
+			"---\ntitle: Hi\ntags:\n- go\n---\nbody\n",
+			"---\ntitle: Hi\ntags:\n- go\n- rust\n---\nbody\n",
+		},
+		{
+			"indented list",
+			"---\ntitle: Hi\ntags:\n  - go\n---\nbody\n",
+			"---\ntitle: Hi\ntags:\n  - go\n  - rust\n---\nbody\n",
+		},
+		{
+			"four spaces",
+			"---\nauthor:\n    name: Ann\ntags:\n- go\n---\n",
+			"---\nauthor:\n    name: Ann\ntags:\n- go\n- rust\n---\n",
+		},
+		{
+			"nested flush lists",
+			"---\nseries:\n- name: a\n  parts:\n  - one\ntags: []\n---\n",
+			"---\nseries:\n- name: a\n  parts:\n  - one\ntags: [rust]\n---\n",
+		},
+		{
+			"literal that looks like a list",
+			"---\ntags:\n- go\nnote: |\n  key:\n    - item\n---\n",
+			"---\ntags:\n- go\n- rust\nnote: |\n  key:\n    - item\n---\n",
+		},
+		{
+			"CRLF",
+			"---\r\ntags:\r\n- go\r\n---\r\n",
+			"---\r\ntags:\r\n- go\r\n- rust\r\n---\r\n",
+		},
+	}
+	e := &editor{tagsKey: "tags", addTags: []string{"rust"}}
+	for _, tt := range tests {
+		got, ok, err := e.edit(tt.doc)
+		if err != nil || !ok || got != tt.want {
+			t.Errorf("%s: edit = %q, %v, %v; want %q", tt.name, got, ok, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package frontmatter
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// filter is a -where condition on a post's front matter
+type filter struct {
+	key, value string
+	// op is '=' for equal, '!' for not equal, 'e' for set and 'n' for not
+	// set
+	op byte
+}
+
+// parseFilters reads -where values: key=value, key!=value, key and !key
+func parseFilters(values []string) ([]filter, error) {
+	var filters []filter
+	for _, v := range values {
+		var f filter
+		switch key, value, ok := strings.Cut(v, "="); {
+		case ok && strings.HasSuffix(key, "!"):
+			f = filter{strings.TrimSpace(strings.TrimSuffix(key, "!")), value, '!'}
+		case ok:
+			f = filter{strings.TrimSpace(key), value, '='}
+		case strings.HasPrefix(v, "!"):
+			f = filter{strings.TrimSpace(v[1:]), "", 'n'}
+		default:
+			f = filter{strings.TrimSpace(v), "", 'e'}
+		}
+		if f.key == "" {
+			return nil, fmt.Errorf("%q; expected key=value, key!=value, key or !key", v)
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+// matches reports whether the front matter mapping m meets f. The tags
+// under tagsKey may also be a comma separated string
+func (f filter) matches(m *yaml.Node, tagsKey string) bool {
+	_, value := lookup(m, f.key)
+	switch f.op {
+	case 'e':
+		return value != nil
+	case 'n':
+		return value == nil
+	case '=':
+		return value != nil && holds(value, f.value, f.key == tagsKey)
+	default:
+		return value == nil || !holds(value, f.value, f.key == tagsKey)
+	}
+}
+
+// holds reports whether a value is want or, for a list, has it as an item.
+// With commas a string is a list too
+func holds(value *yaml.Node, want string, commas bool) bool {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		if commas {
+			return slices.ContainsFunc(strings.Split(value.Value, ","), func(item string) bool { return strings.TrimSpace(item) == want })
+		}
+		return value.Value == want
+	case yaml.SequenceNode:
+		return slices.ContainsFunc(value.Content, func(item *yaml.Node) bool {
+			return item.Kind == yaml.ScalarNode && item.Value == want
+		})
+	}
+	return false
+}
+
+// editTags adds and removes tags under e.tagsKey, whether they are a list
+// or a comma separated string, and reports whether they changed
+func (e *editor) editTags(m *yaml.Node) bool {
+	_, value := lookup(m, e.tagsKey)
+	var tags []string
+	switch {
+	case value == nil || value.Kind == yaml.ScalarNode && value.Tag == "!!null":
+	case value.Kind == yaml.SequenceNode:
+		for _, item := range value.Content {
+			tags = append(tags, item.Value)
+		}
+	case value.Kind == yaml.ScalarNode:
+		for _, t := range strings.Split(value.Value, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tags = append(tags, t)
+			}
+		}
+	default:
+		return false
+	}
+
+	edited := slices.Clone(tags)
+	for _, t := range e.addTags {
+		if !slices.ContainsFunc(edited, func(have string) bool { return strings.EqualFold(have, t) }) {
+			edited = append(edited, t)
+		}
+	}
+	edited = slices.DeleteFunc(edited, func(have string) bool {
+		return slices.ContainsFunc(e.removeTags, func(t string) bool { return strings.EqualFold(have, t) })
+	})
+	if slices.Equal(tags, edited) {
+		return false
+	}
+
+	switch {
+	case value != nil && value.Kind == yaml.ScalarNode && value.Tag != "!!null":
+		value.Value = strings.Join(edited, ", ")
+	case value != nil && value.Kind == yaml.SequenceNode:
+		// Kept items keep their comments and quoting
+		var items []*yaml.Node
+		for _, t := range edited {
+			i := slices.IndexFunc(value.Content, func(item *yaml.Node) bool { return item.Value == t })
+			if i >= 0 {
+				items = append(items, value.Content[i])
+			} else {
+				items = append(items, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: t})
+			}
+		}
+		value.Content = items
+	default:
+		seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, t := range edited {
+			seq.Content = append(seq.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: t})
+		}
+		setValue(m, e.tagsKey, seq)
+	}
+	return true
+}
+
+// dateLayouts are the ways of writing dates reformatDates reads
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"02 Jan 2006",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// layoutNames are the names -date-format takes for common layouts
+var layoutNames = map[string]string{
+	"rfc3339":  time.RFC3339,
+	"rfc1123":  time.RFC1123Z,
+	"date":     "2006-01-02",
+	"datetime": "2006-01-02 15:04:05",
+}
+
+// dateLayout reads -date-format: a layout name or a Go layout with a year
+func dateLayout(format string) (string, error) {
+	if layout, ok := layoutNames[strings.ToLower(format)]; ok {
+		return layout, nil
+	}
+	if !strings.Contains(format, "06") {
+		return "", errors.New("expected a Go layout such as 2006-01-02, or rfc3339, rfc1123, date or datetime")
+	}
+	return format, nil
+}
+
+// reformatDates writes the dates under e.dateKeys in e.dateLayout and
+// reports whether any changed
+func (e *editor) reformatDates(m *yaml.Node) (bool, error) {
+	changed := false
+	for _, key := range e.dateKeys {
+		_, value := lookup(m, key)
+		if value == nil || value.Kind != yaml.ScalarNode || value.Value == "" {
+			continue
+		}
+		t, err := parseDate(value.Value)
+		if err != nil {
+			return false, fmt.Errorf("cannot read the date %q in %s", value.Value, key)
+		}
+		if formatted := t.Format(e.dateLayout); formatted != value.Value {
+			// An empty tag lets the value be written plain when it can be
+			value.Value, value.Tag = formatted, ""
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// parseDate reads a date in any of dateLayouts
+func parseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("unknown date format")
+}
@@ -0,0 +1,165 @@
+package grammar
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"GoodnessucWorkflow/internal/i18n"
+)
+
+// Patterns of the builtin rules
+var (
+	word              = regexp.MustCompile(`[\p{L}\p{N}']+`)
+	multipleSpaces    = regexp.MustCompile(` {2,}`)
+	spaceBeforeSymbol = regexp.MustCompile(`\p{L}( +)[,.;:!?]`)
+	article           = regexp.MustCompile(`\b(a|an|A|An) (\p{L}+)`)
+)
+
+// Sounds the spelling of a word gets wrong for a and an
+var (
+	// consonantSounds start with a vowel and take a, as a user
+	consonantSounds = []string{"uni", "use", "usu", "uti", "ura", "ure", "uro", "eu", "one", "once", "ewe"}
+	// vowelSounds start with a consonant and take an, as an hour
+	vowelSounds = []string{"hour", "honest", "honor", "honour", "heir"}
+)
+
+// doubled are words that can be right twice in a row, as "had had"
+var doubled = []string{"had", "that"}
+
+// builtin checks text with a few rules for English that need no server
+type builtin struct {
+	disabled []string
+}
+
+// rule is a builtin check
+type rule struct {
+	id  string
+	run func(text string) []match
+}
+
+// rules are the builtin checks
+var rules = []rule{
+	{"REPEATED_WORD", repeatedWords},
+	{"MULTIPLE_SPACES", spaces},
+	{"SPACE_BEFORE_PUNCTUATION", spaceBeforePunctuation},
+	{"A_VS_AN", articles},
+}
+
+func (b *builtin) Check(_ context.Context, text, _ string) ([]match, error) {
+	var matches []match
+	for _, r := range rules {
+		if slices.Contains(b.disabled, r.id) {
+			continue
+		}
+		for _, m := range r.run(text) {
+			m.rule, m.unambiguous = r.id, len(m.replacements) == 1
+			matches = append(matches, m)
+		}
+	}
+	return matches, nil
+}
+
+// repeatedWords finds a word written twice in a row, as "the the"
+func repeatedWords(text string) []match {
+	var matches []match
+	words := word.FindAllStringIndex(text, -1)
+	for i := 1; i < len(words); i++ {
+		prev, cur := words[i-1], words[i]
+		between := text[prev[1]:cur[0]]
+		first, second := text[prev[0]:prev[1]], text[cur[0]:cur[1]]
+		if strings.Trim(between, " ") != "" || !strings.EqualFold(first, second) || isNumber(first) || slices.Contains(doubled, strings.ToLower(first)) {
+			continue
+		}
+		matches = append(matches, match{
+			start:        prev[0],
+			end:          cur[1],
+			message:      i18n.T("Possible typo: you repeated a word"),
+			replacements: []string{first},
+		})
+	}
+	return matches
+}
+
+// spaces finds runs of spaces between words
+func spaces(text string) []match {
+	var matches []match
+	for _, m := range multipleSpaces.FindAllStringIndex(text, -1) {
+		// Only between words, not at the start or end of the text
+		if m[0] == 0 || m[1] == len(text) {
+			continue
+		}
+		matches = append(matches, match{
+			start:        m[0],
+			end:          m[1],
+			message:      i18n.T("Use a single space"),
+			replacements: []string{" "},
+		})
+	}
+	return matches
+}
+
+// spaceBeforePunctuation finds spaces before a comma, full stop or other
+// punctuation
+func spaceBeforePunctuation(text string) []match {
+	var matches []match
+	for _, m := range spaceBeforeSymbol.FindAllStringSubmatchIndex(text, -1) {
+		matches = append(matches, match{
+			start:        m[2],
+			end:          m[3] + 1,
+			message:      i18n.T("Remove the space before the punctuation"),
+			replacements: []string{text[m[3] : m[3]+1]},
+		})
+	}
+	return matches
+}
+
+// articles finds a before a vowel sound and an before a consonant sound
+func articles(text string) []match {
+	var matches []match
+	for _, m := range article.FindAllStringSubmatchIndex(text, -1) {
+		a, next := text[m[2]:m[3]], text[m[4]:m[5]]
+		// A capital A is a letter, as in plan A, unless it starts a sentence
+		if a == "A" && m[2] > 0 && !strings.HasSuffix(strings.TrimRight(text[:m[2]], " "), ".") {
+			continue
+		}
+		// Abbreviations are read out by letter, as an SQL query
+		if len(next) > 1 && strings.ToUpper(next) == next {
+			continue
+		}
+		lower := strings.ToLower(next)
+		vowel := strings.ContainsRune("aeiou", rune(lower[0])) && !hasAnyPrefix(lower, consonantSounds)
+		vowel = vowel || hasAnyPrefix(lower, vowelSounds)
+		var want string
+		switch {
+		case vowel && len(a) == 1:
+			want = a + "n"
+		case !vowel && len(a) == 2:
+			want = a[:1]
+		default:
+			continue
+		}
+		matches = append(matches, match{
+			start:        m[2],
+			end:          m[5],
+			message:      i18n.Sprintf("Use %q before %q", strings.ToLower(want), next),
+			replacements: []string{want + " " + next},
+		})
+	}
+	return matches
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNumber(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) }) < 0
+}
@@ -0,0 +1,297 @@
+// Package grammar implements goodness md grammar, which checks the prose of
+// posts with LanguageTool or a few builtin rules and reports each problem
+// at its line and column in the markdown. Code, front matter, tables and
+// HTML are never checked, and -fix applies the suggestions that are
+// unambiguous
+package grammar
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/markdown"
+)
+
+// maxChunk bounds the text of one request; LanguageTool's public API
+// takes up to 20KB
+const maxChunk = 15000
+
+// checker finds problems in plain text
+type checker interface {
+	Check(ctx context.Context, text, language string) ([]match, error)
+}
+
+// match is a problem a checker found between two byte offsets of the text
+type match struct {
+	start, end   int
+	rule         string
+	message      string
+	replacements []string
+	// unambiguous is set when the one replacement is safe to apply
+	unambiguous bool
+}
+
+// Finding is a problem found in a file
+type Finding struct {
+	Path         string   `json:"path"`
+	Line         int      `json:"line"`
+	Column       int      `json:"column"`
+	Rule         string   `json:"rule"`
+	Message      string   `json:"message"`
+	Text         string   `json:"text"`
+	Replacements []string `json:"replacements,omitempty"`
+
+	// start and end are the finding's offsets in the file, and fixable is
+	// set when its one replacement can go there
+	start, end int
+	fixable    bool
+}
+
+func (f Finding) String() string {
+	s := fmt.Sprintf("%s:%d:%d: %s: %s", f.Path, f.Line, f.Column, f.Rule, f.Message)
+	if len(f.Replacements) > 0 {
+		quoted := make([]string, 0, 3)
+		for _, r := range f.Replacements[:min(len(f.Replacements), 3)] {
+			quoted = append(quoted, fmt.Sprintf("%q", r))
+		}
+		s += fmt.Sprintf(" (%q → %s)", f.Text, strings.Join(quoted, ", "))
+	}
+	return s
+}
+
+// chunk is plain text to check and the offset in the file of each byte
+type chunk struct {
+	text    strings.Builder
+	offsets []int
+}
+
+// Run is the md grammar command
+func Run(args []string) {
+	fs := flag.NewFlagSet("grammar", flag.ExitOnError)
+	logging.AddFlags(fs)
+	service := fs.String("service", "languagetool", "checker: languagetool, or builtin for a few English rules that need no network")
+	endpoint := fs.String("endpoint", "", "base URL of a LanguageTool server, such as http://localhost:8081 (default the public API)")
+	language := fs.String("language", "auto", "language of the posts for LanguageTool, such as en-US or de-DE")
+	disable := fs.String("disable", "", "comma separated rule IDs to skip")
+	headings := fs.Bool("headings", true, "check headings too")
+	fix := fs.Bool("fix", false, "apply the suggestions that are unambiguous")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness md grammar [flags] post.md|folder ...\n\nChecks the grammar and style of the prose in posts and prints each\nproblem as file:line:column. Code blocks, inline code, URLs, front\nmatter, tables and HTML are left out of the check. LanguageTool does the\nchecking, through its public API or a server given with -endpoint; a\npremium account is used when LANGUAGETOOL_USERNAME and\nLANGUAGETOOL_API_KEY are set. -service builtin checks a few English rules\nwithout the network. -fix applies the suggestions that are unambiguous,\nwhich goodness undo reverses.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	var disabled []string
+	for _, id := range strings.Split(*disable, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			disabled = append(disabled, id)
+		}
+	}
+	var c checker
+	switch *service {
+	case "languagetool":
+		lt, err := newLanguageTool(*endpoint, disabled)
+		if err != nil {
+			logging.Fatalf("Failed to read the LanguageTool account: %s", err)
+		}
+		c = lt
+	case "builtin":
+		c = &builtin{disabled: disabled}
+	default:
+		logging.Exitf(exitcode.Usage, "Invalid -service value %q; expected languagetool or builtin", *service)
+	}
+
+	var posts []string
+	for _, arg := range fs.Args() {
+		found, err := fsutil.MarkdownFiles(arg)
+		if err != nil {
+			logging.Fatalf("Failed to read %s: %s", arg, err)
+		}
+		posts = append(posts, found...)
+	}
+	if len(posts) == 0 {
+		logging.Infof("No markdown files to check")
+		output.Exit(exitcode.NoMatch)
+	}
+
+	ctx := cli.SignalContext()
+	var remaining []Finding
+	failed, fixedCount := 0, 0
+	for _, path := range posts {
+		if ctx.Err() != nil {
+			break
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			logging.Errorf("Failed to read %s: %s", path, err)
+			output.Processed(path, "failed", "read", err)
+			failed++
+			continue
+		}
+		doc := string(data)
+		findings, err := check(ctx, c, path, doc, *language, *headings)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			logging.Errorf("Failed to check %s: %s", path, err)
+			output.Processed(path, "failed", "check", err)
+			failed++
+			continue
+		}
+
+		if *fix {
+			var fixed []Finding
+			doc, fixed, findings = apply(doc, findings)
+			if len(fixed) > 0 {
+				if err := journal.WriteFile(path, []byte(doc), 0644); err != nil {
+					logging.Errorf("Failed to write %s: %s", path, err)
+					output.Processed(path, "failed", "write", err)
+					failed++
+					continue
+				}
+				output.Wrote(path)
+				fixedCount += len(fixed)
+				logging.Infof("Fixed %d problems in %s", len(fixed), path)
+			}
+		}
+		for _, f := range findings {
+			fmt.Println(f)
+			output.Annotate("warning", f.Path, f.Line, "goodness md grammar "+f.Rule, f.Message)
+		}
+		remaining = append(remaining, findings...)
+		if len(findings) > 0 {
+			output.Processed(path, "failed", "grammar", fmt.Errorf("%d problems", len(findings)))
+		} else {
+			output.Processed(path, "ok", "", nil)
+		}
+	}
+
+	output.Set("findings", append([]Finding{}, remaining...))
+	output.Set("fixed", fixedCount)
+	fmt.Printf("%d problems in %d files checked; %d fixed\n", len(remaining), len(posts), fixedCount)
+	switch {
+	case ctx.Err() != nil:
+		output.Exit(exitcode.Interrupted)
+	case failed > 0 || len(remaining) > 0:
+		output.Exit(exitcode.Failures)
+	}
+}
+
+// check returns the problems c finds in the prose of doc, in document order.
+// Problems that touch code or URLs are dropped
+func check(ctx context.Context, c checker, path, doc, language string, headings bool) ([]Finding, error) {
+	var chunks []*chunk
+	current := new(chunk)
+	for _, p := range markdown.Prose(doc) {
+		if p.Kind == markdown.KindHeading && !headings {
+			continue
+		}
+		text, offsets := p.Plain()
+		if current.text.Len() > 0 && current.text.Len()+len(text) > maxChunk {
+			chunks = append(chunks, current)
+			current = new(chunk)
+		}
+		if current.text.Len() > 0 {
+			current.text.WriteString("\n\n")
+			current.offsets = append(current.offsets, -1, -1)
+		}
+		current.text.WriteString(text)
+		current.offsets = append(current.offsets, offsets...)
+	}
+	if current.text.Len() > 0 {
+		chunks = append(chunks, current)
+	}
+
+	var findings []Finding
+	for _, ch := range chunks {
+		text := ch.text.String()
+		matches, err := c.Check(ctx, text, language)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if f, ok := locate(doc, path, text, ch.offsets, m); ok {
+				findings = append(findings, f)
+			}
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].start < findings[j].start })
+	return findings, nil
+}
+
+// locate maps a match in the plain text of a chunk to the file, or returns
+// false when it touches text that is not in the file as it was checked
+func locate(doc, path, text string, offsets []int, m match) (Finding, bool) {
+	if m.start < 0 || m.end > len(offsets) || m.start >= m.end {
+		return Finding{}, false
+	}
+	for _, at := range offsets[m.start:m.end] {
+		if at < 0 {
+			return Finding{}, false
+		}
+	}
+	start, end := offsets[m.start], offsets[m.end-1]+1
+	lineStart := strings.LastIndexByte(doc[:start], '\n') + 1
+	f := Finding{
+		Path:         path,
+		Line:         strings.Count(doc[:start], "\n") + 1,
+		Column:       utf8.RuneCountInString(doc[lineStart:start]) + 1,
+		Rule:         m.rule,
+		Message:      m.message,
+		Text:         text[m.start:m.end],
+		Replacements: m.replacements,
+		start:        start,
+		end:          end,
+	}
+	// The match must be the very bytes of the file, not text joined across
+	// markup or lines, for its replacement to go in
+	f.fixable = m.unambiguous && doc[start:end] == f.Text
+	return f, true
+}
+
+// apply makes the fixable findings' replacements in doc and returns it
+// with the findings fixed and those left, moved to where they are now. Of
+// overlapping findings only the first is fixed
+func apply(doc string, findings []Finding) (string, []Finding, []Finding) {
+	var fixed, left []Finding
+	var b strings.Builder
+	end, shift := 0, 0
+	for _, f := range findings {
+		if !f.fixable || f.start < end {
+			f.start, f.end = f.start+shift, f.end+shift
+			left = append(left, f)
+			continue
+		}
+		b.WriteString(doc[end:f.start])
+		b.WriteString(f.Replacements[0])
+		shift += len(f.Replacements[0]) - (f.end - f.start)
+		end = f.end
+		fixed = append(fixed, f)
+	}
+	b.WriteString(doc[end:])
+	doc = b.String()
+	for i, f := range left {
+		lineStart := strings.LastIndexByte(doc[:f.start], '\n') + 1
+		left[i].Column = utf8.RuneCountInString(doc[lineStart:f.start]) + 1
+		left[i].Line = strings.Count(doc[:f.start], "\n") + 1
+	}
+	return doc, fixed, left
+}
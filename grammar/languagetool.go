@@ -0,0 +1,137 @@
+package grammar
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"GoodnessucWorkflow/internal/httpclient"
+	"GoodnessucWorkflow/internal/secrets"
+	"GoodnessucWorkflow/pkg/errs"
+)
+
+// LanguageTool endpoints: the free public API and the one premium keys
+// work with
+const (
+	publicEndpoint  = "https://api.languagetool.org"
+	premiumEndpoint = "https://api.languagetoolplus.com"
+)
+
+// timeout bounds one request
+const timeout = time.Minute
+
+// languageTool checks text with a LanguageTool server, the public one or
+// one run locally
+type languageTool struct {
+	endpoint      string
+	username, key string
+	disabled      []string
+}
+
+// newLanguageTool reads the optional premium account of a LanguageTool
+// client for endpoint, or the public API's when it is empty
+func newLanguageTool(endpoint string, disabled []string) (*languageTool, error) {
+	lt := &languageTool{endpoint: strings.TrimSuffix(endpoint, "/"), disabled: disabled}
+	var err error
+	if lt.username, _, err = secrets.Lookup("LANGUAGETOOL_USERNAME"); err != nil {
+		return nil, err
+	}
+	if lt.key, _, err = secrets.Lookup("LANGUAGETOOL_API_KEY"); err != nil {
+		return nil, err
+	}
+	if lt.endpoint == "" {
+		lt.endpoint = publicEndpoint
+		if lt.key != "" {
+			lt.endpoint = premiumEndpoint
+		}
+	}
+	return lt, nil
+}
+
+func (lt *languageTool) Check(ctx context.Context, text, language string) ([]match, error) {
+	form := url.Values{"text": {text}, "language": {language}}
+	if len(lt.disabled) > 0 {
+		form.Set("disabledRules", strings.Join(lt.disabled, ","))
+	}
+	if lt.key != "" {
+		form.Set("username", lt.username)
+		form.Set("apiKey", lt.key)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, lt.endpoint+"/v2/check", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpclient.New(timeout).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, &errs.RemoteError{Service: req.URL.Host, Status: resp.Status, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	var result struct {
+		Matches []struct {
+			Message      string `json:"message"`
+			Offset       int    `json:"offset"`
+			Length       int    `json:"length"`
+			Replacements []struct {
+				Value string `json:"value"`
+			} `json:"replacements"`
+			Rule struct {
+				ID        string `json:"id"`
+				IssueType string `json:"issueType"`
+			} `json:"rule"`
+		} `json:"matches"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	// LanguageTool counts in UTF-16 code units
+	units := utf16Offsets(text)
+	var matches []match
+	for _, m := range result.Matches {
+		if m.Offset < 0 || m.Length <= 0 || m.Offset+m.Length >= len(units) {
+			continue
+		}
+		found := match{
+			start:   units[m.Offset],
+			end:     units[m.Offset+m.Length],
+			rule:    m.Rule.ID,
+			message: m.Message,
+		}
+		for _, r := range m.Replacements {
+			found.replacements = append(found.replacements, r.Value)
+		}
+		// A single suggestion is safe to apply, except for spelling,
+		// where names and jargon are often flagged
+		found.unambiguous = len(found.replacements) == 1 && m.Rule.IssueType != "misspelling"
+		matches = append(matches, found)
+	}
+	return matches, nil
+}
+
+// utf16Offsets maps each UTF-16 code unit of text, and its end, to a byte
+// offset
+func utf16Offsets(text string) []int {
+	units := make([]int, 0, len(text)+1)
+	for i, r := range text {
+		units = append(units, i)
+		if r >= 0x10000 && r <= utf8.MaxRune {
+			units = append(units, i)
+		}
+	}
+	return append(units, len(text))
+}
@@ -0,0 +1,238 @@
+package hook
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/pkg/markdown"
+)
+
+// Violation is one problem a check found
+type Violation struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line,omitempty"`
+	Check   string `json:"check"`
+	Message string `json:"message"`
+	// fixable is set when the fixed content a check returns resolves it
+	fixable bool
+}
+
+func (v Violation) String() string {
+	if v.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s: %s", v.Path, v.Line, v.Check, v.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", v.Path, v.Check, v.Message)
+}
+
+// file is a file under check: its path from the repository root and the
+// content being committed
+type file struct {
+	path string
+	data []byte
+}
+
+// checker inspects a file and may return content with its fixable
+// violations fixed, or nil when it has nothing to fix
+type checker func(f file, opts options) (violations []Violation, fixed []byte)
+
+// check is a checker and the name -checks selects it by
+type check struct {
+	name string
+	run  checker
+}
+
+// checks are the available checks, in the order they run
+var checks = []check{
+	{"lint", lintMarkdown},
+	{"links", checkLinks},
+	{"size", checkImageSize},
+	{"gofmt", checkGofmt},
+}
+
+// CheckNames lists every check, comma separated, for flag help
+func CheckNames() string {
+	names := make([]string, len(checks))
+	for i, c := range checks {
+		names[i] = c.name
+	}
+	return strings.Join(names, ",")
+}
+
+func isMarkdown(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".md" || ext == ".markdown"
+}
+
+func isImage(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".webp", ".svg":
+		return true
+	}
+	return false
+}
+
+// fenceLine opens or closes a fenced code block
+var fenceLine = regexp.MustCompile("^ {0,3}(```|~~~)")
+
+// headingLine is an ATX heading; a hash directly followed by text is
+// reported as a missing space
+var headingLine = regexp.MustCompile(`^ {0,3}(#{1,6})(\s|$)`)
+var badHeading = regexp.MustCompile(`^ {0,3}#{1,6}[^#\s]`)
+
+// lintMarkdown reports whitespace and structure problems. The whitespace
+// ones, trailing spaces other than a two-space line break, repeated blank
+// lines and a missing final newline, are fixed by markdown.Tidy
+func lintMarkdown(f file, _ options) ([]Violation, []byte) {
+	if !isMarkdown(f.path) {
+		return nil, nil
+	}
+	report := func(line int, msg string, fixable bool) Violation {
+		return Violation{Path: f.path, Line: line, Check: "lint", Message: msg, fixable: fixable}
+	}
+
+	text := string(f.data)
+	lines := strings.Split(text, "\n")
+	var violations []Violation
+	inFence, fenceStart, lastLevel, blank := false, 0, 0, 0
+	for i, line := range lines {
+		n := i + 1
+		if fenceLine.MatchString(line) {
+			if !inFence {
+				fenceStart = n
+			}
+			inFence = !inFence
+		}
+		if inFence {
+			blank = 0
+			continue
+		}
+
+		if trimmed := strings.TrimRight(line, " \t"); trimmed != line && line[len(trimmed):] != "  " {
+			violations = append(violations, report(n, "trailing whitespace", true))
+			line = trimmed
+		}
+		if line == "" && i < len(lines)-1 {
+			if blank++; blank == 2 {
+				violations = append(violations, report(n, "multiple blank lines", true))
+			}
+			continue
+		}
+		blank = 0
+
+		if m := headingLine.FindStringSubmatch(line); m != nil {
+			level := len(m[1])
+			if lastLevel > 0 && level > lastLevel+1 {
+				violations = append(violations, report(n, fmt.Sprintf("heading jumps from level %d to %d", lastLevel, level), false))
+			}
+			lastLevel = level
+		} else if badHeading.MatchString(line) {
+			violations = append(violations, report(n, "heading needs a space after the #", false))
+		}
+	}
+	if inFence {
+		violations = append(violations, report(fenceStart, "code fence is never closed", false))
+	}
+	if len(text) > 0 && !strings.HasSuffix(text, "\n") {
+		violations = append(violations, report(len(lines), "no newline at end of file", true))
+	}
+
+	fixed, err := io.ReadAll(markdown.Tidy(bytes.NewReader(f.data)))
+	if err != nil || bytes.Equal(fixed, f.data) {
+		return violations, nil
+	}
+	return violations, fixed
+}
+
+// checkLinks reports relative links and images that point at files that do
+// not exist. URLs, anchors and site-absolute paths are not checked
+func checkLinks(f file, opts options) ([]Violation, []byte) {
+	if !isMarkdown(f.path) {
+		return nil, nil
+	}
+	var violations []Violation
+	for _, l := range markdown.Links(string(f.data)) {
+		target := strings.Trim(l.Target, "<>")
+		if target == "" || strings.HasPrefix(target, "#") || strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+			continue
+		}
+		if u, err := url.Parse(target); err != nil || u.Scheme != "" {
+			continue
+		}
+		target, _, _ = strings.Cut(target, "#")
+		target, _, _ = strings.Cut(target, "?")
+		if unescaped, err := url.PathUnescape(target); err == nil {
+			target = unescaped
+		}
+		path := filepath.Join(opts.root, filepath.Dir(filepath.FromSlash(f.path)), filepath.FromSlash(target))
+		if _, err := os.Stat(path); err != nil {
+			violations = append(violations, Violation{Path: f.path, Line: l.Line, Check: "links", Message: fmt.Sprintf("broken link to %s", l.Target)})
+		}
+	}
+	return violations, nil
+}
+
+// checkImageSize reports images larger than the budget
+func checkImageSize(f file, opts options) ([]Violation, []byte) {
+	if !isImage(f.path) || opts.maxImageSize <= 0 || int64(len(f.data)) <= opts.maxImageSize {
+		return nil, nil
+	}
+	msg := fmt.Sprintf("image is %s, over the %s budget; try goodness img convert -target-size %s", fsutil.FormatBytes(int64(len(f.data))), fsutil.FormatBytes(opts.maxImageSize), fsutil.FormatBytes(opts.maxImageSize))
+	return []Violation{{Path: f.path, Check: "size", Message: msg}}, nil
+}
+
+// goFence opens a fenced Go code block
+var goFence = regexp.MustCompile("^ {0,3}(```|~~~)\\s*(go|golang)\\s*$")
+
+// checkGofmt formats Go files and the Go code blocks of markdown files.
+// Blocks that do not parse, such as fragments with elisions, are left alone
+func checkGofmt(f file, _ options) ([]Violation, []byte) {
+	if strings.HasSuffix(f.path, ".go") {
+		formatted, err := format.Source(f.data)
+		if err != nil || string(formatted) == string(f.data) {
+			return nil, nil
+		}
+		return []Violation{{Path: f.path, Check: "gofmt", Message: "not gofmt-formatted", fixable: true}}, formatted
+	}
+	if !isMarkdown(f.path) {
+		return nil, nil
+	}
+
+	lines := strings.Split(string(f.data), "\n")
+	var violations []Violation
+	changed := false
+	for i := 0; i < len(lines); i++ {
+		m := goFence.FindStringSubmatch(lines[i])
+		if m == nil {
+			continue
+		}
+		end := i + 1
+		for end < len(lines) && !strings.HasPrefix(strings.TrimLeft(lines[end], " "), m[1]) {
+			end++
+		}
+		if end == len(lines) {
+			break
+		}
+		block := strings.Join(lines[i+1:end], "\n") + "\n"
+		formatted, err := format.Source([]byte(block))
+		if err == nil && string(formatted) != block {
+			violations = append(violations, Violation{Path: f.path, Line: i + 1, Check: "gofmt", Message: "Go code block is not gofmt-formatted", fixable: true})
+			replacement := strings.Split(strings.TrimSuffix(string(formatted), "\n"), "\n")
+			lines = append(lines[:i+1], append(replacement, lines[end:]...)...)
+			end = i + 1 + len(replacement)
+			changed = true
+		}
+		i = end
+	}
+	if !changed {
+		return violations, nil
+	}
+	return violations, []byte(strings.Join(lines, "\n"))
+}
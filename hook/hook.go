@@ -0,0 +1,305 @@
+// Package hook implements goodness hook, a git pre-commit hook that checks
+// the markdown and image files being committed, fixes what it can, restages
+// the fixes and fails the commit on anything left
+package hook
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+)
+
+// marker identifies a pre-commit script written by -install
+const marker = "# Installed by goodness hook -install"
+
+// options are the settings the checks share
+type options struct {
+	root         string
+	maxImageSize int64
+}
+
+// Run is the hook command
+func Run(args []string) {
+	fs := flag.NewFlagSet("hook", flag.ExitOnError)
+	logging.AddFlags(fs)
+	only := fs.String("checks", CheckNames(), "comma-separated checks to run: "+CheckNames())
+	fix := fs.Bool("fix", true, "fix what can be fixed and restage the file")
+	maxImageSize := fs.String("max-image-size", "1MB", "size budget for staged images, such as 500KB; 0 for none")
+	all := fs.Bool("all", false, "check every tracked file rather than the staged ones, reading the working tree")
+	install := fs.Bool("install", false, "write a pre-commit hook that runs goodness hook and exit")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness hook [flags]\n\nChecks the markdown, image and Go files staged for commit. Whitespace in\nmarkdown and Go formatting are fixed and restaged unless the file also has\nunstaged changes; anything else fails the commit. Set defaults under hook:\nin the configuration file.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() > 0 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	selected, err := selectChecks(*only)
+	if err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid -checks value %q: %s", *only, err)
+	}
+	opts := options{}
+	if opts.maxImageSize, err = fsutil.ParseByteSize(*maxImageSize); err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid -max-image-size value %q", *maxImageSize)
+	}
+	if opts.root, err = git("", "rev-parse", "--show-toplevel"); err != nil {
+		logging.Exitf(exitcode.Usage, "Not in a git repository: %s", err)
+	}
+	opts.root = strings.TrimSpace(opts.root)
+
+	if *install {
+		path, err := installHook(opts.root)
+		if err != nil {
+			logging.Fatalf("Failed to install the pre-commit hook: %s", err)
+		}
+		output.Wrote(path)
+		fmt.Printf("Pre-commit hook written: %s\n", path)
+		return
+	}
+
+	files, err := candidates(opts.root, *all)
+	if err != nil {
+		logging.Fatalf("Failed to list files: %s", err)
+	}
+	if len(files) == 0 {
+		logging.Infof("No markdown, image or Go files to check")
+		output.Exit(exitcode.NoMatch)
+	}
+	var partial map[string]bool
+	if !*all {
+		if partial, err = unstaged(opts.root); err != nil {
+			logging.Fatalf("Failed to list unstaged changes: %s", err)
+		}
+	}
+
+	var remaining []Violation
+	fixedCount := 0
+	for _, path := range files {
+		f, err := read(opts.root, path, *all)
+		if err != nil {
+			logging.Errorf("Failed to read %s: %s", path, err)
+			output.Processed(path, "failed", "read", err)
+			remaining = append(remaining, Violation{Path: path, Check: "read", Message: err.Error()})
+			continue
+		}
+
+		var found, fixed []Violation
+		for _, c := range selected {
+			violations, content := c.run(f, opts)
+			apply := content != nil && *fix && !partial[path]
+			if apply {
+				f.data = content
+			}
+			for _, v := range violations {
+				if apply && v.fixable {
+					fixed = append(fixed, v)
+				} else {
+					found = append(found, v)
+				}
+			}
+		}
+		if len(fixed) > 0 {
+			if err := restage(opts.root, f, !*all); err != nil {
+				logging.Errorf("Failed to fix %s: %s", path, err)
+				found = append(found, fixed...)
+				fixed = nil
+			} else {
+				fixedCount++
+				output.Wrote(path)
+				logging.Infof("Fixed %s: %s", path, describe(fixed))
+			}
+		} else if partial[path] {
+			for _, v := range found {
+				if v.fixable {
+					logging.Warnf("Not fixing %s: it has unstaged changes", path)
+					break
+				}
+			}
+		}
+
+		for _, v := range found {
+			fmt.Println(v)
+			output.Annotate("error", v.Path, v.Line, "goodness hook "+v.Check, v.Message)
+		}
+		remaining = append(remaining, found...)
+		switch {
+		case len(found) > 0:
+			output.Processed(path, "failed", found[0].Check, errors.New(describe(found)))
+		case len(fixed) > 0:
+			output.Processed(path, "fixed", "", nil)
+		default:
+			output.Processed(path, "ok", "", nil)
+		}
+	}
+
+	output.Set("violations", append([]Violation{}, remaining...))
+	if len(remaining) > 0 {
+		fmt.Printf("%d problems in %d files checked; %d files fixed\n", len(remaining), len(files), fixedCount)
+		output.Exit(exitcode.Failures)
+	}
+	fmt.Printf("%d files checked; %d files fixed\n", len(files), fixedCount)
+}
+
+// selectChecks resolves -checks to the checks to run
+func selectChecks(list string) ([]check, error) {
+	want := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			want[name] = true
+		}
+	}
+	var selected []check
+	for _, c := range checks {
+		if want[c.name] {
+			selected = append(selected, c)
+			delete(want, c.name)
+		}
+	}
+	for name := range want {
+		return nil, fmt.Errorf("unknown check %s", name)
+	}
+	if len(selected) == 0 {
+		return nil, errors.New("no checks")
+	}
+	return selected, nil
+}
+
+// describe summarises violations as their checks and messages
+func describe(violations []Violation) string {
+	parts := make([]string, len(violations))
+	for i, v := range violations {
+		parts[i] = v.Check + ": " + v.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// candidates lists the files to check: staged additions and modifications,
+// or every tracked file with all
+func candidates(root string, all bool) ([]string, error) {
+	args := []string{"diff", "--cached", "--name-only", "--diff-filter=ACMR", "-z"}
+	if all {
+		args = []string{"ls-files", "-z"}
+	}
+	out, err := git(root, args...)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, path := range strings.Split(out, "\x00") {
+		if path != "" && (isMarkdown(path) || isImage(path) || strings.HasSuffix(path, ".go")) {
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// unstaged lists files whose working copy differs from the index; fixing
+// them would stage changes the author left out of the commit
+func unstaged(root string) (map[string]bool, error) {
+	out, err := git(root, "diff", "--name-only", "-z")
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]bool)
+	for _, path := range strings.Split(out, "\x00") {
+		if path != "" {
+			paths[path] = true
+		}
+	}
+	return paths, nil
+}
+
+// read returns the content being committed: the staged blob, or the working
+// copy with all
+func read(root, path string, all bool) (file, error) {
+	if all {
+		data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(path)))
+		return file{path: path, data: data}, err
+	}
+	cmd := exec.Command("git", "show", ":"+path)
+	cmd.Dir = root
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	data, err := cmd.Output()
+	if err != nil {
+		return file{}, gitError(err, &stderr)
+	}
+	return file{path: path, data: data}, nil
+}
+
+// restage writes fixed content to the working copy and, for a commit,
+// stages it again
+func restage(root string, f file, stage bool) error {
+	path := filepath.Join(root, filepath.FromSlash(f.path))
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := journal.WriteFile(path, f.data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if !stage {
+		return nil
+	}
+	_, err = git(root, "add", "--", f.path)
+	return err
+}
+
+// installHook writes the pre-commit script, refusing to replace one that
+// -install did not write
+func installHook(root string) (string, error) {
+	dir, err := git(root, "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return "", err
+	}
+	dir = strings.TrimSpace(dir)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	path := filepath.Join(dir, "pre-commit")
+	if existing, err := os.ReadFile(path); err == nil && !bytes.Contains(existing, []byte(marker)) {
+		return "", fmt.Errorf("%s already exists; add goodness hook to it instead", path)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	script := "#!/bin/sh\n" + marker + "\nexec goodness hook\n"
+	return path, fsutil.WriteFileAtomic(path, []byte(script), 0o755)
+}
+
+// git runs a git command in dir and returns its output
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", gitError(err, &stderr)
+	}
+	return string(out), nil
+}
+
+func gitError(err error, stderr *bytes.Buffer) error {
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return errors.New(msg)
+	}
+	return err
+}
@@ -0,0 +1,327 @@
+// Package cache remembers what the batch commands made from each input,
+// keyed by a hash of the input's content and of the options that shape its
+// outputs, along with its path, since outputs are usually named after
+// their input and an identical copy elsewhere still needs its own. A repeat
+// run looks each input up and skips it when a previous run made its outputs
+// with the same options and the outputs are still as that run left them,
+// so only new and changed files are processed again.
+//
+// Content hashes are remembered by path, size and modification time, so
+// unchanged files are not even read. Everything is kept in one file under
+// the user cache folder, written when a command finishes; entries unused
+// for maxAge are dropped then
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"GoodnessucWorkflow/internal/fsutil"
+)
+
+// maxAge is how long an entry is kept without being used
+const maxAge = 90 * 24 * time.Hour
+
+// fileVersion changes when the file's layout does; older files are dropped
+const fileVersion = 1
+
+// stamp identifies a version of a file without reading it
+type stamp struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"mod_time"`
+}
+
+func stampOf(info os.FileInfo) stamp {
+	return stamp{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+}
+
+// sum is the content hash of a file as it was at its stamp
+type sum struct {
+	stamp
+	SHA256 string `json:"sha256"`
+	Used   int64  `json:"used"`
+}
+
+// output is a file a run wrote, as it was just after
+type output struct {
+	Path string `json:"path"`
+	stamp
+}
+
+// entry is what a run made from one input
+type entry struct {
+	Outputs []output        `json:"outputs,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Used    int64           `json:"used"`
+}
+
+type contents struct {
+	Version int              `json:"version"`
+	Sums    map[string]sum   `json:"sums"`
+	Entries map[string]entry `json:"entries"`
+}
+
+// Cache is the cache of one process. A nil Cache is a cache that is off:
+// nothing is found and nothing is kept
+type Cache struct {
+	path string
+
+	mu       sync.Mutex
+	contents contents
+	// changed are the sums and entries this process added, merged into the
+	// file as it is on disk when saved
+	changedSums    map[string]bool
+	changedEntries map[string]bool
+}
+
+// Hit is what a previous run made from an input
+type Hit struct {
+	// Outputs are the files it wrote, in the order they were stored
+	Outputs []string
+	data    json.RawMessage
+}
+
+// Decode unmarshals the data stored with the outputs into v
+func (h *Hit) Decode(v any) error {
+	if h.data == nil {
+		return fmt.Errorf("no data stored")
+	}
+	return json.Unmarshal(h.data, v)
+}
+
+// Path returns the file the cache is kept in: goodness/inputs.json under
+// the user cache folder
+func Path() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "goodness", "inputs.json"), nil
+}
+
+// Open loads the cache, or returns nil when enabled is false. A missing or
+// unreadable file starts an empty cache
+func Open(enabled bool) (*Cache, error) {
+	if !enabled {
+		return nil, nil
+	}
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	c := &Cache{path: path, contents: load(path), changedSums: make(map[string]bool), changedEntries: make(map[string]bool)}
+	return c, nil
+}
+
+func load(path string) contents {
+	var c contents
+	if data, err := os.ReadFile(path); err == nil {
+		if json.Unmarshal(data, &c) != nil || c.Version != fileVersion {
+			c = contents{}
+		}
+	}
+	c.Version = fileVersion
+	if c.Sums == nil {
+		c.Sums = make(map[string]sum)
+	}
+	if c.Entries == nil {
+		c.Entries = make(map[string]entry)
+	}
+	return c
+}
+
+// AddFlag adds the -cache flag that turns the cache on and off
+func AddFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("cache", true, "skip inputs whose content and options match an earlier run whose outputs are unchanged; -cache=false processes everything")
+}
+
+// Options hashes the values that shape a command's outputs, which must
+// marshal to JSON, into a string for Lookup
+func Options(command string, values ...any) string {
+	data, err := json.Marshal(append([]any{command}, values...))
+	if err != nil {
+		// Options that cannot be hashed never match
+		return ""
+	}
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// Flags returns the values of fs's flags but those named in skip, for
+// Options; skip lists the flags that do not change what is written, such
+// as worker counts and log levels
+func Flags(fs *flag.FlagSet, skip ...string) map[string]string {
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		if !slices.Contains(skip, f.Name) {
+			values[f.Name] = f.Value.String()
+		}
+	})
+	return values
+}
+
+// Lookup hashes the content of path and finds what an earlier run made
+// from it, at the same path, with options. It returns the key to Store the
+// run's outputs under, and the earlier outputs when they are all still as
+// that run left them. An input that cannot be read gets an empty key
+func (c *Cache) Lookup(path, options string) (string, *Hit) {
+	if c == nil || options == "" {
+		return "", nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil
+	}
+	contentSum, err := c.sum(abs)
+	if err != nil {
+		return "", nil
+	}
+	h := sha256.Sum256([]byte(contentSum + "\x00" + abs + "\x00" + options))
+	key := hex.EncodeToString(h[:])
+
+	c.mu.Lock()
+	e, ok := c.contents.Entries[key]
+	c.mu.Unlock()
+	if !ok {
+		return key, nil
+	}
+	hit := &Hit{data: e.Data}
+	for _, out := range e.Outputs {
+		info, err := os.Stat(out.Path)
+		if err != nil || stampOf(info) != out.stamp {
+			return key, nil
+		}
+		hit.Outputs = append(hit.Outputs, out.Path)
+	}
+
+	c.mu.Lock()
+	e.Used = time.Now().Unix()
+	c.contents.Entries[key] = e
+	c.changedEntries[key] = true
+	c.mu.Unlock()
+	return key, hit
+}
+
+// sum returns the content hash of the file at abs, reading it only when it
+// changed since it was last hashed
+func (c *Cache) sum(abs string) (string, error) {
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	now := stampOf(info)
+
+	c.mu.Lock()
+	known, ok := c.contents.Sums[abs]
+	c.mu.Unlock()
+	if !ok || known.stamp != now {
+		f, err := os.Open(abs)
+		if err != nil {
+			return "", err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		known = sum{stamp: now, SHA256: hex.EncodeToString(h.Sum(nil))}
+	}
+
+	c.mu.Lock()
+	known.Used = time.Now().Unix()
+	c.contents.Sums[abs] = known
+	c.changedSums[abs] = true
+	c.mu.Unlock()
+	return known.SHA256, nil
+}
+
+// Store records that the input looked up as key made outputs, along with
+// data for the next run's Hit, which must marshal to JSON. An input that
+// made nothing, such as one a command decided to leave alone, is stored
+// with no outputs
+func (c *Cache) Store(key string, outputs []string, data any) {
+	if c == nil || key == "" {
+		return
+	}
+	e := entry{Used: time.Now().Unix()}
+	for _, out := range outputs {
+		abs, err := filepath.Abs(out)
+		if err != nil {
+			return
+		}
+		info, err := os.Stat(abs)
+		if err != nil {
+			return
+		}
+		e.Outputs = append(e.Outputs, output{Path: abs, stamp: stampOf(info)})
+	}
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return
+		}
+		e.Data = raw
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.contents.Entries[key] = e
+	c.changedEntries[key] = true
+}
+
+// Save writes what this process added to the cache file, merged with what
+// other processes wrote since it was opened
+func (c *Cache) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.changedSums) == 0 && len(c.changedEntries) == 0 {
+		return nil
+	}
+
+	merged := load(c.path)
+	for path := range c.changedSums {
+		merged.Sums[path] = c.contents.Sums[path]
+	}
+	for key := range c.changedEntries {
+		merged.Entries[key] = c.contents.Entries[key]
+	}
+	oldest := time.Now().Add(-maxAge).Unix()
+	for path, s := range merged.Sums {
+		if s.Used < oldest {
+			delete(merged.Sums, path)
+		}
+	}
+	for key, e := range merged.Entries {
+		if e.Used < oldest {
+			delete(merged.Entries, key)
+		}
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(c.path, data, 0644); err != nil {
+		return err
+	}
+	c.contents = merged
+	clear(c.changedSums)
+	clear(c.changedEntries)
+	return nil
+}
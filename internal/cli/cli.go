@@ -0,0 +1,189 @@
+// Package cli dispatches the goodness subcommands and prints their help in
+// one format, so every tool added to the binary reads the same way
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"GoodnessucWorkflow/internal/config"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/internal/stats"
+	"GoodnessucWorkflow/internal/storage"
+)
+
+// current is the path of the command being run below the program name,
+// such as img convert, which selects its configuration section
+var current []string
+
+// Command is one subcommand. Run receives the arguments after its name
+type Command struct {
+	Name    string
+	Summary string
+	Run     func(args []string)
+	// Commands are the subcommands of a group, which Run dispatches to;
+	// completion walks them
+	Commands []Command
+	// Complete lists candidates for the command's arguments, such as
+	// workflow names, for completion
+	Complete func() []string
+}
+
+// Find returns the command called name
+func Find(commands []Command, name string) (Command, bool) {
+	for _, c := range commands {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Command{}, false
+}
+
+// IsHelp reports whether arg asks for help rather than naming a command
+func IsHelp(arg string) bool {
+	switch arg {
+	case "help", "-h", "-help", "--help":
+		return true
+	}
+	return false
+}
+
+// Dispatch runs the command named by args[0]. prog is how the caller is
+// invoked, such as "goodness img", and prefixes the help text. No arguments
+// or a request for help print the list of commands
+func Dispatch(prog string, commands []Command, args []string) {
+	if len(args) == 0 {
+		Usage(os.Stderr, prog, commands)
+		os.Exit(exitcode.Usage)
+	}
+	group := strings.Fields(prog)[1:]
+	if IsHelp(args[0]) {
+		if len(args) > 1 {
+			if c, ok := Find(commands, args[1]); ok {
+				current = append(group, c.Name)
+				c.Run([]string{"-h"})
+				return
+			}
+		}
+		Usage(os.Stdout, prog, commands)
+		return
+	}
+
+	c, ok := Find(commands, args[0])
+	if !ok {
+		logging.Exitf(exitcode.Usage, "Unknown command %q; run '%s help' for a list", args[0], prog)
+	}
+	current = append(group, c.Name)
+	output.SetCommand(strings.Join(current, " "))
+	stats.Command(strings.Join(current, " "))
+	c.Run(args[1:])
+}
+
+// Parse sets the flags of fs from the configuration files and environment
+// for the running command, then parses args over them. Remote locations
+// among the arguments and in -o or -out are staged in local directories,
+// and what the command writes there is uploaded as it exits
+func Parse(fs *flag.FlagSet, args []string) {
+	parse(fs, args)
+	staged, ok, err := storage.Stage(fs, fs.Args())
+	if err != nil {
+		logging.Fatalf("Failed to download: %s", err)
+	}
+	if !ok {
+		return
+	}
+	UploadOnExit()
+	fs.Parse(append([]string{"--"}, staged...))
+}
+
+// UploadOnExit uploads what the command wrote to the staged folders as it
+// exits, unless it exits with an error
+func UploadOnExit() {
+	output.BeforeExit(func(code int) int {
+		if code != exitcode.OK && code != exitcode.Failures {
+			storage.Cleanup()
+			return code
+		}
+		if err := storage.Flush(); err != nil {
+			logging.Errorf("Failed to upload: %s", err)
+			return exitcode.Error
+		}
+		return code
+	})
+}
+
+func parse(fs *flag.FlagSet, args []string) {
+	if completing != nil {
+		completing(fs)
+		return
+	}
+	if err := config.Apply(fs, current); err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid configuration: %s", err)
+	}
+	// Help is printed in the language -lang picked, which is only known
+	// once the flags before -h are parsed
+	usage := fs.Usage
+	fs.Usage = func() {
+		fs.VisitAll(func(f *flag.Flag) {
+			f.Usage = i18n.T(f.Usage)
+		})
+		usage()
+	}
+	fs.Parse(args)
+}
+
+// Usage lists commands with their summaries
+func Usage(w io.Writer, prog string, commands []Command) {
+	fmt.Fprint(w, i18n.Sprintf("Usage: %s <command> [flags] [arguments]\n\nCommands:\n", prog))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.Name, i18n.T(c.Summary))
+	}
+	tw.Flush()
+	fmt.Fprint(w, i18n.Sprintf("\nRun '%s help <command>' for the flags of a command.\n", prog))
+}
+
+// Main parses the flags every command shares, such as -verbose, and then
+// dispatches the remaining arguments
+func Main(prog string, commands []Command, args []string) {
+	fs := flag.NewFlagSet(prog, flag.ExitOnError)
+	logging.AddFlags(fs)
+	fs.Usage = func() {
+		Usage(fs.Output(), prog, commands)
+		fmt.Fprint(fs.Output(), i18n.T("\nFlags shared by every command, also accepted before it:\n"))
+		fs.PrintDefaults()
+		fmt.Fprint(fs.Output(), i18n.Sprintf("\nFlags also take defaults from %s, ~/.config/goodness/config.yaml\nand GOODNESS_* environment variables.\n", config.LocalFileName))
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	if len(args) > 0 && args[0] == CompleteCommand {
+		Complete(commands, fs, args[1:])
+		return
+	}
+	// The arguments are the command's, which stages them itself
+	parse(fs, args)
+
+	switch {
+	case fs.NArg() == 0:
+		fs.Usage()
+		os.Exit(exitcode.Usage)
+	case fs.NArg() == 1 && IsHelp(fs.Arg(0)):
+		fs.SetOutput(os.Stdout)
+		fs.Usage()
+		return
+	}
+	output.BeforeExit(func(code int) int {
+		if err := stats.Flush(); err != nil {
+			logging.Debugf("Failed to record usage statistics: %s", err)
+		}
+		return code
+	})
+	Dispatch(prog, commands, fs.Args())
+	output.Exit(exitcode.OK)
+}
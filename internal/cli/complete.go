@@ -0,0 +1,238 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+)
+
+// CompleteCommand is the hidden command the completion scripts call with
+// the words typed so far, the last being the one to complete
+const CompleteCommand = "__complete"
+
+// completing, when set, receives the flags of the command being completed
+// from Parse instead of parsing them
+var completing func(fs *flag.FlagSet)
+
+// valueList matches the alternatives at the end of a flag's usage, such as
+// "image host: imgur or cloudinary" or "as jpeg, png, gif or webp", with an
+// optional note in parentheses after each
+var valueList = regexp.MustCompile(`(?:: | as )((?:[a-z0-9-]+(?: \([^)]*\))?, )*[a-z0-9-]+(?: \([^)]*\))?,? or [a-z0-9-]+(?: \([^)]*\))?)$`)
+
+// valueNote matches the note after an alternative
+var valueNote = regexp.MustCompile(` \([^)]*\)`)
+
+// flagValues returns the values usage lists for a flag, if any
+func flagValues(usage string) []string {
+	m := valueList.FindStringSubmatch(usage)
+	if m == nil {
+		return nil
+	}
+	list := strings.NewReplacer(", or ", ", ", " or ", ", ").Replace(valueNote.ReplaceAllString(m[1], ""))
+	return strings.Split(list, ", ")
+}
+
+// Complete prints the candidates for the last of words, one per line as
+// the candidate and a description separated by a tab. words are the
+// arguments after the program name, so the last one may be empty
+func Complete(commands []Command, global *flag.FlagSet, words []string) {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur, typed := words[len(words)-1], words[:len(words)-1]
+	if cur == `""` {
+		// PowerShell cannot pass an empty argument to a native program
+		cur = ""
+	}
+
+	// Find the deepest command named so far; flags and their values are
+	// skipped, and so are words after a command that takes arguments
+	var leaf *Command
+	list := commands
+	for _, w := range typed {
+		if strings.HasPrefix(w, "-") || list == nil || IsHelp(w) {
+			continue
+		}
+		c, ok := Find(list, w)
+		if !ok {
+			if leaf == nil || leaf.Commands != nil {
+				return
+			}
+			continue
+		}
+		leaf, list = &c, c.Commands
+	}
+
+	emit := func(candidate, description string) {
+		if strings.HasPrefix(candidate, cur) {
+			fmt.Printf("%s\t%s\n", candidate, description)
+		}
+	}
+
+	candidates := func(fs *flag.FlagSet) {
+		if f, prefix := valueFlag(fs, typed, cur); f != nil {
+			for _, v := range flagValues(f.Usage) {
+				emit(prefix+v, "")
+			}
+			return
+		}
+		switch {
+		case strings.HasPrefix(cur, "-"):
+			fs.VisitAll(func(f *flag.Flag) {
+				emit("-"+f.Name, f.Usage)
+			})
+		case leaf == nil || leaf.Commands != nil:
+			for _, c := range list {
+				emit(c.Name, c.Summary)
+			}
+			emit("help", "show the commands or the flags of one")
+		case leaf.Complete != nil:
+			for _, candidate := range leaf.Complete() {
+				emit(candidate, "")
+			}
+		}
+	}
+
+	// Groups take the shared flags; other commands hand their own to Parse
+	// when run, which passes them to completing and exits
+	if leaf == nil || leaf.Commands != nil {
+		candidates(global)
+		return
+	}
+	completing = func(fs *flag.FlagSet) {
+		candidates(fs)
+		os.Exit(exitcode.OK)
+	}
+	leaf.Run(nil)
+}
+
+// valueFlag returns the flag of fs whose value cur is, given as -flag=value
+// or as the word after -flag, with the part of cur before the value
+func valueFlag(fs *flag.FlagSet, typed []string, cur string) (*flag.Flag, string) {
+	var name, prefix string
+	switch {
+	case strings.HasPrefix(cur, "-") && strings.Contains(cur, "="):
+		prefix = cur[:strings.Index(cur, "=")+1]
+		name = strings.TrimLeft(prefix[:len(prefix)-1], "-")
+	case len(typed) > 1 && typed[len(typed)-1] == "=":
+		// bash splits -flag=value into three words
+		name = strings.TrimLeft(typed[len(typed)-2], "-")
+	case len(typed) > 0 && strings.HasPrefix(typed[len(typed)-1], "-") && !strings.Contains(typed[len(typed)-1], "="):
+		name = strings.TrimLeft(typed[len(typed)-1], "-")
+	default:
+		return nil, ""
+	}
+	f := fs.Lookup(name)
+	if f == nil || isBool(f) {
+		return nil, ""
+	}
+	return f, prefix
+}
+
+// isBool reports whether f is a switch that takes no value
+func isBool(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// Completion returns the completion command of prog, which prints the
+// script for a shell
+func Completion(prog string) func(args []string) {
+	return func(args []string) {
+		fs := flag.NewFlagSet("completion", flag.ExitOnError)
+		logging.AddFlags(fs)
+		fs.Usage = func() {
+			fmt.Fprint(fs.Output(), i18n.Sprintf("Usage: %s completion bash|zsh|fish|powershell\n\nPrints a script that completes commands, flags, their values and workflow\nnames. For example:\n\n", prog))
+			fmt.Fprintf(fs.Output(), "  source <(%[1]s completion bash)                  # in ~/.bashrc\n  %[1]s completion zsh > \"${fpath[1]}/_%[1]s\"\n  %[1]s completion fish > ~/.config/fish/completions/%[1]s.fish\n  %[1]s completion powershell | Out-String | Invoke-Expression\n\n", prog)
+			fs.PrintDefaults()
+			fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+		}
+		Parse(fs, args)
+
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(exitcode.Usage)
+		}
+		script, ok := completionScripts[fs.Arg(0)]
+		if !ok {
+			logging.Exitf(exitcode.Usage, "Unknown shell %q; expected bash, zsh, fish or powershell", fs.Arg(0))
+		}
+		fmt.Print(strings.NewReplacer("PROG", prog, "__COMPLETE", CompleteCommand).Replace(script))
+	}
+}
+
+// completionScripts ask the program for candidates through CompleteCommand
+// and fall back to file names when it has none
+var completionScripts = map[string]string{
+	"bash": `# bash completion for PROG
+_PROG() {
+    local IFS=$'\n'
+    local candidates
+    candidates=$(PROG __COMPLETE "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null | cut -f1)
+    COMPREPLY=($(compgen -W "$candidates" -- "${COMP_WORDS[COMP_CWORD]}"))
+    if [ ${#COMPREPLY[@]} -eq 0 ]; then
+        COMPREPLY=($(compgen -f -- "${COMP_WORDS[COMP_CWORD]}"))
+    fi
+}
+complete -o filenames -F _PROG PROG
+`,
+	"zsh": `#compdef PROG
+# zsh completion for PROG
+_PROG() {
+    local -a lines candidates
+    local line
+    lines=("${(@f)$(PROG __COMPLETE "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    for line in $lines; do
+        [[ -n $line ]] || continue
+        candidates+=("${${line%%$'\t'*}//:/\\:}:${line#*$'\t'}")
+    done
+    if (( ${#candidates} )); then
+        _describe PROG candidates
+    else
+        _files
+    fi
+}
+if [ "$funcstack[1]" = "_PROG" ]; then
+    _PROG "$@"
+else
+    compdef _PROG PROG
+fi
+`,
+	"fish": `# fish completion for PROG
+function __PROG_complete
+    set -l tokens (commandline -opc) (commandline -ct)
+    set -l candidates (PROG __COMPLETE $tokens[2..-1] 2>/dev/null)
+    if test (count $candidates) -gt 0
+        printf '%s\n' $candidates
+    else
+        __fish_complete_path (commandline -ct)
+    end
+end
+complete -c PROG -f -a '(__PROG_complete)'
+`,
+	"powershell": `# powershell completion for PROG
+Register-ArgumentCompleter -Native -CommandName PROG -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $words = @($commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() })
+    if ($wordToComplete -eq '') { $words += '""' }
+    $candidates = @(PROG __COMPLETE @words 2>$null)
+    if ($candidates.Count -eq 0) {
+        Get-ChildItem -Path "$wordToComplete*" | ForEach-Object {
+            [System.Management.Automation.CompletionResult]::new($_.Name, $_.Name, 'ProviderItem', $_.Name)
+        }
+        return
+    }
+    $candidates | ForEach-Object {
+        $name, $description = $_ -split "` + "`" + `t", 2
+        if (-not $description) { $description = $name }
+        [System.Management.Automation.CompletionResult]::new($name, $name, 'ParameterValue', $description)
+    }
+}
+`,
+}
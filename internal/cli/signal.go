@@ -0,0 +1,18 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// SignalContext returns a context that is done at the first SIGINT, or the
+// SIGTERM a container runtime sends before killing a job. Commands that work
+// through many files start no new ones once it is done and finish, or roll
+// back, those in progress. A second signal stops at once
+func SignalContext() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	return ctx
+}
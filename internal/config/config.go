@@ -0,0 +1,339 @@
+// Package config supplies flag defaults from configuration files and the
+// environment, so preferences follow a user across machines and projects.
+//
+// A global file at ~/.config/goodness/config.yaml and the nearest
+// .goodness.yaml in the working directory or above it set flags by command:
+//
+//	all:              # every command
+//	  log-format: json
+//	img:
+//	  convert:
+//	    workers: 4
+//	  rename:
+//	    by: exif
+//
+// Environment variables override both files: GOODNESS_<FLAG> for every
+// command and GOODNESS_<COMMAND>_<FLAG> for one, such as
+// GOODNESS_IMG_CONVERT_WORKERS. Each line of a variable's value sets the
+// flag once, as each item of a list does in the files, for flags that can
+// be repeated. Flags on the command line override all of them. From lowest
+// precedence to highest, a flag is set by:
+//
+//  1. its default
+//  2. the all section of the global file, then the command's section
+//  3. the all section of the project file, then the command's section
+//  4. GOODNESS_<FLAG>, then GOODNESS_<COMMAND>_<FLAG>
+//  5. the command line
+//
+// A workflows section names workflow files for goodness run, and
+// GOODNESS_WORKFLOWS_<NAME> adds or overrides one, relative to the working
+// directory:
+//
+//	workflows:
+//	  publish: ./publish.yaml
+//
+// A hosts section sets the rate limit and retries of the requests sent to
+// each host; see package httpclient. GOODNESS_HOSTS_<HOST>_<SETTING>
+// overrides one setting, with the host's dots and dashes written as
+// underscores and ALL for "*", such as GOODNESS_HOSTS_API_IMGUR_COM_RATE:
+//
+//	hosts:
+//	  api.imgur.com:
+//	    rate: 0.5
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LocalFileName is the project configuration file looked up from the
+// working directory towards the root
+const LocalFileName = ".goodness.yaml"
+
+// allSection holds flags applied to every command
+const allSection = "all"
+
+// workflowsSection maps workflow names to files
+const workflowsSection = "workflows"
+
+// hostsSection holds the request policy of each host
+const hostsSection = "hosts"
+
+// source is the settings read from one configuration file
+type source struct {
+	name   string
+	values map[string]any
+}
+
+var (
+	loadOnce sync.Once
+	sources  []source
+	loadErr  error
+)
+
+// GlobalPath returns the user's configuration file, under XDG_CONFIG_HOME
+// when it is set and ~/.config otherwise; Windows uses the roaming AppData
+// folder
+func GlobalPath() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "goodness", "config.yaml"), nil
+	}
+	if runtime.GOOS == "windows" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "goodness", "config.yaml"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "goodness", "config.yaml"), nil
+}
+
+// LocalPath returns the nearest project configuration file, or "" when there
+// is none between the working directory and the root
+func LocalPath() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, LocalFileName)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// load reads the configuration files once, lowest precedence first
+func load() ([]source, error) {
+	loadOnce.Do(func() {
+		var paths []string
+		if global, err := GlobalPath(); err == nil {
+			paths = append(paths, global)
+		}
+		if local := LocalPath(); local != "" {
+			paths = append(paths, local)
+		}
+
+		for _, path := range paths {
+			values, err := readFile(path)
+			if err != nil {
+				loadErr = err
+				return
+			}
+			if values != nil {
+				sources = append(sources, source{name: path, values: values})
+			}
+		}
+	})
+	return sources, loadErr
+}
+
+// readFile parses one configuration file; a missing file has no settings
+func readFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]any)
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&values); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return values, nil
+}
+
+// Apply sets the flags of fs from the configuration files and environment
+// for the command at path, such as img convert. It runs before fs.Parse so
+// flags given on the command line still win
+func Apply(fs *flag.FlagSet, path []string) error {
+	files, err := load()
+	if err != nil {
+		return err
+	}
+
+	// settings maps flag names to a value and where it came from, filled
+	// from the lowest precedence up
+	type setting struct {
+		value any
+		from  string
+	}
+	settings := make(map[string]setting)
+	for _, src := range files {
+		sections := []map[string]any{lookup(src.values, allSection)}
+		if len(path) > 0 {
+			sections = append(sections, lookup(src.values, path...))
+		}
+		for _, section := range sections {
+			for name, value := range section {
+				if _, nested := value.(map[string]any); !nested {
+					settings[name] = setting{value, src.name}
+				}
+			}
+		}
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		keys := []string{envKey(nil, f.Name)}
+		if len(path) > 0 {
+			keys = append(keys, envKey(path, f.Name))
+		}
+		for _, key := range keys {
+			if value, ok := os.LookupEnv(key); ok {
+				settings[f.Name] = setting{value, key}
+			}
+		}
+	})
+
+	for name, s := range settings {
+		if fs.Lookup(name) == nil {
+			continue
+		}
+		values := []any{s.value}
+		switch v := s.value.(type) {
+		case []any:
+			values = v
+		case string:
+			if strings.Contains(v, "\n") {
+				values = values[:0]
+				for _, line := range strings.Split(strings.TrimRight(v, "\n"), "\n") {
+					values = append(values, line)
+				}
+			}
+		}
+		for _, value := range values {
+			if err := fs.Set(name, fmt.Sprint(value)); err != nil {
+				return fmt.Errorf("%s: invalid value %v for %s: %s", s.from, value, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Workflows returns the workflow files named in the configuration files and
+// the environment, by name. Relative paths are resolved against the
+// directory of the file that names them, or the working directory for the
+// environment, and the environment wins over the project file, which wins
+// over the global one
+func Workflows() (map[string]string, error) {
+	files, err := load()
+	if err != nil {
+		return nil, err
+	}
+	workflows := make(map[string]string)
+	for _, src := range files {
+		section, ok := src.values[workflowsSection].(map[string]any)
+		if !ok {
+			continue
+		}
+		for name, value := range section {
+			path, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: workflows: %s: expected a file name", src.name, name)
+			}
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(filepath.Dir(src.name), path)
+			}
+			workflows[name] = path
+		}
+	}
+	for name, path := range environ(workflowsSection) {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		workflows[name] = path
+	}
+	return workflows, nil
+}
+
+// Host returns the settings of host, or of every host for "*", under hosts
+// in the configuration files and from GOODNESS_HOSTS_<HOST>_<SETTING>. The
+// environment's settings win over the project file's, which win over the
+// global file's, one by one
+func Host(host string) (map[string]any, error) {
+	files, err := load()
+	if err != nil {
+		return nil, err
+	}
+	settings := make(map[string]any)
+	for _, src := range files {
+		section, ok := src.values[hostsSection].(map[string]any)
+		if !ok {
+			continue
+		}
+		value, ok := section[host]
+		if !ok {
+			continue
+		}
+		values, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: hosts: %s: expected settings such as rate and retries", src.name, host)
+		}
+		maps.Copy(settings, values)
+	}
+	name := host
+	if host == "*" {
+		name = allSection
+	}
+	for setting, value := range environ(hostsSection, name) {
+		settings[setting] = value
+	}
+	return settings, nil
+}
+
+// lookup returns the nested section at keys, or nil
+func lookup(values map[string]any, keys ...string) map[string]any {
+	for _, key := range keys {
+		next, ok := values[key].(map[string]any)
+		if !ok {
+			return nil
+		}
+		values = next
+	}
+	return values
+}
+
+// environ returns the environment variables below GOODNESS_<PATH>_ by the
+// rest of their name, lowercased with underscores as dashes, such as
+// max-delay for GOODNESS_HOSTS_ALL_MAX_DELAY
+func environ(path ...string) map[string]string {
+	prefix := envKey(path[:len(path)-1], path[len(path)-1]) + "_"
+	found := make(map[string]string)
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		if name, ok := strings.CutPrefix(key, prefix); ok && name != "" {
+			found[strings.ReplaceAll(strings.ToLower(name), "_", "-")] = value
+		}
+	}
+	return found
+}
+
+// envKey is the environment variable for a flag of the command at path, or
+// of every command when path is nil
+func envKey(path []string, name string) string {
+	parts := append([]string{"GOODNESS"}, path...)
+	parts = append(parts, name)
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(strings.Join(parts, "_")))
+}
@@ -0,0 +1,25 @@
+// Package exitcode defines the exit statuses shared by the goodness
+// commands, so scripts and CI pipelines can branch on the outcome of a run
+package exitcode
+
+const (
+	// OK means everything requested was done
+	OK = 0
+	// Failures means the run finished but some files failed
+	Failures = 1
+	// Usage means the flags or arguments were invalid; flag parsing errors
+	// use the same status
+	Usage = 2
+	// NoMatch means no input matched, so nothing was done
+	NoMatch = 3
+	// Error means an error aborted the run before it finished
+	Error = 4
+	// Locked means another run holds the lock on an input directory
+	Locked = 5
+	// Interrupted means a signal stopped the run after saving its progress,
+	// so running it again with -resume finishes it
+	Interrupted = 6
+)
+
+// Help describes the statuses for usage messages
+const Help = "Exit status: 0 success, 1 some files failed, 2 invalid usage, 3 nothing matched, 4 aborted by an error, 5 locked by another run, 6 interrupted\n"
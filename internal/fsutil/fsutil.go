@@ -0,0 +1,47 @@
+// Package fsutil holds the file helpers shared by the goodness commands
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WriteFileAtomic writes data to a temporary file next to path and renames
+// it into place, so readers never see a partly written file. The data is
+// synced first so a crash cannot leave a renamed but empty file
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return Rename(tmp.Name(), path)
+}
+
+// Within reports whether path is dir or inside it. An empty path is in no
+// directory
+func Within(path, dir string) bool {
+	if path == "" {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
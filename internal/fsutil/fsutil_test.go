@@ -0,0 +1,33 @@
+package fsutil
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWithin(t *testing.T) {
+	tests := []struct {
+		path, dir string
+		want      bool
+	}{
+		{"/photos", "/photos", true},
+		{"/photos/2024/a.jpg", "/photos", true},
+		{"/photos/", "/photos", true},
+		{"/photos-old/a.jpg", "/photos", false},
+		{"/", "/photos", false},
+		{"/other", "/photos", false},
+		{"/photos/../other", "/photos", false},
+		{"/photos/..x/a.jpg", "/photos", true},
+		{"a/b", "a", true},
+		{"a", "a/b", false},
+		{"a", "/photos", false},
+		{"", "/photos", false},
+		{"", ".", false},
+	}
+	for _, tt := range tests {
+		path, dir := filepath.FromSlash(tt.path), filepath.FromSlash(tt.dir)
+		if got := Within(path, dir); got != tt.want {
+			t.Errorf("Within(%q, %q) = %v, want %v", path, dir, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package fsutil
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// windowsReserved are the device names Windows keeps in every folder,
+// whatever extension follows them
+var windowsReserved = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true, "conin$": true, "conout$": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"com¹": true, "com²": true, "com³": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+	"lpt¹": true, "lpt²": true, "lpt³": true,
+}
+
+// SafeName returns name, one element of a path made up from a pattern, a
+// date or a URL, changed where Windows would refuse it: the characters it
+// reserves become -, trailing dots and spaces go, and a device name such as
+// CON or LPT1 gets a _. Elsewhere name is returned as it is
+func SafeName(name string) string {
+	if runtime.GOOS != "windows" {
+		return name
+	}
+	return windowsName(name)
+}
+
+func windowsName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '-'
+		}
+		return r
+	}, name)
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return "_"
+	}
+	// CON.jpg and CON .tar.gz are the device too
+	stem, _, _ := strings.Cut(name, ".")
+	if windowsReserved[strings.ToLower(strings.TrimRight(stem, " "))] {
+		return stem + "_" + name[len(stem):]
+	}
+	return name
+}
+
+// SafePath applies SafeName to every element of a relative path
+func SafePath(rel string) string {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i, part := range parts {
+		if part != "" && part != "." && part != ".." {
+			parts[i] = SafeName(part)
+		}
+	}
+	return filepath.FromSlash(strings.Join(parts, "/"))
+}
+
+// LongPath returns path as it should be passed to another program, which
+// unlike Go may not handle long paths: on Windows an absolute path of
+// MAX_PATH or more gets the \\?\ prefix, or \\?\UNC\ for a share
+func LongPath(path string) string {
+	if runtime.GOOS != "windows" || len(path) < 248 || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if share, ok := strings.CutPrefix(abs, `\\`); ok {
+		return `\\?\UNC\` + share
+	}
+	return `\\?\` + abs
+}
+
+// FoldCase returns name as the filesystem compares it: lowercased on
+// Windows and macOS, whose filesystems ignore case by default, and as it is
+// elsewhere
+func FoldCase(name string) string {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return strings.ToLower(name)
+	}
+	return name
+}
@@ -0,0 +1,90 @@
+package fsutil
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWindowsName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"photo.jpg", "photo.jpg"},
+		{"a:b?c*d.jpg", "a-b-c-d.jpg"},
+		{`x<y>z"w|v\u/t`, "x-y-z-w-v-u-t"},
+		{"tab\there", "tab-here"},
+		{"trailing.", "trailing"},
+		{"trailing .. ", "trailing"},
+		{"...", "_"},
+		{"", "_"},
+		{"CON", "CON_"},
+		{"con.jpg", "con_.jpg"},
+		{"CON .tar.gz", "CON _.tar.gz"},
+		{"Lpt9.png", "Lpt9_.png"},
+		{"COM¹", "COM¹_"},
+		{"conin$.txt", "conin$_.txt"},
+		{"NUL.", "NUL_"},
+		{"console.jpg", "console.jpg"},
+		{"com10.jpg", "com10.jpg"},
+		{"lpt0", "lpt0"},
+		{"my con.jpg", "my con.jpg"},
+	}
+	for _, tt := range tests {
+		if got := windowsName(tt.name); got != tt.want {
+			t.Errorf("windowsName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSafeName(t *testing.T) {
+	for _, name := range []string{"CON", "a:b.jpg", "trailing. ", "plain.jpg"} {
+		want := name
+		if runtime.GOOS == "windows" {
+			want = windowsName(name)
+		}
+		if got := SafeName(name); got != want {
+			t.Errorf("SafeName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSafePath(t *testing.T) {
+	got := SafePath("../2024/aux/./photo.jpg")
+	want := "../2024/aux/./photo.jpg"
+	if runtime.GOOS == "windows" {
+		want = `..\2024\aux_\.\photo.jpg`
+	}
+	if got != want {
+		t.Errorf("SafePath = %q, want %q", got, want)
+	}
+}
+
+func TestFoldCase(t *testing.T) {
+	folds := runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+	for _, name := range []string{"Photo.JPG", "ÄRGER.png", "lower.jpg"} {
+		want := name
+		if folds {
+			want = strings.ToLower(name)
+		}
+		if got := FoldCase(name); got != want {
+			t.Errorf("FoldCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+	if folds && FoldCase("IMG_0001.JPG") != FoldCase("img_0001.jpg") {
+		t.Error("FoldCase does not make names that differ in case equal")
+	}
+}
+
+func TestLongPathShort(t *testing.T) {
+	// Short paths are never changed, and off Windows nothing is
+	long := "/" + strings.Repeat("a", 300)
+	for _, path := range []string{"photo.jpg", `C:\photos\a.jpg`, long} {
+		if runtime.GOOS == "windows" && path == long {
+			continue
+		}
+		if got := LongPath(path); got != path {
+			t.Errorf("LongPath(%q) = %q, want it unchanged", path, got)
+		}
+	}
+}
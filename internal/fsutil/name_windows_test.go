@@ -0,0 +1,36 @@
+package fsutil
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLongPath(t *testing.T) {
+	// pathOf returns an absolute path on drive C of exactly n bytes
+	pathOf := func(n int) string {
+		return `C:\` + strings.Repeat("a", n-3)
+	}
+	unc := `\\server\share\` + strings.Repeat("b", 248)
+	cwd, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	relative := strings.Repeat("c", 248)
+
+	tests := []struct {
+		path, want string
+	}{
+		{pathOf(247), pathOf(247)},
+		{pathOf(248), `\\?\` + pathOf(248)},
+		{pathOf(300), `\\?\` + pathOf(300)},
+		{`\\?\` + pathOf(300), `\\?\` + pathOf(300)},
+		{unc, `\\?\UNC\server\share\` + strings.Repeat("b", 248)},
+		{relative, `\\?\` + filepath.Join(cwd, relative)},
+	}
+	for _, tt := range tests {
+		if got := LongPath(tt.path); got != tt.want {
+			t.Errorf("LongPath(%d bytes) = %q, want %q", len(tt.path), got, tt.want)
+		}
+	}
+}
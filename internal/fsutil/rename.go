@@ -0,0 +1,22 @@
+package fsutil
+
+import (
+	"os"
+	"time"
+)
+
+// renameWait bounds how long Rename keeps retrying a busy file
+const renameWait = 2 * time.Second
+
+// Rename renames from to to, replacing to if it exists. On Windows that
+// fails while another program, such as a virus scanner, the search indexer
+// or an image viewer, has either file open, so it is retried for a moment
+func Rename(from, to string) error {
+	err := os.Rename(from, to)
+	for wait, waited := 10*time.Millisecond, time.Duration(0); err != nil && renameBusy(err) && waited < renameWait; wait *= 2 {
+		time.Sleep(wait)
+		waited += wait
+		err = os.Rename(from, to)
+	}
+	return err
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package fsutil
+
+// renameBusy reports whether a rename failed because another process has a
+// file open, which only stops renames on Windows
+func renameBusy(error) bool {
+	return false
+}
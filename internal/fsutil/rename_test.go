@@ -0,0 +1,38 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenameReplaces(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "new"), filepath.Join(dir, "old")
+	if err := os.WriteFile(from, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(to, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Rename(from, to); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(to); err != nil || string(data) != "new" {
+		t.Errorf("read %q, %v after the rename, want %q", data, err, "new")
+	}
+	if _, err := os.Stat(from); !os.IsNotExist(err) {
+		t.Errorf("the source is still there: %v", err)
+	}
+}
+
+func TestRenameMissing(t *testing.T) {
+	dir := t.TempDir()
+	err := Rename(filepath.Join(dir, "missing"), filepath.Join(dir, "to"))
+	if !os.IsNotExist(err) {
+		t.Errorf("Rename of a missing file = %v, want a not-exist error", err)
+	}
+	if renameBusy(err) {
+		t.Error("a missing file counts as busy")
+	}
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package fsutil
+
+import (
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// renameBusy reports whether a rename failed because another process has
+// one of the files open, which Windows reports as access denied when the
+// target is open without delete sharing
+func renameBusy(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	return errno == windows.ERROR_SHARING_VIOLATION || errno == windows.ERROR_LOCK_VIOLATION || errno == windows.ERROR_ACCESS_DENIED
+}
@@ -0,0 +1,78 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// holdOpen opens path, which Go does without delete sharing so Windows
+// refuses to rename it or over it, and closes it after d
+func holdOpen(t *testing.T, path string, d time.Duration) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timer := time.AfterFunc(d, func() { f.Close() })
+	t.Cleanup(func() {
+		timer.Stop()
+		f.Close()
+	})
+}
+
+// renamePair writes the files from and to in a new folder
+func renamePair(t *testing.T) (from, to string) {
+	t.Helper()
+	dir := t.TempDir()
+	from, to = filepath.Join(dir, "from"), filepath.Join(dir, "to")
+	for _, path := range []string{from, to} {
+		if err := os.WriteFile(path, []byte(filepath.Base(path)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return from, to
+}
+
+func TestRenameRetriesWhileOpen(t *testing.T) {
+	tests := []struct {
+		name   string
+		source bool
+	}{
+		{"source open", true},
+		{"target open", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to := renamePair(t)
+			held := to
+			if tt.source {
+				held = from
+			}
+			holdOpen(t, held, 200*time.Millisecond)
+			if err := Rename(from, to); err != nil {
+				t.Fatalf("Rename = %v, want it to succeed once the file is closed", err)
+			}
+			if data, err := os.ReadFile(to); err != nil || string(data) != "from" {
+				t.Errorf("read %q, %v after the rename, want %q", data, err, "from")
+			}
+		})
+	}
+}
+
+func TestRenameGivesUp(t *testing.T) {
+	from, to := renamePair(t)
+	holdOpen(t, to, 2*renameWait)
+	start := time.Now()
+	err := Rename(from, to)
+	if err == nil {
+		t.Skip("Windows renamed over an open file; nothing to retry")
+	}
+	if !renameBusy(err) {
+		t.Errorf("Rename = %v, want a sharing or access error", err)
+	}
+	if waited := time.Since(start); waited < renameWait {
+		t.Errorf("Rename gave up after %s, want at least %s", waited, renameWait)
+	}
+}
@@ -0,0 +1,48 @@
+package fsutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// byteUnits maps the suffixes accepted by ParseByteSize to multipliers
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// ParseByteSize parses sizes such as "300KB", "1.5GB" or "4096"
+func ParseByteSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// FormatBytes renders n with the largest unit ParseByteSize accepts for it
+func FormatBytes(n int64) string {
+	for _, unit := range byteUnits {
+		if n >= unit.size && unit.size > 1 {
+			return fmt.Sprintf("%.1f%s", float64(n)/float64(unit.size), unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
@@ -0,0 +1,353 @@
+// Package httpclient is the HTTP client the publishing integrations share:
+// image hosts, webhooks, remote downloads and cloud storage. Requests to
+// each host are spaced out to its rate limit, and failed requests are sent
+// again with exponential backoff and jitter, waiting at least as long as a
+// Retry-After header asks, so bulk runs do not get a token banned.
+//
+// Limits and retries come from built-in defaults, stricter for services
+// that document their limits, and from the hosts section of the
+// configuration files, with "*" for every host:
+//
+//	hosts:
+//	  api.imgur.com:
+//	    rate: 0.5       # requests per second
+//	    burst: 5        # requests sent at once after a quiet spell
+//	  "*":
+//	    retries: 6
+//	    delay: 1s       # before the first retry, doubling after each
+//	    max-delay: 1m
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"GoodnessucWorkflow/internal/config"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/pkg/errs"
+)
+
+// Policy is how requests to one host are limited and retried
+type Policy struct {
+	// Rate is the requests per second sent to the host; zero is no limit
+	Rate float64
+	// Burst is how many requests may go at once before Rate applies
+	Burst int
+	// Retries is how many times a failed request is sent again
+	Retries int
+	// Delay is the wait before the first retry, doubling after each up to
+	// MaxDelay
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// defaultPolicy applies to hosts with no settings of their own
+var defaultPolicy = Policy{Rate: 10, Burst: 10, Retries: 4, Delay: 500 * time.Millisecond, MaxDelay: 30 * time.Second}
+
+// knownHosts are the documented limits of the services goodness publishes to
+var knownHosts = map[string]func(*Policy){
+	// One message a second per webhook
+	"hooks.slack.com": func(p *Policy) { p.Rate, p.Burst = 1, 1 },
+	// 30 messages a minute per webhook
+	"discord.com": func(p *Policy) { p.Rate, p.Burst = 0.5, 5 },
+	// 50 uploads an hour for each address
+	"api.imgur.com": func(p *Policy) { p.Rate, p.Burst = 50.0/3600, 50 },
+}
+
+// maxRetryAfter is the longest Retry-After waited for; a server asking for
+// more gets its response returned instead
+const maxRetryAfter = 5 * time.Minute
+
+// Client sends requests under the policy of their host. Clients share the
+// limits, so every integration talking to a host counts against the same one
+type Client struct {
+	http *http.Client
+}
+
+// New returns a client whose attempts each time out after timeout
+func New(timeout time.Duration) *Client {
+	return &Client{http: &http.Client{Timeout: timeout}}
+}
+
+// Do sends req once its host's limit allows and sends it again while it
+// fails in a way worth retrying, returning the last response or error.
+// Requests other than GET, HEAD, PUT, DELETE and OPTIONS, which a server
+// may have acted on, are only retried when they never reached it or were
+// turned away with 429 or 503. A body must come with GetBody to be sent
+// again, as http.NewRequest sets for in-memory bodies
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	l := limiterFor(host)
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		if err := l.wait(req.Context()); err != nil {
+			return nil, err
+		}
+		resp, err := c.http.Do(req)
+
+		retry, after := retryable(req, resp, err)
+		if !retry || attempt >= l.policy.Retries || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+			return resp, err
+		}
+		if after > maxRetryAfter {
+			return resp, err
+		}
+		wait := min(l.policy.Delay<<min(attempt, 30), l.policy.MaxDelay)
+		wait += time.Duration(rand.Int64N(int64(wait)/4 + 1))
+		if after > 0 {
+			// The whole host is asked to slow down, not just this request
+			wait = max(wait, after)
+			l.pause(time.Now().Add(after))
+		}
+
+		reason := ""
+		if err != nil {
+			reason = unwrapURL(err).Error()
+		} else {
+			reason = resp.Status
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		logging.Warnf("Retrying %s %s in %s after %s (attempt %d of %d)", req.Method, host, wait.Round(time.Millisecond), reason, attempt+2, l.policy.Retries+1)
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// Get fetches rawURL
+func (c *Client) Get(rawURL string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Post posts body to rawURL
+func (c *Client) Post(rawURL, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.Do(req)
+}
+
+// PostJSON sends body as JSON to rawURL with headers and decodes the JSON
+// answer into v. An answer that is not a success is an *errs.RemoteError
+func (c *Client) PostJSON(ctx context.Context, rawURL string, headers map[string]string, body, v any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, value := range headers {
+		req.Header.Set(k, value)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	answer, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return &errs.RemoteError{Service: req.URL.Host, Status: resp.Status, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(answer))}
+	}
+	return json.Unmarshal(answer, v)
+}
+
+// retryable reports whether an attempt that ended in resp or err should be
+// made again, and how long the server asked to wait
+func retryable(req *http.Request, resp *http.Response, err error) (bool, time.Duration) {
+	if err != nil {
+		if req.Context().Err() != nil {
+			return false, 0
+		}
+		// A connection never made means the server saw nothing
+		var opErr *net.OpError
+		return idempotent(req.Method) || (errors.As(err, &opErr) && opErr.Op == "dial"), 0
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true, retryAfter(resp.Header.Get("Retry-After"))
+	case http.StatusRequestTimeout, http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent(req.Method), 0
+	}
+	return false, 0
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header, in seconds or as a date
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// unwrapURL drops the URL from a client error, as webhook URLs are secrets
+func unwrapURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// limiter spaces out the requests to one host: after a quiet spell up to
+// Burst go at once, then one every 1/Rate seconds
+type limiter struct {
+	policy Policy
+
+	mu sync.Mutex
+	// next is when the next request may go
+	next time.Time
+}
+
+var (
+	limitersMu sync.Mutex
+	limiters   = make(map[string]*limiter)
+)
+
+// limiterFor returns the limiter every client shares for host
+func limiterFor(host string) *limiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	l, ok := limiters[host]
+	if !ok {
+		l = &limiter{policy: policyFor(host)}
+		limiters[host] = l
+	}
+	return l
+}
+
+// wait blocks until a request may go, or ctx is done. Without a rate only
+// a pause holds requests back
+func (l *limiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	var interval time.Duration
+	if l.policy.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / l.policy.Rate)
+		if earliest := now.Add(-time.Duration(max(l.policy.Burst, 1)-1) * interval); l.next.Before(earliest) {
+			l.next = earliest
+		}
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(interval)
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pause holds back every request to the host until t
+func (l *limiter) pause(t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.next.Before(t) {
+		l.next = t
+	}
+}
+
+// policyFor returns the policy of host: the defaults, then the known
+// limits of the service, then the configuration for every host and for
+// this one
+func policyFor(host string) Policy {
+	p := defaultPolicy
+	if known, ok := knownHosts[host]; ok {
+		known(&p)
+	}
+	for _, name := range []string{"*", host} {
+		settings, err := config.Host(name)
+		if err == nil {
+			err = p.set(settings)
+		}
+		if err != nil {
+			logging.Warnf("Ignoring the hosts configuration for %s: %s", name, err)
+		}
+	}
+	return p
+}
+
+// set applies settings from the configuration to p
+func (p *Policy) set(settings map[string]any) error {
+	next := *p
+	for name, value := range settings {
+		s := fmt.Sprint(value)
+		var err error
+		switch name {
+		case "rate":
+			next.Rate, err = strconv.ParseFloat(s, 64)
+		case "burst":
+			next.Burst, err = strconv.Atoi(s)
+		case "retries":
+			next.Retries, err = strconv.Atoi(s)
+		case "delay":
+			next.Delay, err = time.ParseDuration(s)
+		case "max-delay":
+			next.MaxDelay, err = time.ParseDuration(s)
+		default:
+			return fmt.Errorf("unknown setting %q; expected rate, burst, retries, delay or max-delay", name)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", name, s)
+		}
+	}
+	if next.Rate < 0 || next.Burst < 0 || next.Retries < 0 || next.Delay < 0 || next.MaxDelay < 0 {
+		return errors.New("settings cannot be negative")
+	}
+	*p = next
+	return nil
+}
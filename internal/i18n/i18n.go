@@ -0,0 +1,95 @@
+// Package i18n translates the messages and help of the goodness commands.
+// Text is written in English in the code and looked up by that English in
+// the catalog of the selected language, so anything without a translation
+// still reads, in English. Catalogs live next to this file, one per
+// language, keyed by the exact English text including its format verbs.
+//
+// The language comes from the -lang flag every command takes, which like
+// any flag can be set in the configuration files or as GOODNESS_LANG, and
+// otherwise from LC_ALL, LC_MESSAGES or LANG
+package i18n
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// English is the language of the code, which needs no catalog
+const English = "en"
+
+// catalogs holds the translations of each language but English
+var catalogs = map[string]map[string]string{
+	"es": spanish,
+}
+
+// current is the catalog in use; nil for English
+var current atomic.Pointer[map[string]string]
+
+func init() {
+	for _, key := range []string{"GOODNESS_LANG", "LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(key); value != "" {
+			// A locale without a catalog stays in English; only -lang
+			// complains about one
+			Set(value)
+			return
+		}
+	}
+}
+
+// Languages lists the languages messages can be shown in, English first
+func Languages() []string {
+	langs := []string{English}
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	slices.Sort(langs[1:])
+	return langs
+}
+
+// normalize turns a locale such as es_ES.UTF-8 into its language, es
+func normalize(locale string) string {
+	lang, _, _ := strings.Cut(strings.ToLower(locale), ".")
+	lang, _, _ = strings.Cut(lang, "@")
+	lang, _, _ = strings.Cut(lang, "_")
+	lang, _, _ = strings.Cut(lang, "-")
+	if lang == "c" || lang == "posix" {
+		return English
+	}
+	return lang
+}
+
+// Set selects the language of a locale or language name, such as es or
+// es_ES.UTF-8
+func Set(locale string) error {
+	lang := normalize(locale)
+	if lang == English {
+		current.Store(nil)
+		return nil
+	}
+	catalog, ok := catalogs[lang]
+	if !ok {
+		return fmt.Errorf("no messages in %q; expected %s", locale, strings.Join(Languages(), " or "))
+	}
+	current.Store(&catalog)
+	return nil
+}
+
+// T returns the translation of s in the selected language, or s
+func T(s string) string {
+	catalog := current.Load()
+	if catalog == nil {
+		return s
+	}
+	if translated, ok := (*catalog)[s]; ok {
+		return translated
+	}
+	return s
+}
+
+// Sprintf formats the translation of format
+func Sprintf(format string, args ...any) string {
+	return fmt.Sprintf(T(format), args...)
+}
@@ -0,0 +1,448 @@
+package journal
+
+// The audit log is a second, permanent record of the same changes, for
+// tracing what happened to a file long after its run left the journal. It
+// is one JSON line per change in goodness/audit.jsonl under the state
+// folder, never pruned and kept even with GOODNESS_JOURNAL=off; only
+// GOODNESS_AUDIT=off stops it. Each line holds the hash of the line before
+// it and its own, so editing, removing or reordering lines breaks the chain
+// at that point.
+//
+// The chain alone says nothing of lines cut off the end, nor of a log
+// rewritten whole with fresh hashes. Beside the log, audit.head records
+// the number and hash of its last entry, so a shorter log shows, and with
+// GOODNESS_AUDIT_KEY set the head is signed with an HMAC so that rewriting
+// both files takes the key too. Without the key anyone who can write the
+// state folder can rewrite both; the log is then only tamper-evident
+// against mistakes
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/secrets"
+)
+
+// EnvAuditKey is the secret the audit log's head record is signed with
+const EnvAuditKey = "GOODNESS_AUDIT_KEY"
+
+// opStart is the audit entry each process writes before its first change
+const opStart = "start"
+
+// Waits for the audit log's lock, which a process holds while it appends.
+// A lock older than staleAuditLock was left by a process that crashed
+const (
+	auditLockWait  = 10 * time.Second
+	staleAuditLock = 30 * time.Second
+)
+
+// AuditEntry is one line of the audit log
+type AuditEntry struct {
+	Seq  int64     `json:"seq"`
+	Time time.Time `json:"time"`
+	Run  string    `json:"run"`
+	// Op is start, with the Command and Dir of the process, before the
+	// first change of each process, then create, modify, delete or move
+	Op      string   `json:"op"`
+	Command []string `json:"command,omitempty"`
+	Dir     string   `json:"dir,omitempty"`
+	Path    string   `json:"path,omitempty"`
+	From    string   `json:"from,omitempty"`
+	Size    int64    `json:"size,omitempty"`
+	// Undoes is the run whose change this one reversed, for goodness undo
+	Undoes string `json:"undoes,omitempty"`
+	// Prev is the Hash of the entry before, "" for the first. Hash is the
+	// SHA-256 of the entry as JSON with Hash empty
+	Prev string `json:"prev"`
+	Hash string `json:"hash"`
+}
+
+// ChainError is an audit log whose hash chain breaks at entry Seq
+type ChainError struct {
+	Line   int
+	Seq    int64
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("the audit log breaks at line %d (entry %d): %s", e.Line, e.Seq, e.Reason)
+}
+
+// auditHead is the record of the audit log's last entry kept beside it.
+// MAC is the HMAC-SHA256 of Seq and Hash under the audit key, if one is set
+type auditHead struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+	MAC  string `json:"mac,omitempty"`
+}
+
+// AuditAnchor is what holds the audit log's chain beyond the log itself
+type AuditAnchor struct {
+	// Head is set when the log has a head record; logs written before
+	// there was one get it with their next change
+	Head bool
+	// Signed is set when the head carries an HMAC, and Keyed when the key
+	// is there to check it
+	Signed, Keyed bool
+}
+
+var (
+	auditMu      sync.Mutex
+	auditOff     = os.Getenv("GOODNESS_AUDIT") == "off"
+	auditStarted bool
+)
+
+// processRun is the ID of the run a process starts, from its start time and
+// PID, shared by the journal and the audit log
+var processRun = sync.OnceValue(func() string {
+	return fmt.Sprintf("%s-%d", time.Now().Format("20060102-150405.000"), os.Getpid())
+})
+
+// runID is the run the process's changes belong to, whether or not the
+// journal is on
+func runID() string {
+	if current != "" {
+		return current
+	}
+	if id := os.Getenv(EnvRun); id != "" {
+		return id
+	}
+	return processRun()
+}
+
+// AuditPath returns the audit log: goodness/audit.jsonl in the folder that
+// holds the journal
+func AuditPath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(dir), "audit.jsonl"), nil
+}
+
+// auditHeadPath returns the head record of the audit log at path
+func auditHeadPath(path string) string {
+	return strings.TrimSuffix(path, ".jsonl") + ".head"
+}
+
+// auditKey is the key the head record is signed with, or nil. The secrets
+// file is only read when that asks for no passphrase, as every command
+// audits its changes
+var auditKey = sync.OnceValues(func() ([]byte, error) {
+	key, _, err := secrets.LookupQuiet(EnvAuditKey)
+	if err != nil || key == "" {
+		return nil, err
+	}
+	return []byte(key), nil
+})
+
+// sign returns the HMAC of h under key
+func (h auditHead) sign(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%d:%s", h.Seq, h.Hash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// auditChange appends e, a change of run just made, to the audit log.
+// undoes names the run an undo reversed it for
+func auditChange(e Entry, run, undoes string) {
+	a := AuditEntry{Op: e.Op, Path: e.Path, From: e.From, Undoes: undoes}
+	if e.Op != opDelete {
+		if info, err := os.Lstat(e.Path); err == nil && !info.IsDir() {
+			a.Size = info.Size()
+		}
+	}
+	audit(a, run)
+}
+
+// auditUndo appends the reversal of e, a change of the run undoes, to the
+// audit log
+func auditUndo(e Entry, undoes string) {
+	reversed := Entry{Op: e.Op, Path: e.Path}
+	switch e.Op {
+	case opCreate:
+		reversed.Op = opDelete
+	case opDelete:
+		reversed.Op = opCreate
+	case opMove:
+		reversed.From, reversed.Path = e.Path, e.From
+	}
+	mu.Lock()
+	run := runID()
+	mu.Unlock()
+	auditChange(reversed, run, undoes)
+}
+
+// audit appends a, stamped and chained, preceded by the start entry of the
+// process when it is its first. A failure is warned about once and turns
+// auditing off for the rest of the process, as for the journal
+func audit(a AuditEntry, run string) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if auditOff {
+		return
+	}
+	var entries []AuditEntry
+	if !auditStarted {
+		cwd, _ := os.Getwd()
+		entries = append(entries, AuditEntry{Op: opStart, Run: run, Command: os.Args[1:], Dir: cwd})
+	}
+	a.Run = run
+	entries = append(entries, a)
+	if err := appendAudit(entries); err != nil {
+		logging.Warnf("Failed to write the audit log, changes from here on are not audited: %s", err)
+		auditOff = true
+		return
+	}
+	auditStarted = true
+}
+
+// appendAudit chains entries onto the end of the audit log under its lock
+func appendAudit(entries []AuditEntry) error {
+	path, err := AuditPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// The key is read first so that a keychain error writes nothing
+	key, err := auditKey()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockAudit(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	last, err := lastAuditEntry(f)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, a := range entries {
+		a.Seq, a.Prev, a.Time = last.Seq+1, last.Hash, time.Now().UTC()
+		if a.Hash, err = a.sum(); err != nil {
+			return err
+		}
+		data, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+		last = a
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	head := auditHead{Seq: last.Seq, Hash: last.Hash}
+	if key != nil {
+		head.MAC = head.sign(key)
+	}
+	data, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(auditHeadPath(path), append(data, '\n'), 0600)
+}
+
+// sum is the hash of a with its Hash left out
+func (a AuditEntry) sum() (string, error) {
+	a.Hash = ""
+	data, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// lastAuditEntry reads the last line of the audit log, or a zero entry for
+// an empty log
+func lastAuditEntry(f *os.File) (AuditEntry, error) {
+	var last AuditEntry
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return last, err
+	}
+	// Read back from the end in growing chunks until a whole line is found
+	size := info.Size()
+	for chunk := int64(4096); ; chunk *= 4 {
+		start := max(size-chunk, 0)
+		buf := make([]byte, size-start)
+		if _, err := f.ReadAt(buf, start); err != nil && err != io.EOF {
+			return last, err
+		}
+		buf = bytes.TrimRight(buf, "\n")
+		i := bytes.LastIndexByte(buf, '\n')
+		if i < 0 && start > 0 {
+			continue
+		}
+		if err := json.Unmarshal(buf[i+1:], &last); err != nil {
+			return last, fmt.Errorf("the last line of the audit log is damaged: %w", err)
+		}
+		return last, nil
+	}
+}
+
+// lockAudit takes the lock file at path, waiting while another process
+// holds it, and returns its release
+func lockAudit(path string) (func(), error) {
+	deadline := time.Now().Add(auditLockWait)
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleAuditLock {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is held by another process", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// ReadAudit reads the audit log, oldest entry first, and checks its chain.
+// A broken chain is returned as a *ChainError along with every entry that
+// could be read
+func ReadAudit() ([]AuditEntry, error) {
+	path, err := AuditPath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, checkAuditHead(path, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	var broken *ChainError
+	var prev AuditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var a AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			if broken == nil {
+				broken = &ChainError{Line: line, Seq: prev.Seq + 1, Reason: "the line is not an entry"}
+			}
+			continue
+		}
+		if broken == nil {
+			sum, err := a.sum()
+			switch {
+			case err != nil:
+				broken = &ChainError{Line: line, Seq: a.Seq, Reason: err.Error()}
+			case a.Hash != sum:
+				broken = &ChainError{Line: line, Seq: a.Seq, Reason: "the entry was changed"}
+			case a.Prev != prev.Hash || a.Seq != prev.Seq+1:
+				broken = &ChainError{Line: line, Seq: a.Seq, Reason: "an entry before it was removed, added or moved"}
+			}
+		}
+		entries = append(entries, a)
+		prev = a
+	}
+	if err := scanner.Err(); err != nil {
+		return entries, err
+	}
+	if broken != nil {
+		return entries, broken
+	}
+	return entries, checkAuditHead(path, entries)
+}
+
+// checkAuditHead checks entries, an unbroken chain read from the audit log
+// at path, against its head record. Entry i is then on line i+1
+func checkAuditHead(path string, entries []AuditEntry) error {
+	head, err := readAuditHead(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	n := int64(len(entries))
+	switch {
+	case head.Seq > n:
+		return &ChainError{Line: len(entries) + 1, Seq: n + 1, Reason: fmt.Sprintf("entries were cut off the end; the head record ends at entry %d", head.Seq)}
+	case head.Seq < n:
+		return &ChainError{Line: int(head.Seq) + 1, Seq: head.Seq + 1, Reason: "the entry was added without updating the head record"}
+	case n > 0 && entries[n-1].Hash != head.Hash:
+		return &ChainError{Line: len(entries), Seq: n, Reason: "the entry does not match the head record"}
+	}
+	key, err := auditKey()
+	if err != nil {
+		return err
+	}
+	if key != nil && !hmac.Equal([]byte(head.MAC), []byte(head.sign(key))) {
+		return &ChainError{Line: len(entries), Seq: n, Reason: "the head record is not signed with " + EnvAuditKey}
+	}
+	return nil
+}
+
+// readAuditHead reads the head record of the audit log at path
+func readAuditHead(path string) (auditHead, error) {
+	var head auditHead
+	data, err := os.ReadFile(auditHeadPath(path))
+	if err != nil {
+		return head, err
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return head, fmt.Errorf("the head record of the audit log is damaged: %w", err)
+	}
+	return head, nil
+}
+
+// ReadAuditAnchor reports what anchors the audit log beyond its chain,
+// which ReadAudit checks
+func ReadAuditAnchor() (AuditAnchor, error) {
+	path, err := AuditPath()
+	if err != nil {
+		return AuditAnchor{}, err
+	}
+	head, err := readAuditHead(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return AuditAnchor{}, nil
+	}
+	if err != nil {
+		return AuditAnchor{}, err
+	}
+	key, err := auditKey()
+	if err != nil {
+		return AuditAnchor{}, err
+	}
+	return AuditAnchor{Head: true, Signed: head.MAC != "", Keyed: key != nil}, nil
+}
@@ -0,0 +1,51 @@
+package journal
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAuditHead(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv(EnvAuditKey, "")
+	for _, p := range []string{"/a", "/b", "/c"} {
+		audit(AuditEntry{Op: opCreate, Path: p}, "run")
+	}
+	if auditOff {
+		t.Fatal("auditing turned off")
+	}
+	entries, err := ReadAudit()
+	if err != nil || len(entries) != 4 {
+		t.Fatalf("ReadAudit = %d entries, %v; want 4, nil", len(entries), err)
+	}
+
+	path, err := AuditPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if err := os.WriteFile(path, bytes.Join(lines[:3], nil), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var broken *ChainError
+	if _, err := ReadAudit(); !errors.As(err, &broken) || broken.Seq != 4 || !strings.Contains(broken.Reason, "cut off") {
+		t.Errorf("ReadAudit of a cut log = %v; want entry 4 cut off", err)
+	}
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(auditHeadPath(path), []byte(`{"seq":3,"hash":"x"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadAudit(); !errors.As(err, &broken) || broken.Seq != 4 {
+		t.Errorf("ReadAudit past the head = %v; want entry 4 added", err)
+	}
+}
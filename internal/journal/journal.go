@@ -0,0 +1,443 @@
+// Package journal records every file the goodness commands create, change,
+// move or delete, keeping the previous content, so goodness undo can put a
+// run's files back the way they were.
+//
+// Each process that changes a file starts a run under Dir, named by the time
+// it started. Workflows pass their run to their steps through the
+// GOODNESS_JOURNAL_RUN variable so undo reverses the whole workflow. Setting
+// GOODNESS_JOURNAL=off turns the journal off.
+//
+// The same changes also go to the audit log, a hash-chained record that is
+// never pruned; see audit.go.
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/logging"
+)
+
+// EnvRun names the run a process records into, shared by workflow steps
+const EnvRun = "GOODNESS_JOURNAL_RUN"
+
+// keepRuns is how many runs are kept; older ones are pruned as new ones start
+const keepRuns = 50
+
+// Entry kinds
+const (
+	opCreate = "create"
+	opModify = "modify"
+	opDelete = "delete"
+	opMove   = "move"
+)
+
+// Entry is one change. Saved names the previous content of Path, kept in
+// the run's files folder, for modify, delete and moves onto an existing
+// file. Size and ModTime describe Path just after the change, so undo can
+// tell when something else changed it since
+type Entry struct {
+	Op      string     `json:"op"`
+	Path    string     `json:"path"`
+	From    string     `json:"from,omitempty"`
+	Saved   string     `json:"saved,omitempty"`
+	Dir     bool       `json:"dir,omitempty"`
+	Size    int64      `json:"size,omitempty"`
+	ModTime *time.Time `json:"mod_time,omitempty"`
+	Time    time.Time  `json:"time"`
+}
+
+// Run is one invocation of goodness, or one workflow, and its changes
+type Run struct {
+	ID      string    `json:"id"`
+	Command []string  `json:"command"`
+	Dir     string    `json:"dir"`
+	Started time.Time `json:"started"`
+	Entries []Entry   `json:"-"`
+}
+
+var (
+	mu       sync.Mutex
+	current  string
+	disabled = os.Getenv("GOODNESS_JOURNAL") == "off"
+	saved    int
+)
+
+// Dir returns the folder runs are kept in: goodness/journal under
+// XDG_STATE_HOME, ~/.local/state or, on Windows, the local app data folder
+func Dir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "goodness", "journal"), nil
+	}
+	if runtime.GOOS == "windows" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "goodness", "journal"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "goodness", "journal"), nil
+}
+
+// Start returns the ID of the run this process records into, starting one
+// if needed. With the journal off it is only the ID the audit log records
+// changes under. Workflows call it to hand the ID to their steps; other
+// commands start a run with their first change
+func Start() (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if disabled {
+		return runID(), nil
+	}
+	return start()
+}
+
+func start() (string, error) {
+	if current != "" {
+		return current, nil
+	}
+	root, err := Dir()
+	if err != nil {
+		return "", err
+	}
+
+	id := os.Getenv(EnvRun)
+	if id == "" {
+		id = processRun()
+		prune(root)
+	}
+	dir := filepath.Join(root, id)
+	if err := os.MkdirAll(filepath.Join(dir, "files"), 0700); err != nil {
+		return "", err
+	}
+
+	cwd, _ := os.Getwd()
+	data, err := json.MarshalIndent(Run{ID: id, Command: os.Args[1:], Dir: cwd, Started: time.Now()}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	// The first process of a shared run describes it
+	f, err := os.OpenFile(filepath.Join(dir, "run.json"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err == nil {
+		_, err = f.Write(append(data, '\n'))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", err
+		}
+	} else if !os.IsExist(err) {
+		return "", err
+	}
+	current = id
+	return id, nil
+}
+
+// prune removes all but the newest keepRuns runs
+func prune(root string) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	var ids []string
+	for _, e := range entries {
+		if e.IsDir() {
+			ids = append(ids, e.Name())
+		}
+	}
+	sort.Strings(ids)
+	for len(ids) >= keepRuns {
+		if err := os.RemoveAll(filepath.Join(root, ids[0])); err != nil {
+			logging.Warnf("Failed to prune the undo journal: %s", err)
+			return
+		}
+		ids = ids[1:]
+	}
+}
+
+// fail turns the journal off for the rest of the process after a warning,
+// so a broken journal never stops the work itself
+func fail(err error) {
+	logging.Warnf("Failed to write the undo journal, changes from here on cannot be undone: %s", err)
+	disabled = true
+}
+
+// save keeps the current content of path in the run's files folder,
+// returning its name there, or "" when path does not exist. A hard link is
+// enough as the commands replace files rather than writing into them
+func save(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", path)
+	}
+	id, err := start()
+	if err != nil {
+		return "", err
+	}
+	root, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	saved++
+	name := fmt.Sprintf("%d-%d-%s", os.Getpid(), saved, filepath.Base(path))
+	target := filepath.Join(root, id, "files", name)
+	if err := os.Link(path, target); err != nil {
+		if err := fsutil.CopyFile(path, target); err != nil {
+			return "", err
+		}
+	}
+	return name, nil
+}
+
+// add stamps e with the state of its path and appends it to the run
+func add(e Entry) error {
+	id, err := start()
+	if err != nil {
+		return err
+	}
+	root, err := Dir()
+	if err != nil {
+		return err
+	}
+	e.Time = time.Now()
+	if e.Op != opDelete {
+		info, err := os.Lstat(e.Path)
+		switch {
+		case os.IsNotExist(err) && e.Op == opMove:
+			// Moved on by a later step of a plan journaled once it all ran,
+			// as a file parked to swap names is; undo checks a path against
+			// its last change only
+		case err != nil:
+			return err
+		default:
+			e.Dir = info.IsDir()
+			if !e.Dir {
+				modTime := info.ModTime()
+				e.Size, e.ModTime = info.Size(), &modTime
+			}
+		}
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	// One write per line keeps lines whole when a workflow's steps append
+	// to the same run
+	f, err := os.OpenFile(filepath.Join(root, id, "entries.jsonl"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// change keeps the content of kept, runs op and records entry for it.
+// Journal failures are warned about once and never stop op, which runs
+// unlocked so parallel workers only queue for the bookkeeping
+func change(kept string, entry Entry, op func() error) error {
+	if entry.Op == opCreate && kept != "" {
+		if _, err := os.Lstat(kept); err == nil {
+			entry.Op = opModify
+		}
+	}
+	mu.Lock()
+	if disabled {
+		mu.Unlock()
+		if err := op(); err != nil {
+			return err
+		}
+		auditChange(entry, runID(), "")
+		return nil
+	}
+	var name string
+	if kept != "" {
+		var err error
+		if name, err = save(kept); err != nil {
+			fail(err)
+		}
+	}
+	mu.Unlock()
+
+	if err := op(); err != nil {
+		if name != "" {
+			removeSaved(name)
+		}
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	auditChange(entry, runID(), "")
+	if disabled {
+		return nil
+	}
+	entry.Saved = name
+	if entry.Op == opCreate && name != "" {
+		entry.Op = opModify
+	}
+	if err := add(entry); err != nil {
+		fail(err)
+	}
+	return nil
+}
+
+func removeSaved(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if root, err := Dir(); err == nil {
+		os.Remove(filepath.Join(root, current, "files", name))
+	}
+}
+
+// WriteFile writes data to path with fsutil.WriteFileAtomic
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	path = abs(path)
+	return change(path, Entry{Op: opCreate, Path: path}, func() error {
+		return fsutil.WriteFileAtomic(path, data, perm)
+	})
+}
+
+// Replace renames tmp, a finished file written next to path, over path. It
+// is a change to path, so undo puts back what path held rather than tmp
+func Replace(tmp, path string) error {
+	path = abs(path)
+	return change(path, Entry{Op: opCreate, Path: path}, func() error {
+		return fsutil.Rename(tmp, path)
+	})
+}
+
+// Overwrite renames tmp over path like Replace but keeps no copy of what
+// path held, for changes that remove data which must not linger on disk,
+// as scrub-gps does. The change is audited but undo cannot reverse it
+func Overwrite(tmp, path string) error {
+	path = abs(path)
+	if err := fsutil.Rename(tmp, path); err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	auditChange(Entry{Op: opModify, Path: path}, runID(), "")
+	return nil
+}
+
+// Rename renames from to to
+func Rename(from, to string) error {
+	from, to = abs(from), abs(to)
+	return change(to, Entry{Op: opMove, From: from, Path: to}, func() error {
+		return fsutil.Rename(from, to)
+	})
+}
+
+// Move moves from to to with fsutil.MoveFile, which works across filesystems
+func Move(from, to string) error {
+	from, to = abs(from), abs(to)
+	return change(to, Entry{Op: opMove, From: from, Path: to}, func() error {
+		return fsutil.MoveFile(from, to)
+	})
+}
+
+// Copy copies src to a new file dst with fsutil.CopyFile
+func Copy(src, dst string) error {
+	dst = abs(dst)
+	return change("", Entry{Op: opCreate, Path: dst}, func() error {
+		return fsutil.CopyFile(src, dst)
+	})
+}
+
+// Remove deletes the file path
+func Remove(path string) error {
+	path = abs(path)
+	return change(path, Entry{Op: opDelete, Path: path}, func() error {
+		return os.Remove(path)
+	})
+}
+
+// MkdirAll creates dir and any missing parents, recording each it made
+func MkdirAll(dir string, perm os.FileMode) error {
+	dir = abs(dir)
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Lstat(d); err == nil || filepath.Dir(d) == d {
+			break
+		}
+		missing = append(missing, d)
+	}
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		Created(missing[i])
+	}
+	return nil
+}
+
+// Created records a file or directory made by other means
+func Created(path string) {
+	record("", Entry{Op: opCreate, Path: abs(path)})
+}
+
+// Moved records a move made by other means onto a path that did not exist
+func Moved(from, to string) {
+	record("", Entry{Op: opMove, From: abs(from), Path: abs(to)})
+}
+
+// Removed records that path was deleted by other means, its content having
+// been kept at keptAt until now
+func Removed(path, keptAt string) {
+	record(keptAt, Entry{Op: opDelete, Path: abs(path)})
+}
+
+// Replaced records that path was replaced by other means, its previous
+// content having been kept at keptAt until now
+func Replaced(path, keptAt string) {
+	record(keptAt, Entry{Op: opModify, Path: abs(path)})
+}
+
+// record adds entry for a change already made, saving kept first if set
+func record(kept string, entry Entry) {
+	mu.Lock()
+	defer mu.Unlock()
+	auditChange(entry, runID(), "")
+	if disabled {
+		return
+	}
+	if kept != "" {
+		name, err := save(kept)
+		if err != nil {
+			fail(err)
+			return
+		}
+		entry.Saved = name
+	}
+	if err := add(entry); err != nil {
+		fail(err)
+	}
+}
+
+// abs keeps entries meaningful whichever directory undo runs in
+func abs(path string) string {
+	if a, err := filepath.Abs(path); err == nil {
+		return a
+	}
+	return filepath.Clean(path)
+}
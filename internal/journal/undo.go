@@ -0,0 +1,234 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"GoodnessucWorkflow/internal/fsutil"
+)
+
+// ErrEmpty is returned by Latest when there is no run to undo
+var ErrEmpty = errors.New("the undo journal has no changes to undo")
+
+func (e Entry) String() string {
+	if e.Op == opMove {
+		return fmt.Sprintf("%s %s -> %s", e.Op, e.From, e.Path)
+	}
+	return e.Op + " " + e.Path
+}
+
+// Runs lists the runs that changed files, newest first
+func Runs() ([]*Run, error) {
+	root, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var runs []*Run
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		r, err := Load(d.Name())
+		if err != nil || len(r.Entries) == 0 {
+			continue
+		}
+		runs = append(runs, r)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ID > runs[j].ID })
+	return runs, nil
+}
+
+// Latest returns the newest run that changed files
+func Latest() (*Run, error) {
+	runs, err := Runs()
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, ErrEmpty
+	}
+	return runs[0], nil
+}
+
+// Load reads the run id and its entries
+func Load(id string) (*Run, error) {
+	root, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	if id == "" || filepath.Base(id) != id {
+		return nil, fmt.Errorf("invalid run %q", id)
+	}
+	dir := filepath.Join(root, id)
+	data, err := os.ReadFile(filepath.Join(dir, "run.json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no run %q in the undo journal", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := &Run{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("run %s: %s", id, err)
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, "entries.jsonl"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// A line cut short by a crash is the last change; it may not
+			// have happened
+			continue
+		}
+		r.Entries = append(r.Entries, e)
+	}
+	return r, scanner.Err()
+}
+
+// Check lists the files changed again since the run, whose undo would
+// lose those later changes
+func (r *Run) Check() []error {
+	var errs []error
+	// Only the run's last change to a path says what it should hold now
+	seen := make(map[string]bool)
+	for i := len(r.Entries) - 1; i >= 0; i-- {
+		e := r.Entries[i]
+		if seen[e.Path] {
+			seen[e.From] = true
+			continue
+		}
+		seen[e.Path] = true
+		info, err := os.Lstat(e.Path)
+		switch {
+		case e.Op == opDelete:
+			if err == nil {
+				errs = append(errs, fmt.Errorf("%s was created again since", e.Path))
+			}
+		case os.IsNotExist(err):
+			if e.Op != opCreate {
+				errs = append(errs, fmt.Errorf("%s no longer exists", e.Path))
+			}
+		case err != nil:
+			errs = append(errs, err)
+		case !e.Dir && (info.Size() != e.Size || e.ModTime == nil || !info.ModTime().Equal(*e.ModTime)):
+			errs = append(errs, fmt.Errorf("%s changed since", e.Path))
+		}
+		if e.Op == opMove && !seen[e.From] {
+			seen[e.From] = true
+			if _, err := os.Lstat(e.From); err == nil {
+				errs = append(errs, fmt.Errorf("%s was created again since", e.From))
+			}
+		}
+	}
+	return errs
+}
+
+// Undo reverses the run's changes newest first and removes it from the
+// journal. With force, files changed since are overwritten too. When a
+// change cannot be undone the ones left are kept, so Undo can be retried
+func (r *Run) Undo(force bool) error {
+	root, err := Dir()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(root, r.ID)
+	for i := len(r.Entries) - 1; i >= 0; i-- {
+		if err := r.Entries[i].undo(filepath.Join(dir, "files"), force); err != nil {
+			r.Entries = r.Entries[:i+1]
+			if saveErr := r.saveEntries(dir); saveErr != nil {
+				return errors.Join(err, saveErr)
+			}
+			return fmt.Errorf("%s: %w", r.Entries[i], err)
+		}
+		auditUndo(r.Entries[i], r.ID)
+	}
+	return os.RemoveAll(dir)
+}
+
+func (e Entry) undo(files string, force bool) error {
+	switch e.Op {
+	case opCreate:
+		err := os.Remove(e.Path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil && e.Dir && !force {
+			return fmt.Errorf("the directory is no longer empty: %w", err)
+		}
+		if err != nil && e.Dir {
+			// Keep what was added to it since
+			return nil
+		}
+		return err
+
+	case opModify, opDelete:
+		return restore(filepath.Join(files, e.Saved), e.Path)
+
+	case opMove:
+		if force {
+			os.Remove(e.From)
+		}
+		if err := fsutil.MoveFile(e.Path, e.From); err != nil {
+			return err
+		}
+		if e.Saved != "" {
+			return restore(filepath.Join(files, e.Saved), e.Path)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown op %q", e.Op)
+}
+
+// restore puts the saved copy back at path, replacing anything there
+func restore(saved, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	err := fsutil.Rename(saved, path)
+	var linkErr *os.LinkError
+	if err == nil || !errors.As(err, &linkErr) {
+		return err
+	}
+	// The journal is on another filesystem
+	tmp := filepath.Join(filepath.Dir(path), ".goodness-undo-"+filepath.Base(path))
+	os.Remove(tmp)
+	if err := fsutil.CopyFile(saved, tmp); err != nil {
+		return err
+	}
+	if err := fsutil.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(saved)
+}
+
+// saveEntries rewrites the entries file with the changes still to undo
+func (r *Run) saveEntries(dir string) error {
+	var buf bytes.Buffer
+	for _, e := range r.Entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	}
+	return fsutil.WriteFileAtomic(filepath.Join(dir, "entries.jsonl"), buf.Bytes(), 0600)
+}
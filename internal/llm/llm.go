@@ -0,0 +1,151 @@
+// Package llm asks a language model for text. The commands that want
+// suggestions talk to the Model interface, and each provider implements it
+// over its own API: any OpenAI-compatible chat completions endpoint,
+// Anthropic's messages API or a local Ollama. Prompts can carry images for
+// models that see them. Adding a provider is adding an entry to providers.
+//
+// The provider, endpoint and model are flags, so they can be set once for
+// every command in the configuration files:
+//
+//	all:
+//	  provider: ollama
+//	  model: llama3.1
+//
+// API keys come from the environment or goodness auth login, as
+// OPENAI_API_KEY and ANTHROPIC_API_KEY
+package llm
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"GoodnessucWorkflow/internal/secrets"
+)
+
+// Model answers a prompt
+type Model interface {
+	Complete(ctx context.Context, req Request) (string, error)
+}
+
+// Request is one prompt to a model
+type Request struct {
+	// System sets out the task and the rules of the answer
+	System string
+	// Prompt is the text to work on
+	Prompt string
+	// Images go with the prompt, for models that can see them
+	Images []Image
+}
+
+// Image is a picture sent with a prompt
+type Image struct {
+	// MediaType is image/jpeg, image/png, image/gif or image/webp
+	MediaType string
+	Data      []byte
+}
+
+// timeout bounds one request, as models can take a while on long prompts
+const timeout = 2 * time.Minute
+
+// provider is a kind of API a Model can be reached over
+type provider struct {
+	endpoint string
+	model    string
+	// vision is the default model for prompts with images
+	vision string
+	// key is the secret holding the API key, needed unless a different
+	// endpoint is given, or empty for providers that run without one
+	key  string
+	open func(endpoint, model, key string) Model
+}
+
+// providers are the APIs -provider picks from
+var providers = map[string]provider{
+	"openai": {
+		endpoint: "https://api.openai.com/v1",
+		model:    "gpt-4o-mini",
+		vision:   "gpt-4o-mini",
+		key:      "OPENAI_API_KEY",
+		open:     func(endpoint, model, key string) Model { return &openAI{endpoint, model, key} },
+	},
+	"anthropic": {
+		endpoint: "https://api.anthropic.com",
+		model:    "claude-3-5-haiku-latest",
+		vision:   "claude-3-5-sonnet-latest",
+		key:      "ANTHROPIC_API_KEY",
+		open:     func(endpoint, model, key string) Model { return &anthropic{endpoint, model, key} },
+	},
+	"ollama": {
+		endpoint: "http://localhost:11434",
+		model:    "llama3.1",
+		vision:   "llama3.2-vision",
+		open:     func(endpoint, model, _ string) Model { return &ollama{endpoint, model} },
+	},
+}
+
+// Providers lists the names -provider accepts
+func Providers() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Options pick the model a command talks to
+type Options struct {
+	Provider string
+	Endpoint string
+	Model    string
+	// Vision picks the provider's default model for images
+	Vision bool
+}
+
+// AddFlags defines -provider, -endpoint and -model on fs
+func AddFlags(fs *flag.FlagSet) *Options {
+	o := new(Options)
+	fs.StringVar(&o.Provider, "provider", "openai", "language model API: "+strings.Join(Providers(), ", ")+"; openai also works with any compatible endpoint")
+	fs.StringVar(&o.Endpoint, "endpoint", "", "base URL of the API (default the provider's)")
+	fs.StringVar(&o.Model, "model", "", "model to ask (default the provider's usual one for the task)")
+	return o
+}
+
+// Open returns the model o picks, with its API key looked up
+func (o *Options) Open() (Model, error) {
+	p, ok := providers[o.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q; expected %s", o.Provider, strings.Join(Providers(), ", "))
+	}
+	endpoint := strings.TrimSuffix(o.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = p.endpoint
+	}
+	model := o.Model
+	switch {
+	case model != "":
+	case o.Vision:
+		model = p.vision
+	default:
+		model = p.model
+	}
+
+	// A self-hosted endpoint may need no key
+	var key string
+	if p.key != "" {
+		var err error
+		if endpoint == p.endpoint {
+			key, err = secrets.Get(p.key)
+		} else {
+			key, _, err = secrets.Lookup(p.key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p.open(endpoint, model, key), nil
+}
@@ -0,0 +1,138 @@
+package llm
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"GoodnessucWorkflow/internal/httpclient"
+)
+
+// maxTokens bounds the answers of APIs that need a limit
+const maxTokens = 4096
+
+// openAI talks to the chat completions API of OpenAI and the many servers
+// that copy it
+type openAI struct {
+	endpoint, model, key string
+}
+
+func (m *openAI) Complete(ctx context.Context, req Request) (string, error) {
+	var content any = req.Prompt
+	if len(req.Images) > 0 {
+		parts := []map[string]any{{"type": "text", "text": req.Prompt}}
+		for _, img := range req.Images {
+			parts = append(parts, map[string]any{"type": "image_url", "image_url": map[string]string{"url": dataURL(img)}})
+		}
+		content = parts
+	}
+	body := map[string]any{
+		"model": m.model,
+		"messages": []map[string]any{
+			{"role": "system", "content": req.System},
+			{"role": "user", "content": content},
+		},
+	}
+	headers := map[string]string{}
+	if m.key != "" {
+		headers["Authorization"] = "Bearer " + m.key
+	}
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := httpclient.New(timeout).PostJSON(ctx, m.endpoint+"/chat/completions", headers, body, &result); err != nil {
+		return "", err
+	}
+	if len(result.Choices) == 0 {
+		return "", errors.New("the model returned no answer")
+	}
+	return result.Choices[0].Message.Content, nil
+}
+
+// anthropic talks to Anthropic's messages API
+type anthropic struct {
+	endpoint, model, key string
+}
+
+func (m *anthropic) Complete(ctx context.Context, req Request) (string, error) {
+	var content []map[string]any
+	for _, img := range req.Images {
+		content = append(content, map[string]any{"type": "image", "source": map[string]string{
+			"type":       "base64",
+			"media_type": img.MediaType,
+			"data":       base64.StdEncoding.EncodeToString(img.Data),
+		}})
+	}
+	content = append(content, map[string]any{"type": "text", "text": req.Prompt})
+	body := map[string]any{
+		"model":      m.model,
+		"max_tokens": maxTokens,
+		"system":     req.System,
+		"messages": []map[string]any{
+			{"role": "user", "content": content},
+		},
+	}
+	headers := map[string]string{
+		"x-api-key":         m.key,
+		"anthropic-version": "2023-06-01",
+	}
+	var result struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	if err := httpclient.New(timeout).PostJSON(ctx, m.endpoint+"/v1/messages", headers, body, &result); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, c := range result.Content {
+		if c.Type == "text" {
+			b.WriteString(c.Text)
+		}
+	}
+	return b.String(), nil
+}
+
+// ollama talks to a local Ollama server
+type ollama struct {
+	endpoint, model string
+}
+
+func (m *ollama) Complete(ctx context.Context, req Request) (string, error) {
+	user := map[string]any{"role": "user", "content": req.Prompt}
+	if len(req.Images) > 0 {
+		var images []string
+		for _, img := range req.Images {
+			images = append(images, base64.StdEncoding.EncodeToString(img.Data))
+		}
+		user["images"] = images
+	}
+	body := map[string]any{
+		"model":  m.model,
+		"stream": false,
+		"messages": []map[string]any{
+			{"role": "system", "content": req.System},
+			user,
+		},
+	}
+	var result struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	}
+	if err := httpclient.New(timeout).PostJSON(ctx, m.endpoint+"/api/chat", nil, body, &result); err != nil {
+		return "", err
+	}
+	return result.Message.Content, nil
+}
+
+// dataURL returns img as a data: URL
+func dataURL(img Image) string {
+	return "data:" + img.MediaType + ";base64," + base64.StdEncoding.EncodeToString(img.Data)
+}
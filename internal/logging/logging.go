@@ -0,0 +1,166 @@
+// Package logging is the leveled logger shared by the goodness commands.
+// Messages go to stderr, as plain lines or as JSON objects, so results
+// written to stdout stay clean for pipes and scripts
+package logging
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/output"
+)
+
+var (
+	level  = new(slog.LevelVar)
+	mu     sync.Mutex
+	dest   io.Writer = os.Stderr
+	logger *slog.Logger
+)
+
+func init() {
+	setFormat("text")
+}
+
+// AddFlags registers -verbose, -quiet, -log-format, -lang and -output on
+// fs. They take effect as soon as they are parsed
+func AddFlags(fs *flag.FlagSet) {
+	output.AddFlags(fs)
+	fs.Func("lang", "language of messages and help: "+strings.Join(i18n.Languages(), " or ")+" (default from LANG)", i18n.Set)
+	fs.BoolFunc("verbose", "also log debug messages", func(string) error {
+		level.Set(slog.LevelDebug)
+		return nil
+	})
+	fs.BoolFunc("quiet", "only log warnings and errors", func(string) error {
+		level.Set(slog.LevelWarn)
+		return nil
+	})
+	fs.Func("log-format", "log as text or json (default text)", SetFormat)
+}
+
+// SetFormat logs as text or json from now on, as -log-format does
+func SetFormat(format string) error {
+	format = strings.ToLower(format)
+	if format != "text" && format != "json" {
+		return fmt.Errorf("expected text or json")
+	}
+	setFormat(format)
+	return nil
+}
+
+func setFormat(format string) {
+	if format == "json" {
+		logger = slog.New(slog.NewJSONHandler(writer{}, &slog.HandlerOptions{Level: level}))
+	} else {
+		logger = slog.New(&textHandler{})
+	}
+}
+
+// SetOutput sends messages to w rather than stderr, as a front end that
+// takes over the terminal needs, and returns where they went before
+func SetOutput(w io.Writer) io.Writer {
+	mu.Lock()
+	defer mu.Unlock()
+	prev := dest
+	dest = w
+	return prev
+}
+
+// writer writes to the current destination
+type writer struct{}
+
+func (writer) Write(p []byte) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	return dest.Write(p)
+}
+
+// Logger returns the shared logger for callers that want structured
+// attributes rather than formatted messages
+func Logger() *slog.Logger {
+	return logger
+}
+
+// Debugf, Infof, Warnf and Errorf log a formatted message at their level
+func Debugf(format string, args ...any) { logf(slog.LevelDebug, format, args...) }
+func Infof(format string, args ...any)  { logf(slog.LevelInfo, format, args...) }
+func Warnf(format string, args ...any)  { logf(slog.LevelWarn, format, args...) }
+func Errorf(format string, args ...any) { logf(slog.LevelError, format, args...) }
+
+// Fatalf logs an error and exits with exitcode.Error
+func Fatalf(format string, args ...any) {
+	Exitf(exitcode.Error, format, args...)
+}
+
+// Exitf logs an error and exits with code
+func Exitf(code int, format string, args ...any) {
+	logf(slog.LevelError, format, args...)
+	output.Exit(code)
+}
+
+func logf(l slog.Level, format string, args ...any) {
+	format = i18n.T(format)
+	switch {
+	case l >= slog.LevelError:
+		output.Diagnose("error", fmt.Sprintf(format, args...))
+	case l >= slog.LevelWarn:
+		output.Diagnose("warning", fmt.Sprintf(format, args...))
+	}
+	if !logger.Enabled(context.Background(), l) {
+		return
+	}
+	logger.Log(context.Background(), l, fmt.Sprintf(format, args...))
+}
+
+// textHandler writes one line per record: the message alone for info, and
+// prefixed with its level otherwise, followed by any attributes
+type textHandler struct {
+	attrs []slog.Attr
+}
+
+func (h *textHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= level.Level()
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString(i18n.T("error: "))
+	case r.Level >= slog.LevelWarn:
+		b.WriteString(i18n.T("warning: "))
+	case r.Level < slog.LevelInfo:
+		b.WriteString(i18n.T("debug: "))
+	}
+	b.WriteString(r.Message)
+
+	writeAttr := func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+		return true
+	}
+	for _, a := range h.attrs {
+		writeAttr(a)
+	}
+	r.Attrs(writeAttr)
+	b.WriteByte('\n')
+
+	mu.Lock()
+	defer mu.Unlock()
+	_, err := io.WriteString(dest, b.String())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &textHandler{attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+func (h *textHandler) WithGroup(string) slog.Handler {
+	return h
+}
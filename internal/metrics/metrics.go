@@ -0,0 +1,139 @@
+// Package metrics holds the Prometheus metrics of the long-running commands,
+// goodness daemon and goodness serve, which expose them on /metrics
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Error types counted by goodness_errors_total
+const (
+	InvalidRequest = "invalid_request"
+	Unauthorized   = "unauthorized"
+	TooLarge       = "too_large"
+	Unavailable    = "unavailable"
+	Timeout        = "timeout"
+	Transform      = "transform"
+	Workflow       = "workflow"
+	Internal       = "internal"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "goodness_requests_total",
+		Help: "Requests served, by server, route and status code.",
+	}, []string{"server", "route", "code"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "goodness_request_duration_seconds",
+		Help:    "Time taken to serve requests, by server and route.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"server", "route"})
+	filesConverted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "goodness_files_converted_total",
+		Help: "Images converted, by output format.",
+	}, []string{"format"})
+	bytesIn = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "goodness_converted_input_bytes_total",
+		Help: "Size of the images converted.",
+	})
+	bytesOut = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "goodness_converted_output_bytes_total",
+		Help: "Size of the images the conversions produced.",
+	})
+	bytesSaved = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "goodness_bytes_saved_total",
+		Help: "Bytes saved by conversions that made an image smaller.",
+	})
+	transformDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "goodness_transform_duration_seconds",
+		Help:    "Time taken by transforms, by kind: image or markdown.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 16),
+	}, []string{"transform"})
+	errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "goodness_errors_total",
+		Help: "Failed requests and workflow runs, by type.",
+	}, []string{"type"})
+	workflowRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "goodness_daemon_runs_total",
+		Help: "Workflow runs started by the daemon, by rule and result: ok, failed or skipped.",
+	}, []string{"rule", "result"})
+	workflowDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "goodness_daemon_run_duration_seconds",
+		Help:    "Time taken by the daemon's workflow runs, by rule.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 4, 10),
+	}, []string{"rule"})
+	workflowFiles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "goodness_daemon_files_total",
+		Help: "Changed files handed to the daemon's workflows, by rule.",
+	}, []string{"rule"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests, requestDuration,
+		filesConverted, bytesIn, bytesOut, bytesSaved, transformDuration,
+		errorsTotal,
+		workflowRuns, workflowDuration, workflowFiles,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Request records a request served by server, api or grpc. route is the
+// pattern or method that handled it, never the raw path, to keep the number
+// of series bounded
+func Request(server, route, code string, took time.Duration) {
+	requests.WithLabelValues(server, route, code).Inc()
+	requestDuration.WithLabelValues(server, route).Observe(took.Seconds())
+}
+
+// Converted records an image conversion from in bytes to out bytes
+func Converted(format string, in, out int, took time.Duration) {
+	filesConverted.WithLabelValues(format).Inc()
+	bytesIn.Add(float64(in))
+	bytesOut.Add(float64(out))
+	if out < in {
+		bytesSaved.Add(float64(in - out))
+	}
+	transformDuration.WithLabelValues("image").Observe(took.Seconds())
+}
+
+// Transformed records a markdown transform
+func Transformed(took time.Duration) {
+	transformDuration.WithLabelValues("markdown").Observe(took.Seconds())
+}
+
+// Error counts one error of the given type
+func Error(kind string) {
+	errorsTotal.WithLabelValues(kind).Inc()
+}
+
+// Run records a finished daemon workflow run for rule that handled files
+func Run(rule string, ok bool, files int, took time.Duration) {
+	result := "ok"
+	if !ok {
+		result = "failed"
+		Error(Workflow)
+	}
+	workflowRuns.WithLabelValues(rule, result).Inc()
+	workflowDuration.WithLabelValues(rule).Observe(took.Seconds())
+	workflowFiles.WithLabelValues(rule).Add(float64(files))
+}
+
+// Skipped records a scheduled run of rule skipped as the last one was still
+// running
+func Skipped(rule string) {
+	workflowRuns.WithLabelValues(rule, "skipped").Inc()
+}
@@ -0,0 +1,74 @@
+// Package notify posts messages to Slack and Discord incoming webhooks
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"GoodnessucWorkflow/internal/httpclient"
+	"GoodnessucWorkflow/pkg/errs"
+)
+
+// Services
+const (
+	Slack   = "slack"
+	Discord = "discord"
+)
+
+// discordLimit is the longest message Discord accepts, in characters
+const discordLimit = 2000
+
+var client = httpclient.New(30 * time.Second)
+
+// Send posts text to the webhook of service at webhook
+func Send(ctx context.Context, service, webhook, text string) error {
+	var payload map[string]string
+	switch service {
+	case Slack:
+		payload = map[string]string{"text": text}
+	case Discord:
+		if runes := []rune(text); len(runes) > discordLimit {
+			text = string(runes[:discordLimit-1]) + "…"
+		}
+		payload = map[string]string{"content": text}
+	default:
+		return fmt.Errorf("unknown service %q", service)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return hideURL(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return hideURL(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &errs.RemoteError{Service: service, Status: resp.Status, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return nil
+}
+
+// hideURL drops the URL from err, as a webhook's URL is its secret
+func hideURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
@@ -0,0 +1,188 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Annotation is a problem reported at a file, and a line when known, for
+// the CI system to show inline
+type Annotation struct {
+	Level   string
+	Path    string
+	Line    int
+	Title   string
+	Message string
+}
+
+var (
+	ci          string
+	annotations []Annotation
+	annotated   = make(map[string]bool)
+)
+
+// setCI selects the CI system -ci annotates for
+func setCI(value string) error {
+	switch strings.ToLower(value) {
+	case "none":
+		ci = ""
+	case "github":
+		ci = "github"
+	default:
+		return errors.New("expected none or github")
+	}
+	return nil
+}
+
+// CI reports the CI system -ci selected, or "" when there is none
+func CI() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return ci
+}
+
+// Annotate reports a problem in a file to the CI system. level is error or
+// warning and line is 0 when the problem is not at a line. Failed files
+// recorded by Processed are annotated on their own unless annotated here
+// first
+func Annotate(level, path string, line int, title, message string) {
+	mu.Lock()
+	defer mu.Unlock()
+	annotate(Annotation{Level: level, Path: path, Line: line, Title: title, Message: message})
+}
+
+// errorLine finds the line a decoding error mentions, as in "yaml: line 12:"
+var errorLine = regexp.MustCompile(`\bline (\d+)\b`)
+
+// AnnotateError annotates err against the file it came from, at the line
+// the error mentions if any, for errors such as an invalid workflow file
+func AnnotateError(path, title string, err error) {
+	line := 0
+	if m := errorLine.FindStringSubmatch(err.Error()); m != nil {
+		line, _ = strconv.Atoi(m[1])
+	}
+	Annotate("error", path, line, title, err.Error())
+}
+
+func annotate(a Annotation) {
+	if ci == "" {
+		return
+	}
+	annotated[a.Path] = true
+	a.Path = workspacePath(a.Path)
+	annotations = append(annotations, a)
+
+	props := []string{"file=" + escapeProperty(a.Path)}
+	if a.Line > 0 {
+		props = append(props, "line="+strconv.Itoa(a.Line))
+	}
+	if a.Title != "" {
+		props = append(props, "title="+escapeProperty(a.Title))
+	}
+	fmt.Fprintf(annotationWriter(), "::%s %s::%s\n", a.Level, strings.Join(props, ","), escapeData(a.Message))
+}
+
+// workspacePath makes an absolute path relative to the checkout, since
+// annotations only attach to files named from the repository root
+func workspacePath(path string) string {
+	if !filepath.IsAbs(path) {
+		return filepath.ToSlash(path)
+	}
+	root := os.Getenv("GITHUB_WORKSPACE")
+	if root == "" {
+		root, _ = os.Getwd()
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// annotationWriter is where workflow commands go: stdout, unless it is kept
+// for the JSON document. The runner reads commands from both
+func annotationWriter() io.Writer {
+	if enabled {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+var dataEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+var propertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+
+func escapeData(s string) string     { return dataEscaper.Replace(s) }
+func escapeProperty(s string) string { return propertyEscaper.Replace(s) }
+
+// writeSummary appends a markdown report of the run to the job summary
+// named by GITHUB_STEP_SUMMARY, when the runner set one
+func writeSummary() {
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if ci != "github" || path == "" {
+		return
+	}
+
+	var b strings.Builder
+	status := "passed"
+	if doc.ExitCode != 0 {
+		status = fmt.Sprintf("failed (exit code %d)", doc.ExitCode)
+	}
+	name := "goodness"
+	if doc.Command != "" {
+		name += " " + doc.Command
+	}
+	fmt.Fprintf(&b, "### %s: %s\n\n", name, status)
+	counts := make([]string, 0, len(doc.Stats))
+	for _, key := range []string{"files", "ok", "fixed", "skipped", "failed", "outputs"} {
+		if n, ok := doc.Stats[key]; ok && (n > 0 || key == "files") {
+			counts = append(counts, fmt.Sprintf("%d %s", n, key))
+		}
+	}
+	fmt.Fprintf(&b, "%s\n\n", strings.Join(counts, ", "))
+
+	if len(annotations) > 0 {
+		b.WriteString("| File | Line | Check | Problem |\n|---|---|---|---|\n")
+		for _, a := range annotations {
+			line := ""
+			if a.Line > 0 {
+				line = strconv.Itoa(a.Line)
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", escapeCell(a.Path), line, escapeCell(a.Title), escapeCell(a.Message))
+		}
+		b.WriteString("\n")
+	}
+	var other []Diagnostic
+	for _, d := range doc.Diagnostics {
+		if d.Level == "error" {
+			other = append(other, d)
+		}
+	}
+	if len(annotations) == 0 && len(other) > 0 {
+		for _, d := range other {
+			fmt.Fprintf(&b, "- %s\n", d.Message)
+		}
+		b.WriteString("\n")
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err == nil {
+		_, err = io.WriteString(f, b.String())
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: Failed to write the job summary: %s\n", err)
+	}
+}
+
+// escapeCell keeps text on one row of a markdown table
+func escapeCell(s string) string {
+	return strings.NewReplacer("|", "\\|", "\r", " ", "\n", " ").Replace(s)
+}
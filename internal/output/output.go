@@ -0,0 +1,189 @@
+// Package output implements -output json, which replaces the prose a
+// command prints with one JSON document on stdout once it exits. Commands
+// record the files they processed, the files they wrote and any results of
+// their own here; warnings and errors logged during the run become its
+// diagnostics. Prose the command still prints goes to stderr instead.
+//
+// It also implements -ci github, which turns failed files and the problems
+// commands annotate into GitHub Actions workflow commands and appends a
+// summary of the run to the job summary
+package output
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"GoodnessucWorkflow/pkg/errs"
+)
+
+// Document is what -output json prints
+type Document struct {
+	Command     string         `json:"command"`
+	ExitCode    int            `json:"exit_code"`
+	Files       []File         `json:"files"`
+	Outputs     []string       `json:"outputs"`
+	Stats       map[string]int `json:"stats"`
+	Data        map[string]any `json:"data,omitempty"`
+	Diagnostics []Diagnostic   `json:"diagnostics"`
+}
+
+// File is the outcome for one input
+type File struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Stage  string `json:"stage,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Cause names what kind of failure Error is, as errs.Kind does
+	Cause string `json:"cause,omitempty"`
+}
+
+// Diagnostic is a warning or error logged during the run
+type Diagnostic struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+var (
+	mu      sync.Mutex
+	enabled bool
+	stdout  *os.File
+	doc     = Document{Files: []File{}, Outputs: []string{}, Diagnostics: []Diagnostic{}}
+
+	// beforeExit runs as Exit starts
+	beforeExit []func(code int) int
+)
+
+// AddFlags registers -output and -ci on fs
+func AddFlags(fs *flag.FlagSet) {
+	fs.Func("output", "print results as text or as one json document (default text)", func(value string) error {
+		switch strings.ToLower(value) {
+		case "text":
+			return nil
+		case "json":
+			Enable()
+			return nil
+		}
+		return errors.New("expected text or json")
+	})
+	fs.Func("ci", "annotate failures inline and write a job summary for a CI system: none or github", func(value string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return setCI(value)
+	})
+}
+
+// Enable switches to JSON output. Anything written to os.Stdout from then
+// on goes to stderr, keeping stdout for the document
+func Enable() {
+	mu.Lock()
+	defer mu.Unlock()
+	if enabled {
+		return
+	}
+	enabled = true
+	stdout, os.Stdout = os.Stdout, os.Stderr
+}
+
+// JSON reports whether -output json is in effect
+func JSON() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enabled
+}
+
+// SetCommand names the command the document describes, such as img convert
+func SetCommand(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	doc.Command = name
+}
+
+// Processed records the outcome for an input: ok, cached, failed or
+// skipped, with the stage that failed and its error
+func Processed(path, status, stage string, err error) {
+	f := File{Path: path, Status: status, Stage: stage}
+	if err != nil {
+		f.Error = err.Error()
+		f.Cause = errs.Kind(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	doc.Files = append(doc.Files, f)
+	if status == "failed" && !annotated[path] {
+		message := f.Error
+		if message == "" {
+			message = "failed"
+		}
+		annotate(Annotation{Level: "error", Path: path, Title: strings.TrimSpace("goodness " + stage), Message: message})
+	}
+}
+
+// Wrote records a file the command created or changed
+func Wrote(path string) {
+	mu.Lock()
+	defer mu.Unlock()
+	doc.Outputs = append(doc.Outputs, path)
+}
+
+// Set records a result of the command under key, such as the image details
+// img info prints
+func Set(key string, value any) {
+	mu.Lock()
+	defer mu.Unlock()
+	if doc.Data == nil {
+		doc.Data = make(map[string]any)
+	}
+	doc.Data[key] = value
+}
+
+// Diagnose records a logged warning or error
+func Diagnose(level, message string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if enabled || ci != "" {
+		doc.Diagnostics = append(doc.Diagnostics, Diagnostic{Level: level, Message: message})
+	}
+}
+
+// BeforeExit registers f to run when Exit is called, before the document
+// is written. f receives the exit code and returns the one to use instead
+func BeforeExit(f func(code int) int) {
+	mu.Lock()
+	defer mu.Unlock()
+	beforeExit = append(beforeExit, f)
+}
+
+// Exit prints the document when -output json is in effect, writes the job
+// summary under -ci github and exits with code
+func Exit(code int) {
+	mu.Lock()
+	hooks := beforeExit
+	// A hook that exits itself does not run again
+	beforeExit = nil
+	mu.Unlock()
+	for _, f := range hooks {
+		code = f(code)
+	}
+
+	mu.Lock()
+	doc.ExitCode = code
+	doc.Stats = map[string]int{"files": len(doc.Files), "outputs": len(doc.Outputs)}
+	for _, f := range doc.Files {
+		doc.Stats[f.Status]++
+	}
+	writeSummary()
+	if enabled {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(doc); err != nil {
+			fmt.Fprintf(os.Stderr, "error: Failed to write JSON: %s\n", err)
+		}
+	}
+	mu.Unlock()
+	os.Exit(code)
+}
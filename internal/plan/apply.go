@@ -0,0 +1,212 @@
+package plan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/pkg/errs"
+)
+
+// Error reports the step that stopped Apply and how undoing the steps before
+// it went
+type Error struct {
+	Step   int
+	Op     Step
+	Err    error
+	Undone int
+	// UndoErrs lists the completed steps that could not be undone; their
+	// changes are still on disk
+	UndoErrs []error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("step %d (%s) failed: %s", e.Step, e.Op, e.Err)
+	if errors.Is(e.Err, context.Canceled) {
+		msg = fmt.Sprintf("stopped before step %d (%s)", e.Step, e.Op)
+	}
+	if len(e.UndoErrs) > 0 {
+		return fmt.Sprintf("%s; undoing the completed steps failed too, check the files by hand: %s", msg, errors.Join(e.UndoErrs...))
+	}
+	return fmt.Sprintf("%s; undid %d completed steps", msg, e.Undone)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (s Step) String() string {
+	if s.From != "" {
+		return fmt.Sprintf("%s %s -> %s", s.Op, s.From, s.Path)
+	}
+	return s.Op + " " + s.Path
+}
+
+// Check compares the files the plan consumes with their state when it was
+// made and returns one error for each that changed or went missing
+func (p *Plan) Check() []error {
+	var errs []error
+	for _, s := range p.Steps {
+		if s.ModTime == nil {
+			continue
+		}
+		path := s.consumes()
+		info, err := os.Lstat(path)
+		switch {
+		case os.IsNotExist(err):
+			errs = append(errs, fmt.Errorf("%s no longer exists", path))
+		case err != nil:
+			errs = append(errs, err)
+		case info.Size() != s.Size || !info.ModTime().Equal(*s.ModTime):
+			errs = append(errs, fmt.Errorf("%s changed since the plan was made", path))
+		}
+	}
+	return errs
+}
+
+// Apply runs the steps in order. When one fails, or ctx is done before it
+// starts, the steps already done are undone in reverse and the returned
+// *Error says how that went. Deleted and replaced files are kept under a
+// hidden name until every step has succeeded
+func (p *Plan) Apply(ctx context.Context) error {
+	undos := make([]func() error, len(p.Steps))
+	backups := make([]string, len(p.Steps))
+	for i, s := range p.Steps {
+		var undo func() error
+		var backup string
+		err := ctx.Err()
+		if err == nil {
+			undo, backup, err = s.run(i)
+		}
+		if err != nil {
+			e := &Error{Step: i + 1, Op: s, Err: err}
+			for j := i - 1; j >= 0; j-- {
+				if undos[j] == nil {
+					continue
+				}
+				if err := undos[j](); err != nil {
+					e.UndoErrs = append(e.UndoErrs, fmt.Errorf("step %d (%s): %w", j+1, p.Steps[j], err))
+					continue
+				}
+				e.Undone++
+			}
+			return e
+		}
+		undos[i], backups[i] = undo, backup
+	}
+
+	// Only now is the plan's outcome known, so only now is it journaled
+	for i, s := range p.Steps {
+		switch {
+		case undos[i] == nil:
+		case s.Op == Move:
+			journal.Moved(s.From, s.Path)
+		case s.Op == Delete:
+			journal.Removed(s.Path, backups[i])
+		case s.Op == Link:
+			journal.Replaced(s.Path, backups[i])
+		default:
+			journal.Created(s.Path)
+		}
+		if backups[i] != "" {
+			if err := os.Remove(backups[i]); err != nil {
+				logging.Warnf("Failed to remove backup: %s", err)
+			}
+		}
+	}
+	return nil
+}
+
+// run carries out one step, returning how to undo it and the backup of any
+// file it removed. A directory that already exists has no undo
+func (s Step) run(i int) (undo func() error, backup string, err error) {
+	switch s.Op {
+	case Mkdir:
+		if err := os.Mkdir(s.Path, 0755); err != nil {
+			if info, statErr := os.Stat(s.Path); statErr == nil && info.IsDir() {
+				// Made since the plan was; nothing to undo
+				return nil, "", nil
+			}
+			return nil, "", err
+		}
+		return func() error { return os.Remove(s.Path) }, "", nil
+
+	case Move:
+		if err := absent("move", s.Path); err != nil {
+			return nil, "", err
+		}
+		if err := fsutil.MoveFile(s.From, s.Path); err != nil {
+			return nil, "", err
+		}
+		return func() error { return fsutil.MoveFile(s.Path, s.From) }, "", nil
+
+	case Copy:
+		if err := fsutil.CopyFile(s.From, s.Path); err != nil {
+			return nil, "", err
+		}
+		return func() error { return os.Remove(s.Path) }, "", nil
+
+	case Write:
+		if err := absent("write", s.Path); err != nil {
+			return nil, "", err
+		}
+		mode := s.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+		if err := fsutil.WriteFileAtomic(s.Path, s.Data, mode); err != nil {
+			return nil, "", err
+		}
+		if s.SetModTime != nil {
+			if err := os.Chtimes(s.Path, time.Now(), *s.SetModTime); err != nil {
+				os.Remove(s.Path)
+				return nil, "", err
+			}
+		}
+		return func() error { return os.Remove(s.Path) }, "", nil
+
+	case Delete:
+		backup = backupPath(s.Path, i)
+		if err := fsutil.Rename(s.Path, backup); err != nil {
+			return nil, "", err
+		}
+		return func() error { return fsutil.Rename(backup, s.Path) }, backup, nil
+
+	case Link:
+		backup = backupPath(s.Path, i)
+		if err := fsutil.Rename(s.Path, backup); err != nil {
+			return nil, "", err
+		}
+		if err := os.Link(s.From, s.Path); err != nil {
+			fsutil.Rename(backup, s.Path)
+			return nil, "", err
+		}
+		return func() error {
+			if err := os.Remove(s.Path); err != nil {
+				return err
+			}
+			return fsutil.Rename(backup, s.Path)
+		}, backup, nil
+	}
+	return nil, "", fmt.Errorf("unknown op %q", s.Op)
+}
+
+// absent fails when path exists, since moves and writes never overwrite
+func absent(op, path string) error {
+	if _, err := os.Lstat(path); err == nil {
+		return errs.Exists(op, path)
+	}
+	return nil
+}
+
+// backupPath is the hidden name a removed file waits under, next to it so
+// restoring it is a rename
+func backupPath(path string, step int) string {
+	return filepath.Join(filepath.Dir(path), fmt.Sprintf(".goodness-apply-%d-%d-%s", os.Getpid(), step+1, filepath.Base(path)))
+}
@@ -0,0 +1,256 @@
+// Package plan records the writes, moves and deletions a batch command
+// intends to make so they can be reviewed and saved, then carried out later
+// by goodness apply, which undoes the steps already done when one fails
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"GoodnessucWorkflow/internal/fsutil"
+)
+
+// Version is the plan file format written by this build
+const Version = 1
+
+// Step kinds
+const (
+	Mkdir  = "mkdir"
+	Move   = "move"
+	Copy   = "copy"
+	Write  = "write"
+	Delete = "delete"
+	Link   = "link"
+)
+
+// Step is one change. Path is the file or directory the step creates,
+// replaces or deletes; From is the file a move, copy or link starts from.
+// Size and ModTime describe the file a step consumes as it was when the plan
+// was made, so apply can refuse a plan the files have moved on from. A write
+// carries its Data, Mode and the SetModTime to give the file
+type Step struct {
+	Op         string      `json:"op"`
+	Path       string      `json:"path"`
+	From       string      `json:"from,omitempty"`
+	Size       int64       `json:"size,omitempty"`
+	ModTime    *time.Time  `json:"mod_time,omitempty"`
+	Data       []byte      `json:"data,omitempty"`
+	Mode       os.FileMode `json:"mode,omitempty"`
+	SetModTime *time.Time  `json:"set_mod_time,omitempty"`
+}
+
+// Plan is an ordered list of steps and the command that made it
+type Plan struct {
+	Version int       `json:"version"`
+	Command string    `json:"command"`
+	Created time.Time `json:"created"`
+	Steps   []Step    `json:"steps"`
+
+	// planned maps paths earlier steps create to true and paths they
+	// remove to false
+	planned map[string]bool
+}
+
+// New starts an empty plan for command, such as "img rename"
+func New(command string) *Plan {
+	return &Plan{Version: Version, Command: command, Created: time.Now().UTC(), Steps: []Step{}}
+}
+
+// Exists reports whether path will exist once the steps so far have run
+func (p *Plan) Exists(path string) bool {
+	if exists, ok := p.planned[abs(path)]; ok {
+		return exists
+	}
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// MkdirAll plans the creation of dir and any missing parents
+func (p *Plan) MkdirAll(dir string) {
+	dir = abs(dir)
+	if p.Exists(dir) {
+		return
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		p.MkdirAll(parent)
+	}
+	p.add(Step{Op: Mkdir, Path: dir})
+}
+
+// Move plans renaming from to path
+func (p *Plan) Move(from, path string) {
+	p.add(Step{Op: Move, From: abs(from), Path: abs(path)})
+}
+
+// Copy plans copying from to a new file path
+func (p *Plan) Copy(from, path string) {
+	p.add(Step{Op: Copy, From: abs(from), Path: abs(path)})
+}
+
+// Write plans writing data to a new file path. A non-zero modTime is set on
+// the file afterwards
+func (p *Plan) Write(path string, data []byte, mode os.FileMode, modTime time.Time) {
+	s := Step{Op: Write, Path: abs(path), Data: data, Mode: mode}
+	if !modTime.IsZero() {
+		s.SetModTime = &modTime
+	}
+	p.add(s)
+}
+
+// Delete plans removing path
+func (p *Plan) Delete(path string) {
+	p.add(Step{Op: Delete, Path: abs(path)})
+}
+
+// Link plans replacing path with a hard link to from
+func (p *Plan) Link(from, path string) {
+	p.add(Step{Op: Link, From: abs(from), Path: abs(path)})
+}
+
+// add records the state of the file s consumes and appends it
+func (p *Plan) add(s Step) {
+	if p.planned == nil {
+		p.planned = make(map[string]bool)
+	}
+	// A file an earlier step creates has no state to check yet
+	if consumed := s.consumes(); consumed != "" {
+		if _, planned := p.planned[consumed]; !planned {
+			if info, err := os.Lstat(consumed); err == nil {
+				modTime := info.ModTime()
+				s.Size, s.ModTime = info.Size(), &modTime
+			}
+		}
+	}
+	switch s.Op {
+	case Move:
+		p.planned[s.From] = false
+		p.planned[s.Path] = true
+	case Delete:
+		p.planned[s.Path] = false
+	default:
+		p.planned[s.Path] = true
+	}
+	p.Steps = append(p.Steps, s)
+}
+
+// consumes returns the file whose contents the step depends on: the one it
+// moves or copies, or the one it deletes or replaces
+func (s Step) consumes() string {
+	switch s.Op {
+	case Move, Copy:
+		return s.From
+	case Delete, Link:
+		return s.Path
+	}
+	return ""
+}
+
+// Save writes the plan as JSON to path
+func (p *Plan) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(path, append(data, '\n'), 0644)
+}
+
+// Load reads a plan written by Save
+func Load(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &Plan{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("not a plan file: %s", err)
+	}
+	if p.Version != Version {
+		return nil, fmt.Errorf("plan format version %d is not supported; this build reads version %d", p.Version, Version)
+	}
+	for i, s := range p.Steps {
+		if err := s.validate(); err != nil {
+			return nil, fmt.Errorf("step %d: %s", i+1, err)
+		}
+	}
+	return p, nil
+}
+
+func (s Step) validate() error {
+	switch s.Op {
+	case Mkdir, Write, Delete:
+	case Move, Copy, Link:
+		if s.From == "" {
+			return fmt.Errorf("%s without a from path", s.Op)
+		}
+	default:
+		return fmt.Errorf("unknown op %q", s.Op)
+	}
+	if s.Path == "" {
+		return fmt.Errorf("%s without a path", s.Op)
+	}
+	return nil
+}
+
+// Print lists the steps followed by the Summary
+func (p *Plan) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, s := range p.Steps {
+		switch {
+		case s.From != "":
+			fmt.Fprintf(tw, "  %s\t%s -> %s\n", s.Op, s.From, s.Path)
+		case s.Op == Write:
+			fmt.Fprintf(tw, "  %s\t%s (%s)\n", s.Op, s.Path, fsutil.FormatBytes(int64(len(s.Data))))
+		default:
+			fmt.Fprintf(tw, "  %s\t%s\n", s.Op, s.Path)
+		}
+	}
+	tw.Flush()
+	if len(p.Steps) > 0 {
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, p.Summary())
+}
+
+// Summary counts the steps of each kind, such as "Plan: 2 mkdir, 14 move"
+func (p *Plan) Summary() string {
+	if len(p.Steps) == 0 {
+		return "Plan: no changes"
+	}
+	counts := make(map[string]int)
+	for _, s := range p.Steps {
+		counts[s.Op]++
+	}
+	var parts []string
+	for _, op := range []string{Mkdir, Move, Copy, Write, Link, Delete} {
+		if counts[op] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[op], op))
+		}
+	}
+	return "Plan: " + strings.Join(parts, ", ")
+}
+
+// Targets lists the files the plan creates or replaces, for output.Wrote
+func (p *Plan) Targets() []string {
+	var paths []string
+	for _, s := range p.Steps {
+		switch s.Op {
+		case Move, Copy, Write, Link:
+			paths = append(paths, s.Path)
+		}
+	}
+	return paths
+}
+
+// abs makes plans independent of the directory apply runs in
+func abs(path string) string {
+	if a, err := filepath.Abs(path); err == nil {
+		return a
+	}
+	return filepath.Clean(path)
+}
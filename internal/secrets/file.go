@@ -0,0 +1,247 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/term"
+
+	"GoodnessucWorkflow/internal/config"
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/i18n"
+)
+
+// fileVersion changes when the layout of the secrets file does
+const fileVersion = 1
+
+// pbkdf2Iterations is the cost of deriving the key from the passphrase
+const pbkdf2Iterations = 600_000
+
+// sealedFile is the secrets file on disk: a JSON object of secrets by key,
+// encrypted with AES-256-GCM
+type sealedFile struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// fileStore is the encrypted file fallback. Its passphrase is asked for
+// once per process, when a secret is first read or written
+type fileStore struct {
+	path string
+
+	mu         sync.Mutex
+	passphrase []byte
+}
+
+var (
+	fileOnce sync.Once
+	file     *fileStore
+)
+
+// encryptedFile returns the secrets file next to the global configuration
+// file
+func encryptedFile() *fileStore {
+	fileOnce.Do(func() {
+		file = &fileStore{}
+		if global, err := config.GlobalPath(); err == nil {
+			file.path = filepath.Join(filepath.Dir(global), "secrets.enc")
+		}
+	})
+	return file
+}
+
+// FilePath returns the encrypted file secrets fall back to
+func FilePath() string {
+	return encryptedFile().path
+}
+
+func (f *fileStore) name() string {
+	return "file"
+}
+
+func (f *fileStore) get(key string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secrets, err := f.read()
+	if err != nil {
+		return "", err
+	}
+	value, ok := secrets[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (f *fileStore) set(key, value string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secrets, err := f.read()
+	if err != nil {
+		return err
+	}
+	secrets[key] = value
+	return f.write(secrets)
+}
+
+func (f *fileStore) delete(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	secrets, err := f.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[key]; !ok {
+		return ErrNotFound
+	}
+	delete(secrets, key)
+	return f.write(secrets)
+}
+
+// unlocked reports whether the file can be read without asking for the
+// passphrase
+func (f *fileStore) unlocked() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.passphrase != nil || os.Getenv("GOODNESS_SECRETS_PASSPHRASE") != "" {
+		return true
+	}
+	_, err := os.Stat(f.path)
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// read decrypts the file; a missing file holds no secrets and needs no
+// passphrase
+func (f *fileStore) read() (map[string]string, error) {
+	if f.path == "" {
+		return nil, errors.New("no configuration folder for the secrets file")
+	}
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(map[string]string), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sealed sealedFile
+	if err := json.Unmarshal(data, &sealed); err != nil || sealed.Version != fileVersion {
+		return nil, fmt.Errorf("%s is not a secrets file this version can read", f.path)
+	}
+	passphrase, err := f.passphraseFor(false)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(passphrase, sealed.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, sealed.Nonce, sealed.Ciphertext, nil)
+	if err != nil {
+		f.passphrase = nil
+		return nil, fmt.Errorf("wrong passphrase for %s", f.path)
+	}
+	secrets := make(map[string]string)
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// write encrypts secrets with a fresh salt and nonce and replaces the file
+func (f *fileStore) write(secrets map[string]string) error {
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	passphrase, err := f.passphraseFor(true)
+	if err != nil {
+		return err
+	}
+	sealed := sealedFile{Version: fileVersion, Salt: make([]byte, 16)}
+	rand.Read(sealed.Salt)
+	gcm, err := newGCM(passphrase, sealed.Salt)
+	if err != nil {
+		return err
+	}
+	sealed.Nonce = make([]byte, gcm.NonceSize())
+	rand.Read(sealed.Nonce)
+	sealed.Ciphertext = gcm.Seal(nil, sealed.Nonce, plain, nil)
+
+	data, err := json.Marshal(sealed)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0700); err != nil {
+		return err
+	}
+	return fsutil.WriteFileAtomic(f.path, data, 0600)
+}
+
+func newGCM(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, string(passphrase), salt, pbkdf2Iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// passphraseFor returns the passphrase from GOODNESS_SECRETS_PASSPHRASE or
+// the terminal, asking twice when creating the file
+func (f *fileStore) passphraseFor(creating bool) ([]byte, error) {
+	if f.passphrase != nil {
+		return f.passphrase, nil
+	}
+	if p := os.Getenv("GOODNESS_SECRETS_PASSPHRASE"); p != "" {
+		f.passphrase = []byte(p)
+		return f.passphrase, nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		tty = os.Stdin
+	} else {
+		defer tty.Close()
+	}
+	if !term.IsTerminal(int(tty.Fd())) {
+		return nil, fmt.Errorf("secrets in %s need GOODNESS_SECRETS_PASSPHRASE or a terminal to ask for the passphrase", f.path)
+	}
+	if _, err := os.Stat(f.path); err == nil {
+		creating = false
+	}
+	fmt.Fprint(os.Stderr, i18n.Sprintf("Passphrase for %s: ", f.path))
+	p, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(p) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	if creating {
+		fmt.Fprint(os.Stderr, i18n.T("Repeat the passphrase: "))
+		again, err := term.ReadPassword(int(tty.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if string(again) != string(p) {
+			return nil, errors.New("the passphrases differ")
+		}
+	}
+	f.passphrase = p
+	return p, nil
+}
@@ -0,0 +1,71 @@
+package secrets
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// securityNotFound is the status security exits with for a missing item
+const securityNotFound = 44
+
+// macKeychain keeps secrets in the login keychain through the security
+// tool, as generic passwords of the goodness service
+type macKeychain struct {
+	path string
+}
+
+func systemKeychain() (store, bool) {
+	path, err := exec.LookPath("security")
+	if err != nil {
+		return nil, false
+	}
+	return macKeychain{path: path}, true
+}
+
+func (k macKeychain) name() string {
+	return "keychain"
+}
+
+func (k macKeychain) get(key string) (string, error) {
+	out, err := k.run(nil, "find-generic-password", "-s", keychainService, "-a", key, "-w")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// set passes the secret hex encoded on security's standard input rather
+// than as an argument, where other users could see it
+func (k macKeychain) set(key, value string) error {
+	command := fmt.Sprintf("add-generic-password -U -s %s -a %s -l %q -X %s\n", keychainService, key, "goodness "+key, hex.EncodeToString([]byte(value)))
+	_, err := k.run(strings.NewReader(command), "-i")
+	return err
+}
+
+func (k macKeychain) delete(key string) error {
+	_, err := k.run(nil, "delete-generic-password", "-s", keychainService, "-a", key)
+	return err
+}
+
+func (k macKeychain) run(stdin *strings.Reader, args ...string) ([]byte, error) {
+	cmd := exec.Command(k.path, args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == securityNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("keychain: %s", cmp.Or(strings.TrimSpace(stderr.String()), err.Error()))
+	}
+	return out, nil
+}
@@ -0,0 +1,75 @@
+//go:build !darwin && !windows
+
+package secrets
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// secretService keeps secrets in the Secret Service, such as GNOME Keyring
+// or KWallet, through secret-tool
+type secretService struct {
+	path string
+}
+
+// systemKeychain needs secret-tool and a session bus to reach the service
+// through
+func systemKeychain() (store, bool) {
+	path, err := exec.LookPath("secret-tool")
+	if err != nil || os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		return nil, false
+	}
+	return secretService{path: path}, true
+}
+
+func (s secretService) name() string {
+	return "keychain"
+}
+
+func (s secretService) get(key string) (string, error) {
+	out, err := s.run(nil, "lookup", "service", keychainService, "key", key)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(out) == 0 {
+		// secret-tool fails without a message for a missing item
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// set passes the secret on secret-tool's standard input rather than as an
+// argument, where other users could see it
+func (s secretService) set(key, value string) error {
+	_, err := s.run(strings.NewReader(value), "store", "--label=goodness "+key, "service", keychainService, "key", key)
+	return err
+}
+
+func (s secretService) delete(key string) error {
+	if _, err := s.get(key); err != nil {
+		return err
+	}
+	_, err := s.run(nil, "clear", "service", keychainService, "key", key)
+	return err
+}
+
+func (s secretService) run(stdin *strings.Reader, args ...string) ([]byte, error) {
+	cmd := exec.Command(s.path, args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil && stderr.Len() > 0 {
+		return out, fmt.Errorf("secret service: %s", cmp.Or(strings.TrimSpace(stderr.String()), err.Error()))
+	}
+	return out, err
+}
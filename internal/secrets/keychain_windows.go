@@ -0,0 +1,114 @@
+package secrets
+
+import (
+	"errors"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	advapi32        = windows.NewLazySystemDLL("advapi32.dll")
+	procCredReadW   = advapi32.NewProc("CredReadW")
+	procCredWriteW  = advapi32.NewProc("CredWriteW")
+	procCredDeleteW = advapi32.NewProc("CredDeleteW")
+	procCredFree    = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric      = 1
+	credPersistLocalUser = 2
+)
+
+// credential is CREDENTIALW
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        windows.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// credentialManager keeps secrets in the Windows Credential Manager as
+// generic credentials named goodness:KEY
+type credentialManager struct{}
+
+func systemKeychain() (store, bool) {
+	return credentialManager{}, procCredReadW.Find() == nil
+}
+
+func target(key string) (*uint16, error) {
+	return windows.UTF16PtrFromString(keychainService + ":" + key)
+}
+
+func (credentialManager) name() string {
+	return "keychain"
+}
+
+func (credentialManager) get(key string) (string, error) {
+	name, err := target(key)
+	if err != nil {
+		return "", err
+	}
+	var cred *credential
+	ok, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(name)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ok == 0 {
+		return "", notFound(err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+func (credentialManager) set(key, value string) error {
+	name, err := target(key)
+	if err != nil {
+		return err
+	}
+	user, err := windows.UTF16PtrFromString(key)
+	if err != nil {
+		return err
+	}
+	blob := []byte(value)
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         name,
+		CredentialBlobSize: uint32(len(blob)),
+		Persist:            credPersistLocalUser,
+		UserName:           user,
+	}
+	if len(blob) > 0 {
+		cred.CredentialBlob = &blob[0]
+	}
+	ok, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0)
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
+
+func (credentialManager) delete(key string) error {
+	name, err := target(key)
+	if err != nil {
+		return err
+	}
+	ok, _, err := procCredDeleteW.Call(uintptr(unsafe.Pointer(name)), credTypeGeneric, 0)
+	if ok == 0 {
+		return notFound(err)
+	}
+	return nil
+}
+
+// notFound turns ERROR_NOT_FOUND into ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, windows.ERROR_NOT_FOUND) {
+		return ErrNotFound
+	}
+	return err
+}
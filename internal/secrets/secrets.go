@@ -0,0 +1,242 @@
+// Package secrets keeps the tokens goodness publishes and uploads with out
+// of plaintext configuration. Each secret is named after the environment
+// variable that would otherwise hold it, such as IMGUR_CLIENT_ID, and is
+// stored in the system keychain: the login keychain on macOS, the Credential
+// Manager on Windows and the Secret Service (through secret-tool) elsewhere.
+// Without a keychain, or with GOODNESS_SECRETS=file, secrets go to an
+// encrypted file next to the global configuration, sealed with a key
+// derived from a passphrase that is asked for on the terminal or read from
+// GOODNESS_SECRETS_PASSPHRASE.
+//
+// The environment always wins, so CI jobs keep setting variables as before
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is a secret that is stored nowhere
+var ErrNotFound = errors.New("secret not found")
+
+// keychainService is the service name secrets are filed under in the
+// keychain
+const keychainService = "goodness"
+
+// Service is an integration and the secrets it needs
+type Service struct {
+	Name string
+	// Keys are the secrets, named as the environment variables that can
+	// also hold them
+	Keys []string
+}
+
+// Services are the integrations goodness auth login knows
+var Services = []Service{
+	{Name: "imgur", Keys: []string{"IMGUR_CLIENT_ID"}},
+	{Name: "cloudinary", Keys: []string{"CLOUDINARY_URL"}},
+	{Name: "dropbox", Keys: []string{"DROPBOX_ACCESS_TOKEN"}},
+	{Name: "s3", Keys: []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"}},
+	{Name: "openai", Keys: []string{"OPENAI_API_KEY"}},
+	{Name: "anthropic", Keys: []string{"ANTHROPIC_API_KEY"}},
+	{Name: "deepl", Keys: []string{"DEEPL_AUTH_KEY"}},
+	{Name: "google-translate", Keys: []string{"GOOGLE_TRANSLATE_API_KEY"}},
+	{Name: "languagetool", Keys: []string{"LANGUAGETOOL_USERNAME", "LANGUAGETOOL_API_KEY"}},
+	{Name: "audit", Keys: []string{"GOODNESS_AUDIT_KEY"}},
+}
+
+// FindService returns the service called name
+func FindService(name string) (Service, bool) {
+	i := slices.IndexFunc(Services, func(s Service) bool { return s.Name == name })
+	if i < 0 {
+		return Service{}, false
+	}
+	return Services[i], true
+}
+
+// ValidKey reports whether key can name a secret: an environment variable
+// name in capitals, digits and underscores
+func ValidKey(key string) bool {
+	if key == "" || key[0] >= '0' && key[0] <= '9' {
+		return false
+	}
+	return strings.Trim(key, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_") == ""
+}
+
+// store is where secrets are kept
+type store interface {
+	// name is keychain or file
+	name() string
+	get(key string) (string, error)
+	set(key, value string) error
+	delete(key string) error
+}
+
+var (
+	cacheMu sync.Mutex
+	// cache holds the secrets looked up so far, and missing those found
+	// nowhere, so each store is asked once
+	cache   = make(map[string]string)
+	missing = make(map[string]bool)
+)
+
+// Backend names the store secrets are saved to: keychain or file
+func Backend() string {
+	return stores()[0].name()
+}
+
+// stores returns the stores to read, the one written to first
+func stores() []store {
+	if k, ok := keychain(); ok {
+		// Secrets saved to the file before a keychain was available are
+		// still found
+		return []store{k, encryptedFile()}
+	}
+	return []store{encryptedFile()}
+}
+
+// keychain returns the system keychain, unless GOODNESS_SECRETS asks for
+// the file or there is none
+func keychain() (store, bool) {
+	if os.Getenv("GOODNESS_SECRETS") == "file" {
+		return nil, false
+	}
+	return systemKeychain()
+}
+
+// Lookup returns the secret key from the environment or, failing that, from
+// the stores. A secret that is not stored is not an error
+func Lookup(key string) (string, bool, error) {
+	if value, ok := os.LookupEnv(key); ok {
+		return value, true, nil
+	}
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if value, ok := cache[key]; ok {
+		return value, true, nil
+	}
+	if missing[key] {
+		return "", false, nil
+	}
+	for _, s := range stores() {
+		value, err := s.get(key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("%s: %w", key, err)
+		}
+		cache[key] = value
+		return value, true, nil
+	}
+	missing[key] = true
+	return "", false, nil
+}
+
+// LookupQuiet is Lookup for code that runs under every command, as the
+// audit log does: the encrypted file is only read when that needs no
+// passphrase asked for on the terminal
+func LookupQuiet(key string) (string, bool, error) {
+	if value, ok := os.LookupEnv(key); ok {
+		return value, true, nil
+	}
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if value, ok := cache[key]; ok {
+		return value, true, nil
+	}
+	if missing[key] {
+		return "", false, nil
+	}
+	for _, s := range stores() {
+		if f, ok := s.(*fileStore); ok && !f.unlocked() {
+			continue
+		}
+		value, err := s.get(key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("%s: %w", key, err)
+		}
+		cache[key] = value
+		return value, true, nil
+	}
+	return "", false, nil
+}
+
+// Get returns the secret key, or an error naming the service to log in to
+// when it is missing
+func Get(key string) (string, error) {
+	value, ok, err := Lookup(key)
+	if err != nil {
+		return "", err
+	}
+	if !ok || value == "" {
+		for _, s := range Services {
+			if slices.Contains(s.Keys, key) {
+				return "", fmt.Errorf("%s is not set; set it or run goodness auth login %s", key, s.Name)
+			}
+		}
+		return "", fmt.Errorf("%s is not set; set it or run goodness auth login %s", key, key)
+	}
+	return value, nil
+}
+
+// Set stores the secret key
+func Set(key, value string) error {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if err := stores()[0].set(key, value); err != nil {
+		return err
+	}
+	cache[key] = value
+	delete(missing, key)
+	return nil
+}
+
+// Delete removes the secret key from every store, reporting ErrNotFound
+// when none had it
+func Delete(key string) error {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	delete(cache, key)
+	found := false
+	for _, s := range stores() {
+		err := s.delete(key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Source returns where the secret key comes from: environment, keychain or
+// file, or "" when it is set nowhere
+func Source(key string) (string, error) {
+	if _, ok := os.LookupEnv(key); ok {
+		return "environment", nil
+	}
+	for _, s := range stores() {
+		_, err := s.get(key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return s.name(), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,223 @@
+// Package stats keeps the anonymous usage statistics goodness telemetry
+// sends when, and only when, the user has opted in. Each run adds one line
+// to a pending file: the command it ran, such as img convert, and how many
+// images it converted between each pair of formats. Arguments, paths, file
+// contents and anything identifying the machine or user are never recorded.
+//
+// Nothing is recorded until goodness telemetry on, or GOODNESS_TELEMETRY=on.
+// GOODNESS_TELEMETRY=off or DO_NOT_TRACK=1 turns recording off whatever was
+// chosen
+package stats
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"GoodnessucWorkflow/internal/config"
+	"GoodnessucWorkflow/internal/fsutil"
+)
+
+// Run is what one run records
+type Run struct {
+	Command string `json:"command"`
+	// Formats counts conversions by source and target format, as "png to jpeg"
+	Formats map[string]int `json:"formats,omitempty"`
+}
+
+// Report sums the pending runs
+type Report struct {
+	Runs     int            `json:"runs"`
+	Commands map[string]int `json:"commands"`
+	Formats  map[string]int `json:"formats"`
+}
+
+// choice is the opt-in saved by goodness telemetry on and off
+type choice struct {
+	Enabled bool      `json:"enabled"`
+	Changed time.Time `json:"changed"`
+}
+
+var (
+	mu        sync.Mutex
+	current   Run
+	discarded bool
+)
+
+// paths returns the choice file and the pending file, next to the global
+// configuration file
+func paths() (string, string, error) {
+	global, err := config.GlobalPath()
+	if err != nil {
+		return "", "", err
+	}
+	dir := filepath.Dir(global)
+	return filepath.Join(dir, "telemetry.json"), filepath.Join(dir, "telemetry-pending.jsonl"), nil
+}
+
+// PendingPath returns the file pending runs are kept in
+func PendingPath() string {
+	_, pending, _ := paths()
+	return pending
+}
+
+// Forced reports whether the environment decides, and what: on for
+// GOODNESS_TELEMETRY=on, off for GOODNESS_TELEMETRY=off or DO_NOT_TRACK
+func Forced() (enabled, forced bool) {
+	if v := os.Getenv("DO_NOT_TRACK"); v != "" && v != "0" {
+		return false, true
+	}
+	switch os.Getenv("GOODNESS_TELEMETRY") {
+	case "on":
+		return true, true
+	case "off":
+		return false, true
+	}
+	return false, false
+}
+
+// Enabled reports whether runs are recorded
+func Enabled() bool {
+	if enabled, forced := Forced(); forced {
+		return enabled
+	}
+	path, _, err := paths()
+	if err != nil {
+		return false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	var c choice
+	return json.Unmarshal(data, &c) == nil && c.Enabled
+}
+
+// SetEnabled saves the opt-in. Opting out also deletes the pending runs
+func SetEnabled(enabled bool) error {
+	path, pending, err := paths()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(choice{Enabled: enabled, Changed: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(path, data, 0600); err != nil {
+		return err
+	}
+	if !enabled {
+		if err := os.Remove(pending); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Command sets the command the run counts as, such as img convert
+func Command(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	current.Command = name
+}
+
+// Format counts a conversion from one format to another, such as png to
+// jpeg
+func Format(from, to string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if current.Formats == nil {
+		current.Formats = make(map[string]int)
+	}
+	current.Formats[from+" to "+to]++
+}
+
+// Discard keeps the run from being recorded, for commands such as
+// goodness telemetry whose use says nothing about the tools
+func Discard() {
+	mu.Lock()
+	defer mu.Unlock()
+	discarded = true
+}
+
+// Flush appends the run to the pending file when recording is on. Runs
+// append a line each, so runs finishing at once do not lose each other's
+func Flush() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if discarded || current.Command == "" || !Enabled() {
+		return nil
+	}
+	_, pending, err := paths()
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(pending), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(pending, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	// A run is recorded once
+	current = Run{}
+	return err
+}
+
+// Pending sums the runs recorded since the last Clear. Lines that cannot be
+// read, such as one cut short, are skipped
+func Pending() (Report, error) {
+	report := Report{Commands: make(map[string]int), Formats: make(map[string]int)}
+	_, pending, err := paths()
+	if err != nil {
+		return report, err
+	}
+	f, err := os.Open(pending)
+	if errors.Is(err, os.ErrNotExist) {
+		return report, nil
+	}
+	if err != nil {
+		return report, err
+	}
+	defer f.Close()
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		var run Run
+		if json.Unmarshal(lines.Bytes(), &run) != nil || run.Command == "" {
+			continue
+		}
+		report.Runs++
+		report.Commands[run.Command]++
+		for pair, n := range run.Formats {
+			report.Formats[pair] += n
+		}
+	}
+	return report, lines.Err()
+}
+
+// Clear deletes the pending runs
+func Clear() error {
+	_, pending, err := paths()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(pending); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"GoodnessucWorkflow/internal/secrets"
+)
+
+// Dropbox API hosts
+const (
+	dropboxAPI     = "https://api.dropboxapi.com"
+	dropboxContent = "https://content.dropboxapi.com"
+)
+
+// dropbox is a folder in a Dropbox account, reached with the access token
+// in DROPBOX_ACCESS_TOKEN
+type dropbox struct {
+	root  string
+	token string
+}
+
+func openDropbox(location string) (*dropbox, error) {
+	token, err := secrets.Get("DROPBOX_ACCESS_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	root := ""
+	if location != "" {
+		root = "/" + location
+	}
+	return &dropbox{root: root, token: token}, nil
+}
+
+func (d *dropbox) String() string {
+	return "dropbox://" + strings.TrimPrefix(d.root, "/")
+}
+
+// call posts arg as JSON to an API endpoint and decodes the reply into out
+func (d *dropbox) call(endpoint string, arg, out any) error {
+	body, err := json.Marshal(arg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, dropboxAPI+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+d.token)
+	req.Header.Set("Content-Type", "application/json")
+	_, err = send(req, out)
+	return err
+}
+
+// content calls a content endpoint, which takes its argument in the
+// Dropbox-API-Arg header and the file as the body
+func (d *dropbox) content(endpoint string, arg any, data []byte) ([]byte, error) {
+	header, err := headerJSON(arg)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, dropboxContent+endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+d.token)
+	req.Header.Set("Dropbox-API-Arg", header)
+	if data != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	return send(req, nil)
+}
+
+func (d *dropbox) List() ([]File, error) {
+	type entry struct {
+		Tag         string `json:".tag"`
+		PathDisplay string `json:"path_display"`
+		Size        int64  `json:"size"`
+	}
+	var page struct {
+		Entries []entry `json:"entries"`
+		Cursor  string  `json:"cursor"`
+		HasMore bool    `json:"has_more"`
+	}
+	err := d.call("/2/files/list_folder", map[string]any{"path": d.root, "recursive": true}, &page)
+	var files []File
+	for err == nil {
+		for _, e := range page.Entries {
+			// Paths keep their case as stored, which the root given may not
+			if e.Tag == "file" && len(e.PathDisplay) > len(d.root)+1 {
+				files = append(files, File{Name: e.PathDisplay[len(d.root)+1:], Size: e.Size})
+			}
+		}
+		if !page.HasMore {
+			return files, nil
+		}
+		cursor := page.Cursor
+		page.Entries = nil
+		err = d.call("/2/files/list_folder/continue", map[string]string{"cursor": cursor}, &page)
+	}
+	return nil, err
+}
+
+func (d *dropbox) Get(name string) ([]byte, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	return d.content("/2/files/download", map[string]string{"path": path.Join("/", d.root, name)}, nil)
+}
+
+func (d *dropbox) Put(name string, data []byte) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	arg := map[string]any{"path": path.Join("/", d.root, name), "mode": "overwrite", "mute": true}
+	_, err := d.content("/2/files/upload", arg, data)
+	return err
+}
+
+// headerJSON encodes v as JSON with every non-ASCII character escaped, as
+// HTTP headers must be
+func headerJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r < utf8.RuneSelf {
+			b.WriteByte(data[0])
+		} else if r > 0xFFFF {
+			r -= 0x10000
+			fmt.Fprintf(&b, `\u%04x\u%04x`, 0xD800+(r>>10), 0xDC00+(r&0x3FF))
+		} else {
+			fmt.Fprintf(&b, `\u%04x`, r)
+		}
+		data = data[size:]
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gcs is a folder in a Google Cloud Storage bucket, reached through the
+// JSON API, or through the emulator in STORAGE_EMULATOR_HOST when set
+type gcs struct {
+	bucket   string
+	prefix   string
+	endpoint string
+}
+
+func openGCS(location string) (*gcs, error) {
+	bucket, prefix, _ := strings.Cut(location, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("gs:// needs a bucket")
+	}
+	endpoint := "https://storage.googleapis.com"
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		endpoint = host
+		if !strings.Contains(host, "://") {
+			endpoint = "http://" + host
+		}
+	}
+	return &gcs{bucket: bucket, prefix: prefix, endpoint: strings.TrimSuffix(endpoint, "/")}, nil
+}
+
+func (g *gcs) String() string {
+	return strings.TrimSuffix("gs://"+g.bucket+"/"+g.prefix, "/")
+}
+
+// object is the object name of name, under the prefix
+func (g *gcs) object(name string) string {
+	return strings.TrimPrefix(path.Join(g.prefix, name), "/")
+}
+
+func (g *gcs) newRequest(method, rawURL string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, g.endpoint+rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv("STORAGE_EMULATOR_HOST") == "" {
+		token, err := googleToken()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+func (g *gcs) List() ([]File, error) {
+	prefix := g.object("")
+	if prefix != "" {
+		prefix += "/"
+	}
+	var files []File
+	query := url.Values{"prefix": {prefix}, "fields": {"items(name,size),nextPageToken"}}
+	for {
+		req, err := g.newRequest(http.MethodGet, "/storage/v1/b/"+url.PathEscape(g.bucket)+"/o?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+				Size string `json:"size"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if _, err := send(req, &page); err != nil {
+			return nil, err
+		}
+		for _, o := range page.Items {
+			if name := strings.TrimPrefix(o.Name, prefix); name != "" && !strings.HasSuffix(name, "/") {
+				size, _ := strconv.ParseInt(o.Size, 10, 64)
+				files = append(files, File{Name: name, Size: size})
+			}
+		}
+		if page.NextPageToken == "" {
+			return files, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+func (g *gcs) Get(name string) ([]byte, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	req, err := g.newRequest(http.MethodGet, "/storage/v1/b/"+url.PathEscape(g.bucket)+"/o/"+url.PathEscape(g.object(name))+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	return send(req, nil)
+}
+
+func (g *gcs) Put(name string, data []byte) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	query := url.Values{"uploadType": {"media"}, "name": {g.object(name)}}
+	req, err := g.newRequest(http.MethodPost, "/upload/storage/v1/b/"+url.PathEscape(g.bucket)+"/o?"+query.Encode(), data)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType(name))
+	_, err = send(req, nil)
+	return err
+}
+
+// contentType guesses the MIME type of name from its extension
+func contentType(name string) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+var (
+	tokenOnce sync.Once
+	token     string
+	tokenErr  error
+)
+
+// googleToken returns the OAuth access token for Google Cloud Storage and
+// Google Drive: GOOGLE_OAUTH_ACCESS_TOKEN, or else the one gcloud prints
+// for the signed-in account
+func googleToken() (string, error) {
+	tokenOnce.Do(func() {
+		if token = os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+			return
+		}
+		out, err := exec.Command("gcloud", "auth", "print-access-token").Output()
+		if err != nil {
+			tokenErr = fmt.Errorf("set GOOGLE_OAUTH_ACCESS_TOKEN or sign in with gcloud: %s", err)
+			return
+		}
+		token = strings.TrimSpace(string(out))
+	})
+	return token, tokenErr
+}
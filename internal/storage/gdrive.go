@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	driveAPI    = "https://www.googleapis.com/drive/v3"
+	driveUpload = "https://www.googleapis.com/upload/drive/v3"
+	folderType  = "application/vnd.google-apps.folder"
+)
+
+// drive is a folder in Google Drive, found by name from the top of My
+// Drive since Drive has no paths of its own
+type drive struct {
+	root string
+
+	mu sync.Mutex
+	// folders caches the ID of each folder path, "" being My Drive
+	folders map[string]string
+}
+
+func openDrive(location string) (*drive, error) {
+	if _, err := googleToken(); err != nil {
+		return nil, err
+	}
+	return &drive{root: location, folders: map[string]string{"": "root"}}, nil
+}
+
+func (d *drive) String() string {
+	return "gdrive://" + d.root
+}
+
+// driveFile is the part of a Drive file the storage uses
+type driveFile struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	MimeType string `json:"mimeType"`
+	Size     string `json:"size"`
+}
+
+func (d *drive) newRequest(method, rawURL string, body []byte) (*http.Request, error) {
+	token, err := googleToken()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+// children lists the files in the folder id, with name only the files so
+// named
+func (d *drive) children(id, name string) ([]driveFile, error) {
+	q := fmt.Sprintf("'%s' in parents and trashed = false", quoteQuery(id))
+	if name != "" {
+		q += fmt.Sprintf(" and name = '%s'", quoteQuery(name))
+	}
+	query := url.Values{"q": {q}, "fields": {"nextPageToken,files(id,name,mimeType,size)"}, "pageSize": {"1000"}}
+	var files []driveFile
+	for {
+		req, err := d.newRequest(http.MethodGet, driveAPI+"/files?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Files         []driveFile `json:"files"`
+			NextPageToken string      `json:"nextPageToken"`
+		}
+		if _, err := send(req, &page); err != nil {
+			return nil, err
+		}
+		files = append(files, page.Files...)
+		if page.NextPageToken == "" {
+			return files, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// folder returns the ID of the folder at dir, a path from the top of My
+// Drive, creating it and its parents when create is set. A missing folder
+// is "" otherwise. Lookups are serialized so parallel uploads make each
+// folder once
+func (d *drive) folder(dir string, create bool) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lookup(strings.Trim(dir, "/"), create)
+}
+
+func (d *drive) lookup(dir string, create bool) (string, error) {
+	if id, ok := d.folders[dir]; ok {
+		return id, nil
+	}
+	parent, name := path.Split(dir)
+	parentID, err := d.lookup(strings.TrimSuffix(parent, "/"), create)
+	if err != nil || parentID == "" {
+		return "", err
+	}
+
+	found, err := d.children(parentID, name)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range found {
+		if f.MimeType == folderType {
+			d.folders[dir] = f.ID
+			return f.ID, nil
+		}
+	}
+	if !create {
+		return "", nil
+	}
+	body, err := json.Marshal(map[string]any{"name": name, "mimeType": folderType, "parents": []string{parentID}})
+	if err != nil {
+		return "", err
+	}
+	req, err := d.newRequest(http.MethodPost, driveAPI+"/files?fields=id", body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var made driveFile
+	if _, err := send(req, &made); err != nil {
+		return "", err
+	}
+	d.folders[dir] = made.ID
+	return made.ID, nil
+}
+
+func (d *drive) List() ([]File, error) {
+	id, err := d.folder(d.root, false)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, fmt.Errorf("%s: no such folder", d)
+	}
+	var files []File
+	var walk func(id, prefix string) error
+	walk = func(id, prefix string) error {
+		children, err := d.children(id, "")
+		if err != nil {
+			return err
+		}
+		for _, f := range children {
+			switch {
+			case f.MimeType == folderType:
+				if err := walk(f.ID, prefix+f.Name+"/"); err != nil {
+					return err
+				}
+			case strings.HasPrefix(f.MimeType, "application/vnd.google-apps."):
+				// Docs, Sheets and the like have no file content
+			default:
+				size, _ := strconv.ParseInt(f.Size, 10, 64)
+				files = append(files, File{Name: prefix + f.Name, Size: size})
+			}
+		}
+		return nil
+	}
+	return files, walk(id, "")
+}
+
+// file returns the ID of the file name, or "" when there is none, and the
+// ID of its folder
+func (d *drive) file(name string, create bool) (id, parent string, err error) {
+	dir, base := path.Split(name)
+	parent, err = d.folder(path.Join(d.root, dir), create)
+	if err != nil || parent == "" {
+		return "", parent, err
+	}
+	found, err := d.children(parent, base)
+	if err != nil {
+		return "", parent, err
+	}
+	for _, f := range found {
+		if f.MimeType != folderType {
+			return f.ID, parent, nil
+		}
+	}
+	return "", parent, nil
+}
+
+func (d *drive) Get(name string) ([]byte, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	id, _, err := d.file(name, false)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, fmt.Errorf("%s/%s: no such file", d, name)
+	}
+	req, err := d.newRequest(http.MethodGet, driveAPI+"/files/"+url.PathEscape(id)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	return send(req, nil)
+}
+
+// Put replaces the content of an existing file, keeping its ID and links,
+// or uploads a new one with its name and folder in a multipart body
+func (d *drive) Put(name string, data []byte) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	id, parent, err := d.file(name, true)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		req, err := d.newRequest(http.MethodPatch, driveUpload+"/files/"+url.PathEscape(id)+"?uploadType=media", data)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", contentType(name))
+		_, err = send(req, nil)
+		return err
+	}
+
+	meta, err := json.Marshal(map[string]any{"name": path.Base(name), "parents": []string{parent}})
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+	if err != nil {
+		return err
+	}
+	part.Write(meta)
+	if part, err = w.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType(name)}}); err != nil {
+		return err
+	}
+	part.Write(data)
+	if err := w.Close(); err != nil {
+		return err
+	}
+	req, err := d.newRequest(http.MethodPost, driveUpload+"/files?uploadType=multipart", body.Bytes())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "multipart/related; boundary="+w.Boundary())
+	_, err = send(req, nil)
+	return err
+}
+
+// quoteQuery escapes s for a string in a Drive search query
+func quoteQuery(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
@@ -0,0 +1,474 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"GoodnessucWorkflow/internal/httpclient"
+	"GoodnessucWorkflow/internal/secrets"
+	"GoodnessucWorkflow/pkg/errs"
+)
+
+// minPartSize is the smallest part S3 accepts for every part but the last
+const minPartSize = 5 << 20
+
+// S3Options configures access to an S3-compatible bucket. Prefix is the
+// folder inside it that names are relative to
+type S3Options struct {
+	Endpoint     string
+	Region       string
+	Bucket       string
+	Prefix       string
+	ACL          string
+	CacheControl string
+	PartSize     int64
+	Concurrency  int
+}
+
+// S3 signs requests with AWS Signature Version 4 and talks to the bucket
+// using path-style URLs, which every S3-compatible service understands
+type S3 struct {
+	opts      S3Options
+	endpoint  *url.URL
+	accessKey string
+	secretKey string
+	http      *httpclient.Client
+}
+
+// NewS3 returns a client for the bucket, signing with the credentials in
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+func NewS3(opts S3Options) (*S3, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", opts.Endpoint)
+	}
+
+	accessKey, err := secrets.Get("AWS_ACCESS_KEY_ID")
+	if err != nil {
+		return nil, err
+	}
+	secretKey, err := secrets.Get("AWS_SECRET_ACCESS_KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.PartSize < minPartSize {
+		opts.PartSize = minPartSize
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+
+	return &S3{
+		opts:      opts,
+		endpoint:  endpoint,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		http:      httpclient.New(5 * time.Minute),
+	}, nil
+}
+
+// awsEscape percent-encodes everything except the characters SigV4 leaves unreserved
+func awsEscape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (keepSlash && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// newRequest builds a signed request for key in the configured bucket, or
+// for the bucket itself when key is ""
+func (c *S3) newRequest(method, key string, query url.Values, body []byte) (*http.Request, error) {
+	path := "/" + c.opts.Bucket
+	if key != "" {
+		path += "/" + key
+	}
+	if base := strings.TrimSuffix(c.endpoint.Path, "/"); base != "" {
+		path = base + path
+	}
+
+	u := *c.endpoint
+	u.Path = path
+	u.RawPath = awsEscape(path, true)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	req.Header.Set("X-Amz-Date", time.Now().UTC().Format("20060102T150405Z"))
+	return req, nil
+}
+
+// canonicalQuery sorts and encodes query parameters the way SigV4 expects
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, awsEscape(k, false)+"="+awsEscape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// sign adds the Authorization header covering the host and every header already set
+func (c *S3) sign(req *http.Request) {
+	amzDate := req.Header.Get("X-Amz-Date")
+	date := amzDate[:8]
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := date + "/" + c.opts.Region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.opts.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// do signs and sends req, turning non-2xx responses into errors
+func (c *S3) do(req *http.Request) (*http.Response, error) {
+	c.sign(req)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return resp, &errs.RemoteError{Service: req.Method + " " + req.URL.Path, Status: resp.Status, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	return resp, nil
+}
+
+// remoteETag returns the ETag of key, or "" when the object does not exist
+func (c *S3) remoteETag(key string) (string, error) {
+	req, err := c.newRequest(http.MethodHead, key, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.do(req)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return strings.Trim(resp.Header.Get("ETag"), `"`), nil
+}
+
+// expectedETag computes the ETag S3 reports for data uploaded with the client's part size
+func (c *S3) expectedETag(data []byte) string {
+	if int64(len(data)) <= c.opts.PartSize {
+		sum := md5.Sum(data)
+		return hex.EncodeToString(sum[:])
+	}
+
+	var sums []byte
+	parts := 0
+	for off := int64(0); off < int64(len(data)); off += c.opts.PartSize {
+		sum := md5.Sum(data[off:min(off+c.opts.PartSize, int64(len(data)))])
+		sums = append(sums, sum[:]...)
+		parts++
+	}
+	sum := md5.Sum(sums)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), parts)
+}
+
+// setObjectHeaders adds the headers shared by single and multipart uploads
+func (c *S3) setObjectHeaders(req *http.Request, key string) {
+	if contentType := mime.TypeByExtension(filepath.Ext(key)); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.opts.ACL != "" {
+		req.Header.Set("X-Amz-Acl", c.opts.ACL)
+	}
+	if c.opts.CacheControl != "" {
+		req.Header.Set("Cache-Control", c.opts.CacheControl)
+	}
+}
+
+// openS3 opens s3://bucket/prefix with the endpoint and region in the
+// standard AWS variables
+func openS3(location string) (*S3, error) {
+	bucket, prefix, _ := strings.Cut(location, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("s3:// needs a bucket")
+	}
+	endpoint := cmp.Or(os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"), "https://s3.amazonaws.com")
+	region := cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	return NewS3(S3Options{Endpoint: endpoint, Region: region, Bucket: bucket, Prefix: prefix, PartSize: 8 << 20, Concurrency: 4})
+}
+
+// key is the object key of name, under the prefix
+func (c *S3) key(name string) string {
+	return strings.TrimPrefix(strings.TrimSuffix(c.opts.Prefix, "/")+"/"+name, "/")
+}
+
+// URL returns the s3:// URL of name
+func (c *S3) URL(name string) string {
+	return "s3://" + c.opts.Bucket + "/" + c.key(name)
+}
+
+func (c *S3) String() string {
+	return strings.TrimSuffix(c.URL(""), "/")
+}
+
+// List pages through the objects under the prefix
+func (c *S3) List() ([]File, error) {
+	prefix := c.key("")
+	var files []File
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		req, err := c.newRequest(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key  string `xml:"Key"`
+				Size int64  `xml:"Size"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range page.Contents {
+			// Folder markers made by consoles end in a slash
+			if name := strings.TrimPrefix(o.Key, prefix); name != "" && !strings.HasSuffix(name, "/") {
+				files = append(files, File{Name: name, Size: o.Size})
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return files, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+func (c *S3) Get(name string) ([]byte, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(http.MethodGet, c.key(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (c *S3) Put(name string, data []byte) error {
+	_, err := c.Upload(name, data)
+	return err
+}
+
+// Upload stores data as name unless the bucket already holds identical
+// bytes, which it reports as skipped
+func (c *S3) Upload(name string, data []byte) (skipped bool, err error) {
+	if err := checkName(name); err != nil {
+		return false, err
+	}
+	return c.upload(c.key(name), data)
+}
+
+// upload stores data under key unless the bucket already holds identical bytes
+func (c *S3) upload(key string, data []byte) (skipped bool, err error) {
+	etag, err := c.remoteETag(key)
+	if err != nil {
+		return false, err
+	}
+	if etag == c.expectedETag(data) {
+		return true, nil
+	}
+
+	if int64(len(data)) > c.opts.PartSize {
+		return false, c.uploadMultipart(key, data)
+	}
+
+	req, err := c.newRequest(http.MethodPut, key, nil, data)
+	if err != nil {
+		return false, err
+	}
+	c.setObjectHeaders(req, key)
+	resp, err := c.do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return false, nil
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// uploadMultipart sends data in parts, several at a time, and aborts the
+// upload if any part fails
+func (c *S3) uploadMultipart(key string, data []byte) error {
+	req, err := c.newRequest(http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	c.setObjectHeaders(req, key)
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	var parts []completedPart
+	for off, n := int64(0), 1; off < int64(len(data)); off, n = off+c.opts.PartSize, n+1 {
+		parts = append(parts, completedPart{PartNumber: n})
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	sem := make(chan struct{}, c.opts.Concurrency)
+	for i := range parts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			off := int64(i) * c.opts.PartSize
+			chunk := data[off:min(off+c.opts.PartSize, int64(len(data)))]
+			query := url.Values{"partNumber": {fmt.Sprint(parts[i].PartNumber)}, "uploadId": {initiated.UploadID}}
+
+			req, err := c.newRequest(http.MethodPut, key, query, chunk)
+			if err == nil {
+				var resp *http.Response
+				if resp, err = c.do(req); err == nil {
+					resp.Body.Close()
+					parts[i].ETag = resp.Header.Get("ETag")
+				}
+			}
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	uploadQuery := url.Values{"uploadId": {initiated.UploadID}}
+	if firstErr != nil {
+		if req, err := c.newRequest(http.MethodDelete, key, uploadQuery, nil); err == nil {
+			if resp, err := c.do(req); err == nil {
+				resp.Body.Close()
+			}
+		}
+		return firstErr
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	req, err = c.newRequest(http.MethodPost, key, uploadQuery, body)
+	if err != nil {
+		return err
+	}
+	resp, err = c.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// ssh is a folder on a server, reached by running the system's ssh, or the
+// program in GOODNESS_SSH, so ~/.ssh/config, keys and the agent apply. The
+// connections of one run share a master connection where ssh supports it
+type ssh struct {
+	host string
+	port string
+	root string
+}
+
+// openSSH opens the location after ssh://, [user@]host[:port]/path, whose
+// path is absolute unless it starts with ~ for the home folder
+func openSSH(location string) (*ssh, error) {
+	host, root, _ := strings.Cut(location, "/")
+	if root == "" || root == "~" {
+		root = "~/"
+	} else if !strings.HasPrefix(root, "~/") {
+		root = "/" + root
+	}
+	s := &ssh{host: host, root: strings.TrimSuffix(root, "/")}
+	if i := strings.LastIndexByte(host, ':'); i >= 0 {
+		s.host, s.port = host[:i], host[i+1:]
+		if _, err := strconv.Atoi(s.port); err != nil {
+			return nil, fmt.Errorf("invalid port %q", s.port)
+		}
+	}
+	if at := strings.LastIndexByte(s.host, '@'); s.host[at+1:] == "" {
+		return nil, errors.New("ssh:// needs a host")
+	}
+	// Fail early, and open the master connection before downloads run at once
+	if _, err := s.run(nil, "test -d "+s.quote("")+" || { echo 'no such folder' >&2; exit 1; }"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SSHLocation turns the scp style target [user@]host:path into an ssh://
+// location, reporting false when target is not one
+func SSHLocation(target string) (string, bool) {
+	host, dir, ok := strings.Cut(target, ":")
+	if !ok || host == "" || strings.ContainsAny(host, `/\`) || strings.Contains(target, "://") {
+		return "", false
+	}
+	// A single letter before the colon is a Windows drive, not a host
+	if len(host) == 1 && runtime.GOOS == "windows" {
+		return "", false
+	}
+	if !strings.HasPrefix(dir, "/") {
+		dir = "~/" + dir
+	}
+	return "ssh://" + host + "/" + strings.TrimPrefix(dir, "/"), true
+}
+
+func (s *ssh) String() string {
+	host := s.host
+	if s.port != "" {
+		host += ":" + s.port
+	}
+	return "ssh://" + host + "/" + strings.TrimPrefix(s.root, "/")
+}
+
+// quote is the path of name in the folder quoted for the remote shell,
+// leaving a leading ~ for it to expand
+func (s *ssh) quote(name string) string {
+	p := path.Join(s.root, name)
+	if p == "~" {
+		return p
+	}
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		return "~/" + shellQuote(rest)
+	}
+	return shellQuote(p)
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// run runs script with the remote shell, feeding it stdin, and returns
+// what it printed. The error carries what it printed on stderr
+func (s *ssh) run(stdin []byte, script string) ([]byte, error) {
+	program := "ssh"
+	if p := os.Getenv("GOODNESS_SSH"); p != "" {
+		program = p
+	}
+	var args []string
+	if s.port != "" {
+		args = append(args, "-p", s.port)
+	}
+	if program == "ssh" && runtime.GOOS != "windows" {
+		args = append(args, "-o", "ControlMaster=auto", "-o", "ControlPath="+path.Join(os.TempDir(), "goodness-ssh-%C"), "-o", "ControlPersist=60")
+	}
+	args = append(args, "--", s.host, script)
+	cmd := exec.Command(program, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%s: %s", s.host, message)
+		}
+		return nil, fmt.Errorf("%s: %s", s.host, err)
+	}
+	return out, nil
+}
+
+func (s *ssh) List() ([]File, error) {
+	// Only POSIX tools, for servers without GNU find, in one round trip
+	out, err := s.run(nil, "cd "+s.quote("")+` && find . -type f -exec sh -c 'for f; do printf "%s %s\0" "$(wc -c <"$f")" "$f"; done' sh {} +`)
+	if err != nil {
+		return nil, err
+	}
+	var files []File
+	for _, line := range strings.Split(string(out), "\x00") {
+		size, name, ok := strings.Cut(strings.TrimLeft(line, " "), " ")
+		if !ok {
+			continue
+		}
+		n, _ := strconv.ParseInt(size, 10, 64)
+		files = append(files, File{Name: strings.TrimPrefix(name, "./"), Size: n})
+	}
+	return files, nil
+}
+
+func (s *ssh) Get(name string) ([]byte, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	return s.run(nil, "cat -- "+s.quote(name))
+}
+
+func (s *ssh) Put(name string, data []byte) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	// Written next to the file and moved over it, so a dropped connection
+	// never leaves half a file
+	target, tmp := s.quote(name), s.quote(path.Join(path.Dir(name), ".goodness-upload-"+path.Base(name)))
+	_, err := s.run(data, "mkdir -p -- "+s.quote(path.Dir(name))+" && cat > "+tmp+" && mv -f -- "+tmp+" "+target)
+	return err
+}
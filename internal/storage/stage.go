@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+)
+
+// transfers is how many files are downloaded or uploaded at once
+const transfers = 4
+
+// mirror is a remote folder copied into a local directory for a command
+type mirror struct {
+	store Storage
+	dir   string
+	// before holds each downloaded file as it arrived, so only the files
+	// the command writes go back up
+	before map[string]fileState
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+var (
+	mirrors []*mirror
+	tempDir string
+)
+
+// Stage lets the command with flags take remote locations. Each
+// argument naming a remote folder is downloaded into a temporary directory
+// and replaced by its path there, and an -o or -out flag naming a remote
+// location is pointed into one. Stage returns the arguments to use and
+// whether anything was staged; Flush then uploads what the command wrote
+func Stage(flags *flag.FlagSet, args []string) ([]string, bool, error) {
+	staged, err := stage(flags, args)
+	if err != nil {
+		Cleanup()
+		return nil, false, err
+	}
+	return staged, len(mirrors) > 0, nil
+}
+
+func stage(flags *flag.FlagSet, args []string) ([]string, error) {
+	for _, name := range []string{"o", "out"} {
+		f := flags.Lookup(name)
+		if f == nil || !IsRemote(f.Value.String()) {
+			continue
+		}
+		location := f.Value.String()
+		parent, base := Split(location)
+		store, err := Open(parent)
+		if err != nil {
+			return nil, fmt.Errorf("-%s: %s", name, err)
+		}
+		dir, err := newDir()
+		if err != nil {
+			return nil, err
+		}
+		flags.Set(name, filepath.Join(dir, base))
+		mirrors = append(mirrors, &mirror{store: store, dir: dir})
+	}
+
+	staged := make([]string, len(args))
+	for i, arg := range args {
+		staged[i] = arg
+		if !IsRemote(arg) {
+			continue
+		}
+		dir, err := mirrorFolder(arg)
+		if err != nil {
+			return nil, err
+		}
+		staged[i] = dir
+	}
+	return staged, nil
+}
+
+// Mirror downloads the remote folder at location into a temporary
+// directory and returns its path; Flush then uploads what was written there
+func Mirror(location string) (string, error) {
+	dir, err := mirrorFolder(location)
+	if err != nil {
+		Cleanup()
+	}
+	return dir, err
+}
+
+func mirrorFolder(location string) (string, error) {
+	store, err := Open(location)
+	if err != nil {
+		return "", err
+	}
+	dir, err := newDir()
+	if err != nil {
+		return "", err
+	}
+	// Keep the folder's name, which outputs may be named after
+	_, base := Split(location)
+	m := &mirror{store: store, dir: filepath.Join(dir, cmp.Or(base, "files")), before: make(map[string]fileState)}
+	if err := m.download(); err != nil {
+		return "", fmt.Errorf("%s: %s", location, err)
+	}
+	mirrors = append(mirrors, m)
+	return m.dir, nil
+}
+
+// newDir makes a directory for one mirror in the temporary directory
+func newDir() (string, error) {
+	if tempDir == "" {
+		dir, err := os.MkdirTemp("", "goodness-storage-")
+		if err != nil {
+			return "", err
+		}
+		tempDir = dir
+	}
+	return os.MkdirTemp(tempDir, "")
+}
+
+// download copies every file of the folder into the mirror
+func (m *mirror) download() error {
+	files, err := m.store.List()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+	var mu sync.Mutex
+	var g errgroup.Group
+	g.SetLimit(transfers)
+	for _, f := range files {
+		if checkName(f.Name) != nil {
+			logging.Warnf("Skipping %s/%s: not a usable file name", m.store, f.Name)
+			continue
+		}
+		g.Go(func() error {
+			data, err := m.store.Get(f.Name)
+			if err != nil {
+				return err
+			}
+			path := filepath.Join(m.dir, filepath.FromSlash(f.Name))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := fsutil.WriteFileAtomic(path, data, 0644); err != nil {
+				return err
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			m.before[f.Name] = fileState{size: info.Size(), modTime: info.ModTime()}
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	logging.Infof("Downloaded %d files from %s", len(m.before), m.store)
+	return nil
+}
+
+// upload puts the files created or changed in the mirror since it was made
+// back into the folder. Hidden files, such as state files, stay behind
+func (m *mirror) upload() error {
+	var names []string
+	err := filepath.WalkDir(m.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != m.dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(m.dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if before, ok := m.before[name]; ok && before.size == info.Size() && before.modTime.Equal(info.ModTime()) {
+			return nil
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	var g errgroup.Group
+	g.SetLimit(transfers)
+	for _, name := range names {
+		g.Go(func() error {
+			data, err := os.ReadFile(filepath.Join(m.dir, filepath.FromSlash(name)))
+			if err == nil {
+				err = m.store.Put(name, data)
+			}
+			target := m.store.String() + "/" + name
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", target, err))
+				mu.Unlock()
+				return nil
+			}
+			logging.Infof("Upload successful: %s", target)
+			output.Wrote(target)
+			return nil
+		})
+	}
+	g.Wait()
+	return errors.Join(errs...)
+}
+
+// Flush uploads the files the command created or changed in the staged
+// folders, then removes the temporary directory
+func Flush() error {
+	defer Cleanup()
+	var errs []error
+	for _, m := range mirrors {
+		if err := m.upload(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Cleanup removes the temporary directory without uploading anything
+func Cleanup() {
+	if tempDir != "" {
+		os.RemoveAll(tempDir)
+		tempDir = ""
+	}
+	mirrors = nil
+}
@@ -0,0 +1,179 @@
+// Package storage reads and writes folders of files on the local disk, on
+// a server over SSH or with a cloud service: S3-compatible buckets, Google
+// Cloud Storage, Dropbox and Google Drive. Locations are a local path or a
+// URL such as ssh://user@host/srv/shots, s3://bucket/shots,
+// gs://bucket/shots, dropbox://Photos/shots or gdrive://Photos/shots, the
+// last two starting at the top of the account's files.
+//
+// Stage lets any command take remote locations for its arguments and its
+// -o or -out flag by mirroring them in a temporary directory.
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"GoodnessucWorkflow/internal/httpclient"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/pkg/errs"
+)
+
+// Storage is a folder files are read from and written to. Names are slash
+// separated paths inside it
+type Storage interface {
+	// List returns every file in the folder and its subfolders
+	List() ([]File, error)
+	// Get returns the content of the file name
+	Get(name string) ([]byte, error)
+	// Put creates or replaces the file name with data
+	Put(name string, data []byte) error
+	// String returns the location of the folder
+	String() string
+}
+
+// File is one file in a Storage
+type File struct {
+	Name string
+	Size int64
+}
+
+// schemes are the URL schemes of the remote kinds of storage
+var schemes = []string{"s3", "gs", "dropbox", "gdrive", "ssh", "sftp"}
+
+// IsRemote reports whether location names a cloud or server folder rather
+// than a local path
+func IsRemote(location string) bool {
+	scheme, _, ok := strings.Cut(location, "://")
+	if !ok {
+		return false
+	}
+	for _, s := range schemes {
+		if scheme == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Open returns the storage of the folder at location
+func Open(location string) (Storage, error) {
+	scheme, rest, ok := strings.Cut(location, "://")
+	if !ok {
+		return &local{dir: location}, nil
+	}
+	rest = strings.Trim(rest, "/")
+	switch scheme {
+	case "file":
+		return &local{dir: "/" + rest}, nil
+	case "s3":
+		return openS3(rest)
+	case "gs":
+		return openGCS(rest)
+	case "dropbox":
+		return openDropbox(rest)
+	case "gdrive":
+		return openDrive(rest)
+	case "ssh", "sftp":
+		return openSSH(rest)
+	}
+	return nil, fmt.Errorf("unknown storage %q; expected a path or an s3://, gs://, dropbox://, gdrive:// or ssh:// location", scheme+"://")
+}
+
+// Split returns the folder holding location and the last element of its
+// path, which is "" for the top of a bucket or account
+func Split(location string) (parent, name string) {
+	scheme, rest, _ := strings.Cut(location, "://")
+	rest = strings.Trim(rest, "/")
+	dir, name := path.Split(rest)
+	if scheme == "s3" || scheme == "gs" {
+		if !strings.Contains(rest, "/") {
+			// The bucket itself
+			return location, ""
+		}
+	}
+	return scheme + "://" + strings.TrimSuffix(dir, "/"), name
+}
+
+// checkName rejects names that would leave the folder
+func checkName(name string) error {
+	if name == "" || !fs.ValidPath(name) {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	return nil
+}
+
+// local is a folder on disk
+type local struct {
+	dir string
+}
+
+func (l *local) String() string {
+	return l.dir
+}
+
+func (l *local) List() ([]File, error) {
+	var files []File
+	err := filepath.WalkDir(l.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(l.dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, File{Name: filepath.ToSlash(rel), Size: info.Size()})
+		return nil
+	})
+	return files, err
+}
+
+func (l *local) Get(name string) ([]byte, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(l.dir, filepath.FromSlash(name)))
+}
+
+func (l *local) Put(name string, data []byte) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	p := filepath.Join(l.dir, filepath.FromSlash(name))
+	if err := journal.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return journal.WriteFile(p, data, 0644)
+}
+
+// client is shared by the cloud services but S3, which keeps its own
+var client = httpclient.New(5 * time.Minute)
+
+// send sends req and decodes a JSON reply into out, if not nil, or returns
+// the reply's body when out is nil. Replies other than 2xx are errors
+func send(req *http.Request, out any) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &errs.RemoteError{Service: req.Method + " " + req.URL.Path, Status: resp.Status, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	if out != nil {
+		return nil, json.NewDecoder(resp.Body).Decode(out)
+	}
+	return io.ReadAll(resp.Body)
+}
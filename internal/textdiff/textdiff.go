@@ -0,0 +1,126 @@
+// Package textdiff compares texts line by line, for previews of what a
+// change does to a file
+package textdiff
+
+import "strings"
+
+// Line is one line of a line diff: Kind is ' ' for a line both sides
+// share, '-' for one only before, '+' for one only after and '~' for a run
+// of shared lines left out
+type Line struct {
+	Kind byte
+	Text string
+}
+
+// contextLines are the shared lines kept around each change
+const contextLines = 3
+
+// maxDiffCells bounds the table of the longest common subsequence; larger
+// changes show every old line removed and every new one added
+const maxDiffCells = 16 << 20
+
+// Lines returns the changes from before to after, with shared lines
+// away from any change folded into '~' lines
+func Lines(before, after string) []Line {
+	a := strings.Split(strings.TrimSuffix(before, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(after, "\n"), "\n")
+
+	// Only the middle that differs needs the table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	var lines []Line
+	for _, s := range a[:prefix] {
+		lines = append(lines, Line{' ', s})
+	}
+	lines = append(lines, middleDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, s := range a[len(a)-suffix:] {
+		lines = append(lines, Line{' ', s})
+	}
+	return fold(lines)
+}
+
+// middleDiff diffs a and b by their longest common subsequence
+func middleDiff(a, b []string) []Line {
+	var lines []Line
+	if len(a)*len(b) > maxDiffCells {
+		for _, s := range a {
+			lines = append(lines, Line{'-', s})
+		}
+		for _, s := range b {
+			lines = append(lines, Line{'+', s})
+		}
+		return lines
+	}
+
+	// lcs[i][j] is the length of the common subsequence of a[i:] and b[j:]
+	cols := len(b) + 1
+	lcs := make([]int32, (len(a)+1)*cols)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i*cols+j] = lcs[(i+1)*cols+j+1] + 1
+			} else {
+				lcs[i*cols+j] = max(lcs[(i+1)*cols+j], lcs[i*cols+j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, Line{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[(i+1)*cols+j] >= lcs[i*cols+j+1]):
+			lines = append(lines, Line{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, Line{'+', b[j]})
+			j++
+		}
+	}
+	return lines
+}
+
+// fold replaces the shared lines more than contextLines away from a change
+// with one '~' line per run
+func fold(lines []Line) []Line {
+	near := make([]bool, len(lines))
+	for i, l := range lines {
+		if l.Kind == ' ' {
+			continue
+		}
+		for k := max(i-contextLines, 0); k <= min(i+contextLines, len(lines)-1); k++ {
+			near[k] = true
+		}
+	}
+	var folded []Line
+	for i, l := range lines {
+		switch {
+		case near[i]:
+			folded = append(folded, l)
+		case len(folded) == 0 || folded[len(folded)-1].Kind != '~':
+			folded = append(folded, Line{Kind: '~'})
+		}
+	}
+	return folded
+}
+
+// Stat counts the lines added and removed in lines
+func Stat(lines []Line) (added, removed int) {
+	for _, l := range lines {
+		switch l.Kind {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,12 @@
+package throttle
+
+import (
+	"bytes"
+	"os/exec"
+)
+
+// onBattery reports whether pmset says the power comes from the battery
+func onBattery() bool {
+	out, err := exec.Command("pmset", "-g", "batt").Output()
+	return err == nil && bytes.Contains(out, []byte("'Battery Power'"))
+}
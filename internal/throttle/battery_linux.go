@@ -0,0 +1,31 @@
+package throttle
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// onBattery reports whether the machine has a battery and no power supply
+// online, from /sys/class/power_supply
+func onBattery() bool {
+	supplies, _ := filepath.Glob("/sys/class/power_supply/*")
+	battery := false
+	for _, dir := range supplies {
+		kind, _ := os.ReadFile(filepath.Join(dir, "type"))
+		switch strings.TrimSpace(string(kind)) {
+		case "Battery":
+			// Batteries of mice and headsets say scope Device
+			scope, _ := os.ReadFile(filepath.Join(dir, "scope"))
+			if strings.TrimSpace(string(scope)) != "Device" {
+				battery = true
+			}
+		case "Mains", "USB", "USB_C", "USB_PD":
+			online, _ := os.ReadFile(filepath.Join(dir, "online"))
+			if strings.TrimSpace(string(online)) == "1" {
+				return false
+			}
+		}
+	}
+	return battery
+}
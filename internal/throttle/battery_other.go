@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+
+package throttle
+
+// onBattery cannot tell on this platform, so the workers never pause
+func onBattery() bool {
+	return false
+}
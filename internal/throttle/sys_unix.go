@@ -0,0 +1,22 @@
+//go:build !windows
+
+package throttle
+
+import (
+	"syscall"
+	"time"
+)
+
+// setNice sets the process's nice value to n
+func setNice(n int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, n)
+}
+
+// cpuTime is the CPU time the process has used so far
+func cpuTime() (time.Duration, error) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, err
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano()), nil
+}
@@ -0,0 +1,55 @@
+//go:build windows
+
+package throttle
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	kernel32                 = windows.NewLazySystemDLL("kernel32.dll")
+	procGetSystemPowerStatus = kernel32.NewProc("GetSystemPowerStatus")
+)
+
+// systemPowerStatus is SYSTEM_POWER_STATUS
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+// setNice maps n onto Windows' priority classes: 1 to 9 is below normal and
+// 10 or more idle
+func setNice(n int) error {
+	class := uint32(windows.BELOW_NORMAL_PRIORITY_CLASS)
+	if n >= 10 {
+		class = windows.IDLE_PRIORITY_CLASS
+	}
+	return windows.SetPriorityClass(windows.CurrentProcess(), class)
+}
+
+// cpuTime is the CPU time the process has used so far
+func cpuTime() (time.Duration, error) {
+	var creation, exit, kernel, user windows.Filetime
+	if err := windows.GetProcessTimes(windows.CurrentProcess(), &creation, &exit, &kernel, &user); err != nil {
+		return 0, err
+	}
+	// Filetimes count 100ns intervals
+	ticks := func(f windows.Filetime) int64 { return int64(f.HighDateTime)<<32 | int64(f.LowDateTime) }
+	return time.Duration((ticks(kernel) + ticks(user)) * 100), nil
+}
+
+// onBattery reports whether the AC line is offline
+func onBattery() bool {
+	var status systemPowerStatus
+	if r, _, _ := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&status))); r == 0 {
+		return false
+	}
+	return status.ACLineStatus == 0
+}
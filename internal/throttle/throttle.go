@@ -0,0 +1,242 @@
+// Package throttle keeps long batch runs from taking over a machine: it
+// lowers the process's priority, caps the CPU its workers use, paces what
+// they read and pauses them while a laptop runs on battery
+package throttle
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"time"
+
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/logging"
+)
+
+// How often the CPU use and the power source are checked
+const (
+	cpuInterval     = time.Second
+	batteryInterval = 30 * time.Second
+)
+
+// restStep is how much the rest between files grows or shrinks each
+// interval, once a single worker is over -max-cpu on its own
+const restStep = 100 * time.Millisecond
+
+// Options are the throttling flags
+type Options struct {
+	// MaxCPU is the share of all cores the process may use, in percent; 0
+	// leaves it uncapped
+	MaxCPU float64
+	// Nice is the process's nice value, 0 to 19, higher giving way more
+	Nice int
+	// Pause is how long each worker waits between files
+	Pause time.Duration
+	// IOLimit is the size, such as 20MB, the workers may read together each
+	// second; empty for no limit
+	IOLimit string
+	// Battery pauses the workers while the machine runs on battery
+	Battery bool
+}
+
+// AddFlags adds -max-cpu, -nice, -pause, -io-limit and -battery-pause
+func AddFlags(fs *flag.FlagSet) *Options {
+	o := &Options{}
+	fs.Float64Var(&o.MaxCPU, "max-cpu", 0, "use at most this percent of all CPU cores, starting fewer workers while the run is over it; 0 is no limit")
+	fs.IntVar(&o.Nice, "nice", 0, "run at this nice value, 0 to 19, so other programs come first; on Windows 1 to 9 is below normal priority and 10 or more idle")
+	fs.DurationVar(&o.Pause, "pause", 0, "wait this long between files in each worker, such as 200ms")
+	fs.StringVar(&o.IOLimit, "io-limit", "", "read at most this many bytes per second across all workers, such as 20MB")
+	fs.BoolVar(&o.Battery, "battery-pause", false, "pause while the computer runs on battery and resume when it is plugged in")
+	return o
+}
+
+// Throttle holds back a pool of workers. A nil *Throttle lets them run
+type Throttle struct {
+	opts    *Options
+	ioLimit int64
+	workers int
+
+	mu sync.Mutex
+	// changed is signalled when allowed or onBattery change or the run stops
+	changed   *sync.Cond
+	allowed   int
+	rest      time.Duration
+	onBattery bool
+	started   []bool
+	ioNext    time.Time
+}
+
+// Start applies opts to a pool of workers, lowering the priority at once,
+// and checks the CPU use and the power source in the background until ctx
+// is done. It returns nil when opts throttle nothing
+func Start(ctx context.Context, opts *Options, workers int) (*Throttle, error) {
+	if opts == nil {
+		return nil, nil
+	}
+	var ioLimit int64
+	if opts.IOLimit != "" {
+		n, err := fsutil.ParseByteSize(opts.IOLimit)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid -io-limit %q", opts.IOLimit)
+		}
+		ioLimit = n
+	}
+	switch {
+	case opts.MaxCPU < 0 || opts.MaxCPU > 100:
+		return nil, fmt.Errorf("invalid -max-cpu %g: it is a percent", opts.MaxCPU)
+	case opts.Nice < 0 || opts.Nice > 19:
+		return nil, fmt.Errorf("invalid -nice %d: it is 0 to 19", opts.Nice)
+	case opts.Pause < 0:
+		return nil, fmt.Errorf("invalid -pause %s", opts.Pause)
+	}
+	if opts.Nice > 0 {
+		if err := setNice(opts.Nice); err != nil {
+			logging.Warnf("Failed to lower the priority: %s", err)
+		}
+	}
+	if opts.MaxCPU == 0 && opts.Pause == 0 && ioLimit == 0 && !opts.Battery {
+		return nil, nil
+	}
+
+	workers = max(workers, 1)
+	t := &Throttle{opts: opts, ioLimit: ioLimit, workers: workers, allowed: workers, started: make([]bool, workers)}
+	t.changed = sync.NewCond(&t.mu)
+	context.AfterFunc(ctx, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.changed.Broadcast()
+	})
+	if opts.MaxCPU > 0 {
+		// A first guess from the core count, corrected as the run goes
+		t.allowed = min(max(int(math.Ceil(float64(runtime.NumCPU())*opts.MaxCPU/100)), 1), workers)
+		go t.watchCPU(ctx)
+	}
+	if opts.Battery {
+		t.onBattery = onBattery()
+		if t.onBattery {
+			logging.Infof("Running on battery; waiting for the computer to be plugged in")
+		}
+		go t.watchBattery(ctx)
+	}
+	return t, nil
+}
+
+// Wait holds worker, numbered from 0, back until it may start on a file of
+// size bytes: while more workers run than the CPU cap allows, while the
+// machine is on battery, for the pause between files and for its share of
+// the I/O limit. It returns ctx's error once ctx is done
+func (t *Throttle) Wait(ctx context.Context, worker int, size int64) error {
+	if t == nil {
+		return ctx.Err()
+	}
+	t.mu.Lock()
+	for ctx.Err() == nil && (worker >= t.allowed || t.onBattery) {
+		t.changed.Wait()
+	}
+	pause := time.Duration(0)
+	if worker < len(t.started) {
+		if t.started[worker] {
+			pause = t.opts.Pause + t.rest
+		}
+		t.started[worker] = true
+	}
+	if t.ioLimit > 0 && size > 0 {
+		// Each file takes its turn on a shared clock, so the workers
+		// together read no faster than the limit
+		now := time.Now()
+		start := t.ioNext
+		if start.Before(now) {
+			start = now
+		}
+		t.ioNext = start.Add(time.Duration(float64(size) / float64(t.ioLimit) * float64(time.Second)))
+		pause = max(pause, start.Sub(now))
+	}
+	t.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if pause > 0 {
+		timer := time.NewTimer(pause)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// watchCPU lets one worker fewer start while the process uses more than
+// the cap, and one more once it is well under it. A single worker over the
+// cap rests longer between files instead
+func (t *Throttle) watchCPU(ctx context.Context) {
+	last, err := cpuTime()
+	if err != nil {
+		logging.Warnf("Failed to measure CPU use, -max-cpu only limits the workers: %s", err)
+		return
+	}
+	lastAt := time.Now()
+	ticker := time.NewTicker(cpuInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		used, err := cpuTime()
+		if err != nil {
+			continue
+		}
+		now := time.Now()
+		percent := float64(used-last) / float64(now.Sub(lastAt)) / float64(runtime.NumCPU()) * 100
+		last, lastAt = used, now
+
+		t.mu.Lock()
+		switch {
+		case percent > t.opts.MaxCPU && t.allowed > 1:
+			t.allowed--
+			logging.Debugf("Using %.0f%% CPU; down to %d workers", percent, t.allowed)
+		case percent > t.opts.MaxCPU:
+			t.rest += restStep
+			logging.Debugf("Using %.0f%% CPU; resting %s between files", percent, t.rest)
+		case percent < t.opts.MaxCPU*0.8 && t.rest > 0:
+			t.rest -= restStep
+			logging.Debugf("Using %.0f%% CPU; resting %s between files", percent, t.rest)
+		case percent < t.opts.MaxCPU*0.8 && t.allowed < t.workers:
+			t.allowed++
+			logging.Debugf("Using %.0f%% CPU; up to %d workers", percent, t.allowed)
+			t.changed.Broadcast()
+		}
+		t.mu.Unlock()
+	}
+}
+
+// watchBattery pauses the workers while the machine is on battery
+func (t *Throttle) watchBattery(ctx context.Context) {
+	ticker := time.NewTicker(batteryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		battery := onBattery()
+		t.mu.Lock()
+		if battery != t.onBattery {
+			t.onBattery = battery
+			if battery {
+				logging.Infof("Running on battery; pausing until the computer is plugged in")
+			} else {
+				logging.Infof("Plugged in; resuming")
+				t.changed.Broadcast()
+			}
+		}
+		t.mu.Unlock()
+	}
+}
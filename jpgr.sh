@@ -1,3 +1,3 @@
 #!/bin/bash
 
-go run /Users/chukwuemeriwoukeje/jpgr.go
+go run "$(dirname "$0")/cmd/goodness" img "$@"
@@ -0,0 +1,504 @@
+package jpgr
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/imaging"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults for annotations that set no color, width or size of their own
+const (
+	annotateColor    = "#e5383b"
+	annotateWidth    = 4
+	annotateFontSize = 20
+)
+
+// annotationSpec is an annotation file: defaults and the marks to draw, in
+// order, so later ones are drawn over earlier ones
+type annotationSpec struct {
+	Color       string       `yaml:"color"`
+	Width       float64      `yaml:"width"`
+	FontSize    float64      `yaml:"font-size"`
+	Annotations []annotation `yaml:"annotations"`
+}
+
+// annotation is one mark, in pixels of the screenshot. Exactly one of
+// rect, arrow, step and text is set
+type annotation struct {
+	// Rect is x, y, width and height of an outlined box
+	Rect []float64 `yaml:"rect"`
+	// Arrow is x, y of its tail and x, y of its point
+	Arrow []float64 `yaml:"arrow"`
+	// Step is x, y of the center of a numbered marker, numbered from 1 or
+	// on from Number
+	Step   []float64 `yaml:"step"`
+	Number int       `yaml:"number"`
+	// Text is a label whose box has its top left corner at At
+	Text string    `yaml:"text"`
+	At   []float64 `yaml:"at"`
+
+	Color    string  `yaml:"color"`
+	Width    float64 `yaml:"width"`
+	FontSize float64 `yaml:"font-size"`
+}
+
+// loadAnnotationSpec reads an annotation file
+func loadAnnotationSpec(path string) (*annotationSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	spec := &annotationSpec{}
+	if err := dec.Decode(spec); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return spec, nil
+}
+
+// check resolves the defaults of every annotation and validates it
+func (s *annotationSpec) check() error {
+	if _, err := parseHexColor(s.Color); err != nil {
+		return err
+	}
+	if s.Width <= 0 || s.FontSize <= 0 {
+		return errors.New("width and font-size must be positive")
+	}
+	for i := range s.Annotations {
+		a := &s.Annotations[i]
+		a.Color = cmp.Or(a.Color, s.Color)
+		a.Width = cmp.Or(a.Width, s.Width)
+		a.FontSize = cmp.Or(a.FontSize, s.FontSize)
+		if err := a.check(); err != nil {
+			return fmt.Errorf("annotation %d: %s", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (a *annotation) check() error {
+	kinds := 0
+	for _, set := range []bool{a.Rect != nil, a.Arrow != nil, a.Step != nil, a.Text != ""} {
+		if set {
+			kinds++
+		}
+	}
+	switch {
+	case kinds != 1:
+		return errors.New("set exactly one of rect, arrow, step and text")
+	case a.Rect != nil && (len(a.Rect) != 4 || a.Rect[2] <= 0 || a.Rect[3] <= 0):
+		return errors.New("rect is x, y, width, height with a positive width and height")
+	case a.Arrow != nil && len(a.Arrow) != 4:
+		return errors.New("arrow is x, y of the tail and x, y of the point")
+	case a.Step != nil && len(a.Step) != 2:
+		return errors.New("step is x, y of the marker's center")
+	case a.Text != "" && len(a.At) != 2:
+		return errors.New("text needs at: x, y of its top left corner")
+	case a.Number < 0:
+		return errors.New("number cannot be negative")
+	case a.Width < 0 || a.FontSize < 0:
+		return errors.New("width and font-size cannot be negative")
+	}
+	_, err := parseHexColor(a.Color)
+	return err
+}
+
+// annotationFlag adds the marks given as -rect, -arrow, -step and -text to
+// a spec, in the order they are given
+type annotationFlag struct {
+	spec *annotationSpec
+	kind string
+}
+
+func (f annotationFlag) String() string { return "" }
+
+func (f annotationFlag) Set(value string) error {
+	fields, label := strings.Split(value, ","), ""
+	if f.kind == "text" {
+		fields = strings.SplitN(value, ",", 3)
+		if len(fields) != 3 || fields[2] == "" {
+			return errors.New("want x,y,label")
+		}
+		fields, label = fields[:2], fields[2]
+	}
+	if want := map[string]int{"rect": 4, "arrow": 4, "step": 2, "text": 2}[f.kind]; len(fields) != want {
+		return fmt.Errorf("want %d numbers separated by commas", want)
+	}
+	numbers := make([]float64, len(fields))
+	for i, field := range fields {
+		n, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return fmt.Errorf("invalid coordinate %q", field)
+		}
+		numbers[i] = n
+	}
+	a := annotation{}
+	switch f.kind {
+	case "rect":
+		a.Rect = numbers
+	case "arrow":
+		a.Arrow = numbers
+	case "step":
+		a.Step = numbers
+	case "text":
+		a.At, a.Text = numbers, label
+	}
+	f.spec.Annotations = append(f.spec.Annotations, a)
+	return nil
+}
+
+func runAnnotate(args []string) {
+	fs := flag.NewFlagSet("annotate", flag.ExitOnError)
+	logging.AddFlags(fs)
+	specPath := fs.String("spec", "", "YAML file listing the annotations to draw")
+	out := fs.String("out", "", "path of the annotated copy, with a single screenshot (default <name>-annotated next to it)")
+	colorFlag := fs.String("color", annotateColor, "color of annotations that name none, as #rrggbb, unless the spec sets one")
+	width := fs.Float64("width", annotateWidth, "line width in pixels of rectangles and arrows, unless the spec sets one")
+	fontSize := fs.Float64("font-size", annotateFontSize, "text size in pixels of labels and step numbers, unless the spec sets one")
+	grid := fs.Int("grid", 0, "instead of annotating, write <name>-grid with lines and coordinates every this many pixels, to read positions off")
+	flags := &annotationSpec{}
+	fs.Var(annotationFlag{flags, "rect"}, "rect", "outline the box `x,y,width,height`; may be repeated")
+	fs.Var(annotationFlag{flags, "arrow"}, "arrow", "draw an arrow from `x1,y1,x2,y2`, tail to head; may be repeated")
+	fs.Var(annotationFlag{flags, "step"}, "step", "place the next numbered step marker centered on `x,y`; may be repeated")
+	fs.Var(annotationFlag{flags, "text"}, "text", "write `x,y,label` as a label with its top left corner at x,y; may be repeated")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img annotate [flags] screenshot ...\n\nDraws rectangles, arrows, numbered step markers and text labels onto\nscreenshots and writes annotated copies, leaving the screenshots as they\nwere. Annotations come from a -spec file, from -rect, -arrow, -step and\n-text, or both, and are drawn in that order. Coordinates are pixels from\nthe top left corner; -grid writes a copy with coordinates marked to find\nthem.\n\nA spec file looks like:\n\n  color: \"#e5383b\"\n  annotations:\n    - rect: [40, 120, 300, 60]\n    - arrow: [520, 60, 350, 130]\n    - step: [40, 120]\n    - text: Click Save\n      at: [530, 40]\n      color: \"#1d3557\"\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	if *out != "" && fs.NArg() > 1 {
+		logging.Exitf(exitcode.Usage, "-out takes a single screenshot")
+	}
+	if *grid < 0 {
+		logging.Exitf(exitcode.Usage, "Invalid -grid value %d", *grid)
+	}
+
+	spec := &annotationSpec{}
+	if *specPath != "" {
+		var err error
+		if spec, err = loadAnnotationSpec(*specPath); err != nil {
+			logging.Exitf(exitcode.Usage, "Failed to read the annotations: %s", err)
+		}
+	}
+	spec.Annotations = append(spec.Annotations, flags.Annotations...)
+	spec.Color = cmp.Or(spec.Color, *colorFlag)
+	spec.Width = cmp.Or(spec.Width, *width)
+	spec.FontSize = cmp.Or(spec.FontSize, *fontSize)
+	if err := spec.check(); err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid annotations: %s", err)
+	}
+	if len(spec.Annotations) == 0 && *grid == 0 {
+		logging.Exitf(exitcode.Usage, "Nothing to draw: give -spec, -rect, -arrow, -step, -text or -grid")
+	}
+
+	summary := &runSummary{}
+	for _, path := range fs.Args() {
+		suffix := "-annotated"
+		if *grid > 0 {
+			suffix = "-grid"
+		}
+		outputPath, err := annotateFile(path, *out, suffix, spec, *grid)
+		if err != nil {
+			summary.Fail(path, "annotate", err)
+			continue
+		}
+		summary.Succeed(path)
+		output.Wrote(outputPath)
+		logging.Infof("Annotated copy written: %s", outputPath)
+	}
+	summary.Print(os.Stdout)
+	output.Exit(summary.ExitCode())
+}
+
+// annotateFile draws spec, or a coordinate grid, onto a copy of the image
+// at path and writes it to outputPath, or next to the image with suffix.
+// JPEGs stay JPEGs and everything else becomes a PNG
+func annotateFile(path, outputPath, suffix string, spec *annotationSpec, grid int) (string, error) {
+	src, err := loadImage(path)
+	if err != nil {
+		return "", &stageError{"decode", err}
+	}
+	format, ext := "png", ".png"
+	if formatOf(path) == "jpeg" {
+		format, ext = "jpeg", filepath.Ext(path)
+	}
+	if outputPath == "" {
+		outputPath = strings.TrimSuffix(path, filepath.Ext(path)) + suffix + ext
+	} else if formatOf(outputPath) == "jpeg" {
+		format = "jpeg"
+	}
+
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	a, err := newAnnotator(dst)
+	if err != nil {
+		return "", &stageError{"font", err}
+	}
+	if grid > 0 {
+		a.grid(grid)
+	} else {
+		a.draw(spec)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := imaging.EncodeTo(buf, dst, format, 92); err != nil {
+		return "", &stageError{"encode", err}
+	}
+	if err := writeFileAtomic(outputPath, buf.Bytes(), 0644); err != nil {
+		return "", &stageError{"write", err}
+	}
+	return outputPath, nil
+}
+
+// annotator draws marks onto a screenshot, antialiased
+type annotator struct {
+	dst   *image.RGBA
+	font  *opentype.Font
+	faces map[float64]font.Face
+}
+
+func newAnnotator(dst *image.RGBA) (*annotator, error) {
+	f, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return nil, err
+	}
+	return &annotator{dst: dst, font: f, faces: make(map[float64]font.Face)}, nil
+}
+
+// face is the font at size pixels
+func (a *annotator) face(size float64) font.Face {
+	if face, ok := a.faces[size]; ok {
+		return face
+	}
+	// NewFace only fails for a font that did not parse
+	face, _ := opentype.NewFace(a.font, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	a.faces[size] = face
+	return face
+}
+
+// draw draws the annotations of spec in order
+func (a *annotator) draw(spec *annotationSpec) {
+	step := 0
+	for _, ann := range spec.Annotations {
+		c, _ := parseHexColor(ann.Color)
+		switch {
+		case ann.Rect != nil:
+			a.rect(ann.Rect[0], ann.Rect[1], ann.Rect[2], ann.Rect[3], ann.Width, c)
+		case ann.Arrow != nil:
+			a.arrow(ann.Arrow[0], ann.Arrow[1], ann.Arrow[2], ann.Arrow[3], ann.Width, c)
+		case ann.Step != nil:
+			step++
+			if ann.Number > 0 {
+				step = ann.Number
+			}
+			a.marker(ann.Step[0], ann.Step[1], strconv.Itoa(step), ann.FontSize, c)
+		default:
+			a.label(ann.At[0], ann.At[1], ann.Text, ann.FontSize, c)
+		}
+	}
+}
+
+// point is a position in an image
+type point struct{ x, y float64 }
+
+// fill paints the polygons in c
+func (a *annotator) fill(c color.Color, polygons ...[]point) {
+	fillPolygons(a.dst, c, polygons...)
+}
+
+// fillPolygons paints the polygons onto dst in c, antialiased. A polygon
+// wound the other way to the one around it cuts a hole in it
+func fillPolygons(dst *image.RGBA, c color.Color, polygons ...[]point) {
+	box := image.Rectangle{}
+	for _, p := range polygons {
+		for _, pt := range p {
+			box = box.Union(image.Rect(int(math.Floor(pt.x)), int(math.Floor(pt.y)), int(math.Ceil(pt.x))+1, int(math.Ceil(pt.y))+1))
+		}
+	}
+	// The rasterizer covers only the polygons' part of the image
+	box = box.Intersect(dst.Bounds())
+	if box.Empty() {
+		return
+	}
+	z := vector.NewRasterizer(box.Dx(), box.Dy())
+	for _, p := range polygons {
+		z.MoveTo(float32(p[0].x-float64(box.Min.X)), float32(p[0].y-float64(box.Min.Y)))
+		for _, pt := range p[1:] {
+			z.LineTo(float32(pt.x-float64(box.Min.X)), float32(pt.y-float64(box.Min.Y)))
+		}
+		z.ClosePath()
+	}
+	z.Draw(dst, box, image.NewUniform(c), image.Point{})
+}
+
+// rect outlines the box at x, y with a line width pixels wide, centered on
+// its edges
+func (a *annotator) rect(x, y, w, h, width float64, c color.Color) {
+	o, i := width/2, min(width/2, w/2, h/2)
+	a.fill(c,
+		[]point{{x - o, y - o}, {x + w + o, y - o}, {x + w + o, y + h + o}, {x - o, y + h + o}},
+		[]point{{x + i, y + i}, {x + i, y + h - i}, {x + w - i, y + h - i}, {x + w - i, y + i}},
+	)
+}
+
+// arrow draws a line from x1, y1 with a head pointing at x2, y2
+func (a *annotator) arrow(x1, y1, x2, y2, width float64, c color.Color) {
+	length := math.Hypot(x2-x1, y2-y1)
+	if length == 0 {
+		return
+	}
+	// Unit vectors along the arrow and across it
+	ux, uy := (x2-x1)/length, (y2-y1)/length
+	nx, ny := -uy, ux
+	head := min(max(width*4, 12), length)
+	half := head * 0.6
+	bx, by := x2-ux*head, y2-uy*head
+	w := width / 2
+	a.fill(c,
+		[]point{{x1 + nx*w, y1 + ny*w}, {bx + nx*w, by + ny*w}, {bx - nx*w, by - ny*w}, {x1 - nx*w, y1 - ny*w}},
+		[]point{{x2, y2}, {bx - nx*half, by - ny*half}, {bx + nx*half, by + ny*half}},
+	)
+}
+
+// circle is a polygon close enough to a circle of radius r around x, y
+func circle(x, y, r float64) []point {
+	n := max(int(r*2), 24)
+	p := make([]point, n)
+	for i := range p {
+		angle := 2 * math.Pi * float64(i) / float64(n)
+		p[i] = point{x + r*math.Cos(angle), y + r*math.Sin(angle)}
+	}
+	return p
+}
+
+// roundedRect is the box at x, y with its corners rounded to radius r
+func roundedRect(x, y, w, h, r float64) []point {
+	r = min(r, w/2, h/2)
+	var p []point
+	// Each corner's quarter circle, clockwise from the top left
+	for i, c := range []point{{x + r, y + r}, {x + w - r, y + r}, {x + w - r, y + h - r}, {x + r, y + h - r}} {
+		for step := 0; step <= 8; step++ {
+			angle := math.Pi + float64(i)*math.Pi/2 + float64(step)*math.Pi/16
+			p = append(p, point{c.x + r*math.Cos(angle), c.y + r*math.Sin(angle)})
+		}
+	}
+	return p
+}
+
+// marker draws a filled circle centered on x, y with text in it, ringed in
+// white so it stands out on any background
+func (a *annotator) marker(x, y float64, text string, size float64, c color.NRGBA) {
+	face := a.face(size)
+	textWidth := float64(font.MeasureString(face, text)) / 64
+	r := max(size*0.8, textWidth/2+size*0.35)
+	a.fill(color.White, circle(x, y, r+max(size/10, 2)))
+	a.fill(c, circle(x, y, r))
+	a.text(x-textWidth/2, y, text, face, textColor(c))
+}
+
+// label draws text in a box of color c with its top left corner at x, y.
+// Each line of text is a line of the label
+func (a *annotator) label(x, y float64, text string, size float64, c color.NRGBA) {
+	face := a.face(size)
+	lines := strings.Split(text, "\n")
+	lineHeight := float64(face.Metrics().Height) / 64
+	pad := math.Round(size / 3)
+	textWidth := 0.0
+	for _, line := range lines {
+		textWidth = max(textWidth, float64(font.MeasureString(face, line))/64)
+	}
+	w, h := textWidth+2*pad, lineHeight*float64(len(lines))+2*pad
+	a.fill(c, []point{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}})
+	for i, line := range lines {
+		a.text(x+pad, y+pad+lineHeight*(float64(i)+0.5), line, face, textColor(c))
+	}
+}
+
+// text writes s starting at x with its middle on y
+func (a *annotator) text(x, y float64, s string, face font.Face, c color.Color) {
+	drawText(a.dst, x, y, s, face, c)
+}
+
+// drawText writes s onto dst starting at x with its middle on y and
+// returns where it ends
+func drawText(dst *image.RGBA, x, y float64, s string, face font.Face, c color.Color) float64 {
+	m := face.Metrics()
+	d := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.Point26_6{X: fixed.Int26_6(x * 64), Y: fixed.Int26_6((y + float64(m.Ascent-m.Descent)/64/2) * 64)},
+	}
+	d.DrawString(s)
+	return float64(d.Dot.X) / 64
+}
+
+// textColor is white, or black on light colors
+func textColor(c color.NRGBA) color.Color {
+	if 299*int(c.R)+587*int(c.G)+114*int(c.B) > 160*1000 {
+		return color.Black
+	}
+	return color.White
+}
+
+// grid draws lines every step pixels, labelled with their coordinate, for
+// reading positions off a screenshot
+func (a *annotator) grid(step int) {
+	b := a.dst.Bounds()
+	line := color.NRGBA{R: 0, G: 160, B: 255, A: 160}
+	tag := color.NRGBA{R: 0, G: 110, B: 200, A: 255}
+	face := a.face(12)
+	for x := step; x < b.Dx(); x += step {
+		fx := float64(x)
+		a.fill(line, []point{{fx - 0.5, 0}, {fx + 0.5, 0}, {fx + 0.5, float64(b.Dy())}, {fx - 0.5, float64(b.Dy())}})
+	}
+	for y := step; y < b.Dy(); y += step {
+		fy := float64(y)
+		a.fill(line, []point{{0, fy - 0.5}, {float64(b.Dx()), fy - 0.5}, {float64(b.Dx()), fy + 0.5}, {0, fy + 0.5}})
+	}
+	for x := step; x < b.Dx(); x += step {
+		a.tag(float64(x)+2, 2, strconv.Itoa(x), face, tag)
+	}
+	for y := step; y < b.Dy(); y += step {
+		a.tag(2, float64(y)+2, strconv.Itoa(y), face, tag)
+	}
+}
+
+// tag is a small label for the grid
+func (a *annotator) tag(x, y float64, s string, face font.Face, c color.NRGBA) {
+	w := float64(font.MeasureString(face, s))/64 + 4
+	h := float64(face.Metrics().Height)/64 + 2
+	a.fill(c, []point{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}})
+	a.text(x+2, y+h/2, s, face, color.White)
+}
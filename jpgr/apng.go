@@ -0,0 +1,245 @@
+package jpgr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+)
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// APNG dispose and blend operations from the fcTL chunk
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+	apngBlendOver         = 1
+)
+
+// pngChunk is a raw chunk read from a PNG stream
+type pngChunk struct {
+	kind string
+	data []byte
+}
+
+// apngFrame is one fully composited frame of an animation
+type apngFrame struct {
+	image *image.NRGBA
+	delay int // hundredths of a second
+}
+
+// readPNGChunks splits a PNG stream into its chunks without decoding them
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return nil, errors.New("not a PNG file")
+	}
+
+	var chunks []pngChunk
+	for off := len(pngSignature); off+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[off:]))
+		kind := string(data[off+4 : off+8])
+		if length < 0 || off+12+length > len(data) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		chunks = append(chunks, pngChunk{kind: kind, data: data[off+8 : off+8+length]})
+		off += 12 + length
+		if kind == "IEND" {
+			break
+		}
+	}
+	return chunks, nil
+}
+
+// isAPNG reports whether a PNG stream carries an animation control chunk
+func isAPNG(data []byte) bool {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return false
+	}
+	for _, c := range chunks {
+		switch c.kind {
+		case "acTL":
+			return true
+		case "IDAT":
+			return false
+		}
+	}
+	return false
+}
+
+// writePNGChunk appends a chunk with its length and CRC to buf
+func writePNGChunk(buf *bytes.Buffer, kind string, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.WriteString(kind)
+	buf.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
+
+// decodeAPNG composites every frame of an animated PNG onto the full canvas
+// and returns them with the number of times the animation should play
+func decodeAPNG(data []byte) ([]apngFrame, int, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(chunks) == 0 || chunks[0].kind != "IHDR" || len(chunks[0].data) != 13 {
+		return nil, 0, errors.New("missing IHDR chunk")
+	}
+
+	ihdr := chunks[0].data
+	canvas := image.NewNRGBA(image.Rect(0, 0,
+		int(binary.BigEndian.Uint32(ihdr[0:])), int(binary.BigEndian.Uint32(ihdr[4:]))))
+
+	// Chunks such as PLTE and tRNS apply to every frame
+	var shared []pngChunk
+	plays := 0
+
+	type pending struct {
+		fctl []byte
+		data [][]byte
+	}
+	var frames []pending
+	var current *pending
+
+	for _, c := range chunks[1:] {
+		switch c.kind {
+		case "acTL":
+			if len(c.data) >= 8 {
+				plays = int(binary.BigEndian.Uint32(c.data[4:]))
+			}
+		case "fcTL":
+			if len(c.data) < 26 {
+				return nil, 0, errors.New("short fcTL chunk")
+			}
+			frames = append(frames, pending{fctl: c.data})
+			current = &frames[len(frames)-1]
+		case "IDAT":
+			// IDAT only belongs to the animation when an fcTL precedes it
+			if current != nil {
+				current.data = append(current.data, c.data)
+			}
+		case "fdAT":
+			if current != nil && len(c.data) > 4 {
+				current.data = append(current.data, c.data[4:])
+			}
+		case "IEND":
+		default:
+			if current == nil {
+				shared = append(shared, c)
+			}
+		}
+	}
+	if len(frames) == 0 {
+		return nil, 0, errors.New("animation has no frames")
+	}
+
+	var result []apngFrame
+	for i, f := range frames {
+		w := binary.BigEndian.Uint32(f.fctl[4:])
+		h := binary.BigEndian.Uint32(f.fctl[8:])
+		x := int(binary.BigEndian.Uint32(f.fctl[12:]))
+		y := int(binary.BigEndian.Uint32(f.fctl[16:]))
+		delayNum := int(binary.BigEndian.Uint16(f.fctl[20:]))
+		delayDen := int(binary.BigEndian.Uint16(f.fctl[22:]))
+		dispose := f.fctl[24]
+		blend := f.fctl[25]
+
+		// Rebuild a standalone PNG for the frame so the standard decoder can read it
+		frameIHDR := append([]byte(nil), ihdr...)
+		binary.BigEndian.PutUint32(frameIHDR[0:], w)
+		binary.BigEndian.PutUint32(frameIHDR[4:], h)
+
+		buf := new(bytes.Buffer)
+		buf.WriteString(pngSignature)
+		writePNGChunk(buf, "IHDR", frameIHDR)
+		for _, c := range shared {
+			writePNGChunk(buf, c.kind, c.data)
+		}
+		writePNGChunk(buf, "IDAT", bytes.Join(f.data, nil))
+		writePNGChunk(buf, "IEND", nil)
+
+		frameImg, err := png.Decode(buf)
+		if err != nil {
+			return nil, 0, fmt.Errorf("frame %d: %w", i+1, err)
+		}
+
+		region := image.Rect(x, y, x+int(w), y+int(h))
+		var previous *image.NRGBA
+		if dispose == apngDisposePrevious {
+			previous = image.NewNRGBA(canvas.Rect)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		op := draw.Src
+		if blend == apngBlendOver {
+			op = draw.Over
+		}
+		draw.Draw(canvas, region, frameImg, image.Point{}, op)
+
+		snapshot := image.NewNRGBA(canvas.Rect)
+		copy(snapshot.Pix, canvas.Pix)
+		if delayDen == 0 {
+			delayDen = 100
+		}
+		result = append(result, apngFrame{image: snapshot, delay: delayNum * 100 / delayDen})
+
+		switch dispose {
+		case apngDisposeBackground:
+			draw.Draw(canvas, region, image.Transparent, image.Point{}, draw.Src)
+		case apngDisposePrevious:
+			canvas = previous
+		}
+	}
+
+	return result, plays, nil
+}
+
+// apngToGIF re-encodes an animated PNG as an animated GIF, applying transforms to every frame
+func apngToGIF(data []byte, transforms ...Transform) ([]byte, error) {
+	frames, plays, err := decodeAPNG(data)
+	if err != nil {
+		return nil, err
+	}
+
+	gifPalette := append(color.Palette{color.Transparent}, palette.Plan9[:255]...)
+	// GIF counts repeats after the first play, with -1 meaning play once
+	anim := &gif.GIF{}
+	switch {
+	case plays == 1:
+		anim.LoopCount = -1
+	case plays > 1:
+		anim.LoopCount = plays - 1
+	}
+
+	for _, f := range frames {
+		var img image.Image = f.image
+		for _, transform := range transforms {
+			img = transform(img)
+		}
+
+		b := img.Bounds()
+		paletted := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), gifPalette)
+		draw.FloydSteinberg.Draw(paletted, paletted.Rect, img, b.Min)
+
+		anim.Image = append(anim.Image, paletted)
+		anim.Delay = append(anim.Delay, f.delay)
+		anim.Disposal = append(anim.Disposal, gif.DisposalBackground)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gif.EncodeAll(buf, anim); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,43 @@
+package jpgr
+
+import (
+	"image"
+	"os"
+
+	"GoodnessucWorkflow/internal/fsutil"
+)
+
+// parseByteSize parses sizes such as "300KB", "1.5GB" or "4096"
+var parseByteSize = fsutil.ParseByteSize
+
+// formatBytes renders n with the largest unit parseByteSize accepts for it
+var formatBytes = fsutil.FormatBytes
+
+// estimateMemory guesses the peak memory needed to convert path from its
+// header alone: the decoded pixels, a couple of working copies for the
+// transforms and the encoded output
+func estimateMemory(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	switch formatOf(path) {
+	case "svg":
+		return info.Size() * 64
+	case "raw":
+		// Sensor data is compressed about 2:1 and develops into 16-bit RGB
+		return info.Size() * 12
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return info.Size()
+	}
+	defer f.Close()
+
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return info.Size()
+	}
+	return int64(config.Width)*int64(config.Height)*4*3 + info.Size()*2
+}
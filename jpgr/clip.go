@@ -0,0 +1,169 @@
+package jpgr
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/imaging"
+)
+
+func runClip(args []string) {
+	opts := renameOptions{pad: 1}
+	fs := flag.NewFlagSet("clip", flag.ExitOnError)
+	logging.AddFlags(fs)
+	fs.StringVar(&opts.pattern, "pattern", "clip-{date}-{time}", "file name pattern; supports {date}, {time} and {seq}")
+	fs.StringVar(&opts.dateFormat, "date-format", "2006-01-02", "Go time layout used for {date}")
+	format := fs.String("format", "jpeg", "output format: jpeg, png or gif")
+	quality := fs.Int("quality", 0, "JPEG quality (default encoder quality)")
+	maxWidth := fs.Int("max-width", 0, "scale the image down to at most this many pixels wide")
+	copyPath := fs.Bool("copy-path", false, "put the saved file's path back on the clipboard")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img clip [flags] [directory]\n"))
+		fs.PrintDefaults()
+	}
+	cli.Parse(fs, args)
+
+	directoryPath := directoryArg(fs)
+	if *format == "jpg" {
+		*format = "jpeg"
+	}
+	extension := "." + *format
+	if *format == "jpeg" {
+		extension = ".jpg"
+	}
+
+	data, err := readClipboardImage()
+	if err != nil {
+		logging.Fatalf("Failed to read clipboard: %s", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		logging.Fatalf("Failed to decode clipboard image: %s", err)
+	}
+	if *maxWidth > 0 {
+		img = resizeToFit(img, *maxWidth, img.Bounds().Dy())
+	}
+
+	buf := new(bytes.Buffer)
+	if err := imaging.EncodeTo(buf, img, *format, *quality); err != nil {
+		logging.Fatalf("Failed to convert image: %s", err)
+	}
+
+	if err := os.MkdirAll(directoryPath, 0755); err != nil {
+		logging.Fatalf("Failed to create output directory: %s", err)
+	}
+	outputPath := clipOutputPath(directoryPath, extension, opts)
+	if err := writeFileAtomic(outputPath, buf.Bytes(), 0644); err != nil {
+		logging.Fatalf("Failed to write image file: %s", err)
+	}
+	output.Wrote(outputPath)
+	logging.Infof("Image conversion successful: %s", outputPath)
+
+	if *copyPath {
+		if abs, err := filepath.Abs(outputPath); err == nil {
+			outputPath = abs
+		}
+		if err := writeClipboardText(outputPath); err != nil {
+			logging.Errorf("Failed to copy path to clipboard: %s", err)
+		}
+	}
+}
+
+// clipOutputPath expands the name pattern for now and, when that name is
+// taken, counts {seq} (or a -N suffix) up until it is free
+func clipOutputPath(directoryPath, extension string, opts renameOptions) string {
+	now := time.Now()
+	for seq := 1; ; seq++ {
+		stem := expandPattern(opts, "clip", now, seq)
+		if seq > 1 && !strings.Contains(opts.pattern, "{seq}") {
+			stem = fmt.Sprintf("%s-%d", stem, seq)
+		}
+		path := filepath.Join(directoryPath, stem+extension)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+	}
+}
+
+// readClipboardImage returns the clipboard image as PNG bytes using whatever
+// the platform provides
+func readClipboardImage() ([]byte, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		if path, err := exec.LookPath("pngpaste"); err == nil {
+			return clipboardOutput(path, "-")
+		}
+		// osascript prints the data as «data PNGf89504E47...»
+		out, err := clipboardOutput("osascript", "-e", "the clipboard as «class PNGf»")
+		if err != nil {
+			return nil, err
+		}
+		text := strings.TrimSpace(string(out))
+		text = strings.TrimSuffix(strings.TrimPrefix(text, "«data PNGf"), "»")
+		return hex.DecodeString(text)
+	case "windows":
+		script := "Add-Type -AssemblyName System.Windows.Forms; Add-Type -AssemblyName System.Drawing; " +
+			"$img = [Windows.Forms.Clipboard]::GetImage(); if ($img -eq $null) { exit 1 }; " +
+			"$ms = New-Object IO.MemoryStream; $img.Save($ms, [Drawing.Imaging.ImageFormat]::Png); " +
+			"$out = [Console]::OpenStandardOutput(); $out.Write($ms.ToArray(), 0, $ms.Length)"
+		return clipboardOutput("powershell", "-NoProfile", "-STA", "-Command", script)
+	default:
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			if path, err := exec.LookPath("wl-paste"); err == nil {
+				return clipboardOutput(path, "--type", "image/png")
+			}
+		}
+		return clipboardOutput("xclip", "-selection", "clipboard", "-target", "image/png", "-out")
+	}
+}
+
+// writeClipboardText replaces the clipboard contents with text
+func writeClipboardText(text string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("pbcopy")
+	case "windows":
+		cmd = exec.Command("clip")
+	default:
+		if _, err := exec.LookPath("wl-copy"); err == nil && os.Getenv("WAYLAND_DISPLAY") != "" {
+			cmd = exec.Command("wl-copy")
+		} else {
+			cmd = exec.Command("xclip", "-selection", "clipboard")
+		}
+	}
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+// clipboardOutput runs a clipboard tool and treats empty output as an empty clipboard
+func clipboardOutput(name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", name, msg)
+		}
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no image on the clipboard")
+	}
+	return out, nil
+}
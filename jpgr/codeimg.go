@@ -0,0 +1,392 @@
+package jpgr
+
+import (
+	"bytes"
+	"cmp"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/highlight"
+	"GoodnessucWorkflow/pkg/imaging"
+	"GoodnessucWorkflow/pkg/markdown"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/opentype"
+)
+
+// codeTheme colors a code image: the backdrop, the window and each kind of
+// token, as #rrggbb
+type codeTheme struct {
+	backdrop   string
+	window     string
+	lineNumber string
+	kinds      map[highlight.Kind]string
+}
+
+var codeThemes = map[string]codeTheme{
+	"dark": {backdrop: "#7b8bab", window: "#282c34", lineNumber: "#5c6370", kinds: map[highlight.Kind]string{
+		highlight.Plain: "#abb2bf", highlight.Keyword: "#c678dd", highlight.Type: "#e5c07b", highlight.String: "#98c379",
+		highlight.Number: "#d19a66", highlight.Comment: "#7f848e", highlight.Function: "#61afef",
+	}},
+	"light": {backdrop: "#d0d7de", window: "#ffffff", lineNumber: "#8c959f", kinds: map[highlight.Kind]string{
+		highlight.Plain: "#24292f", highlight.Keyword: "#cf222e", highlight.Type: "#0550ae", highlight.String: "#0a3069",
+		highlight.Number: "#0550ae", highlight.Comment: "#6e7781", highlight.Function: "#8250df",
+	}},
+	"monokai": {backdrop: "#a6a28c", window: "#272822", lineNumber: "#75715e", kinds: map[highlight.Kind]string{
+		highlight.Plain: "#f8f8f2", highlight.Keyword: "#f92672", highlight.Type: "#66d9ef", highlight.String: "#e6db74",
+		highlight.Number: "#ae81ff", highlight.Comment: "#75715e", highlight.Function: "#a6e22e",
+	}},
+	"nord": {backdrop: "#5e81ac", window: "#2e3440", lineNumber: "#4c566a", kinds: map[highlight.Kind]string{
+		highlight.Plain: "#d8dee9", highlight.Keyword: "#81a1c1", highlight.Type: "#8fbcbb", highlight.String: "#a3be8c",
+		highlight.Number: "#b48ead", highlight.Comment: "#616e88", highlight.Function: "#88c0d0",
+	}},
+}
+
+// The colors of the close, minimize and zoom buttons of the window chrome
+var chromeButtons = []color.NRGBA{{0xff, 0x5f, 0x56, 0xff}, {0xff, 0xbd, 0x2e, 0xff}, {0x27, 0xc9, 0x3f, 0xff}}
+
+// codeImageOptions are the codeimg flags
+type codeImageOptions struct {
+	lang        string
+	theme       codeTheme
+	backdrop    color.NRGBA
+	padding     float64
+	fontSize    float64
+	scale       float64
+	tabWidth    int
+	chrome      bool
+	title       string
+	lineNumbers bool
+}
+
+// codeSnippet is code to render and where its image goes
+type codeSnippet struct {
+	source string
+	lang   string
+	code   string
+	title  string
+	output string
+}
+
+func runCodeImage(args []string) {
+	opts := codeImageOptions{}
+	fs := flag.NewFlagSet("codeimg", flag.ExitOnError)
+	logging.AddFlags(fs)
+	fs.StringVar(&opts.lang, "syntax", "", "language of the code (default from the file extension or the code block): "+strings.Join(highlight.Languages(), ", "))
+	themeName := fs.String("theme", "dark", "colors: "+strings.Join(sortedKeys(codeThemes), ", "))
+	backdrop := fs.String("background", "", "color around the window as #rrggbb, or none for transparent (default the theme's)")
+	fs.Float64Var(&opts.padding, "padding", 48, "space around the window in pixels, before -scale")
+	fs.Float64Var(&opts.fontSize, "font-size", 16, "text size in pixels, before -scale")
+	fs.Float64Var(&opts.scale, "scale", 2, "pixels per point, 2 for sharp text on high-density screens")
+	fs.IntVar(&opts.tabWidth, "tab-width", 4, "columns a tab advances to")
+	fs.BoolVar(&opts.chrome, "chrome", true, "draw a title bar with window buttons")
+	fs.StringVar(&opts.title, "title", "", "title in the title bar (default the file name)")
+	fs.BoolVar(&opts.lineNumbers, "line-numbers", false, "number the lines")
+	block := fs.Int("block", 0, "with a markdown file, render only this code block, counting from 1 (default all of them)")
+	out := fs.String("out", "", "path of the image, when there is only one (default <file>.png, or <post>-code-N.png for markdown)")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img codeimg [flags] file ...\n\nRenders code as a PNG with syntax highlighting, in a window on a colored\nbackground, for social posts and sites that mangle code. Each file is\nrendered whole, or each fenced code block of a markdown file is rendered\nto an image of its own. - reads the code from stdin and needs -syntax\nand -out.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	theme, ok := codeThemes[*themeName]
+	if !ok {
+		logging.Exitf(exitcode.Usage, "Invalid -theme value %q", *themeName)
+	}
+	opts.theme = theme
+	switch *backdrop {
+	case "":
+		opts.backdrop, _ = parseHexColor(theme.backdrop)
+	case "none":
+	default:
+		c, err := parseHexColor(*backdrop)
+		if err != nil {
+			logging.Exitf(exitcode.Usage, "Invalid -background value %q", *backdrop)
+		}
+		opts.backdrop = c
+	}
+	if opts.lang != "" {
+		if _, ok := highlight.Lookup(opts.lang); !ok {
+			logging.Warnf("No highlighting for %s; rendering the code plain", opts.lang)
+		}
+	}
+	if opts.padding < 0 || opts.fontSize <= 0 || opts.scale <= 0 || opts.tabWidth < 1 || *block < 0 {
+		logging.Exitf(exitcode.Usage, "Invalid -padding, -font-size, -scale, -tab-width or -block: sizes must be positive")
+	}
+
+	summary := &runSummary{}
+	var snippets []codeSnippet
+	for _, path := range fs.Args() {
+		found, err := readSnippets(path, opts.lang, *block)
+		if err != nil {
+			summary.Fail(path, "read", err)
+			continue
+		}
+		snippets = append(snippets, found...)
+	}
+	if *out != "" {
+		if len(snippets) > 1 {
+			logging.Exitf(exitcode.Usage, "-out needs a single image; these files hold %d code blocks", len(snippets))
+		}
+		for i := range snippets {
+			snippets[i].output = *out
+		}
+	}
+	for _, s := range snippets {
+		if s.output == "" {
+			logging.Exitf(exitcode.Usage, "Code read from stdin needs -out")
+		}
+	}
+
+	mono, err1 := opentype.Parse(gomono.TTF)
+	bold, err2 := opentype.Parse(gomonobold.TTF)
+	if err1 != nil || err2 != nil {
+		logging.Fatalf("Failed to load the font: %s", cmp.Or(err1, err2))
+	}
+	for _, s := range snippets {
+		if opts.title != "" {
+			s.title = opts.title
+		}
+		img := renderCode(s, opts, mono, bold)
+		buf := new(bytes.Buffer)
+		if err := imaging.EncodeTo(buf, img, "png", 0); err != nil {
+			summary.Fail(s.source, "encode", err)
+			continue
+		}
+		if err := writeFileAtomic(s.output, buf.Bytes(), 0644); err != nil {
+			summary.Fail(s.source, "write", err)
+			continue
+		}
+		summary.Succeed(s.source)
+		output.Wrote(s.output)
+		logging.Infof("Code image written: %s", s.output)
+	}
+	summary.Print(os.Stdout)
+	output.Exit(summary.ExitCode())
+}
+
+// readSnippets reads the code in path: the whole file, or the fenced code
+// blocks of a markdown file, only the one numbered block when it is not 0.
+// lang, when set, overrides the language of every snippet
+func readSnippets(path, lang string, block int) ([]codeSnippet, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	stem := strings.TrimSuffix(path, filepath.Ext(path))
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".md" && ext != ".markdown" {
+		s := codeSnippet{source: path, lang: cmp.Or(lang, ext), code: string(data), title: filepath.Base(path)}
+		if path != "-" {
+			s.output = path + ".png"
+		} else {
+			s.source, s.title = "stdin", ""
+		}
+		return []codeSnippet{s}, nil
+	}
+
+	blocks := markdown.CodeBlocks(string(data))
+	if block > len(blocks) {
+		return nil, fmt.Errorf("there is no code block %d, only %d", block, len(blocks))
+	}
+	var snippets []codeSnippet
+	for i, b := range blocks {
+		if block > 0 && i+1 != block {
+			continue
+		}
+		snippets = append(snippets, codeSnippet{
+			source: path + ":" + strconv.Itoa(b.Line),
+			lang:   cmp.Or(lang, b.Lang),
+			code:   b.Code,
+			output: fmt.Sprintf("%s-code-%d.png", stem, i+1),
+		})
+	}
+	if len(snippets) == 0 {
+		logging.Warnf("No code blocks in %s", path)
+	}
+	return snippets, nil
+}
+
+// codeLines splits code into lines of tokens, with tabs expanded, the
+// indentation all lines share removed and blank lines around it dropped
+func codeLines(lang, code string, tabWidth int) [][]highlight.Token {
+	lines := strings.Split(strings.ReplaceAll(code, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = expandTabs(strings.TrimRight(line, " \t"), tabWidth)
+	}
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	indent := -1
+	for _, line := range lines {
+		if line != "" {
+			n := len(line) - len(strings.TrimLeft(line, " "))
+			if indent < 0 || n < indent {
+				indent = n
+			}
+		}
+	}
+	for i, line := range lines {
+		if len(line) >= indent && indent > 0 {
+			lines[i] = line[indent:]
+		}
+	}
+
+	// Tokens run across lines, comments and strings especially, so they are
+	// cut at each newline
+	result := [][]highlight.Token{nil}
+	for _, t := range highlight.Tokens(lang, strings.Join(lines, "\n")) {
+		for i, part := range strings.Split(t.Text, "\n") {
+			if i > 0 {
+				result = append(result, nil)
+			}
+			if part != "" {
+				result[len(result)-1] = append(result[len(result)-1], highlight.Token{Kind: t.Kind, Text: part})
+			}
+		}
+	}
+	return result
+}
+
+// expandTabs replaces the tabs in line with spaces to the next tab stop
+func expandTabs(line string, width int) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var b strings.Builder
+	column := 0
+	for _, r := range line {
+		if r == '\t' {
+			n := width - column%width
+			b.WriteString(strings.Repeat(" ", n))
+			column += n
+			continue
+		}
+		b.WriteRune(r)
+		column++
+	}
+	return b.String()
+}
+
+// renderCode draws s as highlighted text in a window on the backdrop
+func renderCode(s codeSnippet, opts codeImageOptions, mono, bold *opentype.Font) *image.RGBA {
+	scale := opts.scale
+	faceOptions := &opentype.FaceOptions{Size: opts.fontSize * scale, DPI: 72, Hinting: font.HintingFull}
+	// NewFace only fails for a font that did not parse
+	regular, _ := opentype.NewFace(mono, faceOptions)
+	strong, _ := opentype.NewFace(bold, faceOptions)
+
+	lines := codeLines(s.lang, s.code, opts.tabWidth)
+	columns := 0
+	for _, line := range lines {
+		n := 0
+		for _, t := range line {
+			n += utf8.RuneCountInString(t.Text)
+		}
+		columns = max(columns, n)
+	}
+	advance, _ := regular.GlyphAdvance('M')
+	charWidth := float64(advance) / 64
+	lineHeight := opts.fontSize * scale * 1.5
+	gutter := 0.0
+	if opts.lineNumbers {
+		gutter = float64(len(strconv.Itoa(len(lines)))+2) * charWidth
+	}
+	inset, bar := 24*scale, 0.0
+	if opts.chrome {
+		bar = 36 * scale
+	}
+	padding := opts.padding * scale
+	windowW := max(2*inset+gutter+float64(columns)*charWidth, 240*scale)
+	windowH := bar + 2*inset + float64(len(lines))*lineHeight
+	if opts.chrome {
+		windowH -= inset / 2
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, int(windowW+2*padding), int(windowH+2*padding)))
+	if opts.backdrop.A > 0 {
+		fillPolygons(dst, opts.backdrop, []point{{0, 0}, {float64(dst.Rect.Dx()), 0}, {float64(dst.Rect.Dx()), float64(dst.Rect.Dy())}, {0, float64(dst.Rect.Dy())}})
+	}
+
+	// A soft shadow of stacked translucent windows under the window
+	x, y, radius := padding, padding, 8*scale
+	for i := 12.0; i >= 1; i-- {
+		spread := i * 1.5 * scale
+		fillPolygons(dst, color.NRGBA{A: 7}, roundedRect(x-spread, y-spread+6*scale, windowW+2*spread, windowH+2*spread, radius+spread))
+	}
+	window, _ := parseHexColor(opts.theme.window)
+	fillPolygons(dst, window, roundedRect(x, y, windowW, windowH, radius))
+
+	top := y + inset
+	if opts.chrome {
+		for i, c := range chromeButtons {
+			fillPolygons(dst, c, circle(x+20*scale+float64(i)*20*scale, y+bar/2+2*scale, 6*scale))
+		}
+		if s.title != "" {
+			titleColor, _ := parseHexColor(opts.theme.kinds[highlight.Comment])
+			titleFace, _ := opentype.NewFace(mono, &opentype.FaceOptions{Size: opts.fontSize * scale * 0.85, DPI: 72, Hinting: font.HintingFull})
+			width := float64(font.MeasureString(titleFace, s.title)) / 64
+			drawText(dst, x+(windowW-width)/2, y+bar/2+2*scale, s.title, titleFace, titleColor)
+		}
+		top = y + bar + inset/2
+	}
+
+	numberColor, _ := parseHexColor(opts.theme.lineNumber)
+	for i, line := range lines {
+		middle := top + (float64(i)+0.5)*lineHeight
+		left := x + inset
+		if opts.lineNumbers {
+			n := strconv.Itoa(i + 1)
+			drawText(dst, left+gutter-float64(len(n)+2)*charWidth, middle, n, regular, numberColor)
+			left += gutter
+		}
+		for _, t := range line {
+			face := regular
+			if t.Kind == highlight.Keyword {
+				face = strong
+			}
+			c, _ := parseHexColor(opts.theme.kinds[t.Kind])
+			left = drawText(dst, left, middle, t.Text, face, c)
+		}
+	}
+	return dst
+}
+
+// sortedKeys lists the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
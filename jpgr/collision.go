@@ -0,0 +1,84 @@
+package jpgr
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"GoodnessucWorkflow/pkg/errs"
+)
+
+// outputNames hands each source of a run its own output path, so two sources
+// never write to the same file. Names are compared case-insensitively since
+// a.png and a.PNG both become a.jpg
+type outputNames struct {
+	mu      sync.Mutex
+	fail    bool
+	stems   map[string]string
+	planned map[string]bool
+	taken   map[string]bool
+	claimed map[string]string
+}
+
+// planOutputNames assigns output stems to paths in order. A stem already
+// assigned to an earlier source gets -2, -3 and so on, or is an error when
+// fail is set. Planning in the state file's order keeps resumed runs stable
+func planOutputNames(paths []string, roots map[string]string, fail bool) (*outputNames, error) {
+	names := &outputNames{
+		fail:    fail,
+		stems:   make(map[string]string),
+		planned: make(map[string]bool),
+		taken:   make(map[string]bool),
+		claimed: make(map[string]string),
+	}
+	owners := make(map[string]string)
+	for _, path := range paths {
+		base := filepath.Base(path)
+		stem := filepath.Join(roots[path], strings.TrimSuffix(base, filepath.Ext(base)))
+		candidate := stem
+		for n := 2; names.planned[strings.ToLower(candidate)]; n++ {
+			if fail {
+				return nil, fmt.Errorf("%s and %s both map to the output name %s", owners[strings.ToLower(stem)], path, stem)
+			}
+			candidate = fmt.Sprintf("%s-%d", stem, n)
+		}
+		names.stems[path] = candidate
+		names.planned[strings.ToLower(candidate)] = true
+		owners[strings.ToLower(candidate)] = path
+	}
+	return names, nil
+}
+
+// claim returns the output path for path with extension. When the source is
+// replaced by its output, a file already on disk at that path belongs to
+// something else and is never overwritten. Claiming again, as a retry does,
+// returns the same path
+func (n *outputNames) claim(path, extension string, replacesSource bool) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if target, ok := n.claimed[path+extension]; ok {
+		return target, nil
+	}
+	stem := n.stems[path]
+	target := stem + extension
+	free := func(candidate string) bool {
+		return !n.taken[strings.ToLower(candidate)] && (!replacesSource || candidate == path || !fileExists(candidate))
+	}
+	if !free(target) {
+		if n.fail {
+			return "", errs.Exists("write", target)
+		}
+		for i := 2; ; i++ {
+			candidate := fmt.Sprintf("%s-%d", stem, i)
+			if !n.planned[strings.ToLower(candidate)] && free(candidate+extension) {
+				target = candidate + extension
+				break
+			}
+		}
+	}
+	n.taken[strings.ToLower(target)] = true
+	n.claimed[path+extension] = target
+	return target, nil
+}
@@ -0,0 +1,207 @@
+package jpgr
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/imaging"
+)
+
+// comparison holds the quality metrics for one image at one encoder quality
+type comparison struct {
+	path    string
+	quality int
+	ssim    float64
+	psnr    float64
+	ratio   float64 // encoded size as a fraction of the source file
+}
+
+func runCompare(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	logging.AddFlags(fs)
+	qualities := fs.String("quality", "60,75,90", "comma separated JPEG qualities to evaluate")
+	top := fs.Int("top", 10, "number of worst offenders to list per quality")
+	addWalkFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img compare [flags] [directory]\n\nEncodes every PNG at each quality in memory and reports SSIM and PSNR against the original.\n\n"))
+		fs.PrintDefaults()
+	}
+	cli.Parse(fs, args)
+
+	directoryPath := directoryArg(fs)
+
+	var levels []int
+	for _, s := range strings.Split(*qualities, ",") {
+		q, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || q < 1 || q > 100 {
+			logging.Exitf(exitcode.Usage, "Invalid quality %q", s)
+		}
+		levels = append(levels, q)
+	}
+
+	results := make(map[int][]comparison)
+	err := walkTree(directoryPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || formatOf(path) != "png" {
+			return nil
+		}
+
+		img, err := loadImage(path)
+		if err != nil {
+			logging.Errorf("Failed to read image file: %s", err)
+			return nil
+		}
+
+		for _, q := range levels {
+			buf := new(bytes.Buffer)
+			if err := imaging.EncodeTo(buf, img, "jpeg", q); err != nil {
+				logging.Errorf("Failed to convert image: %s", err)
+				continue
+			}
+			size := buf.Len()
+			converted, _, err := image.Decode(buf)
+			if err != nil {
+				logging.Errorf("Failed to decode converted image: %s", err)
+				continue
+			}
+
+			results[q] = append(results[q], comparison{
+				path:    path,
+				quality: q,
+				ssim:    ssim(img, converted),
+				psnr:    psnr(img, converted),
+				ratio:   float64(size) / float64(info.Size()),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		logging.Fatalf("Error processing directory: %s", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "QUALITY\tFILES\tMEAN SSIM\tMIN SSIM\tMEAN PSNR\tMEAN SIZE")
+	var rows []map[string]any
+	for _, q := range levels {
+		rs := results[q]
+		if len(rs) == 0 {
+			continue
+		}
+		var sumSSIM, sumPSNR, sumRatio float64
+		minSSIM := math.Inf(1)
+		for _, r := range rs {
+			sumSSIM += r.ssim
+			sumPSNR += r.psnr
+			sumRatio += r.ratio
+			minSSIM = math.Min(minSSIM, r.ssim)
+		}
+		n := float64(len(rs))
+		fmt.Fprintf(tw, "%d\t%d\t%.4f\t%.4f\t%.2f dB\t%.0f%%\n", q, len(rs), sumSSIM/n, minSSIM, sumPSNR/n, 100*sumRatio/n)
+		rows = append(rows, map[string]any{"quality": q, "files": len(rs), "mean_ssim": sumSSIM / n, "min_ssim": minSSIM, "mean_psnr": sumPSNR / n, "mean_size_ratio": sumRatio / n})
+	}
+	tw.Flush()
+	output.Set("qualities", rows)
+
+	for _, q := range levels {
+		rs := results[q]
+		sort.Slice(rs, func(i, j int) bool { return rs[i].ssim < rs[j].ssim })
+
+		fmt.Printf("\nWorst at quality %d:\n", q)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, r := range rs[:min(*top, len(rs))] {
+			fmt.Fprintf(tw, "  %.4f\t%.2f dB\t%s\n", r.ssim, r.psnr, r.path)
+		}
+		tw.Flush()
+	}
+}
+
+// toRGBA copies img into an RGBA image anchored at the origin
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// psnr returns the peak signal-to-noise ratio over the RGB channels in dB
+func psnr(a, b image.Image) float64 {
+	pa, pb := toRGBA(a), toRGBA(b)
+	if pa.Rect != pb.Rect {
+		return 0
+	}
+
+	var sum float64
+	for i := 0; i < len(pa.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			d := float64(pa.Pix[i+c]) - float64(pb.Pix[i+c])
+			sum += d * d
+		}
+	}
+	mse := sum / float64(len(pa.Pix)/4*3)
+	if mse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/mse)
+}
+
+// ssim returns the mean structural similarity of the luma channels, computed
+// over 8x8 windows with a stride of 4
+func ssim(a, b image.Image) float64 {
+	pa, pb := toRGBA(a), toRGBA(b)
+	if pa.Rect != pb.Rect {
+		return 0
+	}
+
+	w, h := pa.Rect.Dx(), pa.Rect.Dy()
+	luma := func(p *image.RGBA, x, y int) float64 {
+		i := p.PixOffset(x, y)
+		return 0.299*float64(p.Pix[i]) + 0.587*float64(p.Pix[i+1]) + 0.114*float64(p.Pix[i+2])
+	}
+
+	const window, stride = 8, 4
+	c1 := math.Pow(0.01*255, 2)
+	c2 := math.Pow(0.03*255, 2)
+
+	var total float64
+	count := 0
+	for y := 0; y+window <= max(h, window); y += stride {
+		for x := 0; x+window <= max(w, window); x += stride {
+			var sa, sb, saa, sbb, sab float64
+			n := 0.0
+			for dy := 0; dy < window && y+dy < h; dy++ {
+				for dx := 0; dx < window && x+dx < w; dx++ {
+					va, vb := luma(pa, x+dx, y+dy), luma(pb, x+dx, y+dy)
+					sa += va
+					sb += vb
+					saa += va * va
+					sbb += vb * vb
+					sab += va * vb
+					n++
+				}
+			}
+			ma, mb := sa/n, sb/n
+			va, vb := saa/n-ma*ma, sbb/n-mb*mb
+			cov := sab/n - ma*mb
+
+			total += ((2*ma*mb + c1) * (2*cov + c2)) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			count++
+		}
+	}
+	return total / float64(count)
+}
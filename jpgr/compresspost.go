@@ -0,0 +1,314 @@
+package jpgr
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/imaging"
+	"GoodnessucWorkflow/pkg/markdown"
+)
+
+// compressedImage is one image a post links to and what compress-post made
+// of it. Output is the source itself when it was kept as it was
+type compressedImage struct {
+	Source  string `json:"source"`
+	Output  string `json:"output"`
+	Before  int64  `json:"before"`
+	After   int64  `json:"after"`
+	Resized bool   `json:"resized,omitempty"`
+}
+
+// compressOptions are the compress-post flags
+type compressOptions struct {
+	convert   convertOptions
+	maxWidth  int
+	maxHeight int
+	dryRun    bool
+	deleteOld bool
+}
+
+// CompressPost is goodness md compress-post, which makes the local images a
+// markdown post links to ready to publish: scaled down, compressed and
+// converted as configured, with the links pointed at the results
+func CompressPost(args []string) {
+	fs := flag.NewFlagSet("compress-post", flag.ExitOnError)
+	logging.AddFlags(fs)
+	maxWidth := fs.Int("max-width", 1600, "scale images wider than this down; 0 leaves the width alone")
+	maxHeight := fs.Int("max-height", 0, "scale images taller than this down; 0 leaves the height alone")
+	to := fs.String("to", "auto", "format of the compressed images: auto (by content), jpeg, png, webp or keep")
+	quality := fs.Int("quality", 0, "JPEG and WebP quality, 1-100 (default the encoder's, or the one auto picks)")
+	targetSize := fs.String("target-size", "", "search the JPEG quality so each image is at most this size, e.g. 300KB")
+	root := fs.String("root", "", "folder that links starting with / are relative to, such as the site's static folder (default the post's folder)")
+	configPath := fs.String("config", "", "conversion policy file whose rules pick the format and quality per image (default "+policyFileName+" next to the post)")
+	dryRun := fs.Bool("dry-run", false, "report what would be compressed and relinked without changing any file")
+	deleteOld := fs.Bool("delete-originals", false, "remove originals that were converted to another format; other posts linking to them break")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness md compress-post [flags] post.md ...\n\nMakes the local images a post links to ready to publish. Each image is\nscaled down to -max-width, compressed and converted per -to and the\nconversion policy, and the post's links are pointed at the results. An\nimage is only replaced when the result is smaller; images in the same\nformat are replaced in place and others written next to them. Animated\nimages and SVGs are left alone.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	format := strings.ToLower(*to)
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	if format != "auto" && format != "jpeg" && format != "png" && format != "webp" && format != "keep" {
+		logging.Exitf(exitcode.Usage, "Invalid -to value %q", *to)
+	}
+	if *quality < 0 || *quality > 100 {
+		logging.Exitf(exitcode.Usage, "Invalid -quality value %d", *quality)
+	}
+	if *maxWidth < 0 || *maxHeight < 0 {
+		logging.Exitf(exitcode.Usage, "Invalid -max-width or -max-height: sizes cannot be negative")
+	}
+	var target int64
+	if *targetSize != "" {
+		var err error
+		target, err = parseByteSize(*targetSize)
+		if err != nil || target <= 0 {
+			logging.Exitf(exitcode.Usage, "Invalid -target-size %q", *targetSize)
+		}
+	}
+
+	summary := &runSummary{}
+	opts := compressOptions{
+		convert: convertOptions{
+			summary:    summary,
+			output:     format,
+			quality:    *quality,
+			svgScale:   1,
+			targetSize: target,
+		},
+		maxWidth:  *maxWidth,
+		maxHeight: *maxHeight,
+		dryRun:    *dryRun,
+		deleteOld: *deleteOld,
+	}
+	if *maxWidth > 0 || *maxHeight > 0 {
+		opts.convert.transforms = []Transform{imaging.Fit(*maxWidth, *maxHeight)}
+	}
+
+	// The images of every post, each once, in the order they are linked
+	posts := fs.Args()
+	var images []string
+	seen := make(map[string]bool)
+	roots := make(map[string]string)
+	for _, post := range posts {
+		data, err := os.ReadFile(post)
+		if err != nil {
+			logging.Fatalf("Failed to read %s: %s", post, err)
+		}
+		dir, err := filepath.Abs(filepath.Dir(post))
+		if err != nil {
+			logging.Fatalf("Failed to read %s: %s", post, err)
+		}
+		roots[post] = dir
+		if *root != "" {
+			if roots[post], err = filepath.Abs(*root); err != nil {
+				logging.Fatalf("Failed to resolve %s: %s", *root, err)
+			}
+		}
+		for _, link := range markdown.Links(string(data)) {
+			l, ok := parseLocalLink(link.Target, dir, roots[post])
+			if !ok || seen[l.path] {
+				continue
+			}
+			seen[l.path] = true
+			if kind := formatOf(l.path); kind == "png" || kind == "jpeg" || kind == "gif" {
+				images = append(images, l.path)
+			}
+		}
+	}
+
+	policyDir := filepath.Dir(posts[0])
+	policies, err := loadPolicies([]string{policyDir}, *configPath)
+	if err != nil {
+		logging.Fatalf("Failed to load conversion policy: %s", err)
+	}
+	policy := policies[policyDir]
+
+	ctx := cli.SignalContext()
+	converted := newConvertedFiles()
+	results := []compressedImage{}
+	for _, path := range images {
+		if ctx.Err() != nil {
+			summary.Interrupt()
+			break
+		}
+		result, err := compressImage(path, policy, opts)
+		if err != nil {
+			summary.Fail(path, "compress", err)
+			continue
+		}
+		summary.Succeed(path)
+		results = append(results, result)
+		if result.Output != path {
+			converted.Record(path, result.Output)
+		}
+	}
+
+	for _, post := range posts {
+		if err := relinkPost(post, roots[post], converted, opts.dryRun); err != nil {
+			summary.Fail(post, "markdown", err)
+		}
+	}
+
+	output.Set("images", results)
+	printCompressed(os.Stdout, results)
+	summary.Print(os.Stdout)
+	output.Exit(summary.ExitCode())
+}
+
+// compressImage scales, compresses and converts one image, keeping the
+// source unless the result is smaller
+func compressImage(path string, policy *conversionPolicy, opts compressOptions) (compressedImage, error) {
+	result := compressedImage{Source: path, Output: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return result, &stageError{"read", err}
+	}
+	result.Before, result.After = int64(len(data)), int64(len(data))
+	if isAPNG(data) || isAnimatedGIF(data) {
+		logging.Infof("Keeping %s: it is animated", path)
+		return result, nil
+	}
+
+	conv := opts.convert
+	rule, err := policy.ruleFor(path, data, conv)
+	if err != nil {
+		return result, &stageError{"policy", err}
+	}
+	if rule != nil {
+		if rule.Skip {
+			logging.Infof("Keeping %s: excluded by the conversion policy", path)
+			return result, nil
+		}
+		conv.output, conv.quality = rule.To, cmp.Or(rule.Quality, conv.quality)
+	}
+
+	img, err := decodeSource(path, data, conv)
+	if err != nil {
+		return result, &stageError{"decode", err}
+	}
+	b := img.Bounds()
+	result.Resized = opts.maxWidth > 0 && b.Dx() > opts.maxWidth || opts.maxHeight > 0 && b.Dy() > opts.maxHeight
+	format := formatOf(path)
+	switch conv.output {
+	case "keep":
+		conv.output = format
+	case "auto":
+		choice := imaging.Choose(imaging.Analyze(img))
+		logging.Debugf("Picked %s for %s: %s", choice.Format, path, choice.Reason)
+		conv.output, conv.quality, conv.palette = choice.Format, cmp.Or(conv.quality, choice.Quality), choice.Palette
+	}
+	encoded, err := conv.encode(img)
+	if err != nil {
+		return result, &stageError{"encode", err}
+	}
+	if len(encoded) >= len(data) {
+		logging.Infof("Keeping %s: it is already as small as %s makes it", path, conv.output)
+		return result, nil
+	}
+
+	// The same format keeps the name, and with it every link elsewhere
+	outputPath := path
+	if conv.output != format {
+		stem := strings.TrimSuffix(path, filepath.Ext(path))
+		outputPath = stem + conv.extension()
+		for n := 2; fileExists(outputPath); n++ {
+			outputPath = fmt.Sprintf("%s-%d%s", stem, n, conv.extension())
+		}
+	}
+	result.Output, result.After = outputPath, int64(len(encoded))
+	if opts.dryRun {
+		return result, nil
+	}
+	if err := writeFileAtomic(outputPath, encoded, 0644); err != nil {
+		return result, &stageError{"write", err}
+	}
+	output.Wrote(outputPath)
+	if opts.deleteOld && outputPath != path {
+		if err := journal.Remove(path); err != nil {
+			return result, &stageError{"delete original", err}
+		}
+	}
+	return result, nil
+}
+
+// relinkPost points the links in post at the converted images
+func relinkPost(post, root string, converted *convertedFiles, dryRun bool) error {
+	info, err := os.Stat(post)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(post)
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(filepath.Dir(post))
+	if err != nil {
+		return err
+	}
+	updated, count := markdown.RewriteLinks(string(data), func(target string) (string, bool) {
+		return converted.relink(target, dir, root)
+	})
+	switch {
+	case count == 0:
+		return nil
+	case dryRun:
+		logging.Infof("Would update %d links in %s", count, post)
+		return nil
+	}
+	if err := writeFileAtomic(post, []byte(updated), info.Mode().Perm()); err != nil {
+		return err
+	}
+	output.Wrote(post)
+	logging.Infof("Markdown links updated: %s (%d)", post, count)
+	return nil
+}
+
+// printCompressed lists each image with its size before and after, and the
+// total saved
+func printCompressed(w io.Writer, results []compressedImage) {
+	if len(results) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, i18n.T("IMAGE\tBEFORE\tAFTER\tSAVED"))
+	var before, after int64
+	for _, r := range results {
+		name := r.Source
+		if r.Output != r.Source {
+			name += " -> " + filepath.Base(r.Output)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d%%\n", name, formatBytes(r.Before), formatBytes(r.After), savedPercent(r.Before, r.After))
+		before += r.Before
+		after += r.After
+	}
+	tw.Flush()
+	fmt.Fprint(w, i18n.Sprintf("\nSaved %s of %s (%d%%)\n", formatBytes(before-after), formatBytes(before), savedPercent(before, after)))
+}
+
+func savedPercent(before, after int64) int64 {
+	if before == 0 {
+		return 0
+	}
+	return (before - after) * 100 / before
+}
@@ -0,0 +1,522 @@
+package jpgr
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"GoodnessucWorkflow/internal/cache"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/internal/stats"
+	"GoodnessucWorkflow/internal/throttle"
+	"GoodnessucWorkflow/pkg/errs"
+	"GoodnessucWorkflow/pkg/imaging"
+	"golang.org/x/sync/semaphore"
+)
+
+// convertOptions controls a conversion run
+type convertOptions struct {
+	transforms []Transform
+	apng       string
+	svgScale   float64
+	svgWidth   int
+	svgHeight  int
+	sidecar    bool
+	statePath  string
+	resume     bool
+	uploader   *s3Uploader
+	summary    *runSummary
+
+	workers      int
+	memoryBudget int64
+	// throttle holds the workers back for -max-cpu, -pause, -io-limit and
+	// -battery-pause
+	throttle *throttle.Throttle
+
+	targetSize   int64
+	targetResize bool
+
+	// output and quality are the encoding for the current file; a policy
+	// rule may change them per file, and output auto picks them from the
+	// image. palette stores PNGs with few colors as paletted images
+	output     string
+	quality    int
+	palette    bool
+	configPath string
+	policies   map[string]*conversionPolicy
+
+	failOnConflict bool
+	names          *outputNames
+
+	xattrs *xattrSelection
+
+	retries    int
+	retryDelay time.Duration
+
+	runLog *runLog
+
+	// converted collects each source's output for -markdown
+	converted *convertedFiles
+
+	// cache skips sources converted before with the same cacheFlags and
+	// policy
+	cache      *cache.Cache
+	cacheFlags map[string]string
+}
+
+// convertInputs converts every PNG named in inputs or found under the
+// directories in inputs to JPEG and removes the original. SVGs are rasterized
+// to JPEG next to their source. Progress is saved after every file so an
+// interrupted run can continue with -resume. Once ctx is done no more files
+// are started; those already started finish and are saved
+func convertInputs(ctx context.Context, inputs []string, opts convertOptions) {
+	statePath := opts.statePath
+	if statePath == "" {
+		statePath = filepath.Join(inputDirectory(inputs[0]), stateFileName)
+	}
+
+	var roots []string
+	for _, input := range inputs {
+		if _, err := os.Stat(input); err != nil {
+			logging.Exitf(exitcode.Usage, "Error processing directory: %s", err)
+		}
+		roots = append(roots, inputDirectory(input))
+	}
+
+	// A crashed or killed run leaves its lock behind; the next run sees that
+	// its process is gone and takes the lock over
+	lock, err := acquireRunLock(append(roots, filepath.Dir(statePath)))
+	var locked *lockedError
+	if errors.As(err, &locked) {
+		logging.Exitf(exitcode.Locked, "Failed to lock directory: %s", err)
+	} else if err != nil {
+		logging.Fatalf("Failed to lock directory: %s", err)
+	}
+	defer lock.Release()
+
+	var state *runState
+	if opts.resume {
+		state, err = loadRunState(statePath)
+		if err != nil {
+			logging.Fatalf("Failed to load state file: %s", err)
+		}
+		if !slices.Equal(state.Inputs, inputs) {
+			logging.Exitf(exitcode.Usage, "State file belongs to %s, not %s", strings.Join(state.Inputs, " "), strings.Join(inputs, " "))
+		}
+		logging.Infof("Resuming run started %s: %d done, %d failed, %d left",
+			state.StartedAt.Local().Format(time.DateTime), len(state.Completed), len(state.Failed), len(state.Pending()))
+	}
+
+	policies, err := loadPolicies(roots, opts.configPath)
+	if err != nil {
+		logging.Fatalf("Failed to load conversion policy: %s", err)
+	}
+	opts.policies = policies
+
+	if state == nil {
+		paths, roots, err := collectInputs(inputs, policies)
+		if err != nil {
+			logging.Fatalf("Error processing directory: %s", err)
+		}
+		state = newRunState(statePath, inputs, paths, roots)
+	}
+
+	names, err := planOutputNames(state.Planned, state.Roots, opts.failOnConflict)
+	if err != nil {
+		logging.Fatalf("Output name conflict: %s", err)
+	}
+	opts.names = names
+
+	if err := state.Save(); err != nil {
+		logging.Fatalf("Failed to write state file: %s", err)
+	}
+
+	// Every file reserves its estimated memory before it is decoded; files
+	// bigger than the whole budget wait until they can run alone
+	budget := semaphore.NewWeighted(opts.memoryBudget)
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < max(opts.workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				key, hit := opts.cache.Lookup(path, cache.Options("img convert", opts.cacheFlags, opts.policies[state.Roots[path]]))
+				if hit != nil {
+					logging.Debugf("Skipping %s: unchanged since it was converted", path)
+					opts.summary.Cached(path)
+					state.MarkCompleted(path)
+					if opts.converted != nil && len(hit.Outputs) > 0 {
+						opts.converted.Record(path, hit.Outputs[0])
+					}
+					if err := state.Save(); err != nil {
+						logging.Errorf("Failed to write state file: %s", err)
+					}
+					continue
+				}
+
+				// A file still held back or waiting for memory when ctx is
+				// done is left pending, like those never started
+				var size int64
+				if info, err := os.Stat(path); err == nil {
+					size = info.Size()
+				}
+				if err := opts.throttle.Wait(ctx, i, size); err != nil {
+					continue
+				}
+				cost := min(max(estimateMemory(path), 1), opts.memoryBudget)
+				if err := budget.Acquire(ctx, cost); err != nil {
+					continue
+				}
+				var record conversionRecord
+				err := retryTransient(opts.retries, opts.retryDelay, path, func() error {
+					var err error
+					record, err = convertFile(state.Roots[path], path, opts)
+					return err
+				})
+				budget.Release(cost)
+
+				if opts.runLog != nil {
+					if err := opts.runLog.Record(path, record, err); err != nil {
+						logging.Errorf("Failed to write run log: %s", err)
+					}
+				}
+
+				if err != nil {
+					opts.summary.Fail(path, "convert", err)
+					state.MarkFailed(path, err)
+				} else {
+					opts.summary.Succeed(path)
+					state.MarkCompleted(path)
+					if opts.converted != nil && record.Output != "" {
+						opts.converted.Record(path, record.Output)
+					}
+					// A PNG is gone once converted, so there is nothing to skip
+					if fileExists(path) {
+						var outputs []string
+						if record.Output != "" {
+							outputs = append(outputs, record.Output)
+							if opts.sidecar {
+								outputs = append(outputs, record.Output+".json")
+							}
+						}
+						opts.cache.Store(key, outputs, nil)
+					}
+				}
+
+				if err := state.Save(); err != nil {
+					logging.Errorf("Failed to write state file: %s", err)
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, path := range state.Pending() {
+		select {
+		case jobs <- path:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if opts.uploader != nil {
+		opts.uploader.Wait()
+	}
+
+	if left := len(state.Pending()); left > 0 {
+		logging.Warnf("Stopped with %d files left; run again with -resume to convert them", left)
+		return
+	}
+	// Keep the state file while there are failures so they can be inspected
+	if len(state.Failed) == 0 {
+		if err := state.Remove(); err != nil {
+			logging.Errorf("Failed to remove state file: %s", err)
+		}
+	}
+}
+
+// collectInputs expands files and directories into the files a run converts,
+// each mapped to the directory its output is written to. A file reached
+// through more than one argument is converted once. policies, keyed by input
+// directory, decide which files are included
+func collectInputs(inputs []string, policies map[string]*conversionPolicy) ([]string, map[string]string, error) {
+	var paths []string
+	roots := make(map[string]string)
+	seen := make(map[string]bool)
+	add := func(root, path string) {
+		key, err := filepath.Abs(path)
+		if err != nil {
+			key = filepath.Clean(path)
+		}
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		paths = append(paths, path)
+		roots[path] = root
+	}
+
+	for _, input := range inputs {
+		info, err := os.Stat(input)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !info.IsDir() {
+			if !policies[filepath.Dir(input)].collects(input) {
+				logging.Warnf("Skipping %s: not a PNG, SVG or RAW file, or excluded by the conversion policy", input)
+				continue
+			}
+			add(filepath.Dir(input), input)
+			continue
+		}
+
+		found, err := collectConvertible(input, policies[input])
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, path := range found {
+			add(input, path)
+		}
+	}
+	return paths, roots, nil
+}
+
+// inputDirectory returns input itself for a directory and its parent for a file
+func inputDirectory(input string) string {
+	if info, err := os.Stat(input); err == nil && !info.IsDir() {
+		return filepath.Dir(input)
+	}
+	return input
+}
+
+// collectConvertible lists the files under directoryPath that a run converts
+// under policy, which may be nil
+func collectConvertible(directoryPath string, policy *conversionPolicy) ([]string, error) {
+	var paths []string
+	err := walkTree(directoryPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() && policy.collects(path) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// Pending lists the files under dir that goodness img convert would
+// convert, following the directory's policy file
+func Pending(dir string) ([]string, error) {
+	policies, err := loadPolicies([]string{dir}, "")
+	if err != nil {
+		return nil, err
+	}
+	return collectConvertible(dir, policies[dir])
+}
+
+// convertFile converts a single file and writes the result into
+// directoryPath, following the first policy rule that applies to it
+func convertFile(directoryPath, path string, opts convertOptions) (conversionRecord, error) {
+	format := formatOf(path)
+	var record conversionRecord
+
+	imageBytes, err := os.ReadFile(path)
+	if err != nil {
+		return record, &stageError{"read", err}
+	}
+	record.SourceBytes = len(imageBytes)
+
+	rule, err := opts.policies[directoryPath].ruleFor(path, imageBytes, opts)
+	if err != nil {
+		return record, &stageError{"policy", err}
+	}
+	if rule != nil {
+		if rule.Skip {
+			logging.Infof("Skipping %s: excluded by the conversion policy", path)
+			return record, nil
+		}
+		opts.output, opts.quality = rule.To, rule.Quality
+	}
+
+	started := time.Now()
+	var decoded image.Image
+	if opts.output == "auto" {
+		if decoded, err = decodeSource(path, imageBytes, opts); err != nil {
+			return record, &stageError{"decode", err}
+		}
+		choice := imaging.Choose(imaging.Analyze(decoded))
+		logging.Debugf("Picked %s for %s: %s", choice.Format, path, choice.Reason)
+		// Re-encoding a file into its own format only loses quality
+		if choice.Format == format && len(opts.transforms) == 0 {
+			logging.Infof("Keeping %s: it is already %s, which suits it best", path, format)
+			return record, nil
+		}
+		opts.output, opts.quality, opts.palette = choice.Format, cmp.Or(opts.quality, choice.Quality), choice.Palette
+	}
+
+	var outputBytes []byte
+	var outputExtension string
+	switch format {
+	case "svg":
+		outputBytes, err = convertSVG(imageBytes, opts)
+		outputExtension = opts.extension()
+	case "png":
+		outputBytes, outputExtension, err = convertPNG(path, imageBytes, opts)
+	default:
+		img := decoded
+		if img == nil {
+			img, err = decodeSource(path, imageBytes, opts)
+		}
+		if err == nil {
+			outputBytes, err = opts.encode(img)
+		}
+		outputExtension = opts.extension()
+	}
+	record.Duration = time.Since(started)
+	if err != nil {
+		return record, &stageError{"convert", err}
+	}
+	if outputBytes == nil {
+		return record, nil
+	}
+	record.OutputBytes = len(outputBytes)
+	if outputExtension == ".jpg" && opts.targetSize == 0 {
+		record.Quality = cmp.Or(opts.quality, jpeg.DefaultQuality)
+	} else if outputExtension == ".webp" {
+		record.Quality = opts.quality
+	}
+
+	outputPath, err := opts.names.claim(path, outputExtension, format == "png")
+	if err != nil {
+		return record, &stageError{"name", err}
+	}
+	baseName := filepath.Base(path)
+	if filepath.Base(outputPath) != strings.TrimSuffix(baseName, filepath.Ext(baseName))+outputExtension {
+		logging.Warnf("Output name for %s is taken, writing %s instead", path, outputPath)
+	}
+
+	err = writeFileAtomic(outputPath, outputBytes, 0644)
+	if err != nil {
+		return record, &stageError{"write", err}
+	}
+	record.Output = outputPath
+
+	if err := copyXattrs(path, outputPath, opts.xattrs); err != nil {
+		opts.summary.Fail(path, "xattrs", err)
+	}
+
+	if opts.sidecar {
+		if err := writeSidecar(path, imageBytes, outputPath, outputBytes, record.Quality, record.Duration); err != nil {
+			opts.summary.Fail(path, "sidecar", err)
+		}
+	}
+
+	// SVGs and RAW files are sources rather than captures, so they are kept
+	if format == "png" && outputPath != path {
+		want, err := encodedSize(outputBytes)
+		if err == nil {
+			err = verifyOutput(outputPath, want)
+		}
+		if err != nil {
+			// The output is ours, since a PNG's output name never replaces
+			// an existing file, and is worse than nothing
+			journal.Remove(outputPath)
+			return record, &stageError{"verify", err}
+		}
+		err = journal.Remove(path)
+		if err != nil {
+			return record, &stageError{"delete original", err}
+		}
+	}
+
+	output.Wrote(outputPath)
+	stats.Format(format, cmp.Or(formatOf(outputExtension), strings.TrimPrefix(outputExtension, ".")))
+	logging.Infof("Image conversion successful: %s", outputPath)
+
+	if opts.uploader != nil {
+		opts.uploader.Enqueue(directoryPath, outputPath)
+	}
+
+	return record, nil
+}
+
+// encode applies the run's transforms and encodes img as opts.output. JPEGs
+// search for a quality that fits -target-size when one is set
+func (opts convertOptions) encode(img image.Image) ([]byte, error) {
+	if opts.output == "jpeg" && opts.targetSize > 0 {
+		for _, transform := range opts.transforms {
+			img = transform(img)
+		}
+		return encodeUnderSize(img, opts.targetSize, opts.targetResize)
+	}
+	return imaging.Encode(context.Background(), img, opts.imagingOptions())
+}
+
+// imagingOptions are the options for the current file as pkg/imaging takes them
+func (opts convertOptions) imagingOptions() imaging.Options {
+	return imaging.Options{
+		Format:     opts.output,
+		Quality:    opts.quality,
+		Palette:    opts.palette,
+		Transforms: opts.transforms,
+		SVGScale:   opts.svgScale,
+		SVGWidth:   opts.svgWidth,
+		SVGHeight:  opts.svgHeight,
+	}
+}
+
+// extension returns the file extension for opts.output
+func (opts convertOptions) extension() string {
+	if opts.output == "jpeg" {
+		return ".jpg"
+	}
+	return "." + opts.output
+}
+
+// convertPNG converts one PNG and returns the encoded output with its file
+// extension. Animated PNGs follow opts.apng; a nil result means the file was
+// skipped and must be left alone
+func convertPNG(path string, imageBytes []byte, opts convertOptions) ([]byte, string, error) {
+	if !isAPNG(imageBytes) {
+		img, err := png.Decode(bytes.NewReader(imageBytes))
+		if err != nil {
+			return nil, "", errs.Decode(err)
+		}
+		outputBytes, err := opts.encode(img)
+		return outputBytes, opts.extension(), err
+	}
+
+	switch opts.apng {
+	case "gif":
+		gifBytes, err := apngToGIF(imageBytes, opts.transforms...)
+		return gifBytes, ".gif", err
+	case "first":
+		frames, _, err := decodeAPNG(imageBytes)
+		if err != nil {
+			return nil, "", errs.Decode(err)
+		}
+		outputBytes, err := opts.encode(frames[0].image)
+		return outputBytes, opts.extension(), err
+	default:
+		logging.Warnf("Skipping animated PNG, converting it would destroy the animation: %s", path)
+		return nil, "", nil
+	}
+}
@@ -0,0 +1,131 @@
+package jpgr
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+// gravities maps a gravity name to the fraction of the leftover space placed
+// before the crop box on each axis
+var gravities = map[string][2]float64{
+	"northwest": {0, 0},
+	"north":     {0.5, 0},
+	"northeast": {1, 0},
+	"west":      {0, 0.5},
+	"center":    {0.5, 0.5},
+	"east":      {1, 0.5},
+	"southwest": {0, 1},
+	"south":     {0.5, 1},
+	"southeast": {1, 1},
+}
+
+// cropTransforms builds the crop steps requested on the command line. box is
+// either WxH+X+Y for a fixed box or WxH to place the box using gravity; aspect
+// is a ratio such as 16:9 that the result is center-cropped to
+func cropTransforms(box, aspect, gravity string) ([]Transform, error) {
+	anchor, ok := gravities[strings.ToLower(gravity)]
+	if !ok {
+		return nil, fmt.Errorf("unknown gravity %q", gravity)
+	}
+
+	var transforms []Transform
+	if box != "" {
+		w, h, offset, err := parseGeometry(box)
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, func(img image.Image) image.Image {
+			b := img.Bounds()
+			var r image.Rectangle
+			if offset != nil {
+				r = image.Rect(offset.X, offset.Y, offset.X+w, offset.Y+h).Add(b.Min)
+			} else {
+				r = anchoredRect(b, w, h, anchor)
+			}
+			return cropImage(img, r)
+		})
+	}
+
+	if aspect != "" {
+		rw, rh, err := parseAspect(aspect)
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, func(img image.Image) image.Image {
+			b := img.Bounds()
+			w, h := b.Dx(), b.Dy()
+			if w*rh > h*rw {
+				w = h * rw / rh
+			} else {
+				h = w * rh / rw
+			}
+			return cropImage(img, anchoredRect(b, w, h, anchor))
+		})
+	}
+
+	return transforms, nil
+}
+
+// parseGeometry parses WxH or WxH+X+Y; offset is nil when no position is given
+func parseGeometry(s string) (w, h int, offset *image.Point, err error) {
+	size, pos, hasPos := strings.Cut(s, "+")
+	ws, hs, ok := strings.Cut(size, "x")
+	if !ok {
+		return 0, 0, nil, fmt.Errorf("invalid crop geometry %q, want WxH or WxH+X+Y", s)
+	}
+	if w, err = strconv.Atoi(ws); err != nil || w <= 0 {
+		return 0, 0, nil, fmt.Errorf("invalid crop width in %q", s)
+	}
+	if h, err = strconv.Atoi(hs); err != nil || h <= 0 {
+		return 0, 0, nil, fmt.Errorf("invalid crop height in %q", s)
+	}
+
+	if hasPos {
+		xs, ys, ok := strings.Cut(pos, "+")
+		x, errX := strconv.Atoi(xs)
+		y, errY := strconv.Atoi(ys)
+		if !ok || errX != nil || errY != nil {
+			return 0, 0, nil, fmt.Errorf("invalid crop offset in %q", s)
+		}
+		offset = &image.Point{X: x, Y: y}
+	}
+
+	return w, h, offset, nil
+}
+
+// parseAspect parses a ratio written as W:H
+func parseAspect(s string) (int, int, error) {
+	ws, hs, ok := strings.Cut(s, ":")
+	w, errW := strconv.Atoi(ws)
+	h, errH := strconv.Atoi(hs)
+	if !ok || errW != nil || errH != nil || w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("invalid aspect ratio %q, want W:H", s)
+	}
+	return w, h, nil
+}
+
+// anchoredRect places a w by h box inside bounds according to anchor
+func anchoredRect(bounds image.Rectangle, w, h int, anchor [2]float64) image.Rectangle {
+	w = min(w, bounds.Dx())
+	h = min(h, bounds.Dy())
+	x := bounds.Min.X + int(float64(bounds.Dx()-w)*anchor[0])
+	y := bounds.Min.Y + int(float64(bounds.Dy()-h)*anchor[1])
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// cropImage returns the part of img inside r, clipped to the image bounds
+func cropImage(img image.Image, r image.Rectangle) image.Image {
+	r = r.Intersect(img.Bounds())
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(r)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst
+}
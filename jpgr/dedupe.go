@@ -0,0 +1,326 @@
+package jpgr
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/internal/plan"
+	"golang.org/x/image/draw"
+)
+
+// hashedImage is an image file together with its perceptual hash
+type hashedImage struct {
+	path   string
+	hash   uint64
+	pixels int
+	size   int64
+}
+
+func runDedupe(args []string) {
+	fs := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	logging.AddFlags(fs)
+	algorithm := fs.String("hash", "dhash", "perceptual hash: dhash or phash")
+	threshold := fs.Int("threshold", 5, "maximum number of differing hash bits for two images to count as duplicates")
+	action := fs.String("action", "report", "what to do with redundant copies: report, delete or link; link only replaces byte-identical copies")
+	interactive := fs.Bool("interactive", false, "ask before acting on each group")
+	planFile := addPlanFlag(fs)
+	addWalkFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img dedupe [flags] [directory]\n"))
+		fs.PrintDefaults()
+	}
+	cli.Parse(fs, args)
+
+	hashFn := dHash
+	switch *algorithm {
+	case "dhash":
+	case "phash":
+		hashFn = pHash
+	default:
+		logging.Exitf(exitcode.Usage, "Unknown hash %q", *algorithm)
+	}
+	if *action != "report" && *action != "delete" && *action != "link" {
+		logging.Exitf(exitcode.Usage, "Unknown action %q", *action)
+	}
+	var p *plan.Plan
+	if *planFile != "" {
+		if *action == "report" {
+			logging.Exitf(exitcode.Usage, "Invalid -plan use: it needs -action delete or link")
+		}
+		p = plan.New("img dedupe")
+	}
+
+	directoryPath := directoryArg(fs)
+
+	summary := &runSummary{}
+	var images []hashedImage
+	err := walkTree(directoryPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !isRaster(path) {
+			return nil
+		}
+
+		img, err := loadImage(path)
+		if err != nil {
+			logging.Errorf("Failed to read image file: %s", err)
+			summary.Fail(path, "read", err)
+			return nil
+		}
+
+		b := img.Bounds()
+		images = append(images, hashedImage{path: path, hash: hashFn(img), pixels: b.Dx() * b.Dy(), size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		logging.Fatalf("Error processing directory: %s", err)
+	}
+
+	groups := clusterDuplicates(images, *threshold)
+	paths := make([][]string, len(groups))
+	for n, group := range groups {
+		for _, img := range group {
+			paths[n] = append(paths[n], img.path)
+		}
+	}
+	output.Set("groups", paths)
+	if len(groups) == 0 {
+		fmt.Println("No duplicates found")
+	}
+
+	// Every image read counts as done unless acting on it failed
+	failed := make(map[string]bool)
+	fail := func(path, stage string, err error) {
+		summary.Fail(path, stage, err)
+		failed[path] = true
+	}
+	stdin := bufio.NewReader(os.Stdin)
+	for n, group := range groups {
+		keep := group[0]
+		// Only byte-identical copies are linked; near-duplicates, which can
+		// differ in format or content, are listed and left alone
+		dups := group[1:]
+		if *action == "link" {
+			dups = identicalTo(keep, group[1:], fail)
+		}
+		fmt.Printf("Group %d:\n  keep   %s\n", n+1, keep.path)
+		for _, dup := range group[1:] {
+			verb := *action
+			if *action == "link" && !slices.Contains(dups, dup) {
+				verb = "skip"
+			}
+			fmt.Printf("  %-6s %s (distance %d)\n", verb, dup.path, bits.OnesCount64(keep.hash^dup.hash))
+		}
+
+		if *action == "report" || len(dups) == 0 {
+			continue
+		}
+		if *interactive {
+			fmt.Printf("Apply %s to this group? [y/N] ", *action)
+			answer, _ := stdin.ReadString('\n')
+			if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+				continue
+			}
+		}
+
+		for _, dup := range dups {
+			if p != nil {
+				if *action == "delete" {
+					p.Delete(dup.path)
+				} else {
+					p.Link(keep.path, dup.path)
+				}
+				continue
+			}
+			if *action == "delete" {
+				if err := journal.Remove(dup.path); err != nil {
+					logging.Errorf("Failed to delete duplicate: %s", err)
+					fail(dup.path, "delete", err)
+				}
+				continue
+			}
+
+			// Link under a temporary name first so a failed link never loses the copy
+			tmp := dup.path + ".jpgr-link"
+			if err := os.Link(keep.path, tmp); err != nil {
+				logging.Errorf("Failed to link duplicate: %s", err)
+				fail(dup.path, "link", err)
+				continue
+			}
+			if err := journal.Replace(tmp, dup.path); err != nil {
+				os.Remove(tmp)
+				logging.Errorf("Failed to link duplicate: %s", err)
+				fail(dup.path, "link", err)
+			}
+		}
+	}
+	for _, img := range images {
+		if !failed[img.path] {
+			summary.Succeed(img.path)
+		}
+	}
+	summary.Print(os.Stdout)
+	if p != nil {
+		savePlan(os.Stdout, p, *planFile)
+	}
+	output.Exit(summary.ExitCode())
+}
+
+// identicalTo returns the images among dups whose bytes are the same as
+// keep's. Those that cannot be read are left out and passed to fail
+func identicalTo(keep hashedImage, dups []hashedImage, fail func(path, stage string, err error)) []hashedImage {
+	want, err := fileSHA256(keep.path)
+	if err != nil {
+		logging.Errorf("Failed to read image file: %s", err)
+		fail(keep.path, "read", err)
+		return nil
+	}
+	var same []hashedImage
+	for _, dup := range dups {
+		if dup.size != keep.size {
+			continue
+		}
+		sum, err := fileSHA256(dup.path)
+		if err != nil {
+			logging.Errorf("Failed to read image file: %s", err)
+			fail(dup.path, "read", err)
+			continue
+		}
+		if sum == want {
+			same = append(same, dup)
+		}
+	}
+	return same
+}
+
+// clusterDuplicates groups images whose hashes differ by at most threshold
+// bits. Each group is ordered best copy first: most pixels, then largest
+// file, then shortest path
+func clusterDuplicates(images []hashedImage, threshold int) [][]hashedImage {
+	parent := make([]int, len(images))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := range images {
+		for j := i + 1; j < len(images); j++ {
+			if bits.OnesCount64(images[i].hash^images[j].hash) <= threshold {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+
+	byRoot := make(map[int][]hashedImage)
+	for i, img := range images {
+		root := find(i)
+		byRoot[root] = append(byRoot[root], img)
+	}
+
+	var groups [][]hashedImage
+	for _, group := range byRoot {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool {
+			a, b := group[i], group[j]
+			if a.pixels != b.pixels {
+				return a.pixels > b.pixels
+			}
+			if a.size != b.size {
+				return a.size > b.size
+			}
+			if len(a.path) != len(b.path) {
+				return len(a.path) < len(b.path)
+			}
+			return a.path < b.path
+		})
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0].path < groups[j][0].path })
+	return groups
+}
+
+// grayPixels scales img to width by height and returns its luma values row by row
+func grayPixels(img image.Image, width, height int) []float64 {
+	small := image.NewGray(image.Rect(0, 0, width, height))
+	draw.BiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	values := make([]float64, len(small.Pix))
+	for i, v := range small.Pix {
+		values[i] = float64(v)
+	}
+	return values
+}
+
+// dHash compares each pixel of a 9x8 thumbnail with its right neighbour
+func dHash(img image.Image) uint64 {
+	px := grayPixels(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if px[y*9+x] > px[y*9+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// pHash compares the low frequency DCT coefficients of a 32x32 thumbnail with their median
+func pHash(img image.Image) uint64 {
+	const size = 32
+	px := grayPixels(img, size, size)
+
+	coeffs := make([]float64, 0, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					sum += px[y*size+x] *
+						math.Cos(float64(2*x+1)*float64(u)*math.Pi/(2*size)) *
+						math.Cos(float64(2*y+1)*float64(v)*math.Pi/(2*size))
+				}
+			}
+			coeffs = append(coeffs, sum)
+		}
+	}
+
+	// The DC coefficient only carries overall brightness, so leave it out of the median
+	sorted := append([]float64(nil), coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var hash uint64
+	for _, c := range coeffs {
+		hash <<= 1
+		if c > median {
+			hash |= 1
+		}
+	}
+	return hash
+}
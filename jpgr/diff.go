@@ -0,0 +1,136 @@
+package jpgr
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"path/filepath"
+	"strings"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/imaging"
+)
+
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	logging.AddFlags(fs)
+	out := fs.String("out", "", "difference image path (default <first>-diff.png)")
+	threshold := fs.Int("threshold", 16, "largest per-channel difference (0-255) still treated as unchanged")
+	align := fs.String("align", "top-left", "how images of different sizes are lined up: top-left or center")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img diff [flags] <before> <after>\n"))
+		fs.PrintDefaults()
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	if *align != "top-left" && *align != "center" {
+		logging.Exitf(exitcode.Usage, "Invalid -align %q", *align)
+	}
+	beforePath, afterPath := fs.Arg(0), fs.Arg(1)
+	if *out == "" {
+		*out = strings.TrimSuffix(beforePath, filepath.Ext(beforePath)) + "-diff.png"
+	}
+
+	before, err := loadImage(beforePath)
+	if err != nil {
+		logging.Fatalf("Failed to read image file: %s", err)
+	}
+	after, err := loadImage(afterPath)
+	if err != nil {
+		logging.Fatalf("Failed to read image file: %s", err)
+	}
+
+	result, changed, total, bounds := diffImages(toNRGBA(before), toNRGBA(after), *threshold, *align == "center")
+
+	buf := new(bytes.Buffer)
+	if err := imaging.EncodeTo(buf, result, "png", 0); err != nil {
+		logging.Fatalf("Failed to encode difference image: %s", err)
+	}
+	if err := writeFileAtomic(*out, buf.Bytes(), 0644); err != nil {
+		logging.Fatalf("Failed to write difference image: %s", err)
+	}
+
+	output.Set("changed_pixels", changed)
+	output.Set("total_pixels", total)
+	if changed > 0 {
+		output.Set("changed_region", map[string]int{"x": bounds.Min.X, "y": bounds.Min.Y, "width": bounds.Dx(), "height": bounds.Dy()})
+	}
+	fmt.Printf("Changed pixels: %d of %d (%.2f%%)\n", changed, total, 100*float64(changed)/float64(total))
+	if changed > 0 {
+		fmt.Printf("Changed region: %dx%d+%d+%d\n", bounds.Dx(), bounds.Dy(), bounds.Min.X, bounds.Min.Y)
+	}
+	output.Wrote(*out)
+	logging.Infof("Difference image written: %s", *out)
+}
+
+// diffImages lays a and b over a canvas large enough for both and paints
+// changed pixels red over a faded grayscale copy of b. Pixels covered by only
+// one image count as changed. It returns the image, the number of changed
+// and of compared pixels, and the rectangle enclosing the changes
+func diffImages(a, b *image.NRGBA, threshold int, center bool) (*image.NRGBA, int, int, image.Rectangle) {
+	w := max(a.Rect.Dx(), b.Rect.Dx())
+	h := max(a.Rect.Dy(), b.Rect.Dy())
+	offset := func(img *image.NRGBA) image.Point {
+		if !center {
+			return image.Point{}
+		}
+		return image.Pt((w-img.Rect.Dx())/2, (h-img.Rect.Dy())/2)
+	}
+	oa, ob := offset(a), offset(b)
+
+	pixel := func(img *image.NRGBA, o image.Point, x, y int) ([]uint8, bool) {
+		p := image.Pt(x, y).Sub(o)
+		if !p.In(img.Rect) {
+			return nil, false
+		}
+		return img.Pix[img.PixOffset(p.X, p.Y):][:4], true
+	}
+
+	highlight := color.NRGBA{255, 0, 64, 255}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	changed, covered := 0, 0
+	var region image.Rectangle
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			pa, okA := pixel(a, oa, x, y)
+			pb, okB := pixel(b, ob, x, y)
+			if !okA && !okB {
+				continue
+			}
+			covered++
+
+			differs := okA != okB
+			if okA && okB {
+				for c := 0; c < 4; c++ {
+					if d := int(pa[c]) - int(pb[c]); d > threshold || -d > threshold {
+						differs = true
+						break
+					}
+				}
+			}
+
+			if differs {
+				changed++
+				region = region.Union(image.Rect(x, y, x+1, y+1))
+				dst.SetNRGBA(x, y, highlight)
+				continue
+			}
+
+			// Unchanged pixels are kept as faint context
+			gray := uint8((299*int(pb[0]) + 587*int(pb[1]) + 114*int(pb[2])) / 1000)
+			faded := 255 - (255-gray)/4
+			dst.SetNRGBA(x, y, color.NRGBA{faded, faded, faded, 255})
+		}
+	}
+	return dst, changed, covered, region
+}
@@ -0,0 +1,271 @@
+package jpgr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// EXIF tags jpgr reads
+const (
+	tagMake             = 0x010f
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagSoftware         = 0x0131
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagExposureTime     = 0x829a
+	tagFNumber          = 0x829d
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagFocalLength      = 0x920a
+
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+)
+
+// exifTypeSizes is the size in bytes of one value of each TIFF field type
+var exifTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4}
+
+// exifData holds the EXIF fields worth showing at a glance
+type exifData struct {
+	Make             string       `json:"make,omitempty"`
+	Model            string       `json:"model,omitempty"`
+	Software         string       `json:"software,omitempty"`
+	DateTime         string       `json:"date_time,omitempty"`
+	DateTimeOriginal string       `json:"date_time_original,omitempty"`
+	Orientation      int          `json:"orientation,omitempty"`
+	ExposureTime     string       `json:"exposure_time,omitempty"`
+	FNumber          float64      `json:"f_number,omitempty"`
+	ISO              int          `json:"iso,omitempty"`
+	FocalLength      float64      `json:"focal_length_mm,omitempty"`
+	GPS              *gpsPosition `json:"gps,omitempty"`
+}
+
+type gpsPosition struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// jpegSegment is one marker segment from the header of a JPEG stream
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// readJPEGSegments returns the segments before the image data starts
+func readJPEGSegments(data []byte) ([]jpegSegment, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("not a JPEG file")
+	}
+
+	var segments []jpegSegment
+	for off := 2; off+4 <= len(data); {
+		if data[off] != 0xff {
+			return nil, errors.New("corrupt JPEG marker")
+		}
+		marker := data[off+1]
+		if marker == 0xff {
+			off++
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[off+2:]))
+		if length < 2 || off+2+length > len(data) {
+			return nil, errors.New("truncated JPEG segment")
+		}
+		segments = append(segments, jpegSegment{marker: marker, data: data[off+4 : off+2+length]})
+		if marker == 0xda {
+			break
+		}
+		off += 2 + length
+	}
+	return segments, nil
+}
+
+// exifBlock returns the TIFF structure holding the EXIF data of a JPEG or
+// PNG, or nil when there is none
+func exifBlock(data []byte) []byte {
+	if segments, err := readJPEGSegments(data); err == nil {
+		for _, s := range segments {
+			if s.marker == 0xe1 && bytes.HasPrefix(s.data, []byte("Exif\x00\x00")) {
+				return s.data[6:]
+			}
+		}
+		return nil
+	}
+	if chunks, err := readPNGChunks(data); err == nil {
+		for _, c := range chunks {
+			if c.kind == "eXIf" {
+				return c.data
+			}
+		}
+	}
+	return nil
+}
+
+// tiffReader reads IFD entries from a TIFF structure in either byte order
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// exifEntry is one IFD entry with its value bytes resolved
+type exifEntry struct {
+	typ   uint16
+	count int
+	value []byte
+}
+
+func newTIFFReader(data []byte) (*tiffReader, uint32, error) {
+	if len(data) < 8 {
+		return nil, 0, errors.New("truncated EXIF data")
+	}
+	r := &tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return nil, 0, errors.New("invalid EXIF byte order")
+	}
+	if r.order.Uint16(data[2:]) != 42 {
+		return nil, 0, errors.New("invalid EXIF header")
+	}
+	return r, r.order.Uint32(data[4:]), nil
+}
+
+// readIFD returns the entries of the IFD at offset keyed by tag
+func (r *tiffReader) readIFD(offset uint32) (map[uint16]exifEntry, error) {
+	off := int(offset)
+	if off <= 0 || off+2 > len(r.data) {
+		return nil, fmt.Errorf("IFD offset %d out of range", offset)
+	}
+
+	n := int(r.order.Uint16(r.data[off:]))
+	entries := make(map[uint16]exifEntry, n)
+	for i := 0; i < n; i++ {
+		e := off + 2 + 12*i
+		if e+12 > len(r.data) {
+			return nil, errors.New("truncated IFD")
+		}
+		tag := r.order.Uint16(r.data[e:])
+		typ := r.order.Uint16(r.data[e+2:])
+		count := int(r.order.Uint32(r.data[e+4:]))
+		size, ok := exifTypeSizes[typ]
+		if !ok || count < 0 || count > len(r.data) {
+			continue
+		}
+
+		valueOff := e + 8
+		if size*count > 4 {
+			valueOff = int(r.order.Uint32(r.data[e+8:]))
+		}
+		if valueOff < 0 || valueOff+size*count > len(r.data) {
+			continue
+		}
+		entries[tag] = exifEntry{typ: typ, count: count, value: r.data[valueOff : valueOff+size*count]}
+	}
+	return entries, nil
+}
+
+func (r *tiffReader) str(e exifEntry) string {
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+func (r *tiffReader) uint(e exifEntry) int {
+	switch e.typ {
+	case 3:
+		return int(r.order.Uint16(e.value))
+	case 4, 13:
+		return int(r.order.Uint32(e.value))
+	}
+	return 0
+}
+
+// rational returns the i-th RATIONAL or SRATIONAL value as numerator and denominator
+func (r *tiffReader) rational(e exifEntry, i int) (float64, float64) {
+	if (e.typ != 5 && e.typ != 10) || i >= e.count {
+		return 0, 0
+	}
+	num, den := r.order.Uint32(e.value[8*i:]), r.order.Uint32(e.value[8*i+4:])
+	if e.typ == 10 {
+		return float64(int32(num)), float64(int32(den))
+	}
+	return float64(num), float64(den)
+}
+
+func (r *tiffReader) float(e exifEntry, i int) float64 {
+	num, den := r.rational(e, i)
+	if den == 0 {
+		return 0
+	}
+	return num / den
+}
+
+// parseExif reads the highlights from a TIFF structure as found in a JPEG
+// APP1 segment or a PNG eXIf chunk
+func parseExif(tiff []byte) (*exifData, error) {
+	r, ifd0, err := newTIFFReader(tiff)
+	if err != nil {
+		return nil, err
+	}
+	root, err := r.readIFD(ifd0)
+	if err != nil {
+		return nil, err
+	}
+
+	x := &exifData{
+		Make:        r.str(root[tagMake]),
+		Model:       r.str(root[tagModel]),
+		Software:    r.str(root[tagSoftware]),
+		DateTime:    r.str(root[tagDateTime]),
+		Orientation: r.uint(root[tagOrientation]),
+	}
+
+	if e, ok := root[tagExifIFD]; ok {
+		if sub, err := r.readIFD(uint32(r.uint(e))); err == nil {
+			x.DateTimeOriginal = r.str(sub[tagDateTimeOriginal])
+			x.ISO = r.uint(sub[tagISO])
+			x.FNumber = r.float(sub[tagFNumber], 0)
+			x.FocalLength = r.float(sub[tagFocalLength], 0)
+			if num, den := r.rational(sub[tagExposureTime], 0); den > 0 {
+				if num >= den || num == 0 {
+					x.ExposureTime = fmt.Sprintf("%gs", num/den)
+				} else {
+					x.ExposureTime = fmt.Sprintf("1/%gs", den/num)
+				}
+			}
+		}
+	}
+
+	if e, ok := root[tagGPSIFD]; ok {
+		if gps, err := r.readIFD(uint32(r.uint(e))); err == nil {
+			lat, okLat := r.degrees(gps[tagGPSLatitude])
+			lon, okLon := r.degrees(gps[tagGPSLongitude])
+			if okLat && okLon {
+				if r.str(gps[tagGPSLatitudeRef]) == "S" {
+					lat = -lat
+				}
+				if r.str(gps[tagGPSLongitudeRef]) == "W" {
+					lon = -lon
+				}
+				x.GPS = &gpsPosition{Latitude: lat, Longitude: lon}
+			}
+		}
+	}
+	return x, nil
+}
+
+// degrees converts a GPS degrees, minutes, seconds triple to decimal degrees
+func (r *tiffReader) degrees(e exifEntry) (float64, bool) {
+	if e.count != 3 {
+		return 0, false
+	}
+	return r.float(e, 0) + r.float(e, 1)/60 + r.float(e, 2)/3600, true
+}
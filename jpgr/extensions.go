@@ -0,0 +1,86 @@
+package jpgr
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// extensionFormats maps lowercase file extensions to the format jpgr reads
+// them as. Lookups are case-insensitive, so .PNG and .Png are PNGs too
+var extensionFormats = map[string]string{
+	".png":  "png",
+	".svg":  "svg",
+	".jpg":  "jpeg",
+	".jpeg": "jpeg",
+	".gif":  "gif",
+	".dng":  "raw",
+	".cr2":  "raw",
+	".nef":  "raw",
+	".arw":  "raw",
+	".mp4":  "video",
+	".m4v":  "video",
+	".mov":  "video",
+	".webm": "video",
+	".mkv":  "video",
+}
+
+// formatOf returns the format for path's extension, or "" when jpgr does not
+// handle it
+func formatOf(path string) string {
+	return extensionFormats[strings.ToLower(filepath.Ext(path))]
+}
+
+// isRaster reports whether path is a PNG, JPEG or GIF by its extension
+func isRaster(path string) bool {
+	format := formatOf(path)
+	return format == "png" || format == "jpeg" || format == "gif"
+}
+
+// extensionFlag sets the extensions for one format, as in
+// -ext jpeg=.jpg,.jpeg,.jfif. It may be repeated for different formats
+type extensionFlag struct{}
+
+func (extensionFlag) String() string {
+	byFormat := make(map[string][]string)
+	for extension, format := range extensionFormats {
+		byFormat[format] = append(byFormat[format], extension)
+	}
+
+	var parts []string
+	for format, extensions := range byFormat {
+		sort.Strings(extensions)
+		parts = append(parts, format+"="+strings.Join(extensions, ","))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+func (extensionFlag) Set(value string) error {
+	format, list, ok := strings.Cut(value, "=")
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	if !ok || (format != "png" && format != "svg" && format != "jpeg" && format != "gif" && format != "raw" && format != "video") {
+		return fmt.Errorf("expected FORMAT=.ext[,.ext...] with FORMAT png, svg, jpeg, gif, raw or video")
+	}
+
+	for extension, f := range extensionFormats {
+		if f == format {
+			delete(extensionFormats, extension)
+		}
+	}
+	for _, extension := range strings.Split(list, ",") {
+		extension = strings.ToLower(strings.TrimSpace(extension))
+		if extension == "" {
+			continue
+		}
+		if !strings.HasPrefix(extension, ".") {
+			extension = "." + extension
+		}
+		extensionFormats[extension] = format
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package jpgr
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+)
+
+// faviconPNG is one PNG in the generated icon set
+type faviconPNG struct {
+	name     string
+	size     int
+	opaque   bool // flattened onto the background, as iOS shows transparency as black
+	maskable bool // artwork shrunk into the central safe zone of a maskable icon
+}
+
+var faviconPNGs = []faviconPNG{
+	{name: "favicon-16x16.png", size: 16},
+	{name: "favicon-32x32.png", size: 32},
+	{name: "apple-touch-icon.png", size: 180, opaque: true},
+	{name: "android-chrome-192x192.png", size: 192},
+	{name: "android-chrome-512x512.png", size: 512},
+	{name: "maskable-512x512.png", size: 512, opaque: true, maskable: true},
+}
+
+// maskableSafeZone is the fraction of a maskable icon guaranteed to stay visible
+const maskableSafeZone = 0.8
+
+func runFavicons(args []string) {
+	fs := flag.NewFlagSet("favicons", flag.ExitOnError)
+	logging.AddFlags(fs)
+	out := fs.String("out", "", "output directory (default <source dir>/favicons)")
+	name := fs.String("name", "", "application name for the web manifest")
+	themeColor := fs.String("theme-color", "#ffffff", "theme color for the web manifest")
+	background := fs.String("background", "#ffffff", "background for opaque and maskable icons")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img favicons [flags] source-image\n"))
+		fs.PrintDefaults()
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	sourcePath := fs.Arg(0)
+	if *out == "" {
+		*out = filepath.Join(filepath.Dir(sourcePath), "favicons")
+	}
+
+	bg, err := parseHexColor(*background)
+	if err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid -background: %s", err)
+	}
+
+	img, err := loadImage(sourcePath)
+	if err != nil {
+		logging.Fatalf("Failed to read image file: %s", err)
+	}
+	if b := img.Bounds(); b.Dx() != b.Dy() {
+		logging.Warnf("Source is %dx%d, not square; icons will be padded", b.Dx(), b.Dy())
+	}
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		logging.Fatalf("Failed to create output directory: %s", err)
+	}
+
+	ico := new(bytes.Buffer)
+	if err := writeICO(ico, img, []int{16, 32, 48}); err != nil {
+		logging.Fatalf("Failed to build favicon.ico: %s", err)
+	}
+	if err := writeFileAtomic(filepath.Join(*out, "favicon.ico"), ico.Bytes(), 0644); err != nil {
+		logging.Fatalf("Failed to write favicon.ico: %s", err)
+	}
+
+	for _, icon := range faviconPNGs {
+		artwork := icon.size
+		if icon.maskable {
+			artwork = int(float64(icon.size) * maskableSafeZone)
+		}
+
+		var rendered image.Image = squareIcon(img, artwork)
+		if icon.opaque {
+			canvas := image.NewNRGBA(image.Rect(0, 0, icon.size, icon.size))
+			draw.Draw(canvas, canvas.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+			offset := (icon.size - artwork) / 2
+			draw.Draw(canvas, rendered.Bounds().Add(image.Pt(offset, offset)), rendered, image.Point{}, draw.Over)
+			rendered = canvas
+		}
+
+		buf := new(bytes.Buffer)
+		if err := png.Encode(buf, rendered); err != nil {
+			logging.Fatalf("Failed to encode %s: %s", icon.name, err)
+		}
+		if err := writeFileAtomic(filepath.Join(*out, icon.name), buf.Bytes(), 0644); err != nil {
+			logging.Fatalf("Failed to write %s: %s", icon.name, err)
+		}
+	}
+
+	manifest, err := faviconManifest(*name, *themeColor, *background)
+	if err != nil {
+		logging.Fatalf("Failed to build web manifest: %s", err)
+	}
+	if err := writeFileAtomic(filepath.Join(*out, "site.webmanifest"), manifest, 0644); err != nil {
+		logging.Fatalf("Failed to write web manifest: %s", err)
+	}
+
+	tags := faviconTags(*themeColor)
+	if err := writeFileAtomic(filepath.Join(*out, "favicons.html"), []byte(tags), 0644); err != nil {
+		logging.Fatalf("Failed to write link tags: %s", err)
+	}
+
+	output.Wrote(*out)
+	output.Set("tags", tags)
+	logging.Infof("Favicons written to %s; add these tags to <head>:", *out)
+	fmt.Print(tags)
+}
+
+// faviconManifest builds the site.webmanifest describing the PWA icons
+func faviconManifest(name, themeColor, background string) ([]byte, error) {
+	type manifestIcon struct {
+		Src     string `json:"src"`
+		Sizes   string `json:"sizes"`
+		Type    string `json:"type"`
+		Purpose string `json:"purpose,omitempty"`
+	}
+
+	var icons []manifestIcon
+	for _, icon := range faviconPNGs {
+		if !strings.HasPrefix(icon.name, "android-chrome") && !icon.maskable {
+			continue
+		}
+		entry := manifestIcon{
+			Src:   "/" + icon.name,
+			Sizes: fmt.Sprintf("%dx%d", icon.size, icon.size),
+			Type:  "image/png",
+		}
+		if icon.maskable {
+			entry.Purpose = "maskable"
+		}
+		icons = append(icons, entry)
+	}
+
+	data, err := json.MarshalIndent(map[string]any{
+		"name":             name,
+		"short_name":       name,
+		"icons":            icons,
+		"theme_color":      themeColor,
+		"background_color": background,
+		"display":          "standalone",
+	}, "", "  ")
+	return append(data, '\n'), err
+}
+
+// faviconTags returns the <head> markup referencing the generated files
+func faviconTags(themeColor string) string {
+	return `<link rel="icon" href="/favicon.ico" sizes="48x48">
+<link rel="icon" type="image/png" sizes="32x32" href="/favicon-32x32.png">
+<link rel="icon" type="image/png" sizes="16x16" href="/favicon-16x16.png">
+<link rel="apple-touch-icon" sizes="180x180" href="/apple-touch-icon.png">
+<link rel="manifest" href="/site.webmanifest">
+<meta name="theme-color" content="` + themeColor + `">
+`
+}
+
+// parseHexColor parses #rgb or #rrggbb
+func parseHexColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q, want #rrggbb", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q, want #rrggbb", s)
+	}
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+}
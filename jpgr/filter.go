@@ -0,0 +1,138 @@
+package jpgr
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+// filterTransforms parses a comma separated filter chain such as
+// "grayscale,brightness=10,contrast=1.2,sharpen" into transforms applied in
+// the order they are written
+func filterTransforms(spec string) ([]Transform, error) {
+	var transforms []Transform
+	if spec == "" {
+		return transforms, nil
+	}
+
+	for _, item := range strings.Split(spec, ",") {
+		name, value, hasValue := strings.Cut(strings.TrimSpace(item), "=")
+
+		amount := 0.0
+		if hasValue {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for filter %s: %q", name, value)
+			}
+			amount = v
+		}
+
+		switch name {
+		case "grayscale":
+			transforms = append(transforms, grayscale)
+		case "brightness":
+			if !hasValue {
+				return nil, fmt.Errorf("brightness needs a value between -100 and 100")
+			}
+			transforms = append(transforms, brightness(amount))
+		case "contrast":
+			if !hasValue {
+				return nil, fmt.Errorf("contrast needs a factor such as 1.2")
+			}
+			transforms = append(transforms, contrast(amount))
+		case "sharpen":
+			if !hasValue {
+				amount = 1
+			}
+			transforms = append(transforms, sharpen(amount))
+		default:
+			return nil, fmt.Errorf("unknown filter %q", name)
+		}
+	}
+
+	return transforms, nil
+}
+
+// toNRGBA returns a copy of img that the filters can modify in place
+func toNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// clamp rounds v to the nearest valid 8-bit channel value
+func clamp(v float64) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+// mapChannels applies fn to the red, green and blue channels of every pixel
+func mapChannels(img image.Image, fn func(float64) float64) image.Image {
+	dst := toNRGBA(img)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		dst.Pix[i] = clamp(fn(float64(dst.Pix[i])))
+		dst.Pix[i+1] = clamp(fn(float64(dst.Pix[i+1])))
+		dst.Pix[i+2] = clamp(fn(float64(dst.Pix[i+2])))
+	}
+	return dst
+}
+
+func grayscale(img image.Image) image.Image {
+	dst := toNRGBA(img)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		y := clamp(0.299*float64(dst.Pix[i]) + 0.587*float64(dst.Pix[i+1]) + 0.114*float64(dst.Pix[i+2]))
+		dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2] = y, y, y
+	}
+	return dst
+}
+
+// brightness shifts every channel by percent of the full range
+func brightness(percent float64) Transform {
+	shift := percent * 255 / 100
+	return func(img image.Image) image.Image {
+		return mapChannels(img, func(v float64) float64 { return v + shift })
+	}
+}
+
+// contrast scales every channel away from (factor > 1) or towards (factor < 1) mid-gray
+func contrast(factor float64) Transform {
+	return func(img image.Image) image.Image {
+		return mapChannels(img, func(v float64) float64 { return (v-128)*factor + 128 })
+	}
+}
+
+// sharpen applies an unsharp mask with a 3x3 box blur, which suits images that
+// have just been downscaled
+func sharpen(amount float64) Transform {
+	return func(img image.Image) image.Image {
+		src := toNRGBA(img)
+		dst := image.NewNRGBA(src.Rect)
+		copy(dst.Pix, src.Pix)
+
+		w, h := src.Rect.Dx(), src.Rect.Dy()
+		for y := 1; y < h-1; y++ {
+			for x := 1; x < w-1; x++ {
+				i := src.PixOffset(x, y)
+				for c := 0; c < 3; c++ {
+					sum := 0.0
+					for dy := -1; dy <= 1; dy++ {
+						for dx := -1; dx <= 1; dx++ {
+							sum += float64(src.Pix[src.PixOffset(x+dx, y+dy)+c])
+						}
+					}
+					v := float64(src.Pix[i+c])
+					dst.Pix[i+c] = clamp(v + amount*(v-sum/9))
+				}
+			}
+		}
+		return dst
+	}
+}
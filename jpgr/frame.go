@@ -0,0 +1,161 @@
+package jpgr
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"GoodnessucWorkflow/internal/cache"
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/imaging"
+)
+
+func runFrame(args []string) {
+	fs := flag.NewFlagSet("frame", flag.ExitOnError)
+	logging.AddFlags(fs)
+	at := fs.String("at", "smart", "timestamp to grab, such as 3.5 or 00:01:02, or smart to let ffmpeg pick the most representative frame")
+	format := fs.String("format", "jpeg", "poster format: jpeg or png")
+	quality := fs.Int("quality", 0, "JPEG quality (default encoder quality)")
+	maxWidth := fs.Int("max-width", 0, "scale the poster down to at most this many pixels wide")
+	out := fs.String("out", "", "directory for the posters (default next to each video)")
+	useCache := cache.AddFlag(fs)
+	addWalkFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img frame [flags] [file|directory ...]\n\nExtracts a poster image from each video with ffmpeg.\n\n"))
+		fs.PrintDefaults()
+	}
+	cli.Parse(fs, args)
+
+	if *format == "jpg" {
+		*format = "jpeg"
+	}
+	if *format != "jpeg" && *format != "png" {
+		logging.Exitf(exitcode.Usage, "Invalid -format %q", *format)
+	}
+	ffmpeg, err := findFFmpeg()
+	if err != nil {
+		logging.Fatalf("%s", err)
+	}
+	if *out != "" {
+		if err := os.MkdirAll(*out, 0755); err != nil {
+			logging.Fatalf("Failed to create output directory: %s", err)
+		}
+	}
+
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		inputs = []string{defaultDirectory()}
+	}
+
+	inputCache, err := cache.Open(*useCache)
+	if err != nil {
+		logging.Warnf("Not using the cache: %s", err)
+	}
+	outAbs := ""
+	if *out != "" {
+		outAbs, _ = filepath.Abs(*out)
+	}
+	options := cache.Options("img frame", cache.Flags(fs, "out", "cache"), outAbs)
+
+	ctx := cli.SignalContext()
+	summary := &runSummary{}
+	for _, input := range inputs {
+		err := walkTree(input, func(path string, info os.FileInfo, err error) error {
+			if ctx.Err() != nil {
+				return filepath.SkipAll
+			}
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || formatOf(path) != "video" {
+				return nil
+			}
+			key, hit := inputCache.Lookup(path, options)
+			if hit != nil {
+				summary.Cached(path)
+				return nil
+			}
+			outputPath, err := extractFrame(ffmpeg, path, *at, *format, *quality, *maxWidth, *out)
+			switch {
+			case err != nil && ctx.Err() != nil:
+				// ffmpeg got the same SIGINT and nothing was written
+				logging.Infof("Interrupted, left as it was: %s", path)
+			case err != nil:
+				summary.Fail(path, "extract", err)
+			default:
+				summary.Succeed(path)
+				inputCache.Store(key, []string{outputPath}, nil)
+			}
+			return nil
+		})
+		if err != nil {
+			logging.Fatalf("Error processing directory: %s", err)
+		}
+	}
+	if err := inputCache.Save(); err != nil {
+		logging.Errorf("Failed to write the cache: %s", err)
+	}
+
+	if ctx.Err() != nil {
+		summary.Interrupt()
+	}
+	summary.Print(os.Stdout)
+	output.Exit(summary.ExitCode())
+}
+
+// extractFrame has ffmpeg write one frame as PNG to stdout, then scales and
+// encodes it like any other image, and returns where it wrote the poster
+func extractFrame(ffmpeg, path, at, format string, quality, maxWidth int, outDir string) (string, error) {
+	var args []string
+	if at == "smart" {
+		// The thumbnail filter picks the frame closest to the average of each
+		// batch, which skips fades and black intro frames
+		args = []string{"-i", fsutil.LongPath(path), "-vf", "thumbnail=300", "-frames:v", "1"}
+	} else {
+		args = []string{"-ss", at, "-i", fsutil.LongPath(path), "-frames:v", "1"}
+	}
+	data, err := ffmpegOutput(ffmpeg, append(args, "-f", "image2pipe", "-c:v", "png", "-")...)
+	if err != nil {
+		return "", err
+	}
+	if len(data) == 0 {
+		return "", fmt.Errorf("no frame at %s", at)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", &stageError{"decode", err}
+	}
+	if maxWidth > 0 {
+		img = resizeToFit(img, maxWidth, img.Bounds().Dy())
+	}
+
+	buf := new(bytes.Buffer)
+	if err := imaging.EncodeTo(buf, img, format, quality); err != nil {
+		return "", &stageError{"convert", err}
+	}
+
+	extension := ".jpg"
+	if format == "png" {
+		extension = ".png"
+	}
+	outputPath := strings.TrimSuffix(path, filepath.Ext(path)) + extension
+	if outDir != "" {
+		outputPath = filepath.Join(outDir, filepath.Base(outputPath))
+	}
+	if err := writeFileAtomic(outputPath, buf.Bytes(), 0644); err != nil {
+		return "", &stageError{"write", err}
+	}
+	output.Wrote(outputPath)
+	logging.Infof("Poster frame written: %s", outputPath)
+	return outputPath, nil
+}
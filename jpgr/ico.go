@@ -0,0 +1,128 @@
+package jpgr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+)
+
+func runIco(args []string) {
+	fs := flag.NewFlagSet("ico", flag.ExitOnError)
+	logging.AddFlags(fs)
+	sizes := fs.String("sizes", "16,32,48,256", "comma separated icon sizes in pixels, at most 256")
+	out := fs.String("out", "", "output path (default <source name>.ico)")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img ico [flags] source-image\n"))
+		fs.PrintDefaults()
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	sourcePath := fs.Arg(0)
+
+	var pixelSizes []int
+	for _, s := range strings.Split(*sizes, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 || n > 256 {
+			logging.Exitf(exitcode.Usage, "Invalid icon size %q", s)
+		}
+		pixelSizes = append(pixelSizes, n)
+	}
+
+	if *out == "" {
+		*out = strings.TrimSuffix(sourcePath, filepath.Ext(sourcePath)) + ".ico"
+	}
+
+	img, err := loadImage(sourcePath)
+	if err != nil {
+		logging.Fatalf("Failed to read image file: %s", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := writeICO(buf, img, pixelSizes); err != nil {
+		logging.Fatalf("Failed to build icon: %s", err)
+	}
+	if err := writeFileAtomic(*out, buf.Bytes(), 0644); err != nil {
+		logging.Fatalf("Failed to write icon: %s", err)
+	}
+
+	output.Wrote(*out)
+	logging.Infof("Icon written: %s", *out)
+}
+
+// squareIcon resamples img to a size by size square, centering non-square
+// sources on a transparent background
+func squareIcon(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	if b.Dx() == size && b.Dy() == size {
+		return img
+	}
+
+	w, h := size, size
+	if b.Dx() > b.Dy() {
+		h = max(b.Dy()*size/b.Dx(), 1)
+	} else if b.Dy() > b.Dx() {
+		w = max(b.Dx()*size/b.Dy(), 1)
+	}
+
+	scaled := resize(img, w, h)
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	offset := image.Pt((size-w)/2, (size-h)/2)
+	draw.Draw(dst, image.Rectangle{Min: offset, Max: offset.Add(image.Pt(w, h))}, scaled, image.Point{}, draw.Src)
+	return dst
+}
+
+// writeICO writes an .ico file holding one PNG-compressed image per size
+func writeICO(w io.Writer, img image.Image, sizes []int) error {
+	var images [][]byte
+	for _, size := range sizes {
+		buf := new(bytes.Buffer)
+		if err := png.Encode(buf, squareIcon(img, size)); err != nil {
+			return err
+		}
+		images = append(images, buf.Bytes())
+	}
+
+	// ICONDIR header followed by one 16-byte ICONDIRENTRY per image
+	header := new(bytes.Buffer)
+	binary.Write(header, binary.LittleEndian, [3]uint16{0, 1, uint16(len(sizes))})
+
+	offset := 6 + 16*len(sizes)
+	for i, size := range sizes {
+		// A dimension byte of 0 means 256
+		dim := uint8(size % 256)
+		binary.Write(header, binary.LittleEndian, struct {
+			Width, Height, Colors, Reserved uint8
+			Planes, BitCount                uint16
+			Size, Offset                    uint32
+		}{dim, dim, 0, 0, 1, 32, uint32(len(images[i])), uint32(offset)})
+		offset += len(images[i])
+	}
+
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	for _, data := range images {
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package jpgr
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"GoodnessucWorkflow/internal/fsutil"
+)
+
+// ignoreFileName lists paths walkTree leaves out, in gitignore syntax. Each
+// directory in a scanned tree may have one; its patterns apply below it
+const ignoreFileName = ".jpgrignore"
+
+// ignoreRule is one pattern line from an ignore file
+type ignoreRule struct {
+	base     string
+	segments []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// readIgnoreFile parses the ignore file in dir, if there is one. Blank lines
+// and lines starting with # are skipped; ! re-includes, a trailing / matches
+// only directories and a / anywhere else anchors the pattern to dir
+func readIgnoreFile(dir string) ([]ignoreRule, error) {
+	f, err := os.Open(filepath.Join(dir, ignoreFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: dir}
+		if strings.HasPrefix(line, "!") {
+			rule.negate, line = true, line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly, line = true, strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored, line = true, strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		// Case is ignored where the filesystem ignores it
+		rule.segments = strings.Split(fsutil.FoldCase(line), "/")
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// ignored reports whether rules exclude path. Later rules override earlier
+// ones, as in gitignore
+func ignored(rules []ignoreRule, p string, isDir bool) bool {
+	excluded := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel, err := filepath.Rel(rule.base, p)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		parts := strings.Split(fsutil.FoldCase(filepath.ToSlash(rel)), "/")
+
+		var match bool
+		if rule.anchored {
+			match = matchSegments(rule.segments, parts)
+		} else {
+			match, _ = path.Match(rule.segments[0], parts[len(parts)-1])
+		}
+		if match {
+			excluded = !rule.negate
+		}
+	}
+	return excluded
+}
+
+// matchSegments matches a slash separated pattern against path segments,
+// where a ** segment matches any number of directories
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], parts[1:])
+}
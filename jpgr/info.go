@@ -0,0 +1,345 @@
+package jpgr
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf16"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/imaging"
+)
+
+// imageInfo is what the info command reports for one file
+type imageInfo struct {
+	Path       string    `json:"path"`
+	Format     string    `json:"format"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	ColorModel string    `json:"color_model"`
+	BitDepth   int       `json:"bit_depth,omitempty"`
+	FileSize   int64     `json:"file_size"`
+	ICCProfile string    `json:"icc_profile,omitempty"`
+	Animated   bool      `json:"animated,omitempty"`
+	Exif       *exifData `json:"exif,omitempty"`
+}
+
+func runInfo(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	logging.AddFlags(fs)
+	asJSON := fs.Bool("json", false, "print a JSON array instead of text")
+	addWalkFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img info [flags] [file|directory ...]\n"))
+		fs.PrintDefaults()
+	}
+	cli.Parse(fs, args)
+
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		inputs = []string{defaultDirectory()}
+	}
+
+	var paths []string
+	for _, input := range inputs {
+		err := walkTree(input, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// Files named explicitly are inspected whatever their extension
+			if !info.IsDir() && (path == input || isRaster(path) || formatOf(path) == "svg") {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			logging.Fatalf("Error processing directory: %s", err)
+		}
+	}
+
+	infos := make([]imageInfo, 0, len(paths))
+	failed := 0
+	for _, path := range paths {
+		info, err := inspectImage(path)
+		if err != nil {
+			logging.Errorf("Failed to read image file: %s", err)
+			output.Processed(path, "failed", "read", err)
+			failed++
+			continue
+		}
+		output.Processed(path, "ok", "", nil)
+		infos = append(infos, info)
+	}
+
+	output.Set("images", infos)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(infos); err != nil {
+			logging.Fatalf("Failed to write JSON: %s", err)
+		}
+	} else {
+		printInfos(os.Stdout, infos)
+	}
+	switch {
+	case failed > 0:
+		output.Exit(exitcode.Failures)
+	case len(infos) == 0:
+		output.Exit(exitcode.NoMatch)
+	}
+}
+
+// inspectImage reads the header and metadata of one file without decoding its pixels
+func inspectImage(path string) (imageInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return imageInfo{}, err
+	}
+
+	info := imageInfo{Path: path, FileSize: int64(len(data))}
+	if bytes.Contains(data[:min(len(data), 512)], []byte("<svg")) {
+		img, err := imaging.RasterizeSVG(data, 1, 0, 0)
+		if err != nil {
+			return imageInfo{}, err
+		}
+		info.Format, info.ColorModel = "svg", "vector"
+		info.Width, info.Height = img.Bounds().Dx(), img.Bounds().Dy()
+		return info, nil
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return imageInfo{}, err
+	}
+	info.Format, info.Width, info.Height = format, config.Width, config.Height
+	info.ColorModel = colorModelName(config.ColorModel)
+
+	switch format {
+	case "png":
+		pngDetails(data, &info)
+	case "jpeg":
+		jpegDetails(data, &info)
+	case "gif":
+		info.ColorModel = "palette"
+		if len(data) > 10 && data[10]&0x80 != 0 {
+			info.BitDepth = int(data[10]&0x07) + 1
+		}
+		info.Animated = isAnimatedGIF(data)
+	}
+
+	if tiff := exifBlock(data); tiff != nil {
+		if x, err := parseExif(tiff); err == nil {
+			info.Exif = x
+		}
+	}
+	return info, nil
+}
+
+// pngDetails fills in what only the IHDR and iCCP chunks say
+func pngDetails(data []byte, info *imageInfo) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return
+	}
+	for _, c := range chunks {
+		switch c.kind {
+		case "IHDR":
+			if len(c.data) >= 10 {
+				info.BitDepth = int(c.data[8])
+				info.ColorModel = map[byte]string{0: "gray", 2: "rgb", 3: "palette", 4: "gray+alpha", 6: "rgba"}[c.data[9]]
+			}
+		case "acTL":
+			info.Animated = true
+		case "iCCP":
+			name, rest, ok := bytes.Cut(c.data, []byte{0})
+			if !ok || len(rest) < 1 {
+				continue
+			}
+			info.ICCProfile = string(name)
+			if zr, err := zlib.NewReader(bytes.NewReader(rest[1:])); err == nil {
+				if profile, err := io.ReadAll(zr); err == nil {
+					if desc := iccDescription(profile); desc != "" {
+						info.ICCProfile = desc
+					}
+				}
+			}
+		}
+	}
+}
+
+// jpegDetails reads the frame header and any ICC profile split over APP2 segments
+func jpegDetails(data []byte, info *imageInfo) {
+	segments, err := readJPEGSegments(data)
+	if err != nil {
+		return
+	}
+
+	parts := make(map[int][]byte)
+	for _, s := range segments {
+		switch {
+		case s.marker >= 0xc0 && s.marker <= 0xcf && s.marker != 0xc4 && s.marker != 0xc8 && s.marker != 0xcc:
+			if len(s.data) >= 6 {
+				info.BitDepth = int(s.data[0])
+				info.ColorModel = map[byte]string{1: "gray", 3: "ycbcr", 4: "cmyk"}[s.data[5]]
+			}
+		case s.marker == 0xe2 && bytes.HasPrefix(s.data, []byte("ICC_PROFILE\x00")) && len(s.data) > 14:
+			parts[int(s.data[12])] = s.data[14:]
+		}
+	}
+
+	if len(parts) > 0 {
+		seqs := make([]int, 0, len(parts))
+		for seq := range parts {
+			seqs = append(seqs, seq)
+		}
+		sort.Ints(seqs)
+		var profile []byte
+		for _, seq := range seqs {
+			profile = append(profile, parts[seq]...)
+		}
+		info.ICCProfile = iccDescription(profile)
+	}
+}
+
+// iccDescription returns the profile description from the desc tag, which is
+// textDescriptionType in v2 profiles and multiLocalizedUnicodeType in v4
+func iccDescription(profile []byte) string {
+	if len(profile) < 132 {
+		return ""
+	}
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := 0; i < count && 132+12*i+12 <= len(profile); i++ {
+		entry := profile[132+12*i:]
+		if string(entry[:4]) != "desc" {
+			continue
+		}
+		off, size := int(binary.BigEndian.Uint32(entry[4:])), int(binary.BigEndian.Uint32(entry[8:]))
+		if off < 0 || size < 12 || off+size > len(profile) {
+			return ""
+		}
+		tag := profile[off : off+size]
+
+		switch string(tag[:4]) {
+		case "desc":
+			n := int(binary.BigEndian.Uint32(tag[8:]))
+			if 12+n > len(tag) {
+				return ""
+			}
+			return strings.TrimRight(string(tag[12:12+n]), "\x00")
+		case "mluc":
+			if len(tag) < 28 {
+				return ""
+			}
+			length, start := int(binary.BigEndian.Uint32(tag[20:])), int(binary.BigEndian.Uint32(tag[24:]))
+			if start+length > len(tag) {
+				return ""
+			}
+			units := make([]uint16, length/2)
+			for j := range units {
+				units[j] = binary.BigEndian.Uint16(tag[start+2*j:])
+			}
+			return string(utf16.Decode(units))
+		}
+	}
+	return ""
+}
+
+// colorModelName names the standard library color models
+func colorModelName(m color.Model) string {
+	switch m {
+	case color.RGBAModel, color.NRGBAModel:
+		return "rgba"
+	case color.RGBA64Model, color.NRGBA64Model:
+		return "rgba64"
+	case color.GrayModel:
+		return "gray"
+	case color.Gray16Model:
+		return "gray16"
+	case color.YCbCrModel:
+		return "ycbcr"
+	case color.CMYKModel:
+		return "cmyk"
+	}
+	if _, ok := m.(color.Palette); ok {
+		return "palette"
+	}
+	return "unknown"
+}
+
+func printInfos(w io.Writer, infos []imageInfo) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for i, info := range infos {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "%s\n", info.Path)
+		fmt.Fprintf(tw, "  Format\t%s\n", info.Format)
+		fmt.Fprintf(tw, "  Dimensions\t%dx%d\n", info.Width, info.Height)
+		if info.BitDepth > 0 {
+			fmt.Fprintf(tw, "  Color\t%s, %d-bit\n", info.ColorModel, info.BitDepth)
+		} else {
+			fmt.Fprintf(tw, "  Color\t%s\n", info.ColorModel)
+		}
+		fmt.Fprintf(tw, "  File size\t%s\n", formatBytes(info.FileSize))
+		if info.Animated {
+			fmt.Fprintf(tw, "  Animated\tyes\n")
+		}
+		if info.ICCProfile != "" {
+			fmt.Fprintf(tw, "  ICC profile\t%s\n", info.ICCProfile)
+		}
+
+		x := info.Exif
+		if x == nil {
+			continue
+		}
+		if camera := strings.TrimSpace(x.Make + " " + x.Model); camera != "" {
+			fmt.Fprintf(tw, "  Camera\t%s\n", camera)
+		}
+		if taken := x.DateTimeOriginal; taken != "" || x.DateTime != "" {
+			if taken == "" {
+				taken = x.DateTime
+			}
+			fmt.Fprintf(tw, "  Taken\t%s\n", taken)
+		}
+		var exposure []string
+		if x.ExposureTime != "" {
+			exposure = append(exposure, x.ExposureTime)
+		}
+		if x.FNumber > 0 {
+			exposure = append(exposure, fmt.Sprintf("f/%g", x.FNumber))
+		}
+		if x.ISO > 0 {
+			exposure = append(exposure, fmt.Sprintf("ISO %d", x.ISO))
+		}
+		if x.FocalLength > 0 {
+			exposure = append(exposure, fmt.Sprintf("%gmm", x.FocalLength))
+		}
+		if len(exposure) > 0 {
+			fmt.Fprintf(tw, "  Exposure\t%s\n", strings.Join(exposure, ", "))
+		}
+		if x.Orientation > 1 {
+			fmt.Fprintf(tw, "  Orientation\t%d\n", x.Orientation)
+		}
+		if x.Software != "" {
+			fmt.Fprintf(tw, "  Software\t%s\n", x.Software)
+		}
+		if x.GPS != nil {
+			fmt.Fprintf(tw, "  GPS\t%.6f, %.6f\n", x.GPS.Latitude, x.GPS.Longitude)
+		}
+	}
+	tw.Flush()
+}
@@ -0,0 +1,151 @@
+package jpgr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"GoodnessucWorkflow/internal/logging"
+)
+
+// lockFileName marks a directory as being converted by a running jpgr
+const lockFileName = ".jpgr.lock"
+
+// A live holder touches its lock every lockRefresh. A lock nobody has touched
+// for staleLockAge is abandoned, which also covers holders on other machines
+// sharing a network drive
+const (
+	lockRefresh  = time.Minute
+	staleLockAge = 10 * time.Minute
+)
+
+// lockInfo is what a lock file records about its holder
+type lockInfo struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// lockedError reports a directory locked by another run
+type lockedError struct {
+	dir    string
+	holder string
+}
+
+func (e *lockedError) Error() string {
+	return fmt.Sprintf("%s is being converted by %s", e.dir, e.holder)
+}
+
+// runLock holds the lock files of one run until Release
+type runLock struct {
+	paths []string
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// acquireRunLock locks every directory in dirs, or none of them when another
+// run holds any. Stale locks left by crashed runs are taken over
+func acquireRunLock(dirs []string) (*runLock, error) {
+	l := &runLock{stop: make(chan struct{})}
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		path := filepath.Join(dir, lockFileName)
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		if err := createLock(path); err != nil {
+			l.Release()
+			return nil, err
+		}
+		l.paths = append(l.paths, path)
+	}
+
+	go l.refresh(slices.Clone(l.paths))
+	return l, nil
+}
+
+// createLock creates path exclusively, replacing it once if it is stale
+func createLock(path string) error {
+	host, _ := os.Hostname()
+	data, err := json.Marshal(lockInfo{PID: os.Getpid(), Host: host, StartedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.Write(data)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			return err
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+
+		holder, stale := inspectLock(path, host)
+		if !stale || attempt > 0 {
+			return &lockedError{dir: filepath.Dir(path), holder: holder}
+		}
+		logging.Warnf("Removing stale lock left by %s", holder)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+}
+
+// inspectLock describes the holder of the lock at path and reports whether
+// it is stale: its process is gone, or it stopped refreshing the lock
+func inspectLock(path, host string) (string, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "a run that just finished", true
+	}
+	var holder lockInfo
+	data, err := os.ReadFile(path)
+	if err != nil || json.Unmarshal(data, &holder) != nil {
+		return "an unknown run", time.Since(info.ModTime()) > staleLockAge
+	}
+
+	desc := fmt.Sprintf("pid %d on %s since %s", holder.PID, holder.Host, holder.StartedAt.Local().Format(time.DateTime))
+	if holder.Host == host && !processAlive(holder.PID) {
+		return desc, true
+	}
+	return desc, time.Since(info.ModTime()) > staleLockAge
+}
+
+// refresh touches the lock files until Release so they never look stale
+func (l *runLock) refresh(paths []string) {
+	ticker := time.NewTicker(lockRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			for _, path := range paths {
+				os.Chtimes(path, now, now)
+			}
+		}
+	}
+}
+
+// Release removes the lock files
+func (l *runLock) Release() {
+	l.once.Do(func() { close(l.stop) })
+	for _, path := range l.paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logging.Errorf("Failed to remove lock file: %s", err)
+		}
+	}
+	l.paths = nil
+}
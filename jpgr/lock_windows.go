@@ -0,0 +1,16 @@
+//go:build windows
+
+package jpgr
+
+import "os"
+
+// processAlive reports whether a process with pid exists on this machine.
+// FindProcess opens a handle on Windows, which fails once the process is gone
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,384 @@
+package jpgr
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"GoodnessucWorkflow/internal/cache"
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/internal/storage"
+	"GoodnessucWorkflow/internal/throttle"
+	"GoodnessucWorkflow/pkg/imaging"
+)
+
+// defaultDirectory is the folder commands work on when given none: Downloads
+// in the user's home
+func defaultDirectory() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		logging.Exitf(exitcode.Usage, "No directory given and no home folder to default to: %s", err)
+	}
+	return filepath.Join(home, "Downloads")
+}
+
+// directoryArg returns the directory fs was given, or defaultDirectory
+func directoryArg(fs *flag.FlagSet) string {
+	if fs.NArg() > 0 {
+		return fs.Arg(0)
+	}
+	return defaultDirectory()
+}
+
+// Transform modifies a decoded image before it is encoded
+type Transform = imaging.Transform
+
+// ToJpeg converts a PNG image to JPEG format, applying transforms in order
+func ToJpeg(imageBytes []byte, transforms ...Transform) ([]byte, error) {
+	img, err := png.Decode(bytes.NewReader(imageBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	return imageToJpeg(img, transforms...)
+}
+
+// imageToJpeg applies transforms to an already decoded image and encodes it as JPEG
+func imageToJpeg(img image.Image, transforms ...Transform) ([]byte, error) {
+	for _, transform := range transforms {
+		img = transform(img)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, img, nil); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// loadImage decodes an image file in any of the registered formats
+func loadImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and
+// renames it into place, so readers never see a partially written file. The
+// change is recorded in the undo journal
+var writeFileAtomic = journal.WriteFile
+
+// Commands lists the image subcommands, run as goodness img <command>
+func Commands() []cli.Command {
+	return []cli.Command{
+		{Name: "convert", Summary: "convert PNG, SVG and RAW files to JPEG or another format", Run: runConvert},
+		{Name: "annotate", Summary: "draw arrows, boxes, step markers and labels onto screenshots", Run: runAnnotate},
+		{Name: "clip", Summary: "save the image on the clipboard to a file", Run: runClip},
+		{Name: "codeimg", Summary: "render code as a highlighted image in a window, for social posts", Run: runCodeImage},
+		{Name: "compare", Summary: "report SSIM and PSNR of PNGs encoded at several qualities", Run: runCompare},
+		{Name: "dedupe", Summary: "find visually similar images", Run: runDedupe},
+		{Name: "diff", Summary: "highlight the pixels that differ between two images", Run: runDiff},
+		{Name: "favicons", Summary: "generate favicons and touch icons from one image", Run: runFavicons},
+		{Name: "frame", Summary: "extract a poster image from each video", Run: runFrame},
+		{Name: "ico", Summary: "build a multi-size .ico file", Run: runIco},
+		{Name: "info", Summary: "show format, dimensions and EXIF data", Run: runInfo},
+		{Name: "manifest", Summary: "write a SHA-256 manifest of a directory", Run: runManifest},
+		{Name: "merge", Summary: "merge folders, skipping identical files", Run: runMerge},
+		{Name: "organize", Summary: "move images into dated folders", Run: runOrganize},
+		{Name: "rename", Summary: "rename images from a date and sequence pattern", Run: runRename},
+		{Name: "scrub-gps", Summary: "remove GPS data from photos and report which had it", Run: runScrubGPS},
+		{Name: "serve", Summary: "serve resized images over HTTP", Run: runServe},
+		{Name: "sheet", Summary: "build a contact sheet", Run: runSheet},
+		{Name: "sprite", Summary: "pack images into a CSS sprite sheet", Run: runSprite},
+		{Name: "srcset", Summary: "write responsive image sizes and srcset markup", Run: runSrcset},
+		{Name: "upload", Summary: "upload images to Imgur or Cloudinary and print their links", Run: runUpload},
+		{Name: "verify", Summary: "check a directory against its manifest", Run: runVerify},
+		{Name: "video", Summary: "convert animated GIFs to MP4 or WebM", Run: runVideo},
+	}
+}
+
+// Run runs an image subcommand. Arguments that start with a flag or name a
+// file, directory or URL instead of a command are converted, as the jpgr
+// binary this replaces did
+func Run(args []string) {
+	commands := Commands()
+	if len(args) > 0 && !cli.IsHelp(args[0]) {
+		if _, ok := cli.Find(commands, args[0]); !ok && (strings.HasPrefix(args[0], "-") || isRemote(args[0]) || storage.IsRemote(args[0]) || fileExists(args[0])) {
+			args = append([]string{"convert"}, args...)
+		}
+	}
+	cli.Dispatch("goodness img", commands, args)
+}
+
+func runConvert(args []string) {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	logging.AddFlags(fs)
+	crop := fs.String("crop", "", "crop box as WxH+X+Y, or WxH placed using -gravity")
+	aspect := fs.String("aspect", "", "crop to an aspect ratio such as 16:9 or 1:1")
+	gravity := fs.String("gravity", "center", "where crops are anchored: center, north, south, east, west, northeast, northwest, southeast or southwest")
+	rotation := fs.Int("rotate", 0, "rotate clockwise by 90, 180 or 270 degrees")
+	flip := fs.String("flip", "", "mirror the image: h (left to right) or v (top to bottom)")
+	trim := fs.Bool("trim", false, "crop away uniform borders, using the top-left pixel as the border colour")
+	trimTolerance := fs.Int("trim-tolerance", 10, "how far (0-255 per channel) a pixel may differ from the border colour and still be trimmed")
+	trimPadding := fs.Int("trim-padding", 0, "pixels of border to keep around the content after trimming")
+	filters := fs.String("filter", "", "comma separated filter chain: grayscale, brightness=N, contrast=F, sharpen[=F]")
+	apng := fs.String("apng", "skip", "what to do with animated PNGs: skip, first (convert the first frame) or gif (keep the animation as an animated GIF)")
+	svgScale := fs.Float64("svg-scale", 1, "scale factor for rasterizing SVGs at their intrinsic size")
+	svgWidth := fs.Int("svg-width", 0, "rasterize SVGs to this pixel width (overrides -svg-scale)")
+	svgHeight := fs.Int("svg-height", 0, "rasterize SVGs to this pixel height (overrides -svg-scale)")
+	sidecar := fs.Bool("sidecar", false, "write a <output>.json file with dimensions, checksums and timing for each converted image")
+	statePath := fs.String("state", "", "file that records batch progress (default <directory>/"+stateFileName+")")
+	resume := fs.Bool("resume", false, "continue an interrupted run from its state file instead of rescanning")
+	workers := fs.Int("workers", runtime.NumCPU(), "number of files converted concurrently")
+	targetSize := fs.String("target-size", "", "search the JPEG quality so each output is at most this size, e.g. 300KB")
+	targetResize := fs.Bool("target-resize", false, "scale images down when -target-size cannot be met at the lowest quality")
+	urlList := fs.String("url-list", "", "file of image URLs to download and convert, one per line")
+	downloadDir := fs.String("download-dir", "", "where URL sources are saved (default ~/Downloads)")
+	to := fs.String("to", "", "pipe mode: read one image from stdin and write it to stdout as jpeg, png, gif or webp")
+	auto := fs.Bool("auto", false, "pick the output format and quality for each file from its content: PNG for screenshots, logos and other flat images, JPEG (or WebP when transparent) for photos")
+	configPath := fs.String("config", "", "conversion policy file applied to every input (default "+policyFileName+" at the top of each directory)")
+	onConflict := fs.String("on-conflict", "suffix", "when two sources map to one output name, or a PNG's output already exists: suffix (add -2, -3, ...) or fail")
+	xattrs := &xattrSelection{}
+	xattrs.Set("tags,comments")
+	fs.Var(xattrs, "xattrs", "macOS extended attributes copied to outputs: comma separated tags, comments, wherefroms, quarantine, or all or none")
+	webhook := fs.String("webhook", "", "POST a JSON summary of the run to this URL when it finishes")
+	notify := fs.Bool("notify", false, "show a desktop notification when the run finishes")
+	retries := fs.Int("retries", 3, "times a file is retried after a transient I/O error, as seen on network drives and cloud-synced folders")
+	retryDelay := fs.Duration("retry-delay", 500*time.Millisecond, "wait before the first retry; it doubles on each further retry")
+	runLogPath := fs.String("run-log", "", "append a line per processed file to this log: CSV when it ends in .csv, NDJSON otherwise")
+	markdownDir := fs.String("markdown", "", "after converting, update links to converted images in the markdown files under this directory")
+	memoryBudget := fs.String("memory-budget", "2GB", "upper bound on the estimated memory held by in-flight conversions")
+	useCache := cache.AddFlag(fs)
+	limits := throttle.AddFlags(fs)
+	s3opts := storage.S3Options{}
+	fs.StringVar(&s3opts.Bucket, "s3-bucket", "", "upload converted files to this S3-compatible bucket")
+	fs.StringVar(&s3opts.Endpoint, "s3-endpoint", "https://s3.amazonaws.com", "S3-compatible endpoint URL")
+	fs.StringVar(&s3opts.Region, "s3-region", "us-east-1", "region used to sign S3 requests")
+	fs.StringVar(&s3opts.Prefix, "s3-prefix", "", "key prefix for uploaded files")
+	fs.StringVar(&s3opts.ACL, "s3-acl", "", "canned ACL for uploaded files, e.g. public-read")
+	fs.StringVar(&s3opts.CacheControl, "s3-cache-control", "", "Cache-Control header for uploaded files")
+	fs.Int64Var(&s3opts.PartSize, "s3-part-size", 8<<20, "multipart upload part size in bytes")
+	fs.IntVar(&s3opts.Concurrency, "s3-concurrency", 4, "number of concurrent uploads and upload parts")
+	addWalkFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img convert [flags] [file|directory|url ...]\n       goodness img convert [flags] -to FORMAT < input > output\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	transforms, err := orientTransforms(*rotation, *flip)
+	if err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid orientation options: %s", err)
+	}
+
+	if *trim {
+		transforms = append(transforms, trimBorders(*trimTolerance, *trimPadding))
+	}
+
+	cropSteps, err := cropTransforms(*crop, *aspect, *gravity)
+	if err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid crop options: %s", err)
+	}
+	transforms = append(transforms, cropSteps...)
+
+	filterSteps, err := filterTransforms(*filters)
+	if err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid filter options: %s", err)
+	}
+	transforms = append(transforms, filterSteps...)
+
+	if *apng != "skip" && *apng != "first" && *apng != "gif" {
+		logging.Exitf(exitcode.Usage, "Invalid -apng value %q", *apng)
+	}
+
+	if *onConflict != "suffix" && *onConflict != "fail" {
+		logging.Exitf(exitcode.Usage, "Invalid -on-conflict value %q", *onConflict)
+	}
+
+	budget, err := parseByteSize(*memoryBudget)
+	if err != nil || budget <= 0 {
+		logging.Exitf(exitcode.Usage, "Invalid -memory-budget %q", *memoryBudget)
+	}
+
+	var target int64
+	if *targetSize != "" {
+		target, err = parseByteSize(*targetSize)
+		if err != nil || target <= 0 {
+			logging.Exitf(exitcode.Usage, "Invalid -target-size %q", *targetSize)
+		}
+	}
+
+	opts := convertOptions{
+		summary:    &runSummary{},
+		transforms: transforms,
+		apng:       *apng,
+		svgScale:   *svgScale,
+		svgWidth:   *svgWidth,
+		svgHeight:  *svgHeight,
+		sidecar:    *sidecar,
+		statePath:  *statePath,
+		resume:     *resume,
+
+		workers:      *workers,
+		memoryBudget: budget,
+
+		targetSize:   target,
+		targetResize: *targetResize,
+
+		output:     "jpeg",
+		configPath: *configPath,
+
+		failOnConflict: *onConflict == "fail",
+		xattrs:         xattrs,
+
+		retries:    max(*retries, 0),
+		retryDelay: *retryDelay,
+	}
+
+	if *auto {
+		opts.output = "auto"
+	}
+
+	if *to != "" {
+		format := strings.ToLower(*to)
+		if format == "jpg" {
+			format = "jpeg"
+		}
+		if format != "jpeg" && format != "png" && format != "gif" && format != "webp" {
+			logging.Exitf(exitcode.Usage, "Invalid -to value %q", *to)
+		}
+		if err := convertStream(os.Stdin, os.Stdout, format, opts); err != nil {
+			logging.Fatalf("Failed to convert image: %s", err)
+		}
+		return
+	}
+
+	var inputs, urls []string
+	for _, arg := range fs.Args() {
+		if isRemote(arg) {
+			urls = append(urls, arg)
+		} else {
+			inputs = append(inputs, arg)
+		}
+	}
+	if *urlList != "" {
+		listed, err := readURLList(*urlList)
+		if err != nil {
+			logging.Fatalf("Failed to read URL list: %s", err)
+		}
+		urls = append(urls, listed...)
+	}
+	if len(inputs) == 0 && len(urls) == 0 && *urlList == "" {
+		inputs = []string{defaultDirectory()}
+	}
+	// Everything from here on stops early, finishing the files in progress,
+	// on SIGINT or SIGTERM
+	ctx := cli.SignalContext()
+	opts.throttle, err = throttle.Start(ctx, limits, *workers)
+	if err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid throttle options: %s", err)
+	}
+	started := time.Now()
+	requested := append(slices.Clone(inputs), urls...)
+
+	if len(urls) > 0 {
+		if *resume {
+			logging.Exitf(exitcode.Usage, "-resume cannot be combined with URL sources")
+		}
+		if *downloadDir == "" {
+			*downloadDir = defaultDirectory()
+		}
+		for _, path := range downloadSources(ctx, urls, *downloadDir, *workers, opts.summary) {
+			if format := formatOf(path); format == "png" || format == "svg" || format == "raw" {
+				inputs = append(inputs, path)
+			}
+		}
+	}
+
+	if s3opts.Bucket != "" {
+		client, err := storage.NewS3(s3opts)
+		if err != nil {
+			logging.Exitf(exitcode.Usage, "Invalid S3 options: %s", err)
+		}
+		opts.uploader = newS3Uploader(client, max(s3opts.Concurrency, 1), opts.summary)
+	}
+
+	if *runLogPath != "" {
+		runLog, err := openRunLog(*runLogPath)
+		if err != nil {
+			logging.Fatalf("Failed to open run log: %s", err)
+		}
+		defer runLog.Close()
+		opts.runLog = runLog
+	}
+
+	inputCache, err := cache.Open(*useCache)
+	if err != nil {
+		logging.Warnf("Not using the cache: %s", err)
+	}
+	opts.cache = inputCache
+	opts.cacheFlags = cache.Flags(fs, "workers", "memory-budget", "state", "resume", "retries", "retry-delay", "run-log", "markdown", "webhook", "notify", "url-list", "download-dir", "s3-part-size", "s3-concurrency", "cache", "max-cpu", "nice", "pause", "io-limit", "battery-pause")
+
+	if *markdownDir != "" {
+		if _, err := os.Stat(*markdownDir); err != nil {
+			logging.Exitf(exitcode.Usage, "Invalid -markdown directory: %s", err)
+		}
+		opts.converted = newConvertedFiles()
+	}
+
+	if len(inputs) > 0 {
+		convertInputs(ctx, inputs, opts)
+	}
+	if err := opts.cache.Save(); err != nil {
+		logging.Errorf("Failed to write the cache: %s", err)
+	}
+
+	if opts.converted != nil {
+		if err := rewriteMarkdownLinks(*markdownDir, opts.converted, opts.summary); err != nil {
+			logging.Errorf("Failed to update markdown links: %s", err)
+		}
+	}
+
+	if ctx.Err() != nil {
+		opts.summary.Interrupt()
+	}
+	opts.summary.Print(os.Stdout)
+
+	if *webhook != "" || *notify {
+		report := newRunReport(requested, started, opts.summary)
+		if *webhook != "" {
+			if err := postWebhook(*webhook, report); err != nil {
+				logging.Errorf("Failed to call webhook: %s", err)
+			}
+		}
+		if *notify {
+			message := fmt.Sprintf("%d succeeded, %d failed", report.Succeeded, report.Failed)
+			if err := notifyDesktop("jpgr finished", message); err != nil {
+				logging.Errorf("Failed to show notification: %s", err)
+			}
+		}
+	}
+
+	output.Exit(opts.summary.ExitCode())
+}
@@ -0,0 +1,150 @@
+package jpgr
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+)
+
+// manifestFileName is written in the manifest's directory in the same format
+// as sha256sum, so `sha256sum -c` can check it too
+const manifestFileName = "jpgr.sha256"
+
+func runManifest(args []string) {
+	fs := flag.NewFlagSet("manifest", flag.ExitOnError)
+	logging.AddFlags(fs)
+	out := fs.String("out", "", "manifest path (default <directory>/"+manifestFileName+")")
+	addWalkFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img manifest [flags] [directory]\n"))
+		fs.PrintDefaults()
+	}
+	cli.Parse(fs, args)
+
+	directoryPath := directoryArg(fs)
+	if *out == "" {
+		*out = filepath.Join(directoryPath, manifestFileName)
+	}
+
+	var paths []string
+	err := walkTree(directoryPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && isRaster(path) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		logging.Fatalf("Error processing directory: %s", err)
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	for _, path := range paths {
+		sum, err := fileSHA256(path)
+		if err != nil {
+			logging.Fatalf("Failed to hash file: %s", err)
+		}
+		rel, err := filepath.Rel(directoryPath, path)
+		if err != nil {
+			logging.Fatalf("Failed to hash file: %s", err)
+		}
+		fmt.Fprintf(&b, "%s  %s\n", sum, filepath.ToSlash(rel))
+	}
+
+	if err := writeFileAtomic(*out, []byte(b.String()), 0644); err != nil {
+		logging.Fatalf("Failed to write manifest: %s", err)
+	}
+	output.Wrote(*out)
+	logging.Infof("Manifest written: %s (%d files)", *out, len(paths))
+}
+
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	logging.AddFlags(fs)
+	manifest := fs.String("manifest", "", "manifest path (default <directory>/"+manifestFileName+")")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img verify [flags] [directory]\n"))
+		fs.PrintDefaults()
+	}
+	cli.Parse(fs, args)
+
+	directoryPath := directoryArg(fs)
+	if *manifest == "" {
+		*manifest = filepath.Join(directoryPath, manifestFileName)
+	}
+
+	f, err := os.Open(*manifest)
+	if err != nil {
+		logging.Fatalf("Failed to read manifest: %s", err)
+	}
+	defer f.Close()
+
+	checked, problems := 0, 0
+	// problem records a file that fails verification
+	problem := func(rel, kind string, err error) {
+		output.Processed(rel, kind, "verify", err)
+		problems++
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		want, rel, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			continue
+		}
+		checked++
+
+		got, err := fileSHA256(filepath.Join(directoryPath, filepath.FromSlash(rel)))
+		switch {
+		case os.IsNotExist(err):
+			fmt.Printf("MISSING  %s\n", rel)
+			problem(rel, "missing", nil)
+		case err != nil:
+			fmt.Printf("ERROR    %s: %s\n", rel, err)
+			problem(rel, "failed", err)
+		case got != want:
+			fmt.Printf("CHANGED  %s\n", rel)
+			problem(rel, "changed", nil)
+		default:
+			output.Processed(rel, "ok", "", nil)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logging.Fatalf("Failed to read manifest: %s", err)
+	}
+
+	fmt.Printf("Verified %d files, %d problems\n", checked, problems)
+	if problems > 0 {
+		output.Exit(exitcode.Failures)
+	}
+}
+
+// fileSHA256 hashes a file without loading it into memory at once
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,172 @@
+package jpgr
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/markdown"
+)
+
+// markdownExtensions are the files -markdown scans for links
+var markdownExtensions = map[string]bool{".md": true, ".markdown": true, ".mdx": true}
+
+// convertedFiles records the output written for each source during a run,
+// keyed by absolute path, so links to the sources can be pointed at them
+type convertedFiles struct {
+	mu      sync.Mutex
+	outputs map[string]string
+}
+
+func newConvertedFiles() *convertedFiles {
+	return &convertedFiles{outputs: make(map[string]string)}
+}
+
+func (c *convertedFiles) Record(source, output string) {
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return
+	}
+	if output, err = filepath.Abs(output); err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.outputs[source] = output
+}
+
+// rewriteMarkdownLinks points links in the markdown files under root that
+// refer to a converted source at its output instead. Links starting with /
+// are taken as relative to root, as static site generators do
+func rewriteMarkdownLinks(root string, converted *convertedFiles, summary *runSummary) error {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	return walkTree(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !markdownExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			summary.Fail(path, "markdown", err)
+			return nil
+		}
+		dir, err := filepath.Abs(filepath.Dir(path))
+		if err != nil {
+			summary.Fail(path, "markdown", err)
+			return nil
+		}
+
+		updated, count := markdown.RewriteLinks(string(data), func(target string) (string, bool) {
+			return converted.relink(target, dir, absRoot)
+		})
+		if count == 0 {
+			return nil
+		}
+		if err := writeFileAtomic(path, []byte(updated), info.Mode().Perm()); err != nil {
+			summary.Fail(path, "markdown", err)
+			return nil
+		}
+		output.Wrote(path)
+		logging.Infof("Markdown links updated: %s (%d)", path, count)
+		return nil
+	})
+}
+
+// localLink is a link target in a markdown file that refers to a local file
+type localLink struct {
+	// link is the target without angle brackets, query or fragment, and
+	// suffix the query or fragment
+	link, suffix       string
+	bracketed, escaped bool
+	// base is the folder the link is relative to and path the file
+	base, path string
+}
+
+// parseLocalLink reads a link target written in a markdown file in dir,
+// reporting false when it is not a local file. Links starting with / are
+// relative to root
+func parseLocalLink(target, dir, root string) (localLink, bool) {
+	l := localLink{bracketed: strings.HasPrefix(target, "<") && strings.HasSuffix(target, ">")}
+	l.link = strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+	if l.link == "" || strings.Contains(l.link, "://") || strings.HasPrefix(l.link, "data:") || strings.HasPrefix(l.link, "mailto:") {
+		return l, false
+	}
+
+	// A query or fragment is kept as it is
+	if i := strings.IndexAny(l.link, "?#"); i >= 0 {
+		l.link, l.suffix = l.link[:i], l.link[i:]
+	}
+	if l.link == "" {
+		return l, false
+	}
+	unescaped, err := url.PathUnescape(l.link)
+	if err != nil {
+		unescaped = l.link
+	}
+	l.escaped = unescaped != l.link
+
+	l.base = dir
+	if strings.HasPrefix(unescaped, "/") {
+		l.base = root
+	}
+	l.path = filepath.Join(l.base, filepath.FromSlash(unescaped))
+	return l, true
+}
+
+// relink returns the new target for a link target written in a markdown file
+// in dir, when it refers to a converted source. The link keeps its form:
+// the same relative prefix, escaping and angle brackets
+func (c *convertedFiles) relink(target, dir, root string) (string, bool) {
+	l, ok := parseLocalLink(target, dir, root)
+	if !ok {
+		return "", false
+	}
+	link, suffix, escaped, base, source := l.link, l.suffix, l.escaped, l.base, l.path
+
+	c.mu.Lock()
+	output, ok := c.outputs[source]
+	c.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+
+	var updated string
+	if filepath.Dir(output) == filepath.Dir(source) {
+		name := filepath.Base(output)
+		if escaped {
+			name = url.PathEscape(name)
+		}
+		updated = link[:strings.LastIndex(link, "/")+1] + name
+	} else {
+		rel, err := filepath.Rel(base, output)
+		if err != nil {
+			return "", false
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if escaped {
+			for i, part := range parts {
+				parts[i] = url.PathEscape(part)
+			}
+		}
+		updated = strings.Join(parts, "/")
+		if base == root {
+			updated = "/" + updated
+		}
+	}
+
+	updated += suffix
+	if l.bracketed {
+		updated = "<" + updated + ">"
+	}
+	return updated, true
+}
@@ -0,0 +1,222 @@
+package jpgr
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/internal/plan"
+)
+
+// mergeEntry is one row of the merge report. Action is merged, renamed when
+// the file got a new name to avoid a collision, or skipped when an identical
+// file was already there
+type mergeEntry struct {
+	Action string `json:"action"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+func runMerge(args []string) {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	logging.AddFlags(fs)
+	into := fs.String("into", "", "directory the folders are merged into (required)")
+	move := fs.Bool("move", false, "move files instead of copying them")
+	dryRun := fs.Bool("dry-run", false, "print the report without touching any file")
+	asJSON := fs.Bool("json", false, "print the report as a JSON array instead of a table")
+	planFile := addPlanFlag(fs)
+	addWalkFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img merge -into DIRECTORY [flags] directory ...\n\nMerges image folders into one, keeping their subfolders. Files identical to\none already merged are skipped, and different files with the same name get a\n-2, -3, ... suffix.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if *into == "" || fs.NArg() == 0 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+
+	// hashes maps the content hash of every file in the destination, and of
+	// every file planned to land there, to its path
+	hashes := make(map[string]string)
+	if err := indexMergeTarget(*into, hashes); err != nil {
+		logging.Fatalf("Error processing directory: %s", err)
+	}
+
+	var p *plan.Plan
+	if *planFile != "" {
+		p = plan.New("img merge")
+	}
+	ctx := cli.SignalContext()
+	summary := &runSummary{}
+	entries := []mergeEntry{}
+	taken := make(map[string]bool)
+	for _, root := range fs.Args() {
+		if filepath.Clean(root) == filepath.Clean(*into) {
+			logging.Warnf("Skipping %s: it is the merge target", root)
+			continue
+		}
+		err := walkTree(root, func(path string, info os.FileInfo, err error) error {
+			if ctx.Err() != nil {
+				return filepath.SkipAll
+			}
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && filepath.Clean(path) == filepath.Clean(*into) {
+				return filepath.SkipDir
+			}
+			if info.IsDir() || formatOf(path) == "" {
+				return nil
+			}
+
+			entry, err := mergeFile(root, path, *into, hashes, taken, *move, *dryRun, p)
+			if err != nil {
+				summary.Fail(path, "merge", err)
+				return nil
+			}
+			summary.Succeed(path)
+			entries = append(entries, entry)
+			return nil
+		})
+		if err != nil {
+			logging.Fatalf("Error processing directory: %s", err)
+		}
+	}
+
+	if ctx.Err() != nil {
+		summary.Interrupt()
+	}
+	output.Set("entries", entries)
+	summaryOut := os.Stdout
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entries); err != nil {
+			logging.Fatalf("Failed to write JSON: %s", err)
+		}
+		summaryOut = os.Stderr
+	} else {
+		printMergeReport(os.Stdout, entries)
+	}
+	summary.Print(summaryOut)
+	switch {
+	case p != nil && ctx.Err() != nil:
+		logging.Warnf("Not writing the plan: the run stopped before it was complete")
+	case p != nil:
+		savePlan(summaryOut, p, *planFile)
+	}
+	output.Exit(summary.ExitCode())
+}
+
+// indexMergeTarget hashes the images already in dir
+func indexMergeTarget(dir string, hashes map[string]string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	return walkTree(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || formatOf(path) == "" {
+			return err
+		}
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return err
+		}
+		if _, ok := hashes[sum]; !ok {
+			hashes[sum] = path
+		}
+		return nil
+	})
+}
+
+// mergeFile places one file from root at the same relative path under into,
+// unless an identical file is already there or planned. With p it records
+// the change there instead
+func mergeFile(root, path, into string, hashes map[string]string, taken map[string]bool, move, dryRun bool, p *plan.Plan) (mergeEntry, error) {
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return mergeEntry{}, err
+	}
+	if existing, ok := hashes[sum]; ok {
+		return mergeEntry{Action: "skipped", Source: path, Target: existing}, nil
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return mergeEntry{}, err
+	}
+	if rel == "." {
+		rel = filepath.Base(path)
+	}
+	dir := filepath.Join(into, filepath.Dir(rel))
+	target := uniquePath(dir, filepath.Base(rel), taken)
+	hashes[sum] = target
+
+	entry := mergeEntry{Action: "merged", Source: path, Target: target}
+	if filepath.Base(target) != filepath.Base(rel) {
+		entry.Action = "renamed"
+	}
+	if p != nil {
+		p.MkdirAll(dir)
+		if move {
+			p.Move(path, target)
+		} else {
+			p.Copy(path, target)
+		}
+		return entry, nil
+	}
+	if dryRun {
+		return entry, nil
+	}
+
+	if err := journal.MkdirAll(dir, 0755); err != nil {
+		return mergeEntry{}, err
+	}
+	if move {
+		err = moveFile(path, target)
+	} else {
+		err = copyFile(path, target)
+	}
+	if err != nil {
+		return mergeEntry{}, err
+	}
+	output.Wrote(target)
+	logging.Infof("Merged: %s -> %s", path, target)
+	return entry, nil
+}
+
+// copyFile copies src to a new file dst, keeping its mode and modification time
+var copyFile = journal.Copy
+
+// moveFile renames src to dst, copying and removing it when they are on
+// different filesystems
+var moveFile = journal.Move
+
+// printMergeReport writes the merge report as a table followed by totals
+func printMergeReport(w io.Writer, entries []mergeEntry) {
+	counts := make(map[string]int)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tSOURCE\tTARGET")
+	for _, e := range entries {
+		counts[e.Action]++
+		target := e.Target
+		if e.Action == "skipped" {
+			target = "identical to " + e.Target
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", e.Action, e.Source, target)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n%d merged, %d renamed, %d skipped as duplicates\n", counts["merged"], counts["renamed"], counts["skipped"])
+}
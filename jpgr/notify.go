@@ -0,0 +1,109 @@
+package jpgr
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"GoodnessucWorkflow/internal/httpclient"
+	"GoodnessucWorkflow/pkg/errs"
+)
+
+// runReport is the JSON body posted to -webhook when a batch finishes. Text
+// is a one-line summary, which chat services such as Slack display as is
+type runReport struct {
+	Text       string          `json:"text"`
+	Inputs     []string        `json:"inputs"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	Seconds    float64         `json:"duration_seconds"`
+	Succeeded  int             `json:"succeeded"`
+	Failed     int             `json:"failed"`
+	Failures   []reportFailure `json:"failures,omitempty"`
+}
+
+type reportFailure struct {
+	File      string `json:"file"`
+	Stage     string `json:"stage"`
+	Error     string `json:"error"`
+	Cause     string `json:"cause,omitempty"`
+	Transient bool   `json:"transient"`
+}
+
+func newRunReport(inputs []string, started time.Time, summary *runSummary) runReport {
+	succeeded, failures := summary.Snapshot()
+	finished := time.Now().UTC()
+	report := runReport{
+		Inputs:     inputs,
+		StartedAt:  started.UTC(),
+		FinishedAt: finished,
+		Seconds:    finished.Sub(started).Seconds(),
+		Succeeded:  succeeded,
+		Failed:     len(failures),
+	}
+	report.Text = fmt.Sprintf("jpgr finished %s in %s: %d succeeded, %d failed",
+		strings.Join(inputs, ", "), finished.Sub(started).Round(time.Second), succeeded, len(failures))
+	for _, f := range failures {
+		report.Failures = append(report.Failures, reportFailure{File: f.path, Stage: f.stage, Error: f.err.Error(), Cause: errs.Kind(f.err), Transient: f.transient})
+	}
+	return report
+}
+
+// postWebhook sends report to url as JSON
+func postWebhook(url string, report runReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpclient.New(30*time.Second).Post(url, "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return &errs.RemoteError{Service: "webhook", Status: resp.Status, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// notifyDesktop shows a native notification with the operating system's own
+// tool: osascript on macOS, notify-send on Linux and PowerShell on Windows
+func notifyDesktop(title, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(message), appleScriptString(title))
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		script := fmt.Sprintf(`Add-Type -AssemblyName System.Windows.Forms
+$n = New-Object System.Windows.Forms.NotifyIcon
+$n.Icon = [System.Drawing.SystemIcons]::Information
+$n.Visible = $true
+$n.ShowBalloonTip(10000, %s, %s, 'Info')
+Start-Sleep -Seconds 5
+$n.Dispose()`, powerShellString(title), powerShellString(message))
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	default:
+		cmd = exec.Command("notify-send", "--app-name=jpgr", title, message)
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s: %s", cmd.Args[0], msg)
+		}
+		return fmt.Errorf("%s: %s", cmd.Args[0], err)
+	}
+	return nil
+}
+
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func powerShellString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,224 @@
+package jpgr
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/internal/plan"
+)
+
+// organizeOptions controls where organize files images and what it does on the way
+type organizeOptions struct {
+	dest    string
+	layout  string
+	convert bool
+	rename  renameOptions
+	dryRun  bool
+	// plan, when set, collects the moves instead of making them
+	plan *plan.Plan
+}
+
+func runOrganize(args []string) {
+	opts := organizeOptions{rename: renameOptions{dateFormat: "2006-01-02", pad: 1}}
+	fs := flag.NewFlagSet("organize", flag.ExitOnError)
+	logging.AddFlags(fs)
+	fs.StringVar(&opts.dest, "dest", "", "root of the dated folders (default the scanned directory)")
+	fs.StringVar(&opts.layout, "layout", "2006/01", "Go time layout for the subfolder, by file modification time")
+	fs.BoolVar(&opts.convert, "convert", false, "convert PNGs to JPEG while filing them")
+	fs.StringVar(&opts.rename.pattern, "pattern", "", "rename while filing; supports {date}, {time}, {seq} and {name} (default keep the name)")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "print where each image would go without touching any file")
+	watch := fs.Bool("watch", false, "keep running and file new images as they appear")
+	interval := fs.Duration("interval", 2*time.Second, "how often -watch rescans the directory")
+	planFile := addPlanFlag(fs)
+	addWalkFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img organize [flags] [directory]\n"))
+		fs.PrintDefaults()
+	}
+	cli.Parse(fs, args)
+
+	directoryPath := directoryArg(fs)
+	if opts.dest == "" {
+		opts.dest = directoryPath
+	}
+	if *planFile != "" {
+		if *watch {
+			logging.Exitf(exitcode.Usage, "Invalid -plan use: it cannot be combined with -watch")
+		}
+		opts.plan = plan.New("img organize")
+	}
+
+	ctx := cli.SignalContext()
+	summary := &runSummary{}
+	if !*watch {
+		if err := organizeOnce(ctx, directoryPath, 0, opts, summary); err != nil {
+			logging.Fatalf("Error processing directory: %s", err)
+		}
+		if ctx.Err() != nil {
+			summary.Interrupt()
+		}
+		summary.Print(os.Stdout)
+		switch {
+		case opts.plan != nil && ctx.Err() != nil:
+			logging.Warnf("Not writing the plan: the run stopped before it was complete")
+		case opts.plan != nil:
+			savePlan(os.Stdout, opts.plan, *planFile)
+		}
+		output.Exit(summary.ExitCode())
+	}
+
+	logging.Infof("Watching %s every %s", directoryPath, *interval)
+	for {
+		// Files touched within the last interval may still be being written
+		if err := organizeOnce(ctx, directoryPath, *interval, opts, summary); err != nil {
+			logging.Errorf("Error processing directory: %s", err)
+		}
+		select {
+		case <-time.After(*interval):
+		case <-ctx.Done():
+			logging.Infof("Stopped watching %s", directoryPath)
+			return
+		}
+	}
+}
+
+// organizeOnce files every image directly inside directoryPath whose
+// modification time is at least settle ago, until ctx is done, recording
+// each outcome in summary
+func organizeOnce(ctx context.Context, directoryPath string, settle time.Duration, opts organizeOptions, summary *runSummary) error {
+	entries, err := os.ReadDir(directoryPath)
+	if err != nil {
+		return err
+	}
+
+	seq := 1
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if entry.IsDir() || isJunk(entry.Name()) || !isRaster(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(directoryPath, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			logging.Errorf("Failed to read file info: %s", err)
+			summary.Fail(path, "read", err)
+			continue
+		}
+		if time.Since(info.ModTime()) < settle {
+			continue
+		}
+
+		if err := organizeFile(path, info.ModTime(), seq, opts); err != nil {
+			logging.Errorf("Failed to organize %s: %s", path, err)
+			summary.Fail(path, "organize", err)
+			continue
+		}
+		summary.Succeed(path)
+		seq++
+	}
+	return nil
+}
+
+// organizeFile moves one image into its dated folder, converting it first when asked
+func organizeFile(path string, modTime time.Time, seq int, opts organizeOptions) error {
+	name := filepath.Base(path)
+	extension := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, extension)
+	if opts.rename.pattern != "" {
+		stem = expandPattern(opts.rename, stem, modTime, seq)
+	}
+
+	var jpegBytes []byte
+	var sourceSize image.Point
+	if opts.convert && formatOf(path) == "png" {
+		imageBytes, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if sourceSize, err = encodedSize(imageBytes); err != nil {
+			return err
+		}
+		if jpegBytes, err = ToJpeg(imageBytes); err != nil {
+			return err
+		}
+		extension = ".jpg"
+	}
+
+	folder := filepath.Join(opts.dest, fsutil.SafePath(modTime.Format(opts.layout)))
+	exists := fileExists
+	if opts.plan != nil {
+		exists = opts.plan.Exists
+	}
+	target := filepath.Join(folder, stem+extension)
+	for n := 2; exists(target); n++ {
+		target = filepath.Join(folder, fmt.Sprintf("%s-%d%s", stem, n, extension))
+	}
+
+	if opts.dryRun || opts.plan != nil {
+		fmt.Printf("%s -> %s\n", path, target)
+	}
+	if opts.plan != nil {
+		opts.plan.MkdirAll(folder)
+		if jpegBytes != nil {
+			opts.plan.Write(target, jpegBytes, 0644, modTime)
+			opts.plan.Delete(path)
+		} else {
+			opts.plan.Move(path, target)
+		}
+		return nil
+	}
+	if opts.dryRun {
+		return nil
+	}
+	if err := journal.MkdirAll(folder, 0755); err != nil {
+		return err
+	}
+
+	if jpegBytes != nil {
+		// The time is set before the file is in place, so the journal
+		// records the file as it ends up
+		tmp := filepath.Join(folder, ".jpgr-organize-"+filepath.Base(target))
+		if err := fsutil.WriteFileAtomic(tmp, jpegBytes, 0644); err != nil {
+			return err
+		}
+		if err := os.Chtimes(tmp, modTime, modTime); err != nil {
+			logging.Errorf("Failed to keep modification time: %s", err)
+		}
+		if err := journal.Replace(tmp, target); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := verifyOutput(target, sourceSize); err != nil {
+			return err
+		}
+		if err := journal.Remove(path); err != nil {
+			return err
+		}
+	} else if err := journal.Rename(path, target); err != nil {
+		return err
+	}
+
+	output.Wrote(target)
+	logging.Infof("Organized: %s -> %s", path, target)
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
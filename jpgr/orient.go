@@ -0,0 +1,83 @@
+package jpgr
+
+import (
+	"fmt"
+	"image"
+)
+
+// orientTransforms turns -rotate (clockwise degrees) and -flip (h or v) into
+// transforms. The flip is applied after the rotation
+func orientTransforms(degrees int, flip string) ([]Transform, error) {
+	var transforms []Transform
+	switch degrees {
+	case 0, 360:
+	case 90, 180, 270:
+		transforms = append(transforms, rotate(degrees))
+	default:
+		return nil, fmt.Errorf("rotation must be 90, 180 or 270, not %d", degrees)
+	}
+
+	switch flip {
+	case "":
+	case "h":
+		transforms = append(transforms, flipHorizontal)
+	case "v":
+		transforms = append(transforms, flipVertical)
+	default:
+		return nil, fmt.Errorf("flip must be h or v, not %q", flip)
+	}
+	return transforms, nil
+}
+
+// rotate turns the image clockwise by 90, 180 or 270 degrees
+func rotate(degrees int) Transform {
+	return func(img image.Image) image.Image {
+		src := toNRGBA(img)
+		w, h := src.Rect.Dx(), src.Rect.Dy()
+
+		dw, dh := w, h
+		if degrees != 180 {
+			dw, dh = h, w
+		}
+		dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				var dx, dy int
+				switch degrees {
+				case 90:
+					dx, dy = h-1-y, x
+				case 180:
+					dx, dy = w-1-x, h-1-y
+				case 270:
+					dx, dy = y, w-1-x
+				}
+				copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+			}
+		}
+		return dst
+	}
+}
+
+// flipHorizontal mirrors the image left to right
+func flipHorizontal(img image.Image) image.Image {
+	src := toNRGBA(img)
+	dst := image.NewNRGBA(src.Rect)
+	w := src.Rect.Dx()
+	for y := 0; y < src.Rect.Dy(); y++ {
+		for x := 0; x < w; x++ {
+			copy(dst.Pix[dst.PixOffset(w-1-x, y):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
+
+// flipVertical mirrors the image top to bottom
+func flipVertical(img image.Image) image.Image {
+	src := toNRGBA(img)
+	dst := image.NewNRGBA(src.Rect)
+	h := src.Rect.Dy()
+	for y := 0; y < h; y++ {
+		copy(dst.Pix[dst.PixOffset(0, h-1-y):][:dst.Stride], src.Pix[src.PixOffset(0, y):][:src.Stride])
+	}
+	return dst
+}
@@ -0,0 +1,50 @@
+package jpgr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"os"
+
+	_ "golang.org/x/image/webp"
+)
+
+// verifyOutput re-reads a written output and decodes every frame of it, so an
+// original is only removed once its replacement is known to be whole. The
+// decoded size must match want
+func verifyOutput(path string, want image.Point) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var size image.Point
+	if formatOf(path) == "gif" {
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("%s does not decode: %s", path, err)
+		}
+		size = image.Pt(g.Config.Width, g.Config.Height)
+	} else {
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("%s does not decode: %s", path, err)
+		}
+		size = img.Bounds().Size()
+	}
+
+	if size != want {
+		return fmt.Errorf("%s is %dx%d, expected %dx%d", path, size.X, size.Y, want.X, want.Y)
+	}
+	return nil
+}
+
+// encodedSize reads the dimensions from the header of an encoded image
+func encodedSize(data []byte) (image.Point, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return image.Point{}, err
+	}
+	return image.Pt(config.Width, config.Height), nil
+}
@@ -0,0 +1,21 @@
+package jpgr
+
+import (
+	"context"
+	"io"
+
+	"GoodnessucWorkflow/pkg/imaging"
+)
+
+// convertStream reads one PNG, JPEG, GIF or SVG from r and writes it to w in
+// format after applying the transforms. WebP is encoded by cwebp when it is
+// installed, since Go has no WebP encoder
+func convertStream(r io.Reader, w io.Writer, format string, opts convertOptions) error {
+	opts.output = format
+	result, err := imaging.Convert(context.Background(), r, opts.imagingOptions())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(result.Data)
+	return err
+}
@@ -0,0 +1,26 @@
+package jpgr
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/internal/plan"
+)
+
+// addPlanFlag registers -plan, which saves the changes a command would make
+// for goodness apply instead of making them
+func addPlanFlag(fs *flag.FlagSet) *string {
+	return fs.String("plan", "", "write the changes to this file for review and goodness apply instead of making them")
+}
+
+// savePlan writes p to path and tells the user on w how to carry it out
+func savePlan(w io.Writer, p *plan.Plan, path string) {
+	if err := p.Save(path); err != nil {
+		logging.Fatalf("Failed to write plan: %s", err)
+	}
+	output.Wrote(path)
+	fmt.Fprintf(w, "\n%s\nPlan written: %s\nReview it with goodness apply -show %s and carry it out with goodness apply %s\n", p.Summary(), path, path, path)
+}
@@ -0,0 +1,226 @@
+package jpgr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"GoodnessucWorkflow/pkg/errs"
+	"GoodnessucWorkflow/pkg/imaging"
+)
+
+// policyFileName is the per-directory conversion policy read from the top of
+// each scanned directory
+const policyFileName = ".jpgr.yaml"
+
+// conversionPolicy is an ordered list of rules; the first rule that matches
+// a file decides what happens to it
+type conversionPolicy struct {
+	Rules []policyRule `yaml:"rules"`
+}
+
+// policyRule matches files by format name (png, svg, jpeg, gif, raw) or by a
+// glob on the file name, optionally only when or unless a condition holds
+type policyRule struct {
+	Match   string `yaml:"match"`
+	When    string `yaml:"when"`
+	Unless  string `yaml:"unless"`
+	To      string `yaml:"to"`
+	Quality int    `yaml:"quality"`
+	Skip    bool   `yaml:"skip"`
+}
+
+// policyConditions are the values accepted by when and unless
+var policyConditions = []string{"transparent", "animated"}
+
+// loadPolicy reads a policy file and checks every rule
+func loadPolicy(path string) (*conversionPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	policy := &conversionPolicy{}
+	if err := dec.Decode(policy); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	for i := range policy.Rules {
+		if err := policy.Rules[i].check(); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %s", path, i+1, err)
+		}
+	}
+	return policy, nil
+}
+
+func (r *policyRule) check() error {
+	if r.Match == "" {
+		return errors.New("match is required")
+	}
+	if _, err := filepath.Match(r.Match, ""); err != nil {
+		return fmt.Errorf("invalid match %q", r.Match)
+	}
+	if r.Skip == (r.To != "") {
+		return errors.New("set exactly one of to and skip")
+	}
+	r.To = strings.ToLower(r.To)
+	if r.To == "jpg" {
+		r.To = "jpeg"
+	}
+	if r.To != "" && r.To != "jpeg" && r.To != "png" && r.To != "gif" && r.To != "webp" && r.To != "auto" {
+		return fmt.Errorf("invalid to %q: expected jpeg, png, gif, webp or auto", r.To)
+	}
+	if r.Quality < 0 || r.Quality > 100 {
+		return fmt.Errorf("quality %d is outside 0-100", r.Quality)
+	}
+	for _, condition := range []string{r.When, r.Unless} {
+		if condition != "" && !slices.Contains(policyConditions, condition) {
+			return fmt.Errorf("unknown condition %q: expected %s", condition, strings.Join(policyConditions, " or "))
+		}
+	}
+	return nil
+}
+
+// matches reports whether the rule's pattern selects path, ignoring conditions
+func (r policyRule) matches(path string) bool {
+	if r.Match == formatOf(path) || (r.Match == "jpg" && formatOf(path) == "jpeg") {
+		return true
+	}
+	ok, _ := filepath.Match(strings.ToLower(r.Match), strings.ToLower(filepath.Base(path)))
+	return ok
+}
+
+// collects reports whether a run should plan to convert path. Files no rule
+// mentions fall back to the default of converting PNG, SVG and RAW files
+func (p *conversionPolicy) collects(path string) bool {
+	format := formatOf(path)
+	if format == "" || format == "video" {
+		return false
+	}
+	if p != nil {
+		for _, rule := range p.Rules {
+			if !rule.matches(path) {
+				continue
+			}
+			// A conditional rule may not apply, so later rules still count
+			if rule.When != "" || rule.Unless != "" {
+				if !rule.Skip {
+					return true
+				}
+				continue
+			}
+			return !rule.Skip
+		}
+	}
+	return format == "png" || format == "svg" || format == "raw"
+}
+
+// ruleFor returns the first rule that applies to path, or nil when none does.
+// Conditions are only evaluated, and the image only decoded, when a rule
+// that could match has one
+func (p *conversionPolicy) ruleFor(path string, data []byte, opts convertOptions) (*policyRule, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	facts := make(map[string]bool)
+	holds := func(condition string) (bool, error) {
+		if value, ok := facts[condition]; ok {
+			return value, nil
+		}
+		var value bool
+		switch condition {
+		case "animated":
+			value = isAPNG(data) || isAnimatedGIF(data)
+		case "transparent":
+			img, err := decodeSource(path, data, opts)
+			if err != nil {
+				return false, err
+			}
+			value = imaging.HasTransparency(img)
+		}
+		facts[condition] = value
+		return value, nil
+	}
+
+	for i, rule := range p.Rules {
+		if !rule.matches(path) {
+			continue
+		}
+		if rule.When != "" {
+			ok, err := holds(rule.When)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		if rule.Unless != "" {
+			ok, err := holds(rule.Unless)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				continue
+			}
+		}
+		return &p.Rules[i], nil
+	}
+	return nil, nil
+}
+
+// decodeSource decodes any file a run can convert, before transforms
+func decodeSource(path string, data []byte, opts convertOptions) (image.Image, error) {
+	switch formatOf(path) {
+	case "svg":
+		return imaging.RasterizeSVG(data, opts.svgScale, opts.svgWidth, opts.svgHeight)
+	case "raw":
+		img, err := decodeRAW(path, data)
+		return img, errs.Decode(err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, errs.Decode(err)
+}
+
+// loadPolicies finds the policy for each root. An explicit configPath applies
+// to every root; otherwise each root's own policy file is used when present
+func loadPolicies(roots []string, configPath string) (map[string]*conversionPolicy, error) {
+	policies := make(map[string]*conversionPolicy)
+	if configPath != "" {
+		policy, err := loadPolicy(configPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, root := range roots {
+			policies[root] = policy
+		}
+		return policies, nil
+	}
+
+	for _, root := range roots {
+		if _, ok := policies[root]; ok {
+			continue
+		}
+		path := filepath.Join(root, policyFileName)
+		if !fileExists(path) {
+			policies[root] = nil
+			continue
+		}
+		policy, err := loadPolicy(path)
+		if err != nil {
+			return nil, err
+		}
+		policies[root] = policy
+	}
+	return policies, nil
+}
@@ -0,0 +1,141 @@
+package jpgr
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os/exec"
+	"strings"
+
+	"GoodnessucWorkflow/internal/fsutil"
+)
+
+// TIFF tags that locate image data inside RAW containers
+const (
+	tagCompression     = 0x0103
+	tagStripOffsets    = 0x0111
+	tagStripByteCounts = 0x0117
+	tagSubIFDs         = 0x014a
+	tagJPEGOffset      = 0x0201
+	tagJPEGLength      = 0x0202
+	compressionOldJPEG = 6
+	compressionJPEG    = 7
+	maxRAWIFDs         = 32
+)
+
+// decodeRAW develops a camera RAW file. dcraw is used when it is installed,
+// with the camera's white balance and dcraw's automatic exposure; otherwise
+// the largest JPEG preview the camera embedded in the file is used
+func decodeRAW(path string, data []byte) (image.Image, error) {
+	if dcraw, err := exec.LookPath("dcraw"); err == nil {
+		var stderr bytes.Buffer
+		cmd := exec.Command(dcraw, "-c", "-w", fsutil.LongPath(path))
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err == nil {
+			return decodePPM(bytes.NewReader(out))
+		}
+		return nil, fmt.Errorf("dcraw: %s", strings.TrimSpace(stderr.String()))
+	}
+	return rawPreview(data)
+}
+
+// rawPreview finds the largest decodable JPEG referenced from any IFD of a
+// TIFF-based RAW file (DNG, CR2, NEF, ARW)
+func rawPreview(data []byte) (image.Image, error) {
+	r, first, err := newTIFFReader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var best image.Image
+	bestPixels := 0
+	consider := func(off, length int) {
+		if off <= 0 || length <= 0 || off+length > len(data) || !bytes.HasPrefix(data[off:], []byte{0xff, 0xd8}) {
+			return
+		}
+		config, err := jpeg.DecodeConfig(bytes.NewReader(data[off : off+length]))
+		if err != nil || config.Width*config.Height <= bestPixels {
+			return
+		}
+		// Lossless JPEG sensor data passes DecodeConfig but not Decode
+		img, err := jpeg.Decode(bytes.NewReader(data[off : off+length]))
+		if err != nil {
+			return
+		}
+		best, bestPixels = img, config.Width*config.Height
+	}
+
+	queue := []uint32{first}
+	seen := make(map[uint32]bool)
+	for len(queue) > 0 && len(seen) < maxRAWIFDs {
+		offset := queue[0]
+		queue = queue[1:]
+		if offset == 0 || seen[offset] {
+			continue
+		}
+		seen[offset] = true
+
+		ifd, err := r.readIFD(offset)
+		if err != nil {
+			continue
+		}
+		// The pointer to the next IFD in the chain follows the entries
+		n := int(r.order.Uint16(data[offset:]))
+		if next := int(offset) + 2 + 12*n; next+4 <= len(data) {
+			queue = append(queue, r.order.Uint32(data[next:]))
+		}
+		for _, tag := range []uint16{tagSubIFDs, tagExifIFD} {
+			if e, ok := ifd[tag]; ok && (e.typ == 4 || e.typ == 13) {
+				for i := 0; i < e.count; i++ {
+					queue = append(queue, r.order.Uint32(e.value[4*i:]))
+				}
+			}
+		}
+
+		if off, ok := ifd[tagJPEGOffset]; ok {
+			consider(r.uint(off), r.uint(ifd[tagJPEGLength]))
+		}
+		if c := r.uint(ifd[tagCompression]); c == compressionOldJPEG || c == compressionJPEG {
+			if off, ok := ifd[tagStripOffsets]; ok && off.count == 1 {
+				consider(r.uint(off), r.uint(ifd[tagStripByteCounts]))
+			}
+		}
+	}
+
+	if best == nil {
+		return nil, errors.New("no embedded preview found; install dcraw to develop the sensor data")
+	}
+	return best, nil
+}
+
+// decodePPM reads the binary 8-bit PPM that dcraw writes
+func decodePPM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	var magic string
+	var width, height, maxValue int
+	if _, err := fmt.Fscan(br, &magic, &width, &height, &maxValue); err != nil {
+		return nil, err
+	}
+	if magic != "P6" || maxValue != 255 || width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("unsupported PPM %s with max value %d", magic, maxValue)
+	}
+	if _, err := br.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	rgb := make([]byte, width*height*3)
+	if _, err := io.ReadFull(br, rgb); err != nil {
+		return nil, err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < width*height; i++ {
+		copy(img.Pix[4*i:4*i+3], rgb[3*i:3*i+3])
+		img.Pix[4*i+3] = 0xff
+	}
+	return img, nil
+}
@@ -0,0 +1,165 @@
+package jpgr
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/httpclient"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/errs"
+)
+
+// isRemote reports whether an input names a URL rather than a local path
+func isRemote(input string) bool {
+	return strings.HasPrefix(input, "https://") || strings.HasPrefix(input, "http://")
+}
+
+// readURLList reads one URL per line, ignoring blank lines and # comments
+func readURLList(listPath string) ([]string, error) {
+	f, err := os.Open(listPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var urls []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			urls = append(urls, line)
+		}
+	}
+	return urls, scanner.Err()
+}
+
+// downloadSources fetches urls into dir with a pool of workers and returns the
+// local paths in the order the URLs were given. Failed downloads are recorded
+// in the summary and left out, and once ctx is done no more are started
+func downloadSources(ctx context.Context, urls []string, dir string, workers int, summary *runSummary) []string {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		for _, u := range urls {
+			summary.Fail(u, "download", err)
+		}
+		return nil
+	}
+
+	client := httpclient.New(5 * time.Minute)
+	paths := make([]string, len(urls))
+	var reserve sync.Mutex
+	reserved := make(map[string]bool)
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				localPath, err := download(client, urls[n], dir, func(name string) string {
+					reserve.Lock()
+					defer reserve.Unlock()
+					return uniquePath(dir, name, reserved)
+				})
+				if err != nil {
+					summary.Fail(urls[n], "download", err)
+					continue
+				}
+				output.Wrote(localPath)
+				logging.Infof("Download successful: %s", localPath)
+				paths[n] = localPath
+			}
+		}()
+	}
+feed:
+	for n := range urls {
+		select {
+		case jobs <- n:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	var done []string
+	for _, p := range paths {
+		if p != "" {
+			done = append(done, p)
+		}
+	}
+	return done
+}
+
+// download saves one URL into dir under a name taken from the URL path, with
+// an extension from the Content-Type when the path has none
+func download(client *httpclient.Client, rawURL, dir string, reserve func(name string) string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &errs.RemoteError{Service: resp.Request.URL.Host, Status: resp.Status, StatusCode: resp.StatusCode}
+	}
+
+	name := path.Base(u.Path)
+	if name == "/" || name == "." || name == ".." {
+		name = "download"
+	}
+	name = fsutil.SafeName(name)
+	if path.Ext(name) == "" {
+		mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if extensions, _ := mime.ExtensionsByType(mediaType); len(extensions) > 0 {
+			name += extensions[0]
+		}
+	}
+	target := reserve(name)
+
+	tmp, err := os.CreateTemp(dir, ".jpgr-download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return target, journal.Replace(tmp.Name(), target)
+}
+
+// uniquePath returns dir/name, or dir/name-N when that exists on disk or was
+// already handed out in taken
+func uniquePath(dir, name string, taken map[string]bool) string {
+	extension := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, extension)
+	target := filepath.Join(dir, name)
+	for n := 2; taken[target] || fileExists(target); n++ {
+		target = filepath.Join(dir, fmt.Sprintf("%s-%d%s", stem, n, extension))
+	}
+	taken[target] = true
+	return target
+}
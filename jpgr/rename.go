@@ -0,0 +1,311 @@
+package jpgr
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/internal/plan"
+)
+
+// renameOptions controls how new file names are built
+type renameOptions struct {
+	pattern    string
+	dateFormat string
+	start      int
+	pad        int
+	by         string
+	dryRun     bool
+}
+
+// renamePlan is a single planned rename within one directory
+type renamePlan struct {
+	from string
+	to   string
+}
+
+func runRename(args []string) {
+	opts := renameOptions{}
+	fs := flag.NewFlagSet("rename", flag.ExitOnError)
+	logging.AddFlags(fs)
+	fs.StringVar(&opts.pattern, "pattern", "screenshot-{date}-{seq}", "name pattern; supports {date}, {time}, {seq} and {name}")
+	fs.StringVar(&opts.dateFormat, "date-format", "2006-01-02", "Go time layout used for {date}")
+	fs.IntVar(&opts.start, "start", 1, "first sequence number")
+	fs.IntVar(&opts.pad, "pad", 3, "zero-pad {seq} to this many digits")
+	fs.StringVar(&opts.by, "by", "mtime", "time used for {date}, {time} and ordering: mtime, or exif for the capture date, falling back to mtime")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "print the renames without touching any file")
+	planFile := addPlanFlag(fs)
+	addWalkFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img rename [flags] [directory]\n\nExample: goodness img rename -by exif -pattern {date}_{time} names photos like 2024-05-01_132210.jpg\n\n"))
+		fs.PrintDefaults()
+	}
+	cli.Parse(fs, args)
+
+	if opts.by != "mtime" && opts.by != "exif" {
+		logging.Exitf(exitcode.Usage, "Invalid -by value %q", opts.by)
+	}
+
+	directoryPath := directoryArg(fs)
+
+	plans, err := planRenames(directoryPath, opts)
+	if err != nil {
+		logging.Fatalf("Error planning renames: %s", err)
+	}
+
+	if opts.dryRun || *planFile != "" {
+		renames := make([]map[string]string, 0, len(plans))
+		for _, p := range plans {
+			fmt.Printf("%s -> %s\n", filepath.Base(p.from), filepath.Base(p.to))
+			renames = append(renames, map[string]string{"from": p.from, "to": p.to})
+		}
+		output.Set("renames", renames)
+		if *planFile != "" {
+			p := plan.New("img rename")
+			recordRenames(p, plans)
+			savePlan(os.Stdout, p, *planFile)
+		}
+		return
+	}
+
+	if len(plans) == 0 {
+		return
+	}
+	ctx := cli.SignalContext()
+	summary := &runSummary{}
+	if !applyRenames(ctx, plans, summary) {
+		if ctx.Err() != nil {
+			summary.Interrupt()
+			logging.Warnf("Interrupted; every file keeps its old name")
+		} else {
+			logging.Errorf("Failed to move every file aside; every file keeps its old name")
+		}
+	}
+	summary.Print(os.Stdout)
+	output.Exit(summary.ExitCode())
+}
+
+// planRenames lists the images in directoryPath oldest first and assigns each a
+// unique name built from the pattern
+func planRenames(directoryPath string, opts renameOptions) ([]renamePlan, error) {
+	entries, err := os.ReadDir(directoryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		name    string
+		modTime time.Time
+	}
+
+	var images []candidate
+	taken := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() || isJunk(entry.Name()) || !isRaster(entry.Name()) {
+			taken[strings.ToLower(entry.Name())] = true
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		modTime := info.ModTime()
+		if opts.by == "exif" {
+			if captured, ok := captureTime(filepath.Join(directoryPath, entry.Name())); ok {
+				modTime = captured
+			} else {
+				logging.Debugf("No EXIF capture date, using the modification time: %s", entry.Name())
+			}
+		}
+		images = append(images, candidate{name: entry.Name(), modTime: modTime})
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		if images[i].modTime.Equal(images[j].modTime) {
+			return images[i].name < images[j].name
+		}
+		return images[i].modTime.Before(images[j].modTime)
+	})
+
+	var plans []renamePlan
+	for i, img := range images {
+		extension := filepath.Ext(img.name)
+		stem := expandPattern(opts, img.name[:len(img.name)-len(extension)], img.modTime, opts.start+i)
+		extension = strings.ToLower(extension)
+
+		newName := stem + extension
+		for n := 2; taken[strings.ToLower(newName)]; n++ {
+			newName = fmt.Sprintf("%s-%d%s", stem, n, extension)
+		}
+		taken[strings.ToLower(newName)] = true
+
+		if newName == img.name {
+			continue
+		}
+
+		plans = append(plans, renamePlan{
+			from: filepath.Join(directoryPath, img.name),
+			to:   filepath.Join(directoryPath, newName),
+		})
+	}
+
+	return plans, nil
+}
+
+// captureTime reads DateTimeOriginal from the EXIF data of a JPEG or PNG.
+// EXIF times carry no zone, so they are taken as local time
+func captureTime(path string) (time.Time, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	tiff := exifBlock(data)
+	if tiff == nil {
+		return time.Time{}, false
+	}
+	x, err := parseExif(tiff)
+	if err != nil || x.DateTimeOriginal == "" {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", x.DateTimeOriginal, time.Local)
+	return t, err == nil
+}
+
+// expandPattern substitutes the pattern placeholders for a single file,
+// making the result a name Windows accepts
+func expandPattern(opts renameOptions, stem string, modTime time.Time, seq int) string {
+	digits := strconv.Itoa(seq)
+	if len(digits) < opts.pad {
+		digits = strings.Repeat("0", opts.pad-len(digits)) + digits
+	}
+
+	replacer := strings.NewReplacer(
+		"{date}", modTime.Format(opts.dateFormat),
+		"{time}", modTime.Format("150405"),
+		"{seq}", digits,
+		"{name}", slugify(stem),
+	)
+	return fsutil.SafeName(replacer.Replace(opts.pattern))
+}
+
+// slugify lowercases s and collapses everything that is not a letter or digit
+// into single dashes, so the result is safe to use in markdown links
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// recordRenames adds plans to p in an order where no rename lands on a file
+// that has not moved yet, parking one file under a temporary name to break
+// each cycle of swapped names. Renames that only change case are parked too,
+// as the new name is the same file on case-insensitive filesystems
+func recordRenames(p *plan.Plan, plans []renamePlan) {
+	pending := slices.Clone(plans)
+	for parked := 0; len(pending) > 0; {
+		sources := make(map[string]bool, len(pending))
+		for _, r := range pending {
+			sources[strings.ToLower(r.from)] = true
+		}
+
+		var blocked []renamePlan
+		for _, r := range pending {
+			if sources[strings.ToLower(r.to)] {
+				blocked = append(blocked, r)
+				continue
+			}
+			p.Move(r.from, r.to)
+			delete(sources, strings.ToLower(r.from))
+		}
+		if len(blocked) == len(pending) {
+			r := &blocked[0]
+			tmp := filepath.Join(filepath.Dir(r.from), fmt.Sprintf(".jpgr-rename-%d", parked))
+			for parked++; p.Exists(tmp); parked++ {
+				tmp = filepath.Join(filepath.Dir(r.from), fmt.Sprintf(".jpgr-rename-%d", parked))
+			}
+			p.Move(r.from, tmp)
+			r.from = tmp
+		}
+		pending = blocked
+	}
+}
+
+// applyRenames moves every file to a temporary name first, so plans that swap
+// or chain names never overwrite a file that has not been moved yet. When ctx
+// is done, or a file cannot be moved aside and so still holds a name another
+// plan may take, the files already aside are moved back and it reports
+// false. Once every file is aside the renames are finished, a file whose
+// rename fails going back to its own name. Outcomes go to summary
+func applyRenames(ctx context.Context, plans []renamePlan, summary *runSummary) bool {
+	staged := make(map[int]string, len(plans))
+	abort := func() {
+		for j, tmp := range staged {
+			restore(tmp, plans[j].from)
+		}
+	}
+	for i, p := range plans {
+		if ctx.Err() != nil {
+			abort()
+			return false
+		}
+		tmp := filepath.Join(filepath.Dir(p.from), fmt.Sprintf(".jpgr-rename-%d-%d", os.Getpid(), i))
+		if err := journal.Rename(p.from, tmp); err != nil {
+			logging.Errorf("Failed to rename file: %s", err)
+			summary.Fail(p.from, "rename", err)
+			abort()
+			return false
+		}
+		staged[i] = tmp
+	}
+
+	for i, p := range plans {
+		tmp := staged[i]
+		if err := journal.Rename(tmp, p.to); err != nil {
+			logging.Errorf("Failed to rename file: %s", err)
+			summary.Fail(p.from, "rename", err)
+			restore(tmp, p.from)
+			continue
+		}
+		output.Wrote(p.to)
+		summary.Succeed(p.to)
+		logging.Infof("Renamed: %s -> %s", filepath.Base(p.from), filepath.Base(p.to))
+	}
+	return true
+}
+
+// restore moves a file left at tmp back to from, unless another file was
+// renamed to from meanwhile, and otherwise says where it is
+func restore(tmp, from string) {
+	if _, err := os.Lstat(from); err == nil {
+		logging.Errorf("Failed to rename file back, %s is taken; it is left at %s", filepath.Base(from), tmp)
+		return
+	}
+	if err := journal.Rename(tmp, from); err != nil {
+		logging.Errorf("Failed to rename file back, it is left at %s: %s", tmp, err)
+	}
+}
@@ -0,0 +1,176 @@
+package jpgr
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/plan"
+)
+
+func TestRecordRenames(t *testing.T) {
+	tests := []struct {
+		name    string
+		renames [][2]string
+		// existing are files besides the ones renamed
+		existing []string
+	}{
+		{"independent", [][2]string{{"a.jpg", "x.jpg"}, {"b.jpg", "y.jpg"}}, nil},
+		{"chain", [][2]string{{"a.jpg", "b.jpg"}, {"b.jpg", "c.jpg"}, {"c.jpg", "d.jpg"}}, nil},
+		{"swap", [][2]string{{"a.jpg", "b.jpg"}, {"b.jpg", "a.jpg"}}, nil},
+		{"3-cycle", [][2]string{{"a.jpg", "b.jpg"}, {"b.jpg", "c.jpg"}, {"c.jpg", "a.jpg"}}, nil},
+		{"cycle and chain", [][2]string{{"a.jpg", "b.jpg"}, {"b.jpg", "a.jpg"}, {"c.jpg", "d.jpg"}, {"d.jpg", "e.jpg"}}, nil},
+		{"two swaps", [][2]string{{"a.jpg", "b.jpg"}, {"b.jpg", "a.jpg"}, {"c.jpg", "d.jpg"}, {"d.jpg", "c.jpg"}}, nil},
+		{"case only", [][2]string{{"a.jpg", "A.jpg"}}, nil},
+		{"case swap", [][2]string{{"a.jpg", "B.jpg"}, {"b.jpg", "A.jpg"}}, nil},
+		{"parked name taken", [][2]string{{"a.jpg", "b.jpg"}, {"b.jpg", "a.jpg"}}, []string{".jpgr-rename-0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.existing {
+				if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// files maps each lowercased name to the name it has and the
+			// file it held first, as on a case-insensitive filesystem
+			type file struct{ name, origin string }
+			files := make(map[string]file)
+			var plans []renamePlan
+			for _, r := range tt.renames {
+				from, to := filepath.Join(dir, r[0]), filepath.Join(dir, r[1])
+				plans = append(plans, renamePlan{from, to})
+				files[strings.ToLower(from)] = file{from, from}
+			}
+			for _, name := range tt.existing {
+				path := filepath.Join(dir, name)
+				files[strings.ToLower(path)] = file{path, path}
+			}
+
+			p := plan.New("img rename")
+			recordRenames(p, plans)
+			for i, s := range p.Steps {
+				if s.Op != plan.Move {
+					t.Fatalf("step %d is %s, want move", i+1, s)
+				}
+				f, ok := files[strings.ToLower(s.From)]
+				if !ok || f.name != s.From {
+					t.Fatalf("step %d (%s) moves a file that is not there", i+1, s)
+				}
+				if _, taken := files[strings.ToLower(s.Path)]; taken {
+					t.Fatalf("step %d (%s) lands on a file that has not moved yet", i+1, s)
+				}
+				delete(files, strings.ToLower(s.From))
+				files[strings.ToLower(s.Path)] = file{s.Path, f.origin}
+			}
+
+			if len(files) != len(plans)+len(tt.existing) {
+				t.Errorf("got %d files after the steps, want %d", len(files), len(plans)+len(tt.existing))
+			}
+			for _, r := range plans {
+				if f := files[strings.ToLower(r.to)]; f.name != r.to || f.origin != r.from {
+					t.Errorf("%s holds %q as %q, want %q", r.to, f.origin, f.name, r.from)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordRenamesApply(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	dir := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(name string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	record := func(renames ...string) *plan.Plan {
+		var plans []renamePlan
+		for i := 0; i+1 < len(renames); i += 2 {
+			plans = append(plans, renamePlan{filepath.Join(dir, renames[i]), filepath.Join(dir, renames[i+1])})
+		}
+		p := plan.New("img rename")
+		recordRenames(p, plans)
+		return p
+	}
+
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg", "e.jpg"} {
+		write(name, name)
+	}
+	p := record("a.jpg", "b.jpg", "b.jpg", "c.jpg", "c.jpg", "a.jpg", "d.jpg", "e.jpg", "e.jpg", "d.jpg")
+	if errs := p.Check(); len(errs) > 0 {
+		t.Fatalf("Check() = %v on unchanged files", errs)
+	}
+	if err := p.Apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"a.jpg": "c.jpg", "b.jpg": "a.jpg", "c.jpg": "b.jpg", "d.jpg": "e.jpg", "e.jpg": "d.jpg"} {
+		if got := read(name); got != want {
+			t.Errorf("%s holds %q, want %q", name, got, want)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 5 {
+		t.Errorf("got %d files after apply, want 5", len(entries))
+	}
+
+	// The parked moves are journaled too, so undo takes every name back
+	run, err := journal.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := run.Check(); len(errs) > 0 {
+		t.Fatalf("Check() = %v on the run just applied", errs)
+	}
+	if err := run.Undo(false); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg", "e.jpg"} {
+		if got := read(name); got != name {
+			t.Errorf("%s holds %q after undo, want %q", name, got, name)
+		}
+	}
+
+	// A file that changed after the plan was made is caught before apply
+	p = record("a.jpg", "b.jpg", "b.jpg", "a.jpg")
+	write("b.jpg", "edited")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "b.jpg"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if errs := p.Check(); len(errs) != 1 {
+		t.Errorf("Check() = %v, want one error for b.jpg", errs)
+	}
+
+	// A parked name taken after the plan was made stops apply, and the
+	// moves already done are undone
+	p = record("c.jpg", "x.jpg", "a.jpg", "b.jpg", "b.jpg", "a.jpg")
+	if len(p.Steps) != 4 || !strings.HasPrefix(filepath.Base(p.Steps[1].Path), ".jpgr-rename-") {
+		t.Fatalf("got steps %v, want c.jpg moved and then a.jpg parked", p.Steps)
+	}
+	write(filepath.Base(p.Steps[1].Path), "taken")
+	if err := p.Apply(context.Background()); err == nil {
+		t.Fatal("Apply() succeeded with the parked name taken")
+	}
+	for name, want := range map[string]string{"a.jpg": "a.jpg", "b.jpg": "edited", "c.jpg": "c.jpg"} {
+		if got := read(name); got != want {
+			t.Errorf("%s holds %q after the failed apply, want %q", name, got, want)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "x.jpg")); err == nil {
+		t.Error("x.jpg is left after the failed apply")
+	}
+}
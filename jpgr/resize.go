@@ -0,0 +1,9 @@
+package jpgr
+
+import "GoodnessucWorkflow/pkg/imaging"
+
+// resizeToFit and resize moved to pkg/imaging so other front ends share them
+var (
+	resizeToFit = imaging.ResizeToFit
+	resize      = imaging.Resize
+)
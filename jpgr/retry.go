@@ -0,0 +1,38 @@
+package jpgr
+
+import (
+	"errors"
+	"math/rand/v2"
+	"syscall"
+	"time"
+
+	"GoodnessucWorkflow/internal/logging"
+)
+
+// maxRetryDelay caps the doubling wait between attempts
+const maxRetryDelay = 30 * time.Second
+
+// isTransient reports whether err is an I/O error that may go away on its
+// own, as happens on network drives and with cloud-synced placeholders that
+// are still being downloaded
+func isTransient(err error) bool {
+	var errno syscall.Errno
+	return errors.As(err, &errno) && transientErrnos[errno]
+}
+
+// retryTransient runs fn until it succeeds, fails with an error that is not
+// transient, or has been retried retries times. The wait starts at delay and
+// doubles each time, with some jitter so parallel workers spread out
+func retryTransient(retries int, delay time.Duration, label string, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries || !isTransient(err) {
+			return err
+		}
+
+		wait := min(delay<<attempt, maxRetryDelay)
+		wait += time.Duration(rand.Int64N(int64(wait)/4 + 1))
+		logging.Warnf("Retrying %s in %s after transient error: %s", label, wait.Round(time.Millisecond), err)
+		time.Sleep(wait)
+	}
+}
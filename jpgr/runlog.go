@@ -0,0 +1,121 @@
+package jpgr
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// conversionRecord describes what convertFile did with one source. An empty
+// Output means the file was skipped
+type conversionRecord struct {
+	Output      string
+	SourceBytes int
+	OutputBytes int
+	Quality     int
+	Duration    time.Duration
+}
+
+// runLogEntry is one line of the -run-log file
+type runLogEntry struct {
+	Time        time.Time `json:"time"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination,omitempty"`
+	SourceBytes int       `json:"source_bytes,omitempty"`
+	OutputBytes int       `json:"output_bytes,omitempty"`
+	Quality     int       `json:"quality,omitempty"`
+	DurationMs  float64   `json:"duration_ms"`
+	Outcome     string    `json:"outcome"`
+	Stage       string    `json:"stage,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// runLogColumns is the CSV header, in the order of runLogEntry's fields
+var runLogColumns = []string{"time", "source", "destination", "source_bytes", "output_bytes", "quality", "duration_ms", "outcome", "stage", "error"}
+
+// runLog appends one entry per processed file to a CSV file, when its name
+// ends in .csv, or to an NDJSON file otherwise. Entries from earlier runs
+// are kept so months of conversions can be audited in one place
+type runLog struct {
+	mu   sync.Mutex
+	f    *os.File
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+func openRunLog(path string) (*runLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	l := &runLog{f: f}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		l.csv = csv.NewWriter(f)
+		if info.Size() == 0 {
+			l.csv.Write(runLogColumns)
+			l.csv.Flush()
+		}
+	} else {
+		l.json = json.NewEncoder(f)
+	}
+	return l, nil
+}
+
+// Record appends the outcome of converting source
+func (l *runLog) Record(source string, record conversionRecord, err error) error {
+	entry := runLogEntry{
+		Time:        time.Now().UTC(),
+		Source:      source,
+		Destination: record.Output,
+		SourceBytes: record.SourceBytes,
+		OutputBytes: record.OutputBytes,
+		Quality:     record.Quality,
+		DurationMs:  float64(record.Duration.Microseconds()) / 1000,
+		Outcome:     "converted",
+	}
+	var se *stageError
+	switch {
+	case errors.As(err, &se):
+		entry.Outcome, entry.Stage, entry.Error = "failed", se.stage, se.err.Error()
+	case err != nil:
+		entry.Outcome, entry.Error = "failed", err.Error()
+	case record.Output == "":
+		entry.Outcome = "skipped"
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.json != nil {
+		return l.json.Encode(entry)
+	}
+	l.csv.Write([]string{
+		entry.Time.Format(time.RFC3339Nano),
+		entry.Source,
+		entry.Destination,
+		strconv.Itoa(entry.SourceBytes),
+		strconv.Itoa(entry.OutputBytes),
+		strconv.Itoa(entry.Quality),
+		strconv.FormatFloat(entry.DurationMs, 'f', 3, 64),
+		entry.Outcome,
+		entry.Stage,
+		entry.Error,
+	})
+	l.csv.Flush()
+	return l.csv.Error()
+}
+
+func (l *runLog) Close() error {
+	return l.f.Close()
+}
@@ -0,0 +1,69 @@
+package jpgr
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/internal/storage"
+)
+
+// s3Uploader uploads converted files in the background while the conversion
+// pass carries on
+type s3Uploader struct {
+	client  *storage.S3
+	summary *runSummary
+	jobs    chan [2]string
+	wg      sync.WaitGroup
+}
+
+func newS3Uploader(client *storage.S3, concurrency int, summary *runSummary) *s3Uploader {
+	u := &s3Uploader{client: client, summary: summary, jobs: make(chan [2]string)}
+	for i := 0; i < concurrency; i++ {
+		u.wg.Add(1)
+		go u.work()
+	}
+	return u
+}
+
+func (u *s3Uploader) work() {
+	defer u.wg.Done()
+	for job := range u.jobs {
+		path, name := job[0], job[1]
+		data, err := os.ReadFile(path)
+		if err != nil {
+			u.summary.Fail(path, "upload", err)
+			continue
+		}
+
+		skipped, err := u.client.Upload(name, data)
+		if err != nil {
+			u.summary.Fail(path, "upload", err)
+			continue
+		}
+		if skipped {
+			logging.Infof("Upload skipped, bucket copy is identical: %s", u.client.URL(name))
+		} else {
+			output.Wrote(u.client.URL(name))
+			logging.Infof("Upload successful: %s", u.client.URL(name))
+		}
+	}
+}
+
+// Enqueue schedules path for upload; its key is its path relative to root
+// under the configured prefix
+func (u *s3Uploader) Enqueue(root, path string) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	u.jobs <- [2]string{path, filepath.ToSlash(rel)}
+}
+
+// Wait blocks until every queued upload has finished
+func (u *s3Uploader) Wait() {
+	close(u.jobs)
+	u.wg.Wait()
+}
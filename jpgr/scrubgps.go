@@ -0,0 +1,248 @@
+package jpgr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+)
+
+// gpsFinding is one row of the scrub-gps audit report: a file whose EXIF
+// data had a GPS block
+type gpsFinding struct {
+	Path     string       `json:"path"`
+	GPS      *gpsPosition `json:"gps,omitempty"`
+	Scrubbed bool         `json:"scrubbed"`
+}
+
+func runScrubGPS(args []string) {
+	fs := flag.NewFlagSet("scrub-gps", flag.ExitOnError)
+	logging.AddFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "only report which files contain GPS data, without changing them")
+	asJSON := fs.Bool("json", false, "print the report as a JSON array instead of a table")
+	addWalkFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img scrub-gps [flags] [file|directory ...]\n\nRemoves the GPS block from the EXIF data of JPEG and PNG files, keeping every\nother field, and reports which files had one. No copy of the original is\nkept, not even in the undo journal, so goodness undo cannot bring the\nlocation back.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		inputs = []string{defaultDirectory()}
+	}
+
+	ctx := cli.SignalContext()
+	summary := &runSummary{}
+	findings := []gpsFinding{}
+	for _, input := range inputs {
+		err := walkTree(input, func(path string, info os.FileInfo, err error) error {
+			if ctx.Err() != nil {
+				return filepath.SkipAll
+			}
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			if format := formatOf(path); format != "jpeg" && format != "png" {
+				if path == input {
+					summary.Fail(path, "read", errors.New("only JPEG and PNG files are supported"))
+				}
+				return nil
+			}
+
+			finding, err := scrubFile(path, info, *dryRun)
+			if err != nil {
+				summary.Fail(path, "scrub", err)
+				return nil
+			}
+			summary.Succeed(path)
+			if finding != nil {
+				findings = append(findings, *finding)
+			}
+			return nil
+		})
+		if err != nil {
+			logging.Fatalf("Error processing directory: %s", err)
+		}
+	}
+
+	if ctx.Err() != nil {
+		summary.Interrupt()
+	}
+	output.Set("findings", findings)
+	summaryOut := os.Stdout
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(findings); err != nil {
+			logging.Fatalf("Failed to write JSON: %s", err)
+		}
+		summaryOut = os.Stderr
+	} else {
+		printGPSFindings(os.Stdout, findings)
+	}
+	summary.Print(summaryOut)
+	output.Exit(summary.ExitCode())
+}
+
+// scrubFile removes the GPS block from one file unless dryRun is set. It
+// returns nil when the file has none. The file keeps its mode, modification
+// time and extended attributes
+func scrubFile(path string, info os.FileInfo, dryRun bool) (*gpsFinding, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tiff := exifBlock(data)
+	if tiff == nil {
+		return nil, nil
+	}
+	// The TIFF block aliases data, so scrubbing it in place edits the file
+	// contents without moving anything else
+	position, found, err := scrubGPS(tiff, dryRun)
+	if err != nil || !found {
+		return nil, err
+	}
+	finding := &gpsFinding{Path: path, GPS: position}
+	if dryRun {
+		return finding, nil
+	}
+
+	if formatOf(path) == "png" {
+		if data, err = rebuildPNG(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := fsutil.WriteFileAtomic(path+".jpgr-scrub", data, info.Mode().Perm()); err != nil {
+		return nil, err
+	}
+	// The GPS block is gone either way; Finder metadata is best effort
+	if err := copyXattrs(path, path+".jpgr-scrub", &xattrSelection{all: true}); err != nil {
+		logging.Warnf("Failed to copy extended attributes of %s: %s", path, err)
+	}
+	if err := os.Chtimes(path+".jpgr-scrub", info.ModTime(), info.ModTime()); err != nil {
+		os.Remove(path + ".jpgr-scrub")
+		return nil, err
+	}
+	if err := journal.Overwrite(path+".jpgr-scrub", path); err != nil {
+		os.Remove(path + ".jpgr-scrub")
+		return nil, err
+	}
+
+	finding.Scrubbed = true
+	output.Wrote(path)
+	logging.Infof("GPS data removed: %s", path)
+	return finding, nil
+}
+
+// scrubGPS reports the position recorded in a TIFF structure and whether it
+// has a GPS block at all. Unless dryRun is set it zeroes the block and its
+// values and drops the pointer to it from IFD0, all in place, so no offset
+// elsewhere in the structure changes
+func scrubGPS(tiff []byte, dryRun bool) (*gpsPosition, bool, error) {
+	x, err := parseExif(tiff)
+	if err != nil {
+		return nil, false, err
+	}
+	r, ifd0, err := newTIFFReader(tiff)
+	if err != nil {
+		return nil, false, err
+	}
+	root, err := r.readIFD(ifd0)
+	if err != nil {
+		return nil, false, err
+	}
+	pointer, ok := root[tagGPSIFD]
+	if !ok {
+		return nil, false, nil
+	}
+	if dryRun {
+		return x.GPS, true, nil
+	}
+
+	// Values stored out of line are slices of tiff, as is the IFD itself
+	if offset := r.uint(pointer); offset > 0 {
+		if gps, err := r.readIFD(uint32(offset)); err == nil {
+			for _, e := range gps {
+				clear(e.value)
+			}
+			n := int(r.order.Uint16(tiff[offset:]))
+			clear(tiff[offset:min(offset+2+12*n+4, len(tiff))])
+		}
+	}
+
+	// Shift the entries after the pointer, and the next IFD offset that
+	// follows them, up over it
+	off := int(ifd0)
+	n := int(r.order.Uint16(tiff[off:]))
+	end := off + 2 + 12*n + 4
+	if end > len(tiff) {
+		return nil, false, errors.New("truncated IFD")
+	}
+	for i := 0; i < n; i++ {
+		e := off + 2 + 12*i
+		if r.order.Uint16(tiff[e:]) == tagGPSIFD {
+			copy(tiff[e:], tiff[e+12:end])
+			clear(tiff[end-12 : end])
+			r.order.PutUint16(tiff[off:], uint16(n-1))
+			break
+		}
+	}
+	return x.GPS, true, nil
+}
+
+// rebuildPNG writes the chunks of a PNG again so their CRCs match contents
+// that were edited in place
+func rebuildPNG(data []byte) ([]byte, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteString(pngSignature)
+	for _, c := range chunks {
+		writePNGChunk(buf, c.kind, c.data)
+	}
+	return buf.Bytes(), nil
+}
+
+// printGPSFindings writes the audit report as a table
+func printGPSFindings(w io.Writer, findings []gpsFinding) {
+	if len(findings) == 0 {
+		fmt.Fprintln(w, "No files contain GPS data")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tLATITUDE\tLONGITUDE\tACTION")
+	for _, f := range findings {
+		lat, lon := "-", "-"
+		if f.GPS != nil {
+			lat, lon = fmt.Sprintf("%.6f", f.GPS.Latitude), fmt.Sprintf("%.6f", f.GPS.Longitude)
+		}
+		action := "found"
+		if f.Scrubbed {
+			action = "scrubbed"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", f.Path, lat, lon, action)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,214 @@
+package jpgr
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/pkg/errs"
+	"GoodnessucWorkflow/pkg/imaging"
+)
+
+// imageServer resizes and converts images under root on request, keeping
+// the rendered variants in cache. Files are opened through root, so
+// neither .. nor a symbolic link reaches outside it. renders bounds how
+// many images are decoded and encoded at once
+type imageServer struct {
+	root    *os.Root
+	cache   *variantCache
+	renders chan struct{}
+}
+
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	logging.AddFlags(fs)
+	addr := fs.String("addr", "127.0.0.1:8080", "address to listen on")
+	cacheDir := fs.String("cache", "", "directory for rendered variants (default <user cache dir>/jpgr)")
+	cacheSize := fs.String("cache-size", "1GB", "disk space the rendered variants may take before the least recently served are removed; 0 keeps none")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img serve [flags] [directory]\n\nServes /img/<path>?w=800&h=600&fmt=jpeg&q=80 from the directory. fmt is\njpeg, png, gif, or webp when cwebp is installed. Symbolic links are\nfollowed only when they are relative and stay inside the directory. Sizes\nabove the source's are served at the source's size.\n\n"))
+		fs.PrintDefaults()
+	}
+	cli.Parse(fs, args)
+
+	maxCache, err := fsutil.ParseByteSize(*cacheSize)
+	if err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid -cache-size value %q", *cacheSize)
+	}
+	root := directoryArg(fs)
+
+	if *cacheDir == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			logging.Fatalf("Failed to locate cache directory: %s", err)
+		}
+		*cacheDir = filepath.Join(userCache, "jpgr")
+	}
+	if err := os.MkdirAll(*cacheDir, 0755); err != nil {
+		logging.Fatalf("Failed to create cache directory: %s", err)
+	}
+
+	dir, err := os.OpenRoot(root)
+	if err != nil {
+		logging.Fatalf("Failed to open %s: %s", root, err)
+	}
+	cache, err := openVariantCache(*cacheDir, maxCache)
+	if err != nil {
+		logging.Fatalf("Failed to read the cache directory: %s", err)
+	}
+	srv := &imageServer{root: dir, cache: cache, renders: make(chan struct{}, runtime.NumCPU())}
+	http.Handle("/img/", srv)
+
+	logging.Infof("Serving %s on http://%s/img/", root, *addr)
+	logging.Fatalf("Failed to serve: %s", http.ListenAndServe(*addr, nil))
+}
+
+func (s *imageServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rel := filepath.FromSlash(strings.TrimPrefix(r.URL.Path, "/img/"))
+	if !filepath.IsLocal(rel) {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+	source, err := s.root.Open(rel)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	width, errW := queryInt(query.Get("w"))
+	height, errH := queryInt(query.Get("h"))
+	quality, errQ := queryInt(query.Get("q"))
+	if errW != nil || errH != nil || errQ != nil || quality > 100 {
+		http.Error(w, "w, h and q must be positive integers and q at most 100", http.StatusBadRequest)
+		return
+	}
+
+	format := strings.ToLower(query.Get("fmt"))
+	if format == "" {
+		format = formatOf(rel)
+	}
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	if format != "jpeg" && format != "png" && format != "gif" && format != "webp" {
+		http.Error(w, fmt.Sprintf("unsupported format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	// Images are never scaled up, so sizes at or above the source's all
+	// name the one full size variant
+	config, _, err := image.DecodeConfig(source)
+	if err != nil {
+		http.Error(w, "not an image", http.StatusUnsupportedMediaType)
+		return
+	}
+	if width >= config.Width {
+		width = 0
+	}
+	if height >= config.Height {
+		height = 0
+	}
+	if _, err := source.Seek(0, io.SeekStart); err != nil {
+		logging.Errorf("Failed to read %s: %s", rel, err)
+		http.Error(w, "failed to render image", http.StatusInternalServerError)
+		return
+	}
+
+	// The key covers the source's size and mtime so edits invalidate old variants
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%d|%d|%s|%d",
+		rel, info.Size(), info.ModTime().UnixNano(), width, height, format, quality)))
+	etag := hex.EncodeToString(key[:])
+	cachePath := s.cache.path(etag, format)
+
+	data, ok := s.cache.get(cachePath)
+	if !ok {
+		select {
+		case s.renders <- struct{}{}:
+		case <-r.Context().Done():
+			return
+		}
+		data, err = s.render(r.Context(), source, width, height, format, quality)
+		<-s.renders
+		if errors.Is(err, errs.ErrUnsupportedFormat) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		if err != nil {
+			logging.Errorf("Failed to render %s: %s", rel, err)
+			http.Error(w, "failed to render image", http.StatusInternalServerError)
+			return
+		}
+		if err := s.cache.put(cachePath, data); err != nil {
+			logging.Errorf("Failed to cache %s: %s", rel, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", mime.TypeByExtension("."+format))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("ETag", `"`+etag+`"`)
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(data))
+}
+
+// render decodes the source, fits it within width by height when either is
+// set, and encodes it in format
+func (s *imageServer) render(ctx context.Context, source io.Reader, width, height int, format string, quality int) ([]byte, error) {
+	img, _, err := image.Decode(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if width > 0 || height > 0 {
+		b := img.Bounds()
+		if width == 0 {
+			width = b.Dx()
+		}
+		if height == 0 {
+			height = b.Dy()
+		}
+		img = resizeToFit(img, width, height)
+	}
+
+	return imaging.Encode(ctx, img, imaging.Options{Format: format, Quality: quality})
+}
+
+// queryInt parses an optional non-negative integer query parameter
+func queryInt(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,154 @@
+package jpgr
+
+import (
+	"container/list"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/logging"
+)
+
+// variantCache keeps the variants img serve renders on disk, dropping the
+// least recently served once they take more than max bytes. Only files
+// named as variants are ever counted or removed, so a -cache folder shared
+// with other files is safe
+type variantCache struct {
+	dir string
+	max int64
+
+	mu sync.Mutex
+	// order holds *cachedVariant, least recently served first
+	order  *list.List
+	byPath map[string]*list.Element
+	total  int64
+}
+
+// cachedVariant is one file of the variant cache
+type cachedVariant struct {
+	path string
+	size int64
+}
+
+// openVariantCache indexes the variants already in dir, taking their
+// modification times as when they were last served
+func openVariantCache(dir string, max int64) (*variantCache, error) {
+	c := &variantCache{dir: dir, max: max, order: list.New(), byPath: make(map[string]*list.Element)}
+	type found struct {
+		cachedVariant
+		used time.Time
+	}
+	var variants []found
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isVariantPath(dir, path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		variants = append(variants, found{cachedVariant{path, info.Size()}, info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(variants, func(i, j int) bool { return variants[i].used.Before(variants[j].used) })
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, v := range variants {
+		c.byPath[v.path] = c.order.PushBack(&v.cachedVariant)
+		c.total += v.size
+	}
+	c.evict(nil)
+	return c, nil
+}
+
+// isVariantPath reports whether path is named as a variant under dir:
+// xx/<sha256 in hex>.<format>, where xx starts the hash
+func isVariantPath(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	sub, name := filepath.Split(rel)
+	hash, _, _ := strings.Cut(name, ".")
+	if _, err := hex.DecodeString(hash); err != nil || len(hash) != 64 {
+		return false
+	}
+	return sub == hash[:2]+string(filepath.Separator)
+}
+
+// path returns where the variant with key etag in format is kept
+func (c *variantCache) path(etag, format string) string {
+	return filepath.Join(c.dir, etag[:2], etag+"."+format)
+}
+
+// get reads a cached variant and marks it as just served
+func (c *variantCache) get(path string) ([]byte, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	if e, ok := c.byPath[path]; ok {
+		c.order.MoveToBack(e)
+	}
+	c.mu.Unlock()
+	// The time orders the cache again when the server restarts
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, true
+}
+
+// put keeps a rendered variant, dropping the least recently served ones
+// beyond the cache's size. With a size of 0 nothing is kept
+func (c *variantCache) put(path string, data []byte) error {
+	if c.max <= 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := fsutil.WriteFileAtomic(path, data, 0644); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.byPath[path]; ok {
+		c.total -= e.Value.(*cachedVariant).size
+		c.order.Remove(e)
+	}
+	v := &cachedVariant{path, int64(len(data))}
+	e := c.order.PushBack(v)
+	c.byPath[path], c.total = e, c.total+v.size
+	c.evict(e)
+	return nil
+}
+
+// evict removes the least recently served variants until the cache fits,
+// keeping keep
+func (c *variantCache) evict(keep *list.Element) {
+	for c.total > c.max {
+		e := c.order.Front()
+		if e == nil || e == keep {
+			return
+		}
+		v := e.Value.(*cachedVariant)
+		if err := os.Remove(v.path); err != nil && !os.IsNotExist(err) {
+			logging.Warnf("Failed to remove %s: %s", v.path, err)
+		}
+		c.order.Remove(e)
+		delete(c.byPath, v.path)
+		c.total -= v.size
+	}
+}
@@ -0,0 +1,52 @@
+package jpgr
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVariantCacheEvicts(t *testing.T) {
+	dir := t.TempDir()
+	other := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(other, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := openVariantCache(dir, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := c.path(strings.Repeat("a", 64), "png")
+	b := c.path(strings.Repeat("b", 64), "png")
+	d := c.path(strings.Repeat("d", 64), "png")
+	for _, p := range []string{a, b} {
+		if err := c.put(p, make([]byte, 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Serving a makes b the least recently served
+	if _, ok := c.get(a); !ok {
+		t.Fatal("a is not cached")
+	}
+	if err := c.put(d, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]bool{a: true, b: false, d: true, other: true} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s kept = %v, want %v", filepath.Base(path), err == nil, want)
+		}
+	}
+
+	// Reopened with less room, the oldest variant goes first
+	c, err = openVariantCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.total != 10 || len(c.byPath) != 1 {
+		t.Errorf("reopened cache holds %d bytes in %d variants, want 10 in 1", c.total, len(c.byPath))
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("a file that is not a variant was removed: %s", err)
+	}
+}
@@ -0,0 +1,165 @@
+package jpgr
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/imaging"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	sheetPadding     = 8
+	sheetLabelHeight = 16
+)
+
+// sheetOptions controls the contact sheet layout
+type sheetOptions struct {
+	columns int
+	rows    int
+	cell    int
+	labels  bool
+	format  string
+	outDir  string
+}
+
+func runSheet(args []string) {
+	opts := sheetOptions{}
+	fs := flag.NewFlagSet("sheet", flag.ExitOnError)
+	logging.AddFlags(fs)
+	fs.IntVar(&opts.columns, "columns", 5, "thumbnails per row")
+	fs.IntVar(&opts.rows, "rows", 6, "rows per page")
+	fs.IntVar(&opts.cell, "cell", 240, "size in pixels of the square each thumbnail is fitted into")
+	fs.BoolVar(&opts.labels, "labels", true, "print the file name under each thumbnail")
+	fs.StringVar(&opts.format, "format", "jpeg", "page format: jpeg or png")
+	fs.StringVar(&opts.outDir, "out", "", "directory for the pages (default <directory>/contact-sheets)")
+	addWalkFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img sheet [flags] [directory]\n"))
+		fs.PrintDefaults()
+	}
+	cli.Parse(fs, args)
+
+	directoryPath := directoryArg(fs)
+	if opts.outDir == "" {
+		opts.outDir = filepath.Join(directoryPath, "contact-sheets")
+	}
+	if opts.columns < 1 || opts.rows < 1 || opts.cell < 1 {
+		logging.Exitf(exitcode.Usage, "columns, rows and cell must be positive")
+	}
+
+	entries, err := os.ReadDir(directoryPath)
+	if err != nil {
+		logging.Fatalf("Error reading directory: %s", err)
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && !isJunk(entry.Name()) && isRaster(entry.Name()) {
+			paths = append(paths, filepath.Join(directoryPath, entry.Name()))
+		}
+	}
+	sort.Strings(paths)
+
+	if len(paths) == 0 {
+		logging.Exitf(exitcode.NoMatch, "No images found")
+	}
+
+	if err := os.MkdirAll(opts.outDir, 0755); err != nil {
+		logging.Fatalf("Failed to create output directory: %s", err)
+	}
+
+	perPage := opts.columns * opts.rows
+	for page := 0; page*perPage < len(paths); page++ {
+		end := min((page+1)*perPage, len(paths))
+		sheet := renderSheet(paths[page*perPage:end], opts)
+
+		ext := "." + opts.format
+		if opts.format == "jpeg" {
+			ext = ".jpg"
+		}
+		outputPath := filepath.Join(opts.outDir, fmt.Sprintf("sheet-%03d%s", page+1, ext))
+
+		buf := new(bytes.Buffer)
+		if err := imaging.EncodeTo(buf, sheet, opts.format, 0); err != nil {
+			logging.Errorf("Failed to encode contact sheet: %s", err)
+			continue
+		}
+		if err := writeFileAtomic(outputPath, buf.Bytes(), 0644); err != nil {
+			logging.Errorf("Failed to write contact sheet: %s", err)
+			continue
+		}
+
+		output.Wrote(outputPath)
+		logging.Infof("Contact sheet written: %s", outputPath)
+	}
+}
+
+// renderSheet lays out one page of thumbnails in a grid
+func renderSheet(paths []string, opts sheetOptions) image.Image {
+	labelHeight := 0
+	if opts.labels {
+		labelHeight = sheetLabelHeight
+	}
+
+	cellW := opts.cell + sheetPadding
+	cellH := opts.cell + labelHeight + sheetPadding
+	rows := (len(paths) + opts.columns - 1) / opts.columns
+	columns := min(opts.columns, len(paths))
+
+	sheet := image.NewRGBA(image.Rect(0, 0, columns*cellW+sheetPadding, rows*cellH+sheetPadding))
+	draw.Draw(sheet, sheet.Bounds(), image.White, image.Point{}, draw.Src)
+
+	for i, path := range paths {
+		x := sheetPadding + (i%opts.columns)*cellW
+		y := sheetPadding + (i/opts.columns)*cellH
+
+		img, err := loadImage(path)
+		if err != nil {
+			logging.Errorf("Failed to read image file: %s", err)
+			continue
+		}
+
+		thumb := resizeToFit(img, opts.cell, opts.cell)
+		tb := thumb.Bounds()
+		offset := image.Pt(x+(opts.cell-tb.Dx())/2, y+(opts.cell-tb.Dy())/2)
+		draw.Draw(sheet, tb.Sub(tb.Min).Add(offset), thumb, tb.Min, draw.Over)
+
+		if opts.labels {
+			drawLabel(sheet, filepath.Base(path), x, y+opts.cell+labelHeight-4, opts.cell)
+		}
+	}
+
+	return sheet
+}
+
+// drawLabel writes text at x,y, truncating it to fit within width pixels
+func drawLabel(dst draw.Image, text string, x, y, width int) {
+	face := basicfont.Face7x13
+	maxChars := width / face.Advance
+	if len(text) > maxChars && maxChars > 3 {
+		text = text[:maxChars-3] + "..."
+	}
+
+	d := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(color.Gray{Y: 64}),
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(text)
+}
@@ -0,0 +1,60 @@
+package jpgr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"time"
+)
+
+// sidecar describes a converted image so site generators can read its
+// dimensions without decoding it
+type sidecar struct {
+	Source       string    `json:"source"`
+	Output       string    `json:"output"`
+	Format       string    `json:"format"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	Quality      int       `json:"quality,omitempty"`
+	Bytes        int       `json:"bytes"`
+	SourceBytes  int       `json:"source_bytes"`
+	SHA256       string    `json:"sha256"`
+	SourceSHA256 string    `json:"source_sha256"`
+	DurationMs   float64   `json:"duration_ms"`
+	ConvertedAt  time.Time `json:"converted_at"`
+}
+
+// writeSidecar writes <outputPath>.json next to the converted image
+func writeSidecar(sourcePath string, sourceBytes []byte, outputPath string, outputBytes []byte, quality int, elapsed time.Duration) error {
+	config, format, err := image.DecodeConfig(bytes.NewReader(outputBytes))
+	if err != nil {
+		return err
+	}
+
+	meta := sidecar{
+		Source:       sourcePath,
+		Output:       outputPath,
+		Format:       format,
+		Width:        config.Width,
+		Height:       config.Height,
+		Bytes:        len(outputBytes),
+		SourceBytes:  len(sourceBytes),
+		Quality:      quality,
+		SHA256:       sha256Hex(outputBytes),
+		SourceSHA256: sha256Hex(sourceBytes),
+		DurationMs:   float64(elapsed.Microseconds()) / 1000,
+		ConvertedAt:  time.Now().UTC(),
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(outputPath+".json", append(data, '\n'), 0644)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
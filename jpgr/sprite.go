@@ -0,0 +1,160 @@
+package jpgr
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/imaging"
+)
+
+// spriteFrame is where one image sits in the packed sheet
+type spriteFrame struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+type spriteImage struct {
+	name  string
+	image image.Image
+	frame spriteFrame
+}
+
+func runSprite(args []string) {
+	fs := flag.NewFlagSet("sprite", flag.ExitOnError)
+	logging.AddFlags(fs)
+	out := fs.String("out", "", "sheet path; the .json and .css maps are written next to it (default <directory>/sprite.png)")
+	padding := fs.Int("padding", 2, "transparent pixels between images")
+	maxWidth := fs.Int("max-width", 1024, "widest the sheet may grow before starting a new row")
+	prefix := fs.String("prefix", "sprite", "CSS class prefix")
+	addWalkFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img sprite [flags] [directory]\n"))
+		fs.PrintDefaults()
+	}
+	cli.Parse(fs, args)
+
+	directoryPath := directoryArg(fs)
+	if *out == "" {
+		*out = filepath.Join(directoryPath, "sprite.png")
+	}
+	outAbs, _ := filepath.Abs(*out)
+
+	entries, err := os.ReadDir(directoryPath)
+	if err != nil {
+		logging.Fatalf("Error reading directory: %s", err)
+	}
+
+	var sprites []*spriteImage
+	names := make(map[string]bool)
+	for _, entry := range entries {
+		path := filepath.Join(directoryPath, entry.Name())
+		if abs, _ := filepath.Abs(path); entry.IsDir() || isJunk(entry.Name()) || !isRaster(path) || abs == outAbs {
+			continue
+		}
+		img, err := loadImage(path)
+		if err != nil {
+			logging.Errorf("Failed to read image file: %s", err)
+			continue
+		}
+		base := slugify(strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())))
+		name := base
+		for n := 2; names[name]; n++ {
+			name = fmt.Sprintf("%s-%d", base, n)
+		}
+		names[name] = true
+		sprites = append(sprites, &spriteImage{name: name, image: img})
+	}
+	if len(sprites) == 0 {
+		logging.Exitf(exitcode.NoMatch, "No images found")
+	}
+
+	sheet := packSprites(sprites, *padding, *maxWidth)
+
+	buf := new(bytes.Buffer)
+	if err := imaging.EncodeTo(buf, sheet, "png", 0); err != nil {
+		logging.Fatalf("Failed to encode sprite sheet: %s", err)
+	}
+	if err := writeFileAtomic(*out, buf.Bytes(), 0644); err != nil {
+		logging.Fatalf("Failed to write sprite sheet: %s", err)
+	}
+
+	frames := make(map[string]spriteFrame, len(sprites))
+	for _, s := range sprites {
+		frames[s.name] = s.frame
+	}
+	data, err := json.MarshalIndent(frames, "", "  ")
+	if err != nil {
+		logging.Fatalf("Failed to build sprite map: %s", err)
+	}
+	stem := strings.TrimSuffix(*out, filepath.Ext(*out))
+	if err := writeFileAtomic(stem+".json", append(data, '\n'), 0644); err != nil {
+		logging.Fatalf("Failed to write sprite map: %s", err)
+	}
+	if err := writeFileAtomic(stem+".css", spriteCSS(sprites, filepath.Base(*out), *prefix), 0644); err != nil {
+		logging.Fatalf("Failed to write sprite map: %s", err)
+	}
+
+	output.Wrote(*out)
+	logging.Infof("Sprite sheet written: %s (%d images, %dx%d)", *out, len(sprites), sheet.Bounds().Dx(), sheet.Bounds().Dy())
+}
+
+// packSprites places the images on shelves, tallest first, so each row wastes
+// as little height as possible, and draws them onto one sheet
+func packSprites(sprites []*spriteImage, padding, maxWidth int) *image.NRGBA {
+	sort.SliceStable(sprites, func(i, j int) bool {
+		hi, hj := sprites[i].image.Bounds().Dy(), sprites[j].image.Bounds().Dy()
+		if hi != hj {
+			return hi > hj
+		}
+		return sprites[i].name < sprites[j].name
+	})
+
+	x, y, shelf, width := 0, 0, 0, 0
+	for _, s := range sprites {
+		b := s.image.Bounds()
+		if x > 0 && x+b.Dx() > maxWidth {
+			x, y, shelf = 0, y+shelf+padding, 0
+		}
+		s.frame = spriteFrame{X: x, Y: y, Width: b.Dx(), Height: b.Dy()}
+		x += b.Dx() + padding
+		shelf = max(shelf, b.Dy())
+		width = max(width, x-padding)
+	}
+
+	sheet := image.NewNRGBA(image.Rect(0, 0, width, y+shelf))
+	for _, s := range sprites {
+		f := s.frame
+		draw.Draw(sheet, image.Rect(f.X, f.Y, f.X+f.Width, f.Y+f.Height), s.image, s.image.Bounds().Min, draw.Src)
+	}
+	return sheet
+}
+
+// spriteCSS writes one class per image that shows its slice of the sheet
+func spriteCSS(sprites []*spriteImage, sheetName, prefix string) []byte {
+	sorted := append([]*spriteImage(nil), sprites...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].name < sorted[j].name })
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, ".%s {\n  background-image: url(%q);\n  background-repeat: no-repeat;\n  display: inline-block;\n}\n", prefix, sheetName)
+	for _, s := range sorted {
+		f := s.frame
+		fmt.Fprintf(&b, "\n.%s-%s {\n  background-position: %dpx %dpx;\n  width: %dpx;\n  height: %dpx;\n}\n",
+			prefix, s.name, -f.X, -f.Y, f.Width, f.Height)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,227 @@
+package jpgr
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"GoodnessucWorkflow/internal/cache"
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/imaging"
+)
+
+// srcsetEntry describes the responsive variants generated for one source image
+type srcsetEntry struct {
+	Src      string          `json:"src"`
+	Srcset   string          `json:"srcset"`
+	Width    int             `json:"width"`
+	Height   int             `json:"height"`
+	Variants []srcsetVariant `json:"variants"`
+}
+
+type srcsetVariant struct {
+	Path  string `json:"path"`
+	Width int    `json:"width"`
+}
+
+func runSrcset(args []string) {
+	fs := flag.NewFlagSet("srcset", flag.ExitOnError)
+	logging.AddFlags(fs)
+	widths := fs.String("widths", "480,768,1200,1600", "comma separated variant widths in pixels")
+	format := fs.String("format", "jpeg", "variant format: jpeg or png")
+	quality := fs.Int("quality", 0, "JPEG quality for the variants (default encoder quality)")
+	out := fs.String("out", "", "directory for the variants and mapping (default <directory>/srcset)")
+	emit := fs.String("emit", "json", "mapping format: json or html")
+	useCache := cache.AddFlag(fs)
+	addWalkFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img srcset [flags] [directory]\n"))
+		fs.PrintDefaults()
+	}
+	cli.Parse(fs, args)
+
+	directoryPath := directoryArg(fs)
+	if *out == "" {
+		*out = filepath.Join(directoryPath, "srcset")
+	}
+	if *emit != "json" && *emit != "html" {
+		logging.Exitf(exitcode.Usage, "Invalid -emit %q", *emit)
+	}
+
+	var targetWidths []int
+	for _, s := range strings.Split(*widths, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 {
+			logging.Exitf(exitcode.Usage, "Invalid width %q", s)
+		}
+		targetWidths = append(targetWidths, n)
+	}
+	sort.Ints(targetWidths)
+
+	extension := "." + *format
+	if *format == "jpeg" {
+		extension = ".jpg"
+	}
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		logging.Fatalf("Failed to create output directory: %s", err)
+	}
+	outAbs, _ := filepath.Abs(*out)
+	inputCache, err := cache.Open(*useCache)
+	if err != nil {
+		logging.Warnf("Not using the cache: %s", err)
+	}
+	cacheFlags := cache.Flags(fs, "out", "emit", "cache")
+
+	ctx := cli.SignalContext()
+	mapping := make(map[string]srcsetEntry)
+	err = walkTree(directoryPath, func(path string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return filepath.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if abs, _ := filepath.Abs(path); info.IsDir() && abs == outAbs {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !isRaster(path) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(directoryPath, path)
+		if err != nil {
+			return err
+		}
+		// Variants are named after the path relative to the directory
+		key, hit := inputCache.Lookup(path, cache.Options("img srcset", cacheFlags, outAbs, rel))
+		var entry srcsetEntry
+		if hit != nil && hit.Decode(&entry) == nil {
+			mapping[filepath.ToSlash(rel)] = entry
+			output.Processed(path, "cached", "", nil)
+			logging.Debugf("Skipping %s: unchanged since its variants were written", rel)
+			return nil
+		}
+		entry, err = buildSrcset(path, rel, *out, targetWidths, *format, extension, *quality)
+		if err != nil {
+			logging.Errorf("Failed to build variants for %s: %s", path, err)
+			return nil
+		}
+		mapping[filepath.ToSlash(rel)] = entry
+		var variants []string
+		for _, v := range entry.Variants {
+			variants = append(variants, filepath.Join(*out, filepath.FromSlash(v.Path)))
+		}
+		inputCache.Store(key, variants, entry)
+		output.Processed(path, "ok", "", nil)
+		logging.Infof("Variants written: %s (%d)", rel, len(entry.Variants))
+		return nil
+	})
+	if err != nil {
+		logging.Fatalf("Error processing directory: %s", err)
+	}
+	if err := inputCache.Save(); err != nil {
+		logging.Errorf("Failed to write the cache: %s", err)
+	}
+	// A mapping of part of the images would replace a whole one; the
+	// variants already written are cached for the next run
+	if ctx.Err() != nil {
+		logging.Warnf("Interrupted; the mapping is written once a run gets through every image")
+		output.Exit(exitcode.Interrupted)
+	}
+
+	var data []byte
+	mappingPath := filepath.Join(*out, "srcset."+*emit)
+	if *emit == "json" {
+		data, err = json.MarshalIndent(mapping, "", "  ")
+		data = append(data, '\n')
+	} else {
+		data = srcsetHTML(mapping)
+	}
+	if err != nil {
+		logging.Fatalf("Failed to build mapping: %s", err)
+	}
+	if err := writeFileAtomic(mappingPath, data, 0644); err != nil {
+		logging.Fatalf("Failed to write mapping: %s", err)
+	}
+	output.Wrote(mappingPath)
+	logging.Infof("Mapping written: %s", mappingPath)
+}
+
+// buildSrcset writes the variants of one image as <stem>-<width>w<ext>. Widths
+// wider than the source are dropped and the source width is used instead, so
+// images are never upscaled
+func buildSrcset(path, rel, outDir string, widths []int, format, extension string, quality int) (srcsetEntry, error) {
+	img, err := loadImage(path)
+	if err != nil {
+		return srcsetEntry{}, err
+	}
+	b := img.Bounds()
+
+	var chosen []int
+	for _, w := range widths {
+		if w < b.Dx() {
+			chosen = append(chosen, w)
+		}
+	}
+	chosen = append(chosen, min(b.Dx(), widths[len(widths)-1]))
+
+	stem := strings.TrimSuffix(filepath.ToSlash(rel), filepath.Ext(rel))
+	entry := srcsetEntry{}
+	var candidates []string
+	for _, w := range chosen {
+		h := max(b.Dy()*w/b.Dx(), 1)
+		variant := img
+		if w != b.Dx() {
+			variant = resize(img, w, h)
+		}
+
+		name := fmt.Sprintf("%s-%dw%s", stem, w, extension)
+		variantPath := filepath.Join(outDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(variantPath), 0755); err != nil {
+			return srcsetEntry{}, err
+		}
+
+		buf := new(bytes.Buffer)
+		if err := imaging.EncodeTo(buf, variant, format, quality); err != nil {
+			return srcsetEntry{}, err
+		}
+		if err := writeFileAtomic(variantPath, buf.Bytes(), 0644); err != nil {
+			return srcsetEntry{}, err
+		}
+
+		entry.Variants = append(entry.Variants, srcsetVariant{Path: name, Width: w})
+		candidates = append(candidates, fmt.Sprintf("%s %dw", name, w))
+		entry.Src, entry.Width, entry.Height = name, w, h
+	}
+	entry.Srcset = strings.Join(candidates, ", ")
+	return entry, nil
+}
+
+// srcsetHTML renders one <img> tag per source image
+func srcsetHTML(mapping map[string]srcsetEntry) []byte {
+	keys := make([]string, 0, len(mapping))
+	for k := range mapping {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	for _, k := range keys {
+		e := mapping[k]
+		fmt.Fprintf(&b, "<!-- %s -->\n<img src=\"%s\" srcset=\"%s\" sizes=\"100vw\" width=\"%d\" height=\"%d\" alt=\"\" loading=\"lazy\">\n",
+			html.EscapeString(k), html.EscapeString(e.Src), html.EscapeString(e.Srcset), e.Width, e.Height)
+	}
+	return b.Bytes()
+}
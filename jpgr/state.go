@@ -0,0 +1,103 @@
+package jpgr
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"GoodnessucWorkflow/internal/fsutil"
+)
+
+// stateFileName is where batch progress is kept unless -state says otherwise
+const stateFileName = ".jpgr-state.json"
+
+// runState records which files a batch run planned to convert and what
+// happened to each, so an interrupted run can pick up where it stopped
+type runState struct {
+	Inputs    []string          `json:"inputs"`
+	StartedAt time.Time         `json:"started_at"`
+	Planned   []string          `json:"planned"`
+	Roots     map[string]string `json:"roots"`
+	Completed map[string]bool   `json:"completed"`
+	Failed    map[string]string `json:"failed"`
+
+	mu   sync.Mutex
+	path string
+}
+
+func newRunState(statePath string, inputs, planned []string, roots map[string]string) *runState {
+	return &runState{
+		Inputs:    inputs,
+		StartedAt: time.Now().UTC(),
+		Planned:   planned,
+		Roots:     roots,
+		Completed: make(map[string]bool),
+		Failed:    make(map[string]string),
+		path:      statePath,
+	}
+}
+
+func loadRunState(statePath string) (*runState, error) {
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &runState{path: statePath}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Completed == nil {
+		state.Completed = make(map[string]bool)
+	}
+	if state.Failed == nil {
+		state.Failed = make(map[string]string)
+	}
+	return state, nil
+}
+
+// Pending returns the planned files that have neither completed nor failed
+func (s *runState) Pending() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pending []string
+	for _, path := range s.Planned {
+		if !s.Completed[path] && s.Failed[path] == "" {
+			pending = append(pending, path)
+		}
+	}
+	return pending
+}
+
+func (s *runState) MarkCompleted(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Completed[path] = true
+}
+
+func (s *runState) MarkFailed(path string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Failed[path] = err.Error()
+}
+
+// Save writes the state atomically so a crash mid-write never leaves a
+// truncated state file behind
+func (s *runState) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return fsutil.WriteFileAtomic(s.path, data, 0644)
+}
+
+// Remove deletes the state file once a run has nothing left to resume
+func (s *runState) Remove() error {
+	return os.Remove(s.path)
+}
@@ -0,0 +1,184 @@
+package jpgr
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/errs"
+)
+
+// stageError records which step of processing a file failed
+type stageError struct {
+	stage string
+	err   error
+}
+
+func (e *stageError) Error() string {
+	return e.stage + ": " + e.err.Error()
+}
+
+func (e *stageError) Unwrap() error {
+	return e.err
+}
+
+// fileFailure is one row of the end-of-run summary
+type fileFailure struct {
+	path      string
+	stage     string
+	err       error
+	transient bool
+}
+
+// runSummary collects outcomes from the conversion pass and the background
+// uploaders so they can be reported together once the run is over
+type runSummary struct {
+	mu        sync.Mutex
+	succeeded int
+	// cached counts the successes skipped as unchanged since an earlier run
+	cached   int
+	failures []fileFailure
+	// interrupted is set when a signal stopped the run before every file
+	// was processed
+	interrupted bool
+}
+
+// Succeed records that path was processed
+func (s *runSummary) Succeed(path string) {
+	output.Processed(path, "ok", "", nil)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.succeeded++
+}
+
+// Cached records that path was skipped because an earlier run already
+// made its outputs, which counts as a success
+func (s *runSummary) Cached(path string) {
+	output.Processed(path, "cached", "", nil)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.succeeded++
+	s.cached++
+}
+
+// Fail records a failure; errors carrying a stage override the given one
+func (s *runSummary) Fail(path, stage string, err error) {
+	var se *stageError
+	if errors.As(err, &se) {
+		stage, err = se.stage, se.err
+	}
+
+	output.Processed(path, "failed", stage, err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, fileFailure{path: path, stage: stage, err: err, transient: isTransient(err)})
+}
+
+// Snapshot returns the success count and a copy of the failures so far
+func (s *runSummary) Snapshot() (int, []fileFailure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.succeeded, append([]fileFailure(nil), s.failures...)
+}
+
+// Interrupt records that the run stopped early on a signal, so the totals
+// cover only part of the files and the command exits as interrupted
+func (s *runSummary) Interrupt() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interrupted = true
+}
+
+func (s *runSummary) Failed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.failures)
+}
+
+// ExitCode is the status a command exits with once the run is over
+func (s *runSummary) ExitCode() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.interrupted:
+		return exitcode.Interrupted
+	case len(s.failures) > 0:
+		return exitcode.Failures
+	case s.succeeded == 0:
+		return exitcode.NoMatch
+	}
+	return exitcode.OK
+}
+
+// Print writes the totals and, when anything failed, a table of failures
+func (s *runSummary) Print(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transient := 0
+	for _, f := range s.failures {
+		if f.transient {
+			transient++
+		}
+	}
+
+	fmt.Fprint(w, i18n.Sprintf("\n%d succeeded", s.succeeded))
+	if s.cached > 0 {
+		fmt.Fprint(w, i18n.Sprintf(" (%d unchanged)", s.cached))
+	}
+	fmt.Fprint(w, i18n.Sprintf(", %d failed", len(s.failures)))
+	if transient > 0 {
+		fmt.Fprint(w, i18n.Sprintf(" (%d transient, may succeed if run again)", transient))
+	}
+	fmt.Fprintln(w)
+	if s.interrupted {
+		fmt.Fprint(w, i18n.T("Interrupted: files not reached are left as they were\n"))
+	}
+	if len(s.failures) == 0 {
+		return
+	}
+	if causes := s.causes(); causes != "" {
+		fmt.Fprint(w, i18n.Sprintf("Failures by cause: %s\n", causes))
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, i18n.T("\nFILE\tSTAGE\tKIND\tERROR"))
+	for _, f := range s.failures {
+		kind := i18n.T("permanent")
+		if f.transient {
+			kind = i18n.T("transient")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", f.path, f.stage, kind, f.err)
+	}
+	tw.Flush()
+}
+
+// causes counts the failures by errs.Kind, in the order each cause first
+// failed, as "2 decode-failed, 1 other"; it is empty when no failure has a
+// known cause
+func (s *runSummary) causes() string {
+	var order []string
+	counts := make(map[string]int)
+	for _, f := range s.failures {
+		cause := cmp.Or(errs.Kind(f.err), "other")
+		if counts[cause] == 0 {
+			order = append(order, cause)
+		}
+		counts[cause]++
+	}
+	if counts["other"] == len(s.failures) {
+		return ""
+	}
+	parts := make([]string, len(order))
+	for i, cause := range order {
+		parts[i] = fmt.Sprintf("%d %s", counts[cause], cause)
+	}
+	return strings.Join(parts, ", ")
+}
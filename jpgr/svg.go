@@ -0,0 +1,16 @@
+package jpgr
+
+import "GoodnessucWorkflow/pkg/imaging"
+
+// convertSVG rasterizes an SVG and encodes it as opts.output. JPEGs and GIFs
+// are drawn on a white background, since they cannot keep partial transparency
+func convertSVG(data []byte, opts convertOptions) ([]byte, error) {
+	img, err := imaging.RasterizeSVG(data, opts.svgScale, opts.svgWidth, opts.svgHeight)
+	if err != nil {
+		return nil, err
+	}
+	if imaging.KeepsAlpha(opts.output) {
+		return opts.encode(img)
+	}
+	return opts.encode(imaging.FlattenWhite(img))
+}
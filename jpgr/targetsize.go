@@ -0,0 +1,69 @@
+package jpgr
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+)
+
+// minTargetQuality is the lowest quality the size search tries before it
+// gives up or, with -target-resize, starts shrinking the image
+const minTargetQuality = 10
+
+// encodeUnderSize binary-searches the highest JPEG quality whose output is at
+// most limit bytes. When even minTargetQuality is too big and shrink is set,
+// the image is scaled down by the size overshoot and searched again
+func encodeUnderSize(img image.Image, limit int64, shrink bool) ([]byte, error) {
+	for {
+		best, smallest, err := searchQuality(img, limit)
+		if err != nil || best != nil {
+			return best, err
+		}
+
+		b := img.Bounds()
+		if !shrink || b.Dx() <= 16 || b.Dy() <= 16 {
+			return nil, fmt.Errorf("smallest encoding is %s, over the %s target", formatBytes(int64(smallest)), formatBytes(limit))
+		}
+
+		// File size grows roughly with pixel count, so scale both sides by
+		// the square root of the overshoot and leave a little headroom
+		scale := math.Sqrt(float64(limit)/float64(smallest)) * 0.95
+		img = resize(img, max(int(float64(b.Dx())*scale), 1), max(int(float64(b.Dy())*scale), 1))
+	}
+}
+
+// searchQuality returns the best encoding under limit, or nil with the size
+// of the minTargetQuality encoding when nothing fits
+func searchQuality(img image.Image, limit int64) ([]byte, int, error) {
+	encode := func(q int) ([]byte, error) {
+		buf := new(bytes.Buffer)
+		err := jpeg.Encode(buf, img, &jpeg.Options{Quality: q})
+		return buf.Bytes(), err
+	}
+
+	smallest, err := encode(minTargetQuality)
+	if err != nil {
+		return nil, 0, err
+	}
+	if int64(len(smallest)) > limit {
+		return nil, len(smallest), nil
+	}
+
+	best := smallest
+	lo, hi := minTargetQuality+1, 100
+	for lo <= hi {
+		q := (lo + hi) / 2
+		data, err := encode(q)
+		if err != nil {
+			return nil, 0, err
+		}
+		if int64(len(data)) <= limit {
+			best, lo = data, q+1
+		} else {
+			hi = q - 1
+		}
+	}
+	return best, len(best), nil
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package jpgr
+
+import "syscall"
+
+// transientErrnos are the errors worth retrying. EDEADLK is what macOS
+// returns while an iCloud Drive placeholder is being downloaded
+var transientErrnos = map[syscall.Errno]bool{
+	syscall.EAGAIN:    true,
+	syscall.EBUSY:     true,
+	syscall.EDEADLK:   true,
+	syscall.EINTR:     true,
+	syscall.EIO:       true,
+	syscall.ENOTCONN:  true,
+	syscall.ESTALE:    true,
+	syscall.ETIMEDOUT: true,
+}
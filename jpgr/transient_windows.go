@@ -0,0 +1,27 @@
+//go:build windows
+
+package jpgr
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// transientErrnos are the errors worth retrying: files locked by another
+// process, dropped network shares and OneDrive placeholders still syncing
+var transientErrnos = map[syscall.Errno]bool{
+	windows.ERROR_SHARING_VIOLATION:                 true,
+	windows.ERROR_LOCK_VIOLATION:                    true,
+	windows.ERROR_BAD_NETPATH:                       true,
+	windows.ERROR_NETNAME_DELETED:                   true,
+	windows.ERROR_SEM_TIMEOUT:                       true,
+	windows.ERROR_CLOUD_FILE_PROVIDER_NOT_RUNNING:   true,
+	windows.ERROR_CLOUD_FILE_NETWORK_UNAVAILABLE:    true,
+	windows.ERROR_CLOUD_FILE_IN_USE:                 true,
+	windows.ERROR_CLOUD_FILE_REQUEST_ABORTED:        true,
+	windows.ERROR_CLOUD_FILE_PROPERTY_LOCK_CONFLICT: true,
+	windows.ERROR_CLOUD_FILE_REQUEST_CANCELED:       true,
+	windows.ERROR_CLOUD_FILE_PROVIDER_TERMINATED:    true,
+	windows.ERROR_CLOUD_FILE_REQUEST_TIMEOUT:        true,
+}
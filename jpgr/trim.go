@@ -0,0 +1,46 @@
+package jpgr
+
+import (
+	"image"
+)
+
+// trimBorders crops away the uniform border around an image. The border colour
+// is taken from the top-left pixel; pixels whose channels all lie within
+// tolerance of it count as border. padding pixels of border are kept on each side
+func trimBorders(tolerance, padding int) Transform {
+	return func(img image.Image) image.Image {
+		src := toNRGBA(img)
+		w, h := src.Rect.Dx(), src.Rect.Dy()
+		if w == 0 || h == 0 {
+			return img
+		}
+
+		border := src.Pix[:4]
+		isBorder := func(x, y int) bool {
+			p := src.Pix[src.PixOffset(x, y):]
+			for c := 0; c < 4; c++ {
+				d := int(p[c]) - int(border[c])
+				if d > tolerance || -d > tolerance {
+					return false
+				}
+			}
+			return true
+		}
+
+		minX, minY, maxX, maxY := w, h, -1, -1
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				if !isBorder(x, y) {
+					minX, maxX = min(minX, x), max(maxX, x)
+					minY, maxY = min(minY, y), max(maxY, y)
+				}
+			}
+		}
+		if maxX < 0 {
+			return img
+		}
+
+		content := image.Rect(minX, minY, maxX+1, maxY+1).Inset(-padding).Intersect(src.Rect)
+		return toNRGBA(src.SubImage(content))
+	}
+}
@@ -0,0 +1,193 @@
+package jpgr
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"GoodnessucWorkflow/internal/cache"
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+)
+
+// findFFmpeg locates ffmpeg from JPGR_FFMPEG or PATH. Video features shell out
+// to it rather than bundling an encoder
+func findFFmpeg() (string, error) {
+	if path := os.Getenv("JPGR_FFMPEG"); path != "" {
+		return path, nil
+	}
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return "", errors.New("ffmpeg not found on PATH; install it (brew install ffmpeg, apt install ffmpeg, winget install ffmpeg) or set JPGR_FFMPEG")
+	}
+	return path, nil
+}
+
+// runFFmpeg runs ffmpeg quietly and returns its error output on failure
+func runFFmpeg(ffmpeg string, args ...string) error {
+	_, err := ffmpegOutput(ffmpeg, args...)
+	return err
+}
+
+// ffmpegOutput runs ffmpeg quietly and returns what it wrote to stdout
+func ffmpegOutput(ffmpeg string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(ffmpeg, append([]string{"-hide_banner", "-loglevel", "error", "-y"}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("ffmpeg: %s", msg)
+		}
+		return nil, fmt.Errorf("ffmpeg: %s", err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// isAnimatedGIF reports whether a GIF stream has more than one graphic
+// control block, which every animation frame carries
+func isAnimatedGIF(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("GIF8")) && bytes.Count(data, []byte{0x21, 0xf9, 0x04}) > 1
+}
+
+func runVideo(args []string) {
+	fs := flag.NewFlagSet("video", flag.ExitOnError)
+	logging.AddFlags(fs)
+	format := fs.String("format", "mp4", "video format: mp4 (H.264) or webm (VP9)")
+	crf := fs.Int("crf", 0, "constant rate factor; lower is better quality (default 23 for mp4, 35 for webm)")
+	deleteOriginal := fs.Bool("delete-original", false, "remove each GIF once its video is written")
+	useCache := cache.AddFlag(fs)
+	addWalkFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness img video [flags] [file|directory ...]\n\nConverts animated GIFs to MP4 or WebM with ffmpeg.\n\n"))
+		fs.PrintDefaults()
+	}
+	cli.Parse(fs, args)
+
+	if *format != "mp4" && *format != "webm" {
+		logging.Exitf(exitcode.Usage, "Invalid -format %q", *format)
+	}
+	if *crf == 0 {
+		*crf = map[string]int{"mp4": 23, "webm": 35}[*format]
+	}
+	ffmpeg, err := findFFmpeg()
+	if err != nil {
+		logging.Fatalf("%s", err)
+	}
+
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		inputs = []string{defaultDirectory()}
+	}
+
+	inputCache, err := cache.Open(*useCache)
+	if err != nil {
+		logging.Warnf("Not using the cache: %s", err)
+	}
+	options := cache.Options("img video", cache.Flags(fs, "cache"))
+
+	ctx := cli.SignalContext()
+	summary := &runSummary{}
+	for _, input := range inputs {
+		err := walkTree(input, func(path string, info os.FileInfo, err error) error {
+			if ctx.Err() != nil {
+				return filepath.SkipAll
+			}
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || formatOf(path) != "gif" {
+				return nil
+			}
+			key, hit := inputCache.Lookup(path, options)
+			if hit != nil {
+				// Static GIFs are stored without a video and stay uncounted
+				if len(hit.Outputs) > 0 {
+					summary.Cached(path)
+				}
+				return nil
+			}
+			converted, err := gifToVideo(ffmpeg, path, *format, *crf, *deleteOriginal)
+			switch {
+			case err != nil && ctx.Err() != nil:
+				// ffmpeg got the same SIGINT; its partial output was removed
+				logging.Infof("Interrupted, left as it was: %s", path)
+			case err != nil:
+				summary.Fail(path, "convert", err)
+			case converted:
+				summary.Succeed(path)
+				if fileExists(path) {
+					inputCache.Store(key, []string{strings.TrimSuffix(path, filepath.Ext(path)) + "." + *format}, nil)
+				}
+			default:
+				inputCache.Store(key, nil, nil)
+			}
+			return nil
+		})
+		if err != nil {
+			logging.Fatalf("Error processing directory: %s", err)
+		}
+	}
+	if err := inputCache.Save(); err != nil {
+		logging.Errorf("Failed to write the cache: %s", err)
+	}
+
+	if ctx.Err() != nil {
+		summary.Interrupt()
+	}
+	summary.Print(os.Stdout)
+	output.Exit(summary.ExitCode())
+}
+
+// gifToVideo encodes one animated GIF next to itself and reports whether it
+// did. Static GIFs are left alone
+func gifToVideo(ffmpeg, path, format string, crf int, deleteOriginal bool) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, &stageError{"read", err}
+	}
+	if !isAnimatedGIF(data) {
+		return false, nil
+	}
+
+	// yuv420p needs even dimensions, and is what browsers and phones can play
+	args := []string{"-i", fsutil.LongPath(path), "-an", "-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2", "-pix_fmt", "yuv420p", "-crf", strconv.Itoa(crf)}
+	if format == "mp4" {
+		args = append(args, "-c:v", "libx264", "-movflags", "+faststart", "-f", "mp4")
+	} else {
+		args = append(args, "-c:v", "libvpx-vp9", "-b:v", "0", "-f", "webm")
+	}
+
+	outputPath := strings.TrimSuffix(path, filepath.Ext(path)) + "." + format
+	tmp := filepath.Join(filepath.Dir(outputPath), ".jpgr-"+filepath.Base(outputPath))
+	if err := runFFmpeg(ffmpeg, append(args, fsutil.LongPath(tmp))...); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	if err := journal.Replace(tmp, outputPath); err != nil {
+		os.Remove(tmp)
+		return false, &stageError{"write", err}
+	}
+
+	if info, err := os.Stat(outputPath); err == nil {
+		output.Wrote(outputPath)
+		logging.Infof("Video conversion successful: %s (%s -> %s)", outputPath, formatBytes(int64(len(data))), formatBytes(info.Size()))
+	}
+	if deleteOriginal {
+		if err := journal.Remove(path); err != nil {
+			return false, &stageError{"delete original", err}
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,72 @@
+package jpgr
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// includeHidden turns off the junk filter in walkTree and isJunk; commands
+// that scan directories expose it as -include-hidden
+var includeHidden bool
+
+// junkNames are operating system files and folders that never hold user images
+var junkNames = map[string]bool{
+	"$recycle.bin":              true,
+	"system volume information": true,
+	"thumbs.db":                 true,
+	"desktop.ini":               true,
+	"icon\r":                    true,
+}
+
+func addWalkFlags(fs *flag.FlagSet) {
+	fs.Var(extensionFlag{}, "ext", "extensions to treat as a format, as FORMAT=.ext[,.ext...]; repeat for each format")
+	fs.BoolVar(&includeHidden, "include-hidden", false, "also process dotfiles, trash folders, resource forks and temporary files")
+}
+
+// isJunk reports whether a file or directory name is hidden, a macOS resource
+// fork, trash, or a temporary file left behind by an editor or download
+func isJunk(name string) bool {
+	if includeHidden {
+		return false
+	}
+
+	lower := strings.ToLower(name)
+	return strings.HasPrefix(name, ".") ||
+		strings.HasPrefix(name, "~$") ||
+		strings.HasSuffix(name, "~") ||
+		strings.HasSuffix(lower, ".tmp") ||
+		strings.HasSuffix(lower, ".crdownload") ||
+		strings.HasSuffix(lower, ".part") ||
+		junkNames[lower]
+}
+
+// walkTree is filepath.Walk that skips junk files and anything matched by a
+// .jpgrignore file in the tree, and does not descend into skipped
+// directories. The root itself is always walked
+func walkTree(root string, fn filepath.WalkFunc) error {
+	// rules holds the ignore patterns in effect inside each walked directory
+	rules := make(map[string][]ignoreRule)
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && path != root {
+			inherited := rules[filepath.Dir(path)]
+			if isJunk(info.Name()) || ignored(inherited, path, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		if err == nil && info.IsDir() {
+			own, err := readIgnoreFile(path)
+			if err != nil {
+				return err
+			}
+			inherited := rules[filepath.Dir(path)]
+			rules[path] = append(inherited[:len(inherited):len(inherited)], own...)
+		}
+		return fn(path, info, err)
+	})
+}
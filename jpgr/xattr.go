@@ -0,0 +1,68 @@
+package jpgr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// xattrGroups maps the names accepted by -xattrs to the macOS extended
+// attributes they cover
+var xattrGroups = map[string][]string{
+	"tags":       {"com.apple.metadata:_kMDItemUserTags"},
+	"comments":   {"com.apple.metadata:kMDItemFinderComment"},
+	"wherefroms": {"com.apple.metadata:kMDItemWhereFroms"},
+	"quarantine": {"com.apple.quarantine"},
+}
+
+// xattrSelection is the -xattrs flag: which extended attributes are copied
+// from a source to its converted output. It only has an effect on macOS
+type xattrSelection struct {
+	all   bool
+	names map[string]bool
+	value string
+}
+
+func (s *xattrSelection) String() string {
+	if s == nil {
+		return ""
+	}
+	return s.value
+}
+
+func (s *xattrSelection) Set(value string) error {
+	all, names := false, make(map[string]bool)
+	for _, group := range strings.Split(value, ",") {
+		group = strings.ToLower(strings.TrimSpace(group))
+		switch group {
+		case "", "none":
+		case "all":
+			all = true
+		default:
+			attrs, ok := xattrGroups[group]
+			if !ok {
+				groups := make([]string, 0, len(xattrGroups))
+				for name := range xattrGroups {
+					groups = append(groups, name)
+				}
+				sort.Strings(groups)
+				return fmt.Errorf("unknown attribute group %q: expected %s, all or none", group, strings.Join(groups, ", "))
+			}
+			for _, attr := range attrs {
+				names[attr] = true
+			}
+		}
+	}
+	s.all, s.names, s.value = all, names, value
+	return nil
+}
+
+// includes reports whether the attribute called name is copied
+func (s *xattrSelection) includes(name string) bool {
+	return s.all || s.names[name]
+}
+
+// empty reports whether nothing is copied
+func (s *xattrSelection) empty() bool {
+	return s == nil || (!s.all && len(s.names) == 0)
+}
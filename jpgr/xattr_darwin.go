@@ -0,0 +1,56 @@
+//go:build darwin
+
+package jpgr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyXattrs copies the selected extended attributes of src onto dst, such
+// as Finder tags and comments, which a newly written file does not have
+func copyXattrs(src, dst string, selection *xattrSelection) error {
+	if selection.empty() {
+		return nil
+	}
+
+	size, err := unix.Listxattr(src, nil)
+	if err != nil || size == 0 {
+		return err
+	}
+	list := make([]byte, size)
+	if size, err = unix.Listxattr(src, list); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range bytes.Split(list[:size], []byte{0}) {
+		if len(name) == 0 || !selection.includes(string(name)) {
+			continue
+		}
+		value, err := getXattr(src, string(name))
+		if err == nil {
+			err = unix.Setxattr(dst, string(name), value, 0)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	size, err := unix.Getxattr(path, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, size)
+	size, err = unix.Getxattr(path, name, value)
+	if err != nil {
+		return nil, err
+	}
+	return value[:size], nil
+}
@@ -0,0 +1,9 @@
+//go:build !darwin
+
+package jpgr
+
+// copyXattrs does nothing outside macOS, where the Finder metadata it copies
+// does not exist
+func copyXattrs(src, dst string, selection *xattrSelection) error {
+	return nil
+}
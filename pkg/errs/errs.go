@@ -0,0 +1,92 @@
+// Package errs defines the failures goodness tells apart, so programs using
+// the pkg libraries can branch on them with errors.Is and errors.As rather
+// than on message text, and the commands can group failed files by cause.
+// Errors are wrapped with the file they happened to as an *fs.PathError,
+// and with the service that refused a request as a *RemoteError
+package errs
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+)
+
+var (
+	// ErrUnsupportedFormat is an input or output format goodness cannot
+	// handle, or cannot on this machine for lack of a program such as cwebp
+	ErrUnsupportedFormat = errors.New("unsupported format")
+	// ErrDecodeFailed is an input in a known format that could not be read,
+	// such as a truncated or corrupt image
+	ErrDecodeFailed = errors.New("decode failed")
+	// ErrWouldOverwrite is a write refused because another file is at its
+	// path
+	ErrWouldOverwrite = errors.New("would overwrite an existing file")
+	// ErrRemoteRejected is a request a remote service answered with an error
+	ErrRemoteRejected = errors.New("rejected by the remote service")
+)
+
+// kinds are the errors Kind names, as -output json and the run summaries
+// report them
+var kinds = []struct {
+	err  error
+	name string
+}{
+	{ErrUnsupportedFormat, "unsupported-format"},
+	{ErrDecodeFailed, "decode-failed"},
+	{ErrWouldOverwrite, "would-overwrite"},
+	{ErrRemoteRejected, "remote-rejected"},
+}
+
+// Kind names the error of this package err is: unsupported-format,
+// decode-failed, would-overwrite or remote-rejected, or "" for any other
+func Kind(err error) string {
+	for _, k := range kinds {
+		if errors.Is(err, k.err) {
+			return k.name
+		}
+	}
+	return ""
+}
+
+// Decode classifies an error from decoding an image: data in no known
+// format is ErrUnsupportedFormat and any other failure ErrDecodeFailed
+func Decode(err error) error {
+	if err == nil || Kind(err) != "" {
+		return err
+	}
+	if errors.Is(err, image.ErrFormat) {
+		return fmt.Errorf("%w: %w", ErrUnsupportedFormat, err)
+	}
+	return fmt.Errorf("%w: %w", ErrDecodeFailed, err)
+}
+
+// Exists is the error of an op, such as write or move, refused because
+// path is taken
+func Exists(op, path string) error {
+	return &fs.PathError{Op: op, Path: path, Err: ErrWouldOverwrite}
+}
+
+// RemoteError is a request a remote service answered with an error status.
+// errors.Is reports it as ErrRemoteRejected
+type RemoteError struct {
+	// Service is what was called, such as a webhook or a request line
+	Service string
+	// Status is the response status, such as 403 Forbidden
+	Status     string
+	StatusCode int
+	// Message is what the service said about it, if anything
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	s := e.Service + " returned " + e.Status
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	return s
+}
+
+func (e *RemoteError) Is(target error) bool {
+	return target == ErrRemoteRejected
+}
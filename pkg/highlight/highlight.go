@@ -0,0 +1,384 @@
+// Package highlight splits source code into tokens for syntax coloring:
+// keywords, types and constants, strings, numbers, comments and function
+// names. It is a small lexer per language rather than a parser, which is
+// enough to color snippets for images and slides
+package highlight
+
+import (
+	"html"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kind is what a token is, which picks its color
+type Kind int
+
+const (
+	// Plain is anything else: names, operators and spaces
+	Plain Kind = iota
+	Keyword
+	// Type is a built-in type, constant or value such as true
+	Type
+	String
+	Number
+	Comment
+	// Function is a name followed by an opening parenthesis
+	Function
+)
+
+// Token is a run of source text of one kind
+type Token struct {
+	Kind Kind
+	Text string
+}
+
+// language is how one language writes comments, strings and words
+type language struct {
+	lineComments  []string
+	blockComments [][2]string
+	// quotes are the string delimiters; those in multiline may span lines
+	quotes    string
+	multiline string
+	// raw are delimiters inside which a backslash escapes nothing
+	raw          string
+	tripleQuotes bool
+	// identChars are the characters other than letters, digits and _ that
+	// may appear in a word
+	identChars string
+	ignoreCase bool
+	keywords   map[string]bool
+	types      map[string]bool
+}
+
+func words(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var cLike = language{
+	lineComments:  []string{"//"},
+	blockComments: [][2]string{{"/*", "*/"}},
+	quotes:        `"'`,
+}
+
+var languages = map[string]language{
+	"go": {
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "\"'`",
+		multiline:     "`",
+		raw:           "`",
+		keywords:      words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var"),
+		types:         words("any bool byte comparable complex64 complex128 error float32 float64 int int8 int16 int32 int64 rune string uint uint8 uint16 uint32 uint64 uintptr true false nil iota"),
+	},
+	"javascript": {
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "\"'`",
+		multiline:     "`",
+		identChars:    "$",
+		keywords:      words("async await break case catch class const continue debugger default delete do else export extends finally for from function if import in instanceof let new of return static super switch this throw try typeof var void while with yield"),
+		types:         words("true false null undefined NaN Infinity Array Object String Number Boolean Promise Map Set Symbol Error JSON Math console"),
+	},
+	"typescript": {
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "\"'`",
+		multiline:     "`",
+		identChars:    "$",
+		keywords:      words("abstract as async await break case catch class const continue declare default delete do else enum export extends finally for from function if implements import in instanceof interface keyof let namespace new of private protected public readonly return satisfies static super switch this throw try type typeof var void while yield"),
+		types:         words("true false null undefined any unknown never void string number boolean bigint object symbol Array Record Partial Promise Map Set Error"),
+	},
+	"python": {
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		tripleQuotes: true,
+		keywords:     words("and as assert async await break class continue def del elif else except finally for from global if import in is lambda match case nonlocal not or pass raise return try while with yield"),
+		types:        words("True False None self int float str bool list dict set tuple bytes object type Exception print len range"),
+	},
+	"rust": {
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        `"`,
+		multiline:     `"`,
+		keywords:      words("as async await break const continue crate dyn else enum extern fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait type unsafe use where while"),
+		types:         words("true false bool char str String i8 i16 i32 i64 i128 isize u8 u16 u32 u64 u128 usize f32 f64 Option Some None Result Ok Err Vec Box"),
+	},
+	"java": withWords(cLike,
+		"abstract assert break case catch class continue default do else enum extends final finally for if implements import instanceof interface native new package private protected public record return static super switch synchronized this throw throws try var void volatile while yield",
+		"true false null boolean byte char double float int long short String Object Integer List Map"),
+	"c": withWords(cLike,
+		"auto break case const continue default do else enum extern for goto if inline register restrict return sizeof static struct switch typedef union volatile while #include #define #ifdef #ifndef #endif #if #else #pragma",
+		"char double float int long short signed unsigned void size_t bool true false NULL"),
+	"cpp": withWords(cLike,
+		"alignas auto break case catch class const constexpr continue default delete do else enum explicit export extern for friend goto if inline mutable namespace new noexcept operator private protected public return sizeof static struct switch template this throw try typedef typename union using virtual volatile while #include #define #ifdef #ifndef #endif #if #else #pragma",
+		"bool char double float int long short signed unsigned void size_t true false nullptr std string vector"),
+	"ruby": {
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		multiline:    `"'`,
+		identChars:   "?!@",
+		keywords:     words("alias and begin break case class def do else elsif end ensure for if in module next not or redo require rescue retry return self super then unless until when while yield attr_accessor attr_reader puts"),
+		types:        words("true false nil"),
+	},
+	"shell": {
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		multiline:    `"'`,
+		raw:          "'",
+		identChars:   "-",
+		keywords:     words("if then else elif fi for while until do done case esac in function return exit export local readonly set unset source alias echo cd sudo"),
+		types:        words("true false"),
+	},
+	"sql": {
+		lineComments:  []string{"--"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        `'"`,
+		ignoreCase:    true,
+		keywords:      words("add all alter and as asc begin between by case check column commit constraint create default delete desc distinct drop else end exists foreign from full group having if in index inner insert into is join key left like limit not null offset on or order outer primary references returning right rollback select set table then union unique update using values view when where with"),
+		types:         words("int integer bigint smallint serial text varchar char boolean date timestamp timestamptz numeric decimal real json jsonb uuid true false"),
+	},
+	"yaml": {
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		identChars:   "-",
+		types:        words("true false null yes no on off"),
+	},
+	"json": {
+		quotes: `"`,
+		types:  words("true false null"),
+	},
+	"css": {
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        `"'`,
+		identChars:    "-@!",
+		keywords:      words("@media @import @keyframes @font-face @supports !important"),
+		types:         words("px em rem vh vw auto none inherit initial block flex grid inline absolute relative fixed"),
+	},
+}
+
+// withWords is lang with its keywords and types
+func withWords(lang language, keywords, types string) language {
+	lang.keywords, lang.types = words(keywords), words(types)
+	if strings.Contains(keywords, "#") {
+		lang.identChars = "#"
+	}
+	return lang
+}
+
+// aliases map file extensions and other names to the languages
+var aliases = map[string]string{
+	"golang": "go",
+	"js":     "javascript", "jsx": "javascript", "mjs": "javascript", "cjs": "javascript", "node": "javascript",
+	"ts": "typescript", "tsx": "typescript",
+	"py": "python", "python3": "python",
+	"rs":  "rust",
+	"h":   "c",
+	"c++": "cpp", "cc": "cpp", "cxx": "cpp", "hpp": "cpp",
+	"rb": "ruby",
+	"sh": "shell", "bash": "shell", "zsh": "shell", "console": "shell", "shellscript": "shell",
+	"yml":  "yaml",
+	"scss": "css",
+}
+
+// Lookup returns the language called name or written in files with the
+// extension name, as go, py or .py, reporting false for one it lacks
+func Lookup(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimPrefix(name, "."))
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	_, ok := languages[name]
+	return name, ok
+}
+
+// Languages lists the languages by name, in order
+func Languages() []string {
+	names := make([]string, 0, len(languages))
+	for name := range languages {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Tokens splits code in the language called lang into tokens. Code in a
+// language Lookup does not know is one plain token
+func Tokens(lang, code string) []Token {
+	name, ok := Lookup(lang)
+	if !ok {
+		if code == "" {
+			return nil
+		}
+		return []Token{{Plain, code}}
+	}
+	l := languages[name]
+	var tokens []Token
+	emit := func(kind Kind, text string) {
+		if n := len(tokens); n > 0 && tokens[n-1].Kind == kind {
+			tokens[n-1].Text += text
+			return
+		}
+		tokens = append(tokens, Token{kind, text})
+	}
+
+	for i := 0; i < len(code); {
+		rest := code[i:]
+		if n := l.comment(rest); n > 0 {
+			emit(Comment, rest[:n])
+			i += n
+			continue
+		}
+		if n := l.str(rest); n > 0 {
+			emit(String, rest[:n])
+			i += n
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(rest)
+		switch {
+		case unicode.IsDigit(r) && !wordBefore(code[:i], l):
+			n := numberLength(rest)
+			emit(Number, rest[:n])
+			i += n
+		case l.isIdent(r, true):
+			n := size
+			for n < len(rest) {
+				r, size := utf8.DecodeRuneInString(rest[n:])
+				if !l.isIdent(r, false) {
+					break
+				}
+				n += size
+			}
+			word := rest[:n]
+			key := word
+			if l.ignoreCase {
+				key = strings.ToLower(word)
+			}
+			switch {
+			case l.keywords[key]:
+				emit(Keyword, word)
+			case l.types[key]:
+				emit(Type, word)
+			case strings.HasPrefix(strings.TrimLeft(rest[n:], " "), "("):
+				emit(Function, word)
+			default:
+				emit(Plain, word)
+			}
+			i += n
+		default:
+			emit(Plain, rest[:size])
+			i += size
+		}
+	}
+	return tokens
+}
+
+// comment returns the length of the comment s starts with, or 0
+func (l language) comment(s string) int {
+	for _, prefix := range l.lineComments {
+		if strings.HasPrefix(s, prefix) {
+			if end := strings.IndexByte(s, '\n'); end >= 0 {
+				return end
+			}
+			return len(s)
+		}
+	}
+	for _, pair := range l.blockComments {
+		if strings.HasPrefix(s, pair[0]) {
+			if end := strings.Index(s[len(pair[0]):], pair[1]); end >= 0 {
+				return len(pair[0]) + end + len(pair[1])
+			}
+			return len(s)
+		}
+	}
+	return 0
+}
+
+// str returns the length of the string literal s starts with, or 0. A
+// string left open ends with its line, or with s for a multiline one
+func (l language) str(s string) int {
+	if s == "" || !strings.ContainsRune(l.quotes, rune(s[0])) {
+		return 0
+	}
+	q := s[0]
+	if l.tripleQuotes && len(s) >= 3 && s[1] == q && s[2] == q {
+		if end := strings.Index(s[3:], s[:3]); end >= 0 {
+			return 3 + end + 3
+		}
+		return len(s)
+	}
+	raw := strings.IndexByte(l.raw, q) >= 0
+	multiline := strings.IndexByte(l.multiline, q) >= 0
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && !raw:
+			i++
+		case s[i] == q:
+			return i + 1
+		case s[i] == '\n' && !multiline:
+			return i
+		}
+	}
+	return len(s)
+}
+
+// isIdent reports whether r may start, or continue, a word
+func (l language) isIdent(r rune, start bool) bool {
+	return unicode.IsLetter(r) || r == '_' || !start && unicode.IsDigit(r) || strings.ContainsRune(l.identChars, r)
+}
+
+// wordBefore reports whether s ends in the middle of a word, so a digit
+// after it belongs to the word
+func wordBefore(s string, l language) bool {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return s != "" && l.isIdent(r, false)
+}
+
+// numberLength is the length of the number s starts with, in any of the
+// usual notations: 42, 3.14, 1e-9, 0xff, 1_000
+func numberLength(s string) int {
+	n := 0
+	for n < len(s) {
+		c := s[n]
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c == '.' && n+1 < len(s) && s[n+1] >= '0' && s[n+1] <= '9':
+		case (c == '-' || c == '+') && n > 0 && (s[n-1] == 'e' || s[n-1] == 'E') && !strings.HasPrefix(strings.ToLower(s), "0x"):
+		default:
+			return n
+		}
+		n++
+	}
+	return n
+}
+
+// classes are the CSS classes HTML gives each kind of token but Plain
+var classes = map[Kind]string{
+	Keyword:  "hl-keyword",
+	Type:     "hl-type",
+	String:   "hl-string",
+	Number:   "hl-number",
+	Comment:  "hl-comment",
+	Function: "hl-function",
+}
+
+// HTML returns code escaped for HTML, with each token but plain text in a
+// span of the class hl-keyword, hl-type, hl-string, hl-number, hl-comment
+// or hl-function, for a stylesheet to color
+func HTML(lang, code string) string {
+	var b strings.Builder
+	for _, t := range Tokens(lang, code) {
+		text := html.EscapeString(t.Text)
+		if class, ok := classes[t.Kind]; ok {
+			b.WriteString(`<span class="` + class + `">` + text + `</span>`)
+			continue
+		}
+		b.WriteString(text)
+	}
+	return b.String()
+}
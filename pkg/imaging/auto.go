@@ -0,0 +1,120 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"os/exec"
+)
+
+// MaxPaletteColors is the most colors an image can have and still be stored
+// losslessly as a paletted PNG
+const MaxPaletteColors = 256
+
+// samplePixels bounds how many pixels Analyze looks at, so large photos are
+// judged from an evenly spread sample
+const samplePixels = 1 << 20
+
+// Traits summarizes what Choose looks at in an image
+type Traits struct {
+	Transparent bool
+	// Colors is the number of distinct colors, counted up to one more than
+	// MaxPaletteColors
+	Colors int
+	// Flat is the share of sampled pixels that equal their right neighbour:
+	// near one for screenshots and drawings, low for photos
+	Flat float64
+}
+
+// Encoding is the output Choose picks for one image
+type Encoding struct {
+	Format  string
+	Quality int
+	Palette bool
+	Reason  string
+}
+
+// Analyze samples img and measures its traits
+func Analyze(img image.Image) Traits {
+	b := img.Bounds()
+	step := max(1, int(math.Sqrt(float64(b.Dx())*float64(b.Dy())/samplePixels)))
+
+	traits := Traits{}
+	colors := make(map[color.NRGBA]bool)
+	sampled, same := 0, 0
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A != 0xff {
+				traits.Transparent = true
+			}
+			if len(colors) <= MaxPaletteColors {
+				colors[c] = true
+			}
+			if x+1 < b.Max.X {
+				sampled++
+				if color.NRGBAModel.Convert(img.At(x+1, y)) == c {
+					same++
+				}
+			}
+		}
+	}
+
+	traits.Colors = len(colors)
+	if sampled > 0 {
+		traits.Flat = float64(same) / float64(sampled)
+	}
+	return traits
+}
+
+// Choose picks the format and quality that suit an image's content.
+// Few colors or large flat areas mean a screenshot, diagram or logo, which
+// PNG keeps sharp and small; anything else is photographic and goes to JPEG,
+// or to WebP when it needs its transparency and cwebp is available
+func Choose(traits Traits) Encoding {
+	switch {
+	case traits.Colors <= MaxPaletteColors:
+		return Encoding{Format: "png", Palette: true, Reason: "few colors"}
+	case traits.Flat >= 0.6:
+		return Encoding{Format: "png", Reason: "flat color areas"}
+	case traits.Transparent:
+		if _, err := exec.LookPath("cwebp"); err == nil {
+			return Encoding{Format: "webp", Quality: 85, Reason: "photographic with transparency"}
+		}
+		return Encoding{Format: "png", Reason: "photographic with transparency"}
+	case traits.Flat >= 0.3:
+		// Mixed content such as a screenshot of a photo has edges that
+		// blur at lower qualities
+		return Encoding{Format: "jpeg", Quality: 92, Reason: "mixed content"}
+	}
+	return Encoding{Format: "jpeg", Quality: 85, Reason: "photographic"}
+}
+
+// ToPaletted returns img as a paletted image when it has at most
+// MaxPaletteColors colors, and nil otherwise. It loses nothing, unlike
+// quantizing to a palette
+func ToPaletted(img image.Image) *image.Paletted {
+	b := img.Bounds()
+	index := make(map[color.NRGBA]uint8)
+	var palette color.Palette
+	indices := make([]uint8, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			i, ok := index[c]
+			if !ok {
+				if len(palette) == MaxPaletteColors {
+					return nil
+				}
+				i = uint8(len(palette))
+				index[c] = i
+				palette = append(palette, c)
+			}
+			indices = append(indices, i)
+		}
+	}
+
+	paletted := image.NewPaletted(b, palette)
+	copy(paletted.Pix, indices)
+	return paletted
+}
@@ -0,0 +1,181 @@
+// Package imaging is the conversion core of goodness img: decoding,
+// rasterizing SVGs, picking an output format and encoding. It works on
+// readers and bytes rather than files and flags, so other programs convert
+// images exactly the way the command line does
+package imaging
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+
+	"GoodnessucWorkflow/pkg/errs"
+)
+
+// Transform modifies a decoded image before it is encoded
+type Transform func(image.Image) image.Image
+
+// Options controls how an image is converted
+type Options struct {
+	// Format is jpeg, png, gif, webp, or auto to let Choose pick one from
+	// the image content
+	Format string
+	// Quality is the JPEG or WebP quality; zero keeps the encoder default
+	Quality int
+	// Palette stores PNGs with at most MaxPaletteColors colors as paletted
+	// images, which Choose asks for when it picks PNG for few colors
+	Palette bool
+	// Transforms run in order on the decoded image
+	Transforms []Transform
+
+	// SVGScale multiplies an SVG's intrinsic size; SVGWidth and SVGHeight
+	// fit it to a size instead. See RasterizeSVG
+	SVGScale  float64
+	SVGWidth  int
+	SVGHeight int
+}
+
+// Result is an encoded image with the format and quality used for it
+type Result struct {
+	Data    []byte
+	Format  string
+	Quality int
+}
+
+// Convert reads one PNG, JPEG, GIF, WebP or SVG image from src and encodes
+// it as opts.Format. SVGs are drawn on white unless the format keeps
+// transparency. The whole image is encoded before Convert returns, so a
+// failure never leaves half an image for the caller to write
+func Convert(ctx context.Context, src io.Reader, opts Options) (Result, error) {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return Result{}, err
+	}
+	img, svg, err := Decode(data, opts)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if opts.Format == "auto" {
+		choice := Choose(Analyze(img))
+		opts.Format, opts.Quality, opts.Palette = choice.Format, cmp.Or(opts.Quality, choice.Quality), choice.Palette
+	}
+	if svg && !KeepsAlpha(opts.Format) {
+		img = FlattenWhite(img)
+	}
+
+	out, err := Encode(ctx, img, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Data: out, Format: opts.Format, Quality: opts.Quality}, nil
+}
+
+// Decode decodes an image in any registered format, or rasterizes it when
+// it is an SVG document, which svg then reports. Failures are
+// errs.ErrUnsupportedFormat or errs.ErrDecodeFailed
+func Decode(data []byte, opts Options) (img image.Image, svg bool, err error) {
+	img, _, err = image.Decode(bytes.NewReader(data))
+	if err == nil || !bytes.Contains(data, []byte("<svg")) {
+		return img, false, errs.Decode(err)
+	}
+	rgba, err := RasterizeSVG(data, svgScale(opts), opts.SVGWidth, opts.SVGHeight)
+	if err != nil {
+		return nil, false, err
+	}
+	return rgba, true, nil
+}
+
+// KeepsAlpha reports whether format can store partial transparency
+func KeepsAlpha(format string) bool {
+	return format == "png" || format == "webp"
+}
+
+// Encode applies opts.Transforms to img and encodes it as opts.Format,
+// which must be a concrete format rather than auto
+func Encode(ctx context.Context, img image.Image, opts Options) ([]byte, error) {
+	for _, transform := range opts.Transforms {
+		img = transform(img)
+	}
+	if opts.Palette && opts.Format == "png" {
+		if paletted := ToPaletted(img); paletted != nil {
+			img = paletted
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	var err error
+	if opts.Format == "webp" {
+		err = EncodeWebP(ctx, buf, img, opts.Quality)
+	} else {
+		err = EncodeTo(buf, img, opts.Format, opts.Quality)
+	}
+	return buf.Bytes(), err
+}
+
+// EncodeTo writes img to w as jpeg, png or gif. quality only applies to
+// JPEG, where zero means the encoder default
+func EncodeTo(w io.Writer, img image.Image, format string, quality int) error {
+	switch format {
+	case "jpeg", "jpg":
+		var opts *jpeg.Options
+		if quality > 0 {
+			opts = &jpeg.Options{Quality: quality}
+		}
+		return jpeg.Encode(w, img, opts)
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	default:
+		return fmt.Errorf("%w: %q", errs.ErrUnsupportedFormat, format)
+	}
+}
+
+// EncodeWebP hands img to cwebp as a PNG and copies the result to w, since
+// Go has no WebP encoder. quality zero keeps cwebp's default
+func EncodeWebP(ctx context.Context, w io.Writer, img image.Image, quality int) error {
+	cwebp, err := exec.LookPath("cwebp")
+	if err != nil {
+		return fmt.Errorf("%w: webp output needs cwebp on PATH", errs.ErrUnsupportedFormat)
+	}
+
+	tmp, err := os.CreateTemp("", "goodness-*.png")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = png.Encode(tmp, img)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	var stderr strings.Builder
+	args := []string{"-quiet", tmp.Name(), "-o", "-"}
+	if quality > 0 {
+		args = append([]string{"-q", strconv.Itoa(quality)}, args...)
+	}
+	cmd := exec.CommandContext(ctx, cwebp, args...)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cwebp: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
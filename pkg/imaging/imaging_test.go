@@ -0,0 +1,189 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"os/exec"
+	"testing"
+
+	"GoodnessucWorkflow/pkg/errs"
+)
+
+// testImage is a 64x48 image in a few flat colors, so GIF keeps it exactly
+// and JPEG stays close
+func testImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	colors := []color.NRGBA{{200, 30, 30, 255}, {30, 160, 60, 255}, {40, 60, 200, 255}, {240, 240, 240, 255}}
+	for y := range 48 {
+		for x := range 64 {
+			img.Set(x, y, colors[(x/16+y/16)%len(colors)])
+		}
+	}
+	return img
+}
+
+// encoded returns testImage in format
+func encoded(t *testing.T, format string) []byte {
+	t.Helper()
+	if format == "webp" {
+		requireCwebp(t)
+	}
+	out, err := Encode(context.Background(), testImage(), Options{Format: format, Quality: 95})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func requireCwebp(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("cwebp"); err != nil {
+		t.Skip("cwebp is not installed")
+	}
+}
+
+// meanDiff is the mean difference of the channels of a and b
+func meanDiff(a, b image.Image) float64 {
+	total, n := 0, 0
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			ca := color.NRGBAModel.Convert(a.At(x, y)).(color.NRGBA)
+			cb := color.NRGBAModel.Convert(b.At(x, y)).(color.NRGBA)
+			for _, d := range []int{int(ca.R) - int(cb.R), int(ca.G) - int(cb.G), int(ca.B) - int(cb.B), int(ca.A) - int(cb.A)} {
+				total += max(d, -d)
+				n++
+			}
+		}
+	}
+	return float64(total) / float64(n)
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	// tolerance is how far each format may stray from the original on
+	// average. GIF maps colors to the Plan 9 palette with dithering
+	tolerance := map[string]float64{"png": 0, "jpeg": 4, "webp": 4, "gif": 16}
+	for _, from := range []string{"png", "jpeg", "gif", "webp"} {
+		for _, to := range []string{"png", "jpeg", "gif", "webp"} {
+			t.Run(from+" to "+to, func(t *testing.T) {
+				if to == "webp" {
+					requireCwebp(t)
+				}
+				src := encoded(t, from)
+				result, err := Convert(context.Background(), bytes.NewReader(src), Options{Format: to, Quality: 95})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Format != to {
+					t.Errorf("Format = %q, want %q", result.Format, to)
+				}
+				img, format, err := image.Decode(bytes.NewReader(result.Data))
+				if err != nil {
+					t.Fatalf("the output does not decode: %s", err)
+				}
+				if format != to {
+					t.Errorf("the output decodes as %s, want %s", format, to)
+				}
+				if got := img.Bounds(); got != testImage().Bounds() {
+					t.Fatalf("bounds = %v, want %v", got, testImage().Bounds())
+				}
+				// Lossy input carries its loss into lossless output
+				limit := tolerance[from] + tolerance[to]
+				if d := meanDiff(testImage(), img); d > limit {
+					t.Errorf("pixels differ by %.2f on average, want at most %.2f", d, limit)
+				}
+			})
+		}
+	}
+}
+
+func TestConvertAuto(t *testing.T) {
+	result, err := Convert(context.Background(), bytes.NewReader(encoded(t, "png")), Options{Format: "auto"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Four flat colors are a drawing, which auto keeps lossless
+	if result.Format != "png" {
+		t.Errorf("auto picked %s for a flat image, want png", result.Format)
+	}
+	if _, _, err := image.Decode(bytes.NewReader(result.Data)); err != nil {
+		t.Errorf("the output does not decode: %s", err)
+	}
+}
+
+func TestConvertResize(t *testing.T) {
+	result, err := Convert(context.Background(), bytes.NewReader(encoded(t, "png")), Options{Format: "png", Transforms: []Transform{Fit(32, 0)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(result.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 32 || cfg.Height != 24 {
+		t.Errorf("size = %dx%d, want 32x24", cfg.Width, cfg.Height)
+	}
+}
+
+const testSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 40 20"><rect width="40" height="20" fill="#00f"/></svg>`
+
+func TestConvertSVG(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          Options
+		width, height int
+	}{
+		{"intrinsic", Options{Format: "png"}, 40, 20},
+		{"scaled", Options{Format: "png", SVGScale: 2.5}, 100, 50},
+		{"width", Options{Format: "jpeg", SVGWidth: 80}, 80, 40},
+		{"height", Options{Format: "gif", SVGHeight: 10}, 20, 10},
+		{"fitted", Options{Format: "png", SVGWidth: 100, SVGHeight: 100}, 100, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h, err := SVGSize([]byte(testSVG), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w != tt.width || h != tt.height {
+				t.Errorf("SVGSize = %dx%d, want %dx%d", w, h, tt.width, tt.height)
+			}
+			result, err := Convert(context.Background(), bytes.NewReader([]byte(testSVG)), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, format, err := image.Decode(bytes.NewReader(result.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != tt.opts.Format {
+				t.Errorf("format = %s, want %s", format, tt.opts.Format)
+			}
+			if b := img.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Errorf("size = %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.width, tt.height)
+			}
+			r, g, b, _ := img.At(tt.width/2, tt.height/2).RGBA()
+			if r>>8 > 40 || g>>8 > 40 || b>>8 < 200 {
+				t.Errorf("center is %d,%d,%d, want blue", r>>8, g>>8, b>>8)
+			}
+		})
+	}
+}
+
+func TestRasterizeSVGTooLarge(t *testing.T) {
+	if _, err := RasterizeSVG([]byte(testSVG), 5000, 0, 0); err == nil {
+		t.Error("RasterizeSVG drew a 200000 pixel wide SVG")
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	if _, err := Convert(context.Background(), bytes.NewReader([]byte("not an image")), Options{Format: "png"}); !errors.Is(err, errs.ErrUnsupportedFormat) && !errors.Is(err, errs.ErrDecodeFailed) {
+		t.Errorf("Convert of text = %v, want an unsupported format or decode error", err)
+	}
+	if _, err := Convert(context.Background(), bytes.NewReader(encoded(t, "png")), Options{Format: "bmp"}); !errors.Is(err, errs.ErrUnsupportedFormat) {
+		t.Errorf("Convert to bmp = %v, want an unsupported format error", err)
+	}
+}
@@ -0,0 +1,45 @@
+package imaging
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// ResizeToFit scales img down so it fits inside maxWidth by maxHeight while
+// keeping its aspect ratio; images that already fit are returned unchanged
+func ResizeToFit(img image.Image, maxWidth, maxHeight int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= maxWidth && b.Dy() <= maxHeight {
+		return img
+	}
+
+	w, h := maxWidth, b.Dy()*maxWidth/b.Dx()
+	if h > maxHeight {
+		w, h = b.Dx()*maxHeight/b.Dy(), maxHeight
+	}
+	return Resize(img, max(w, 1), max(h, 1))
+}
+
+// Resize scales img to exactly width by height
+func Resize(img image.Image, width, height int) image.Image {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
+	return dst
+}
+
+// Fit is a Transform that scales images down to fit inside width by
+// height; zero leaves that side unbounded
+func Fit(width, height int) Transform {
+	return func(img image.Image) image.Image {
+		b := img.Bounds()
+		w, h := width, height
+		if w == 0 {
+			w = b.Dx()
+		}
+		if h == 0 {
+			h = b.Dy()
+		}
+		return ResizeToFit(img, w, h)
+	}
+}
@@ -0,0 +1,83 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+
+	"GoodnessucWorkflow/pkg/errs"
+)
+
+// maxSVGSide bounds each side of a rasterized SVG, as JPEG does
+const maxSVGSide = 65535
+
+// RasterizeSVG renders an SVG document. When width or height is set the
+// drawing is fitted to it, keeping its aspect ratio; otherwise its intrinsic
+// viewBox size is multiplied by scale
+func RasterizeSVG(data []byte, scale float64, width, height int) (*image.RGBA, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data), oksvg.WarnErrorMode)
+	if err != nil {
+		return nil, errs.Decode(err)
+	}
+	w, h, err := svgSize(icon, scale, width, height)
+	if err != nil {
+		return nil, err
+	}
+	if w > maxSVGSide || h > maxSVGSide {
+		return nil, fmt.Errorf("SVG drawn at %dx%d is over %d pixels a side", w, h, maxSVGSide)
+	}
+	icon.SetTarget(0, 0, float64(w), float64(h))
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	scanner := rasterx.NewScannerGV(w, h, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(w, h, scanner), 1)
+	return img, nil
+}
+
+// SVGSize returns the size Decode would rasterize the SVG document data at
+// with opts, without drawing it, so callers can refuse huge ones first
+func SVGSize(data []byte, opts Options) (width, height int, err error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data), oksvg.WarnErrorMode)
+	if err != nil {
+		return 0, 0, errs.Decode(err)
+	}
+	return svgSize(icon, svgScale(opts), opts.SVGWidth, opts.SVGHeight)
+}
+
+// svgSize works out the size RasterizeSVG draws icon at. Sides too large
+// for an int32 come back as math.MaxInt32
+func svgSize(icon *oksvg.SvgIcon, scale float64, width, height int) (int, int, error) {
+	vw, vh := icon.ViewBox.W, icon.ViewBox.H
+	if vw <= 0 || vh <= 0 {
+		return 0, 0, fmt.Errorf("%w: SVG has no usable viewBox or size", errs.ErrDecodeFailed)
+	}
+
+	switch {
+	case width > 0 && height > 0:
+		scale = min(float64(width)/vw, float64(height)/vh)
+	case width > 0:
+		scale = float64(width) / vw
+	case height > 0:
+		scale = float64(height) / vh
+	}
+	if scale <= 0 {
+		return 0, 0, errors.New("SVG scale must be positive")
+	}
+	side := func(v float64) int {
+		return int(max(min(v*scale+0.5, math.MaxInt32), 1))
+	}
+	return side(vw), side(vh), nil
+}
+
+// svgScale is the scale SVGs are drawn at with opts, 1 when unset
+func svgScale(opts Options) float64 {
+	if opts.SVGScale == 0 {
+		return 1
+	}
+	return opts.SVGScale
+}
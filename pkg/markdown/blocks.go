@@ -0,0 +1,39 @@
+package markdown
+
+import "strings"
+
+// CodeBlock is a fenced code block found in a document
+type CodeBlock struct {
+	// Lang is the first word after the opening fence, such as go, or empty
+	Lang string
+	// Code is what the fences enclose, without a final newline
+	Code string
+	// Line is the 1-based line of the opening fence
+	Line int
+}
+
+// CodeBlocks returns the fenced code blocks in text in document order. A
+// block left open runs to the end of the document
+func CodeBlocks(text string) []CodeBlock {
+	var blocks []CodeBlock
+	var code []string
+	open := false
+	for i, line := range strings.Split(text, "\n") {
+		switch {
+		case fenceLine.MatchString(line) && !open:
+			info := strings.TrimLeft(strings.TrimSpace(line), "`~")
+			lang, _, _ := strings.Cut(strings.TrimSpace(info), " ")
+			blocks = append(blocks, CodeBlock{Lang: strings.Trim(lang, "{}."), Line: i + 1})
+			code, open = nil, true
+		case fenceLine.MatchString(line):
+			blocks[len(blocks)-1].Code = strings.Join(code, "\n")
+			open = false
+		case open:
+			code = append(code, line)
+		}
+	}
+	if open {
+		blocks[len(blocks)-1].Code = strings.TrimSuffix(strings.Join(code, "\n"), "\n")
+	}
+	return blocks
+}
@@ -0,0 +1,63 @@
+package markdown
+
+import (
+	"io"
+	"strings"
+)
+
+// Bold rewrites inline code as bold text, for platforms such as Medium that
+// drop code formatting
+func Bold(r io.Reader) io.Reader {
+	return transformString(r, boldInlineCode)
+}
+
+// boldInlineCode replaces the backticks around inline code with ** and
+// leaves fenced code blocks alone
+func boldInlineCode(input string) string {
+	var result strings.Builder
+	inCodeBlock := false
+
+	for i := 0; i < len(input); i++ {
+		if input[i] == '`' {
+			// Check if there is a backtick before or after the current backtick
+			prevBacktick := false
+			nextBacktick := false
+
+			if i > 0 && input[i-1] == '`' {
+				prevBacktick = true
+			}
+
+			if i < len(input)-1 && input[i+1] == '`' {
+				nextBacktick = true
+			}
+
+			if prevBacktick && nextBacktick {
+				// It's a code block
+				if inCodeBlock {
+					result.WriteString("```")
+					inCodeBlock = false
+				} else {
+					result.WriteString("``")
+					inCodeBlock = true
+				}
+				i++ // Skip the next backtick since we are handling a code block
+			} else if prevBacktick || nextBacktick {
+				// It's either the first or last backtick of an inline code
+				if !inCodeBlock {
+					result.WriteByte(input[i])
+				}
+			} else {
+				// It's inline code, so replace backtick with bold markers
+				if !inCodeBlock {
+					result.WriteString("**")
+				} else {
+					result.WriteByte(input[i])
+				}
+			}
+		} else {
+			result.WriteByte(input[i])
+		}
+	}
+
+	return result.String()
+}
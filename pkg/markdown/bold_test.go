@@ -0,0 +1,50 @@
+package markdown
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// readAll drains a transform's output
+func readAll(t *testing.T, r io.Reader) string {
+	t.Helper()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestBoldSample checks Bold against the output of the original bolder
+// program on the post it shipped with
+func TestBoldSample(t *testing.T) {
+	sample, err := os.ReadFile("testdata/sample.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("testdata/sample.bold.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, Bold(strings.NewReader(string(sample)))); got != string(want) {
+		t.Errorf("Bold of the sample post differs from testdata/sample.bold.md:\n%s", got)
+	}
+}
+
+func TestBold(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"use `go` here", "use **go** here"},
+		{"`a` and `b`", "**a** and **b**"},
+		{"no code", "no code"},
+		{"```go\nx := `raw`\n```\nthen `y`", "```go\nx := `raw`\n```\nthen **y**"},
+	}
+	for _, tt := range tests {
+		if got := readAll(t, Bold(strings.NewReader(tt.in))); got != tt.want {
+			t.Errorf("Bold(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
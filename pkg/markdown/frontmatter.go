@@ -0,0 +1,34 @@
+package markdown
+
+import "strings"
+
+// SplitFrontMatter returns the YAML front matter a document opens with,
+// without its --- lines, and the rest of the document. ok is false, and
+// body the whole document, when it has none
+func SplitFrontMatter(text string) (front, body string, ok bool) {
+	rest, found := strings.CutPrefix(text, "---\n")
+	if !found {
+		if rest, found = strings.CutPrefix(text, "---\r\n"); !found {
+			return "", text, false
+		}
+	}
+	for offset := 0; offset <= len(rest); {
+		end := strings.IndexByte(rest[offset:], '\n')
+		line := rest[offset:]
+		if end >= 0 {
+			line = rest[offset : offset+end]
+		}
+		if marker := strings.TrimRight(line, " \t\r"); marker == "---" || marker == "..." {
+			body := ""
+			if end >= 0 {
+				body = rest[offset+end+1:]
+			}
+			return strings.TrimRight(rest[:offset], "\r\n"), body, true
+		}
+		if end < 0 {
+			break
+		}
+		offset += end + 1
+	}
+	return "", text, false
+}
@@ -0,0 +1,565 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"GoodnessucWorkflow/pkg/highlight"
+)
+
+// Block starts HTML looks for at the beginning of a line
+var (
+	atxHeading    = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	thematicBreak = regexp.MustCompile(`^ {0,3}(?:(?:-[ \t]*){3,}|(?:\*[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	blockquote    = regexp.MustCompile(`^ {0,3}> ?`)
+	listItem      = regexp.MustCompile(`^( {0,3})([-*+]|\d{1,9}[.)])([ \t]+|$)(.*)$`)
+	tableDivider  = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+	htmlBlock     = regexp.MustCompile(`^ {0,3}<(?:[A-Za-z/]|!--)`)
+	entity        = regexp.MustCompile(`^&(?:[A-Za-z][A-Za-z0-9]*|#[0-9]{1,7}|#[xX][0-9A-Fa-f]{1,6});`)
+	autolink      = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.-]*:[^\s<>]*)>`)
+	inlineTag     = regexp.MustCompile(`^</?[A-Za-z][A-Za-z0-9-]*(?:\s[^<>]*)?/?>|^<!--[\s\S]*?-->`)
+)
+
+// HTML renders a markdown document as HTML: headings, paragraphs, lists,
+// block quotes, tables, rules and fenced code, highlighted by its language,
+// with emphasis, code spans, links and images. HTML in the document passes
+// through. It covers what posts are written with rather than all of
+// CommonMark
+func HTML(text string) string {
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n"), false)
+	return b.String()
+}
+
+// renderBlocks writes lines as HTML. Tight leaves paragraphs unwrapped, for
+// the items of a list without blank lines between them
+func renderBlocks(b *strings.Builder, lines []string, tight bool) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+
+		case fenceLine.MatchString(line):
+			marker := strings.TrimLeft(line, " ")[:3]
+			info := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), marker[:1]))
+			lang, _, _ := strings.Cut(info, " ")
+			lang = strings.Trim(lang, "{}.")
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimLeft(lines[i], " "), marker); i++ {
+				code = append(code, lines[i])
+			}
+			i++
+			b.WriteString("<pre><code")
+			if lang != "" {
+				b.WriteString(` class="language-` + html.EscapeString(lang) + `"`)
+			}
+			b.WriteString(">" + highlight.HTML(lang, strings.Join(code, "\n")) + "</code></pre>\n")
+
+		case atxHeading.MatchString(line):
+			m := atxHeading.FindStringSubmatch(line)
+			level := strconv.Itoa(len(m[1]))
+			b.WriteString("<h" + level + ">" + inlineHTML(m[2]) + "</h" + level + ">\n")
+			i++
+
+		case thematicBreak.MatchString(line):
+			b.WriteString("<hr>\n")
+			i++
+
+		case blockquote.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && blockquote.MatchString(lines[i]); i++ {
+				quoted = append(quoted, blockquote.ReplaceAllString(lines[i], ""))
+			}
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quoted, false)
+			b.WriteString("</blockquote>\n")
+
+		case listItem.MatchString(line):
+			i = renderList(b, lines, i)
+
+		case i+1 < len(lines) && strings.Contains(line, "|") && tableDivider.MatchString(lines[i+1]) && strings.Contains(lines[i+1], "-"):
+			i = renderTable(b, lines, i)
+
+		case htmlBlock.MatchString(line):
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+				b.WriteString(lines[i] + "\n")
+			}
+
+		default:
+			var para []string
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != "" && (len(para) == 0 || !startsBlock(lines[i])); i++ {
+				para = append(para, strings.TrimLeft(lines[i], " \t"))
+			}
+			text := inlineHTML(strings.Join(para, "\n"))
+			if tight {
+				b.WriteString(text + "\n")
+			} else {
+				b.WriteString("<p>" + text + "</p>\n")
+			}
+		}
+	}
+}
+
+// startsBlock reports whether line begins a block that ends a paragraph
+func startsBlock(line string) bool {
+	if m := listItem.FindStringSubmatch(line); m != nil {
+		// Only a list starting at 1 interrupts a paragraph, so a sentence
+		// that wraps before a number stays a sentence
+		return m[4] != "" && (!isDigit(m[2][0]) || strings.HasPrefix(m[2], "1"))
+	}
+	return fenceLine.MatchString(line) || atxHeading.MatchString(line) || thematicBreak.MatchString(line) || blockquote.MatchString(line) || htmlBlock.MatchString(line)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// renderList writes the list that starts at lines[i] and returns the index
+// of the line after it. Lines indented past an item's marker belong to it
+func renderList(b *strings.Builder, lines []string, i int) int {
+	first := listItem.FindStringSubmatch(lines[i])
+	ordered := isDigit(first[2][0])
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	b.WriteString("<" + tag)
+	if n, _ := strconv.Atoi(strings.TrimRight(first[2], ".)")); ordered && n != 1 {
+		b.WriteString(` start="` + strconv.Itoa(n) + `"`)
+	}
+	b.WriteString(">\n")
+
+	var items [][]string
+	loose, blankBefore := false, false
+	for i < len(lines) {
+		m := listItem.FindStringSubmatch(lines[i])
+		if m == nil || isDigit(m[2][0]) != ordered {
+			break
+		}
+		// A blank line between items makes the list loose
+		loose = loose || blankBefore
+		width := len(m[1]) + len(m[2]) + max(len(m[3]), 1)
+		item := []string{m[4]}
+		for i++; i < len(lines); i++ {
+			l := lines[i]
+			if strings.TrimSpace(l) == "" {
+				item = append(item, "")
+				continue
+			}
+			indent := len(l) - len(strings.TrimLeft(l, " "))
+			if indent >= width {
+				item = append(item, l[width:])
+				continue
+			}
+			// A line that is not indented continues the item's paragraph,
+			// unless a blank line or another block comes first
+			if item[len(item)-1] == "" || startsBlock(l) {
+				break
+			}
+			item = append(item, l)
+		}
+		blankBefore = false
+		for len(item) > 1 && item[len(item)-1] == "" {
+			item = item[:len(item)-1]
+			blankBefore = true
+		}
+		for _, l := range item {
+			if l == "" {
+				loose = true
+			}
+		}
+		items = append(items, item)
+	}
+	for _, item := range items {
+		b.WriteString("<li>")
+		var inner strings.Builder
+		renderBlocks(&inner, item, !loose)
+		b.WriteString(strings.TrimSuffix(inner.String(), "\n") + "</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// renderTable writes the pipe table whose header is lines[i] and returns
+// the index of the line after it
+func renderTable(b *strings.Builder, lines []string, i int) int {
+	header := tableCells(lines[i])
+	var align []string
+	for _, cell := range tableCells(lines[i+1]) {
+		switch {
+		case strings.HasPrefix(cell, ":") && strings.HasSuffix(cell, ":"):
+			align = append(align, "center")
+		case strings.HasSuffix(cell, ":"):
+			align = append(align, "right")
+		case strings.HasPrefix(cell, ":"):
+			align = append(align, "left")
+		default:
+			align = append(align, "")
+		}
+	}
+	row := func(cells []string, tag string) {
+		b.WriteString("<tr>")
+		for c := range header {
+			text := ""
+			if c < len(cells) {
+				text = cells[c]
+			}
+			b.WriteString("<" + tag)
+			if c < len(align) && align[c] != "" {
+				b.WriteString(` style="text-align: ` + align[c] + `"`)
+			}
+			b.WriteString(">" + inlineHTML(text) + "</" + tag + ">")
+		}
+		b.WriteString("</tr>\n")
+	}
+	b.WriteString("<table>\n<thead>\n")
+	row(header, "th")
+	b.WriteString("</thead>\n<tbody>\n")
+	for i += 2; i < len(lines) && strings.TrimSpace(lines[i]) != "" && strings.Contains(lines[i], "|"); i++ {
+		row(tableCells(lines[i]), "td")
+	}
+	b.WriteString("</tbody>\n</table>\n")
+	return i
+}
+
+// tableCells splits a table row at the pipes that are not escaped
+func tableCells(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	var cells []string
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '|':
+			cells = append(cells, strings.TrimSpace(line[start:i]))
+			start = i + 1
+		}
+	}
+	cells = append(cells, strings.TrimSpace(line[start:]))
+	for i, cell := range cells {
+		cells[i] = strings.ReplaceAll(cell, `\|`, "|")
+	}
+	return cells
+}
+
+// inlineHTML renders the emphasis, code spans, links, images and line
+// breaks in text and escapes the rest
+func inlineHTML(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		c := text[i]
+		rest := text[i:]
+		switch {
+		case c == '\\' && i+1 < len(text) && text[i+1] == '\n':
+			b.WriteString("<br>\n")
+			i += 2
+		case c == '\\' && i+1 < len(text) && strings.IndexByte("\\`*_{}[]()#+-.!|~<>\"'", text[i+1]) >= 0:
+			b.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+
+		case c == '`':
+			n := len(rest) - len(strings.TrimLeft(rest, "`"))
+			end := closingTicks(rest[n:], n)
+			if end < 0 {
+				b.WriteString(rest[:n])
+				i += n
+				continue
+			}
+			code := strings.ReplaceAll(rest[n:n+end], "\n", " ")
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' {
+				code = code[1 : len(code)-1]
+			}
+			b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+			i += n + end + n
+
+		case c == '!' && strings.HasPrefix(rest, "!["):
+			if label, dest, title, n, ok := parseInlineLink(rest[1:]); ok {
+				b.WriteString(`<img src="` + html.EscapeString(dest) + `" alt="` + html.EscapeString(plainText(label)) + `"`)
+				if title != "" {
+					b.WriteString(` title="` + html.EscapeString(title) + `"`)
+				}
+				b.WriteString(">")
+				i += 1 + n
+				continue
+			}
+			b.WriteString("!")
+			i++
+
+		case c == '[':
+			if label, dest, title, n, ok := parseInlineLink(rest); ok {
+				b.WriteString(`<a href="` + html.EscapeString(dest) + `"`)
+				if title != "" {
+					b.WriteString(` title="` + html.EscapeString(title) + `"`)
+				}
+				b.WriteString(">" + inlineHTML(label) + "</a>")
+				i += n
+				continue
+			}
+			b.WriteString("[")
+			i++
+
+		case c == '<':
+			if m := autolink.FindStringSubmatch(rest); m != nil {
+				b.WriteString(`<a href="` + html.EscapeString(m[1]) + `">` + html.EscapeString(m[1]) + "</a>")
+				i += len(m[0])
+			} else if tag := inlineTag.FindString(rest); tag != "" {
+				b.WriteString(tag)
+				i += len(tag)
+			} else {
+				b.WriteString("&lt;")
+				i++
+			}
+
+		case c == '&':
+			if e := entity.FindString(rest); e != "" {
+				b.WriteString(e)
+				i += len(e)
+			} else {
+				b.WriteString("&amp;")
+				i++
+			}
+
+		case c == '*' || c == '_' || c == '~' && strings.HasPrefix(rest, "~~"):
+			if out, n := emphasis(text, i); n > 0 {
+				b.WriteString(out)
+				i += n
+				continue
+			}
+			n := len(rest) - len(strings.TrimLeft(rest, string(c)))
+			b.WriteString(rest[:n])
+			i += n
+
+		case c == ' ' && strings.HasPrefix(rest, "  \n"):
+			b.WriteString("<br>\n")
+			i += 3
+
+		default:
+			b.WriteString(html.EscapeString(rest[:1]))
+			i++
+		}
+	}
+	return b.String()
+}
+
+// closingTicks is where the run of exactly n backticks closing a code span
+// starts in s, or -1
+func closingTicks(s string, n int) int {
+	for i := 0; i < len(s); {
+		if s[i] != '`' {
+			i++
+			continue
+		}
+		run := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+		if run == n {
+			return i
+		}
+		i += run
+	}
+	return -1
+}
+
+// emphasis renders the strong, emphasized or struck through text opening at
+// text[i] and returns it with the length it spans, or 0 when the delimiter
+// there opens nothing
+func emphasis(text string, i int) (string, int) {
+	run, n := emphasisSpan(text, i)
+	if n == 0 {
+		return "", 0
+	}
+	inner := inlineHTML(text[i+run : i+n-run])
+	switch {
+	case text[i] == '~':
+		inner = "<del>" + inner + "</del>"
+	case run == 1:
+		inner = "<em>" + inner + "</em>"
+	case run == 2:
+		inner = "<strong>" + inner + "</strong>"
+	default:
+		inner = "<em><strong>" + inner + "</strong></em>"
+	}
+	return inner, n
+}
+
+// emphasisSpan returns the length of the delimiter opening emphasis at
+// text[i] and of the whole span, or zeros when it opens nothing. A *** that
+// nothing closes may still open * and ** nested, as in ***a** b*
+func emphasisSpan(text string, i int) (run, n int) {
+	c := text[i]
+	// Underscores inside words, as in snake_case, are not emphasis
+	if c == '_' && i > 0 && isWordByte(text[i-1]) {
+		return 0, 0
+	}
+	runs := []int{delimiterRun(text[i:])}
+	if runs[0] == 3 {
+		runs = append(runs, 1, 2)
+	}
+	for _, run := range runs {
+		after := text[i+run:]
+		if !opens(after, 0) {
+			return 0, 0
+		}
+		if end := closingDelimiter(after, text[i:i+run], true); end >= 0 {
+			return run, run + end + run
+		}
+	}
+	return 0, 0
+}
+
+// closingDelimiter is where the delimiter closing one opened by delim starts
+// in s, the text after the opener, or -1. Escapes and code spans are
+// skipped, and with nest so is emphasis opened by other delimiters, so the
+// ** of *a **b** c* opens rather than closes. A longer run that opens
+// nothing closes with its first bytes, as in *a **b***
+func closingDelimiter(s, delim string, nest bool) int {
+	c := delim[0]
+	// unclosed holds the nested delimiters already found to have no closer;
+	// they have none further on either, which keeps the scan linear
+	var unclosed map[string]bool
+	for j := 0; j < len(s); {
+		switch s[j] {
+		case '\\':
+			j += 2
+			continue
+		case '`':
+			n := len(s[j:]) - len(strings.TrimLeft(s[j:], "`"))
+			if end := closingTicks(s[j+n:], n); end >= 0 {
+				j += n + end + n
+			} else {
+				j += n
+			}
+			continue
+		case c:
+		default:
+			j++
+			continue
+		}
+
+		m := len(s[j:]) - len(strings.TrimLeft(s[j:], string(c)))
+		// A lone ~ is text
+		if c == '~' && m < 2 {
+			j++
+			continue
+		}
+		if nest && m != len(delim) && opens(s, j+m) && (c != '_' || j == 0 || !isWordByte(s[j-1])) {
+			run := delimiterRun(s[j:])
+			inner := s[j : j+run]
+			if !unclosed[inner] {
+				if end := closingDelimiter(s[j+run:], inner, false); end >= 0 {
+					j += run + end + run
+					continue
+				}
+				if unclosed == nil {
+					unclosed = make(map[string]bool)
+				}
+				unclosed[inner] = true
+			}
+		}
+		closes := j > 0 && s[j-1] != ' ' && s[j-1] != '\n'
+		if c == '_' && j+m < len(s) && isWordByte(s[j+m]) {
+			closes = false
+		}
+		switch {
+		case !closes || m < len(delim):
+		case m == len(delim) || c == '~' || !opens(s, j+m):
+			return j
+		}
+		j += m
+	}
+	return -1
+}
+
+// opens reports whether s[i] starts text, so a delimiter run just before it
+// could open emphasis
+func opens(s string, i int) bool {
+	return i < len(s) && s[i] != ' ' && s[i] != '\n'
+}
+
+// delimiterRun is the length of the emphasis delimiter s starts with: ~~
+// or up to three * or _
+func delimiterRun(s string) int {
+	if s[0] == '~' {
+		return 2
+	}
+	return min(len(s)-len(strings.TrimLeft(s, s[:1])), 3)
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// parseInlineLink parses [label](destination "title") at the start of s and
+// returns its parts and length
+func parseInlineLink(s string) (label, dest, title string, n int, ok bool) {
+	depth := 0
+	closeLabel := -1
+	for i := 0; i < len(s) && closeLabel < 0; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth == 0 {
+				closeLabel = i
+			}
+		}
+	}
+	if closeLabel < 0 || closeLabel+1 >= len(s) || s[closeLabel+1] != '(' {
+		return "", "", "", 0, false
+	}
+	label = s[1:closeLabel]
+	i := closeLabel + 2
+	for i < len(s) && s[i] == ' ' {
+		i++
+	}
+	if i < len(s) && s[i] == '<' {
+		end := strings.IndexByte(s[i:], '>')
+		if end < 0 {
+			return "", "", "", 0, false
+		}
+		dest, i = s[i+1:i+end], i+end+1
+	} else {
+		start, parens := i, 0
+		for ; i < len(s) && s[i] != ' ' && s[i] != '\n'; i++ {
+			if s[i] == '(' {
+				parens++
+			} else if s[i] == ')' {
+				if parens == 0 {
+					break
+				}
+				parens--
+			}
+		}
+		dest = s[start:i]
+	}
+	for i < len(s) && (s[i] == ' ' || s[i] == '\n') {
+		i++
+	}
+	if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+		end := strings.IndexByte(s[i+1:], s[i])
+		if end < 0 {
+			return "", "", "", 0, false
+		}
+		title, i = s[i+1:i+1+end], i+end+2
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+	}
+	if i >= len(s) || s[i] != ')' {
+		return "", "", "", 0, false
+	}
+	return label, dest, title, i + 1, true
+}
+
+// plainText is the text of inline markdown without its markup, for alt text
+func plainText(s string) string {
+	return strings.NewReplacer("**", "", "__", "", "*", "", "`", "", "~~", "").Replace(s)
+}
@@ -0,0 +1,139 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInlineHTML(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		// Emphasis
+		{"strong and em", "***both***", "<em><strong>both</strong></em>"},
+		{"em in strong", "**bold *em* bold**", "<strong>bold <em>em</em> bold</strong>"},
+		{"strong in em", "*em **strong** em*", "<em>em <strong>strong</strong> em</em>"},
+		{"strong in em underscores", "_em __strong__ em_", "<em>em <strong>strong</strong> em</em>"},
+		{"em in del", "~~del *em*~~", "<del>del <em>em</em></del>"},
+		{"closing run shared", "*a **b***", "<em>a <strong>b</strong></em>"},
+		{"triple opens strong first", "***a** b*", "<em><strong>a</strong> b</em>"},
+		{"triple opens em first", "***a* b**", "<strong><em>a</em> b</strong>"},
+		{"longer run inside", "*foo**bar*", "<em>foo**bar</em>"},
+		{"longer run closing", "*a***", "<em>a</em>**"},
+		{"closer in code span", "*a `*` b*", "<em>a <code>*</code> b</em>"},
+		{"escaped closer", `*a \* b*`, "<em>a * b</em>"},
+		{"snake case", "snake_case_name", "snake_case_name"},
+		{"dunder", "__init__ and _x_", "<strong>init</strong> and <em>x</em>"},
+		{"spaced stars", "a * b * c", "a * b * c"},
+		{"unclosed", "**unclosed", "**unclosed"},
+		{"two spans", "**a** and **b**", "<strong>a</strong> and <strong>b</strong>"},
+		{"extra tilde", "~~a~~~", "<del>a</del>~"},
+		{"in a word", "foo*bar*", "foo<em>bar</em>"},
+
+		// Code spans and backtick runs
+		{"tick inside double", "``code with ` tick``", "<code>code with ` tick</code>"},
+		{"double inside single spaced", "` `` `", "<code>``</code>"},
+		{"double inside triple", "```a``b```", "<code>a``b</code>"},
+		{"unclosed tick", "`unclosed", "`unclosed"},
+		{"unmatched runs", "``x`", "``x`"},
+		{"one space stripped", "`  a  `", "<code> a </code>"},
+		{"spaces stripped", "` a `", "<code>a</code>"},
+		{"newline in code", "`a\nb`", "<code>a b</code>"},
+		{"markup in code", "`<b>*x*</b>`", "<code>&lt;b&gt;*x*&lt;/b&gt;</code>"},
+
+		// Links
+		{"parentheses in target", "[wiki](https://en.wikipedia.org/wiki/Go_(programming_language))", `<a href="https://en.wikipedia.org/wiki/Go_(programming_language)">wiki</a>`},
+		{"parentheses and title", `[a](b(c)d "t")`, `<a href="b(c)d" title="t">a</a>`},
+		{"angle target", "[a](<x y>)", `<a href="x y">a</a>`},
+		{"extra parenthesis", "[a](b))", `<a href="b">a</a>)`},
+		{"nested brackets", "[a [nested] b](u)", `<a href="u">a [nested] b</a>`},
+		{"single quoted title", "[a](u 'single')", `<a href="u" title="single">a</a>`},
+		{"image", `![alt *x*](i.png "T")`, `<img src="i.png" alt="alt x" title="T">`},
+		{"unclosed target", "[a](b", "[a](b"},
+		{"escaped parenthesis", `[a]\(b)`, "[a](b)"},
+		{"parentheses after", "[x](u)(v)", `<a href="u">x</a>(v)`},
+		{"escaped bracket", `[\]](u)`, `<a href="u">]</a>`},
+		{"emphasis in label", "[*a*](u)", `<a href="u"><em>a</em></a>`},
+	}
+	for _, tt := range tests {
+		if got := inlineHTML(tt.in); got != tt.want {
+			t.Errorf("%s: inlineHTML(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseInlineLink(t *testing.T) {
+	tests := []struct {
+		in                 string
+		label, dest, title string
+		n                  int
+		ok                 bool
+	}{
+		{"[a](b) rest", "a", "b", "", 6, true},
+		{"[a](b(c)) rest", "a", "b(c)", "", 9, true},
+		{"[a]( b  'c' ) rest", "a", "b", "c", 13, true},
+		{"[a](<b c>)", "a", "b c", "", 10, true},
+		{"[a][b]", "", "", "", 0, false},
+		{"[a](b 'c)", "", "", "", 0, false},
+		{"[a(b)", "", "", "", 0, false},
+	}
+	for _, tt := range tests {
+		label, dest, title, n, ok := parseInlineLink(tt.in)
+		if label != tt.label || dest != tt.dest || title != tt.title || n != tt.n || ok != tt.ok {
+			t.Errorf("parseInlineLink(%q) = %q, %q, %q, %d, %v; want %q, %q, %q, %d, %v",
+				tt.in, label, dest, title, n, ok, tt.label, tt.dest, tt.title, tt.n, tt.ok)
+		}
+	}
+}
+
+func TestClosingTicks(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want int
+	}{
+		{"a`", 1, 1},
+		{"a``b`", 1, 4},
+		{"a`b``", 2, 3},
+		{"a```", 2, -1},
+		{"abc", 1, -1},
+	}
+	for _, tt := range tests {
+		if got := closingTicks(tt.s, tt.n); got != tt.want {
+			t.Errorf("closingTicks(%q, %d) = %d, want %d", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
+
+// TestEmphasisUnclosed checks that many delimiters with no closer are not
+// searched over and over, which the API would otherwise time out on
+func TestEmphasisUnclosed(t *testing.T) {
+	text := strings.Repeat("*a **b ***c _d __e ", 500)
+	done := make(chan string)
+	go func() { done <- inlineHTML(text) }()
+	select {
+	case got := <-done:
+		if strings.Contains(got, "<em>") || strings.Contains(got, "<strong>") {
+			t.Error("unclosed delimiters rendered as emphasis")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("inlineHTML took over 10s on unclosed delimiters")
+	}
+}
+
+func TestEmphasisPlainAndTranslatable(t *testing.T) {
+	text := "***a** b* and *c*"
+	p := Prose(text)[0]
+	if got, _ := p.Plain(); got != "a b and c" {
+		t.Errorf("Plain() = %q, want %q", got, "a b and c")
+	}
+	tr := NewTranslatable(text)
+	got, err := tr.Markdown(tr.HTML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != text {
+		t.Errorf("Translatable round trip = %q, want %q", got, text)
+	}
+}
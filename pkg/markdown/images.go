@@ -0,0 +1,102 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Image patterns: markdown images, HTML img tags and the attributes of a
+// tag that matter here
+var (
+	markdownImage = regexp.MustCompile(`!\[([^\]\n]*)\]\(\s*(<[^>\n]*>|[^)\s]+)`)
+	imgTag        = regexp.MustCompile(`(?i)<img\b[^>]*>`)
+	imgAttr       = regexp.MustCompile(`(?i)\b(src|alt)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// Image is an image found in a document
+type Image struct {
+	// Target is the image's path or URL and Alt its alt text, unescaped
+	Target string
+	Alt    string
+	// HTML is set for an img tag rather than markdown's ![alt](target)
+	HTML bool
+	// AltStart and AltEnd are the byte offsets of the alt text; for a tag
+	// without an alt attribute both are where one would go. Line is the
+	// 1-based line the image is on
+	AltStart, AltEnd, Line int
+
+	hasAlt bool
+}
+
+// Images returns the images in text in document order, skipping fenced
+// code blocks
+func Images(text string) []Image {
+	inFence := fenced(text)
+	var images []Image
+	for _, m := range markdownImage.FindAllStringSubmatchIndex(text, -1) {
+		if inFence(m[0]) {
+			continue
+		}
+		images = append(images, Image{
+			Target:   strings.Trim(text[m[4]:m[5]], "<>"),
+			Alt:      unescapeAlt(text[m[2]:m[3]]),
+			AltStart: m[2],
+			AltEnd:   m[3],
+			Line:     1 + strings.Count(text[:m[0]], "\n"),
+			hasAlt:   true,
+		})
+	}
+	for _, m := range imgTag.FindAllStringIndex(text, -1) {
+		if inFence(m[0]) {
+			continue
+		}
+		tag := text[m[0]:m[1]]
+		img := Image{HTML: true, Line: 1 + strings.Count(text[:m[0]], "\n")}
+		// Without an alt attribute, one goes right after <img
+		img.AltStart, img.AltEnd = m[0]+len("<img"), m[0]+len("<img")
+		for _, a := range imgAttr.FindAllStringSubmatchIndex(tag, -1) {
+			value := 4
+			if a[value] < 0 {
+				value = 6
+			}
+			switch strings.ToLower(tag[a[2]:a[3]]) {
+			case "src":
+				img.Target = html.UnescapeString(tag[a[value]:a[value+1]])
+			case "alt":
+				img.Alt = html.UnescapeString(tag[a[value]:a[value+1]])
+				img.AltStart, img.AltEnd, img.hasAlt = m[0]+a[value], m[0]+a[value+1], true
+			}
+		}
+		images = append(images, img)
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].AltStart < images[j].AltStart })
+	return images
+}
+
+// SetAlt returns what replaces the document's bytes from AltStart to
+// AltEnd to give the image the alt text alt, escaped for where it goes
+func (img Image) SetAlt(alt string) string {
+	alt = strings.Join(strings.Fields(alt), " ")
+	switch {
+	case !img.HTML:
+		return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(alt)
+	case img.hasAlt:
+		return html.EscapeString(alt)
+	default:
+		return ` alt="` + html.EscapeString(alt) + `"`
+	}
+}
+
+// unescapeAlt drops the backslashes escaping punctuation in markdown alt text
+func unescapeAlt(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\\[]*_`!", s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
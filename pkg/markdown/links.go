@@ -0,0 +1,109 @@
+package markdown
+
+import (
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// linkPatterns find link targets in markdown: inline links and images,
+// reference definitions and HTML img tags. The first non-empty group of
+// each match is the target
+var linkPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`!?\[[^\]\n]*\]\(\s*(<[^>\n]*>|[^)\s]+)`),
+	regexp.MustCompile(`(?m)^ {0,3}\[[^\]\n]+\]:[ \t]*(<[^>\n]*>|\S+)`),
+	regexp.MustCompile(`<img\b[^>]*?\bsrc\s*=\s*(?:"([^"]*)"|'([^']*)')`),
+}
+
+// fence opens or closes a fenced code block, whose contents are left alone
+var fence = regexp.MustCompile("(?m)^ {0,3}(```|~~~)")
+
+// Link is a link target found in a document
+type Link struct {
+	Target string
+	// Start and End are the byte offsets of the target, and Line is the
+	// 1-based line it starts on
+	Start, End, Line int
+}
+
+// Links returns the link targets in text in document order, skipping
+// fenced code blocks
+func Links(text string) []Link {
+	inFence := fenced(text)
+	var links []Link
+	for _, pattern := range linkPatterns {
+		for _, m := range pattern.FindAllStringSubmatchIndex(text, -1) {
+			if inFence(m[0]) {
+				continue
+			}
+			for g := 2; g+1 < len(m); g += 2 {
+				if m[g] >= 0 {
+					line := 1 + strings.Count(text[:m[g]], "\n")
+					links = append(links, Link{Target: text[m[g]:m[g+1]], Start: m[g], End: m[g+1], Line: line})
+					break
+				}
+			}
+		}
+	}
+
+	// Links from different patterns never overlap, but they are found out
+	// of order
+	sort.Slice(links, func(i, j int) bool { return links[i].Start < links[j].Start })
+	return links
+}
+
+// fenced returns a function reporting whether a byte offset of text is in
+// a fenced code block
+func fenced(text string) func(pos int) bool {
+	var blocks [][2]int
+	fences := fence.FindAllStringIndex(text, -1)
+	for i := 0; i+1 < len(fences); i += 2 {
+		blocks = append(blocks, [2]int{fences[i][0], fences[i+1][1]})
+	}
+	if len(fences)%2 == 1 {
+		blocks = append(blocks, [2]int{fences[len(fences)-1][0], len(text)})
+	}
+	return func(pos int) bool {
+		for _, b := range blocks {
+			if pos >= b[0] && pos < b[1] {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// RewriteLinks replaces the link targets in text that relink maps to a new
+// target, skipping fenced code blocks, and returns the result with the
+// number of links changed
+func RewriteLinks(text string, relink func(string) (string, bool)) (string, int) {
+	var b strings.Builder
+	last, count := 0, 0
+	for _, l := range Links(text) {
+		if l.Start < last {
+			continue
+		}
+		target, ok := relink(l.Target)
+		if !ok {
+			continue
+		}
+		b.WriteString(text[last:l.Start])
+		b.WriteString(target)
+		last = l.End
+		count++
+	}
+	b.WriteString(text[last:])
+	return b.String(), count
+}
+
+// Relink returns a Transform that rewrites link targets with relink, as
+// RewriteLinks does
+func Relink(relink func(target string) (string, bool)) Transform {
+	return func(r io.Reader) io.Reader {
+		return transformString(r, func(text string) string {
+			text, _ = RewriteLinks(text, relink)
+			return text
+		})
+	}
+}
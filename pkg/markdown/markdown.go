@@ -0,0 +1,66 @@
+// Package markdown holds the markdown transforms behind goodness md and the
+// link rewriting goodness img uses after converting images. Transforms are
+// plain functions on readers, so other programs can chain them over files,
+// strings or network streams
+package markdown
+
+import (
+	"io"
+	"sort"
+	"strings"
+)
+
+// Transform rewrites a markdown document. Errors reading the input are
+// returned by the output's Read
+type Transform func(io.Reader) io.Reader
+
+// Chain returns a Transform that applies transforms in order
+func Chain(transforms ...Transform) Transform {
+	return func(r io.Reader) io.Reader {
+		for _, t := range transforms {
+			r = t(r)
+		}
+		return r
+	}
+}
+
+// builtin are the transforms front ends such as the API and the wasm build
+// offer by name
+var builtin = map[string]Transform{
+	"bold": Bold,
+	"tidy": Tidy,
+}
+
+// Named returns the built-in transform called name: bold or tidy
+func Named(name string) (Transform, bool) {
+	t, ok := builtin[name]
+	return t, ok
+}
+
+// Names lists the built-in transforms in order
+func Names() []string {
+	names := make([]string, 0, len(builtin))
+	for name := range builtin {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// transformString adapts a function on the whole document to a Transform
+func transformString(r io.Reader, fn func(string) string) io.Reader {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return errReader{err}
+	}
+	return strings.NewReader(fn(string(data)))
+}
+
+// errReader fails every read with err
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
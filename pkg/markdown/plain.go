@@ -0,0 +1,141 @@
+package markdown
+
+import (
+	"html"
+	"strings"
+)
+
+// Placeholders stand in for code and URLs in plain text, so the sentence
+// around them still reads as one
+const (
+	codePlaceholder = "code"
+	linkPlaceholder = "link"
+)
+
+// Plain returns p's text without its markup, for checkers that read prose,
+// and the offset in the document of each of its bytes. Bytes that are not
+// in the document as they are, as the placeholders for code spans and URLs
+// or decoded entities, have the offset -1. Link text is kept, and images
+// and HTML tags are dropped
+func (p Paragraph) Plain() (string, []int) {
+	var b plainBuilder
+	b.inline(p.Text, 0)
+	for i, at := range b.offsets {
+		if at >= 0 {
+			b.offsets[i] = p.Offset(at)
+		}
+	}
+	return b.text.String(), b.offsets
+}
+
+// plainBuilder collects plain text and where each byte came from
+type plainBuilder struct {
+	text    strings.Builder
+	offsets []int
+}
+
+// real adds s, which is at offset at
+func (b *plainBuilder) real(s string, at int) {
+	b.text.WriteString(s)
+	for i := range len(s) {
+		b.offsets = append(b.offsets, at+i)
+	}
+}
+
+// synthetic adds s, which is not in the text as it is
+func (b *plainBuilder) synthetic(s string) {
+	b.text.WriteString(s)
+	for range len(s) {
+		b.offsets = append(b.offsets, -1)
+	}
+}
+
+// inline adds the plain text of inline markdown that starts at offset base
+func (b *plainBuilder) inline(text string, base int) {
+	for i := 0; i < len(text); {
+		c := text[i]
+		rest := text[i:]
+		switch {
+		case c == '\\' && i+1 < len(text) && text[i+1] == '\n':
+			b.synthetic(" ")
+			i += 2
+		case c == '\\' && i+1 < len(text) && strings.IndexByte("\\`*_{}[]()#+-.!|~<>\"'", text[i+1]) >= 0:
+			b.real(text[i+1:i+2], base+i+1)
+			i += 2
+
+		case c == '`':
+			n := len(rest) - len(strings.TrimLeft(rest, "`"))
+			end := closingTicks(rest[n:], n)
+			if end < 0 {
+				b.real(rest[:n], base+i)
+				i += n
+				continue
+			}
+			b.synthetic(codePlaceholder)
+			i += n + end + n
+
+		case c == '!' && strings.HasPrefix(rest, "!["):
+			if _, _, _, n, ok := parseInlineLink(rest[1:]); ok {
+				i += 1 + n
+				continue
+			}
+			b.real("!", base+i)
+			i++
+
+		case c == '[':
+			if label, _, _, n, ok := parseInlineLink(rest); ok {
+				b.inline(label, base+i+1)
+				i += n
+				continue
+			}
+			b.real("[", base+i)
+			i++
+
+		case c == '<':
+			if m := autolink.FindString(rest); m != "" {
+				b.synthetic(linkPlaceholder)
+				i += len(m)
+			} else if tag := inlineTag.FindString(rest); tag != "" {
+				i += len(tag)
+			} else {
+				b.real("<", base+i)
+				i++
+			}
+
+		case c == '&':
+			if e := entity.FindString(rest); e != "" {
+				b.synthetic(html.UnescapeString(e))
+				i += len(e)
+			} else {
+				b.real("&", base+i)
+				i++
+			}
+
+		case c == 'h' && (i == 0 || !isWordByte(text[i-1])) && bareURL.MatchString(rest):
+			b.synthetic(linkPlaceholder)
+			i += len(bareURL.FindString(rest))
+
+		case c == '*' || c == '_' || c == '~' && strings.HasPrefix(rest, "~~"):
+			if run, n := emphasisSpan(text, i); n > 0 {
+				b.inline(rest[run:n-run], base+i+run)
+				i += n
+				continue
+			}
+			n := len(rest) - len(strings.TrimLeft(rest, string(c)))
+			b.real(rest[:n], base+i)
+			i += n
+
+		case c == '\n':
+			b.real(" ", base+i)
+			i++
+		case c == ' ' && strings.HasPrefix(strings.TrimLeft(rest, " "), "\n"):
+			// Spaces ending a line, as in a hard break, read as one
+			b.synthetic(" ")
+			i += len(rest) - len(strings.TrimLeft(rest, " ")) + 1
+
+		default:
+			b.real(rest[:1], base+i)
+			i++
+		}
+	}
+}
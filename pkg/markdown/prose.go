@@ -0,0 +1,166 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// markupTag is an HTML tag InlineText drops
+var markupTag = regexp.MustCompile(`<[^>]*>`)
+
+// Paragraph kinds
+const (
+	KindParagraph = "paragraph"
+	KindHeading   = "heading"
+	KindItem      = "item"
+	KindQuote     = "quote"
+)
+
+// Paragraph is a run of prose in a document: a paragraph, a heading, a
+// list item or a paragraph of a block quote
+type Paragraph struct {
+	// Kind is KindParagraph, KindHeading, KindItem or KindQuote
+	Kind string
+	// Level is a heading's level, 1 to 6
+	Level int
+	// Text is the paragraph's inline markdown without its block markers,
+	// such as # or >, with its lines joined by newlines
+	Text string
+	// Line is the 1-based line the paragraph starts on
+	Line int
+	// Start and End are the byte offsets in the document of the
+	// paragraph's lines, markers included, without the last newline
+	Start, End int
+
+	// marker is what comes before Text on the first line, and indent what
+	// Rewrite puts before each line after it
+	marker, indent string
+	// lines are the offsets in the document where the lines of Text start
+	lines []int
+}
+
+// Rewrite returns the markdown that replaces the document's bytes from
+// p.Start to p.End to give p the text s, keeping its block markers. A
+// heading is kept on one line
+func (p Paragraph) Rewrite(s string) string {
+	s = strings.TrimSpace(strings.ReplaceAll(s, "\r\n", "\n"))
+	if p.Kind == KindHeading {
+		return p.marker + strings.Join(strings.Fields(s), " ")
+	}
+	return p.marker + strings.ReplaceAll(s, "\n", "\n"+p.indent)
+}
+
+// Offset returns the offset in the document of byte i of p.Text
+func (p Paragraph) Offset(i int) int {
+	line := strings.Count(p.Text[:i], "\n")
+	return p.lines[line] + i - (strings.LastIndexByte(p.Text[:i], '\n') + 1)
+}
+
+// Prose returns the paragraphs of text in document order. Front matter,
+// code blocks, HTML blocks, tables and rules are not prose and are left out
+func Prose(text string) []Paragraph {
+	_, body, _ := SplitFrontMatter(text)
+	first := strings.Count(text[:len(text)-len(body)], "\n")
+	offset := len(text) - len(body)
+
+	var paragraphs []Paragraph
+	var current *Paragraph
+	flush := func() {
+		if current != nil && strings.TrimSpace(current.Text) != "" {
+			current.lines[0] += len(current.Text) - len(strings.TrimLeft(current.Text, " \t"))
+			current.Text = strings.TrimSpace(current.Text)
+			paragraphs = append(paragraphs, *current)
+		}
+		current = nil
+	}
+	// start begins a paragraph with line i, which is at offset at and has
+	// its text after marker
+	start := func(kind string, i, at int, line, marker, indent string) {
+		flush()
+		current = &Paragraph{
+			Kind:   kind,
+			Text:   line[len(marker):],
+			Line:   first + i + 1,
+			Start:  at,
+			End:    at + len(line),
+			marker: marker,
+			indent: indent,
+			lines:  []int{at + len(marker)},
+		}
+	}
+
+	lines := strings.Split(body, "\n")
+	inFence, skipping := false, false
+	for i, raw := range lines {
+		at := offset
+		offset += len(raw) + 1
+		line := strings.TrimSuffix(raw, "\r")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case fenceLine.MatchString(line):
+			flush()
+			inFence = !inFence
+		case inFence:
+		case trimmed == "":
+			flush()
+			skipping = false
+		case skipping:
+		case i+1 < len(lines) && strings.Contains(line, "|") && tableDivider.MatchString(strings.TrimSuffix(lines[i+1], "\r")):
+			// Tables and HTML blocks run to the next blank line
+			flush()
+			skipping = true
+		case htmlBlock.MatchString(line) && current == nil:
+			skipping = true
+		case thematicBreak.MatchString(line):
+			flush()
+		case atxHeading.MatchString(line):
+			// A closing run of #s stays out of the paragraph
+			m := atxHeading.FindStringSubmatchIndex(line)
+			text := ""
+			if m[4] >= 0 {
+				text = line[m[4]:m[5]]
+				line = line[:m[5]]
+			}
+			start(KindHeading, i, at, line, line[:len(line)-len(text)], "")
+			current.Level = m[3] - m[2]
+			flush()
+		case blockquote.MatchString(line):
+			quote := blockquote.FindString(line)
+			inner := line[len(quote):]
+			if m := listItem.FindStringSubmatch(inner); m != nil {
+				marker := quote + m[1] + m[2] + m[3]
+				start(KindItem, i, at, line, marker, quote+strings.Repeat(" ", len(marker)-len(quote)))
+			} else if strings.TrimSpace(inner) == "" {
+				flush()
+			} else if current == nil || current.Kind != KindQuote {
+				start(KindQuote, i, at, line, line[:len(line)-len(strings.TrimLeft(inner, " \t"))], quote)
+			} else {
+				current.Text += "\n" + strings.TrimSpace(inner)
+				current.End = at + len(line)
+				current.lines = append(current.lines, at+len(quote)+len(inner)-len(strings.TrimLeft(inner, " \t")))
+			}
+		case listItem.MatchString(line):
+			m := listItem.FindStringSubmatch(line)
+			marker := m[1] + m[2] + m[3]
+			start(KindItem, i, at, line, marker, strings.Repeat(" ", len(marker)))
+		case current == nil && strings.HasPrefix(line, "    "):
+			// Indented code
+		case current == nil:
+			start(KindParagraph, i, at, line, line[:len(line)-len(strings.TrimLeft(line, " \t"))], "")
+		default:
+			current.Text += "\n" + trimmed
+			current.End = at + len(line)
+			current.lines = append(current.lines, at+len(line)-len(strings.TrimLeft(line, " \t")))
+		}
+	}
+	flush()
+	return paragraphs
+}
+
+// InlineText returns inline markdown as plain text: links become their
+// text, images are dropped, and emphasis and code marks are removed
+func InlineText(text string) string {
+	plain := html.UnescapeString(markupTag.ReplaceAllString(inlineHTML(text), ""))
+	return strings.Join(strings.Fields(plain), " ")
+}
@@ -0,0 +1,94 @@
+package markdown
+
+import "strings"
+
+// Cell is the text of one cell of a table
+type Cell struct {
+	// Text is the cell's inline markdown without the spaces around it
+	Text string
+	// Line is the 1-based line of the cell's row
+	Line int
+	// Start and End are the byte offsets of Text in the document
+	Start, End int
+}
+
+// Rewrite returns the markdown that replaces the document's bytes from
+// c.Start to c.End to give c the text s: one line, with its pipes escaped
+// so they do not split the cell
+func (c Cell) Rewrite(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			b.WriteString(s[i : i+2])
+			i++
+		case s[i] == '|':
+			b.WriteString(`\|`)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// TableCells returns the non-empty cells of the tables in text, row by
+// row in document order. Tables inside code blocks are left out, and so
+// are the divider rows
+func TableCells(text string) []Cell {
+	_, body, _ := SplitFrontMatter(text)
+	first := strings.Count(text[:len(text)-len(body)], "\n")
+	offset := len(text) - len(body)
+
+	var cells []Cell
+	lines := strings.Split(body, "\n")
+	inFence, inTable := false, false
+	for i, raw := range lines {
+		at := offset
+		offset += len(raw) + 1
+		line := strings.TrimSuffix(raw, "\r")
+		switch {
+		case fenceLine.MatchString(line):
+			inFence, inTable = !inFence, false
+		case inFence:
+		case strings.TrimSpace(line) == "":
+			inTable = false
+		case inTable:
+			if !tableDivider.MatchString(line) {
+				cells = append(cells, rowCells(line, at, first+i+1)...)
+			}
+		case i+1 < len(lines) && strings.Contains(line, "|") && tableDivider.MatchString(strings.TrimSuffix(lines[i+1], "\r")):
+			inTable = true
+			cells = append(cells, rowCells(line, at, first+i+1)...)
+		}
+	}
+	return cells
+}
+
+// rowCells splits a table row, which starts at offset at on line n, at its
+// pipes. As in GitHub's tables, only a pipe escaped with a backslash stays
+// in a cell, even in a code span
+func rowCells(row string, at, n int) []Cell {
+	var cells []Cell
+	add := func(start, end int) {
+		cell := row[start:end]
+		trimmed := strings.TrimSpace(cell)
+		if trimmed == "" {
+			return
+		}
+		start += len(cell) - len(strings.TrimLeft(cell, " \t"))
+		cells = append(cells, Cell{Text: trimmed, Line: n, Start: at + start, End: at + start + len(trimmed)})
+	}
+	start := 0
+	for i := 0; i < len(row); i++ {
+		switch row[i] {
+		case '\\':
+			i++
+		case '|':
+			add(start, i)
+			start = i + 1
+		}
+	}
+	add(start, len(row))
+	return cells
+}
@@ -0,0 +1,44 @@
+package markdown
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTableCells(t *testing.T) {
+	doc := "---\ntitle: T\n---\n" +
+		"Intro | not a table\n\n" +
+		"| Name | Notes |\n" +
+		"|------|:-----:|\n" +
+		"| `a \\| b` | Fast *enough* |\n" +
+		"|  | x \\| y |\n\n" +
+		"```\n| a | b |\n|---|---|\n```\n" +
+		"a | b\r\n--|--\r\nc | d\r\n"
+	var got []string
+	for _, c := range TableCells(doc) {
+		if doc[c.Start:c.End] != c.Text {
+			t.Errorf("cell %q is at %q", c.Text, doc[c.Start:c.End])
+		}
+		got = append(got, c.Text)
+	}
+	want := []string{"Name", "Notes", "`a \\| b`", "Fast *enough*", "x \\| y", "a", "b", "c", "d"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TableCells = %q, want %q", got, want)
+	}
+	if cells := TableCells(doc); cells[0].Line != 6 || cells[len(cells)-1].Line != 17 {
+		t.Errorf("lines %d and %d, want 6 and 17", cells[0].Line, cells[len(cells)-1].Line)
+	}
+}
+
+func TestCellRewrite(t *testing.T) {
+	for in, want := range map[string]string{
+		"a | b":        `a \| b`,
+		`a \| b`:       `a \| b`,
+		"two\nlines  ": "two lines",
+		"`x|y`":        "`x\\|y`",
+	} {
+		if got := (Cell{}).Rewrite(in); got != want {
+			t.Errorf("Rewrite(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package markdown
+
+import (
+	"io"
+	"regexp"
+	"strings"
+)
+
+// fenceLine opens or closes a fenced code block
+var fenceLine = regexp.MustCompile("^ {0,3}(```|~~~)")
+
+// Tidy cleans up whitespace outside fenced code blocks: it drops trailing
+// spaces and tabs but keeps a two-space line break, collapses runs of blank
+// lines into one and ends the document with a newline
+func Tidy(r io.Reader) io.Reader {
+	return transformString(r, tidy)
+}
+
+func tidy(text string) string {
+	if text == "" {
+		return text
+	}
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	out := make([]string, 0, len(lines))
+	inFence, blank := false, 0
+	for _, line := range lines {
+		if fenceLine.MatchString(line) {
+			inFence = !inFence
+			blank = 0
+		} else if inFence {
+			out = append(out, line)
+			continue
+		}
+
+		if trimmed := strings.TrimRight(line, " \t"); line[len(trimmed):] != "  " || trimmed == "" {
+			line = trimmed
+		}
+		if line == "" {
+			if blank++; blank > 1 {
+				continue
+			}
+		} else {
+			blank = 0
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n") + "\n"
+}
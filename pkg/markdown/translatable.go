@@ -0,0 +1,265 @@
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Translation patterns: a bare URL and the parts of a tag Translatable reads
+// back
+var (
+	bareURL     = regexp.MustCompile(`^https?://[^\s<>()\[\]]*[^\s<>()\[\].,;:!?'"]`)
+	anyTag      = regexp.MustCompile(`^<(/?)([A-Za-z][A-Za-z0-9-]*)([^<>]*?)/?>`)
+	tagAttr     = regexp.MustCompile(`([A-Za-z-]+)\s*=\s*"([^"]*)"`)
+	spanClosing = regexp.MustCompile(`(?i)</span\s*>`)
+)
+
+// Translatable is inline markdown made ready for a translation service
+// that takes HTML. Emphasis becomes tags and link text stays translatable,
+// while code spans, link targets, images, URLs, escapes, entities and
+// inline HTML go in translate="no" spans to come back as they were
+type Translatable struct {
+	// HTML is what to send for translation
+	HTML string
+
+	kept  []string
+	links []string
+}
+
+// NewTranslatable prepares text, a paragraph's inline markdown, for
+// translation
+func NewTranslatable(text string) *Translatable {
+	t := new(Translatable)
+	t.HTML = t.encode(text)
+	return t
+}
+
+// keep puts s in a span the translation leaves alone
+func (t *Translatable) keep(s string) string {
+	t.kept = append(t.kept, s)
+	return fmt.Sprintf(`<span class="notranslate" translate="no" id="k%d">%s</span>`, len(t.kept)-1, html.EscapeString(s))
+}
+
+func (t *Translatable) encode(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		c := text[i]
+		rest := text[i:]
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte("\n\\`*_{}[]()#+-.!|~<>\"'", text[i+1]) >= 0:
+			b.WriteString(t.keep(rest[:2]))
+			i += 2
+		case c == ' ' && strings.HasPrefix(rest, "  \n"):
+			b.WriteString(t.keep(rest[:3]))
+			i += 3
+
+		case c == '`':
+			n := len(rest) - len(strings.TrimLeft(rest, "`"))
+			end := closingTicks(rest[n:], n)
+			if end < 0 {
+				b.WriteString(rest[:n])
+				i += n
+				continue
+			}
+			b.WriteString(t.keep(rest[:n+end+n]))
+			i += n + end + n
+
+		case c == '!' && strings.HasPrefix(rest, "!["):
+			if _, _, _, n, ok := parseInlineLink(rest[1:]); ok {
+				b.WriteString(t.keep(rest[:1+n]))
+				i += 1 + n
+				continue
+			}
+			b.WriteString("!")
+			i++
+
+		case c == '[':
+			if label, _, _, n, ok := parseInlineLink(rest); ok {
+				// The link's text is translated and its target kept
+				t.links = append(t.links, rest[1+len(label)+1:n])
+				fmt.Fprintf(&b, `<a id="l%d">%s</a>`, len(t.links)-1, t.encode(label))
+				i += n
+				continue
+			}
+			b.WriteString("[")
+			i++
+
+		case c == '<':
+			if m := autolink.FindString(rest); m != "" {
+				b.WriteString(t.keep(m))
+				i += len(m)
+			} else if tag := inlineTag.FindString(rest); tag != "" {
+				b.WriteString(t.keep(tag))
+				i += len(tag)
+			} else {
+				b.WriteString("&lt;")
+				i++
+			}
+
+		case c == '&':
+			if e := entity.FindString(rest); e != "" {
+				b.WriteString(t.keep(e))
+				i += len(e)
+			} else {
+				b.WriteString("&amp;")
+				i++
+			}
+
+		case c == 'h' && (i == 0 || !isWordByte(text[i-1])) && bareURL.MatchString(rest):
+			u := bareURL.FindString(rest)
+			b.WriteString(t.keep(u))
+			i += len(u)
+
+		case c == '*' || c == '_' || c == '~' && strings.HasPrefix(rest, "~~"):
+			if run, n := emphasisSpan(text, i); n > 0 {
+				tag := "strong"
+				switch {
+				case c == '~':
+					tag = "del"
+				case run == 1:
+					tag = "em"
+				}
+				fmt.Fprintf(&b, `<%s data-md="%s">%s</%s>`, tag, rest[:run], t.encode(rest[run:n-run]), tag)
+				i += n
+				continue
+			}
+			n := len(rest) - len(strings.TrimLeft(rest, string(c)))
+			b.WriteString(rest[:n])
+			i += n
+
+		case c == '\n':
+			// Lines are rewrapped, as the translation has its own length
+			b.WriteByte(' ')
+			i++
+
+		default:
+			b.WriteString(html.EscapeString(rest[:1]))
+			i++
+		}
+	}
+	return b.String()
+}
+
+// Markdown turns the translation of t.HTML back into markdown. It fails
+// when the translation lost a part that was kept out of it
+func (t *Translatable) Markdown(translated string) (string, error) {
+	var out []byte
+	// pending are the openings of emphasis and links written only when
+	// their text starts, so spaces the translation put inside go outside
+	var pending []string
+	var closers []string
+	used := make([]bool, len(t.kept))
+
+	flush := func() {
+		for _, p := range pending {
+			out = append(out, p...)
+		}
+		pending = pending[:0]
+	}
+	text := func(s string) {
+		if s == "" {
+			return
+		}
+		if len(pending) > 0 {
+			trimmed := strings.TrimLeft(s, " \t\n")
+			if len(out) > 0 && strings.IndexByte(" \t\n", out[len(out)-1]) < 0 {
+				out = append(out, s[:len(s)-len(trimmed)]...)
+			}
+			s = trimmed
+			if s == "" {
+				return
+			}
+			flush()
+		}
+		out = append(out, s...)
+	}
+	closing := func(closer string) {
+		if len(pending) > 0 {
+			// Nothing between the opening and here
+			pending = pending[:len(pending)-1]
+			return
+		}
+		trimmed := strings.TrimRight(string(out), " \t\n")
+		spaces := string(out[len(trimmed):])
+		out = append([]byte(trimmed), closer...)
+		out = append(out, spaces...)
+	}
+
+	for s := translated; s != ""; {
+		lt := strings.IndexByte(s, '<')
+		if lt < 0 {
+			text(html.UnescapeString(s))
+			break
+		}
+		text(html.UnescapeString(s[:lt]))
+		s = s[lt:]
+		m := anyTag.FindStringSubmatch(s)
+		if m == nil {
+			text("<")
+			s = s[1:]
+			continue
+		}
+		s = s[len(m[0]):]
+		end, name := m[1] == "/", strings.ToLower(m[2])
+		attrs := map[string]string{}
+		for _, a := range tagAttr.FindAllStringSubmatch(m[3], -1) {
+			attrs[strings.ToLower(a[1])] = html.UnescapeString(a[2])
+		}
+		id := attrs["id"]
+		switch {
+		case name == "span" && !end && strings.HasPrefix(id, "k"):
+			n, err := strconv.Atoi(id[1:])
+			if err != nil || n >= len(t.kept) {
+				return "", fmt.Errorf("the translation has an unknown part %q", id)
+			}
+			if loc := spanClosing.FindStringIndex(s); loc != nil {
+				s = s[loc[1]:]
+			}
+			flush()
+			out = append(out, t.kept[n]...)
+			used[n] = true
+		case name == "a" && !end && strings.HasPrefix(id, "l"):
+			n, err := strconv.Atoi(id[1:])
+			if err != nil || n >= len(t.links) {
+				return "", fmt.Errorf("the translation has an unknown link %q", id)
+			}
+			pending = append(pending, "[")
+			closers = append(closers, "]"+t.links[n])
+		case (name == "em" || name == "strong" || name == "del") && !end:
+			marker := attrs["data-md"]
+			if marker == "" {
+				marker = map[string]string{"em": "*", "strong": "**", "del": "~~"}[name]
+			}
+			pending = append(pending, marker)
+			closers = append(closers, marker)
+		case (name == "a" || name == "em" || name == "strong" || name == "del") && end:
+			if len(closers) == 0 {
+				continue
+			}
+			closing(closers[len(closers)-1])
+			closers = closers[:len(closers)-1]
+		case name == "span" || name == "br":
+			// The translation's own markup carries nothing to keep
+		default:
+			text(m[0])
+		}
+	}
+	for len(closers) > 0 {
+		closing(closers[len(closers)-1])
+		closers = closers[:len(closers)-1]
+	}
+
+	lost := 0
+	for _, u := range used {
+		if !u {
+			lost++
+		}
+	}
+	if lost > 0 {
+		return "", fmt.Errorf("the translation lost %d parts kept out of it, such as code or URLs", lost)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,167 @@
+// Package transformclient calls the gRPC service goodness serve grpc runs,
+// so pipelines in other programs can use the goodness transforms without
+// shelling out. Images stream in both directions, so large files never
+// have to fit in one message
+package transformclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	pb "GoodnessucWorkflow/proto/goodness/v1"
+)
+
+// chunkSize is how much of an image each message carries
+const chunkSize = 64 << 10
+
+// Client is a connection to a goodness gRPC server
+type Client struct {
+	conn *grpc.ClientConn
+	rpc  pb.TransformServiceClient
+}
+
+// Option configures a Client
+type Option func(*settings)
+
+type settings struct {
+	token string
+	tls   *tls.Config
+}
+
+// WithToken sends token as a bearer token on every call
+func WithToken(token string) Option {
+	return func(s *settings) { s.token = token }
+}
+
+// WithTLS connects over TLS with cfg rather than in plain text
+func WithTLS(cfg *tls.Config) Option {
+	return func(s *settings) { s.tls = cfg }
+}
+
+// New connects to the server at addr, such as 127.0.0.1:8091. The
+// connection is made lazily on the first call
+func New(addr string, opts ...Option) (*Client, error) {
+	var s settings
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if s.tls != nil {
+		dialOpts[0] = grpc.WithTransportCredentials(credentials.NewTLS(s.tls))
+	}
+	if s.token != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(bearer{token: s.token, secure: s.tls != nil}))
+	}
+	conn, err := grpc.NewClient(addr, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, rpc: pb.NewTransformServiceClient(conn)}, nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// TransformMarkdown runs transforms, such as bold, tidy or plugin:NAME, over
+// content; options go to plugins
+func (c *Client) TransformMarkdown(ctx context.Context, content string, transforms []string, options map[string]string) (string, error) {
+	resp, err := c.rpc.TransformMarkdown(ctx, &pb.TransformMarkdownRequest{Content: content, Transforms: transforms, Options: options})
+	if err != nil {
+		return "", err
+	}
+	return resp.GetContent(), nil
+}
+
+// ConvertImage streams the image in src to the server and the converted
+// image to dst, returning its format, quality and size
+func (c *Client) ConvertImage(ctx context.Context, src io.Reader, opts *pb.ConvertImageOptions, dst io.Writer) (*pb.ImageInfo, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.rpc.ConvertImage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		opts = &pb.ConvertImageOptions{}
+	}
+	if err := stream.Send(&pb.ConvertImageRequest{Part: &pb.ConvertImageRequest_Options{Options: opts}}); err != nil {
+		return nil, recvError(stream, err)
+	}
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			chunk := append([]byte(nil), buf[:n]...)
+			if err := stream.Send(&pb.ConvertImageRequest{Part: &pb.ConvertImageRequest_Chunk{Chunk: chunk}}); err != nil {
+				return nil, recvError(stream, err)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	first, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	info := first.GetInfo()
+	if info == nil {
+		return nil, errors.New("server sent image data before its details")
+	}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return info, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := dst.Write(resp.GetChunk()); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// recvError replaces the io.EOF a send returns once the server has ended
+// the call with the status the server ended it with
+func recvError(stream pb.TransformService_ConvertImageClient, err error) error {
+	if err != io.EOF {
+		return err
+	}
+	for {
+		if _, err := stream.Recv(); err != nil {
+			return err
+		}
+	}
+}
+
+// bearer sends the token as authorization metadata
+type bearer struct {
+	token  string
+	secure bool
+}
+
+func (b bearer) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + b.token}, nil
+}
+
+func (b bearer) RequireTransportSecurity() bool {
+	return b.secure
+}
@@ -0,0 +1,220 @@
+// Package plugin runs user transforms as external programs, so markdown and
+// image steps can be added to goodness without forking it. A plugin called
+// NAME is an executable named goodness-plugin-NAME in the plugins folder
+// next to the global configuration file or on PATH. For each file it reads
+// one JSON Request on stdin and writes one JSON Response to stdout:
+//
+//	{"version": 1, "kind": "markdown", "path": "posts/a.md",
+//	 "options": {"style": "medium"}, "content": "# Title\n..."}
+//	{"content": "# Title\n..."}
+//
+// Image requests carry base64 data and its format instead of content, and
+// the response may change the format. A response with an error, or a
+// non-zero exit status, fails the file
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+
+	"GoodnessucWorkflow/internal/config"
+	"GoodnessucWorkflow/pkg/markdown"
+)
+
+// Version is the protocol version sent with every request
+const Version = 1
+
+// Prefix starts the executable name of every plugin
+const Prefix = "goodness-plugin-"
+
+// Request is what a plugin reads on stdin
+type Request struct {
+	Version int               `json:"version"`
+	Kind    string            `json:"kind"`
+	Path    string            `json:"path,omitempty"`
+	Options map[string]string `json:"options,omitempty"`
+	// Content is the markdown document for markdown requests
+	Content string `json:"content,omitempty"`
+	// Data and Format are the encoded image for image requests
+	Data   []byte `json:"data,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
+// Response is what a plugin writes to stdout
+type Response struct {
+	Content string `json:"content,omitempty"`
+	Data    []byte `json:"data,omitempty"`
+	Format  string `json:"format,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Dir is the plugins folder next to the global configuration file, which
+// is searched before PATH
+func Dir() (string, error) {
+	global, err := config.GlobalPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(global), "plugins"), nil
+}
+
+// Find returns the executable of the plugin called name
+func Find(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid plugin name %q", name)
+	}
+	if dir, err := Dir(); err == nil {
+		path := filepath.Join(dir, Prefix+name)
+		if path, err := exec.LookPath(path); err == nil {
+			return path, nil
+		}
+	}
+	path, err := exec.LookPath(Prefix + name)
+	if err != nil {
+		return "", fmt.Errorf("no plugin %q: put %s%s in %s or on PATH", name, Prefix, name, dirOrDefault())
+	}
+	return path, nil
+}
+
+func dirOrDefault() string {
+	if dir, err := Dir(); err == nil {
+		return dir
+	}
+	return "the plugins folder"
+}
+
+// List returns the installed plugins by name, with the executable each name
+// resolves to
+func List() map[string]string {
+	dirs := filepath.SplitList(os.Getenv("PATH"))
+	if dir, err := Dir(); err == nil {
+		dirs = append([]string{dir}, dirs...)
+	}
+
+	plugins := make(map[string]string)
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name, ok := strings.CutPrefix(e.Name(), Prefix)
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+			if !ok || name == "" || e.IsDir() {
+				continue
+			}
+			if _, seen := plugins[name]; seen {
+				continue
+			}
+			if path, err := exec.LookPath(filepath.Join(dir, e.Name())); err == nil {
+				plugins[name] = path
+			}
+		}
+	}
+	return plugins
+}
+
+// Names returns the keys of plugins in order
+func Names(plugins map[string]string) []string {
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Call sends req to the plugin called name and returns its response
+func Call(ctx context.Context, name string, req Request) (*Response, error) {
+	path, err := Find(name)
+	if err != nil {
+		return nil, err
+	}
+	req.Version = Version
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("plugin %s: %s: %s", name, err, msg)
+		}
+		return nil, fmt.Errorf("plugin %s: %s", name, err)
+	}
+
+	resp := &Response{}
+	if err := json.Unmarshal(stdout.Bytes(), resp); err != nil {
+		return nil, fmt.Errorf("plugin %s: invalid response: %s", name, err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("plugin %s: %s", name, resp.Error)
+	}
+	return resp, nil
+}
+
+// Markdown returns a Transform that hands the document to the plugin
+// called name
+func Markdown(ctx context.Context, name string, options map[string]string) markdown.Transform {
+	return func(r io.Reader) io.Reader {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return errReader{err}
+		}
+		resp, err := Call(ctx, name, Request{Kind: "markdown", Options: options, Content: string(data)})
+		if err != nil {
+			return errReader{err}
+		}
+		return strings.NewReader(resp.Content)
+	}
+}
+
+// Image hands an encoded image to the plugin called name and returns the
+// image it sends back with its format, which may differ from the input's
+func Image(ctx context.Context, name string, options map[string]string, path string, data []byte) ([]byte, string, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := Call(ctx, name, Request{Kind: "image", Path: path, Options: options, Data: data, Format: format})
+	if err != nil {
+		return nil, "", err
+	}
+	if len(resp.Data) == 0 {
+		return nil, "", errors.New("plugin " + name + ": response has no image data")
+	}
+	if resp.Format == "" {
+		resp.Format = format
+	}
+	return resp.Data, resp.Format, nil
+}
+
+// errReader fails every read with err
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
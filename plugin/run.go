@@ -0,0 +1,215 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+)
+
+// kinds maps file extensions to the kind of request sent for them
+var kinds = map[string]string{
+	".md":       "markdown",
+	".markdown": "markdown",
+	".png":      "image",
+	".jpg":      "image",
+	".jpeg":     "image",
+	".gif":      "image",
+	".webp":     "image",
+}
+
+// extensions are the file extensions written for image formats a plugin
+// returns
+var extensions = map[string]string{
+	"png":  ".png",
+	"jpeg": ".jpg",
+	"gif":  ".gif",
+	"webp": ".webp",
+}
+
+// Commands are the plugin subcommands
+func Commands() []cli.Command {
+	return []cli.Command{
+		{Name: "list", Summary: "list installed plugins", Run: runList},
+		{Name: "run", Summary: "run a plugin over markdown and image files", Run: runPlugin},
+	}
+}
+
+// Run is the plugin command
+func Run(args []string) {
+	cli.Dispatch("goodness plugin", Commands(), args)
+}
+
+func runList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	logging.AddFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.Sprintf("Usage: goodness plugin list\n\nLists plugins found in %s and on PATH.\n\n", dirOrDefault()))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	plugins := List()
+	output.Set("plugins", plugins)
+	if len(plugins) == 0 {
+		fmt.Printf("No plugins installed; add %sNAME executables to %s or PATH\n", Prefix, dirOrDefault())
+		output.Exit(exitcode.NoMatch)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tPATH")
+	for _, name := range Names(plugins) {
+		fmt.Fprintf(tw, "%s\t%s\n", name, plugins[name])
+	}
+	tw.Flush()
+}
+
+func runPlugin(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	logging.AddFlags(fs)
+	kind := fs.String("kind", "all", "which files to send: markdown, image or all")
+	timeout := fs.Duration("timeout", time.Minute, "time a plugin may take for one file")
+	options := make(map[string]string)
+	fs.Func("set", "pass an option to the plugin as NAME=VALUE; repeat for each option", func(value string) error {
+		name, v, ok := strings.Cut(value, "=")
+		if !ok || name == "" {
+			return errors.New("expected NAME=VALUE")
+		}
+		options[name] = v
+		return nil
+	})
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness plugin run [flags] NAME [file|directory ...]\n\nSends each markdown and image file to the plugin and writes back what it\nreturns. Images the plugin returns in another format are written next to\nthe original with that format's extension.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if *kind != "all" && *kind != "markdown" && *kind != "image" {
+		logging.Exitf(exitcode.Usage, "Invalid -kind value %q", *kind)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	name := fs.Arg(0)
+	if _, err := Find(name); err != nil {
+		logging.Exitf(exitcode.Usage, "%s", err)
+	}
+	inputs := fs.Args()[1:]
+	if len(inputs) == 0 {
+		inputs = []string{"."}
+	}
+
+	succeeded, failed := 0, 0
+	for _, input := range inputs {
+		err := filepath.WalkDir(input, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if path != input && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			k := kinds[strings.ToLower(filepath.Ext(path))]
+			if k == "" || *kind != "all" && k != *kind {
+				if path == input {
+					logging.Errorf("Failed to run plugin on %s: not a markdown or image file", path)
+					failed++
+				}
+				return nil
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			defer cancel()
+			if err := apply(ctx, name, k, path, options); err != nil {
+				logging.Errorf("Failed to run plugin on %s: %s", path, err)
+				output.Processed(path, "failed", "plugin", err)
+				failed++
+				return nil
+			}
+			output.Processed(path, "ok", "", nil)
+			succeeded++
+			return nil
+		})
+		if err != nil {
+			logging.Fatalf("Error processing directory: %s", err)
+		}
+	}
+
+	fmt.Printf("%d succeeded, %d failed\n", succeeded, failed)
+	switch {
+	case failed > 0:
+		output.Exit(exitcode.Failures)
+	case succeeded == 0:
+		output.Exit(exitcode.NoMatch)
+	}
+}
+
+// apply runs the plugin on one file and writes back its result when it
+// changed anything
+func apply(ctx context.Context, name, kind, path string, options map[string]string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	out, target := data, path
+	if kind == "markdown" {
+		resp, err := Call(ctx, name, Request{Kind: kind, Path: path, Options: options, Content: string(data)})
+		if err != nil {
+			return err
+		}
+		out = []byte(resp.Content)
+	} else {
+		var format string
+		if out, format, err = Image(ctx, name, options, path, data); err != nil {
+			return err
+		}
+		ext, ok := extensions[format]
+		if !ok {
+			return fmt.Errorf("plugin %s returned unsupported format %q", name, format)
+		}
+		if !sameFormat(path, ext) {
+			target = strings.TrimSuffix(path, filepath.Ext(path)) + ext
+		}
+	}
+
+	if target == path && string(out) == string(data) {
+		logging.Debugf("Unchanged: %s", path)
+		return nil
+	}
+	if err := journal.WriteFile(target, out, info.Mode().Perm()); err != nil {
+		return err
+	}
+	output.Wrote(target)
+	logging.Infof("Output written: %s", target)
+	return nil
+}
+
+// sameFormat reports whether path already has ext, counting .jpeg as .jpg
+func sameFormat(path, ext string) bool {
+	have := strings.ToLower(filepath.Ext(path))
+	if have == ".jpeg" {
+		have = ".jpg"
+	}
+	return have == ext
+}
@@ -0,0 +1,547 @@
+// The transformation core of goodness as a gRPC service, served by
+// goodness serve grpc. Regenerate the Go code after editing with
+//
+//	protoc --go_out=. --go_opt=module=GoodnessucWorkflow \
+//	  --go-grpc_out=. --go-grpc_opt=module=GoodnessucWorkflow \
+//	  proto/goodness/v1/transform.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        v5.29.3
+// source: proto/goodness/v1/transform.proto
+
+package goodnessv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type TransformMarkdownRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Content string                 `protobuf:"bytes,1,opt,name=content,proto3" json:"content,omitempty"`
+	// Transforms run in order: bold, tidy, or plugin:NAME; the default is bold
+	Transforms []string `protobuf:"bytes,2,rep,name=transforms,proto3" json:"transforms,omitempty"`
+	// Options are passed to plugins
+	Options       map[string]string `protobuf:"bytes,3,rep,name=options,proto3" json:"options,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransformMarkdownRequest) Reset() {
+	*x = TransformMarkdownRequest{}
+	mi := &file_proto_goodness_v1_transform_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransformMarkdownRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransformMarkdownRequest) ProtoMessage() {}
+
+func (x *TransformMarkdownRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_goodness_v1_transform_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransformMarkdownRequest.ProtoReflect.Descriptor instead.
+func (*TransformMarkdownRequest) Descriptor() ([]byte, []int) {
+	return file_proto_goodness_v1_transform_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *TransformMarkdownRequest) GetContent() string {
+	if x != nil {
+		return x.Content
+	}
+	return ""
+}
+
+func (x *TransformMarkdownRequest) GetTransforms() []string {
+	if x != nil {
+		return x.Transforms
+	}
+	return nil
+}
+
+func (x *TransformMarkdownRequest) GetOptions() map[string]string {
+	if x != nil {
+		return x.Options
+	}
+	return nil
+}
+
+type TransformMarkdownResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Content       string                 `protobuf:"bytes,1,opt,name=content,proto3" json:"content,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransformMarkdownResponse) Reset() {
+	*x = TransformMarkdownResponse{}
+	mi := &file_proto_goodness_v1_transform_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransformMarkdownResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransformMarkdownResponse) ProtoMessage() {}
+
+func (x *TransformMarkdownResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_goodness_v1_transform_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransformMarkdownResponse.ProtoReflect.Descriptor instead.
+func (*TransformMarkdownResponse) Descriptor() ([]byte, []int) {
+	return file_proto_goodness_v1_transform_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *TransformMarkdownResponse) GetContent() string {
+	if x != nil {
+		return x.Content
+	}
+	return ""
+}
+
+type ConvertImageOptions struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Format is jpeg, png, gif, webp or auto; the default is jpeg
+	Format string `protobuf:"bytes,1,opt,name=format,proto3" json:"format,omitempty"`
+	// Quality is the JPEG or WebP quality; zero keeps the encoder default
+	Quality int32 `protobuf:"varint,2,opt,name=quality,proto3" json:"quality,omitempty"`
+	// Width and height bound the result, keeping the aspect ratio; zero
+	// leaves a side unbounded
+	Width  int32 `protobuf:"varint,3,opt,name=width,proto3" json:"width,omitempty"`
+	Height int32 `protobuf:"varint,4,opt,name=height,proto3" json:"height,omitempty"`
+	// Scale multiplies the intrinsic size of an SVG
+	Scale         float64 `protobuf:"fixed64,5,opt,name=scale,proto3" json:"scale,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConvertImageOptions) Reset() {
+	*x = ConvertImageOptions{}
+	mi := &file_proto_goodness_v1_transform_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConvertImageOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConvertImageOptions) ProtoMessage() {}
+
+func (x *ConvertImageOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_goodness_v1_transform_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConvertImageOptions.ProtoReflect.Descriptor instead.
+func (*ConvertImageOptions) Descriptor() ([]byte, []int) {
+	return file_proto_goodness_v1_transform_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ConvertImageOptions) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *ConvertImageOptions) GetQuality() int32 {
+	if x != nil {
+		return x.Quality
+	}
+	return 0
+}
+
+func (x *ConvertImageOptions) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *ConvertImageOptions) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *ConvertImageOptions) GetScale() float64 {
+	if x != nil {
+		return x.Scale
+	}
+	return 0
+}
+
+type ConvertImageRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Part:
+	//
+	//	*ConvertImageRequest_Options
+	//	*ConvertImageRequest_Chunk
+	Part          isConvertImageRequest_Part `protobuf_oneof:"part"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConvertImageRequest) Reset() {
+	*x = ConvertImageRequest{}
+	mi := &file_proto_goodness_v1_transform_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConvertImageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConvertImageRequest) ProtoMessage() {}
+
+func (x *ConvertImageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_goodness_v1_transform_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConvertImageRequest.ProtoReflect.Descriptor instead.
+func (*ConvertImageRequest) Descriptor() ([]byte, []int) {
+	return file_proto_goodness_v1_transform_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ConvertImageRequest) GetPart() isConvertImageRequest_Part {
+	if x != nil {
+		return x.Part
+	}
+	return nil
+}
+
+func (x *ConvertImageRequest) GetOptions() *ConvertImageOptions {
+	if x != nil {
+		if x, ok := x.Part.(*ConvertImageRequest_Options); ok {
+			return x.Options
+		}
+	}
+	return nil
+}
+
+func (x *ConvertImageRequest) GetChunk() []byte {
+	if x != nil {
+		if x, ok := x.Part.(*ConvertImageRequest_Chunk); ok {
+			return x.Chunk
+		}
+	}
+	return nil
+}
+
+type isConvertImageRequest_Part interface {
+	isConvertImageRequest_Part()
+}
+
+type ConvertImageRequest_Options struct {
+	Options *ConvertImageOptions `protobuf:"bytes,1,opt,name=options,proto3,oneof"`
+}
+
+type ConvertImageRequest_Chunk struct {
+	Chunk []byte `protobuf:"bytes,2,opt,name=chunk,proto3,oneof"`
+}
+
+func (*ConvertImageRequest_Options) isConvertImageRequest_Part() {}
+
+func (*ConvertImageRequest_Chunk) isConvertImageRequest_Part() {}
+
+type ImageInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Format        string                 `protobuf:"bytes,1,opt,name=format,proto3" json:"format,omitempty"`
+	Quality       int32                  `protobuf:"varint,2,opt,name=quality,proto3" json:"quality,omitempty"`
+	Size          int64                  `protobuf:"varint,3,opt,name=size,proto3" json:"size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ImageInfo) Reset() {
+	*x = ImageInfo{}
+	mi := &file_proto_goodness_v1_transform_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ImageInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImageInfo) ProtoMessage() {}
+
+func (x *ImageInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_goodness_v1_transform_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImageInfo.ProtoReflect.Descriptor instead.
+func (*ImageInfo) Descriptor() ([]byte, []int) {
+	return file_proto_goodness_v1_transform_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ImageInfo) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *ImageInfo) GetQuality() int32 {
+	if x != nil {
+		return x.Quality
+	}
+	return 0
+}
+
+func (x *ImageInfo) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+type ConvertImageResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Part:
+	//
+	//	*ConvertImageResponse_Info
+	//	*ConvertImageResponse_Chunk
+	Part          isConvertImageResponse_Part `protobuf_oneof:"part"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConvertImageResponse) Reset() {
+	*x = ConvertImageResponse{}
+	mi := &file_proto_goodness_v1_transform_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConvertImageResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConvertImageResponse) ProtoMessage() {}
+
+func (x *ConvertImageResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_goodness_v1_transform_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConvertImageResponse.ProtoReflect.Descriptor instead.
+func (*ConvertImageResponse) Descriptor() ([]byte, []int) {
+	return file_proto_goodness_v1_transform_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ConvertImageResponse) GetPart() isConvertImageResponse_Part {
+	if x != nil {
+		return x.Part
+	}
+	return nil
+}
+
+func (x *ConvertImageResponse) GetInfo() *ImageInfo {
+	if x != nil {
+		if x, ok := x.Part.(*ConvertImageResponse_Info); ok {
+			return x.Info
+		}
+	}
+	return nil
+}
+
+func (x *ConvertImageResponse) GetChunk() []byte {
+	if x != nil {
+		if x, ok := x.Part.(*ConvertImageResponse_Chunk); ok {
+			return x.Chunk
+		}
+	}
+	return nil
+}
+
+type isConvertImageResponse_Part interface {
+	isConvertImageResponse_Part()
+}
+
+type ConvertImageResponse_Info struct {
+	Info *ImageInfo `protobuf:"bytes,1,opt,name=info,proto3,oneof"`
+}
+
+type ConvertImageResponse_Chunk struct {
+	Chunk []byte `protobuf:"bytes,2,opt,name=chunk,proto3,oneof"`
+}
+
+func (*ConvertImageResponse_Info) isConvertImageResponse_Part() {}
+
+func (*ConvertImageResponse_Chunk) isConvertImageResponse_Part() {}
+
+var File_proto_goodness_v1_transform_proto protoreflect.FileDescriptor
+
+const file_proto_goodness_v1_transform_proto_rawDesc = "" +
+	"\n" +
+	"!proto/goodness/v1/transform.proto\x12\vgoodness.v1\"\xde\x01\n" +
+	"\x18TransformMarkdownRequest\x12\x18\n" +
+	"\acontent\x18\x01 \x01(\tR\acontent\x12\x1e\n" +
+	"\n" +
+	"transforms\x18\x02 \x03(\tR\n" +
+	"transforms\x12L\n" +
+	"\aoptions\x18\x03 \x03(\v22.goodness.v1.TransformMarkdownRequest.OptionsEntryR\aoptions\x1a:\n" +
+	"\fOptionsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"5\n" +
+	"\x19TransformMarkdownResponse\x12\x18\n" +
+	"\acontent\x18\x01 \x01(\tR\acontent\"\x8b\x01\n" +
+	"\x13ConvertImageOptions\x12\x16\n" +
+	"\x06format\x18\x01 \x01(\tR\x06format\x12\x18\n" +
+	"\aquality\x18\x02 \x01(\x05R\aquality\x12\x14\n" +
+	"\x05width\x18\x03 \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\x04 \x01(\x05R\x06height\x12\x14\n" +
+	"\x05scale\x18\x05 \x01(\x01R\x05scale\"s\n" +
+	"\x13ConvertImageRequest\x12<\n" +
+	"\aoptions\x18\x01 \x01(\v2 .goodness.v1.ConvertImageOptionsH\x00R\aoptions\x12\x16\n" +
+	"\x05chunk\x18\x02 \x01(\fH\x00R\x05chunkB\x06\n" +
+	"\x04part\"Q\n" +
+	"\tImageInfo\x12\x16\n" +
+	"\x06format\x18\x01 \x01(\tR\x06format\x12\x18\n" +
+	"\aquality\x18\x02 \x01(\x05R\aquality\x12\x12\n" +
+	"\x04size\x18\x03 \x01(\x03R\x04size\"d\n" +
+	"\x14ConvertImageResponse\x12,\n" +
+	"\x04info\x18\x01 \x01(\v2\x16.goodness.v1.ImageInfoH\x00R\x04info\x12\x16\n" +
+	"\x05chunk\x18\x02 \x01(\fH\x00R\x05chunkB\x06\n" +
+	"\x04part2\xcf\x01\n" +
+	"\x10TransformService\x12b\n" +
+	"\x11TransformMarkdown\x12%.goodness.v1.TransformMarkdownRequest\x1a&.goodness.v1.TransformMarkdownResponse\x12W\n" +
+	"\fConvertImage\x12 .goodness.v1.ConvertImageRequest\x1a!.goodness.v1.ConvertImageResponse(\x010\x01B1Z/GoodnessucWorkflow/proto/goodness/v1;goodnessv1b\x06proto3"
+
+var (
+	file_proto_goodness_v1_transform_proto_rawDescOnce sync.Once
+	file_proto_goodness_v1_transform_proto_rawDescData []byte
+)
+
+func file_proto_goodness_v1_transform_proto_rawDescGZIP() []byte {
+	file_proto_goodness_v1_transform_proto_rawDescOnce.Do(func() {
+		file_proto_goodness_v1_transform_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_goodness_v1_transform_proto_rawDesc), len(file_proto_goodness_v1_transform_proto_rawDesc)))
+	})
+	return file_proto_goodness_v1_transform_proto_rawDescData
+}
+
+var file_proto_goodness_v1_transform_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_proto_goodness_v1_transform_proto_goTypes = []any{
+	(*TransformMarkdownRequest)(nil),  // 0: goodness.v1.TransformMarkdownRequest
+	(*TransformMarkdownResponse)(nil), // 1: goodness.v1.TransformMarkdownResponse
+	(*ConvertImageOptions)(nil),       // 2: goodness.v1.ConvertImageOptions
+	(*ConvertImageRequest)(nil),       // 3: goodness.v1.ConvertImageRequest
+	(*ImageInfo)(nil),                 // 4: goodness.v1.ImageInfo
+	(*ConvertImageResponse)(nil),      // 5: goodness.v1.ConvertImageResponse
+	nil,                               // 6: goodness.v1.TransformMarkdownRequest.OptionsEntry
+}
+var file_proto_goodness_v1_transform_proto_depIdxs = []int32{
+	6, // 0: goodness.v1.TransformMarkdownRequest.options:type_name -> goodness.v1.TransformMarkdownRequest.OptionsEntry
+	2, // 1: goodness.v1.ConvertImageRequest.options:type_name -> goodness.v1.ConvertImageOptions
+	4, // 2: goodness.v1.ConvertImageResponse.info:type_name -> goodness.v1.ImageInfo
+	0, // 3: goodness.v1.TransformService.TransformMarkdown:input_type -> goodness.v1.TransformMarkdownRequest
+	3, // 4: goodness.v1.TransformService.ConvertImage:input_type -> goodness.v1.ConvertImageRequest
+	1, // 5: goodness.v1.TransformService.TransformMarkdown:output_type -> goodness.v1.TransformMarkdownResponse
+	5, // 6: goodness.v1.TransformService.ConvertImage:output_type -> goodness.v1.ConvertImageResponse
+	5, // [5:7] is the sub-list for method output_type
+	3, // [3:5] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_proto_goodness_v1_transform_proto_init() }
+func file_proto_goodness_v1_transform_proto_init() {
+	if File_proto_goodness_v1_transform_proto != nil {
+		return
+	}
+	file_proto_goodness_v1_transform_proto_msgTypes[3].OneofWrappers = []any{
+		(*ConvertImageRequest_Options)(nil),
+		(*ConvertImageRequest_Chunk)(nil),
+	}
+	file_proto_goodness_v1_transform_proto_msgTypes[5].OneofWrappers = []any{
+		(*ConvertImageResponse_Info)(nil),
+		(*ConvertImageResponse_Chunk)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_goodness_v1_transform_proto_rawDesc), len(file_proto_goodness_v1_transform_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proto_goodness_v1_transform_proto_goTypes,
+		DependencyIndexes: file_proto_goodness_v1_transform_proto_depIdxs,
+		MessageInfos:      file_proto_goodness_v1_transform_proto_msgTypes,
+	}.Build()
+	File_proto_goodness_v1_transform_proto = out.File
+	file_proto_goodness_v1_transform_proto_goTypes = nil
+	file_proto_goodness_v1_transform_proto_depIdxs = nil
+}
@@ -0,0 +1,179 @@
+// The transformation core of goodness as a gRPC service, served by
+// goodness serve grpc. Regenerate the Go code after editing with
+//
+//	protoc --go_out=. --go_opt=module=GoodnessucWorkflow \
+//	  --go-grpc_out=. --go-grpc_opt=module=GoodnessucWorkflow \
+//	  proto/goodness/v1/transform.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: proto/goodness/v1/transform.proto
+
+package goodnessv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	TransformService_TransformMarkdown_FullMethodName = "/goodness.v1.TransformService/TransformMarkdown"
+	TransformService_ConvertImage_FullMethodName      = "/goodness.v1.TransformService/ConvertImage"
+)
+
+// TransformServiceClient is the client API for TransformService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// TransformService runs the markdown and image transforms of goodness.
+// When the server has a token, every call must send the metadata
+// authorization: Bearer TOKEN
+type TransformServiceClient interface {
+	// TransformMarkdown runs transforms over one markdown document
+	TransformMarkdown(ctx context.Context, in *TransformMarkdownRequest, opts ...grpc.CallOption) (*TransformMarkdownResponse, error)
+	// ConvertImage streams an image in and the converted image back. The
+	// first request carries the options and every later one a chunk of the
+	// image; the first response carries the result's details and every later
+	// one a chunk of it
+	ConvertImage(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ConvertImageRequest, ConvertImageResponse], error)
+}
+
+type transformServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTransformServiceClient(cc grpc.ClientConnInterface) TransformServiceClient {
+	return &transformServiceClient{cc}
+}
+
+func (c *transformServiceClient) TransformMarkdown(ctx context.Context, in *TransformMarkdownRequest, opts ...grpc.CallOption) (*TransformMarkdownResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TransformMarkdownResponse)
+	err := c.cc.Invoke(ctx, TransformService_TransformMarkdown_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *transformServiceClient) ConvertImage(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ConvertImageRequest, ConvertImageResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TransformService_ServiceDesc.Streams[0], TransformService_ConvertImage_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ConvertImageRequest, ConvertImageResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TransformService_ConvertImageClient = grpc.BidiStreamingClient[ConvertImageRequest, ConvertImageResponse]
+
+// TransformServiceServer is the server API for TransformService service.
+// All implementations must embed UnimplementedTransformServiceServer
+// for forward compatibility.
+//
+// TransformService runs the markdown and image transforms of goodness.
+// When the server has a token, every call must send the metadata
+// authorization: Bearer TOKEN
+type TransformServiceServer interface {
+	// TransformMarkdown runs transforms over one markdown document
+	TransformMarkdown(context.Context, *TransformMarkdownRequest) (*TransformMarkdownResponse, error)
+	// ConvertImage streams an image in and the converted image back. The
+	// first request carries the options and every later one a chunk of the
+	// image; the first response carries the result's details and every later
+	// one a chunk of it
+	ConvertImage(grpc.BidiStreamingServer[ConvertImageRequest, ConvertImageResponse]) error
+	mustEmbedUnimplementedTransformServiceServer()
+}
+
+// UnimplementedTransformServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTransformServiceServer struct{}
+
+func (UnimplementedTransformServiceServer) TransformMarkdown(context.Context, *TransformMarkdownRequest) (*TransformMarkdownResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TransformMarkdown not implemented")
+}
+func (UnimplementedTransformServiceServer) ConvertImage(grpc.BidiStreamingServer[ConvertImageRequest, ConvertImageResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method ConvertImage not implemented")
+}
+func (UnimplementedTransformServiceServer) mustEmbedUnimplementedTransformServiceServer() {}
+func (UnimplementedTransformServiceServer) testEmbeddedByValue()                          {}
+
+// UnsafeTransformServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TransformServiceServer will
+// result in compilation errors.
+type UnsafeTransformServiceServer interface {
+	mustEmbedUnimplementedTransformServiceServer()
+}
+
+func RegisterTransformServiceServer(s grpc.ServiceRegistrar, srv TransformServiceServer) {
+	// If the following call pancis, it indicates UnimplementedTransformServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&TransformService_ServiceDesc, srv)
+}
+
+func _TransformService_TransformMarkdown_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransformMarkdownRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TransformServiceServer).TransformMarkdown(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TransformService_TransformMarkdown_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TransformServiceServer).TransformMarkdown(ctx, req.(*TransformMarkdownRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TransformService_ConvertImage_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TransformServiceServer).ConvertImage(&grpc.GenericServerStream[ConvertImageRequest, ConvertImageResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TransformService_ConvertImageServer = grpc.BidiStreamingServer[ConvertImageRequest, ConvertImageResponse]
+
+// TransformService_ServiceDesc is the grpc.ServiceDesc for TransformService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TransformService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "goodness.v1.TransformService",
+	HandlerType: (*TransformServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "TransformMarkdown",
+			Handler:    _TransformService_TransformMarkdown_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ConvertImage",
+			Handler:       _TransformService_ConvertImage_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "proto/goodness/v1/transform.proto",
+}
@@ -0,0 +1,361 @@
+package serve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/metrics"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/errs"
+	"GoodnessucWorkflow/pkg/imaging"
+)
+
+// api serves the transforms under /v1
+type api struct {
+	*limits
+	mux *http.ServeMux
+}
+
+func runAPI(args []string) {
+	fs := flag.NewFlagSet("api", flag.ExitOnError)
+	logging.AddFlags(fs)
+	addr := fs.String("addr", "127.0.0.1:8090", "address to listen on")
+	newLimits := addLimitFlags(fs, "GOODNESS_SERVE_API_TOKEN")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness serve api [flags]\n\nServes the markdown and image transforms over HTTP until interrupted:\n\n  POST /v1/markdown/transform   markdown in, markdown out\n  POST /v1/image/convert        multipart upload or raw image in, image out\n  GET  /v1/health               liveness, never needs the token\n  GET  /metrics                 Prometheus metrics\n\nOptions go in the query string, in form fields next to the upload or in a\nJSON body. Errors are JSON objects with an error field.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() > 0 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	a := &api{limits: newLimits(*addr)}
+	a.mux = http.NewServeMux()
+	a.mux.HandleFunc("GET /v1/health", a.health)
+	a.mux.HandleFunc("POST /v1/markdown/transform", a.markdownTransform)
+	a.mux.HandleFunc("POST /v1/image/convert", a.imageConvert)
+	a.mux.Handle("GET /metrics", metrics.Handler())
+
+	srv := &http.Server{Addr: *addr, Handler: a, ReadHeaderTimeout: 10 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), a.timeout)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	logging.Infof("Serving the API on http://%s/v1/", *addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logging.Fatalf("Failed to serve: %s", err)
+	}
+}
+
+// ServeHTTP checks the token and body limit, then routes the request and
+// logs and counts its outcome
+func (a *api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	_, route := a.mux.Handler(r)
+	if route == "" {
+		route = "other"
+	}
+	defer func() {
+		took := time.Since(start)
+		logging.Infof("%s %s %d %s", r.Method, r.URL.Path, rec.status, took.Round(time.Millisecond))
+		metrics.Request("api", route, strconv.Itoa(rec.status), took)
+		if kind := httpErrorType(rec.status); kind != "" {
+			metrics.Error(kind)
+		}
+	}()
+
+	if r.URL.Path != "/v1/health" && !a.authorized(r.Header.Get("Authorization")) {
+		rec.Header().Set("WWW-Authenticate", `Bearer realm="goodness"`)
+		writeError(rec, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+	r.Body = http.MaxBytesReader(rec, r.Body, a.maxBody)
+	a.mux.ServeHTTP(rec, r)
+}
+
+func (a *api) health(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// markdownRequest is the JSON body of POST /v1/markdown/transform
+type markdownRequest struct {
+	Content string `json:"content"`
+	// Transforms run in order: bold, tidy, or plugin:NAME; the default is bold
+	Transforms []string          `json:"transforms"`
+	Options    map[string]string `json:"options"`
+}
+
+// markdownTransform runs transforms over a document. A JSON body gets a
+// JSON reply with the content; any other body is the markdown itself and
+// the reply is too, with transforms and options in the query
+func (a *api) markdownTransform(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), a.timeout)
+	defer cancel()
+
+	req := markdownRequest{Options: make(map[string]string)}
+	isJSON := mediaType(r) == "application/json"
+	if isJSON {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if req.Options == nil {
+			req.Options = make(map[string]string)
+		}
+	} else {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		req.Content = string(data)
+	}
+	query := r.URL.Query()
+	if t := query.Get("transforms"); t != "" {
+		req.Transforms = strings.Split(t, ",")
+	}
+	for name, values := range query {
+		if name != "transforms" {
+			req.Options[name] = values[len(values)-1]
+		}
+	}
+	transform, err := markdownChain(ctx, req.Transforms, req.Options)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	release, err := a.acquire(ctx)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "server busy")
+		return
+	}
+	defer release()
+	start := time.Now()
+	out, err := io.ReadAll(transform(strings.NewReader(req.Content)))
+	metrics.Transformed(time.Since(start))
+	if err != nil {
+		logging.Errorf("Failed to transform markdown: %s", err)
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	if isJSON {
+		writeJSON(w, http.StatusOK, map[string]string{"content": string(out)})
+		return
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Write(out)
+}
+
+// imageConvert converts one image: the file field of a multipart form, or
+// the whole body. Options are format (jpeg, png, gif, webp or auto),
+// quality, width and height to fit within, and scale for SVGs
+func (a *api) imageConvert(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), a.timeout)
+	defer cancel()
+
+	data, options, err := readImage(r)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	opts, err := convertOptions(options)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := a.checkPixels(data, opts); err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+
+	release, err := a.acquire(ctx)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "server busy")
+		return
+	}
+	defer release()
+	start := time.Now()
+	result, err := imaging.Convert(ctx, bytes.NewReader(data), opts)
+	if err != nil {
+		if ctx.Err() != nil {
+			writeError(w, http.StatusServiceUnavailable, "timed out")
+			return
+		}
+		if errors.Is(err, errs.ErrUnsupportedFormat) {
+			writeError(w, http.StatusUnsupportedMediaType, err.Error())
+			return
+		}
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	metrics.Converted(result.Format, len(data), len(result.Data), time.Since(start))
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(extension(result.Format)))
+	w.Header().Set("X-Goodness-Format", result.Format)
+	if result.Quality > 0 {
+		w.Header().Set("X-Goodness-Quality", strconv.Itoa(result.Quality))
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(result.Data)))
+	w.Write(result.Data)
+}
+
+// readImage returns the uploaded image and the options sent with it, from
+// the query, from the other form fields, and from an options field holding
+// a JSON object, in increasing precedence
+func readImage(r *http.Request) ([]byte, map[string]string, error) {
+	options := make(map[string]string)
+	for name, values := range r.URL.Query() {
+		options[name] = values[len(values)-1]
+	}
+
+	if mediaType(r) != "multipart/form-data" {
+		data, err := io.ReadAll(r.Body)
+		if err == nil && len(data) == 0 {
+			err = errors.New("empty body; send the image or a multipart form with a file field")
+		}
+		return data, options, err
+	}
+
+	if err := r.ParseMultipartForm(8 << 20); err != nil {
+		return nil, nil, err
+	}
+	defer r.MultipartForm.RemoveAll()
+	for name, values := range r.MultipartForm.Value {
+		if name != "options" {
+			options[name] = values[len(values)-1]
+		}
+	}
+	if raw := r.MultipartForm.Value["options"]; len(raw) > 0 {
+		var fromJSON map[string]any
+		if err := json.Unmarshal([]byte(raw[0]), &fromJSON); err != nil {
+			return nil, nil, fmt.Errorf("options: %s", err)
+		}
+		for name, value := range fromJSON {
+			options[name] = fmt.Sprint(value)
+		}
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, nil, errors.New("missing file field")
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	return data, options, err
+}
+
+// convertOptions reads the options of an image conversion sent as text
+func convertOptions(options map[string]string) (imaging.Options, error) {
+	var quality, width, height int
+	ints := map[string]*int{"quality": &quality, "width": &width, "height": &height}
+	for name, dst := range ints {
+		if value := options[name]; value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return imaging.Options{}, fmt.Errorf("%s must be an integer", name)
+			}
+			*dst = n
+		}
+	}
+	var scale float64
+	if value := options["scale"]; value != "" {
+		var err error
+		if scale, err = strconv.ParseFloat(value, 64); err != nil {
+			return imaging.Options{}, errors.New("scale must be a number")
+		}
+	}
+	return imageOptions(options["format"], quality, width, height, scale)
+}
+
+// extension maps a format to its file extension for MIME lookups
+func extension(format string) string {
+	if format == "jpeg" {
+		return ".jpg"
+	}
+	return "." + format
+}
+
+func mediaType(r *http.Request) string {
+	t, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return t
+}
+
+// writeBodyError reports a body that could not be read, telling a body
+// over -max-body apart from a malformed one
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("body over the %s limit", fsutil.FormatBytes(tooLarge.Limit)))
+		return
+	}
+	writeError(w, http.StatusBadRequest, err.Error())
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// httpErrorType is the goodness_errors_total type of a response status, or
+// "" for one that is not an error
+func httpErrorType(status int) string {
+	switch {
+	case status < 400, status == http.StatusNotFound, status == http.StatusMethodNotAllowed:
+		return ""
+	case status == http.StatusBadRequest:
+		return metrics.InvalidRequest
+	case status == http.StatusUnauthorized:
+		return metrics.Unauthorized
+	case status == http.StatusRequestEntityTooLarge:
+		return metrics.TooLarge
+	case status == http.StatusUnprocessableEntity, status == http.StatusUnsupportedMediaType:
+		return metrics.Transform
+	case status == http.StatusServiceUnavailable:
+		return metrics.Unavailable
+	}
+	return metrics.Internal
+}
+
+// statusRecorder remembers the status a handler wrote for the request log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
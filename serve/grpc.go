@@ -0,0 +1,289 @@
+package serve
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/metrics"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/imaging"
+	pb "GoodnessucWorkflow/proto/goodness/v1"
+)
+
+// chunkSize is how much of an image each ConvertImage message carries
+const chunkSize = 64 << 10
+
+// grpcServer implements goodness.v1.TransformService
+type grpcServer struct {
+	pb.UnimplementedTransformServiceServer
+	*limits
+}
+
+func runGRPC(args []string) {
+	fs := flag.NewFlagSet("grpc", flag.ExitOnError)
+	logging.AddFlags(fs)
+	addr := fs.String("addr", "127.0.0.1:8091", "address to listen on")
+	certFile := fs.String("tls-cert", "", "serve TLS with this certificate file; needs -tls-key")
+	keyFile := fs.String("tls-key", "", "private key file for -tls-cert")
+	metricsAddr := fs.String("metrics", "", "also serve Prometheus metrics over HTTP on this address at /metrics")
+	newLimits := addLimitFlags(fs, "GOODNESS_SERVE_GRPC_TOKEN")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness serve grpc [flags]\n\nServes the goodness.v1.TransformService gRPC service defined in\nproto/goodness/v1/transform.proto until interrupted, with the standard health\nand reflection services. Calls send the token as authorization metadata.\nWith -metrics, Prometheus metrics are served over HTTP at /metrics.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() > 0 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	if (*certFile == "") != (*keyFile == "") {
+		logging.Exitf(exitcode.Usage, "Invalid TLS flags: -tls-cert and -tls-key go together")
+	}
+	s := &grpcServer{limits: newLimits(*addr)}
+
+	opts := []grpc.ServerOption{
+		// Markdown arrives in one message; images arrive in chunks
+		grpc.MaxRecvMsgSize(int(s.maxBody) + 1024),
+		grpc.MaxSendMsgSize(int(s.maxBody) + 1024),
+		grpc.ChainUnaryInterceptor(s.unaryInterceptor),
+		grpc.ChainStreamInterceptor(s.streamInterceptor),
+	}
+	if *certFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(*certFile, *keyFile)
+		if err != nil {
+			logging.Exitf(exitcode.Usage, "Invalid TLS flags: %s", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	srv := grpc.NewServer(opts...)
+	pb.RegisterTransformServiceServer(srv, s)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	reflection.Register(srv)
+
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		logging.Fatalf("Failed to listen: %s", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		timer := time.AfterFunc(s.timeout, srv.Stop)
+		defer timer.Stop()
+		srv.GracefulStop()
+	}()
+
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", metrics.Handler())
+		msrv := &http.Server{Addr: *metricsAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := msrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logging.Errorf("Failed to serve metrics: %s", err)
+			}
+		}()
+		defer msrv.Close()
+		logging.Infof("Metrics on http://%s/metrics", *metricsAddr)
+	}
+
+	logging.Infof("Serving gRPC on %s", *addr)
+	if err := srv.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		logging.Fatalf("Failed to serve: %s", err)
+	}
+}
+
+// TransformMarkdown runs transforms over one document
+func (s *grpcServer) TransformMarkdown(ctx context.Context, req *pb.TransformMarkdownRequest) (*pb.TransformMarkdownResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if int64(len(req.GetContent())) > s.maxBody {
+		return nil, status.Errorf(codes.ResourceExhausted, "content over the %d byte limit", s.maxBody)
+	}
+	transform, err := markdownChain(ctx, req.GetTransforms(), req.GetOptions())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	release, err := s.acquire(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, "server busy")
+	}
+	defer release()
+	start := time.Now()
+	out, err := io.ReadAll(transform(strings.NewReader(req.GetContent())))
+	metrics.Transformed(time.Since(start))
+	if err != nil {
+		logging.Errorf("Failed to transform markdown: %s", err)
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &pb.TransformMarkdownResponse{Content: string(out)}, nil
+}
+
+// ConvertImage reads the options and then the image's chunks until the
+// client closes its side, converts it, and streams the result back
+func (s *grpcServer) ConvertImage(stream pb.TransformService_ConvertImageServer) error {
+	ctx, cancel := context.WithTimeout(stream.Context(), s.timeout)
+	defer cancel()
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	o := first.GetOptions()
+	if o == nil {
+		return status.Error(codes.InvalidArgument, "the first message must carry the options")
+	}
+	opts, err := imageOptions(o.GetFormat(), int(o.GetQuality()), int(o.GetWidth()), int(o.GetHeight()), o.GetScale())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var data bytes.Buffer
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if req.GetOptions() != nil {
+			return status.Error(codes.InvalidArgument, "options may only come in the first message")
+		}
+		if int64(data.Len()+len(req.GetChunk())) > s.maxBody {
+			return status.Errorf(codes.ResourceExhausted, "image over the %d byte limit", s.maxBody)
+		}
+		data.Write(req.GetChunk())
+	}
+	if data.Len() == 0 {
+		return status.Error(codes.InvalidArgument, "no image data")
+	}
+	if err := s.checkPixels(data.Bytes(), opts); err != nil {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	release, err := s.acquire(ctx)
+	if err != nil {
+		return status.Error(codes.Unavailable, "server busy")
+	}
+	start := time.Now()
+	size := data.Len()
+	result, err := imaging.Convert(ctx, &data, opts)
+	release()
+	if err != nil {
+		if ctx.Err() != nil {
+			return status.Error(codes.DeadlineExceeded, "timed out")
+		}
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	metrics.Converted(result.Format, size, len(result.Data), time.Since(start))
+
+	info := &pb.ImageInfo{Format: result.Format, Quality: int32(result.Quality), Size: int64(len(result.Data))}
+	if err := stream.Send(&pb.ConvertImageResponse{Part: &pb.ConvertImageResponse_Info{Info: info}}); err != nil {
+		return err
+	}
+	for out := result.Data; len(out) > 0; {
+		n := min(len(out), chunkSize)
+		if err := stream.Send(&pb.ConvertImageResponse{Part: &pb.ConvertImageResponse_Chunk{Chunk: out[:n]}}); err != nil {
+			return err
+		}
+		out = out[n:]
+	}
+	return nil
+}
+
+// authorize checks the token in the call's metadata; health checks and
+// reflection need none
+func (s *grpcServer) authorize(ctx context.Context, method string) error {
+	if strings.HasPrefix(method, "/grpc.health.") || strings.HasPrefix(method, "/grpc.reflection.") {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := ""
+	if values := md.Get("authorization"); len(values) > 0 {
+		header = values[0]
+	}
+	if !s.authorized(header) {
+		return status.Error(codes.Unauthenticated, "missing or invalid token")
+	}
+	return nil
+}
+
+func (s *grpcServer) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	err := s.authorize(ctx, info.FullMethod)
+	var resp any
+	if err == nil {
+		resp, err = handler(ctx, req)
+	}
+	observe(info.FullMethod, err, time.Since(start))
+	return resp, err
+}
+
+func (s *grpcServer) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := s.authorize(ss.Context(), info.FullMethod)
+	if err == nil {
+		err = handler(srv, ss)
+	}
+	observe(info.FullMethod, err, time.Since(start))
+	return err
+}
+
+// observe logs and counts the outcome of a call
+func observe(method string, err error, took time.Duration) {
+	code := status.Code(err)
+	logging.Infof("%s %s %s", method, code, took.Round(time.Millisecond))
+	metrics.Request("grpc", method, code.String(), took)
+	if kind := grpcErrorType(code); kind != "" {
+		metrics.Error(kind)
+	}
+}
+
+// grpcErrorType is the goodness_errors_total type of a status code, or ""
+// for one that is not an error
+func grpcErrorType(code codes.Code) string {
+	switch code {
+	case codes.OK, codes.Canceled, codes.NotFound, codes.Unimplemented:
+		return ""
+	case codes.InvalidArgument:
+		return metrics.InvalidRequest
+	case codes.Unauthenticated:
+		return metrics.Unauthorized
+	case codes.ResourceExhausted:
+		return metrics.TooLarge
+	case codes.FailedPrecondition:
+		return metrics.Transform
+	case codes.Unavailable:
+		return metrics.Unavailable
+	case codes.DeadlineExceeded:
+		return metrics.Timeout
+	}
+	return metrics.Internal
+}
@@ -0,0 +1,18 @@
+// Package serve runs goodness as a long-lived network service so other
+// programs can reuse its transforms
+package serve
+
+import "GoodnessucWorkflow/internal/cli"
+
+// Commands are the serve subcommands
+func Commands() []cli.Command {
+	return []cli.Command{
+		{Name: "api", Summary: "serve the markdown and image transforms over an HTTP API", Run: runAPI},
+		{Name: "grpc", Summary: "serve the markdown and image transforms as a gRPC service", Run: runGRPC},
+	}
+}
+
+// Run is the serve command
+func Run(args []string) {
+	cli.Dispatch("goodness serve", Commands(), args)
+}
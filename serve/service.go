@@ -0,0 +1,158 @@
+package serve
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"math"
+	"runtime"
+	"strings"
+	"time"
+
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/pkg/imaging"
+	"GoodnessucWorkflow/pkg/markdown"
+	"GoodnessucWorkflow/plugin"
+)
+
+// errPixels rejects an image over -max-pixels
+var errPixels = errors.New("image over the pixel limit")
+
+// limits are what every server checks before running a transform: the
+// token, the request size and time, and how many transforms run at once
+type limits struct {
+	token     string
+	maxBody   int64
+	maxPixels int64
+	timeout   time.Duration
+	slots     chan struct{}
+}
+
+// addLimitFlags registers the flags limits come from on fs. The returned
+// function builds limits once fs is parsed, exiting on invalid values
+func addLimitFlags(fs *flag.FlagSet, tokenEnv string) func(addr string) *limits {
+	token := fs.String("token", "", "require the bearer token TOKEN on every request but health checks; prefer "+tokenEnv+" to keep it out of the process list")
+	maxBody := fs.String("max-body", "20MB", "largest request accepted")
+	maxPixels := fs.Int64("max-pixels", 50_000_000, "largest image accepted, in pixels")
+	timeout := fs.Duration("timeout", time.Minute, "time a request may take, including waiting for a slot")
+	concurrency := fs.Int("concurrency", runtime.NumCPU(), "transforms run at once; further requests wait")
+
+	return func(addr string) *limits {
+		limit, err := fsutil.ParseByteSize(*maxBody)
+		if err != nil || limit <= 0 {
+			logging.Exitf(exitcode.Usage, "Invalid -max-body value %q", *maxBody)
+		}
+		if *concurrency < 1 {
+			logging.Exitf(exitcode.Usage, "Invalid -concurrency value %d", *concurrency)
+		}
+		if *token == "" {
+			logging.Warnf("No -token set; anyone who can reach %s can use the service", addr)
+		}
+		return &limits{token: *token, maxBody: limit, maxPixels: *maxPixels, timeout: *timeout, slots: make(chan struct{}, *concurrency)}
+	}
+}
+
+// authorized checks the value of an Authorization header or metadata entry
+func (l *limits) authorized(header string) bool {
+	if l.token == "" {
+		return true
+	}
+	got, ok := strings.CutPrefix(header, "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(l.token)) == 1
+}
+
+// acquire waits for a free transform slot until ctx is done
+func (l *limits) acquire(ctx context.Context) (release func(), err error) {
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// checkPixels rejects images larger than maxPixels before they are decoded.
+// SVGs are measured at the size opts would draw them at. Other formats
+// DecodeConfig does not know pass, and fail in the conversion
+func (l *limits) checkPixels(data []byte, opts imaging.Options) error {
+	width, height := 0, 0
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		width, height = cfg.Width, cfg.Height
+	} else if bytes.Contains(data, []byte("<svg")) {
+		if width, height, err = imaging.SVGSize(data, opts); err != nil {
+			return nil
+		}
+	}
+	if int64(width)*int64(height) > l.maxPixels {
+		return fmt.Errorf("%w: %dx%d is over %d pixels", errPixels, width, height, l.maxPixels)
+	}
+	return nil
+}
+
+// markdownChain builds the transforms names lists: bold, tidy, or
+// plugin:NAME, which gets options. No names means bold
+func markdownChain(ctx context.Context, names []string, options map[string]string) (markdown.Transform, error) {
+	if len(names) == 0 {
+		names = []string{"bold"}
+	}
+	var transforms []markdown.Transform
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if t, ok := markdown.Named(name); ok {
+			transforms = append(transforms, t)
+			continue
+		}
+		pluginName, ok := strings.CutPrefix(name, "plugin:")
+		if !ok {
+			return nil, fmt.Errorf("unknown transform %q; expected bold, tidy or plugin:NAME", name)
+		}
+		if _, err := plugin.Find(pluginName); err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, plugin.Markdown(ctx, pluginName, options))
+	}
+	return markdown.Chain(transforms...), nil
+}
+
+// Bounds of the size options, whatever the format. Larger SVG drawings are
+// refused by checkPixels too, but these stop absurd values early
+const (
+	maxSide  = 65535
+	maxScale = 100
+)
+
+// imageOptions checks the options of an image conversion: format is jpeg,
+// png, gif, webp or auto, width and height bound the result and scale
+// applies to SVGs
+func imageOptions(format string, quality, width, height int, scale float64) (imaging.Options, error) {
+	opts := imaging.Options{Format: strings.ToLower(format), Quality: quality, SVGScale: scale}
+	switch opts.Format {
+	case "", "jpg":
+		opts.Format = "jpeg"
+	case "jpeg", "png", "gif", "webp", "auto":
+	default:
+		return opts, fmt.Errorf("unsupported format %q; expected jpeg, png, gif, webp or auto", format)
+	}
+	switch {
+	case quality < 0 || quality > 100:
+		return opts, errors.New("quality must be between 0 and 100")
+	case width < 0 || height < 0:
+		return opts, errors.New("width and height cannot be negative")
+	case width > maxSide || height > maxSide:
+		return opts, fmt.Errorf("width and height cannot be over %d", maxSide)
+	case scale < 0 || scale > maxScale || math.IsNaN(scale):
+		return opts, fmt.Errorf("scale must be between 0 and %d", maxScale)
+	}
+	if width > 0 || height > 0 {
+		// SVGs are drawn at the size rather than scaled down afterwards
+		opts.SVGWidth, opts.SVGHeight = width, height
+		opts.Transforms = append(opts.Transforms, imaging.Fit(width, height))
+	}
+	return opts, nil
+}
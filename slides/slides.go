@@ -0,0 +1,256 @@
+// Package slides implements goodness md slides, which turns a markdown file
+// into a slide deck, so a talk can be drafted in the same files and with
+// the same tools as a post
+package slides
+
+import (
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/markdown"
+	"gopkg.in/yaml.v3"
+)
+
+// templates hold the page around the slides for each -format
+//
+//go:embed templates/*.html
+var templates embed.FS
+
+// defaultRevealURL is where the reveal format loads reveal.js from
+const defaultRevealURL = "https://cdn.jsdelivr.net/npm/reveal.js@5"
+
+// Lines that split a deck and that start a slide's speaker notes
+var (
+	slideRule   = regexp.MustCompile(`^---[ \t]*$`)
+	slideHeader = regexp.MustCompile(`^ {0,3}(#{1,6})[ \t]`)
+	notesStart  = regexp.MustCompile(`^(?i)notes?:[ \t]*`)
+	fence       = regexp.MustCompile("^ {0,3}(```|~~~)")
+)
+
+// slide is one slide rendered as HTML
+type slide struct {
+	Content template.HTML
+	Notes   template.HTML
+}
+
+// deck is what the templates render
+type deck struct {
+	Title     string
+	Theme     string
+	RevealURL string
+	Slides    []slide
+}
+
+// Run is the md slides command
+func Run(args []string) {
+	fs := flag.NewFlagSet("slides", flag.ExitOnError)
+	logging.AddFlags(fs)
+	format := fs.String("format", "html", "html for a standalone page, or reveal for a reveal.js presentation")
+	split := fs.String("split", "auto", "where slides start: rule at --- lines, h1 or h2 at headings of that level or above, or auto for rule when the file has any --- lines and h2 otherwise")
+	theme := fs.String("theme", "light", "colors: light or dark")
+	title := fs.String("title", "", "title of the deck (default the front matter's title, the first heading or the file name)")
+	embedImages := fs.Bool("embed", false, "embed local images in the page, so it can be moved or sent on its own")
+	revealURL := fs.String("reveal-url", defaultRevealURL, "where -format reveal loads reveal.js from, a URL or a folder")
+	out := fs.String("out", "", "path of the deck (default <file>-slides.html next to it)")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness md slides [flags] talk.md\n\nTurns a markdown file into HTML slides. Slides are separated by --- lines,\nor start at headings with -split. Code blocks are highlighted, and images\nare linked from where the page is written or embedded with -embed. Lines\nafter Notes: on a slide are speaker notes. The standalone page moves with\nthe arrow keys, space and clicks, and prints one slide per page.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	if *format != "html" && *format != "reveal" {
+		logging.Exitf(exitcode.Usage, "Invalid -format value %q", *format)
+	}
+	if *split != "auto" && *split != "rule" && *split != "h1" && *split != "h2" {
+		logging.Exitf(exitcode.Usage, "Invalid -split value %q", *split)
+	}
+	if *theme != "light" && *theme != "dark" {
+		logging.Exitf(exitcode.Usage, "Invalid -theme value %q", *theme)
+	}
+
+	path := fs.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logging.Fatalf("Failed to read %s: %s", path, err)
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(path, filepath.Ext(path)) + "-slides.html"
+	}
+	front, body, _ := markdown.SplitFrontMatter(string(data))
+
+	// Image links are made to work from where the deck is written
+	postDir, outDir := filepath.Dir(path), filepath.Dir(*out)
+	body, _ = markdown.RewriteLinks(body, func(target string) (string, bool) {
+		return relink(target, postDir, outDir, *embedImages)
+	})
+
+	d := deck{Title: *title, Theme: *theme, RevealURL: strings.TrimSuffix(*revealURL, "/")}
+	if d.Title == "" {
+		d.Title = deckTitle(front, body, path)
+	}
+	for _, s := range splitSlides(body, *split) {
+		content, notes := splitNotes(s)
+		d.Slides = append(d.Slides, slide{
+			Content: template.HTML(markdown.HTML(content)),
+			Notes:   template.HTML(markdown.HTML(notes)),
+		})
+	}
+	if len(d.Slides) == 0 {
+		logging.Exitf(exitcode.NoMatch, "%s has nothing to put on a slide", path)
+	}
+
+	t, err := template.ParseFS(templates, "templates/"+*format+".html")
+	if err != nil {
+		logging.Fatalf("Failed to load the %s template: %s", *format, err)
+	}
+	var page bytes.Buffer
+	if err := t.Execute(&page, d); err != nil {
+		logging.Fatalf("Failed to render the slides: %s", err)
+	}
+	if err := journal.WriteFile(*out, page.Bytes(), 0644); err != nil {
+		logging.Fatalf("Failed to write %s: %s", *out, err)
+	}
+	output.Set("slides", len(d.Slides))
+	output.Wrote(*out)
+	logging.Infof("Slides written: %s (%d slides)", *out, len(d.Slides))
+}
+
+// splitSlides cuts body into the markdown of each slide, skipping empty
+// ones. Lines in fenced code blocks never split
+func splitSlides(body, split string) []string {
+	lines := strings.Split(body, "\n")
+	if split == "auto" {
+		split = "h2"
+		inFence := false
+		for _, line := range lines {
+			if fence.MatchString(line) {
+				inFence = !inFence
+			} else if !inFence && slideRule.MatchString(line) {
+				split = "rule"
+				break
+			}
+		}
+	}
+
+	var slides []string
+	var current []string
+	flush := func() {
+		if text := strings.TrimSpace(strings.Join(current, "\n")); text != "" {
+			slides = append(slides, text)
+		}
+		current = nil
+	}
+	inFence := false
+	for _, line := range lines {
+		if fence.MatchString(line) {
+			inFence = !inFence
+		}
+		switch {
+		case inFence:
+		case split == "rule" && slideRule.MatchString(line):
+			flush()
+			continue
+		case split != "rule" && slideHeader.MatchString(line):
+			if level := len(slideHeader.FindStringSubmatch(line)[1]); level <= int(split[1]-'0') {
+				flush()
+			}
+		}
+		current = append(current, line)
+	}
+	flush()
+	return slides
+}
+
+// splitNotes cuts the speaker notes, from a line starting with Notes: on,
+// off the markdown of a slide
+func splitNotes(s string) (content, notes string) {
+	lines := strings.Split(s, "\n")
+	inFence := false
+	for i, line := range lines {
+		if fence.MatchString(line) {
+			inFence = !inFence
+		} else if !inFence && notesStart.MatchString(line) {
+			return strings.Join(lines[:i], "\n"), notesStart.ReplaceAllString(strings.Join(lines[i:], "\n"), "")
+		}
+	}
+	return s, ""
+}
+
+// deckTitle is the title in the front matter, or else the first heading or
+// the file's name
+func deckTitle(front, body, path string) string {
+	var meta struct {
+		Title string `yaml:"title"`
+	}
+	if yaml.Unmarshal([]byte(front), &meta) == nil && meta.Title != "" {
+		return meta.Title
+	}
+	for _, line := range strings.Split(body, "\n") {
+		if m := slideHeader.FindStringIndex(line); m != nil {
+			return strings.TrimSpace(strings.Trim(strings.TrimSpace(line[m[1]:]), "#"))
+		}
+	}
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+// relink points a local link target, relative to the post's folder, at the
+// same file from the deck's folder, or embeds it as a data URL when embed
+// is set and it is an image
+func relink(target, postDir, outDir string, embed bool) (string, bool) {
+	if target == "" || strings.HasPrefix(target, "#") || strings.HasPrefix(target, "/") || strings.Contains(target, ":") {
+		return "", false
+	}
+	name, suffix := target, ""
+	if i := strings.IndexAny(target, "?#"); i >= 0 {
+		name, suffix = target[:i], target[i:]
+	}
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	file := filepath.Join(postDir, filepath.FromSlash(name))
+
+	if embed {
+		if kind := mime.TypeByExtension(strings.ToLower(filepath.Ext(file))); strings.HasPrefix(kind, "image/") {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				logging.Warnf("Failed to embed %s: %s", file, err)
+				return "", false
+			}
+			return "data:" + kind + ";base64," + base64.StdEncoding.EncodeToString(data), true
+		}
+	}
+	if postDir == outDir {
+		return "", false
+	}
+	absFile, err1 := filepath.Abs(file)
+	absOut, err2 := filepath.Abs(outDir)
+	if err1 != nil || err2 != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(absOut, absFile)
+	if err != nil {
+		return "", false
+	}
+	return (&url.URL{Path: filepath.ToSlash(rel)}).EscapedPath() + suffix, true
+}
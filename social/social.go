@@ -0,0 +1,400 @@
+// Package social implements goodness md social, which drafts the posts that
+// share an article: a thread for X, a LinkedIn summary and a short teaser
+package social
+
+import (
+	"bytes"
+	"cmp"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/markdown"
+	"gopkg.in/yaml.v3"
+)
+
+// builtin holds the default template of each snippet
+//
+//go:embed templates/*.txt
+var builtin embed.FS
+
+// snippets are the files written next to the post, as <post>.<name>.txt
+var snippets = []string{"thread", "linkedin", "teaser"}
+
+// linkedInLimit is the most characters a LinkedIn post can have
+const linkedInLimit = 3000
+
+// linkLength is what X counts a link as, whatever its length
+const linkLength = 23
+
+var (
+	postBreak   = regexp.MustCompile(`(?m)^[ \t]*---[ \t]*$`)
+	blankLines  = regexp.MustCompile(`\n{3,}`)
+	link        = regexp.MustCompile(`https?://\S+`)
+	sentenceEnd = regexp.MustCompile(`[.!?…]+["'”’)\]]*\s+`)
+)
+
+// Post is what the templates are given
+type Post struct {
+	Title       string
+	Description string
+	URL         string
+	Tags        []string
+	// Hashtags are the tags written as hashtags, such as #MachineLearning
+	Hashtags string
+	// Lead is the first paragraph before the sections, or of the first one
+	Lead     string
+	Sections []Section
+}
+
+// Section is the part of the post under a heading
+type Section struct {
+	Heading string
+	// Lead is the section's first paragraph
+	Lead string
+	// Text is all of its paragraphs, separated by blank lines
+	Text string
+}
+
+// frontMatter holds the fields read from a post's front matter
+type frontMatter struct {
+	Title        string `yaml:"title"`
+	Description  string `yaml:"description"`
+	Summary      string `yaml:"summary"`
+	URL          string `yaml:"url"`
+	CanonicalURL string `yaml:"canonical_url"`
+	Tags         any    `yaml:"tags"`
+}
+
+// Run is the md social command
+func Run(args []string) {
+	fs := flag.NewFlagSet("social", flag.ExitOnError)
+	logging.AddFlags(fs)
+	url := fs.String("url", "", "link to the published post (default the front matter's url or canonical_url)")
+	limit := fs.Int("limit", 280, "characters in each post of the thread and in the teaser; links count as 23, as on X")
+	level := fs.Int("level", 2, "headings of this level or above start a post in the thread")
+	number := fs.Bool("number", true, "number the posts of the thread, as 1/6")
+	templates := fs.String("templates", "", "folder with thread.txt, linkedin.txt or teaser.txt to use instead of the built-in templates")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness md social [flags] post.md\n\nDrafts the posts that share an article and writes them next to it:\npost.thread.txt, a thread for X with a post for each section,\npost.linkedin.txt, a LinkedIn summary, and post.teaser.txt, a line or two\nto go with a link. Code, images and tables are left out. Each is made from\na Go text template, which -templates replaces; the thread's posts are\nseparated by --- lines and split where they run over -limit.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	if *limit < 40 {
+		logging.Exitf(exitcode.Usage, "Invalid -limit value %d; expected at least 40", *limit)
+	}
+	if *level < 1 || *level > 6 {
+		logging.Exitf(exitcode.Usage, "Invalid -level value %d", *level)
+	}
+
+	path := fs.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		logging.Fatalf("Failed to read %s: %s", path, err)
+	}
+	post := parse(string(data), *level)
+	if *url != "" {
+		post.URL = *url
+	}
+	if post.Title == "" {
+		post.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if post.Lead == "" && len(post.Sections) == 0 {
+		logging.Exitf(exitcode.NoMatch, "%s has no prose to share", path)
+	}
+
+	stem := strings.TrimSuffix(path, filepath.Ext(path))
+	var written []string
+	for _, name := range snippets {
+		t, err := loadTemplate(*templates, name)
+		if err != nil {
+			logging.Fatalf("Failed to load the %s template: %s", name, err)
+		}
+		var b bytes.Buffer
+		if err := t.Execute(&b, post); err != nil {
+			logging.Fatalf("Failed to render the %s template: %s", name, err)
+		}
+		text := tidy(b.String())
+
+		switch name {
+		case "thread":
+			posts := thread(text, *limit, *number)
+			output.Set("posts", len(posts))
+			text = strings.Join(posts, "\n\n---\n\n")
+		case "linkedin":
+			if n := utf8.RuneCountInString(text); n > linkedInLimit {
+				logging.Warnf("The LinkedIn summary has %d characters, more than the %d LinkedIn allows", n, linkedInLimit)
+			}
+		case "teaser":
+			if n := length(text); n > *limit {
+				logging.Warnf("The teaser has %d characters, more than -limit %d", n, *limit)
+			}
+		}
+
+		target := stem + "." + name + ".txt"
+		if err := journal.WriteFile(target, []byte(text+"\n"), 0644); err != nil {
+			logging.Fatalf("Failed to write %s: %s", target, err)
+		}
+		output.Wrote(target)
+		written = append(written, target)
+	}
+	logging.Infof("Snippets written: %s", strings.Join(written, ", "))
+}
+
+// parse reads the title, description, link and tags from a post's front
+// matter and its prose by section. Headings of level or above start a
+// section, except a level 1 heading the post opens with, which is its title
+func parse(text string, level int) Post {
+	var post Post
+	front, _, _ := markdown.SplitFrontMatter(text)
+	var meta frontMatter
+	if yaml.Unmarshal([]byte(front), &meta) == nil {
+		post.Title = meta.Title
+		post.Description = strings.Join(strings.Fields(cmp.Or(meta.Description, meta.Summary)), " ")
+		post.URL = cmp.Or(meta.URL, meta.CanonicalURL)
+		post.Tags = tags(meta.Tags)
+		post.Hashtags = hashtags(post.Tags)
+	}
+
+	var paragraphs []string
+	section := -1
+	flush := func() {
+		if section < 0 {
+			if len(paragraphs) > 0 {
+				post.Lead = paragraphs[0]
+			}
+		} else if len(paragraphs) > 0 {
+			s := &post.Sections[section]
+			s.Lead = paragraphs[0]
+			s.Text = strings.Join(paragraphs, "\n\n")
+		}
+		paragraphs = nil
+	}
+	for i, p := range markdown.Prose(text) {
+		plain := markdown.InlineText(p.Text)
+		switch {
+		case plain == "":
+		case p.Kind == markdown.KindHeading && p.Level == 1 && i == 0:
+			post.Title = cmp.Or(post.Title, plain)
+		case p.Kind == markdown.KindHeading && p.Level <= level:
+			flush()
+			post.Sections = append(post.Sections, Section{Heading: plain})
+			section = len(post.Sections) - 1
+		case p.Kind == markdown.KindHeading:
+		default:
+			paragraphs = append(paragraphs, plain)
+		}
+	}
+	flush()
+	if post.Lead == "" && len(post.Sections) > 0 {
+		post.Lead = post.Sections[0].Lead
+	}
+	return post
+}
+
+// tags reads front matter tags written as a list or a comma separated string
+func tags(value any) []string {
+	var list []string
+	switch v := value.(type) {
+	case string:
+		list = strings.Split(v, ",")
+	case []any:
+		for _, tag := range v {
+			list = append(list, fmt.Sprint(tag))
+		}
+	}
+	var out []string
+	for _, tag := range list {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+// hashtags writes tags as hashtags, joining the words of a tag such as
+// machine learning into #MachineLearning
+func hashtags(tags []string) string {
+	var out []string
+	for _, tag := range tags {
+		words := strings.FieldsFunc(tag, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+		if len(words) > 1 {
+			for i, w := range words {
+				r, size := utf8.DecodeRuneInString(w)
+				words[i] = string(unicode.ToUpper(r)) + w[size:]
+			}
+		}
+		if hashtag := strings.Join(words, ""); hashtag != "" {
+			out = append(out, "#"+hashtag)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// loadTemplate parses the template called name from dir, or the built-in
+// one when dir is empty or has none
+func loadTemplate(dir, name string) (*template.Template, error) {
+	funcs := template.FuncMap{"sentences": sentences, "fit": fit}
+	if dir != "" {
+		data, err := os.ReadFile(filepath.Join(dir, name+".txt"))
+		if err == nil {
+			return template.New(name).Funcs(funcs).Parse(string(data))
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return template.New(name+".txt").Funcs(funcs).ParseFS(builtin, "templates/"+name+".txt")
+}
+
+// tidy drops trailing spaces and runs of blank lines a template leaves
+func tidy(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// splitSentences cuts text after each sentence, keeping the space that
+// follows it
+func splitSentences(text string) []string {
+	var out []string
+	for {
+		m := sentenceEnd.FindStringIndex(text)
+		if m == nil {
+			break
+		}
+		out = append(out, text[:m[1]])
+		text = text[m[1]:]
+	}
+	if text != "" {
+		out = append(out, text)
+	}
+	return out
+}
+
+// sentences returns the first n sentences of text
+func sentences(n int, text string) string {
+	s := splitSentences(text)
+	if len(s) > n {
+		s = s[:n]
+	}
+	return strings.TrimSpace(strings.Join(s, ""))
+}
+
+// fit returns as many sentences from the start of text as fit in n
+// characters, or its first words and an ellipsis when even the first
+// sentence is longer
+func fit(n int, text string) string {
+	var b strings.Builder
+	for _, s := range splitSentences(text) {
+		if length(strings.TrimSpace(b.String()+s)) > n {
+			break
+		}
+		b.WriteString(s)
+	}
+	if out := strings.TrimSpace(b.String()); out != "" {
+		return out
+	}
+	words := strings.Fields(text)
+	b.Reset()
+	for _, w := range words {
+		if length(b.String()+w)+1 > n {
+			break
+		}
+		b.WriteString(w + " ")
+	}
+	return strings.TrimSpace(b.String()) + "…"
+}
+
+// length counts characters as X does, with every link as linkLength
+func length(text string) int {
+	n := utf8.RuneCountInString(text)
+	for _, l := range link.FindAllString(text, -1) {
+		n += linkLength - utf8.RuneCountInString(l)
+	}
+	return n
+}
+
+// thread cuts text into posts at --- lines, splits posts longer than limit
+// at paragraphs, then sentences, then words, and numbers them when number
+// is set
+func thread(text string, limit int, number bool) []string {
+	for digits := 1; ; digits++ {
+		room := limit
+		if number {
+			// Room for "\n\n12/12"
+			room -= 3 + 2*digits
+		}
+		var posts []string
+		for _, p := range postBreak.Split(text, -1) {
+			if p = strings.TrimSpace(p); p != "" {
+				posts = append(posts, split(p, room)...)
+			}
+		}
+		if !number {
+			return posts
+		}
+		if len(fmt.Sprint(len(posts))) > digits {
+			continue
+		}
+		for i := range posts {
+			posts[i] += fmt.Sprintf("\n\n%d/%d", i+1, len(posts))
+		}
+		return posts
+	}
+}
+
+// split cuts a post longer than room into several that fit, at paragraphs
+// first, then sentences, then words. A word longer than room is left whole
+func split(post string, room int) []string {
+	if length(post) <= room {
+		return []string{post}
+	}
+	for _, cut := range []func(string) []string{
+		func(s string) []string { return strings.SplitAfter(s, "\n\n") },
+		splitSentences,
+		func(s string) []string { return strings.SplitAfter(s, " ") },
+	} {
+		pieces := cut(post)
+		if len(pieces) < 2 {
+			continue
+		}
+		var posts []string
+		current := ""
+		for _, piece := range pieces {
+			if current != "" && length(strings.TrimSpace(current+piece)) > room {
+				posts = append(posts, split(strings.TrimSpace(current), room)...)
+				current = ""
+			}
+			current += piece
+		}
+		if current = strings.TrimSpace(current); current != "" {
+			posts = append(posts, split(current, room)...)
+		}
+		return posts
+	}
+	return []string{post}
+}
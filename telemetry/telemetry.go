@@ -0,0 +1,207 @@
+// Package telemetry implements goodness telemetry, which turns the opt-in
+// usage statistics on and off, shows what has been recorded and sends it.
+// Only which commands ran and which formats images were converted between
+// are recorded, to tell which converters are worth improving; see
+// internal/stats. Nothing is sent without goodness telemetry send, and
+// there is no default address to send to
+package telemetry
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/httpclient"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/internal/stats"
+	"GoodnessucWorkflow/pkg/errs"
+	"GoodnessucWorkflow/version"
+)
+
+// payloadVersion changes when the fields of Payload do
+const payloadVersion = 1
+
+// Payload is what goodness telemetry send posts, as JSON
+type Payload struct {
+	Schema   int    `json:"schema"`
+	Version  string `json:"version"`
+	Go       string `json:"go"`
+	Platform string `json:"platform"`
+	stats.Report
+}
+
+// Commands are the telemetry subcommands
+func Commands() []cli.Command {
+	return []cli.Command{
+		{Name: "on", Summary: "start recording which commands and formats are used", Run: runOn},
+		{Name: "off", Summary: "stop recording and delete what has not been sent", Run: runOff},
+		{Name: "status", Summary: "show whether usage statistics are recorded and how many runs are pending", Run: runStatus},
+		{Name: "show", Summary: "print the payload send would post", Run: runShow},
+		{Name: "send", Summary: "post the pending statistics to -endpoint and clear them", Run: runSend},
+	}
+}
+
+// Run is the telemetry command
+func Run(args []string) {
+	// Managing the statistics is not a use of the tools
+	stats.Discard()
+	cli.Dispatch("goodness telemetry", Commands(), args)
+}
+
+// parse parses the flags of a subcommand that takes no arguments
+func parse(fs *flag.FlagSet, args []string, usage string) {
+	logging.AddFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T(usage))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+}
+
+func runOn(args []string) {
+	fs := flag.NewFlagSet("on", flag.ExitOnError)
+	parse(fs, args, "Usage: goodness telemetry on [flags]\n\nRecords, from now on, which commands each run uses and which formats images\nare converted between. Arguments, paths, file contents and anything that\nidentifies you or the machine are never recorded, and nothing is sent\nuntil goodness telemetry send.\n\n")
+
+	if err := stats.SetEnabled(true); err != nil {
+		logging.Fatalf("Failed to save the choice: %s", err)
+	}
+	warnForced()
+	logging.Infof("Usage statistics are recorded; goodness telemetry show prints them")
+	output.Exit(exitcode.OK)
+}
+
+func runOff(args []string) {
+	fs := flag.NewFlagSet("off", flag.ExitOnError)
+	parse(fs, args, "Usage: goodness telemetry off [flags]\n\nStops recording usage statistics and deletes the runs not yet sent.\n\n")
+
+	if err := stats.SetEnabled(false); err != nil {
+		logging.Fatalf("Failed to save the choice: %s", err)
+	}
+	warnForced()
+	logging.Infof("Usage statistics are not recorded")
+	output.Exit(exitcode.OK)
+}
+
+// warnForced warns when the environment overrides the saved choice
+func warnForced() {
+	if enabled, forced := stats.Forced(); forced {
+		if enabled {
+			logging.Warnf("GOODNESS_TELEMETRY=on keeps recording on in this environment")
+		} else {
+			logging.Warnf("GOODNESS_TELEMETRY=off or DO_NOT_TRACK keeps recording off in this environment")
+		}
+	}
+}
+
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	parse(fs, args, "Usage: goodness telemetry status [flags]\n\nShows whether usage statistics are recorded, whether the environment\ndecides it, and how many runs wait to be sent.\n\n")
+
+	report, err := stats.Pending()
+	if err != nil {
+		logging.Fatalf("Failed to read %s: %s", stats.PendingPath(), err)
+	}
+	enabled := stats.Enabled()
+	_, forced := stats.Forced()
+	switch {
+	case enabled && forced:
+		fmt.Println(i18n.T("Recording: on, set by the environment"))
+	case enabled:
+		fmt.Println(i18n.T("Recording: on"))
+	case forced:
+		fmt.Println(i18n.T("Recording: off, set by the environment"))
+	default:
+		fmt.Println(i18n.T("Recording: off"))
+	}
+	fmt.Println(i18n.Sprintf("Pending runs: %d in %s", report.Runs, stats.PendingPath()))
+	output.Set("enabled", enabled)
+	output.Set("pending", report.Runs)
+	output.Exit(exitcode.OK)
+}
+
+// pending returns the payload of the pending runs
+func pending() Payload {
+	report, err := stats.Pending()
+	if err != nil {
+		logging.Fatalf("Failed to read %s: %s", stats.PendingPath(), err)
+	}
+	info := version.Get()
+	return Payload{Schema: payloadVersion, Version: info.Version, Go: info.Go, Platform: info.Platform, Report: report}
+}
+
+func runShow(args []string) {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	parse(fs, args, "Usage: goodness telemetry show [flags]\n\nPrints the JSON goodness telemetry send would post: the version and\nplatform of goodness, and how often each command ran and each pair of\nformats was converted since the last send.\n\n")
+
+	payload := pending()
+	data, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		logging.Fatalf("Failed to encode the statistics: %s", err)
+	}
+	fmt.Println(string(data))
+	output.Set("payload", payload)
+	output.Exit(exitcode.OK)
+}
+
+func runSend(args []string) {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	endpoint := fs.String("endpoint", "", "`URL` to post the statistics to, over https or, for testing, http on localhost; required")
+	timeout := fs.Duration("timeout", 30*time.Second, "time limit for the request")
+	parse(fs, args, "Usage: goodness telemetry send -endpoint URL [flags]\n\nPosts the pending statistics, exactly as goodness telemetry show prints\nthem, to the endpoint as JSON, and clears them once it accepts them. There\nis no default endpoint; set one with -endpoint, or as\nGOODNESS_TELEMETRY_SEND_ENDPOINT or in the configuration.\n\n")
+
+	if *endpoint == "" {
+		logging.Exitf(exitcode.Usage, "No endpoint to send to; pass -endpoint")
+	}
+	u, err := url.Parse(*endpoint)
+	if err != nil || u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+		logging.Exitf(exitcode.Usage, "Invalid -endpoint value %q", *endpoint)
+	}
+	if u.Scheme == "http" && u.Hostname() != "localhost" && u.Hostname() != "127.0.0.1" {
+		logging.Exitf(exitcode.Usage, "Invalid -endpoint value %q: statistics are only sent over https", *endpoint)
+	}
+
+	payload := pending()
+	if payload.Runs == 0 {
+		logging.Infof("No statistics to send")
+		output.Exit(exitcode.OK)
+	}
+	if err := send(u.String(), payload, *timeout); err != nil {
+		logging.Fatalf("Failed to send the statistics: %s", err)
+	}
+	if err := stats.Clear(); err != nil {
+		logging.Fatalf("Failed to clear %s: %s", stats.PendingPath(), err)
+	}
+	logging.Infof("Sent the statistics of %d runs to %s", payload.Runs, u.Host)
+	output.Set("sent", payload.Runs)
+	output.Exit(exitcode.OK)
+}
+
+func send(endpoint string, payload Payload, timeout time.Duration) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := httpclient.New(timeout).Post(endpoint, "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &errs.RemoteError{Service: endpoint, Status: resp.Status, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+	return nil
+}
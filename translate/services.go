@@ -0,0 +1,168 @@
+package translate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"GoodnessucWorkflow/internal/httpclient"
+	"GoodnessucWorkflow/internal/secrets"
+)
+
+// timeout bounds one request
+const timeout = time.Minute
+
+// translator translates batches of HTML snippets. from is empty to let the
+// service detect the language
+type translator interface {
+	Translate(ctx context.Context, texts []string, from, to string) ([]string, error)
+}
+
+// service is an API -service picks from
+type service struct {
+	// endpoint is the API's base URL, or empty when it depends on the key
+	endpoint string
+	// key is the secret holding the API key
+	key string
+	// batch is how many snippets one request may carry
+	batch int
+	open  func(endpoint, key string) translator
+}
+
+// services are the APIs -service picks from
+var services = map[string]service{
+	"deepl": {
+		key:   "DEEPL_AUTH_KEY",
+		batch: 50,
+		open:  func(endpoint, key string) translator { return &deepl{endpoint, key} },
+	},
+	"google": {
+		endpoint: "https://translation.googleapis.com",
+		key:      "GOOGLE_TRANSLATE_API_KEY",
+		batch:    128,
+		open:     func(endpoint, key string) translator { return &google{endpoint, key} },
+	},
+}
+
+// serviceNames lists the names -service accepts
+func serviceNames() []string {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// open returns the service called name, in batches it can take
+func open(name, endpoint string) (translator, error) {
+	s, ok := services[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown service %q; expected %s", name, strings.Join(serviceNames(), ", "))
+	}
+	var key string
+	var err error
+	if endpoint == "" {
+		endpoint = s.endpoint
+		key, err = secrets.Get(s.key)
+	} else {
+		key, _, err = secrets.Lookup(s.key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &batched{s.open(strings.TrimSuffix(endpoint, "/"), key), s.batch}, nil
+}
+
+// batched splits long lists of snippets into requests of at most size
+type batched struct {
+	translator
+	size int
+}
+
+func (b *batched) Translate(ctx context.Context, texts []string, from, to string) ([]string, error) {
+	var all []string
+	for start := 0; start < len(texts); start += b.size {
+		part := texts[start:min(start+b.size, len(texts))]
+		got, err := b.translator.Translate(ctx, part, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if len(got) != len(part) {
+			return nil, fmt.Errorf("sent %d texts and got %d translations back", len(part), len(got))
+		}
+		all = append(all, got...)
+	}
+	return all, nil
+}
+
+// deepl talks to the DeepL API
+type deepl struct {
+	endpoint, key string
+}
+
+func (d *deepl) Translate(ctx context.Context, texts []string, from, to string) ([]string, error) {
+	endpoint := d.endpoint
+	if endpoint == "" {
+		// Keys of free accounts end in :fx and work with the free API only
+		endpoint = "https://api.deepl.com"
+		if strings.HasSuffix(d.key, ":fx") {
+			endpoint = "https://api-free.deepl.com"
+		}
+	}
+	body := map[string]any{
+		"text":         texts,
+		"target_lang":  strings.ToUpper(to),
+		"tag_handling": "html",
+	}
+	if from != "" {
+		body["source_lang"] = strings.ToUpper(from)
+	}
+	headers := map[string]string{"Authorization": "DeepL-Auth-Key " + d.key}
+	var result struct {
+		Translations []struct {
+			Text string `json:"text"`
+		} `json:"translations"`
+	}
+	if err := httpclient.New(timeout).PostJSON(ctx, endpoint+"/v2/translate", headers, body, &result); err != nil {
+		return nil, err
+	}
+	translated := make([]string, len(result.Translations))
+	for i, t := range result.Translations {
+		translated[i] = t.Text
+	}
+	return translated, nil
+}
+
+// google talks to the Google Cloud Translation API
+type google struct {
+	endpoint, key string
+}
+
+func (g *google) Translate(ctx context.Context, texts []string, from, to string) ([]string, error) {
+	body := map[string]any{
+		"q":      texts,
+		"target": to,
+		"format": "html",
+	}
+	if from != "" {
+		body["source"] = from
+	}
+	var result struct {
+		Data struct {
+			Translations []struct {
+				TranslatedText string `json:"translatedText"`
+			} `json:"translations"`
+		} `json:"data"`
+	}
+	if err := httpclient.New(timeout).PostJSON(ctx, g.endpoint+"/language/translate/v2", map[string]string{"X-Goog-Api-Key": g.key}, body, &result); err != nil {
+		return nil, err
+	}
+	translated := make([]string, len(result.Data.Translations))
+	for i, t := range result.Data.Translations {
+		translated[i] = t.TranslatedText
+	}
+	return translated, nil
+}
@@ -0,0 +1,279 @@
+// Package translate implements goodness md translate, which sends the
+// prose of a post to DeepL or Google Translate and writes the translation
+// next to it, as post.fr.md. Code, front matter keys, link targets and
+// inline code never leave the file and come back exactly as they were
+package translate
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/errs"
+	"GoodnessucWorkflow/pkg/markdown"
+)
+
+// language matches the language codes -from and -to take, as fr or pt-BR
+var language = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,4})?$`)
+
+// replacement puts text in place of a document's bytes from start to end
+type replacement struct {
+	start, end int
+	text       string
+}
+
+// segment is one text sent for translation and what to do with the answer
+type segment struct {
+	line  int
+	html  string
+	apply func(translated string) (replacement, error)
+}
+
+// Run is the md translate command
+func Run(args []string) {
+	fs := flag.NewFlagSet("translate", flag.ExitOnError)
+	logging.AddFlags(fs)
+	to := fs.String("to", "", "language to translate to, such as fr, de or pt-BR (required)")
+	from := fs.String("from", "", "language of the posts (default detected by the service)")
+	name := fs.String("service", "deepl", "translation API: "+strings.Join(serviceNames(), ", "))
+	endpoint := fs.String("endpoint", "", "base URL of the API (default the service's)")
+	fields := fs.String("fields", "title,description,summary", "comma separated front matter keys whose values are translated")
+	force := fs.Bool("force", false, "replace translations that already exist")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness md translate -to LANG [flags] post.md ...\n\nSends the paragraphs, headings, list items, quotes and table cells of each\npost to a translation API and writes the result next to it, as post.fr.md\nfor -to fr. Code blocks, inline code, link and image targets, URLs, HTML\nand the front matter stay as they are, except the values of -fields. The\nAPI key is read from DEEPL_AUTH_KEY or GOOGLE_TRANSLATE_API_KEY, or saved\nwith goodness auth login.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() == 0 || *to == "" {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	if !language.MatchString(*to) {
+		logging.Exitf(exitcode.Usage, "Invalid -to value %q; expected a language code such as fr or pt-BR", *to)
+	}
+	if *from != "" && !language.MatchString(*from) {
+		logging.Exitf(exitcode.Usage, "Invalid -from value %q; expected a language code such as en", *from)
+	}
+	t, err := open(*name, *endpoint)
+	if err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid service options: %s", err)
+	}
+	var keys []string
+	for _, k := range strings.Split(*fields, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+
+	ctx := cli.SignalContext()
+	failed := 0
+	for _, path := range fs.Args() {
+		if ctx.Err() != nil {
+			break
+		}
+		target := targetPath(path, *from, *to)
+		if _, err := os.Stat(target); err == nil && !*force {
+			err := errs.Exists("write", target)
+			logging.Errorf("Failed to translate %s: %s; pass -force to replace it", path, err)
+			output.Processed(path, "failed", "write", err)
+			failed++
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			logging.Errorf("Failed to read %s: %s", path, err)
+			output.Processed(path, "failed", "read", err)
+			failed++
+			continue
+		}
+		doc := string(data)
+		segments := slices.Concat(frontSegments(doc, keys), proseSegments(doc), tableSegments(doc))
+
+		texts := make([]string, len(segments))
+		for i, s := range segments {
+			texts[i] = s.html
+		}
+		logging.Debugf("Sending %d texts from %s", len(texts), path)
+		translated, err := t.Translate(ctx, texts, *from, *to)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			logging.Errorf("Failed to translate %s: %s", path, err)
+			output.Processed(path, "failed", "translate", err)
+			failed++
+			continue
+		}
+
+		var edits []replacement
+		kept := 0
+		for i, s := range segments {
+			r, err := s.apply(translated[i])
+			if err != nil {
+				logging.Warnf("Kept line %d of %s untranslated: %s", s.line, path, err)
+				kept++
+				continue
+			}
+			edits = append(edits, r)
+		}
+		sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+		for _, r := range edits {
+			doc = doc[:r.start] + r.text + doc[r.end:]
+		}
+
+		if err := journal.WriteFile(target, []byte(doc), 0644); err != nil {
+			logging.Errorf("Failed to write %s: %s", target, err)
+			output.Processed(path, "failed", "write", err)
+			failed++
+			continue
+		}
+		output.Wrote(target)
+		output.Processed(path, "ok", "", nil)
+		logging.Infof("Translated %d texts of %s into %s", len(edits), path, target)
+		if kept > 0 {
+			failed++
+		}
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		output.Exit(exitcode.Interrupted)
+	case failed > 0:
+		output.Exit(exitcode.Failures)
+	}
+}
+
+// targetPath is where the translation of path goes: post.md becomes
+// post.fr.md, and post.en.md too when translating from en
+func targetPath(path, from, to string) string {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	if from != "" {
+		stem = strings.TrimSuffix(stem, "."+strings.ToLower(from))
+	}
+	return stem + "." + strings.ToLower(to) + ext
+}
+
+// proseSegments are the paragraphs of doc, with their markup kept out of
+// the translation
+func proseSegments(doc string) []segment {
+	var segments []segment
+	for _, p := range markdown.Prose(doc) {
+		t := markdown.NewTranslatable(p.Text)
+		segments = append(segments, segment{
+			line: p.Line,
+			html: t.HTML,
+			apply: func(translated string) (replacement, error) {
+				text, err := t.Markdown(translated)
+				if err != nil {
+					return replacement{}, err
+				}
+				return replacement{p.Start, p.End, p.Rewrite(text)}, nil
+			},
+		})
+	}
+	return segments
+}
+
+// tableSegments are the cells of doc's tables, translated one by one so
+// the rows keep their shape
+func tableSegments(doc string) []segment {
+	var segments []segment
+	for _, c := range markdown.TableCells(doc) {
+		t := markdown.NewTranslatable(c.Text)
+		segments = append(segments, segment{
+			line: c.Line,
+			html: t.HTML,
+			apply: func(translated string) (replacement, error) {
+				text, err := t.Markdown(translated)
+				if err != nil {
+					return replacement{}, err
+				}
+				return replacement{c.Start, c.End, c.Rewrite(text)}, nil
+			},
+		})
+	}
+	return segments
+}
+
+// frontSegments are the values of the front matter keys to translate.
+// Only strings written on the key's line are translated; the keys and
+// everything else stay as they are
+func frontSegments(doc string, keys []string) []segment {
+	front, _, ok := markdown.SplitFrontMatter(doc)
+	if !ok || len(keys) == 0 {
+		return nil
+	}
+	var root yaml.Node
+	if yaml.Unmarshal([]byte(front), &root) != nil || len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+
+	// starts are the byte offsets of the document's lines; the front
+	// matter's first line is the document's second
+	starts := []int{0}
+	for i := 0; i < len(doc); i++ {
+		if doc[i] == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+
+	var segments []segment
+	mapping := root.Content[0].Content
+	for i := 0; i+1 < len(mapping); i += 2 {
+		key, value := mapping[i], mapping[i+1]
+		if !slices.Contains(keys, key.Value) || value.Kind != yaml.ScalarNode || value.Tag != "!!str" {
+			continue
+		}
+		if value.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 || value.Line != key.Line || value.Line >= len(starts)-1 {
+			continue
+		}
+		lineStart, lineEnd := starts[value.Line], starts[value.Line+1]-1
+		line := strings.TrimSuffix(doc[lineStart:lineEnd], "\r")
+		column := len(string([]rune(line)[:value.Column-1]))
+		// A value that goes on to the next lines is left alone
+		if next := value.Line + 1; next < len(starts)-1 && strings.HasPrefix(doc[starts[next]:], " ") {
+			continue
+		}
+		segments = append(segments, segment{
+			line: value.Line + 1,
+			html: html.EscapeString(value.Value),
+			apply: func(translated string) (replacement, error) {
+				text := yamlString(html.UnescapeString(translated))
+				if value.LineComment != "" {
+					text += " " + value.LineComment
+				}
+				return replacement{lineStart + column, lineStart + len(line), text}, nil
+			},
+		})
+	}
+	return segments
+}
+
+// yamlString writes s as a YAML scalar on one line
+func yamlString(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	out, err := yaml.Marshal(s)
+	if text := strings.TrimSuffix(string(out), "\n"); err == nil && !strings.Contains(text, "\n") {
+		return text
+	}
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
@@ -0,0 +1,186 @@
+package ui
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// ANSI styles for lines
+const (
+	plain   = ""
+	bold    = "\x1b[1m"
+	dim     = "\x1b[2m"
+	reverse = "\x1b[7m"
+	red     = "\x1b[31m"
+	green   = "\x1b[32m"
+	yellow  = "\x1b[33m"
+	cyan    = "\x1b[36m"
+	reset   = "\x1b[0m"
+)
+
+// line is one row of the screen in a single style
+type line struct {
+	text  string
+	style string
+}
+
+// screen owns the terminal while the interface runs: raw input on stdin
+// and the alternate screen on stdout
+type screen struct {
+	in    *os.File
+	out   *bufio.Writer
+	fd    int
+	state *term.State
+}
+
+// openScreen switches the terminal to raw mode and the alternate screen
+func openScreen() (*screen, error) {
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	s := &screen{in: os.Stdin, out: bufio.NewWriter(os.Stdout), fd: int(os.Stdout.Fd()), state: state}
+	s.out.WriteString("\x1b[?1049h\x1b[?25l")
+	s.out.Flush()
+	return s, nil
+}
+
+// Close gives the terminal back as it was
+func (s *screen) Close() {
+	s.out.WriteString("\x1b[?25h\x1b[?1049l")
+	s.out.Flush()
+	term.Restore(int(s.in.Fd()), s.state)
+}
+
+// size returns the terminal's width and height, with a fallback for
+// terminals that do not say
+func (s *screen) size() (width, height int) {
+	width, height, err := term.GetSize(s.fd)
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// draw replaces the screen with lines, cut to its width. The last line
+// sits at the bottom, below blank rows if there are too few lines
+func (s *screen) draw(lines []line, footer []line) {
+	width, height := s.size()
+	if room := height - len(footer); len(lines) > room {
+		lines = lines[:max(room, 0)]
+	}
+	for len(lines)+len(footer) < height {
+		lines = append(lines, line{})
+	}
+	lines = append(lines, footer...)
+
+	s.out.WriteString("\x1b[H")
+	for i, l := range lines {
+		text := truncate(l.text, width)
+		if l.style != plain {
+			// A reversed row is padded so the whole row shows as selected
+			if l.style == reverse {
+				text += strings.Repeat(" ", width-utf8.RuneCountInString(text))
+			}
+			text = l.style + text + reset
+		}
+		s.out.WriteString(text)
+		s.out.WriteString("\x1b[K")
+		if i < len(lines)-1 {
+			s.out.WriteString("\r\n")
+		}
+	}
+	s.out.Flush()
+}
+
+// truncate cuts s to width runes, expanding tabs and dropping other
+// control characters that would move the cursor
+func truncate(s string, width int) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		if r == '\t' {
+			for pad := 4 - n%4; pad > 0 && n < width; pad-- {
+				b.WriteByte(' ')
+				n++
+			}
+			continue
+		}
+		if r < ' ' || r == 0x7f {
+			continue
+		}
+		if n == width {
+			break
+		}
+		b.WriteRune(r)
+		n++
+	}
+	return b.String()
+}
+
+// readKeys sends each key read from r until it fails, then closes keys
+func readKeys(r io.Reader, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, k := range parseKeys(buf[:n]) {
+			keys <- k
+		}
+	}
+}
+
+// escapeKeys names the escape sequences of the keys the interface uses
+var escapeKeys = map[string]string{
+	"A": "up", "B": "down", "C": "right", "D": "left",
+	"H": "home", "F": "end", "1~": "home", "4~": "end",
+	"5~": "pgup", "6~": "pgdown",
+}
+
+// parseKeys turns raw terminal input into key names: up, down, pgup,
+// pgdown, home, end, enter, esc, space, ctrl-c or the character typed
+func parseKeys(data []byte) []string {
+	var keys []string
+	for len(data) > 0 {
+		switch b := data[0]; {
+		case b == 0x1b && len(data) > 2 && (data[1] == '[' || data[1] == 'O'):
+			// A CSI or SS3 sequence ends with a byte from @ to ~
+			end := 2
+			for end < len(data) && (data[end] < 0x40 || data[end] > 0x7e) {
+				end++
+			}
+			if end == len(data) {
+				return keys
+			}
+			if name, ok := escapeKeys[string(data[2:end+1])]; ok {
+				keys = append(keys, name)
+			}
+			data = data[end+1:]
+			continue
+		case b == 0x1b:
+			keys = append(keys, "esc")
+		case b == '\r' || b == '\n':
+			keys = append(keys, "enter")
+		case b == ' ':
+			keys = append(keys, "space")
+		case b == 3:
+			keys = append(keys, "ctrl-c")
+		case b < ' ' || b == 0x7f:
+		default:
+			r, size := utf8.DecodeRune(data)
+			keys = append(keys, string(r))
+			data = data[size:]
+			continue
+		}
+		data = data[1:]
+	}
+	return keys
+}
@@ -0,0 +1,718 @@
+// Package ui implements goodness ui, a terminal front end for the batch
+// commands. It lists the files in a folder waiting to be converted or
+// rewritten, previews the markdown changes, and runs the files picked, or
+// a workflow, showing each step as it goes
+package ui
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/internal/textdiff"
+	"GoodnessucWorkflow/jpgr"
+	"GoodnessucWorkflow/pkg/markdown"
+	"GoodnessucWorkflow/workflow"
+)
+
+// Run is the ui command
+func Run(args []string) {
+	fs := flag.NewFlagSet("ui", flag.ExitOnError)
+	logging.AddFlags(fs)
+	transforms := fs.String("transforms", "bold", "comma separated markdown transforms to preview and apply, in order: "+strings.Join(markdown.Names(), ", "))
+	jobs := fs.Int("jobs", runtime.NumCPU(), "steps run at once when a workflow's steps declare needs")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness ui [flags] [folder]\n\nOpens a terminal interface on folder, the working directory by default. It\nlists the images goodness img convert would convert and the markdown files\n-transforms would change, previews those changes, and runs the files picked\nor a workflow with live progress. Keys are shown at the bottom; q quits.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	dir := cmp.Or(fs.Arg(0), ".")
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		logging.Exitf(exitcode.Usage, "Invalid folder %q", dir)
+	}
+	var chain []markdown.Transform
+	for _, name := range strings.Split(*transforms, ",") {
+		t, ok := markdown.Named(strings.TrimSpace(name))
+		if !ok {
+			logging.Exitf(exitcode.Usage, "Invalid -transforms value %q: expected %s", *transforms, strings.Join(markdown.Names(), ", "))
+		}
+		chain = append(chain, t)
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		logging.Exitf(exitcode.Usage, "goodness ui needs a terminal; scripts can use goodness run and the img and md commands")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		logging.Fatalf("Failed to find the goodness executable: %s", err)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		logging.Fatalf("Failed to resolve %s: %s", dir, err)
+	}
+
+	m := &model{
+		dir:        dir,
+		abs:        abs,
+		transforms: *transforms,
+		transform:  markdown.Chain(chain...),
+		self:       self,
+		jobs:       *jobs,
+		events:     make(chan func()),
+		log:        &logBuffer{wake: make(chan struct{}, 1)},
+	}
+	if err := m.scan(); err != nil {
+		logging.Fatalf("Failed to list files: %s", err)
+	}
+	scr, err := openScreen()
+	if err != nil {
+		logging.Fatalf("Failed to set up the terminal: %s", err)
+	}
+	// Messages would scribble over the screen, so they join the step output
+	stderr := logging.SetOutput(m.log)
+	m.loop(scr)
+	scr.Close()
+	logging.SetOutput(stderr)
+	output.Exit(exitcode.OK)
+}
+
+// Kinds of pending file
+const (
+	imageFile    = "image"
+	markdownFile = "markdown"
+)
+
+// item is a file waiting to be converted or rewritten
+type item struct {
+	path     string // relative to the folder
+	kind     string
+	included bool
+	note     string
+	// before and after are a markdown file's content and its content once
+	// transformed
+	before, after string
+}
+
+type view int
+
+const (
+	listView view = iota
+	previewView
+	workflowView
+	progressView
+)
+
+// row is the progress of one step or markdown file in a run
+type row struct {
+	workflow.Result
+	started time.Time
+}
+
+// model is the state of the interface. Only the loop goroutine touches it;
+// runs hand it changes through events
+type model struct {
+	dir, abs   string
+	transforms string
+	transform  markdown.Transform
+	self       string
+	jobs       int
+
+	view   view
+	status string
+
+	items       []*item
+	cursor, top int
+
+	diff   []textdiff.Line
+	scroll int
+
+	workflows []string
+	choice    int
+
+	title   string
+	rows    []*row
+	running bool
+	cancel  context.CancelFunc
+	summary string
+
+	events chan func()
+	log    *logBuffer
+}
+
+// loop draws the screen and handles keys and run events until quit
+func (m *model) loop(scr *screen) {
+	keys := make(chan string)
+	go readKeys(scr.in, keys)
+	// Running steps show their time so far
+	tick := time.NewTicker(time.Second / 2)
+	defer tick.Stop()
+	for {
+		_, height := scr.size()
+		body, footer := m.render(height)
+		scr.draw(body, footer)
+
+		var ticks <-chan time.Time
+		if m.running {
+			ticks = tick.C
+		}
+		select {
+		case k, ok := <-keys:
+			if !ok || m.key(k) {
+				if m.cancel != nil {
+					m.cancel()
+				}
+				return
+			}
+		case f := <-m.events:
+			f()
+		case <-m.log.wake:
+		case <-ticks:
+		}
+	}
+}
+
+// scan lists the pending files again, keeping files left out as they were
+func (m *model) scan() error {
+	excluded := make(map[string]bool)
+	for _, it := range m.items {
+		if !it.included {
+			excluded[it.path] = true
+		}
+	}
+
+	var items []*item
+	images, err := jpgr.Pending(m.dir)
+	if err != nil {
+		return err
+	}
+	for _, path := range images {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		items = append(items, &item{path: m.rel(path), kind: imageFile, note: fsutil.FormatBytes(info.Size())})
+	}
+
+	err = filepath.WalkDir(m.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != m.dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ext := strings.ToLower(filepath.Ext(path)); d.IsDir() || (ext != ".md" && ext != ".markdown") {
+			return nil
+		}
+		before, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		after, err := io.ReadAll(m.transform(bytes.NewReader(before)))
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		if bytes.Equal(before, after) {
+			return nil
+		}
+		added, removed := textdiff.Stat(textdiff.Lines(string(before), string(after)))
+		items = append(items, &item{
+			path:   m.rel(path),
+			kind:   markdownFile,
+			note:   fmt.Sprintf("+%d -%d lines", added, removed),
+			before: string(before),
+			after:  string(after),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].path < items[j].path })
+	for _, it := range items {
+		it.included = !excluded[it.path]
+	}
+	m.items = items
+	m.cursor = max(min(m.cursor, len(items)-1), 0)
+	return nil
+}
+
+// rel returns path relative to the folder
+func (m *model) rel(path string) string {
+	if rel, err := filepath.Rel(m.dir, path); err == nil {
+		return rel
+	}
+	return path
+}
+
+// key handles one key and reports whether to quit
+func (m *model) key(k string) bool {
+	switch m.view {
+	case listView:
+		m.status = ""
+		switch k {
+		case "q", "ctrl-c":
+			return true
+		case "up", "k":
+			m.move(-1)
+		case "down", "j":
+			m.move(1)
+		case "pgup":
+			m.move(-10)
+		case "pgdown":
+			m.move(10)
+		case "home", "g":
+			m.move(-len(m.items))
+		case "end", "G":
+			m.move(len(m.items))
+		case "space":
+			if len(m.items) > 0 {
+				m.items[m.cursor].included = !m.items[m.cursor].included
+				m.move(1)
+			}
+		case "a":
+			all := true
+			for _, it := range m.items {
+				all = all && it.included
+			}
+			for _, it := range m.items {
+				it.included = !all
+			}
+		case "enter", "p":
+			if len(m.items) > 0 {
+				it := m.items[m.cursor]
+				m.diff, m.scroll, m.view = nil, 0, previewView
+				if it.kind == markdownFile {
+					m.diff = textdiff.Lines(it.before, it.after)
+				}
+			}
+		case "r":
+			m.runIncluded()
+		case "w":
+			m.workflows, m.choice, m.view = workflow.Names(), 0, workflowView
+		case "s":
+			if err := m.scan(); err != nil {
+				m.status = fmt.Sprintf("Failed to list files: %s", err)
+			}
+		}
+
+	case previewView:
+		switch k {
+		case "up", "k":
+			m.scroll = max(m.scroll-1, 0)
+		case "down", "j":
+			m.scroll = min(m.scroll+1, max(len(m.diff)-1, 0))
+		case "pgup":
+			m.scroll = max(m.scroll-10, 0)
+		case "pgdown":
+			m.scroll = min(m.scroll+10, max(len(m.diff)-1, 0))
+		case "space":
+			it := m.items[m.cursor]
+			it.included = !it.included
+		case "q", "esc", "enter", "left", "ctrl-c":
+			m.view = listView
+		}
+
+	case workflowView:
+		switch k {
+		case "up", "k":
+			m.choice = max(m.choice-1, 0)
+		case "down", "j":
+			m.choice = min(m.choice+1, max(len(m.workflows)-1, 0))
+		case "enter":
+			if len(m.workflows) > 0 {
+				m.runWorkflow(m.workflows[m.choice])
+			}
+		case "q", "esc", "left", "ctrl-c":
+			m.view = listView
+		}
+
+	case progressView:
+		if m.running {
+			if k == "ctrl-c" {
+				m.cancel()
+				m.status = "Stopping"
+			}
+			return false
+		}
+		if k == "q" || k == "ctrl-c" {
+			return true
+		}
+		m.view, m.status = listView, ""
+	}
+	return false
+}
+
+// move moves the cursor by n files, staying on the list
+func (m *model) move(n int) {
+	m.cursor = max(min(m.cursor+n, len(m.items)-1), 0)
+}
+
+// runIncluded converts the included images with goodness img convert and
+// writes the included markdown files transformed
+func (m *model) runIncluded() {
+	var images, docs []string
+	for _, it := range m.items {
+		switch {
+		case !it.included:
+		case it.kind == imageFile:
+			images = append(images, it.path)
+		default:
+			docs = append(docs, it.path)
+		}
+	}
+	if len(images)+len(docs) == 0 {
+		m.status = "Nothing included; space picks a file and a picks them all"
+		return
+	}
+	var steps []workflow.Step
+	if len(images) > 0 {
+		steps = append(steps, workflow.Step{Name: "img convert", Run: "img convert", Args: images})
+	}
+	w := &workflow.Workflow{Name: "ui", Dir: m.abs, Steps: steps}
+	m.start(fmt.Sprintf("Running %d included files", len(images)+len(docs)), w, docs)
+}
+
+// runWorkflow runs the workflow file or configured workflow name
+func (m *model) runWorkflow(name string) {
+	path, err := workflow.Resolve(name)
+	if err == nil {
+		var w *workflow.Workflow
+		if w, err = workflow.Load(path); err == nil {
+			m.start("Workflow "+w.Name, w, nil)
+			return
+		}
+	}
+	m.view, m.status = listView, fmt.Sprintf("Invalid workflow: %s", err)
+}
+
+// start runs the markdown files docs, then the steps of w, in the
+// background, feeding their progress to the progress view
+func (m *model) start(title string, w *workflow.Workflow, docs []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.view, m.title, m.summary, m.status = progressView, title, "", ""
+	m.running, m.cancel = true, cancel
+	m.log.reset()
+
+	m.rows = nil
+	rows := make(map[string]*row)
+	for _, doc := range docs {
+		r := &row{Result: workflow.Result{Step: "md " + doc}}
+		m.rows = append(m.rows, r)
+		rows[r.Step] = r
+	}
+	for _, s := range w.Steps {
+		r := &row{Result: workflow.Result{Step: s.Name}}
+		m.rows = append(m.rows, r)
+		rows[r.Step] = r
+	}
+	progress := func(res workflow.Result) {
+		m.events <- func() {
+			r := rows[res.Step]
+			r.Result = res
+			if res.Status == "running" {
+				r.started = time.Now()
+			}
+		}
+	}
+
+	c := workflow.NewContext(w, nil)
+	c.Output = m.log
+	c.Progress = progress
+	go func() {
+		begin := time.Now()
+		var results []workflow.Result
+		for _, doc := range docs {
+			r := m.rewrite(ctx, doc, progress)
+			progress(r)
+			results = append(results, r)
+		}
+		if len(w.Steps) > 0 {
+			results = append(results, workflow.Execute(ctx, c, m.self, w.Steps, m.jobs, false)...)
+		}
+		elapsed := time.Since(begin)
+		m.events <- func() { m.finish(results, elapsed) }
+	}()
+}
+
+// rewrite writes the markdown file doc transformed, if that changes it
+func (m *model) rewrite(ctx context.Context, doc string, progress func(workflow.Result)) workflow.Result {
+	r := workflow.Result{Step: "md " + doc, Status: "skipped"}
+	if ctx.Err() != nil {
+		return r
+	}
+	r.Status, r.Attempts = "running", 1
+	progress(r)
+
+	start := time.Now()
+	path := filepath.Join(m.dir, doc)
+	before, err := os.ReadFile(path)
+	var after []byte
+	if err == nil {
+		after, err = io.ReadAll(m.transform(bytes.NewReader(before)))
+	}
+	if err == nil && !bytes.Equal(before, after) {
+		err = journal.WriteFile(path, after, 0644)
+	}
+	r.Duration = time.Since(start)
+	if err != nil {
+		logging.Errorf("Failed to rewrite %s: %s", path, err)
+		r.Status, r.Err, r.Code = "failed", err, exitcode.Error
+		return r
+	}
+	logging.Infof("Rewrote %s with %s", path, m.transforms)
+	output.Wrote(path)
+	r.Status = "ok"
+	return r
+}
+
+// finish ends a run and lists the files again, as the run changed them
+func (m *model) finish(results []workflow.Result, elapsed time.Duration) {
+	m.running = false
+	m.cancel()
+	m.cancel = nil
+	counts := make(map[string]int)
+	for _, r := range results {
+		counts[r.Status]++
+	}
+	m.summary = fmt.Sprintf("%d ok, %d failed, %d skipped in %s", counts["ok"], counts["failed"], counts["skipped"], elapsed.Round(time.Millisecond))
+	if err := m.scan(); err != nil {
+		m.status = fmt.Sprintf("Failed to list files: %s", err)
+	}
+}
+
+// render returns the body and footer of the current view for a screen
+// height rows tall
+func (m *model) render(height int) (body, footer []line) {
+	switch m.view {
+	case previewView:
+		body, footer = m.renderPreview(height)
+	case workflowView:
+		body, footer = m.renderWorkflows()
+	case progressView:
+		body, footer = m.renderProgress(height)
+	default:
+		body, footer = m.renderList(height)
+	}
+	if m.status != "" {
+		footer = append([]line{{m.status, yellow}}, footer...)
+	}
+	return body, footer
+}
+
+func (m *model) renderList(height int) (body, footer []line) {
+	included := 0
+	width := 0
+	for _, it := range m.items {
+		if it.included {
+			included++
+		}
+		width = max(width, len(it.path))
+	}
+	body = []line{{fmt.Sprintf("goodness ui  %s  %d of %d files included", m.dir, included, len(m.items)), bold}, {}}
+	footer = []line{{"space include  a all  enter preview  r run included  w workflows  s rescan  q quit", dim}}
+	if len(m.items) == 0 {
+		body = append(body, line{text: fmt.Sprintf("Nothing to convert or rewrite in %s; w runs a workflow", m.dir)})
+		return body, footer
+	}
+
+	// Keep the cursor in view, leaving room for the status line
+	room := max(height-len(body)-len(footer)-1, 1)
+	if m.cursor < m.top {
+		m.top = m.cursor
+	}
+	if m.cursor >= m.top+room {
+		m.top = m.cursor - room + 1
+	}
+	m.top = max(min(m.top, len(m.items)-room), 0)
+	for i := m.top; i < min(m.top+room, len(m.items)); i++ {
+		it := m.items[i]
+		box := "[ ]"
+		if it.included {
+			box = "[x]"
+		}
+		l := line{text: fmt.Sprintf(" %s %-8s  %-*s  %s", box, it.kind, width, it.path, it.note)}
+		if i == m.cursor {
+			l.style = reverse
+		}
+		body = append(body, l)
+	}
+	return body, footer
+}
+
+func (m *model) renderPreview(height int) (body, footer []line) {
+	it := m.items[m.cursor]
+	state := "left out"
+	if it.included {
+		state = "included"
+	}
+	footer = []line{{"up/down scroll  space include  esc back", dim}}
+
+	if it.kind == imageFile {
+		body = []line{{fmt.Sprintf("%s  (%s)", it.path, state), bold}, {}}
+		path := filepath.Join(m.dir, it.path)
+		body = append(body, line{text: "Size        " + it.note})
+		if f, err := os.Open(path); err == nil {
+			if cfg, format, err := image.DecodeConfig(f); err == nil {
+				body = append(body, line{text: "Format      " + format}, line{text: fmt.Sprintf("Dimensions  %dx%d", cfg.Width, cfg.Height)})
+			}
+			f.Close()
+		}
+		body = append(body, line{}, line{text: "Running the included files converts it as goodness img convert " + it.path + " would", style: dim})
+		return body, footer
+	}
+
+	body = []line{{fmt.Sprintf("%s  %s with %s  (%s)", it.path, it.note, m.transforms, state), bold}, {}}
+	room := max(height-len(body)-len(footer)-1, 1)
+	m.scroll = max(min(m.scroll, len(m.diff)-room), 0)
+	for _, d := range m.diff[m.scroll:min(m.scroll+room, len(m.diff))] {
+		switch d.Kind {
+		case '-':
+			body = append(body, line{"- " + d.Text, red})
+		case '+':
+			body = append(body, line{"+ " + d.Text, green})
+		case '~':
+			body = append(body, line{"  ...", cyan})
+		default:
+			body = append(body, line{text: "  " + d.Text})
+		}
+	}
+	return body, footer
+}
+
+func (m *model) renderWorkflows() (body, footer []line) {
+	body = []line{{"Run a workflow", bold}, {}}
+	footer = []line{{"enter run  esc back", dim}}
+	if len(m.workflows) == 0 {
+		body = append(body, line{text: "No workflows: list some under workflows in the configuration, or add a .yaml file to the working directory"})
+		return body, footer
+	}
+	for i, name := range m.workflows {
+		l := line{text: "  " + name}
+		if i == m.choice {
+			l.style = reverse
+		}
+		body = append(body, l)
+	}
+	return body, footer
+}
+
+func (m *model) renderProgress(height int) (body, footer []line) {
+	body = []line{{m.title, bold}, {}}
+	width := 0
+	for _, r := range m.rows {
+		width = max(width, len(r.Step))
+	}
+	for _, r := range m.rows {
+		status, style, took := cmp.Or(r.Status, "waiting"), plain, ""
+		switch r.Status {
+		case "ok":
+			style = green
+		case "failed":
+			status, style = fmt.Sprintf("failed (exit %d)", r.Code), red
+		case "skipped":
+			style = yellow
+		case "running":
+			took = time.Since(r.started).Round(time.Second).String()
+		}
+		if r.Duration > 0 {
+			took = r.Duration.Round(time.Millisecond).String()
+		}
+		note := ""
+		if r.Err != nil {
+			note = r.Err.Error()
+		}
+		body = append(body, line{fmt.Sprintf("  %-*s  %-16s  %-8s  %s", width, r.Step, status, took, note), style})
+	}
+
+	if m.running {
+		footer = []line{{"ctrl-c stop", dim}}
+	} else {
+		footer = []line{{m.summary, bold}, {"any key back to the files  q quit", dim}}
+	}
+	// The newest output fills what is left
+	room := height - len(body) - len(footer) - 3
+	if room > 0 {
+		body = append(body, line{}, line{"Output", dim})
+		for _, l := range m.log.tail(room) {
+			body = append(body, line{text: l})
+		}
+	}
+	return body, footer
+}
+
+// maxLogLines is how much output the progress view keeps
+const maxLogLines = 1000
+
+// logBuffer keeps the latest lines of step output and log messages, waking
+// the loop as they arrive
+type logBuffer struct {
+	mu      sync.Mutex
+	lines   []string
+	partial []byte
+	wake    chan struct{}
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	b.partial = append(b.partial, p...)
+	for {
+		i := bytes.IndexByte(b.partial, '\n')
+		if i < 0 {
+			break
+		}
+		b.lines = append(b.lines, strings.TrimSuffix(string(b.partial[:i]), "\r"))
+		b.partial = b.partial[i+1:]
+	}
+	if len(b.lines) > maxLogLines {
+		b.lines = append([]string(nil), b.lines[len(b.lines)-maxLogLines:]...)
+	}
+	b.mu.Unlock()
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+// tail returns the last n lines
+func (b *logBuffer) tail(n int) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.lines[max(len(b.lines)-n, 0):]...)
+}
+
+func (b *logBuffer) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lines, b.partial = nil, nil
+}
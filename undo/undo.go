@@ -0,0 +1,105 @@
+// Package undo implements goodness undo, which reverses the file changes of
+// a run recorded in the undo journal
+package undo
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+)
+
+// Run is the undo command
+func Run(args []string) {
+	fs := flag.NewFlagSet("undo", flag.ExitOnError)
+	logging.AddFlags(fs)
+	list := fs.Bool("list", false, "list the runs that can be undone, newest first, and exit")
+	dryRun := fs.Bool("dry-run", false, "print the changes that would be reversed without touching any file")
+	force := fs.Bool("force", false, "undo even if files changed again since the run, losing those changes")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness undo [flags] [run-id]\n\nReverses every file change of a run, the most recent one by default:\ncreated files are removed, and changed, moved and deleted files are put\nback. A workflow is one run. The journal keeps the last 50 runs; set\nGOODNESS_JOURNAL=off to stop recording.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	if *list {
+		listRuns()
+		return
+	}
+
+	var r *journal.Run
+	var err error
+	if fs.NArg() == 1 {
+		r, err = journal.Load(fs.Arg(0))
+	} else {
+		r, err = journal.Latest()
+	}
+	if errors.Is(err, journal.ErrEmpty) {
+		logging.Exitf(exitcode.NoMatch, "Nothing to undo: %s", err)
+	}
+	if err != nil {
+		logging.Fatalf("Failed to read the undo journal: %s", err)
+	}
+	if len(r.Entries) == 0 {
+		logging.Exitf(exitcode.NoMatch, "Nothing to undo: run %s changed no files", r.ID)
+	}
+
+	fmt.Printf("Run %s, goodness %s, at %s:\n\n", r.ID, strings.Join(r.Command, " "), r.Started.Local().Format(time.DateTime))
+	for i := len(r.Entries) - 1; i >= 0; i-- {
+		fmt.Printf("  undo %s\n", r.Entries[i])
+	}
+	output.Set("run", r.ID)
+	output.Set("changes", len(r.Entries))
+	if *dryRun {
+		return
+	}
+
+	if errs := r.Check(); len(errs) > 0 {
+		for _, err := range errs {
+			logging.Warnf("Changed since the run: %s", err)
+		}
+		if !*force {
+			logging.Fatalf("Failed to undo run %s: %d files changed since; use -force to undo anyway", r.ID, len(errs))
+		}
+	}
+	if err := r.Undo(*force); err != nil {
+		logging.Fatalf("Failed to undo run %s: %s", r.ID, err)
+	}
+	fmt.Printf("\nUndid %d changes\n", len(r.Entries))
+}
+
+// listRuns prints the runs in the journal
+func listRuns() {
+	runs, err := journal.Runs()
+	if err != nil {
+		logging.Fatalf("Failed to read the undo journal: %s", err)
+	}
+	if len(runs) == 0 {
+		fmt.Println("Nothing to undo")
+		return
+	}
+	report := make([]map[string]any, len(runs))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN\tSTARTED\tCHANGES\tCOMMAND")
+	for i, r := range runs {
+		fmt.Fprintf(tw, "%s\t%s\t%d\tgoodness %s\n", r.ID, r.Started.Local().Format(time.DateTime), len(r.Entries), strings.Join(r.Command, " "))
+		report[i] = map[string]any{"id": r.ID, "started": r.Started, "changes": len(r.Entries), "command": r.Command}
+	}
+	tw.Flush()
+	output.Set("runs", report)
+}
@@ -0,0 +1,179 @@
+// Package version implements goodness version, which reports what build of
+// goodness is running and which formats it can read and write on this
+// machine, for telling installs apart when they behave differently.
+//
+// Release builds set the version, commit and date with -ldflags:
+//
+//	go build -ldflags "-X GoodnessucWorkflow/version.Version=v1.2.0
+//	  -X GoodnessucWorkflow/version.Commit=$(git rev-parse HEAD)
+//	  -X GoodnessucWorkflow/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/goodness
+//
+// Otherwise they come from the module and VCS information Go embeds
+package version
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"text/tabwriter"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+)
+
+// Set with -ldflags -X; empty values fall back to the build information
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+// Info describes the running build
+type Info struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	// Modified is set when the build had uncommitted changes
+	Modified bool   `json:"modified,omitempty"`
+	Date     string `json:"date,omitempty"`
+	Go       string `json:"go"`
+	Platform string `json:"platform"`
+}
+
+// Get returns the version of the running build
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, Go: runtime.Version(), Platform: runtime.GOOS + "/" + runtime.GOARCH}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		if info.Version == "" {
+			info.Version = "(unknown)"
+		}
+		return info
+	}
+	if info.Version == "" {
+		info.Version = build.Main.Version
+	}
+	for _, s := range build.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = s.Value
+			}
+		case "vcs.modified":
+			info.Modified = Commit == "" && s.Value == "true"
+		}
+	}
+	if info.Version == "" {
+		info.Version = "(devel)"
+	}
+	return info
+}
+
+// Format is how well one format is supported on this machine
+type Format struct {
+	Name  string `json:"name"`
+	Read  bool   `json:"read"`
+	Write bool   `json:"write"`
+	// Needs is the program the support depends on, and Found where it is
+	Needs string `json:"needs,omitempty"`
+	Found string `json:"found,omitempty"`
+	Note  string `json:"note,omitempty"`
+}
+
+// Formats reports the image and video formats goodness can read and write,
+// looking up the programs some of them need on PATH
+func Formats() []Format {
+	cwebp, _ := exec.LookPath("cwebp")
+	dcraw, _ := exec.LookPath("dcraw")
+	ffmpeg, _ := exec.LookPath("ffmpeg")
+	raw := "embedded previews only"
+	if dcraw != "" {
+		raw = ""
+	}
+	return []Format{
+		{Name: "png", Read: true, Write: true},
+		{Name: "jpeg", Read: true, Write: true},
+		{Name: "gif", Read: true, Write: true},
+		{Name: "webp", Read: true, Write: cwebp != "", Needs: "cwebp", Found: cwebp},
+		{Name: "svg", Read: true},
+		{Name: "raw", Read: true, Needs: "dcraw", Found: dcraw, Note: raw},
+		{Name: "avif", Note: "not supported"},
+		{Name: "heic", Note: "not supported"},
+		{Name: "mp4", Write: ffmpeg != "", Needs: "ffmpeg", Found: ffmpeg},
+		{Name: "webm", Write: ffmpeg != "", Needs: "ffmpeg", Found: ffmpeg},
+	}
+}
+
+// Run is the version command
+func Run(args []string) {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	logging.AddFlags(fs)
+	short := fs.Bool("short", false, "print only the version")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness version [flags]\n\nPrints the version, commit and build date of goodness, the Go version it\nwas built with, and which image and video formats it can read and write\nhere, with the programs some of them need.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() > 0 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+
+	info := Get()
+	output.Set("version", info)
+	if *short {
+		fmt.Println(info.Version)
+		output.Exit(exitcode.OK)
+	}
+	formats := Formats()
+	output.Set("formats", formats)
+
+	fmt.Printf("goodness %s\n", info.Version)
+	if info.Commit != "" {
+		modified := ""
+		if info.Modified {
+			modified = i18n.T(" (modified)")
+		}
+		fmt.Printf("%s %s%s\n", i18n.T("commit:"), info.Commit, modified)
+	}
+	if info.Date != "" {
+		fmt.Printf("%s %s\n", i18n.T("built:"), info.Date)
+	}
+	fmt.Printf("%s %s %s\n\n", i18n.T("go:"), info.Go, info.Platform)
+
+	yes, no := i18n.T("yes"), i18n.T("no")
+	answer := func(b bool) string {
+		if b {
+			return yes
+		}
+		return no
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, i18n.T("FORMAT\tREAD\tWRITE\tNOTES"))
+	for _, f := range formats {
+		note := i18n.T(f.Note)
+		switch {
+		case f.Needs != "" && f.Found != "":
+			note = i18n.Sprintf("%s at %s", f.Needs, f.Found)
+		case f.Needs != "":
+			note = i18n.Sprintf("%s not found", f.Needs)
+			if f.Note != "" {
+				note += "; " + i18n.T(f.Note)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", f.Name, answer(f.Read), answer(f.Write), note)
+	}
+	tw.Flush()
+	output.Exit(exitcode.OK)
+}
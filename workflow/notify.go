@@ -0,0 +1,138 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/notify"
+)
+
+// Notify posts a summary of each run to a chat webhook. Exactly one of
+// Slack and Discord holds the webhook's URL, usually "${VAR}" so it comes
+// from the environment. On is always, the default, failure or success.
+// Message is a text/template rendered with a Summary in place of the default
+type Notify struct {
+	Slack   string `yaml:"slack"`
+	Discord string `yaml:"discord"`
+	On      string `yaml:"on"`
+	Message string `yaml:"message"`
+
+	message *template.Template
+}
+
+// Summary is what notification messages are rendered from
+type Summary struct {
+	Workflow string
+	Host     string
+	// Status is succeeded or failed, as the run's exit status says
+	Status   string
+	Duration time.Duration
+	OK       int
+	Failed   int
+	Skipped  int
+	Steps    []Result
+	Vars     map[string]string
+}
+
+// defaultMessage lists the steps that failed or were skipped under a line
+// with the totals
+const defaultMessage = `{{if eq .Status "failed"}}❌{{else}}✅{{end}} Workflow {{.Workflow}} {{.Status}} on {{.Host}} in {{.Duration}}: {{.OK}} ok, {{.Failed}} failed, {{.Skipped}} skipped
+{{- range .Steps}}{{if .Err}}
+• {{.Step}} {{.Status}}: {{.Err}}{{end}}{{end}}`
+
+func (n *Notify) check() error {
+	switch {
+	case n.Slack == "" && n.Discord == "":
+		return errors.New("needs slack or discord")
+	case n.Slack != "" && n.Discord != "":
+		return errors.New("sets both slack and discord; add one notify entry for each")
+	}
+	switch n.On {
+	case "":
+		n.On = "always"
+	case "always", "failure", "success":
+	default:
+		return fmt.Errorf("on: expected always, failure or success, got %q", n.On)
+	}
+	text := n.Message
+	if text == "" {
+		text = defaultMessage
+	}
+	var err error
+	if n.message, err = template.New("message").Option("missingkey=zero").Parse(text); err != nil {
+		return fmt.Errorf("message: %s", err)
+	}
+	return nil
+}
+
+// service returns the chat service and the webhook's URL as written
+func (n *Notify) service() (string, string) {
+	if n.Slack != "" {
+		return notify.Slack, n.Slack
+	}
+	return notify.Discord, n.Discord
+}
+
+// summarize describes a run that took elapsed for notifications
+func (c *Context) summarize(results []Result, elapsed time.Duration) Summary {
+	host, _ := os.Hostname()
+	s := Summary{
+		Workflow: c.Workflow.Name,
+		Host:     host,
+		Status:   "succeeded",
+		Duration: elapsed.Round(time.Millisecond),
+		Steps:    results,
+		Vars:     c.Vars,
+	}
+	for _, r := range results {
+		switch r.Status {
+		case "ok":
+			s.OK++
+		case "failed":
+			s.Failed++
+		case "skipped":
+			s.Skipped++
+		}
+	}
+	if ExitCode(results) != 0 {
+		s.Status = "failed"
+	}
+	return s
+}
+
+// notify posts the summary of a run to each webhook that wants it. Failing
+// to post is warned about and leaves the run's outcome alone
+func (c *Context) notify(results []Result, elapsed time.Duration) {
+	if len(c.Workflow.Notify) == 0 {
+		return
+	}
+	summary := c.summarize(results, elapsed)
+	for _, n := range c.Workflow.Notify {
+		if n.On == "failure" && summary.Status != "failed" || n.On == "success" && summary.Status == "failed" {
+			continue
+		}
+		service, webhook := n.service()
+		webhook = c.Expand(webhook)
+		if strings.Contains(webhook, "${") {
+			logging.Warnf("Failed to notify %s: the webhook %s is not set", service, webhook)
+			continue
+		}
+		var text bytes.Buffer
+		if err := n.message.Execute(&text, summary); err != nil {
+			logging.Warnf("Failed to notify %s: message: %s", service, err)
+			continue
+		}
+		if err := notify.Send(context.Background(), service, webhook, strings.TrimSpace(text.String())); err != nil {
+			logging.Warnf("Failed to notify %s: %s", service, err)
+			continue
+		}
+		logging.Debugf("Notified %s", service)
+	}
+}
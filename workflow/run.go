@@ -0,0 +1,531 @@
+package workflow
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/config"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/internal/storage"
+)
+
+// Result is the outcome of one step
+type Result struct {
+	Step     string
+	Status   string // ok, failed, skipped or planned
+	Code     int
+	Attempts int
+	Duration time.Duration
+	// Err is why the step failed or was skipped
+	Err error
+	// Stopped is set on a failed step that skipped the steps waiting for it
+	Stopped bool
+}
+
+// Run is the run command
+func Run(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	logging.AddFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "print the command of each step without running it")
+	jobs := fs.Int("jobs", runtime.NumCPU(), "steps run at once when steps declare needs")
+	only := fs.String("only", "", "comma separated names of the steps to run, in workflow order")
+	notify := fs.Bool("notify", true, "post the summary to the workflow's notify webhooks; -notify=false skips them")
+	sandboxed := fs.Bool("sandbox", false, "run the steps on a copy of the workflow's folder, show what they changed and copy it back on confirmation")
+	yes := fs.Bool("yes", false, "with -sandbox, copy the changes back without asking")
+	remote := fs.String("remote", "", "run the steps on a folder on a server, `user@host:/path` or ssh://user@host/path, downloading it first and uploading the files the steps write")
+	overrides := make(map[string]string)
+	fs.Func("var", "set a workflow variable as NAME=VALUE, overriding the file; repeat for each variable", func(value string) error {
+		name, v, ok := strings.Cut(value, "=")
+		if !ok || name == "" {
+			return errors.New("expected NAME=VALUE")
+		}
+		overrides[name] = v
+		return nil
+	})
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.T("Usage: goodness run [flags] workflow.yaml|name\n\nRuns the steps of a workflow file in the file's directory. A step follows\nthe one before it, or the steps listed in its needs, and a failed step\nskips the steps after it unless it sets continue-on-error. Steps whose needs\nare met run at the same time. A name refers to a file listed under\nworkflows in the configuration.\n\nWith -sandbox the steps run on a temporary copy of the workflow's folder.\nThe files they added, changed or removed are listed, with the changed\nlines of text files, and copied back only once confirmed, as one run for\ngoodness undo.\n\nWith -remote the steps run on a folder on a server instead, reached with\nssh. It is downloaded to a temporary folder, and the files the steps\ncreate or change there are uploaded back unless the workflow stops with an\nerror. Deleted files stay on the server.\n\n"))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	if *sandboxed && *dryRun {
+		logging.Exitf(exitcode.Usage, "-sandbox and -dry-run cannot be combined")
+	}
+	if *yes && !*sandboxed {
+		logging.Exitf(exitcode.Usage, "-yes only applies with -sandbox")
+	}
+	if *remote != "" && *sandboxed {
+		logging.Exitf(exitcode.Usage, "-remote and -sandbox cannot be combined")
+	}
+	location := *remote
+	if ssh, ok := storage.SSHLocation(location); ok {
+		location = ssh
+	}
+	if location != "" && !storage.IsRemote(location) {
+		logging.Exitf(exitcode.Usage, "Invalid -remote value %q; expected user@host:/path or a location such as ssh://user@host/path", *remote)
+	}
+	path, err := Resolve(fs.Arg(0))
+	if err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid workflow: %s", err)
+	}
+	w, err := Load(path)
+	if err != nil {
+		output.AnnotateError(path, "invalid workflow", err)
+		logging.Exitf(exitcode.Usage, "Invalid workflow: %s", err)
+	}
+	var names []string
+	if *only != "" {
+		names = strings.Split(*only, ",")
+	}
+	steps, err := w.Select(names)
+	if err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid -only value %q: %s", *only, err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		logging.Fatalf("Failed to find the goodness executable: %s", err)
+	}
+
+	if location != "" && !*dryRun {
+		dir, err := storage.Mirror(location)
+		if err != nil {
+			logging.Fatalf("Failed to download %s: %s", location, err)
+		}
+		cli.UploadOnExit()
+		// The steps change a temporary copy, which undo cannot reach
+		w.Dir = dir
+		disableJournal(w)
+	}
+
+	var box *sandbox
+	if *sandboxed {
+		if box, err = newSandbox(w.Dir); err != nil {
+			logging.Fatalf("Failed to copy %s to a sandbox: %s", w.Dir, err)
+		}
+		output.BeforeExit(func(code int) int {
+			if err := box.close(); err != nil {
+				logging.Warnf("Failed to remove the sandbox %s: %s", box.tmp, err)
+			}
+			return code
+		})
+		// The steps' own changes are to the copy and would only clutter
+		// undo and the audit log; copying back records them instead
+		w.Dir = box.root
+		disableJournal(w)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	c := NewContext(w, overrides)
+	if box != nil {
+		if paths := box.outside(c, self, steps); len(paths) > 0 {
+			logging.Exitf(exitcode.Usage, "Steps use paths outside %s, which a sandbox cannot hold: %s", box.dir, strings.Join(paths, ", "))
+		}
+	}
+	start := time.Now()
+	results := Execute(ctx, c, self, steps, *jobs, *dryRun)
+	elapsed := time.Since(start)
+	PrintResults(os.Stdout, results, elapsed)
+	// A sandboxed run has changed nothing yet, so there is nothing to notify
+	if *notify && !*dryRun && box == nil {
+		c.notify(results, elapsed)
+	}
+	report := make([]map[string]any, len(results))
+	for i, r := range results {
+		report[i] = map[string]any{"step": r.Step, "status": r.Status, "exit_code": r.Code, "attempts": r.Attempts, "duration_ms": r.Duration.Milliseconds()}
+		if r.Err != nil {
+			report[i]["error"] = r.Err.Error()
+		}
+	}
+	output.Set("steps", report)
+	interrupted := ctx.Err() != nil
+	stop()
+	if box != nil && !interrupted {
+		syncBack(box, *yes)
+	}
+	output.Exit(ExitCode(results))
+}
+
+// disableJournal keeps the steps of w out of the undo journal and the audit
+// log, for runs in a temporary folder
+func disableJournal(w *Workflow) {
+	if w.Env == nil {
+		w.Env = make(map[string]string)
+	}
+	w.Env["GOODNESS_JOURNAL"] = "off"
+	w.Env["GOODNESS_AUDIT"] = "off"
+}
+
+// syncBack shows what the steps changed in box and copies it to the
+// workflow's folder when -yes is set or the user agrees
+func syncBack(box *sandbox, yes bool) {
+	changes, err := box.changes()
+	if err != nil {
+		logging.Fatalf("Failed to compare the sandbox with %s: %s", box.dir, err)
+	}
+	output.Set("sandbox", changes)
+	fmt.Println()
+	if len(changes) == 0 {
+		logging.Infof("The steps changed no files")
+		return
+	}
+	box.printChanges(os.Stdout, changes)
+	if !yes {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			logging.Infof("Left %s unchanged; pass -yes to copy the changes back without asking", box.dir)
+			return
+		}
+		fmt.Print(i18n.Sprintf("\nCopy these %d changes to %s? [y/N] ", len(changes), box.dir))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+			logging.Infof("Left %s unchanged", box.dir)
+			return
+		}
+	}
+	if err := box.apply(changes); err != nil {
+		logging.Fatalf("Failed to copy the changes to %s: %s", box.dir, err)
+	}
+	logging.Infof("Copied %d changes to %s; goodness undo reverses them", len(changes), box.dir)
+}
+
+// Resolve returns the file of a workflow given as a path or as a name from
+// the configuration files
+func Resolve(arg string) (string, error) {
+	if _, err := os.Stat(arg); err == nil {
+		return arg, nil
+	}
+	workflows, err := config.Workflows()
+	if err != nil {
+		return "", fmt.Errorf("invalid configuration: %s", err)
+	}
+	if path, ok := workflows[arg]; ok {
+		return path, nil
+	}
+	return "", fmt.Errorf("no workflow file or configured workflow named %q", arg)
+}
+
+// Names lists the configured workflow names and the YAML files in the
+// working directory, for completion
+func Names() []string {
+	workflows, _ := config.Workflows()
+	names := sortedKeys(workflows)
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		files, _ := filepath.Glob(pattern)
+		names = append(names, files...)
+	}
+	return names
+}
+
+// Execute runs steps with c and returns a result for each, in workflow
+// order. A step starts once the steps it waits for are done, with at most
+// jobs running at once. A failing step skips the steps that wait for it,
+// directly or not, unless it continues on error; other branches carry on.
+// With dryRun set the commands are printed instead
+func Execute(ctx context.Context, c *Context, self string, steps []Step, jobs int, dryRun bool) []Result {
+	deps := dependencies(steps)
+	if dryRun {
+		ordered, err := order(steps)
+		if err != nil {
+			ordered = steps
+		}
+		results := make([]Result, 0, len(steps))
+		for _, s := range ordered {
+			argv, _, _ := c.Command(self, s)
+			after := ""
+			if s.Needs != nil {
+				after = " (after " + cmp.Or(strings.Join(deps[s.Name], ", "), "nothing") + ")"
+			}
+			fmt.Printf("%s%s: %s\n", s.Name, after, quoteArgs(argv))
+			results = append(results, Result{Step: s.Name, Status: "planned"})
+		}
+		return results
+	}
+
+	// The steps record into one run, so goodness undo reverses the workflow
+	var journalEnv []string
+	if id, err := journal.Start(); err != nil {
+		logging.Warnf("Failed to start the undo journal: %s", err)
+	} else if id != "" {
+		journalEnv = []string{journal.EnvRun + "=" + id}
+	}
+
+	// Steps that may overlap get their output prefixed with their name
+	// rather than the terminal to themselves
+	shared := jobs > 1 && concurrent(steps)
+	var outputMu sync.Mutex
+
+	results := make(map[string]*Result, len(steps))
+	done := make(chan Result)
+	running := 0
+	for {
+		// Settle every step that can be settled without running anything,
+		// then start what is ready
+		for changed := true; changed; {
+			changed = false
+			for _, s := range steps {
+				if results[s.Name] != nil {
+					continue
+				}
+				if blocker := blockedBy(deps[s.Name], results); blocker != "" || ctx.Err() != nil {
+					r := &Result{Step: s.Name, Status: "skipped"}
+					if blocker != "" {
+						r.Err = fmt.Errorf("needs %s, which did not succeed", blocker)
+					}
+					results[s.Name] = r
+					c.report(*r)
+					changed = true
+					continue
+				}
+				if running >= max(jobs, 1) || !finished(deps[s.Name], results) {
+					continue
+				}
+				results[s.Name] = &Result{Step: s.Name, Status: "running"}
+				c.report(*results[s.Name])
+				running++
+				go func() {
+					done <- c.runStep(ctx, self, s, journalEnv, shared, &outputMu)
+				}()
+				changed = true
+			}
+		}
+		if running == 0 {
+			break
+		}
+		r := <-done
+		running--
+		results[r.Step] = &r
+		c.report(r)
+	}
+
+	ordered := make([]Result, 0, len(steps))
+	for _, s := range steps {
+		ordered = append(ordered, *results[s.Name])
+	}
+	return ordered
+}
+
+// report passes r to c.Progress, if set
+func (c *Context) report(r Result) {
+	if c.Progress != nil {
+		c.Progress(r)
+	}
+}
+
+// blockedBy returns the first of deps that was skipped or failed without
+// continuing on error, or ""
+func blockedBy(deps []string, results map[string]*Result) string {
+	for _, dep := range deps {
+		if r := results[dep]; r != nil && (r.Status == "skipped" || r.Stopped) {
+			return dep
+		}
+	}
+	return ""
+}
+
+// finished reports whether every step in deps is done
+func finished(deps []string, results map[string]*Result) bool {
+	for _, dep := range deps {
+		if r := results[dep]; r == nil || r.Status == "running" {
+			return false
+		}
+	}
+	return true
+}
+
+// runStep runs one step, retrying it as it allows. With shared set, or
+// c.Output, its output is prefixed with its name and it gets no input
+func (c *Context) runStep(ctx context.Context, self string, s Step, journalEnv []string, shared bool, outputMu *sync.Mutex) Result {
+	argv, dir, env := c.Command(self, s)
+	start := time.Now()
+	r := Result{Step: s.Name, Status: "ok"}
+	for r.Attempts = 1; ; r.Attempts++ {
+		if r.Attempts == 1 {
+			logging.Infof("Step %s started", s.Name)
+		}
+		logging.Debugf("Running %s in %s", quoteArgs(argv), dir)
+
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if s.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, s.Timeout)
+		}
+		cmd := exec.CommandContext(attemptCtx, argv[0], argv[1:]...)
+		cmd.Dir = dir
+		cmd.Env = append(append(os.Environ(), journalEnv...), env...)
+		var out *prefixWriter
+		if shared || c.Output != nil {
+			var w io.Writer = os.Stdout
+			if c.Output != nil {
+				w = c.Output
+			}
+			out = &prefixWriter{w: w, mu: outputMu, prefix: "[" + s.Name + "] "}
+			cmd.Stdout, cmd.Stderr = out, out
+		} else {
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		}
+		err := cmd.Run()
+		if out != nil {
+			out.Flush()
+		}
+		if err != nil && errors.Is(attemptCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			err = fmt.Errorf("timed out after %s", s.Timeout)
+		}
+		cancel()
+
+		if err == nil {
+			r.Status, r.Err, r.Code = "ok", nil, 0
+			break
+		}
+		r.Status, r.Err, r.Code = "failed", err, exitcode.Error
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			r.Code = exitErr.ExitCode()
+		}
+		if r.Attempts > s.Retries || ctx.Err() != nil {
+			break
+		}
+
+		wait := min(s.RetryDelay<<(r.Attempts-1), maxRetryDelay)
+		logging.Warnf("Step %s failed, retrying in %s (attempt %d of %d): %s", s.Name, wait, r.Attempts+1, s.Retries+1, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+	}
+	r.Duration = time.Since(start)
+
+	switch {
+	case r.Err == nil:
+		logging.Infof("Step %s finished in %s", s.Name, r.Duration.Round(time.Millisecond))
+	case s.ContinueOnError && ctx.Err() == nil:
+		logging.Warnf("Step %s failed, continuing: %s", s.Name, r.Err)
+	default:
+		logging.Errorf("Step %s failed: %s", s.Name, r.Err)
+		r.Stopped = true
+	}
+	return r
+}
+
+// maxRetryDelay caps the doubling wait between attempts at a step
+const maxRetryDelay = 5 * time.Minute
+
+// prefixWriter writes whole lines, each starting with prefix, so the output
+// of steps running at once stays readable
+type prefixWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(data), nil
+		}
+		p.writeLine(p.buf[:i+1])
+		p.buf = p.buf[i+1:]
+	}
+}
+
+// Flush writes a last line that did not end in a newline
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.writeLine(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	io.WriteString(p.w, p.prefix)
+	p.w.Write(line)
+}
+
+// ExitCode is the status the run command exits with: the code of a step
+// that stopped the workflow, 1 when only steps that continue on error
+// failed, and 0 otherwise
+func ExitCode(results []Result) int {
+	code := exitcode.OK
+	for _, r := range results {
+		switch {
+		case r.Stopped:
+			return r.Code
+		case r.Status == "failed":
+			code = exitcode.Failures
+		}
+	}
+	return code
+}
+
+// PrintResults writes a table with the outcome of every step, why steps
+// failed or were skipped, and totals for the run, which took elapsed
+func PrintResults(w io.Writer, results []Result, elapsed time.Duration) {
+	counts := make(map[string]int)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nSTEP\tSTATUS\tATTEMPTS\tDURATION\tNOTE")
+	for _, r := range results {
+		counts[r.Status]++
+		duration, attempts, note := "-", "-", ""
+		if r.Duration > 0 {
+			duration = r.Duration.Round(time.Millisecond).String()
+		}
+		if r.Attempts > 0 {
+			attempts = strconv.Itoa(r.Attempts)
+		}
+		status := r.Status
+		if r.Status == "failed" {
+			status = fmt.Sprintf("failed (exit %d)", r.Code)
+		}
+		if r.Err != nil {
+			note = r.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Step, status, attempts, duration, note)
+	}
+	tw.Flush()
+	if counts["planned"] == 0 {
+		fmt.Fprintf(w, "\n%d ok, %d failed, %d skipped in %s\n", counts["ok"], counts["failed"], counts["skipped"], elapsed.Round(time.Millisecond))
+	}
+}
+
+// quoteArgs joins argv for display, quoting arguments a shell would split
+func quoteArgs(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = arg
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`*?") {
+			quoted[i] = strconv.Quote(arg)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,288 @@
+package workflow
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/textdiff"
+)
+
+// maxDiffSize bounds the text files whose changes are shown line by line
+const maxDiffSize = 1 << 20
+
+// sandbox is a copy of a workflow's folder for goodness run -sandbox. The
+// steps change the copy, and only the changes confirmed afterwards are
+// made to the folder itself, through the journal so undo covers them
+type sandbox struct {
+	// dir is the folder the workflow lives in and root its copy
+	dir  string
+	root string
+	tmp  string
+}
+
+// fileChange is a file the steps added, changed or removed in the sandbox,
+// by its path relative to the folder
+type fileChange struct {
+	Op   string `json:"op"` // added, changed or removed
+	Path string `json:"path"`
+	Size int64  `json:"size,omitempty"`
+}
+
+// newSandbox copies dir, the folder of a workflow, to a temporary folder.
+// Symbolic links are copied as links
+func newSandbox(dir string) (*sandbox, error) {
+	tmp, err := os.MkdirTemp("", "goodness-sandbox-*")
+	if err != nil {
+		return nil, err
+	}
+	b := &sandbox{dir: dir, root: filepath.Join(tmp, filepath.Base(dir)), tmp: tmp}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(b.root, rel)
+		switch {
+		case d.IsDir():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return fsutil.CopyFile(path, target)
+		}
+		// Sockets, pipes and devices stay behind
+		return nil
+	})
+	if err != nil {
+		b.close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// close removes the sandbox
+func (b *sandbox) close() error {
+	return os.RemoveAll(b.tmp)
+}
+
+// outside lists the paths the steps name outside the folder, as "step:
+// path", which running in the sandbox would change for real. Only
+// absolute paths and paths leading out with .. are considered
+func (b *sandbox) outside(c *Context, self string, steps []Step) []string {
+	var found []string
+	for _, s := range steps {
+		argv, dir, _ := c.Command(self, s)
+		if !inside(dir, b.root) {
+			found = append(found, s.Name+": "+dir)
+		}
+		for _, arg := range argv[1:] {
+			if strings.HasPrefix(arg, "-") {
+				_, value, ok := strings.Cut(arg, "=")
+				if !ok {
+					continue
+				}
+				arg = value
+			}
+			if strings.Contains(arg, "://") {
+				continue
+			}
+			path := arg
+			switch {
+			case filepath.IsAbs(arg):
+			case arg == ".." || strings.HasPrefix(arg, ".."+string(filepath.Separator)) || strings.HasPrefix(arg, "../"):
+				path = filepath.Join(dir, arg)
+			default:
+				continue
+			}
+			if !inside(path, b.root) {
+				found = append(found, s.Name+": "+arg)
+			}
+		}
+	}
+	return found
+}
+
+// inside reports whether path is dir or below it
+func inside(path, dir string) bool {
+	rel, err := filepath.Rel(dir, filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// changes compares the sandbox with the folder, listing the regular files
+// added, changed and removed in path order
+func (b *sandbox) changes() ([]fileChange, error) {
+	before, err := regularFiles(b.dir)
+	if err != nil {
+		return nil, err
+	}
+	after, err := regularFiles(b.root)
+	if err != nil {
+		return nil, err
+	}
+	var changes []fileChange
+	for _, rel := range sortedKeys(after) {
+		size, existed := before[rel]
+		switch {
+		case !existed:
+			changes = append(changes, fileChange{Op: "added", Path: rel, Size: after[rel]})
+		case size != after[rel]:
+			changes = append(changes, fileChange{Op: "changed", Path: rel, Size: after[rel]})
+		default:
+			same, err := sameContent(filepath.Join(b.dir, rel), filepath.Join(b.root, rel))
+			if err != nil {
+				return nil, err
+			}
+			if !same {
+				changes = append(changes, fileChange{Op: "changed", Path: rel, Size: after[rel]})
+			}
+		}
+	}
+	for _, rel := range sortedKeys(before) {
+		if _, ok := after[rel]; !ok {
+			changes = append(changes, fileChange{Op: "removed", Path: rel})
+		}
+	}
+	return changes, nil
+}
+
+// regularFiles maps the regular files under dir, by relative path, to
+// their sizes
+func regularFiles(dir string) (map[string]int64, error) {
+	files := make(map[string]int64)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		files[rel] = info.Size()
+		return err
+	})
+	return files, err
+}
+
+// sameContent reports whether the files at a and b hold the same bytes
+func sameContent(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+	ra, rb := bufio.NewReader(fa), bufio.NewReader(fb)
+	bufA, bufB := make([]byte, 64<<10), make([]byte, 64<<10)
+	for {
+		na, errA := io.ReadFull(ra, bufA)
+		nb, errB := io.ReadFull(rb, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		endA := errors.Is(errA, io.EOF) || errors.Is(errA, io.ErrUnexpectedEOF)
+		endB := errors.Is(errB, io.EOF) || errors.Is(errB, io.ErrUnexpectedEOF)
+		switch {
+		case errA != nil && !endA:
+			return false, errA
+		case errB != nil && !endB:
+			return false, errB
+		case endA || endB:
+			return endA && endB, nil
+		}
+	}
+}
+
+// printChanges lists changes and shows how each changed text file differs
+func (b *sandbox) printChanges(w io.Writer, changes []fileChange) {
+	fmt.Fprint(w, i18n.Sprintf("Changes made in the sandbox to %s:\n\n", b.dir))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range changes {
+		size := ""
+		if c.Op != "removed" {
+			size = fsutil.FormatBytes(c.Size)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", i18n.T(c.Op), c.Path, size)
+	}
+	tw.Flush()
+	for _, c := range changes {
+		if c.Op != "changed" || c.Size > maxDiffSize {
+			continue
+		}
+		before, err := os.ReadFile(filepath.Join(b.dir, c.Path))
+		if err != nil || !isText(before) {
+			continue
+		}
+		after, err := os.ReadFile(filepath.Join(b.root, c.Path))
+		if err != nil || !isText(after) {
+			continue
+		}
+		fmt.Fprintf(w, "\n--- %s\n", c.Path)
+		for _, l := range textdiff.Lines(string(before), string(after)) {
+			if l.Kind == '~' {
+				fmt.Fprintln(w, "  ...")
+				continue
+			}
+			fmt.Fprintf(w, "%c %s\n", l.Kind, l.Text)
+		}
+	}
+}
+
+// isText reports whether data looks like text worth diffing
+func isText(data []byte) bool {
+	return len(data) <= maxDiffSize && utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
+
+// apply makes changes to the folder: added and changed files are copied
+// from the sandbox and removed ones deleted, each recorded in the journal
+func (b *sandbox) apply(changes []fileChange) error {
+	for _, c := range changes {
+		target := filepath.Join(b.dir, c.Path)
+		if c.Op == "removed" {
+			if err := journal.Remove(target); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := journal.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		tmp := filepath.Join(filepath.Dir(target), ".goodness-sandbox-"+filepath.Base(target))
+		os.Remove(tmp)
+		if err := fsutil.CopyFile(filepath.Join(b.root, c.Path), tmp); err != nil {
+			return err
+		}
+		if err := journal.Replace(tmp, target); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	return nil
+}
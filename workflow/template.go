@@ -0,0 +1,184 @@
+package workflow
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"GoodnessucWorkflow/internal/cli"
+	"GoodnessucWorkflow/internal/exitcode"
+	"GoodnessucWorkflow/internal/fsutil"
+	"GoodnessucWorkflow/internal/httpclient"
+	"GoodnessucWorkflow/internal/i18n"
+	"GoodnessucWorkflow/internal/journal"
+	"GoodnessucWorkflow/internal/logging"
+	"GoodnessucWorkflow/internal/output"
+	"GoodnessucWorkflow/pkg/errs"
+)
+
+// builtin holds the templates goodness workflow init knows by name. The
+// first line of each is a comment describing it
+//
+//go:embed templates/*.yaml
+var builtin embed.FS
+
+// maxTemplateSize bounds a template fetched from a URL
+const maxTemplateSize = 1 << 20
+
+// Commands are the workflow subcommands
+func Commands() []cli.Command {
+	return []cli.Command{
+		{Name: "init", Summary: "write a workflow file from a built-in template, a file or a URL", Run: runInit, Complete: Templates},
+	}
+}
+
+// Command is the workflow command; Run is the run command
+func Command(args []string) {
+	cli.Dispatch("goodness workflow", Commands(), args)
+}
+
+// Templates lists the names of the built-in templates
+func Templates() []string {
+	entries, _ := builtin.ReadDir("templates")
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = strings.TrimSuffix(e.Name(), ".yaml")
+	}
+	return names
+}
+
+// templatesHelp lists the built-in templates with their descriptions for
+// the usage message
+func templatesHelp() string {
+	var b strings.Builder
+	for _, name := range Templates() {
+		data, _ := builtin.ReadFile("templates/" + name + ".yaml")
+		first, _ := bufio.NewReader(bytes.NewReader(data)).ReadString('\n')
+		fmt.Fprintf(&b, "  %-22s%s\n", name, strings.TrimSpace(strings.TrimPrefix(first, "#")))
+	}
+	return b.String()
+}
+
+func runInit(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	logging.AddFlags(fs)
+	out := fs.String("o", "", "workflow file to write (default TEMPLATE.yaml in the working directory)")
+	force := fs.Bool("force", false, "replace the file if it exists")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), i18n.Sprintf("Usage: goodness workflow init [flags] TEMPLATE|FILE|URL\n\nWrites a workflow file to start from, ready for goodness run. TEMPLATE is\none of the built-in templates below; a FILE or an http(s) URL imports a\nworkflow someone shared, which is checked before it is written. Edit the\nvars at the top, or override them with goodness run -var.\n\nTemplates:\n%s\n", templatesHelp()))
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s", i18n.T(exitcode.Help))
+	}
+	cli.Parse(fs, args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		output.Exit(exitcode.Usage)
+	}
+	source := fs.Arg(0)
+	data, name, shared, err := fetchTemplate(source)
+	if errors.Is(err, errNoTemplate) {
+		logging.Exitf(exitcode.Usage, "Unknown template %q; expected %s, a file or a URL", source, strings.Join(Templates(), ", "))
+	}
+	if err != nil {
+		logging.Fatalf("Failed to read %s: %s", source, err)
+	}
+	target := *out
+	if target == "" {
+		target = name + ".yaml"
+	}
+
+	w, err := Parse(target, data)
+	if err != nil {
+		logging.Exitf(exitcode.Usage, "Invalid workflow: %s", err)
+	}
+	if _, err := os.Stat(target); err == nil && !*force {
+		logging.Exitf(exitcode.Usage, "Failed to write %s: %s; pass -force to replace it", target, errs.ErrWouldOverwrite)
+	}
+	if err := journal.WriteFile(target, data, 0644); err != nil {
+		logging.Fatalf("Failed to write %s: %s", target, err)
+	}
+	output.Wrote(target)
+
+	if shared {
+		var programs []string
+		for _, s := range w.Steps {
+			if len(s.Exec) > 0 && !slices.Contains(programs, s.Exec[0]) {
+				programs = append(programs, s.Exec[0])
+			}
+		}
+		if len(programs) > 0 {
+			logging.Warnf("%s runs other programs (%s); read it before running it", target, strings.Join(programs, ", "))
+		}
+	}
+	vars := sortedKeys(w.Vars)
+	output.Set("workflow", map[string]any{"name": w.Name, "steps": len(w.Steps), "vars": vars})
+	logging.Infof("Wrote %s; run it with goodness run %s", target, target)
+	if len(vars) > 0 {
+		logging.Infof("Variables, set in the file or with -var NAME=VALUE: %s", strings.Join(vars, ", "))
+	}
+	output.Exit(exitcode.OK)
+}
+
+// errNoTemplate is a source that is neither a URL, a built-in template nor
+// a file
+var errNoTemplate = errors.New("no such template")
+
+// fetchTemplate returns the workflow source names, the name to save it
+// under and whether it came from outside goodness: a URL or a local file. A
+// built-in name wins over a file of the same name
+func fetchTemplate(source string) (data []byte, name string, shared bool, err error) {
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		data, err := download(u.String())
+		return data, baseName(path.Base(u.Path)), true, err
+	}
+	if slices.Contains(Templates(), source) {
+		data, err := builtin.ReadFile("templates/" + source + ".yaml")
+		return data, source, false, err
+	}
+	data, err = os.ReadFile(source)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", false, errNoTemplate
+	}
+	return data, baseName(filepath.Base(source)), true, err
+}
+
+// baseName is the name of a template file without its extension, or
+// workflow when there is nothing left
+func baseName(file string) string {
+	name := strings.TrimSuffix(file, filepath.Ext(file))
+	if name == "" || name == "." || name == "/" {
+		return "workflow"
+	}
+	return fsutil.SafeName(name)
+}
+
+func download(rawURL string) ([]byte, error) {
+	resp, err := httpclient.New(30 * time.Second).Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, &errs.RemoteError{Service: resp.Request.URL.Host, Status: resp.Status, StatusCode: resp.StatusCode}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxTemplateSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxTemplateSize {
+		return nil, fmt.Errorf("larger than %d bytes", maxTemplateSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,408 @@
+// Package workflow runs declarative pipelines: a YAML file lists steps, each
+// a goodness command or an external program, that run in order with shared
+// variables and environment. Steps that declare what they need run as soon
+// as it is done, alongside other branches
+package workflow
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"GoodnessucWorkflow/internal/secrets"
+)
+
+// Workflow is a parsed workflow file:
+//
+//	name: publish
+//	vars:
+//	  posts: ./posts
+//	steps:
+//	  - name: images
+//	    run: img convert
+//	    with: {auto: true, markdown: "${posts}"}
+//	    args: [./assets]
+//	  - run: md bold
+//	    with: {o: "${posts}/medium.md"}
+//	    args: ["${posts}/post.md"]
+//	  - plugin: smartypants
+//	    with: {style: medium}
+//	    args: ["${posts}/medium.md"]
+//	  - name: html
+//	    exec: [pandoc, "${posts}/post.md", -o, "${posts}/post.html"]
+//	    needs: []
+//	    timeout: 2m
+//	    retries: 2
+//	notify:
+//	  - slack: "${SLACK_WEBHOOK}"
+//	    on: failure
+type Workflow struct {
+	Name   string            `yaml:"name"`
+	Vars   map[string]string `yaml:"vars"`
+	Env    map[string]string `yaml:"env"`
+	Steps  []Step            `yaml:"steps"`
+	Notify []Notify          `yaml:"notify"`
+
+	// Dir is the directory of the workflow file; steps run there and
+	// relative paths in it resolve against it
+	Dir string `yaml:"-"`
+}
+
+// Step is one unit of a workflow. Exactly one of Run, a goodness command
+// such as "img convert", Plugin, a plugin run over the args with With as
+// its options, and Exec, an external program with its arguments, is set
+type Step struct {
+	Name            string            `yaml:"name"`
+	Run             string            `yaml:"run"`
+	Plugin          string            `yaml:"plugin"`
+	Exec            []string          `yaml:"exec"`
+	With            map[string]any    `yaml:"with"`
+	Args            []string          `yaml:"args"`
+	Env             map[string]string `yaml:"env"`
+	Dir             string            `yaml:"dir"`
+	ContinueOnError bool              `yaml:"continue-on-error"`
+
+	// Needs names the steps that must finish first. Without it a step
+	// follows the one before it; needs: [] starts it straight away
+	Needs []string `yaml:"needs"`
+	// Timeout stops an attempt at the step after this long
+	Timeout time.Duration `yaml:"timeout"`
+	// Retries runs a failed step again up to this many times, waiting
+	// RetryDelay before the first retry and twice as long each time after
+	Retries    int           `yaml:"retries"`
+	RetryDelay time.Duration `yaml:"retry-delay"`
+}
+
+// defaultRetryDelay is the wait before the first retry of a step
+const defaultRetryDelay = time.Second
+
+// Load reads a workflow file and checks every step
+func Load(path string) (*Workflow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(path, data)
+}
+
+// Parse checks every step of the workflow in data, as if read from path
+func Parse(path string, data []byte) (*Workflow, error) {
+	var err error
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	w := &Workflow{}
+	if err := dec.Decode(w); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if len(w.Steps) == 0 {
+		return nil, fmt.Errorf("%s: no steps", path)
+	}
+
+	if w.Dir, err = filepath.Abs(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if w.Name == "" {
+		w.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	seen := make(map[string]bool)
+	for i := range w.Steps {
+		s := &w.Steps[i]
+		if s.Name == "" {
+			s.Name = fmt.Sprintf("step-%d", i+1)
+		}
+		if seen[s.Name] {
+			return nil, fmt.Errorf("%s: step %d: duplicate name %q", path, i+1, s.Name)
+		}
+		seen[s.Name] = true
+		if err := s.check(); err != nil {
+			return nil, fmt.Errorf("%s: step %s: %s", path, s.Name, err)
+		}
+		if s.RetryDelay == 0 {
+			s.RetryDelay = defaultRetryDelay
+		}
+	}
+	for _, s := range w.Steps {
+		for _, need := range s.Needs {
+			if !seen[need] {
+				return nil, fmt.Errorf("%s: step %s: needs unknown step %q", path, s.Name, need)
+			}
+		}
+	}
+	if _, err := order(w.Steps); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for i := range w.Notify {
+		if err := w.Notify[i].check(); err != nil {
+			return nil, fmt.Errorf("%s: notify %d: %s", path, i+1, err)
+		}
+	}
+	return w, nil
+}
+
+func (s *Step) check() error {
+	kinds := 0
+	for _, set := range []bool{s.Run != "", s.Plugin != "", len(s.Exec) > 0} {
+		if set {
+			kinds++
+		}
+	}
+	switch {
+	case kinds == 0:
+		return errors.New("needs run, plugin or exec")
+	case kinds > 1:
+		return errors.New("sets more than one of run, plugin and exec")
+	case len(s.Exec) > 0 && len(s.With) > 0:
+		return errors.New("with only applies to run steps; put the flags in exec")
+	}
+	for name, value := range s.With {
+		if _, nested := value.(map[string]any); nested {
+			return fmt.Errorf("with: %s: expected a value or a list", name)
+		}
+	}
+	switch {
+	case slices.Contains(s.Needs, s.Name):
+		return errors.New("needs itself")
+	case s.Timeout < 0:
+		return errors.New("timeout cannot be negative")
+	case s.Retries < 0:
+		return errors.New("retries cannot be negative")
+	case s.RetryDelay < 0:
+		return errors.New("retry-delay cannot be negative")
+	}
+	return nil
+}
+
+// dependencies returns the names of the steps each step waits for: its
+// needs, or the step before it when it has none. Needs outside steps are
+// dropped, so a subset chosen with -only runs on its own
+func dependencies(steps []Step) map[string][]string {
+	included := make(map[string]bool, len(steps))
+	for _, s := range steps {
+		included[s.Name] = true
+	}
+	deps := make(map[string][]string, len(steps))
+	for i, s := range steps {
+		switch {
+		case s.Needs != nil:
+			for _, need := range s.Needs {
+				if included[need] {
+					deps[s.Name] = append(deps[s.Name], need)
+				}
+			}
+		case i > 0:
+			deps[s.Name] = []string{steps[i-1].Name}
+		}
+	}
+	return deps
+}
+
+// order returns steps with each after the steps it waits for, keeping the
+// file's order where it can, or an error when steps wait for each other
+func order(steps []Step) ([]Step, error) {
+	deps := dependencies(steps)
+	placed := make(map[string]bool, len(steps))
+	ordered := make([]Step, 0, len(steps))
+	for len(ordered) < len(steps) {
+		progress := false
+		for _, s := range steps {
+			if placed[s.Name] || !allIn(deps[s.Name], placed) {
+				continue
+			}
+			placed[s.Name] = true
+			ordered = append(ordered, s)
+			progress = true
+		}
+		if !progress {
+			var waiting []string
+			for _, s := range steps {
+				if !placed[s.Name] {
+					waiting = append(waiting, s.Name)
+				}
+			}
+			return nil, fmt.Errorf("steps %s need each other", strings.Join(waiting, ", "))
+		}
+	}
+	return ordered, nil
+}
+
+func allIn(names []string, set map[string]bool) bool {
+	for _, name := range names {
+		if !set[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// concurrent reports whether any of steps may run alongside another
+func concurrent(steps []Step) bool {
+	return slices.ContainsFunc(steps, func(s Step) bool { return s.Needs != nil })
+}
+
+// Context is the state shared by the steps of one run: the workflow's
+// variables, overridden by any given on the command line, and its directory
+type Context struct {
+	Workflow *Workflow
+	Vars     map[string]string
+
+	// Output, when set, receives the output of every step with each line
+	// prefixed by the step's name, rather than the terminal
+	Output io.Writer
+	// Progress, when set, is called as each step starts running and again
+	// once it settles
+	Progress func(Result)
+}
+
+// NewContext returns the context for running w with overrides taking
+// precedence over the variables in the file
+func NewContext(w *Workflow, overrides map[string]string) *Context {
+	vars := map[string]string{
+		"workflow.name": w.Name,
+		"workflow.dir":  w.Dir,
+	}
+	for name, value := range w.Vars {
+		vars[name] = value
+	}
+	for name, value := range overrides {
+		vars[name] = value
+	}
+	return &Context{Workflow: w, Vars: vars}
+}
+
+// varPattern matches a ${name} reference
+var varPattern = regexp.MustCompile(`\$\{([A-Za-z0-9_.-]+)\}`)
+
+// Expand replaces ${name} in s with a workflow variable, or with the
+// environment variable or the secret saved with goodness auth login of that
+// name when the workflow has none. Variables
+// may refer to other variables. Unknown names and bare $name are left
+// alone, so shell commands in exec steps keep their own variables
+func (c *Context) Expand(s string) string {
+	for depth := 0; depth < 8 && strings.Contains(s, "${"); depth++ {
+		expanded := varPattern.ReplaceAllStringFunc(s, func(ref string) string {
+			name := ref[2 : len(ref)-1]
+			if value, ok := c.Vars[name]; ok {
+				return value
+			}
+			if value, ok := os.LookupEnv(name); ok {
+				return value
+			}
+			if secrets.ValidKey(name) {
+				if value, ok, _ := secrets.Lookup(name); ok {
+					return value
+				}
+			}
+			return ref
+		})
+		if expanded == s {
+			break
+		}
+		s = expanded
+	}
+	return s
+}
+
+// Command returns the program, arguments, directory and extra environment
+// of step s with every variable expanded. Run steps invoke self, the
+// goodness executable, with the step's with map turned into flags; plugin
+// steps invoke goodness plugin run with it turned into -set options
+func (c *Context) Command(self string, s Step) (argv []string, dir string, env []string) {
+	switch {
+	case s.Run != "":
+		argv = append([]string{self}, strings.Fields(s.Run)...)
+		argv = append(argv, c.flags(s.With)...)
+	case s.Plugin != "":
+		argv = []string{self, "plugin", "run"}
+		for _, name := range sortedKeys(s.With) {
+			argv = append(argv, "-set", name+"="+c.Expand(fmt.Sprint(s.With[name])))
+		}
+		argv = append(argv, c.Expand(s.Plugin))
+	default:
+		for _, arg := range s.Exec {
+			argv = append(argv, c.Expand(arg))
+		}
+	}
+	for _, arg := range s.Args {
+		argv = append(argv, c.Expand(arg))
+	}
+
+	dir = c.Workflow.Dir
+	if s.Dir != "" {
+		dir = c.Expand(s.Dir)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(c.Workflow.Dir, dir)
+		}
+	}
+
+	env = []string{
+		"GOODNESS_WORKFLOW=" + c.Workflow.Name,
+		"GOODNESS_WORKFLOW_DIR=" + c.Workflow.Dir,
+		"GOODNESS_STEP=" + s.Name,
+	}
+	for _, vars := range []map[string]string{c.Workflow.Env, s.Env} {
+		for _, name := range sortedKeys(vars) {
+			env = append(env, name+"="+c.Expand(vars[name]))
+		}
+	}
+	return argv, dir, env
+}
+
+// flags turns a with map into command-line flags in name order: true is
+// -name, false is -name=false and a list repeats the flag per item
+func (c *Context) flags(with map[string]any) []string {
+	var flags []string
+	for _, name := range sortedKeys(with) {
+		values := []any{with[name]}
+		if list, ok := with[name].([]any); ok {
+			values = list
+		}
+		for _, value := range values {
+			if value == true {
+				flags = append(flags, "-"+name)
+				continue
+			}
+			flags = append(flags, "-"+name+"="+c.Expand(fmt.Sprint(value)))
+		}
+	}
+	return flags
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Select returns the steps of w named in only, in workflow order, or every
+// step when only is empty
+func (w *Workflow) Select(only []string) ([]Step, error) {
+	if len(only) == 0 {
+		return w.Steps, nil
+	}
+	var steps []Step
+	for _, name := range only {
+		if !slices.ContainsFunc(w.Steps, func(s Step) bool { return s.Name == name }) {
+			return nil, fmt.Errorf("no step named %q", name)
+		}
+	}
+	for _, s := range w.Steps {
+		if slices.Contains(only, s.Name) {
+			steps = append(steps, s)
+		}
+	}
+	return steps, nil
+}